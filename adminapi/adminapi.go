@@ -0,0 +1,1279 @@
+// Package adminapi provides the admin-only JSON RPC service API, served on a local Unix domain
+// socket so that local tooling can manage the server without exposing admin endpoints publicly.
+// Access is controlled by the socket file permissions rather than by network auth.  It can also be
+// served over HTTP on a separate listener, where access is controlled by a bearer token.  The
+// destructive actions (deleting users/channels) are only served here, the public JSON RPC API
+// doesn't have them.
+package adminapi
+
+import (
+	"chatserver/archive"
+	"chatserver/attachments"
+	"chatserver/clienterrors"
+	"chatserver/credentials"
+	"chatserver/diskguard"
+	"chatserver/drafts"
+	"chatserver/export"
+	"chatserver/model"
+	"chatserver/model/subs"
+	"chatserver/pins"
+	"chatserver/preferences"
+	"chatserver/reactions"
+	"chatserver/security"
+	"chatserver/snapshots"
+	"chatserver/storage"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// Serve accepts connections on the listener and serves the given RPC server on each of them
+// (one JSON RPC codec per connection).  It only returns when the listener fails.
+func Serve(listener net.Listener, server *rpc.Server) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		log.Println("admin connection opened")
+		go func() {
+			server.ServeCodec(jsonrpc.NewServerCodec(conn))
+			log.Println("admin connection closed")
+		}()
+	}
+}
+
+// Handler returns an HTTP handler serving the given RPC server to clients that present the token
+// (as "Authorization: Bearer <token>"), one JSON RPC request per POST.
+func Handler(server *rpc.Server, token string) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		authorization := []byte(request.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(authorization, []byte("Bearer "+token)) != 1 {
+			http.Error(writer, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		err := server.ServeRequest(jsonrpc.NewServerCodec(httpConn{Reader: request.Body, Writer: writer}))
+		if err != nil {
+			log.Println("admin request:", err)
+		}
+	})
+}
+
+// httpConn adapts an HTTP request body and response to the connection a JSON RPC codec expects.
+type httpConn struct {
+	io.Reader
+	io.Writer
+}
+
+// Close has no effect, the HTTP server closes the request.
+func (c httpConn) Close() error {
+	return nil
+}
+
+// ConnectionCounter provides an interface for connection handlers to report how many
+// connections they have open.
+type ConnectionCounter interface {
+	NumConnections() int
+}
+
+// RequestLogger provides an interface for connection handlers whose requests can be logged.
+type RequestLogger interface {
+	SetRequestLogging(enabled bool)
+	RequestLogging() bool
+}
+
+// AdminAPI provides the admin JSON RPC service API.
+type AdminAPI struct {
+	model          *model.Model
+	subsEngine     *subs.Engine
+	credentials    *credentials.Credentials
+	archiveConfig  archive.Config
+	connections    map[string]ConnectionCounter
+	requestLoggers map[string]RequestLogger
+	clientErrors   *clienterrors.Buffer
+	diskGuard      *diskguard.Guard
+	store          storage.Store
+	attachments    *attachments.Store
+}
+
+// NewInstance creates/initializes/returns a new AdminAPI instance.  The credentials are nil when
+// passwords are disabled, the archive config is the config subset recorded in exported archives,
+// the connection counters (keyed by listener name) are reported by GetConnectionStats, and the
+// request loggers (keyed by API name) are switched by SetRequestLogging, the errors reported by
+// clients are kept in the client errors buffer, the disk guard (nil when there's no log file) is
+// reported by GetDiskStatus and compacts the log for CompactLog, and the object store (nil when
+// there's none) holds the exports, archives and snapshots requested with a Key, and the attachment
+// store (nil when attachments are disabled) is garbage collected by GCAttachments.
+func NewInstance(model *model.Model, subsEngine *subs.Engine, credentials *credentials.Credentials, archiveConfig archive.Config, connections map[string]ConnectionCounter, requestLoggers map[string]RequestLogger, clientErrors *clienterrors.Buffer, diskGuard *diskguard.Guard, store storage.Store, attachmentStore *attachments.Store) *AdminAPI {
+	instance := AdminAPI{
+		model:          model,
+		subsEngine:     subsEngine,
+		credentials:    credentials,
+		archiveConfig:  archiveConfig,
+		connections:    connections,
+		requestLoggers: requestLoggers,
+		clientErrors:   clientErrors,
+		diskGuard:      diskGuard,
+		store:          store,
+		attachments:    attachmentStore,
+	}
+
+	return &instance
+}
+
+// CreateUserArgs provides the input arguments for the CreateUser action.
+type CreateUserArgs struct {
+	Username string
+}
+
+// CreateUserResponse provides the output arguments for the CreateUser action.
+type CreateUserResponse struct {
+}
+
+// CreateUser will create a new user.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.CreateUser",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) CreateUser(args *CreateUserArgs, response *CreateUserResponse) error {
+	return a.model.CreateUser(args.Username)
+}
+
+// DeleteUserArgs provides the input arguments for the DeleteUser action.
+type DeleteUserArgs struct {
+	Username string
+}
+
+// DeleteUserResponse provides the output arguments for the DeleteUser action.
+type DeleteUserResponse struct {
+}
+
+// DeleteUser will delete an existing user (and their password).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.DeleteUser",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) DeleteUser(args *DeleteUserArgs, response *DeleteUserResponse) error {
+	err := a.model.DeleteUser(args.Username)
+	if err != nil {
+		return err
+	}
+
+	// Only remove the password once the user is really gone (protected users can't be deleted)
+	if a.credentials != nil {
+		return a.credentials.DeletePassword(args.Username)
+	}
+
+	return nil
+}
+
+// RenameUserArgs provides the input arguments for the RenameUser action.
+type RenameUserArgs struct {
+	Username    string
+	NewUsername string
+}
+
+// RenameUserResponse provides the output arguments for the RenameUser action.
+type RenameUserResponse struct {
+}
+
+// RenameUser will rename an existing user, keeping their messages, memberships, conversations,
+// password, preferences and drafts under the new name.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.RenameUser",
+//     "params": [{
+//         "Username": "User1",
+//         "NewUsername": "User2"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) RenameUser(args *RenameUserArgs, response *RenameUserResponse) error {
+	if a.credentials != nil && a.credentials.HasPassword(args.NewUsername) {
+		return credentials.ErrRegistered
+	}
+
+	err := a.model.RenameUser(args.Username, args.NewUsername)
+	if err != nil {
+		return err
+	}
+
+	preferences.Rename(a.model, args.Username, args.NewUsername)
+	drafts.Rename(a.model, args.Username, args.NewUsername)
+	security.Rename(a.model, args.Username, args.NewUsername)
+	reactions.Rename(a.model, args.Username, args.NewUsername)
+	if a.credentials != nil {
+		return a.credentials.RenamePassword(args.Username, args.NewUsername)
+	}
+
+	return nil
+}
+
+// SetPasswordArgs provides the input arguments for the SetPassword action.
+type SetPasswordArgs struct {
+	Username string
+	Password string
+}
+
+// SetPasswordResponse provides the output arguments for the SetPassword action.
+type SetPasswordResponse struct {
+}
+
+// SetPassword will set (or reset) the password of an existing user, making it a registered
+// account.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SetPassword",
+//     "params": [{
+//         "Username": "User1",
+//         "Password": "correct horse battery staple"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) SetPassword(args *SetPasswordArgs, response *SetPasswordResponse) error {
+	if a.credentials == nil {
+		return errors.New("passwords are disabled")
+	}
+
+	if _, ok := a.model.GetUsers()[args.Username]; !ok {
+		return errors.New("unknown user")
+	}
+
+	return a.credentials.SetPassword(args.Username, args.Password)
+}
+
+// DeletePasswordArgs provides the input arguments for the DeletePassword action.
+type DeletePasswordArgs struct {
+	Username string
+}
+
+// DeletePasswordResponse provides the output arguments for the DeletePassword action.
+type DeletePasswordResponse struct {
+}
+
+// DeletePassword will delete the password of a user, making it an unregistered account again.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.DeletePassword",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) DeletePassword(args *DeletePasswordArgs, response *DeletePasswordResponse) error {
+	if a.credentials == nil {
+		return errors.New("passwords are disabled")
+	}
+
+	return a.credentials.DeletePassword(args.Username)
+}
+
+// UnlockLoginArgs provides the input arguments for the UnlockLogin action.
+type UnlockLoginArgs struct {
+	Username string
+	Address  string
+}
+
+// UnlockLoginResponse provides the output arguments for the UnlockLogin action.
+type UnlockLoginResponse struct {
+	Unlocked bool
+}
+
+// UnlockLogin will forget the failed logins of an account and of a client address (either may be empty), so a
+// user that was locked out (or is waiting after failed logins) can log in again straight away.  Unlocked is false
+// if neither of them had any.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.UnlockLogin",
+//     "params": [{
+//         "Username": "User1",
+//         "Address": "192.0.2.1"
+//     }]
+// }
+//
+// Output
+// {
+//     "Unlocked": true
+// }
+func (a *AdminAPI) UnlockLogin(args *UnlockLoginArgs, response *UnlockLoginResponse) error {
+	if a.credentials == nil {
+		return errors.New("passwords are disabled")
+	}
+
+	response.Unlocked = a.credentials.Unlock(args.Username, args.Address)
+	return nil
+}
+
+// DisableTOTPArgs provides the input arguments for the DisableTOTP action.
+type DisableTOTPArgs struct {
+	Username string
+}
+
+// DisableTOTPResponse provides the output arguments for the DisableTOTP action.
+type DisableTOTPResponse struct {
+}
+
+// DisableTOTP will disable the two-factor authentication of a user (e.g. when they lost their authenticator and
+// recovery codes), so they can log in with just their password again.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.DisableTOTP",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) DisableTOTP(args *DisableTOTPArgs, response *DisableTOTPResponse) error {
+	if a.credentials == nil {
+		return errors.New("passwords are disabled")
+	}
+
+	return a.credentials.DisableTOTP(args.Username)
+}
+
+// CreateChannelArgs provides the input arguments for the CreateChannel action.
+type CreateChannelArgs struct {
+	Channelname string
+}
+
+// CreateChannelResponse provides the output arguments for the CreateChannel action.
+type CreateChannelResponse struct {
+}
+
+// CreateChannel will create a new channel.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.CreateChannel",
+//     "params": [{
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) CreateChannel(args *CreateChannelArgs, response *CreateChannelResponse) error {
+	return a.model.CreateChannel(args.Channelname)
+}
+
+// DeleteChannelArgs provides the input arguments for the DeleteChannel action.
+type DeleteChannelArgs struct {
+	Channelname string
+}
+
+// DeleteChannelResponse provides the output arguments for the DeleteChannel action.
+type DeleteChannelResponse struct {
+}
+
+// DeleteChannel will delete an existing channel.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.DeleteChannel",
+//     "params": [{
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) DeleteChannel(args *DeleteChannelArgs, response *DeleteChannelResponse) error {
+	return a.model.DeleteChannel(args.Channelname)
+}
+
+// DeleteMessageArgs provides the input arguments for the DeleteMessage action.
+type DeleteMessageArgs struct {
+	Channelname string
+	MessageID   uint64
+}
+
+// DeleteMessageResponse provides the output arguments for the DeleteMessage action.
+type DeleteMessageResponse struct {
+}
+
+// DeleteMessage will delete any message in a channel (by its ID), leaving a tombstone in its place.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.DeleteMessage",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "MessageID": 42
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) DeleteMessage(args *DeleteMessageArgs, response *DeleteMessageResponse) error {
+	return a.model.ModerateMessage(args.Channelname, args.MessageID)
+}
+
+// RestoreChannelArgs provides the input arguments for the RestoreChannel action.
+type RestoreChannelArgs struct {
+	Channelname string
+}
+
+// RestoreChannelResponse provides the output arguments for the RestoreChannel action.
+type RestoreChannelResponse struct {
+}
+
+// RestoreChannel will restore a recently deleted channel (with its history, members and mutes),
+// failing if the undo window has passed or the name has been reused.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.RestoreChannel",
+//     "params": [{
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) RestoreChannel(args *RestoreChannelArgs, response *RestoreChannelResponse) error {
+	return a.model.RestoreChannel(args.Channelname)
+}
+
+// RenameChannelArgs provides the input arguments for the RenameChannel action.
+type RenameChannelArgs struct {
+	Channelname    string
+	NewChannelname string
+}
+
+// RenameChannelResponse provides the output arguments for the RenameChannel action.
+type RenameChannelResponse struct {
+}
+
+// RenameChannel will rename an existing channel, keeping its history, members, settings and the
+// drafts for it under the new name.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.RenameChannel",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "NewChannelname": "Channel2"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) RenameChannel(args *RenameChannelArgs, response *RenameChannelResponse) error {
+	err := a.model.RenameChannel(args.Channelname, args.NewChannelname)
+	if err != nil {
+		return err
+	}
+
+	drafts.RenameChannel(a.model, args.Channelname, args.NewChannelname)
+	reactions.RenameChannel(a.model, args.Channelname, args.NewChannelname)
+	pins.RenameChannel(a.model, args.Channelname, args.NewChannelname)
+	return nil
+}
+
+// PinMessageArgs provides the input arguments for the PinMessage action.
+type PinMessageArgs struct {
+	Channelname string
+	MessageID   uint64
+	Pinned      bool
+}
+
+// PinMessageResponse provides the output arguments for the PinMessage action.
+type PinMessageResponse struct {
+}
+
+// PinMessage will pin a message in its channel (or, with Pinned false, unpin it, e.g. one the automation bot
+// pinned).  Pinning a message already pinned (or unpinning one that isn't) does nothing.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.PinMessage",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "MessageID": 42,
+//         "Pinned": false
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) PinMessage(args *PinMessageArgs, response *PinMessageResponse) error {
+	return pins.Pin(a.model, a.subsEngine, args.Channelname, args.MessageID, args.Pinned)
+}
+
+// DeletedChannel provides the details of a deleted channel that can still be restored.
+type DeletedChannel struct {
+	Name    string
+	Deleted time.Time
+}
+
+// GetDeletedChannelsArgs provides the input arguments for the GetDeletedChannels action.
+type GetDeletedChannelsArgs struct {
+}
+
+// GetDeletedChannelsResponse provides the output arguments for the GetDeletedChannels action.
+type GetDeletedChannelsResponse struct {
+	Channels []DeletedChannel
+}
+
+// GetDeletedChannels will get the deleted channels (sorted by name) that can still be restored.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetDeletedChannels",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Channels": [{
+//         "Name": "Channel1",
+//         "Deleted": "2020-01-01T00:00:00Z"
+//     }]
+// }
+func (a *AdminAPI) GetDeletedChannels(args *GetDeletedChannelsArgs, response *GetDeletedChannelsResponse) error {
+	response.Channels = make([]DeletedChannel, 0)
+	for channelname, deleted := range a.model.GetDeletedChannels() {
+		response.Channels = append(response.Channels, DeletedChannel{Name: channelname, Deleted: deleted})
+	}
+	sort.Slice(response.Channels, func(i, j int) bool { return response.Channels[i].Name < response.Channels[j].Name })
+
+	return nil
+}
+
+// SetChannelTopicArgs provides the input arguments for the SetChannelTopic action.
+type SetChannelTopicArgs struct {
+	Channelname string
+	Topic       string
+}
+
+// SetChannelTopicResponse provides the output arguments for the SetChannelTopic action.
+type SetChannelTopicResponse struct {
+}
+
+// SetChannelTopic will set the topic of an existing channel.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SetChannelTopic",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Topic": "Topic1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) SetChannelTopic(args *SetChannelTopicArgs, response *SetChannelTopicResponse) error {
+	return a.model.SetChannelTopic(args.Channelname, args.Topic)
+}
+
+// ProtectUserArgs provides the input arguments for the ProtectUser action.
+type ProtectUserArgs struct {
+	Username string
+}
+
+// ProtectUserResponse provides the output arguments for the ProtectUser action.
+type ProtectUserResponse struct {
+}
+
+// ProtectUser will protect a user from deletion.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.ProtectUser",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) ProtectUser(args *ProtectUserArgs, response *ProtectUserResponse) error {
+	a.model.Policy().ProtectUser(args.Username)
+
+	return nil
+}
+
+// UnprotectUserArgs provides the input arguments for the UnprotectUser action.
+type UnprotectUserArgs struct {
+	Username string
+}
+
+// UnprotectUserResponse provides the output arguments for the UnprotectUser action.
+type UnprotectUserResponse struct {
+}
+
+// UnprotectUser will allow a previously protected user to be deleted.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.UnprotectUser",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) UnprotectUser(args *UnprotectUserArgs, response *UnprotectUserResponse) error {
+	a.model.Policy().UnprotectUser(args.Username)
+
+	return nil
+}
+
+// ProtectChannelArgs provides the input arguments for the ProtectChannel action.
+type ProtectChannelArgs struct {
+	Channelname string
+}
+
+// ProtectChannelResponse provides the output arguments for the ProtectChannel action.
+type ProtectChannelResponse struct {
+}
+
+// ProtectChannel will protect a channel from deletion.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.ProtectChannel",
+//     "params": [{
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) ProtectChannel(args *ProtectChannelArgs, response *ProtectChannelResponse) error {
+	a.model.Policy().ProtectChannel(args.Channelname)
+
+	return nil
+}
+
+// UnprotectChannelArgs provides the input arguments for the UnprotectChannel action.
+type UnprotectChannelArgs struct {
+	Channelname string
+}
+
+// UnprotectChannelResponse provides the output arguments for the UnprotectChannel action.
+type UnprotectChannelResponse struct {
+}
+
+// UnprotectChannel will allow a previously protected channel to be deleted.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.UnprotectChannel",
+//     "params": [{
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) UnprotectChannel(args *UnprotectChannelArgs, response *UnprotectChannelResponse) error {
+	a.model.Policy().UnprotectChannel(args.Channelname)
+
+	return nil
+}
+
+// GetProtectedArgs provides the input arguments for the GetProtected action.
+type GetProtectedArgs struct {
+}
+
+// GetProtectedResponse provides the output arguments for the GetProtected action.
+type GetProtectedResponse struct {
+	Users    []string
+	Channels []string
+}
+
+// GetProtected will get the (sorted) names of all protected users and channels.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetProtected",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Users": ["Anonymous"],
+//     "Channels": ["General"]
+// }
+func (a *AdminAPI) GetProtected(args *GetProtectedArgs, response *GetProtectedResponse) error {
+	response.Users = make([]string, 0)
+	for username := range a.model.Policy().GetProtectedUsers() {
+		response.Users = append(response.Users, username)
+	}
+	sort.Strings(response.Users)
+
+	response.Channels = make([]string, 0)
+	for channelname := range a.model.Policy().GetProtectedChannels() {
+		response.Channels = append(response.Channels, channelname)
+	}
+	sort.Strings(response.Channels)
+
+	return nil
+}
+
+// ExportChannelArgs provides the input arguments for the ExportChannel action.
+type ExportChannelArgs struct {
+	Channelname string
+	Directory   string
+	Key         string
+}
+
+// ExportChannelResponse provides the output arguments for the ExportChannel action.
+type ExportChannelResponse struct {
+}
+
+// ExportChannel will render the full history of a channel into a static HTML bundle (with
+// pagination and a search index) in a directory on the server, or (with a Key rather than a
+// Directory) to the object storage, with the file names appended to the key.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.ExportChannel",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Directory": "/var/lib/chatserver/export/Channel1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) ExportChannel(args *ExportChannelArgs, response *ExportChannelResponse) error {
+	if args.Key == "" {
+		return export.WriteChannel(a.model, args.Channelname, args.Directory)
+	}
+
+	if a.store == nil {
+		return errors.New("no object storage")
+	}
+
+	return export.PutChannel(a.model, args.Channelname, a.store, args.Key)
+}
+
+// ExportArchiveArgs provides the input arguments for the ExportArchive action.
+type ExportArchiveArgs struct {
+	Path string
+	Key  string
+}
+
+// ExportArchiveResponse provides the output arguments for the ExportArchive action.
+type ExportArchiveResponse struct {
+}
+
+// ExportArchive will write an archive of the full server state to a file on the server, or (with a
+// Key rather than a Path) to an object in the object storage.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.ExportArchive",
+//     "params": [{
+//         "Path": "/var/lib/chatserver/archive.json.gz"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) ExportArchive(args *ExportArchiveArgs, response *ExportArchiveResponse) error {
+	stateArchive, err := archive.New(a.model, a.archiveConfig, a.attachments)
+	if err != nil {
+		return err
+	}
+
+	if args.Key == "" {
+		return stateArchive.WriteFile(args.Path)
+	}
+
+	if a.store == nil {
+		return errors.New("no object storage")
+	}
+
+	return stateArchive.Put(a.store, args.Key)
+}
+
+// ImportArchiveArgs provides the input arguments for the ImportArchive action.
+type ImportArchiveArgs struct {
+	Path string
+	Key  string
+}
+
+// ImportArchiveResponse provides the output arguments for the ImportArchive action.
+type ImportArchiveResponse struct {
+}
+
+// ImportArchive will import an archive file on the server (or, with a Key rather than a Path, an
+// archive object in the object storage) into the current state.  A current archive can only be
+// imported into a server without any message history (see archive.Archive.Import).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.ImportArchive",
+//     "params": [{
+//         "Path": "/var/lib/chatserver/archive.json.gz"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) ImportArchive(args *ImportArchiveArgs, response *ImportArchiveResponse) error {
+	var stateArchive *archive.Archive
+	var err error
+	if args.Key == "" {
+		stateArchive, err = archive.ParseFile(args.Path)
+	} else if a.store == nil {
+		err = errors.New("no object storage")
+	} else {
+		stateArchive, err = archive.Get(a.store, args.Key)
+	}
+	if err != nil {
+		return err
+	}
+
+	return stateArchive.Import(a.model, a.attachments)
+}
+
+// GetConnectionStatsArgs provides the input arguments for the GetConnectionStats action.
+type GetConnectionStatsArgs struct {
+}
+
+// GetConnectionStatsResponse provides the output arguments for the GetConnectionStats action.
+type GetConnectionStatsResponse struct {
+	Connections   map[string]int
+	Subscriptions int
+	Goroutines    int
+}
+
+// GetConnectionStats will get the number of open connections (per listener), subscribed clients,
+// and running goroutines.  Every connection has one subscribed client, and the goroutines
+// should return to the same level once the connections close (otherwise they're leaking).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetConnectionStats",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Connections": {
+//         "telnet": 2,
+//         "web": 1
+//     },
+//     "Subscriptions": 3,
+//     "Goroutines": 24
+// }
+func (a *AdminAPI) GetConnectionStats(args *GetConnectionStatsArgs, response *GetConnectionStatsResponse) error {
+	response.Connections = make(map[string]int)
+	for name, counter := range a.connections {
+		response.Connections[name] = counter.NumConnections()
+	}
+
+	response.Subscriptions = a.subsEngine.NumClients()
+	response.Goroutines = runtime.NumGoroutine()
+
+	return nil
+}
+
+// SetRequestLoggingArgs provides the input arguments for the SetRequestLogging action.
+type SetRequestLoggingArgs struct {
+	API     string
+	Enabled bool
+}
+
+// SetRequestLoggingResponse provides the output arguments for the SetRequestLogging action.
+type SetRequestLoggingResponse struct {
+}
+
+// SetRequestLogging will turn logging the requests to an API (e.g. "web") on or off.  Each request
+// is logged with its method, the client's address, how long it took and its params (truncated,
+// with passwords, tokens and session IDs redacted).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SetRequestLogging",
+//     "params": [{
+//         "API": "web",
+//         "Enabled": true
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) SetRequestLogging(args *SetRequestLoggingArgs, response *SetRequestLoggingResponse) error {
+	requestLogger, ok := a.requestLoggers[args.API]
+	if !ok {
+		return errors.New("unknown API")
+	}
+
+	requestLogger.SetRequestLogging(args.Enabled)
+	return nil
+}
+
+// GetClientErrorsArgs provides the input arguments for the GetClientErrors action.
+type GetClientErrorsArgs struct {
+}
+
+// GetClientErrorsResponse provides the output arguments for the GetClientErrors action.
+type GetClientErrorsResponse struct {
+	Reports []clienterrors.Report
+	Dropped int
+}
+
+// GetClientErrors will get the most recent errors reported by clients (oldest first), and the
+// number of older reports that have been dropped.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetClientErrors",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Reports": [{
+//         "Time": "2020-01-12T...",
+//         "Kind": "error",
+//         "Message": "TypeError: result.User is undefined",
+//         "Details": "updateCurrentUserInfo@http://localhost:8080/:250:21\n...",
+//         "UserAgent": "Mozilla/5.0 ..."
+//     }],
+//     "Dropped": 0
+// }
+func (a *AdminAPI) GetClientErrors(args *GetClientErrorsArgs, response *GetClientErrorsResponse) error {
+	response.Reports, response.Dropped = a.clientErrors.Reports()
+
+	return nil
+}
+
+// SetReadOnlyArgs provides the input arguments for the SetReadOnly action.
+type SetReadOnlyArgs struct {
+	ReadOnly bool
+}
+
+// SetReadOnlyResponse provides the output arguments for the SetReadOnly action.
+type SetReadOnlyResponse struct {
+}
+
+// SetReadOnly will switch the server to (or from) read-only, where every change is rejected with
+// "server is read-only" (e.g. to make it writable again after it failed to write to the log, once
+// the problem is fixed).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SetReadOnly",
+//     "params": [{
+//         "ReadOnly": false
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) SetReadOnly(args *SetReadOnlyArgs, response *SetReadOnlyResponse) error {
+	a.model.SetReadOnly(args.ReadOnly)
+
+	return nil
+}
+
+// GetDiskStatusArgs provides the input arguments for the GetDiskStatus action.
+type GetDiskStatusArgs struct {
+}
+
+// GetDiskStatusResponse provides the output arguments for the GetDiskStatus action.
+type GetDiskStatusResponse struct {
+	FreeBytes uint64
+	LogBytes  int64
+	ReadOnly  bool
+}
+
+// GetDiskStatus will get the free disk space and the size of the actions log (as of the latest
+// check), and whether the server is read-only.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetDiskStatus",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "FreeBytes": 10737418240,
+//     "LogBytes": 52428800,
+//     "ReadOnly": false
+// }
+func (a *AdminAPI) GetDiskStatus(args *GetDiskStatusArgs, response *GetDiskStatusResponse) error {
+	response.ReadOnly = a.model.IsReadOnly()
+	if a.diskGuard == nil {
+		return nil
+	}
+
+	status := a.diskGuard.Status()
+	response.FreeBytes = status.FreeBytes
+	response.LogBytes = status.LogBytes
+
+	return nil
+}
+
+// CompactLogArgs provides the input arguments for the CompactLog action.
+type CompactLogArgs struct {
+}
+
+// CompactLogResponse provides the output arguments for the CompactLog action.
+type CompactLogResponse struct {
+}
+
+// CompactLog will compact the actions log now, replacing it with a snapshot of the current state.
+// The old log is kept if the compacted one can't be written.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.CompactLog",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (a *AdminAPI) CompactLog(args *CompactLogArgs, response *CompactLogResponse) error {
+	if a.diskGuard == nil {
+		return errors.New("no log file")
+	}
+
+	return a.diskGuard.Compact()
+}
+
+// SaveSnapshotArgs provides the input arguments for the SaveSnapshot action.
+type SaveSnapshotArgs struct {
+}
+
+// SaveSnapshotResponse provides the output arguments for the SaveSnapshot action.
+type SaveSnapshotResponse struct {
+	Key string
+}
+
+// SaveSnapshot will save a snapshot of the current state to the object storage now (rather than
+// waiting for the snapshot interval), replacing the latest one, which the server starts from when
+// it has no actions log.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SaveSnapshot",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Key": "snapshots/latest.json"
+// }
+func (a *AdminAPI) SaveSnapshot(args *SaveSnapshotArgs, response *SaveSnapshotResponse) error {
+	if a.store == nil {
+		return errors.New("no object storage")
+	}
+
+	response.Key = snapshots.Key
+	return snapshots.Save(a.model, a.store)
+}
+
+// GCAttachmentsArgs provides the input arguments for the GCAttachments action.
+type GCAttachmentsArgs struct {
+}
+
+// GCAttachmentsResponse provides the output arguments for the GCAttachments action.
+type GCAttachmentsResponse struct {
+	Deleted    int
+	FreedBytes int64
+}
+
+// GCAttachments will delete the uploaded files that aren't attached to any message (including the
+// messages in deleted channels that can still be restored), other than those uploaded within the
+// last hour, which may be about to be attached.  A file is stored once however many messages it's
+// attached to, and is only deleted once none of them refer to it.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GCAttachments",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Deleted": 3,
+//     "FreedBytes": 1048576
+// }
+func (a *AdminAPI) GCAttachments(args *GCAttachmentsArgs, response *GCAttachmentsResponse) error {
+	if a.attachments == nil {
+		return errors.New("attachments are disabled")
+	}
+
+	deleted, freed, err := a.attachments.GC(a.model.GetAttachmentRefs(), attachments.GracePeriod)
+	response.Deleted = deleted
+	response.FreedBytes = freed
+
+	return err
+}
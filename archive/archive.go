@@ -0,0 +1,658 @@
+// Package archive provides a portable, versioned archive of the full server state (users, channels,
+// memberships, message history, direct messages, group conversations, calendars, teams and plugin data, plus the config subset that shaped it) so a
+// server can be migrated between hosts.  Archives are gzip compressed JSON.
+//
+// Version 2 adds the direct messages, group conversations and attachments (the messages' references
+// to the files, and the files themselves, copied from the attachments store), and keeps the messages' IDs (including those of deleted messages, which are kept without
+// their text, so the IDs after them don't shift), along with the edits, cross-posts, read markers,
+// stars and notes, and is imported as a whole into a server without any history.  Version 1
+// archives (without IDs) are still merged into the existing state.
+//
+// Left out deliberately: channels deleted but still restorable (they can't be restored after a
+// restart either), the messages each user has posted today (the quota starts over), the keys of
+// messages posted once (see model.PostMessageOnce), and sessions and connections.
+package archive
+
+import (
+	"bytes"
+	"chatserver/attachments"
+	"chatserver/model"
+	"chatserver/model/actions"
+	"chatserver/storage"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// Version is the archive format version written by this package.
+const Version int = 2
+
+// Errors returned when importing an archive.
+var (
+	ErrNoAttachments = errors.New("archive has attachments, but they aren't enabled")
+	ErrInvalidFile   = errors.New("archived file doesn't match its ID")
+)
+
+// Archive contains a snapshot of the server state.  LastMessageID, LastGroupID and LastEventID are
+// the last IDs assigned (version 2), so the IDs assigned after importing don't reuse them.  Files
+// contains the attached files, by ID (left out when attachments aren't enabled).
+type Archive struct {
+	Version       int
+	Created       time.Time
+	Config        Config
+	Users         []User
+	Channels      []Channel
+	Conversations []Conversation `json:",omitempty"`
+	Groups        []Group        `json:",omitempty"`
+	Teams         []Team
+	PluginData    map[string]map[string]string
+	LastMessageID uint64            `json:",omitempty"`
+	LastGroupID   uint64            `json:",omitempty"`
+	LastEventID   uint64            `json:",omitempty"`
+	Files         map[string][]byte `json:",omitempty"`
+}
+
+// Config contains the config subset that the state depends on.  It is informational, importing
+// doesn't change the running config (copy it into the new host's config file).
+type Config struct {
+	BuiltinUsername    string
+	BuiltinChannelname string
+	DefaultChannels    []string
+	ProtectedUsers     []string
+	ProtectedChannels  []string
+}
+
+// User contains the state of a single user.
+type User struct {
+	Name          string
+	Owner         string
+	BlockedUsers  []string
+	MutedChannels []string
+	Profile       model.Profile
+	Status        model.Status
+}
+
+// Channel contains the state of a single channel.  PostCounts counts the messages each user posted
+// on each day (by "2006-01-02" then username), ReadMarkers the ID of the last message each user has
+// read, and Stars the IDs of the messages each user has starred (version 2).
+type Channel struct {
+	Name         string
+	Topic        string
+	Language     string
+	Rules        string
+	Members      []string
+	Messages     []Message
+	Events       []Event `json:",omitempty"`
+	LastActivity time.Time
+	PostCounts   map[string]map[string]int `json:",omitempty"`
+	ReadMarkers  map[string]uint64         `json:",omitempty"`
+	Stars        map[string][]uint64       `json:",omitempty"`
+	Notes        *Notes                    `json:",omitempty"`
+}
+
+// Notes contains a channel's notes, along with their version and who last edited them.
+type Notes struct {
+	Text    string
+	Version uint64
+	Editor  string
+	Edited  time.Time
+}
+
+// Conversation contains the direct messages between two users.
+type Conversation struct {
+	Usernames []string
+	Messages  []Message
+}
+
+// Group contains a group conversation, its members and its messages.
+type Group struct {
+	ID       uint64
+	Members  []string
+	Messages []Message
+}
+
+// Team contains a team and its members.
+type Team struct {
+	Name    string
+	Members []string
+}
+
+// Event contains an event on a channel's calendar.
+type Event struct {
+	ID       uint64 `json:",omitempty"`
+	Creator  string
+	Start    time.Time
+	Title    string
+	Going    []string
+	Reminded bool
+}
+
+// Message contains a single message (a deleted one has no text).  CrossPost is the ID of the first
+// copy of a message cross-posted to several channels.
+type Message struct {
+	ID               uint64 `json:",omitempty"`
+	Username         string
+	Timestamp        time.Time
+	ClaimedTimestamp time.Time
+	Edited           time.Time
+	Deleted          bool `json:",omitempty"`
+	Text             string
+	OriginSystem     string
+	OriginAuthor     string
+	SnippetLanguage  string       `json:",omitempty"`
+	Attachments      []Attachment `json:",omitempty"`
+	Mentions         []string     `json:",omitempty"`
+	CrossPost        uint64       `json:",omitempty"`
+}
+
+// Attachment contains a file attached to a message (its contents are in the archive's Files, by
+// its ID).
+type Attachment struct {
+	ID          string
+	Name        string
+	ContentType string
+	Size        int64
+}
+
+// New creates an archive of the current state of a model (from a single snapshot, so it's
+// consistent), along with the attached files in an attachments store (nil if attachments aren't
+// enabled).
+func New(m *model.Model, config Config, store *attachments.Store) (*Archive, error) {
+	snapshot := m.Snapshot()
+	archive := Archive{
+		Version:       Version,
+		Created:       time.Now().UTC(),
+		Config:        config,
+		Users:         make([]User, 0, len(snapshot.Users)),
+		Channels:      make([]Channel, 0, len(snapshot.Channels)),
+		Teams:         make([]Team, 0, len(snapshot.Teams)),
+		PluginData:    snapshot.PluginData,
+		LastMessageID: snapshot.LastMessageID,
+		LastGroupID:   snapshot.LastGroupID,
+		LastEventID:   snapshot.LastEventID,
+	}
+
+	for _, snapshotUser := range snapshot.Users {
+		archive.Users = append(archive.Users, User{
+			Name:          snapshotUser.Name,
+			Owner:         snapshotUser.Owner,
+			BlockedUsers:  snapshotUser.BlockedUsers,
+			MutedChannels: snapshotUser.MutedChannels,
+			Profile:       model.Profile{DisplayName: snapshotUser.DisplayName, Bio: snapshotUser.Bio, Pronouns: snapshotUser.Pronouns},
+			Status:        model.Status{Text: snapshotUser.StatusText, Away: snapshotUser.Away},
+		})
+	}
+
+	for _, snapshotChannel := range snapshot.Channels {
+		channel := Channel{
+			Name:         snapshotChannel.Name,
+			Topic:        snapshotChannel.Topic,
+			Language:     snapshotChannel.Language,
+			Rules:        snapshotChannel.Rules,
+			Members:      snapshotChannel.Members,
+			Messages:     archiveMessages(snapshotChannel.Messages),
+			LastActivity: snapshotChannel.LastActivity,
+			PostCounts:   snapshotChannel.PostCounts,
+			ReadMarkers:  snapshotChannel.ReadMarkers,
+			Stars:        snapshotChannel.Stars,
+		}
+
+		for _, snapshotEvent := range snapshotChannel.Events {
+			channel.Events = append(channel.Events, Event{
+				ID:       snapshotEvent.ID,
+				Creator:  snapshotEvent.Creator,
+				Start:    snapshotEvent.Start,
+				Title:    snapshotEvent.Title,
+				Going:    snapshotEvent.Going,
+				Reminded: snapshotEvent.Reminded,
+			})
+		}
+
+		if snapshotChannel.Notes != nil {
+			notes := Notes(*snapshotChannel.Notes)
+			channel.Notes = &notes
+		}
+
+		archive.Channels = append(archive.Channels, channel)
+	}
+
+	for _, snapshotConversation := range snapshot.Conversations {
+		archive.Conversations = append(archive.Conversations, Conversation{
+			Usernames: snapshotConversation.Usernames,
+			Messages:  archiveMessages(snapshotConversation.Messages),
+		})
+	}
+
+	for _, snapshotGroup := range snapshot.Groups {
+		archive.Groups = append(archive.Groups, Group{
+			ID:       snapshotGroup.ID,
+			Members:  snapshotGroup.Members,
+			Messages: archiveMessages(snapshotGroup.Messages),
+		})
+	}
+
+	for _, snapshotTeam := range snapshot.Teams {
+		archive.Teams = append(archive.Teams, Team{
+			Name:    snapshotTeam.Name,
+			Members: snapshotTeam.Members,
+		})
+	}
+
+	if store != nil {
+		err := archive.copyFiles(store)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &archive, nil
+}
+
+// copyFiles copies the files attached to the archived messages from an attachments store.
+func (a *Archive) copyFiles(store *attachments.Store) error {
+	a.Files = make(map[string][]byte)
+	for _, messages := range a.messages() {
+		for _, message := range messages {
+			for _, attachment := range message.Attachments {
+				if _, ok := a.Files[attachment.ID]; ok {
+					continue
+				}
+
+				data, _, err := store.Get(attachment.ID)
+				if err != nil {
+					return err
+				}
+				a.Files[attachment.ID] = data
+			}
+		}
+	}
+
+	return nil
+}
+
+// messages returns the archived messages (of every channel and conversation).
+func (a *Archive) messages() [][]Message {
+	messages := make([][]Message, 0, len(a.Channels)+len(a.Conversations)+len(a.Groups))
+	for _, channel := range a.Channels {
+		messages = append(messages, channel.Messages)
+	}
+
+	for _, conversation := range a.Conversations {
+		messages = append(messages, conversation.Messages)
+	}
+
+	for _, group := range a.Groups {
+		messages = append(messages, group.Messages)
+	}
+
+	return messages
+}
+
+// archiveMessages copies messages from a snapshot into the archive.
+func archiveMessages(snapshotMessages []actions.SnapshotMessage) []Message {
+	messages := make([]Message, 0, len(snapshotMessages))
+	for _, snapshotMessage := range snapshotMessages {
+		messages = append(messages, Message{
+			ID:               snapshotMessage.ID,
+			Username:         snapshotMessage.Username,
+			Timestamp:        snapshotMessage.Timestamp,
+			ClaimedTimestamp: snapshotMessage.ClaimedTimestamp,
+			Edited:           snapshotMessage.Edited,
+			Deleted:          snapshotMessage.Deleted,
+			Text:             snapshotMessage.Text,
+			OriginSystem:     snapshotMessage.OriginSystem,
+			OriginAuthor:     snapshotMessage.OriginAuthor,
+			SnippetLanguage:  snapshotMessage.SnippetLanguage,
+			Mentions:         snapshotMessage.Mentions,
+			CrossPost:        snapshotMessage.CrossPost,
+		})
+
+		for _, attachment := range snapshotMessage.Attachments {
+			messages[len(messages)-1].Attachments = append(messages[len(messages)-1].Attachments, Attachment(attachment))
+		}
+	}
+
+	return messages
+}
+
+// ParseFile reads an archive file and validates its version.
+func ParseFile(path string) (*Archive, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Get reads an archive from an object in a store and validates its version.
+func Get(store storage.Store, key string) (*Archive, error) {
+	data, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(bytes.NewReader(data))
+}
+
+// Parse reads an archive and validates its version.
+func Parse(compressed io.Reader) (*Archive, error) {
+	reader, err := gzip.NewReader(compressed)
+	if err != nil {
+		return nil, errors.New("invalid archive file")
+	}
+	defer reader.Close()
+
+	archive := Archive{}
+	err = json.NewDecoder(reader).Decode(&archive)
+	if err != nil {
+		return nil, errors.New("invalid archive file")
+	}
+
+	if archive.Version < 1 || archive.Version > Version {
+		return nil, errors.New("unsupported archive version")
+	}
+
+	return &archive, nil
+}
+
+// WriteFile writes the archive to a file.
+func (a *Archive) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return a.Write(file)
+}
+
+// Put writes the archive to an object in a store.
+func (a *Archive) Put(store storage.Store, key string) error {
+	var buffer bytes.Buffer
+	err := a.Write(&buffer)
+	if err != nil {
+		return err
+	}
+
+	return store.Put(key, buffer.Bytes())
+}
+
+// Write writes the archive (compressed).
+func (a *Archive) Write(compressed io.Writer) error {
+	writer := gzip.NewWriter(compressed)
+	err := json.NewEncoder(writer).Encode(a)
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// Import applies the archived state to a model.  A version 2 archive replaces the state as a whole
+// (see model.Restore), keeping the message IDs, so it can only be imported into a server without
+// any history (model.ErrHasHistory is returned otherwise).  Its files are uploaded to an
+// attachments store first (nil if attachments aren't enabled, which an archive with files can't
+// be imported into).
+//
+// A version 1 archive is merged through the model's regular actions (so the imported state is
+// logged) instead: existing users, channels and teams are kept (archived team members are added to
+// them), messages are appended to the channel history (getting new IDs) and plugin data overwrites
+// any existing values for the same keys.
+func (a *Archive) Import(m *model.Model, store *attachments.Store) error {
+	if a.Version == 1 {
+		a.merge(m)
+		return nil
+	}
+
+	if len(a.Files) > 0 && store == nil {
+		return ErrNoAttachments
+	}
+
+	// The files are named by the hash of their contents, so a changed file gets another ID
+	for id, data := range a.Files {
+		uploadedID, err := store.Upload(data)
+		if err != nil {
+			return err
+		}
+
+		if uploadedID != id {
+			return ErrInvalidFile
+		}
+	}
+
+	return m.Restore(a.snapshot())
+}
+
+// snapshot converts a (version 2) archive into a snapshot of the model state.
+func (a *Archive) snapshot() *actions.Snapshot {
+	snapshot := actions.Snapshot{
+		Users:           make([]actions.SnapshotUser, 0, len(a.Users)),
+		Channels:        make([]actions.SnapshotChannel, 0, len(a.Channels)),
+		DeletedChannels: make([]actions.SnapshotDeletedChannel, 0),
+		Conversations:   make([]actions.SnapshotConversation, 0, len(a.Conversations)),
+		Groups:          make([]actions.SnapshotGroup, 0, len(a.Groups)),
+		Teams:           make([]actions.SnapshotTeam, 0, len(a.Teams)),
+		PluginData:      a.PluginData,
+		LastMessageID:   a.LastMessageID,
+		LastGroupID:     a.LastGroupID,
+		LastEventID:     a.LastEventID,
+		PostsToday:      make(map[string]int),
+	}
+
+	if snapshot.PluginData == nil {
+		snapshot.PluginData = make(map[string]map[string]string)
+	}
+
+	for _, user := range a.Users {
+		snapshot.Users = append(snapshot.Users, actions.SnapshotUser{
+			Name:          user.Name,
+			Owner:         user.Owner,
+			BlockedUsers:  user.BlockedUsers,
+			MutedChannels: user.MutedChannels,
+			DisplayName:   user.Profile.DisplayName,
+			Bio:           user.Profile.Bio,
+			Pronouns:      user.Profile.Pronouns,
+			StatusText:    user.Status.Text,
+			Away:          user.Status.Away,
+		})
+	}
+
+	for _, channel := range a.Channels {
+		snapshotChannel := actions.SnapshotChannel{
+			Name:         channel.Name,
+			Topic:        channel.Topic,
+			Language:     channel.Language,
+			Rules:        channel.Rules,
+			Members:      channel.Members,
+			LastActivity: channel.LastActivity,
+			Messages:     snapshotMessages(channel.Messages),
+			PostCounts:   channel.PostCounts,
+			ReadMarkers:  channel.ReadMarkers,
+			Stars:        channel.Stars,
+		}
+
+		for _, event := range channel.Events {
+			snapshotChannel.Events = append(snapshotChannel.Events, actions.SnapshotEvent{
+				ID:       event.ID,
+				Creator:  event.Creator,
+				Start:    event.Start,
+				Title:    event.Title,
+				Going:    event.Going,
+				Reminded: event.Reminded,
+			})
+		}
+
+		if channel.Notes != nil {
+			notes := actions.SnapshotNotes(*channel.Notes)
+			snapshotChannel.Notes = &notes
+		}
+
+		snapshot.Channels = append(snapshot.Channels, snapshotChannel)
+	}
+
+	for _, conversation := range a.Conversations {
+		snapshot.Conversations = append(snapshot.Conversations, actions.SnapshotConversation{
+			Usernames: conversation.Usernames,
+			Messages:  snapshotMessages(conversation.Messages),
+		})
+	}
+
+	for _, group := range a.Groups {
+		snapshot.Groups = append(snapshot.Groups, actions.SnapshotGroup{
+			ID:       group.ID,
+			Members:  group.Members,
+			Messages: snapshotMessages(group.Messages),
+		})
+	}
+
+	for _, team := range a.Teams {
+		snapshot.Teams = append(snapshot.Teams, actions.SnapshotTeam{
+			Name:    team.Name,
+			Members: team.Members,
+		})
+	}
+
+	return &snapshot
+}
+
+// snapshotMessages copies messages from the archive into a snapshot.
+func snapshotMessages(messages []Message) []actions.SnapshotMessage {
+	snapshotMessages := make([]actions.SnapshotMessage, 0, len(messages))
+	for _, message := range messages {
+		snapshotMessages = append(snapshotMessages, actions.SnapshotMessage{
+			ID:               message.ID,
+			Username:         message.Username,
+			Timestamp:        message.Timestamp,
+			ClaimedTimestamp: message.ClaimedTimestamp,
+			Edited:           message.Edited,
+			Deleted:          message.Deleted,
+			Text:             message.Text,
+			OriginSystem:     message.OriginSystem,
+			OriginAuthor:     message.OriginAuthor,
+			SnippetLanguage:  message.SnippetLanguage,
+			Mentions:         message.Mentions,
+			CrossPost:        message.CrossPost,
+		})
+
+		for _, attachment := range message.Attachments {
+			snapshotMessages[len(snapshotMessages)-1].Attachments = append(snapshotMessages[len(snapshotMessages)-1].Attachments, actions.Attachment(attachment))
+		}
+	}
+
+	return snapshotMessages
+}
+
+// merge applies a version 1 archive to a model through its regular actions.
+func (a *Archive) merge(m *model.Model) {
+	existingUsers := m.GetUsers()
+
+	// Regular users before the virtual users they own
+	for _, user := range a.Users {
+		if user.Owner == "" {
+			m.CreateUser(user.Name)
+		}
+	}
+
+	for _, user := range a.Users {
+		if user.Owner != "" {
+			m.CreateVirtualUser(user.Owner, user.Name)
+		}
+	}
+
+	for _, channel := range a.Channels {
+		m.CreateChannel(channel.Name)
+		m.SetChannelTopic(channel.Name, channel.Topic)
+		m.SetChannelRules(channel.Name, channel.Language, channel.Rules)
+	}
+
+	// Memberships (new users have joined the default channels, which may not match the archive)
+	archivedMembers := make(map[string]map[string]struct{})
+	for _, channel := range a.Channels {
+		archivedMembers[channel.Name] = make(map[string]struct{})
+		for _, member := range channel.Members {
+			archivedMembers[channel.Name][member] = struct{}{}
+			m.JoinChannel(member, channel.Name)
+		}
+	}
+
+	for _, user := range a.Users {
+		if _, ok := existingUsers[user.Name]; ok {
+			continue
+		}
+
+		for channelname := range m.GetJoinedChannels(user.Name) {
+			if _, ok := archivedMembers[channelname][user.Name]; !ok {
+				m.LeaveChannel(user.Name, channelname)
+			}
+		}
+	}
+
+	for _, user := range a.Users {
+		for _, blockedUser := range user.BlockedUsers {
+			m.BlockUser(user.Name, blockedUser)
+		}
+
+		for _, mutedChannel := range user.MutedChannels {
+			m.MuteChannel(user.Name, mutedChannel)
+		}
+
+		// Archives written before profiles were added don't have any
+		if user.Profile != (model.Profile{}) {
+			m.SetUserProfile(user.Name, user.Profile)
+		}
+
+		// Nor statuses
+		if user.Status != (model.Status{}) {
+			m.SetUserStatus(user.Name, user.Status)
+		}
+	}
+
+	// Archives written before teams were added don't have any
+	for _, team := range a.Teams {
+		m.CreateTeam(team.Name)
+		for _, member := range team.Members {
+			m.AddTeamMember(team.Name, member)
+		}
+	}
+
+	// Nor events (they get new IDs)
+	for _, channel := range a.Channels {
+		for _, event := range channel.Events {
+			eventID, err := m.CreateEvent(channel.Name, event.Creator, event.Start, event.Title)
+			if err != nil {
+				continue
+			}
+
+			for _, username := range event.Going {
+				m.RSVPEvent(eventID, username, true)
+			}
+			if event.Reminded {
+				m.MarkEventReminded(eventID)
+			}
+		}
+	}
+
+	for _, channel := range a.Channels {
+		for _, message := range channel.Messages {
+			m.ImportMessage(channel.Name, model.Message{
+				Username:        message.Username,
+				Timestamp:       message.Timestamp,
+				Text:            message.Text,
+				Origin:          model.Origin{System: message.OriginSystem, Author: message.OriginAuthor},
+				SnippetLanguage: message.SnippetLanguage,
+			})
+		}
+	}
+
+	// Archives written before plugin data was added don't have any
+	for namespace, values := range a.PluginData {
+		for key, value := range values {
+			m.PutPluginData(namespace, key, value)
+		}
+	}
+}
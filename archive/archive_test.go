@@ -0,0 +1,217 @@
+package archive_test
+
+import (
+	"bytes"
+	"chatserver/archive"
+	"chatserver/attachments"
+	"chatserver/model"
+	"chatserver/storage"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestModel returns a model with some history: edited, deleted, cross-posted, read and starred
+// messages (one with a skewed claimed timestamp), direct messages, a group conversation, notes, events, teams and plugin data.
+func newTestModel(t *testing.T) *model.Model {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.CreateVirtualUser("user1", "bot1")
+	testModel.SetUserProfile("user1", model.Profile{DisplayName: "User One"})
+	testModel.BlockUser("user2", "bot1")
+	testModel.CreateChannel("channel1")
+	testModel.JoinChannel("user1", "channel1")
+	testModel.JoinChannel("user2", "channel1")
+	testModel.SetChannelTopic("channel1", "topic1")
+
+	message1, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	message2, _ := testModel.PostMessage("channel1", "user2", time.Time{}, "message2 @user1")
+	testModel.PostMessageMulti([]string{"channel1", "General"}, "user1", time.Time{}, "message3")
+	testModel.PostBridgedMessage("General", "user2", time.Now().Add(-time.Hour), "message4", "Slack", "alice")
+	testModel.EditMessage("channel1", message1.ID, "user1", "message1 edited")
+	testModel.DeleteMessage("channel1", message2.ID, "user2")
+	testModel.PostDirectMessage("user1", "user2", time.Time{}, "direct1")
+	testModel.PostDirectMessage("user2", "user1", time.Time{}, "direct2")
+	groupID, _ := testModel.CreateGroup("user1", []string{"user2", "bot1"})
+	testModel.PostGroupMessage(groupID, "user2", time.Time{}, "group1")
+	testModel.MarkRead("user2", "channel1", message1.ID)
+	testModel.StarMessage("user2", "channel1", message1.ID, true)
+	testModel.SetChannelNotes("channel1", "user1", 0, "notes1")
+
+	eventID, _ := testModel.CreateEvent("channel1", "user1", time.Now().Add(time.Hour), "event1")
+	testModel.RSVPEvent(eventID, "user2", true)
+	testModel.CreateTeam("team1")
+	testModel.AddTeamMember("team1", "user2")
+	testModel.PutPluginData("plugin1", "key1", "value1")
+
+	return testModel
+}
+
+// encode returns an archive's JSON without its creation time, to compare archives.
+func encode(stateArchive *archive.Archive, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+
+	copied := *stateArchive
+	copied.Created = time.Time{}
+	data, _ := json.Marshal(copied)
+
+	return string(data)
+}
+
+func TestExportImport(t *testing.T) {
+	testModel := newTestModel(t)
+	exported, err := archive.New(testModel, archive.Config{}, nil)
+	if err != nil {
+		t.Fatal("Failed to create archive")
+	}
+
+	var buffer bytes.Buffer
+	if exported.Write(&buffer) != nil {
+		t.Fatal("Failed to write archive")
+	}
+
+	parsed, err := archive.Parse(&buffer)
+	if err != nil || parsed.Version != archive.Version {
+		t.Fatal("Failed to parse archive")
+	}
+
+	// Ensure that the imported state (including what the replica was fed) is the exported state
+	replica, err := model.NewModel(model.Options{TrustTimestamps: true}, nil, nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create replica")
+	}
+
+	importedModel, err := model.NewModel(model.Options{}, nil, replica.Actor(), nil)
+	if err != nil {
+		t.Fatal("Failed to create model")
+	}
+
+	if parsed.Import(importedModel, nil) != nil {
+		t.Fatal("Failed to import archive")
+	}
+
+	if encode(archive.New(importedModel, archive.Config{}, nil)) != encode(exported, nil) {
+		t.Error("Failed to import the exported state")
+	}
+
+	if encode(archive.New(replica, archive.Config{}, nil)) != encode(exported, nil) {
+		t.Error("Failed to log the imported state")
+	}
+
+	// The message IDs are kept (the deleted message keeps its place), and new messages don't
+	// reuse them
+	history := importedModel.GetChannelHistory("channel1", "user1", -1)
+	if len(history) != 3 || history[0].ID != 1 || history[0].Text != "message1 edited" || history[0].Edited.IsZero() ||
+		history[1].ID != 2 || !history[1].Deleted || history[2].CrossPost == 0 {
+		t.Error("Failed to keep the messages")
+	}
+
+	directMessages := importedModel.GetDirectMessageHistory("user2", "user1", -1)
+	if len(directMessages) != 2 || directMessages[0].Text != "direct1" || directMessages[1].Username != "user2" {
+		t.Error("Failed to import the direct messages")
+	}
+
+	groups := importedModel.GetGroups("user2")
+	if len(groups) != 1 || len(groups[0].Members) != 3 {
+		t.Fatal("Failed to import the group")
+	}
+
+	groupMessages := importedModel.GetGroupMessageHistory(groups[0].ID, "user1", -1)
+	if len(groupMessages) != 1 || groupMessages[0].Text != "group1" {
+		t.Error("Failed to import the group's messages")
+	}
+
+	message, err := importedModel.PostMessage("channel1", "user1", time.Time{}, "message5")
+	if err != nil || message.ID != exported.LastMessageID+1 {
+		t.Error("Reused a message ID")
+	}
+
+	// Only a server without history can be imported into
+	if parsed.Import(importedModel, nil) != model.ErrHasHistory {
+		t.Error("Imported into a server with history")
+	}
+}
+
+func TestImportVersion1(t *testing.T) {
+	stateArchive := archive.Archive{
+		Version:  1,
+		Users:    []archive.User{{Name: "user1"}},
+		Channels: []archive.Channel{{Name: "channel1", Members: []string{"user1"}, Messages: []archive.Message{{Username: "user1", Text: "message1"}}}},
+	}
+
+	testModel := newTestModel(t)
+	if stateArchive.Import(testModel, nil) != nil {
+		t.Fatal("Failed to import version 1 archive")
+	}
+
+	// Ensure that the message is appended to the existing history
+	history := testModel.GetChannelHistory("channel1", "user1", -1)
+	if len(history) != 4 || history[3].Text != "message1" {
+		t.Error("Failed to merge version 1 archive")
+	}
+}
+
+func TestExportImportAttachments(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	store := attachments.NewStore(storage.NewLocalStore(filepath.Join(tempDir, "store1")), 1000, nil)
+	id, _ := store.Upload([]byte("file1"))
+
+	testModel := newTestModel(t)
+	message, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message5")
+	if testModel.AttachFile("channel1", message.ID, "user1", model.Attachment{ID: id, Name: "file1.txt", ContentType: "text/plain", Size: 5}) != nil {
+		t.Fatal("Failed to attach file")
+	}
+
+	exported, err := archive.New(testModel, archive.Config{}, store)
+	if err != nil || string(exported.Files[id]) != "file1" {
+		t.Fatal("Failed to archive the attached file")
+	}
+
+	// An archive with files can't be imported without attachments
+	importedModel, _ := model.NewModel(model.Options{}, nil, nil, nil)
+	if exported.Import(importedModel, nil) != archive.ErrNoAttachments {
+		t.Error("Imported files without attachments")
+	}
+
+	// Ensure that the file is copied into the new store along with the reference to it
+	importedStore := attachments.NewStore(storage.NewLocalStore(filepath.Join(tempDir, "store2")), 1000, nil)
+	if exported.Import(importedModel, importedStore) != nil {
+		t.Fatal("Failed to import archive")
+	}
+
+	history := importedModel.GetChannelHistory("channel1", "user1", 1)
+	if len(history) != 1 || len(history[0].Attachments) != 1 || history[0].Attachments[0].Name != "file1.txt" {
+		t.Error("Failed to import the attachment")
+	}
+
+	if data, _, err := importedStore.Get(id); err != nil || string(data) != "file1" {
+		t.Error("Failed to import the attached file")
+	}
+
+	if importedModel.GetAttachmentRefs()[id] != 1 {
+		t.Error("Failed to count the imported attachment")
+	}
+
+	// A file that doesn't match its ID isn't imported
+	exported.Files[id] = []byte("file2")
+	otherModel, _ := model.NewModel(model.Options{}, nil, nil, nil)
+	if exported.Import(otherModel, importedStore) != archive.ErrInvalidFile {
+		t.Error("Imported a changed file")
+	}
+}
@@ -0,0 +1,232 @@
+// Package attachments stores the files uploaded to be attached to messages in object storage, by
+// the SHA-256 hash of their contents, so a file uploaded again (e.g. the same image posted in
+// several channels) is only stored once.  The model counts the messages each file is attached to
+// (see model.GetAttachmentRefs), and GC deletes the files no message refers to anymore (along with
+// their thumbnails, which are generated for the images so clients can show previews).
+package attachments
+
+import (
+	"chatserver/sessions"
+	"chatserver/storage"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keyPrefix is prepended to the IDs of the files to get their keys in the object store.
+const keyPrefix string = "attachments/"
+
+// GracePeriod is how long an uploaded file is kept without being attached to a message (e.g.
+// between a client uploading it and attaching it), so GC doesn't delete it in the meantime.
+const GracePeriod time.Duration = time.Hour
+
+// Errors returned by the store.
+var (
+	ErrNotFound = errors.New("attachment not found")
+	ErrTooLarge = errors.New("attachment too large")
+)
+
+// Store provides the stored files, which are named by their IDs (the hex SHA-256 hash of their
+// contents).
+type Store struct {
+	store          storage.Store
+	maxSize        int64
+	thumbnailSizes []int
+}
+
+// NewStore creates/initializes/returns a new Store for files of up to maxSize bytes in an object
+// store, generating thumbnails of the images in each of thumbnailSizes (none when it's empty).
+func NewStore(store storage.Store, maxSize int64, thumbnailSizes []int) *Store {
+	attachmentStore := Store{
+		store:          store,
+		maxSize:        maxSize,
+		thumbnailSizes: thumbnailSizes,
+	}
+
+	return &attachmentStore
+}
+
+// MaxSize returns the largest file that can be uploaded.
+func (s *Store) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Upload stores a file (and the thumbnails of an image), unless the same contents are stored
+// already, and returns its ID.  A file that's already stored but was last written before half the
+// grace period is written again, so GC can't delete it before it's attached.
+func (s *Store) Upload(data []byte) (string, error) {
+	if int64(len(data)) > s.maxSize {
+		return "", ErrTooLarge
+	}
+
+	hash := sha256.Sum256(data)
+	id := hex.EncodeToString(hash[:])
+
+	object, err := s.store.Stat(keyPrefix + id)
+	if err == nil && time.Since(object.Modified) < GracePeriod/2 {
+		return id, nil
+	} else if err != nil && err != storage.ErrNotFound {
+		return "", err
+	}
+
+	err = s.store.Put(keyPrefix+id, data)
+	if err != nil {
+		return "", err
+	}
+
+	return id, s.putThumbnails(id, data)
+}
+
+// Get returns the contents of a file, and its content type (sniffed from the contents).
+func (s *Store) Get(id string) ([]byte, string, error) {
+	if !validID(id) {
+		return nil, "", ErrNotFound
+	}
+
+	data, err := s.store.Get(keyPrefix + id)
+	if err == storage.ErrNotFound {
+		return nil, "", ErrNotFound
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	return data, http.DetectContentType(data), nil
+}
+
+// GC deletes the files that no message refers to (those not in the reference counts, see
+// model.GetAttachmentRefs), other than those written within the grace period, and their
+// thumbnails.  It returns the number of files deleted, and the number of bytes freed.
+func (s *Store) GC(refs map[string]int, gracePeriod time.Duration) (int, int64, error) {
+	objects, err := s.store.List(keyPrefix)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	deleted := 0
+	freed := int64(0)
+	stored := make(map[string]bool)
+	for _, object := range objects {
+		id := strings.TrimPrefix(object.Key, keyPrefix)
+		if refs[id] > 0 || time.Since(object.Modified) < gracePeriod {
+			stored[id] = true
+			continue
+		}
+
+		err := s.store.Delete(object.Key)
+		if err != nil {
+			return deleted, freed, err
+		}
+
+		deleted++
+		freed += object.Size
+	}
+
+	thumbnailsFreed, err := s.gcThumbnails(stored)
+	return deleted, freed + thumbnailsFreed, err
+}
+
+// UploadResponse is the response to an upload.
+type UploadResponse struct {
+	ID   string
+	Size int64
+}
+
+// Handler serves the files under a path prefix (e.g. "/attachments/<id>"), and stores the files
+// POSTed to the prefix itself by clients with a valid session (in the "session" query parameter).
+// Only images are shown in the browser; anything else is downloaded, never sniffed as HTML.  The
+// thumbnails of images are served with the size in the "thumbnail" query parameter (e.g.
+// "/attachments/<id>?thumbnail=256").
+func Handler(store *Store, sessions *sessions.Store, prefix string) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id := strings.TrimPrefix(request.URL.Path, prefix)
+		switch {
+		case request.Method == http.MethodPost && id == "":
+			if _, ok := sessions.Get(request.URL.Query().Get("session")); !ok {
+				http.Error(writer, "invalid session", http.StatusForbidden)
+				return
+			}
+
+			data, err := ioutil.ReadAll(io.LimitReader(request.Body, store.MaxSize()+1))
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			id, err := store.Upload(data)
+			if err == ErrTooLarge {
+				http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			} else if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			writer.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(writer).Encode(UploadResponse{ID: id, Size: int64(len(data))})
+		case (request.Method == http.MethodGet || request.Method == http.MethodHead) && request.URL.Query().Get("thumbnail") != "":
+			size, err := strconv.Atoi(request.URL.Query().Get("thumbnail"))
+			if err != nil {
+				http.NotFound(writer, request)
+				return
+			}
+
+			data, err := store.Thumbnail(id, size)
+			if err == ErrNotFound {
+				http.NotFound(writer, request)
+				return
+			} else if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			writer.Header().Set("Content-Type", "image/png")
+			writer.Header().Set("X-Content-Type-Options", "nosniff")
+			writer.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			writer.Write(data)
+		case request.Method == http.MethodGet || request.Method == http.MethodHead:
+			data, contentType, err := store.Get(id)
+			if err == ErrNotFound {
+				http.NotFound(writer, request)
+				return
+			} else if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if !strings.HasPrefix(contentType, "image/") {
+				contentType = "application/octet-stream"
+				writer.Header().Set("Content-Disposition", "attachment")
+			}
+
+			// The contents of an ID never change
+			writer.Header().Set("Content-Type", contentType)
+			writer.Header().Set("X-Content-Type-Options", "nosniff")
+			writer.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			writer.Write(data)
+		default:
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// validID returns whether an ID is a hex SHA-256 hash.
+func validID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,209 @@
+package attachments_test
+
+import (
+	"bytes"
+	"chatserver/attachments"
+	"chatserver/sessions"
+	"chatserver/storage"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUploadAndGC(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	objectStore := storage.NewLocalStore(tempDir)
+	store := attachments.NewStore(objectStore, 10, nil)
+
+	// The same contents are only stored once
+	id1, err := store.Upload([]byte("file1"))
+	if err != nil || len(id1) != 64 {
+		t.Fatal("Failed to upload file")
+	}
+
+	id2, err := store.Upload([]byte("file1"))
+	if err != nil || id2 != id1 {
+		t.Error("Uploading the same contents gave a different ID")
+	}
+
+	id3, err := store.Upload([]byte("file3"))
+	if err != nil || id3 == id1 {
+		t.Error("Uploading different contents gave the same ID")
+	}
+
+	if objects, _ := objectStore.List(""); len(objects) != 2 {
+		t.Error("Incorrect number of files stored")
+	}
+
+	if _, err := store.Upload([]byte("a file that's too large")); err != attachments.ErrTooLarge {
+		t.Error("Uploaded a file that's too large")
+	}
+
+	data, contentType, err := store.Get(id1)
+	if err != nil || string(data) != "file1" || contentType != "text/plain; charset=utf-8" {
+		t.Error("Failed to get file")
+	}
+
+	if _, _, err := store.Get("../" + id1); err != attachments.ErrNotFound {
+		t.Error("Got a file with an invalid ID")
+	}
+
+	// Files are kept for the grace period, and then as long as they're referred to
+	deleted, _, err := store.GC(map[string]int{}, attachments.GracePeriod)
+	if err != nil || deleted != 0 {
+		t.Error("Deleted files within the grace period")
+	}
+
+	deleted, freed, err := store.GC(map[string]int{id1: 2}, 0)
+	if err != nil || deleted != 1 || freed != 5 {
+		t.Error("Failed to delete unreferenced file")
+	}
+
+	if _, _, err := store.Get(id3); err != attachments.ErrNotFound {
+		t.Error("Got a deleted file")
+	}
+
+	if _, _, err := store.Get(id1); err != nil {
+		t.Error("Deleted a referenced file")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	store := attachments.NewStore(storage.NewLocalStore(filepath.Join(tempDir, "store")), 1000, []int{4})
+	sessionStore := sessions.NewStore(time.Hour, nil)
+	session := sessionStore.Create("user1", "", 0)
+	server := httptest.NewServer(attachments.Handler(store, sessionStore, "/attachments/"))
+	defer server.Close()
+
+	// Only clients with a session can upload
+	response, err := http.Post(server.URL+"/attachments/?session=invalid", "", bytes.NewReader([]byte("<html>")))
+	if err != nil || response.StatusCode != http.StatusForbidden {
+		t.Error("Uploaded a file without a session")
+	}
+
+	response, err = http.Post(server.URL+"/attachments/?session="+session.ID, "", bytes.NewReader([]byte("<html>")))
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatal("Failed to upload file")
+	}
+
+	var uploaded attachments.UploadResponse
+	if json.NewDecoder(response.Body).Decode(&uploaded) != nil || uploaded.Size != 6 {
+		t.Error("Incorrect upload response")
+	}
+	response.Body.Close()
+
+	// Anything other than an image is downloaded
+	response, err = http.Get(server.URL + "/attachments/" + uploaded.ID)
+	if err != nil || response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "application/octet-stream" ||
+		response.Header.Get("Content-Disposition") != "attachment" || response.Header.Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("Failed to serve file as a download")
+	}
+
+	response, err = http.Get(server.URL + "/attachments/" + uploaded.ID + "0")
+	if err != nil || response.StatusCode != http.StatusNotFound {
+		t.Error("Served a file that doesn't exist")
+	}
+
+	// Only images have thumbnails
+	response, err = http.Get(server.URL + "/attachments/" + uploaded.ID + "?thumbnail=4")
+	if err != nil || response.StatusCode != http.StatusNotFound {
+		t.Error("Served a thumbnail of a file that isn't an image")
+	}
+}
+
+func TestThumbnails(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	// Make an 8x4 image, red on the left half and blue on the right
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			if x < 4 {
+				img.Set(x, y, color.NRGBA{R: 0xff, A: 0xff})
+			} else {
+				img.Set(x, y, color.NRGBA{B: 0xff, A: 0xff})
+			}
+		}
+	}
+	var buffer bytes.Buffer
+	png.Encode(&buffer, img)
+
+	objectStore := storage.NewLocalStore(tempDir)
+	store := attachments.NewStore(objectStore, 1000, []int{2, 16})
+
+	// Thumbnails are generated on upload, scaled down (never up) to fit their size
+	id, err := store.Upload(buffer.Bytes())
+	if err != nil {
+		t.Fatal("Failed to upload image")
+	}
+
+	if objects, _ := objectStore.List("thumbnails/"); len(objects) != 2 {
+		t.Error("Failed to generate thumbnails on upload")
+	}
+
+	data, err := store.Thumbnail(id, 2)
+	if err != nil {
+		t.Fatal("Failed to get thumbnail")
+	}
+
+	thumbnail, err := png.Decode(bytes.NewReader(data))
+	if err != nil || thumbnail.Bounds().Dx() != 2 || thumbnail.Bounds().Dy() != 1 {
+		t.Fatal("Incorrect thumbnail size")
+	}
+
+	if color.NRGBAModel.Convert(thumbnail.At(0, 0)) != (color.NRGBA{R: 0xff, A: 0xff}) ||
+		color.NRGBAModel.Convert(thumbnail.At(1, 0)) != (color.NRGBA{B: 0xff, A: 0xff}) {
+		t.Error("Incorrect thumbnail contents")
+	}
+
+	data, _ = store.Thumbnail(id, 16)
+	if thumbnail, err := png.Decode(bytes.NewReader(data)); err != nil || thumbnail.Bounds().Dx() != 8 || thumbnail.Bounds().Dy() != 4 {
+		t.Error("Scaled a thumbnail up")
+	}
+
+	if _, err := store.Thumbnail(id, 3); err != attachments.ErrNotFound {
+		t.Error("Got a thumbnail of a size that isn't configured")
+	}
+
+	// Thumbnails missing from the store are generated when they're asked for
+	objectStore.Delete("thumbnails/" + id + "/2")
+	if _, err := store.Thumbnail(id, 2); err != nil {
+		t.Error("Failed to generate a missing thumbnail")
+	}
+
+	// Thumbnails are deleted along with their files
+	deleted, _, err := store.GC(map[string]int{}, 0)
+	if err != nil || deleted != 1 {
+		t.Error("Failed to delete unreferenced image")
+	}
+
+	if objects, _ := objectStore.List("thumbnails/"); len(objects) != 0 {
+		t.Error("Failed to delete the thumbnails of a deleted image")
+	}
+}
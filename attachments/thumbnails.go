@@ -0,0 +1,200 @@
+package attachments
+
+import (
+	"bytes"
+	"chatserver/storage"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	// Register the formats thumbnails are generated for
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+// thumbnailPrefix is prepended to "<id>/<size>" to get the keys of the thumbnails in the object
+// store.
+const thumbnailPrefix string = "thumbnails/"
+
+// maxThumbnailPixels is the largest image (in pixels) thumbnails are generated for, so a small
+// file claiming huge dimensions can't exhaust the memory.
+const maxThumbnailPixels int = 50 * 1000 * 1000
+
+// ThumbnailSizes returns the sizes of the thumbnails generated for images (the largest width and
+// height, in pixels).
+func (s *Store) ThumbnailSizes() []int {
+	return s.thumbnailSizes
+}
+
+// Thumbnail returns a PNG thumbnail of an image, fitting in a square of one of the thumbnail
+// sizes.  Thumbnails are generated when images are uploaded, or else when they're first asked for
+// (e.g. for images uploaded before the size was configured), and kept in the object store.
+func (s *Store) Thumbnail(id string, size int) ([]byte, error) {
+	if !validID(id) || !s.isThumbnailSize(size) {
+		return nil, ErrNotFound
+	}
+
+	data, err := s.store.Get(thumbnailKey(id, size))
+	if err == nil {
+		return data, nil
+	} else if err != storage.ErrNotFound {
+		return nil, err
+	}
+
+	original, _, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	img, ok := decodeImage(original)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	data, err = encodeThumbnail(img, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, s.store.Put(thumbnailKey(id, size), data)
+}
+
+// putThumbnails generates and stores the thumbnails of a file, if it's an image.
+func (s *Store) putThumbnails(id string, data []byte) error {
+	if len(s.thumbnailSizes) == 0 {
+		return nil
+	}
+
+	img, ok := decodeImage(data)
+	if !ok {
+		return nil
+	}
+
+	for _, size := range s.thumbnailSizes {
+		thumbnail, err := encodeThumbnail(img, size)
+		if err != nil {
+			return err
+		}
+
+		err = s.store.Put(thumbnailKey(id, size), thumbnail)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// gcThumbnails deletes the thumbnails of the files that aren't stored anymore, and returns the
+// number of bytes freed.
+func (s *Store) gcThumbnails(stored map[string]bool) (int64, error) {
+	objects, err := s.store.List(thumbnailPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	freed := int64(0)
+	for _, object := range objects {
+		id := strings.SplitN(strings.TrimPrefix(object.Key, thumbnailPrefix), "/", 2)[0]
+		if stored[id] {
+			continue
+		}
+
+		err := s.store.Delete(object.Key)
+		if err != nil {
+			return freed, err
+		}
+
+		freed += object.Size
+	}
+
+	return freed, nil
+}
+
+func (s *Store) isThumbnailSize(size int) bool {
+	for _, thumbnailSize := range s.thumbnailSizes {
+		if thumbnailSize == size {
+			return true
+		}
+	}
+
+	return false
+}
+
+func thumbnailKey(id string, size int) string {
+	return thumbnailPrefix + id + "/" + strconv.Itoa(size)
+}
+
+// decodeImage decodes a GIF, JPEG or PNG image, unless it's too large.
+func decodeImage(data []byte) (image.Image, bool) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 || config.Width > maxThumbnailPixels/config.Height {
+		return nil, false
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+
+	return img, true
+}
+
+// encodeThumbnail scales an image down (never up) to fit in a square of a size, averaging the
+// pixels that make up each pixel of the thumbnail, and encodes it as a PNG.
+func encodeThumbnail(img image.Image, size int) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	thumbnailWidth, thumbnailHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			thumbnailWidth, thumbnailHeight = size, height*size/width
+		} else {
+			thumbnailWidth, thumbnailHeight = width*size/height, size
+		}
+	}
+	if thumbnailWidth < 1 {
+		thumbnailWidth = 1
+	}
+	if thumbnailHeight < 1 {
+		thumbnailHeight = 1
+	}
+
+	thumbnail := image.NewNRGBA(image.Rect(0, 0, thumbnailWidth, thumbnailHeight))
+	for y := 0; y < thumbnailHeight; y++ {
+		y0, y1 := y*height/thumbnailHeight, (y+1)*height/thumbnailHeight
+		for x := 0; x < thumbnailWidth; x++ {
+			x0, x1 := x*width/thumbnailWidth, (x+1)*width/thumbnailWidth
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+
+			// Fully transparent pixels are left as they are, the others are unpremultiplied (the
+			// sums are of premultiplied colors)
+			if a == 0 {
+				continue
+			}
+			thumbnail.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r * 0xff / a),
+				G: uint8(g * 0xff / a),
+				B: uint8(b * 0xff / a),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	var buffer bytes.Buffer
+	err := png.Encode(&buffer, thumbnail)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,256 @@
+// Package bootstrap provides a utility to parse a JSON bootstrap file describing the
+// initial state of the chat server (users, channels, topics, and memberships) and apply
+// it to a model.  The same file format can be used as a desired state that is continuously
+// reconciled against the model.
+package bootstrap
+
+import (
+	"bytes"
+	"chatserver/credentials"
+	"chatserver/model"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Channel contains the initial state of a single channel.
+type Channel struct {
+	Name    string
+	Topic   string
+	Members []string
+}
+
+// Bootstrap contains the initial state of the chat server.
+type Bootstrap struct {
+	Users    []string
+	Channels []Channel
+}
+
+// ParseFile attempts to open a JSON bootstrap file at a given location, parse it into a
+// Bootstrap struct, validate the contents, and return the data.
+func ParseFile(bootstrapFilePath string) (*Bootstrap, error) {
+	// Read the bootstrap file
+	bootstrapData, err := ioutil.ReadFile(bootstrapFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the bootstrap JSON (rejecting unknown fields so unsupported state isn't silently dropped)
+	bootstrap := Bootstrap{}
+	decoder := json.NewDecoder(bytes.NewReader(bootstrapData))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&bootstrap)
+	if err != nil {
+		return nil, errors.New("invalid bootstrap file")
+	}
+
+	// Validate the users
+	users := make(map[string]struct{})
+	for _, username := range bootstrap.Users {
+		if !isValidName(username) {
+			return nil, errors.New("invalid bootstrap user")
+		}
+		users[username] = struct{}{}
+	}
+
+	// Validate the channels
+	for _, channel := range bootstrap.Channels {
+		if !isValidName(channel.Name) {
+			return nil, errors.New("invalid bootstrap channel")
+		}
+
+		for _, member := range channel.Members {
+			if _, ok := users[member]; !ok {
+				return nil, errors.New("invalid bootstrap channel member")
+			}
+		}
+	}
+
+	return &bootstrap, nil
+}
+
+// Apply creates the users and channels described by the Bootstrap in the model, sets the
+// channel topics, and joins the channel members.  Entities that already exist are reused.
+func (b *Bootstrap) Apply(m *model.Model) {
+	for _, username := range b.Users {
+		m.CreateUser(username)
+	}
+
+	for _, channel := range b.Channels {
+		m.CreateChannel(channel.Name)
+
+		if channel.Topic != "" {
+			m.SetChannelTopic(channel.Name, channel.Topic)
+		}
+
+		for _, member := range channel.Members {
+			m.JoinChannel(member, channel.Name)
+		}
+	}
+}
+
+// Namespace is the plugin data namespace in which Reconcile records the users and channels managed
+// by the desired state (those it has listed), the only ones pruning deletes.
+const Namespace = "bootstrap"
+
+// Reconcile brings the model in line with the Bootstrap as a desired state, as a single change (see
+// model.Batch): if any part of it is rejected, nothing is changed and the error is returned.
+// Missing users, channels, and memberships are created and channel topics are updated.  The users
+// and channels listed are recorded as managed by the desired state.  When prune is set, the managed
+// users and channels that are no longer listed are deleted (unless protected), so the users that
+// registered themselves (and the channels they created) are never deleted, and members that aren't
+// listed are removed from channels that declare their members.  The passwords of deleted users are
+// deleted along with them (credentialStore is nil when passwords are disabled).
+func (b *Bootstrap) Reconcile(m *model.Model, prune bool, credentialStore *credentials.Credentials) error {
+	mutations := make([]model.Mutation, 0)
+	managed := m.GetPluginKeys(Namespace)
+	manage := func(key string) {
+		if _, ok := managed[key]; !ok {
+			mutations = append(mutations, model.Mutation{Type: "PutPluginData", Namespace: Namespace, Key: key, Value: "managed"})
+			managed[key] = struct{}{}
+		}
+	}
+
+	// Create anything that is missing
+	users := m.GetUsers()
+	desiredUsers := make(map[string]struct{})
+	newUsers := make(map[string]struct{})
+	for _, username := range b.Users {
+		if _, ok := users[username]; !ok {
+			mutations = append(mutations, model.Mutation{Type: "CreateUser", Username: username})
+			users[username] = struct{}{}
+			newUsers[username] = struct{}{}
+		}
+
+		desiredUsers[username] = struct{}{}
+		manage(userPrefix + username)
+	}
+
+	// New users join the default channels as they're created
+	defaultChannels := make(map[string]struct{})
+	for _, channelname := range m.DefaultChannels() {
+		defaultChannels[channelname] = struct{}{}
+	}
+
+	channels := m.GetChannels()
+	desiredChannels := make(map[string]struct{})
+	members := make(map[string]map[string]struct{})
+	for _, channel := range b.Channels {
+		topic := ""
+		if _, ok := channels[channel.Name]; ok {
+			if _, ok := members[channel.Name]; !ok {
+				members[channel.Name] = m.GetChannelMembers(channel.Name)
+				topic = m.GetChannelInfo(channel.Name).Topic
+			}
+		} else {
+			mutations = append(mutations, model.Mutation{Type: "CreateChannel", Channelname: channel.Name})
+			channels[channel.Name] = struct{}{}
+			members[channel.Name] = make(map[string]struct{})
+		}
+
+		desiredChannels[channel.Name] = struct{}{}
+		manage(channelPrefix + channel.Name)
+
+		if channel.Topic != topic {
+			mutations = append(mutations, model.Mutation{Type: "SetChannelTopic", Channelname: channel.Name, Topic: channel.Topic})
+		}
+
+		for _, member := range channel.Members {
+			_, isMember := members[channel.Name][member]
+			_, isNew := newUsers[member]
+			_, isDefault := defaultChannels[channel.Name]
+			if !isMember && !(isNew && isDefault) {
+				mutations = append(mutations, model.Mutation{Type: "JoinChannel", Username: member, Channelname: channel.Name})
+			}
+			members[channel.Name][member] = struct{}{}
+		}
+	}
+
+	// Delete the managed users and channels that are no longer desired
+	deletedUsers := make(map[string]struct{})
+	if prune {
+		for _, key := range sortedKeys(managed) {
+			if strings.HasPrefix(key, userPrefix) {
+				username := strings.TrimPrefix(key, userPrefix)
+				if _, ok := desiredUsers[username]; ok {
+					continue
+				}
+
+				if _, ok := users[username]; ok && !m.Policy().IsUserProtected(username) {
+					mutations = append(mutations, model.Mutation{Type: "DeleteUser", Username: username})
+					deletedUsers[username] = struct{}{}
+				}
+			} else if strings.HasPrefix(key, channelPrefix) {
+				channelname := strings.TrimPrefix(key, channelPrefix)
+				if _, ok := desiredChannels[channelname]; ok {
+					continue
+				}
+
+				if _, ok := channels[channelname]; ok && !m.Policy().IsChannelProtected(channelname) {
+					mutations = append(mutations, model.Mutation{Type: "DeleteChannel", Channelname: channelname})
+				}
+			}
+
+			mutations = append(mutations, model.Mutation{Type: "PutPluginData", Namespace: Namespace, Key: key})
+		}
+
+		// Remove the members that aren't listed from the channels that declare their members
+		for _, channel := range b.Channels {
+			if len(channel.Members) == 0 {
+				continue
+			}
+
+			desiredMembers := make(map[string]struct{})
+			for _, member := range channel.Members {
+				desiredMembers[member] = struct{}{}
+			}
+
+			for _, username := range sortedKeys(members[channel.Name]) {
+				_, isDesired := desiredMembers[username]
+				_, isDeleted := deletedUsers[username]
+				if !isDesired && !isDeleted {
+					mutations = append(mutations, model.Mutation{Type: "LeaveChannel", Username: username, Channelname: channel.Name})
+				}
+			}
+			members[channel.Name] = desiredMembers
+		}
+	}
+
+	if len(mutations) == 0 {
+		return nil
+	}
+
+	if _, err := m.Batch(mutations); err != nil {
+		return err
+	}
+
+	if credentialStore != nil {
+		for username := range deletedUsers {
+			credentialStore.DeletePassword(username)
+		}
+	}
+
+	return nil
+}
+
+// The keys of the managed users and channels (in the plugin data namespace)
+const (
+	userPrefix    = "user:"
+	channelPrefix = "channel:"
+)
+
+func sortedKeys(keys map[string]struct{}) []string {
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	return sortedKeys
+}
+
+func isValidName(name string) bool {
+	return name != "" && !strings.Contains(name, " ")
+}
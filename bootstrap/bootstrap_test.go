@@ -0,0 +1,156 @@
+package bootstrap_test
+
+import (
+	"chatserver/bootstrap"
+	"chatserver/credentials"
+	"chatserver/model"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "bootstrap.json")
+	ioutil.WriteFile(path, []byte(`{"Users": ["user1"], "Channels": [{"Name": "channel1", "Topic": "topic1", "Members": ["user1"]}]}`), 0600)
+	desiredState, err := bootstrap.ParseFile(path)
+	if err != nil || len(desiredState.Users) != 1 || len(desiredState.Channels) != 1 || desiredState.Channels[0].Topic != "topic1" {
+		t.Error("Failed to parse bootstrap file")
+	}
+
+	// Ensure that unknown fields, invalid names and members that aren't listed are rejected
+	invalid := []string{
+		`{"Users": ["user1"], "Teams": []}`,
+		`{"Users": ["user 1"]}`,
+		`{"Channels": [{"Name": ""}]}`,
+		`{"Users": ["user1"], "Channels": [{"Name": "channel1", "Members": ["user2"]}]}`,
+	}
+
+	for _, data := range invalid {
+		ioutil.WriteFile(path, []byte(data), 0600)
+		if _, err := bootstrap.ParseFile(path); err == nil {
+			t.Errorf("Parsed the invalid bootstrap file %s", data)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create model")
+	}
+
+	testCredentials := credentials.New(credentials.NewLogStore(testModel))
+
+	// A user that registered itself (e.g. over telnet), and the channel it created
+	testModel.CreateUser("registered")
+	testCredentials.SetPassword("registered", "password1")
+	testModel.CreateChannel("channel3")
+
+	desiredState := bootstrap.Bootstrap{
+		Users: []string{"user1", "user2"},
+		Channels: []bootstrap.Channel{
+			{Name: "General", Members: []string{"user1", "user2"}},
+			{Name: "channel1", Topic: "topic1", Members: []string{"user1"}},
+			{Name: "channel2"},
+		},
+	}
+
+	if desiredState.Reconcile(testModel, true, testCredentials) != nil {
+		t.Fatal("Failed to reconcile")
+	}
+
+	// Ensure that the desired state is created (new users have joined the default channels)
+	users := testModel.GetUsers()
+	if _, ok := users["user1"]; !ok {
+		t.Error("Failed to create user")
+	}
+
+	channelInfo := testModel.GetChannelInfo("channel1")
+	if channelInfo.Topic != "topic1" {
+		t.Error("Failed to set channel topic")
+	}
+
+	if _, ok := testModel.GetChannelMembers("channel1")["user1"]; !ok {
+		t.Error("Failed to join channel")
+	}
+
+	// The users and channels that were never listed are kept, but not their declared memberships
+	if _, ok := users["registered"]; !ok || !testCredentials.HasPassword("registered") {
+		t.Error("Pruned a user that isn't managed")
+	}
+
+	if _, ok := testModel.GetChannels()["channel3"]; !ok {
+		t.Error("Pruned a channel that isn't managed")
+	}
+
+	if _, ok := testModel.GetChannelMembers("General")["registered"]; ok {
+		t.Error("Failed to remove a member that isn't listed")
+	}
+
+	// Ensure that the managed users and channels that are no longer listed are deleted
+	testCredentials.SetPassword("user2", "password2")
+	desiredState = bootstrap.Bootstrap{
+		Users:    []string{"user1"},
+		Channels: []bootstrap.Channel{{Name: "channel1", Topic: "topic1", Members: []string{"user1"}}},
+	}
+
+	if desiredState.Reconcile(testModel, true, testCredentials) != nil {
+		t.Fatal("Failed to reconcile")
+	}
+
+	users = testModel.GetUsers()
+	if _, ok := users["user2"]; ok || testCredentials.HasPassword("user2") {
+		t.Error("Failed to prune user")
+	}
+
+	channels := testModel.GetChannels()
+	if _, ok := channels["channel2"]; ok {
+		t.Error("Failed to prune channel")
+	}
+
+	if _, ok := users["registered"]; !ok {
+		t.Error("Pruned a user that isn't managed")
+	}
+
+	if _, ok := channels["channel3"]; !ok {
+		t.Error("Pruned a channel that isn't managed")
+	}
+
+	// Reconciling the same state again changes nothing
+	if desiredState.Reconcile(testModel, true, testCredentials) != nil {
+		t.Error("Failed to reconcile the same state")
+	}
+}
+
+func TestReconcileRejected(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{MaxUsers: 3}, nil, nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create model")
+	}
+
+	// Ensure that nothing is changed if part of the desired state is rejected
+	desiredState := bootstrap.Bootstrap{
+		Users:    []string{"user1", "user2", "user3"},
+		Channels: []bootstrap.Channel{{Name: "channel1"}},
+	}
+
+	if desiredState.Reconcile(testModel, false, nil) != model.ErrUserQuota {
+		t.Error("Failed to reject the desired state")
+	}
+
+	if len(testModel.GetUsers()) != 1 || len(testModel.GetChannels()) != 1 {
+		t.Error("Applied part of a rejected desired state")
+	}
+
+	if len(testModel.GetPluginKeys(bootstrap.Namespace)) != 0 {
+		t.Error("Recorded the managed entities of a rejected desired state")
+	}
+}
@@ -0,0 +1,149 @@
+package bots
+
+import (
+	"chatserver/model"
+	"chatserver/pins"
+	"chatserver/reactions"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Automations is a bot handler that runs simple rules set up by the admins: messages with a
+// keyword are moved to another channel, and so are the messages of nothing but emoji.  A moved
+// message is reposted by the bot in the other channel (with its author and where it came from),
+// deleted where it was posted, and a note left behind saying where it went.  Snippets aren't
+// moved, keywords in code being more likely mentions than topics.  Messages that get enough
+// reactions are pinned in their channel, with a note saying so.
+type Automations struct {
+	moves        map[string]string
+	keywords     []string
+	emojiChannel string
+	pinReactions int
+}
+
+// NewAutomations creates/initializes/returns a new Automations handler.  Moves map keywords
+// (matched against the words of a message, ignoring case) to the channels their messages are
+// moved to, the messages of just emoji are moved to the emoji channel (empty disables it), and
+// messages are pinned once they have the pin reactions (0 disables it).
+func NewAutomations(moves map[string]string, emojiChannel string, pinReactions int) *Automations {
+	automations := Automations{
+		moves:        make(map[string]string),
+		keywords:     make([]string, 0),
+		emojiChannel: emojiChannel,
+		pinReactions: pinReactions,
+	}
+
+	for keyword, channelname := range moves {
+		automations.moves[strings.ToLower(keyword)] = channelname
+		automations.keywords = append(automations.keywords, strings.ToLower(keyword))
+	}
+
+	// Check the keywords in a fixed order, so the same message is always moved to the same channel
+	sort.Strings(automations.keywords)
+
+	return &automations
+}
+
+// OnUserCreated does nothing, the rules are only about messages.
+func (a *Automations) OnUserCreated(bot *Bot, username string) {
+}
+
+// OnMessage moves the message if it matches a rule (the emoji rule first, then the first keyword
+// found).
+func (a *Automations) OnMessage(bot *Bot, channelname string, message model.Message) {
+	if message.SnippetLanguage != "" {
+		return
+	}
+
+	if a.emojiChannel != "" && isEmojiOnly(message.Text) {
+		a.move(bot, channelname, message, a.emojiChannel)
+		return
+	}
+
+	words := make(map[string]struct{})
+	for _, field := range strings.Fields(strings.ToLower(message.Text)) {
+		words[strings.Trim(field, ".,!?;:\"'()")] = struct{}{}
+	}
+
+	for _, keyword := range a.keywords {
+		if _, ok := words[keyword]; ok {
+			a.move(bot, channelname, message, a.moves[keyword])
+			return
+		}
+	}
+}
+
+// OnMessageChanged pins the message once it has enough reactions (it stays pinned if they're taken
+// back).  The note the bot posts about it tells the clients the channel changed.
+func (a *Automations) OnMessageChanged(bot *Bot, channelname string, messageID uint64) {
+	if a.pinReactions <= 0 || reactions.Count(bot.Model(), channelname, messageID) < a.pinReactions {
+		return
+	}
+
+	if pins.IsPinned(bot.Model(), channelname, messageID) {
+		return
+	}
+
+	message, ok := bot.Model().GetMessage(channelname, bot.Username(), messageID)
+	if !ok || message.Deleted {
+		return
+	}
+
+	err := pins.Pin(bot.Model(), nil, channelname, messageID, true)
+	if err != nil {
+		log.Println("automations: pinning message", messageID, "in", channelname+":", err)
+		return
+	}
+
+	bot.Post(channelname, message.DisplayAuthor()+"'s message was pinned ("+strconv.Itoa(a.pinReactions)+" reactions)")
+}
+
+// move reposts a message in another channel and deletes the original.  Messages already in the
+// channel stay put, and the original is only deleted once it's been reposted.
+func (a *Automations) move(bot *Bot, channelname string, message model.Message, targetChannelname string) {
+	if channelname == targetChannelname {
+		return
+	}
+
+	_, err := bot.Model().PostMessage(targetChannelname, bot.Username(), time.Time{}, message.DisplayAuthor()+" (moved from "+channelname+"): "+message.Text)
+	if err != nil {
+		log.Println("automations: moving message", message.ID, "from", channelname, "to", targetChannelname+":", err)
+		return
+	}
+
+	err = bot.Model().ModerateMessage(channelname, message.ID)
+	if err != nil {
+		log.Println("automations: deleting moved message", message.ID, "in", channelname+":", err)
+		return
+	}
+
+	bot.Post(channelname, message.DisplayAuthor()+"'s message was moved to "+targetChannelname)
+}
+
+// isEmojiOnly returns whether a message is just emoji (symbols, along with the modifiers and
+// joiners that combine them, or :shortcodes:), ignoring spaces.
+func isEmojiOnly(text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return false
+	}
+
+	for _, field := range fields {
+		if len(field) > 2 && strings.HasPrefix(field, ":") && strings.HasSuffix(field, ":") && !strings.ContainsAny(field[1:len(field)-1], ": ") {
+			continue
+		}
+
+		for _, r := range field {
+			skinTone := r >= 0x1f3fb && r <= 0x1f3ff
+			if !unicode.Is(unicode.So, r) && !skinTone && r != 0x200d && r != 0xfe0f {
+				return false
+			}
+		}
+	}
+
+	return true
+}
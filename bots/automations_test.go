@@ -0,0 +1,113 @@
+package bots_test
+
+import (
+	"chatserver/bots"
+	"chatserver/model"
+	"chatserver/pins"
+	"chatserver/reactions"
+	"testing"
+	"time"
+)
+
+func TestAutomationsMove(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+	testModel.CreateChannel("emoji")
+	testModel.CreateUser("user1")
+
+	automations := bots.NewAutomations(map[string]string{"Deploy": "channel2"}, "emoji", 0)
+	bot := bots.NewBot(testModel, "automation", automations)
+
+	original, _ := testModel.PostMessage("channel1", "user1", time.Now(), "deploy now!")
+	bot.OnChannelChanged("channel1")
+
+	// Ensure that a copy is posted to the other channel, with its author
+	moved := testModel.GetChannelHistory("channel2", "user1", -1)
+	if len(moved) != 1 || moved[0].Username != "automation" || moved[0].Text != "user1 (moved from channel1): deploy now!" {
+		t.Error("Failed to post the moved message")
+	}
+
+	// Ensure that the original is deleted, with a note saying where it went
+	message, ok := testModel.GetMessage("channel1", "user1", original.ID)
+	if !ok || !message.Deleted {
+		t.Error("Failed to delete the moved message")
+	}
+
+	messages := testModel.GetChannelHistory("channel1", "user1", 1)
+	if len(messages) != 1 || messages[0].Text != "user1's message was moved to channel2" {
+		t.Error("Failed to note where the message went")
+	}
+
+	// Ensure that the bot's own messages are never moved (e.g. its copies and notes)
+	bot.Post("channel1", "deploy from the bot")
+	bot.OnChannelChanged("channel1")
+	messages = testModel.GetChannelHistory("channel1", "user1", 1)
+	if len(messages) != 1 || messages[0].Deleted || messages[0].Text != "deploy from the bot" {
+		t.Error("Moved the bot's own message")
+	}
+
+	if len(testModel.GetChannelHistory("channel2", "user1", -1)) != 1 {
+		t.Error("Moved the bot's own message")
+	}
+
+	// Ensure that emoji-only messages are moved, and the other messages stay put
+	testModel.PostMessage("channel1", "user1", time.Now(), "🎉 :tada:")
+	testModel.PostMessage("channel1", "user1", time.Now(), "deployment done 🎉")
+	bot.OnChannelChanged("channel1")
+	if len(testModel.GetChannelHistory("emoji", "user1", -1)) != 1 {
+		t.Error("Failed to move the emoji-only message")
+	}
+
+	// (the note about the moved message comes after it)
+	messages = testModel.GetChannelHistory("channel1", "user1", 2)
+	if len(messages) != 2 || messages[0].Deleted || messages[0].Text != "deployment done 🎉" {
+		t.Error("Moved a message without a rule")
+	}
+}
+
+func TestAutomationsPin(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+
+	automations := bots.NewAutomations(map[string]string{}, "", 2)
+	bot := bots.NewBot(testModel, "automation", automations)
+
+	message, _ := testModel.PostMessage("channel1", "user1", time.Now(), "message1")
+	bot.OnChannelChanged("channel1")
+
+	// Ensure that the message isn't pinned until it has enough reactions
+	reactions.React(testModel, nil, "user1", "channel1", message.ID, "👍", true)
+	bot.OnMessageChanged("channel1", message.ID)
+	if pins.IsPinned(testModel, "channel1", message.ID) {
+		t.Error("Pinned a message without enough reactions")
+	}
+
+	reactions.React(testModel, nil, "user2", "channel1", message.ID, "🎉", true)
+	bot.OnMessageChanged("channel1", message.ID)
+	if !pins.IsPinned(testModel, "channel1", message.ID) {
+		t.Error("Failed to pin the message")
+	}
+
+	messages := testModel.GetChannelHistory("channel1", "user1", 1)
+	if len(messages) != 1 || messages[0].Text != "user1's message was pinned (2 reactions)" {
+		t.Error("Failed to note the pinned message")
+	}
+
+	// Ensure that the message is only pinned (and noted) once
+	reactions.React(testModel, nil, "user2", "channel1", message.ID, "👍", true)
+	bot.OnMessageChanged("channel1", message.ID)
+	if len(pins.Get(testModel, "channel1")) != 1 || testModel.GetChannelInfo("channel1").NumMessages != 2 {
+		t.Error("Pinned the message again")
+	}
+}
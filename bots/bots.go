@@ -0,0 +1,153 @@
+// Package bots provides built-in bots.  A bot is a user that watches the model (as a
+// subscription engine client) and reacts to the users that are created and the messages that
+// are posted, usually by posting messages of its own.  What a bot does is up to its Handler.
+package bots
+
+import (
+	"chatserver/model"
+	"sync"
+	"time"
+)
+
+// Handler provides an interface for bots to fulfill in order to react to the model.
+type Handler interface {
+	// OnUserCreated is called when a (non-virtual) user is created.
+	OnUserCreated(bot *Bot, username string)
+
+	// OnMessage is called for each message posted to a channel (other than the bot's own).
+	OnMessage(bot *Bot, channelname string, message model.Message)
+}
+
+// MessageChangeHandler may be implemented by handlers that also react to changes to the messages
+// posted to a channel (edits, deletions and reactions).
+type MessageChangeHandler interface {
+	Handler
+
+	// OnMessageChanged is called when a message in a channel changes.
+	OnMessageChanged(bot *Bot, channelname string, messageID uint64)
+}
+
+// Bot runs a Handler as a user of the model.  It satisfies the subs Client interface.
+type Bot struct {
+	model    *model.Model
+	username string
+	handler  Handler
+	users    map[string]struct{}
+	lastSeqs map[string]uint64
+	mutex    sync.Mutex
+}
+
+// NewBot creates/initializes/returns a new Bot, creating its user if needed.  Only users created
+// and messages posted after this are passed to the handler.
+func NewBot(model *model.Model, username string, handler Handler) *Bot {
+	model.CreateUser(username)
+
+	bot := Bot{
+		model:    model,
+		username: username,
+		handler:  handler,
+		users:    model.GetUsers(),
+		lastSeqs: make(map[string]uint64),
+	}
+
+	for channelname := range model.GetChannels() {
+		bot.lastSeqs[channelname] = model.GetChannelInfo(channelname).LastSeq
+	}
+
+	return &bot
+}
+
+// Username returns the bot's username.
+func (b *Bot) Username() string {
+	return b.username
+}
+
+// Model returns the model the bot is a user of.
+func (b *Bot) Model() *model.Model {
+	return b.model
+}
+
+// Post posts a message by the bot to a channel.
+func (b *Bot) Post(channelname string, text string) {
+	b.model.PostMessage(channelname, b.username, time.Time{}, text)
+}
+
+// OnUsersChanged is called whenever the users state changes in the model.  It passes any new
+// users to the handler.
+func (b *Bot) OnUsersChanged() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	users := b.model.GetUsers()
+	for username := range users {
+		if _, ok := b.users[username]; ok {
+			continue
+		}
+
+		// Virtual users belong to bridges, so they aren't really new to the chat
+		if b.model.GetUserInfo(username).Owner == "" {
+			b.handler.OnUserCreated(b, username)
+		}
+	}
+
+	b.users = users
+}
+
+// OnUserChanged is called whenever a particular user's state changes in the model.
+func (b *Bot) OnUserChanged(username string) {
+}
+
+// OnChannelsChanged is called whenever the channels state changes in the model.  It starts
+// watching new channels.
+func (b *Bot) OnChannelsChanged() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	channels := b.model.GetChannels()
+	for channelname := range channels {
+		if _, ok := b.lastSeqs[channelname]; !ok {
+			b.lastSeqs[channelname] = b.model.GetChannelInfo(channelname).LastSeq
+		}
+	}
+
+	for channelname := range b.lastSeqs {
+		if _, ok := channels[channelname]; !ok {
+			delete(b.lastSeqs, channelname)
+		}
+	}
+}
+
+// OnChannelChanged is called whenever a particular channel's state changes in the model.  It
+// passes any new messages to the handler.
+func (b *Bot) OnChannelChanged(channelname string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	lastSeq := b.model.GetChannelInfo(channelname).LastSeq
+	if lastSeq <= b.lastSeqs[channelname] {
+		return
+	}
+
+	messages := b.model.GetChannelHistoryRange(channelname, b.username, b.lastSeqs[channelname]+1, lastSeq)
+	b.lastSeqs[channelname] = lastSeq
+
+	for _, message := range messages {
+		if message.Username != b.username && !message.Deleted {
+			b.handler.OnMessage(b, channelname, message)
+		}
+	}
+}
+
+// OnMessageChanged is called whenever a message in a channel is edited, deleted or reacted to.  It
+// passes the change to the handler if it handles them (see MessageChangeHandler).
+func (b *Bot) OnMessageChanged(channelname string, messageID uint64) {
+	messageChangeHandler, ok := b.handler.(MessageChangeHandler)
+	if !ok {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	messageChangeHandler.OnMessageChanged(b, channelname, messageID)
+}
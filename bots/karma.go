@@ -0,0 +1,84 @@
+package bots
+
+import (
+	"chatserver/model"
+	"strconv"
+	"strings"
+)
+
+// KarmaNamespace is the plugin data namespace the karma scores are stored in (keyed by username).
+const KarmaNamespace string = "karma"
+
+// Karma is a bot handler that keeps karma scores.  Posting "username++" gives a user a point
+// (users can't give themselves points), and posting "/karma [username]" shows a user's score
+// (the poster's by default).  The scores are kept in the model's plugin data, so they persist.
+type Karma struct {
+}
+
+// NewKarma creates/initializes/returns a new Karma handler.
+func NewKarma() *Karma {
+	karma := Karma{}
+
+	return &karma
+}
+
+// KarmaScore returns a user's karma score.
+func KarmaScore(model *model.Model, username string) int {
+	value, ok := model.PluginStore(KarmaNamespace).Get(username)
+	if !ok {
+		return 0
+	}
+
+	score, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+
+	return score
+}
+
+// OnUserCreated has no effect on karma.
+func (k *Karma) OnUserCreated(bot *Bot, username string) {
+}
+
+// OnMessage answers /karma and gives a point for each "username++" in a message.
+func (k *Karma) OnMessage(bot *Bot, channelname string, message model.Message) {
+	fields := strings.Fields(message.Text)
+	if len(fields) == 0 {
+		return
+	}
+
+	if fields[0] == "/karma" {
+		username := message.Username
+		if len(fields) > 1 {
+			username = fields[1]
+		}
+
+		bot.Post(channelname, username+" has "+strconv.Itoa(KarmaScore(bot.Model(), username))+" karma")
+		return
+	}
+
+	users := bot.Model().GetUsers()
+	given := make(map[string]struct{})
+	for _, field := range fields {
+		word := strings.TrimRight(field, ".,!?;:")
+		if !strings.HasSuffix(word, "++") {
+			continue
+		}
+		username := strings.TrimSuffix(word, "++")
+
+		// Only existing users can get points, at most once per message, and never from themselves
+		if _, ok := users[username]; !ok || username == message.Username {
+			continue
+		}
+
+		if _, ok := given[username]; ok {
+			continue
+		}
+		given[username] = struct{}{}
+
+		score := KarmaScore(bot.Model(), username) + 1
+		bot.Model().PluginStore(KarmaNamespace).Put(username, strconv.Itoa(score))
+		bot.Post(channelname, username+" now has "+strconv.Itoa(score)+" karma")
+	}
+}
@@ -0,0 +1,70 @@
+package bots
+
+import (
+	"chatserver/model"
+	"log"
+	"sort"
+	"time"
+)
+
+// Mirror is a bot handler that mirrors channels: each message posted to a followed channel is
+// posted again to the channels following it, attributed to its author via the followed channel
+// (e.g. "User1 via #announcements").  The mirrors are meant to be read-only for everyone but the
+// bot (see the model's ReadOnlyChannels).  A mirror can be followed in turn, and the messages are
+// mirrored along the chain, but never back to a channel they've already been posted to, so
+// mirrors following each other can't loop.  Snippets are mirrored as plain text, and edits and
+// deletions aren't mirrored.
+type Mirror struct {
+	followers map[string][]string
+}
+
+// NewMirror creates/initializes/returns a new Mirror handler.  Mirrors map the mirror channels to
+// the channels they follow.
+func NewMirror(mirrors map[string]string) *Mirror {
+	mirror := Mirror{
+		followers: make(map[string][]string),
+	}
+
+	for mirrorChannelname, channelname := range mirrors {
+		mirror.followers[channelname] = append(mirror.followers[channelname], mirrorChannelname)
+	}
+
+	// Mirror to the followers in a fixed order
+	for _, followers := range mirror.followers {
+		sort.Strings(followers)
+	}
+
+	return &mirror
+}
+
+// OnUserCreated does nothing, only messages are mirrored.
+func (m *Mirror) OnUserCreated(bot *Bot, username string) {
+}
+
+// OnMessage posts the message to the channels following its channel (directly or along a chain of
+// mirrors).  The bot's own messages never get here, so the copies aren't mirrored again.
+func (m *Mirror) OnMessage(bot *Bot, channelname string, message model.Message) {
+	author := message.Origin.Author
+	if author == "" {
+		author = message.Username
+	}
+
+	posted := map[string]struct{}{channelname: {}}
+	pending := append([]string(nil), m.followers[channelname]...)
+	for len(pending) > 0 {
+		mirrorChannelname := pending[0]
+		pending = pending[1:]
+		if _, ok := posted[mirrorChannelname]; ok {
+			continue
+		}
+		posted[mirrorChannelname] = struct{}{}
+
+		_, err := bot.Model().PostBridgedMessage(mirrorChannelname, bot.Username(), time.Time{}, message.Text, "#"+channelname, author)
+		if err != nil {
+			log.Println("mirror: posting message", message.ID, "from", channelname, "to", mirrorChannelname+":", err)
+			continue
+		}
+
+		pending = append(pending, m.followers[mirrorChannelname]...)
+	}
+}
@@ -0,0 +1,246 @@
+package bots
+
+import (
+	"bufio"
+	"chatserver/model"
+	"encoding/json"
+	"errors"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ProcessProtocol is the version of the protocol spoken with plugin processes.
+const ProcessProtocol int = 1
+
+// maxPendingProcessEvents bounds the events buffered for a plugin process.
+const maxPendingProcessEvents int = 1000
+
+// processRestartDelay is how long to wait before restarting a plugin process that exited.
+const processRestartDelay time.Duration = 5 * time.Second
+
+// ProcessEvent is written to a plugin process, one JSON object per line on its stdin.  The types
+// are "hello" (the first event, with the protocol version, the bot's username and the plugin data
+// namespace), "user_created", "message" and "data" (the answer to a "get_data" command).
+type ProcessEvent struct {
+	Type        string `json:"type"`
+	Protocol    int    `json:"protocol,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Channelname string `json:"channel,omitempty"`
+	ID          uint64 `json:"id,omitempty"`
+	Seq         uint64 `json:"seq,omitempty"`
+	Timestamp   string `json:"timestamp,omitempty"`
+	Text        string `json:"text,omitempty"`
+	Key         string `json:"key,omitempty"`
+	Value       string `json:"value,omitempty"`
+	Found       bool   `json:"found,omitempty"`
+}
+
+// ProcessCommand is read from a plugin process, one JSON object per line on its stdout.  The
+// types are "hello" (the answer to the hello event, with the protocol version the plugin speaks),
+// "post" (a message by the bot), "put_data" (an empty value deletes the key) and "get_data".
+type ProcessCommand struct {
+	Type        string `json:"type"`
+	Protocol    int    `json:"protocol"`
+	Channelname string `json:"channel"`
+	Text        string `json:"text"`
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+}
+
+// Process is a bot handler that runs a plugin as an external process, so plugins can be written
+// in any language and a plugin crashing doesn't take the server down (it's restarted instead).
+// The plugin's data is kept in the plugin data namespace named after the bot.
+type Process struct {
+	command []string
+	events  chan ProcessEvent
+	mutex   sync.Mutex
+	running bool
+}
+
+// NewProcess creates/initializes/returns a new Process handler for a command line (the
+// executable followed by its arguments).  The process is started by Run.
+func NewProcess(command []string) *Process {
+	process := Process{
+		command: command,
+		events:  make(chan ProcessEvent, maxPendingProcessEvents),
+	}
+
+	return &process
+}
+
+// Run starts the plugin process for a bot (whose handler the Process is), restarting it whenever
+// it exits.
+func (p *Process) Run(bot *Bot) {
+	go func() {
+		for {
+			err := p.runOnce(bot)
+			log.Println("plugin "+bot.Username()+":", err)
+			time.Sleep(processRestartDelay)
+		}
+	}()
+}
+
+// OnUserCreated passes the new user to the plugin.
+func (p *Process) OnUserCreated(bot *Bot, username string) {
+	p.send(ProcessEvent{Type: "user_created", Username: username})
+}
+
+// OnMessage passes the message to the plugin.
+func (p *Process) OnMessage(bot *Bot, channelname string, message model.Message) {
+	p.send(ProcessEvent{
+		Type:        "message",
+		Username:    message.Username,
+		Channelname: channelname,
+		ID:          message.ID,
+		Seq:         message.Seq,
+		Timestamp:   message.Timestamp.Format(time.RFC3339),
+		Text:        message.Text,
+	})
+}
+
+// send queues an event for the plugin, dropping it if the plugin isn't running or can't keep up.
+func (p *Process) send(event ProcessEvent) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.running {
+		return
+	}
+
+	select {
+	case p.events <- event:
+	default:
+	}
+}
+
+func (p *Process) setRunning(running bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.running = running
+
+	// Events queued for a previous run of the plugin are stale
+	for len(p.events) > 0 {
+		<-p.events
+	}
+}
+
+// runOnce runs the plugin process until it exits (or breaks the protocol).
+func (p *Process) runOnce(bot *Bot) error {
+	if len(p.command) == 0 {
+		return errors.New("missing command")
+	}
+
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	// Pass on what the plugin logs
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Println("plugin "+bot.Username()+":", scanner.Text())
+		}
+	}()
+
+	done := make(chan struct{})
+	defer func() {
+		p.setRunning(false)
+		close(done)
+		stdin.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	// Agree on the protocol version
+	encoder := json.NewEncoder(stdin)
+	scanner := bufio.NewScanner(stdout)
+	err = encoder.Encode(ProcessEvent{Type: "hello", Protocol: ProcessProtocol, Username: bot.Username(), Namespace: bot.Username()})
+	if err != nil {
+		return err
+	}
+
+	command, err := readCommand(scanner)
+	if err != nil {
+		return err
+	}
+
+	if command.Type != "hello" || command.Protocol != ProcessProtocol {
+		return errors.New("unsupported protocol")
+	}
+
+	p.setRunning(true)
+
+	// Write the events as they come in
+	go func() {
+		for {
+			select {
+			case event := <-p.events:
+				if encoder.Encode(event) != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Carry out the commands
+	store := bot.Model().PluginStore(bot.Username())
+	for {
+		command, err := readCommand(scanner)
+		if err != nil {
+			return err
+		}
+
+		switch command.Type {
+		case "post":
+			bot.Post(command.Channelname, command.Text)
+		case "put_data":
+			store.Put(command.Key, command.Value)
+		case "get_data":
+			value, found := store.Get(command.Key)
+			p.send(ProcessEvent{Type: "data", Key: command.Key, Value: value, Found: found})
+		default:
+			return errors.New("unknown command " + command.Type)
+		}
+	}
+}
+
+func readCommand(scanner *bufio.Scanner) (ProcessCommand, error) {
+	if !scanner.Scan() {
+		if scanner.Err() != nil {
+			return ProcessCommand{}, scanner.Err()
+		}
+
+		return ProcessCommand{}, errors.New("process exited")
+	}
+
+	command := ProcessCommand{}
+	err := json.Unmarshal(scanner.Bytes(), &command)
+	if err != nil {
+		return ProcessCommand{}, errors.New("invalid command")
+	}
+
+	return command, nil
+}
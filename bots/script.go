@@ -0,0 +1,179 @@
+package bots
+
+import (
+	"chatserver/model"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib"
+)
+
+// scriptTimeout bounds how long a script can run for a single event.
+const scriptTimeout time.Duration = time.Second
+
+// Script is a bot handler that runs a Tengo script (https://github.com/d5/tengo) for each event.
+// The script sees the event ("on_message" or "on_user_create") in the variable event, along with
+// channel, user and message, and can call post(channel, text), get_data(key) and
+// put_data(key, value) (kept in the plugin data namespace named after the bot).  The script file
+// is reloaded whenever it changes.
+type Script struct {
+	path     string
+	modTime  time.Time
+	compiled *tengo.Compiled
+	mutex    sync.Mutex
+}
+
+// NewScript creates/initializes/returns a new Script handler for a script file.
+func NewScript(path string) *Script {
+	script := Script{
+		path: path,
+	}
+
+	return &script
+}
+
+// OnUserCreated runs the script for the new user.
+func (s *Script) OnUserCreated(bot *Bot, username string) {
+	s.run(bot, "on_user_create", "", username, "")
+}
+
+// OnMessage runs the script for the message.
+func (s *Script) OnMessage(bot *Bot, channelname string, message model.Message) {
+	s.run(bot, "on_message", channelname, message.Username, message.Text)
+}
+
+func (s *Script) run(bot *Bot, event string, channelname string, username string, text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.load(bot)
+	if err != nil {
+		log.Println("script "+s.path+":", err)
+		return
+	}
+
+	// A script that failed to compile is skipped until it's fixed
+	if s.compiled == nil {
+		return
+	}
+
+	compiled := s.compiled.Clone()
+	for name, value := range map[string]string{"event": event, "channel": channelname, "user": username, "message": text} {
+		err := compiled.Set(name, value)
+		if err != nil {
+			log.Println("script "+s.path+":", err)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+
+	err = compiled.RunContext(ctx)
+	if err != nil {
+		log.Println("script "+s.path+":", err)
+	}
+}
+
+// load (re)compiles the script if the file changed since it was last loaded.
+func (s *Script) load(bot *Bot) error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	s.modTime = info.ModTime()
+	s.compiled = nil
+
+	source, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	// Scripts only get the standard library modules that don't reach outside the script
+	script := tengo.NewScript(source)
+	script.SetImports(stdlib.GetModuleMap("math", "text", "times", "rand", "json", "enum"))
+	for _, name := range []string{"event", "channel", "user", "message"} {
+		err := script.Add(name, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	store := bot.Model().PluginStore(bot.Username())
+	functions := map[string]tengo.CallableFunc{
+		"post": func(args ...tengo.Object) (tengo.Object, error) {
+			strs, err := stringArgs(args, 2)
+			if err != nil {
+				return nil, err
+			}
+
+			bot.Post(strs[0], strs[1])
+			return tengo.UndefinedValue, nil
+		},
+		"get_data": func(args ...tengo.Object) (tengo.Object, error) {
+			strs, err := stringArgs(args, 1)
+			if err != nil {
+				return nil, err
+			}
+
+			value, ok := store.Get(strs[0])
+			if !ok {
+				return tengo.UndefinedValue, nil
+			}
+
+			return &tengo.String{Value: value}, nil
+		},
+		"put_data": func(args ...tengo.Object) (tengo.Object, error) {
+			strs, err := stringArgs(args, 2)
+			if err != nil {
+				return nil, err
+			}
+
+			store.Put(strs[0], strs[1])
+			return tengo.UndefinedValue, nil
+		},
+	}
+
+	for name, function := range functions {
+		err := script.Add(name, &tengo.UserFunction{Name: name, Value: function})
+		if err != nil {
+			return err
+		}
+	}
+
+	compiled, err := script.Compile()
+	if err != nil {
+		return err
+	}
+	s.compiled = compiled
+
+	log.Println("script " + s.path + ": loaded")
+	return nil
+}
+
+// stringArgs converts the arguments of a script function call to strings.
+func stringArgs(args []tengo.Object, numArgs int) ([]string, error) {
+	if len(args) != numArgs {
+		return nil, tengo.ErrWrongNumArguments
+	}
+
+	strs := make([]string, 0)
+	for _, arg := range args {
+		str, ok := tengo.ToString(arg)
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{Name: "argument", Expected: "string", Found: arg.TypeName()}
+		}
+		strs = append(strs, str)
+	}
+
+	return strs, nil
+}
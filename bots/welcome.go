@@ -0,0 +1,73 @@
+package bots
+
+import (
+	"chatserver/model"
+	"sort"
+	"strings"
+)
+
+// Welcome is a bot handler that greets new users, answers /help in the channel it's asked in,
+// and responds to keyword triggers with canned responses.
+type Welcome struct {
+	channelname string
+	greeting    string
+	help        string
+	triggers    map[string]string
+	keywords    []string
+}
+
+// NewWelcome creates/initializes/returns a new Welcome handler.  New users are greeted in the
+// channel with the greeting ("{user}" is replaced by their name).  Triggers map keywords
+// (matched against the words of a message, ignoring case) to responses.  An empty greeting or
+// help disables it.
+func NewWelcome(channelname string, greeting string, help string, triggers map[string]string) *Welcome {
+	welcome := Welcome{
+		channelname: channelname,
+		greeting:    greeting,
+		help:        help,
+		triggers:    make(map[string]string),
+		keywords:    make([]string, 0),
+	}
+
+	for keyword, response := range triggers {
+		welcome.triggers[strings.ToLower(keyword)] = response
+		welcome.keywords = append(welcome.keywords, strings.ToLower(keyword))
+	}
+
+	// Check the keywords in a fixed order, so the same message always gets the same response
+	sort.Strings(welcome.keywords)
+
+	return &welcome
+}
+
+// OnUserCreated greets a new user.
+func (w *Welcome) OnUserCreated(bot *Bot, username string) {
+	if w.greeting == "" {
+		return
+	}
+
+	bot.Post(w.channelname, strings.Replace(w.greeting, "{user}", username, -1))
+}
+
+// OnMessage answers /help and keyword triggers (only the first keyword found is responded to).
+func (w *Welcome) OnMessage(bot *Bot, channelname string, message model.Message) {
+	text := strings.TrimSpace(message.Text)
+	if text == "/help" {
+		if w.help != "" {
+			bot.Post(channelname, w.help)
+		}
+		return
+	}
+
+	words := make(map[string]struct{})
+	for _, field := range strings.Fields(strings.ToLower(text)) {
+		words[strings.Trim(field, ".,!?;:\"'()")] = struct{}{}
+	}
+
+	for _, keyword := range w.keywords {
+		if _, ok := words[keyword]; ok {
+			bot.Post(channelname, w.triggers[keyword])
+			return
+		}
+	}
+}
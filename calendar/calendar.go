@@ -0,0 +1,56 @@
+// Package calendar posts the reminders for the events on the channels' calendars (see
+// model.CreateEvent).  Shortly before an event starts, the built-in user posts a reminder to its
+// channel naming the users going, and the event is marked reminded in the model (and so in the
+// log), so a reminder is only ever posted once.
+package calendar
+
+import (
+	"chatserver/model"
+	"log"
+	"strings"
+	"time"
+)
+
+// DefaultLead is how long before an event starts its reminder is posted by default.
+const DefaultLead time.Duration = 15 * time.Minute
+
+// CheckInterval is how often the events are checked for reminders that are due.
+const CheckInterval time.Duration = time.Minute
+
+// Remind posts the reminders for the events starting within lead of the model's current time.
+// Events that started without being reminded (e.g. while the server was down) are marked reminded
+// without posting one, as it would be too late.
+func Remind(m *model.Model, lead time.Duration) {
+	now := m.Now()
+	for channelname := range m.GetChannels() {
+		for _, event := range m.GetEvents(channelname) {
+			if event.Reminded || event.Start.After(now.Add(lead)) {
+				continue
+			}
+
+			// Mark it first, so a failure part way through can't post it twice
+			if err := m.MarkEventReminded(event.ID); err != nil {
+				continue
+			}
+
+			if event.Start.Before(now) {
+				continue
+			}
+
+			_, err := m.PostMessage(channelname, m.BuiltinUsername(), time.Time{}, Reminder(event))
+			if err != nil {
+				log.Println("calendar: failed to post reminder:", err)
+			}
+		}
+	}
+}
+
+// Reminder returns the text of the reminder posted for an event.
+func Reminder(event model.CalendarEvent) string {
+	text := "[calendar] '" + event.Title + "' starts at " + event.Start.Local().Format("15:04")
+	if len(event.Going) > 0 {
+		text += " (going: " + strings.Join(event.Going, ", ") + ")"
+	}
+
+	return text
+}
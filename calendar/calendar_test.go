@@ -0,0 +1,58 @@
+package calendar_test
+
+import (
+	"chatserver/calendar"
+	"chatserver/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemind(t *testing.T) {
+	now := time.Date(2030, 1, 2, 15, 0, 0, 0, time.UTC)
+	testModel, err := model.NewModel(model.Options{Clock: func() time.Time { return now }}, nil, nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	startedID, _ := testModel.CreateEvent("General", "user1", now.Add(-time.Minute), "Started")
+	soonID, _ := testModel.CreateEvent("General", "user1", now.Add(10*time.Minute), "Soon")
+	laterID, _ := testModel.CreateEvent("General", "user1", now.Add(time.Hour), "Later")
+	testModel.RSVPEvent(soonID, "user1", true)
+
+	calendar.Remind(testModel, calendar.DefaultLead)
+
+	// Only the event starting within the lead is reminded, while the one that already started is
+	// marked without a reminder
+	reminded := make(map[uint64]bool)
+	for _, event := range testModel.GetEvents("General") {
+		reminded[event.ID] = event.Reminded
+	}
+	if !reminded[startedID] || !reminded[soonID] || reminded[laterID] {
+		t.Error("Incorrect events reminded", reminded)
+	}
+
+	reminders := postedReminders(testModel)
+	if len(reminders) != 1 || !strings.Contains(reminders[0], "'Soon'") || !strings.Contains(reminders[0], "going: user1") {
+		t.Error("Incorrect reminders posted", reminders)
+	}
+
+	// A reminder is only posted once
+	calendar.Remind(testModel, calendar.DefaultLead)
+	if len(postedReminders(testModel)) != 1 {
+		t.Error("Reminder posted again")
+	}
+}
+
+// postedReminders returns the reminders posted to the built-in channel.
+func postedReminders(testModel *model.Model) []string {
+	reminders := make([]string, 0)
+	for _, message := range testModel.GetChannelHistory(testModel.BuiltinChannelname(), testModel.BuiltinUsername(), -1) {
+		if strings.HasPrefix(message.Text, "[calendar]") {
+			reminders = append(reminders, message.Text)
+		}
+	}
+
+	return reminders
+}
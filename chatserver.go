@@ -1,25 +1,50 @@
 package main
 
 import (
+	"chatserver/adminapi"
+	"chatserver/archive"
+	"chatserver/attachments"
+	"chatserver/bootstrap"
+	"chatserver/bots"
+	"chatserver/calendar"
+	"chatserver/clienterrors"
 	"chatserver/config"
+	"chatserver/credentials"
+	"chatserver/diskguard"
+	"chatserver/events"
+	"chatserver/listeners"
 	"chatserver/model"
 	"chatserver/model/actions"
 	"chatserver/model/subs"
+	"chatserver/projections"
+	"chatserver/security"
+	"chatserver/sessions"
+	"chatserver/snapshots"
+	"chatserver/storage"
 	"chatserver/telnetapi"
+	"chatserver/tracing"
 	"chatserver/webapi"
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"net/rpc"
 	"os"
+	"os/signal"
+	"runtime/debug"
 	"strconv"
-
-	gotelnet "github.com/reiver/go-telnet"
+	"syscall"
+	"time"
 )
 
+// maxClientErrors is the number of errors reported by clients that are kept for admins.
+const maxClientErrors int = 200
+
 func main() {
 	// All configuration options are contained in the config file
 	configFilePath := flag.String("c", "", "config file path")
+	exportFilePath := flag.String("export", "", "export the server state to an archive file and exit")
+	importFilePath := flag.String("import", "", "import an archive file into the server state and exit")
 	flag.Parse()
 
 	// The config file path is required
@@ -37,14 +62,105 @@ func main() {
 	// Print the parsed config
 	log.Println("Welcome to chatserver!")
 	log.Println("----------------------")
-	log.Println("Serving telnet on port", config.TelnetPort)
-	log.Println("Serving web client on port", config.WebPort)
+	log.Println("Version:", serverVersion())
+	log.Println("Serving telnet on address", config.TelnetAddress, "port", config.TelnetPort)
+	log.Println("Serving web client on address", config.WebAddress, "port", config.WebPort)
+	log.Println("Admin socket path:", config.AdminSocketPath)
+	log.Println("Admin address:", config.AdminAddress)
+	log.Println("Admin port:", config.AdminPort)
 	log.Println("Web client path:", config.WebClientPath)
 	log.Println("Log file path:", config.LogFilePath)
+	log.Println("Log sync:", config.LogSync)
+	log.Println("Log sync interval (ms):", config.LogSyncMillis)
+	log.Println("Built-in username:", config.BuiltinUsername)
+	log.Println("Built-in channelname:", config.BuiltinChannelname)
+	log.Println("Default channels:", config.DefaultChannels)
+	log.Println("Protected users:", config.ProtectedUsers)
+	log.Println("Protected channels:", config.ProtectedChannels)
+	log.Println("Bootstrap file path:", config.BootstrapFilePath)
+	log.Println("Reconcile file path:", config.ReconcileFilePath)
+	log.Println("Reconcile interval:", config.ReconcileInterval)
+	log.Println("Reconcile prune:", config.ReconcilePrune)
+	log.Println("Tracing endpoint:", config.TracingEndpoint)
+	log.Println("Events sink:", config.EventsSink)
+	log.Println("Events target:", config.EventsTarget)
+	log.Println("Events subject:", config.EventsSubject)
+	log.Println("Language word lists:", len(config.LanguageWordLists))
+	log.Println("Read replicas:", config.ReadReplicas)
+	log.Println("Message search:", config.MessageSearch)
+	log.Println("Telnet output queue (bytes):", config.TelnetOutputQueueBytes)
+	log.Println("Telnet overflow:", config.TelnetOverflow)
+	log.Println("Telnet flush interval (ms):", config.TelnetFlushMillis)
+	log.Println("Session timeout:", config.SessionTimeout)
+	log.Println("Welcome bot username:", config.WelcomeBotUsername)
+	log.Println("Welcome bot triggers:", len(config.WelcomeBotTriggers))
+	log.Println("Karma bot username:", config.KarmaBotUsername)
+	log.Println("Automation bot username:", config.AutomationUsername)
+	log.Println("Automation moves:", len(config.AutomationMoves))
+	log.Println("Automation pin reactions:", config.AutomationPinReactions)
+	log.Println("Mirror bot username:", config.MirrorUsername)
+	log.Println("Mirror channels:", config.MirrorChannels)
+	log.Println("Plugin bots:", len(config.PluginBots))
+	log.Println("Script bots:", len(config.ScriptBots))
+	log.Println("Credential store:", config.CredentialStore)
+	log.Println("Disk check interval:", config.DiskCheckInterval)
+	log.Println("Disk alert/compact/read-only free (MB):", config.DiskAlertFreeMB, config.DiskCompactFreeMB, config.DiskReadOnlyFreeMB)
+	log.Println("Log alert/compact size (MB):", config.LogAlertSizeMB, config.LogCompactSizeMB)
+	log.Println("Alert webhook URL:", config.AlertWebhookURL)
+	log.Println("Alert channelname:", config.AlertChannelname)
+	log.Println("Storage backend:", config.StorageBackend)
+	log.Println("Storage directory:", config.StorageDirectory)
+	log.Println("Storage endpoint:", config.StorageEndpoint)
+	log.Println("Storage bucket:", config.StorageBucket)
+	log.Println("Storage prefix:", config.StoragePrefix)
+	log.Println("Snapshot interval:", config.SnapshotInterval)
+	log.Println("Attachment max (MB):", config.AttachmentMaxMB)
+	log.Println("Thumbnail sizes:", config.ThumbnailSizes)
+	log.Println("Event reminder (mins):", config.EventReminderMins)
+	log.Println("Duplicate window:", config.DuplicateWindow)
+	log.Println("Duplicate posts:", config.DuplicatePosts)
+
+	// Create the tracer if tracing is enabled (a nil tracer records nothing)
+	var tracer *tracing.Tracer
+	if config.TracingEndpoint != "" {
+		tracer = tracing.NewTracer(config.TracingEndpoint, "chatserver")
+	}
+
+	// Create the object store if one is configured (for archives, exports, snapshots and attachments)
+	var objectStore storage.Store
+	if config.StorageBackend != "" {
+		storageOptions := storage.Options{
+			Backend:         config.StorageBackend,
+			Directory:       config.StorageDirectory,
+			Endpoint:        config.StorageEndpoint,
+			Region:          config.StorageRegion,
+			Bucket:          config.StorageBucket,
+			Prefix:          config.StoragePrefix,
+			AccessKeyID:     config.StorageAccessKeyID,
+			SecretAccessKey: config.StorageSecretKey,
+		}
+		objectStore, err = storage.New(storageOptions)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Start from the latest snapshot when there's no log to replay (e.g. in a new container)
+	if config.SnapshotInterval > 0 {
+		restored, err := snapshots.Restore(objectStore, config.LogFilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if restored {
+			log.Println("Restored snapshot:", snapshots.Key)
+		}
+	}
 
 	// Create the actions Replayer and Logger as needed (determined by the log file path)
 	var actionsReplayer model.ActionsReplayer
 	var actionsLogger actions.Actor
+	var logFileLogger *actions.Logger
 	if config.LogFilePath != "" {
 		// If the file doesn't exist, then don't try to replay it
 		_, err := os.Stat(config.LogFilePath)
@@ -55,42 +171,508 @@ func main() {
 			}
 		}
 
-		actionsLogger, err = actions.NewLogger(config.LogFilePath)
+		logFileLogger, err = actions.NewLogger(config.LogFilePath)
 		if err != nil {
 			log.Fatal(err)
 		}
+		logFileLogger.SetSyncPolicy(config.LogSync, time.Duration(config.LogSyncMillis)*time.Millisecond)
+		actionsLogger = logFileLogger
+
+		if tracer != nil {
+			actionsLogger = tracing.NewActor(tracer, actionsLogger)
+		}
 	}
 
 	// Create/Initialize the model
 	subsEngine := subs.NewEngine()
-	model, err := model.NewModel(actionsReplayer, actionsLogger, subsEngine)
+	modelOptions := model.Options{
+		BuiltinUsername:    config.BuiltinUsername,
+		BuiltinChannelname: config.BuiltinChannelname,
+		DefaultChannels:    config.DefaultChannels,
+		ProtectedUsers:     config.ProtectedUsers,
+		ProtectedChannels:  config.ProtectedChannels,
+		MaxUsers:           config.MaxUsers,
+		MaxChannelsPerUser: config.MaxChannelsPerUser,
+		MaxMessagesPerDay:  config.MaxMessagesPerDay,
+		DuplicateWindow:    time.Duration(config.DuplicateWindow) * time.Second,
+		RejectDuplicates:   config.DuplicatePosts == "reject",
+	}
+
+	// The bots' users can't be deleted out from under them
+	modelOptions.ProtectedUsers = append([]string(nil), config.ProtectedUsers...)
+	for _, botUsername := range []string{config.WelcomeBotUsername, config.KarmaBotUsername, config.AutomationUsername, config.MirrorUsername} {
+		if botUsername != "" {
+			modelOptions.ProtectedUsers = append(modelOptions.ProtectedUsers, botUsername)
+		}
+	}
+	for botUsername := range config.PluginBots {
+		modelOptions.ProtectedUsers = append(modelOptions.ProtectedUsers, botUsername)
+	}
+	for botUsername := range config.ScriptBots {
+		modelOptions.ProtectedUsers = append(modelOptions.ProtectedUsers, botUsername)
+	}
+
+	// Nor can the mirror channels be deleted or renamed, and only the mirror bot posts to them
+	if config.MirrorUsername != "" {
+		modelOptions.ProtectedChannels = append([]string(nil), config.ProtectedChannels...)
+		modelOptions.ReadOnlyChannels = make(map[string]string)
+		for mirrorChannelname := range config.MirrorChannels {
+			modelOptions.ProtectedChannels = append(modelOptions.ProtectedChannels, mirrorChannelname)
+			modelOptions.ReadOnlyChannels[mirrorChannelname] = config.MirrorUsername
+		}
+	}
+
+	// Create the read replicas (caught up from the log, then kept up to date with every action
+	// the model logs, so they don't need the message filters or events, and keep the timestamps
+	// the model assigns)
+	replicaOptions := modelOptions
+	replicaOptions.TrustTimestamps = true
+	replicas := make([]*model.Model, 0, config.ReadReplicas)
+	for i := 0; i < config.ReadReplicas; i++ {
+		replica, err := model.NewModel(replicaOptions, actionsReplayer, nil, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		replicas = append(replicas, replica)
+	}
+
+	// Create the query projections (caught up from the log, then kept up to date from the
+	// actions the model logs on their own goroutine)
+	var searchIndex *projections.SearchIndex
+	var projectionStream *projections.Stream
+	if config.MessageSearch {
+		searchIndex = projections.NewSearchIndex()
+		if actionsReplayer != nil {
+			err := actionsReplayer.Replay(searchIndex)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		projectionStream = projections.NewStream(searchIndex)
+	}
+
+	// Feed the logged actions to the read replicas and projections as well
+	if len(replicas) > 0 || projectionStream != nil {
+		fanoutActors := make([]actions.Actor, 0, len(replicas)+2)
+		if actionsLogger != nil {
+			fanoutActors = append(fanoutActors, actionsLogger)
+		}
+		for _, replica := range replicas {
+			fanoutActors = append(fanoutActors, replica.Actor())
+		}
+		if projectionStream != nil {
+			fanoutActors = append(fanoutActors, projectionStream)
+		}
+		actionsLogger = actions.NewFanout(fanoutActors...)
+	}
+
+	modelOptions.LanguageFilters = make(map[string]model.MessageFilter)
+	for language, words := range config.LanguageWordLists {
+		modelOptions.LanguageFilters[language] = model.NewWordListFilter(words)
+	}
+	if config.EventsSink != "" {
+		eventsSink, err := events.NewSink(config.EventsSink, config.EventsTarget, config.EventsSubject)
+		if err != nil {
+			log.Fatal(err)
+		}
+		modelOptions.Events = events.NewStream(eventsSink, config.EventsSalt)
+	}
+	var modelSubsEngine model.SubsEngine = subsEngine
+	if tracer != nil {
+		modelSubsEngine = tracing.NewSubsEngine(tracer, subsEngine)
+	}
+	model, err := model.NewModel(modelOptions, actionsReplayer, actionsLogger, modelSubsEngine)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Apply the bootstrap file on first start (when there is no existing state to replay)
+	if config.BootstrapFilePath != "" && actionsReplayer == nil {
+		bootstrap, err := bootstrap.ParseFile(config.BootstrapFilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		bootstrap.Apply(model)
+	}
+
+	// Set up the account passwords (if enabled)
+	var credentialStore *credentials.Credentials
+	switch config.CredentialStore {
+	case "log":
+		credentialStore = credentials.New(credentials.NewLogStore(model))
+	case "file":
+		fileStore, err := credentials.NewFileStore(config.CredentialFilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		credentialStore = credentials.New(fileStore)
+	}
+
+	// Tell users about the security events on their accounts
+	if credentialStore != nil {
+		credentialStore.SetNotifier(func(username string, text string) {
+			security.Record(model, subsEngine, username, text)
+		})
+	}
+
+	// Store the uploaded attachments in the object store, if they're enabled
+	var attachmentStore *attachments.Store
+	if config.AttachmentMaxMB > 0 {
+		attachmentStore = attachments.NewStore(objectStore, int64(config.AttachmentMaxMB)*1024*1024, config.ThumbnailSizes)
+	}
+
+	// Export/import an archive of the state (when the server isn't running, otherwise use the
+	// admin API)
+	archiveConfig := archive.Config{
+		BuiltinUsername:    model.BuiltinUsername(),
+		BuiltinChannelname: model.BuiltinChannelname(),
+		DefaultChannels:    config.DefaultChannels,
+		ProtectedUsers:     config.ProtectedUsers,
+		ProtectedChannels:  config.ProtectedChannels,
+	}
+
+	if *exportFilePath != "" {
+		stateArchive, err := archive.New(model, archiveConfig, attachmentStore)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = stateArchive.WriteFile(*exportFilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Exported archive:", *exportFilePath)
+		return
+	}
+
+	if *importFilePath != "" {
+		stateArchive, err := archive.ParseFile(*importFilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = stateArchive.Import(model, attachmentStore)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Imported archive:", *importFilePath)
+		return
+	}
+
+	// Guard against the disk filling up with the log (checked once before serving, then
+	// periodically): alert the admins, compact the log, and switch to read-only while there's too
+	// little space, rather than failing part way through a change
+	var diskGuard *diskguard.Guard
+	if logFileLogger != nil {
+		diskGuardOptions := diskguard.Options{
+			AlertFreeBytes:    uint64(config.DiskAlertFreeMB) * 1024 * 1024,
+			CompactFreeBytes:  uint64(config.DiskCompactFreeMB) * 1024 * 1024,
+			ReadOnlyFreeBytes: uint64(config.DiskReadOnlyFreeMB) * 1024 * 1024,
+			AlertLogBytes:     int64(config.LogAlertSizeMB) * 1024 * 1024,
+			CompactLogBytes:   int64(config.LogCompactSizeMB) * 1024 * 1024,
+			AlertChannelname:  config.AlertChannelname,
+			WebhookURL:        config.AlertWebhookURL,
+		}
+		diskGuard = diskguard.NewGuard(model, config.LogFilePath, func() error {
+			return model.Compact(logFileLogger.Compact)
+		}, diskGuardOptions)
+		logFileLogger.SetErrorHandler(diskGuard.LogWriteFailed)
+		diskGuard.Check()
+
+		go func() {
+			for range time.Tick(time.Duration(config.DiskCheckInterval) * time.Second) {
+				diskGuard.Check()
+			}
+		}()
+	}
+
+	// Save a snapshot of the state periodically, so it can be restored if the log is lost with the
+	// container
+	if config.SnapshotInterval > 0 {
+		go func() {
+			for range time.Tick(time.Duration(config.SnapshotInterval) * time.Second) {
+				err := snapshots.Save(model, objectStore)
+				if err != nil {
+					log.Println("snapshot:", err)
+				}
+			}
+		}()
+	}
+
+	// Post the reminders for the channels' calendar events as they come up
+	go func() {
+		for range time.Tick(calendar.CheckInterval) {
+			calendar.Remind(model, time.Duration(config.EventReminderMins)*time.Minute)
+		}
+	}()
+
+	// Reconcile the desired state file (once before serving, then periodically so edits are picked up)
+	if config.ReconcileFilePath != "" {
+		desiredState, err := bootstrap.ParseFile(config.ReconcileFilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = desiredState.Reconcile(model, config.ReconcilePrune, credentialStore)
+		if err != nil {
+			log.Println("reconcile:", err)
+		}
+
+		go func() {
+			for range time.Tick(time.Duration(config.ReconcileInterval) * time.Second) {
+				desiredState, err := bootstrap.ParseFile(config.ReconcileFilePath)
+				if err != nil {
+					log.Println("reconcile:", err)
+					continue
+				}
+				err = desiredState.Reconcile(model, config.ReconcilePrune, credentialStore)
+				if err != nil {
+					log.Println("reconcile:", err)
+				}
+			}
+		}()
+	}
+
+	// Start the bots (they react to the model like any other subscription client)
+	if config.WelcomeBotUsername != "" {
+		welcome := bots.NewWelcome(model.BuiltinChannelname(), config.WelcomeBotGreeting, config.WelcomeBotHelp, config.WelcomeBotTriggers)
+		err := subsEngine.Connect(bots.NewBot(model, config.WelcomeBotUsername, welcome))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.KarmaBotUsername != "" {
+		err := subsEngine.Connect(bots.NewBot(model, config.KarmaBotUsername, bots.NewKarma()))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.AutomationUsername != "" {
+		automations := bots.NewAutomations(config.AutomationMoves, config.AutomationEmoji, config.AutomationPinReactions)
+		err := subsEngine.Connect(bots.NewBot(model, config.AutomationUsername, automations))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.MirrorUsername != "" {
+		// The mirror channels are created along with the bot, as its user is
+		for mirrorChannelname := range config.MirrorChannels {
+			model.CreateChannel(mirrorChannelname)
+		}
+
+		err := subsEngine.Connect(bots.NewBot(model, config.MirrorUsername, bots.NewMirror(config.MirrorChannels)))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for botUsername, command := range config.PluginBots {
+		process := bots.NewProcess(command)
+		bot := bots.NewBot(model, botUsername, process)
+		err := subsEngine.Connect(bot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		process.Run(bot)
+	}
+
+	for botUsername, scriptFilePath := range config.ScriptBots {
+		err := subsEngine.Connect(bots.NewBot(model, botUsername, bots.NewScript(scriptFilePath)))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Pick up any listeners passed to us (e.g. systemd socket activation with the
+	// FileDescriptorName "telnet" and "web"), otherwise bind to the configured addresses
+	inheritedListeners, err := listeners.Inherited()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	telnetAddress := net.JoinHostPort(config.TelnetAddress, strconv.Itoa(config.TelnetPort))
+	telnetListener, err := listeners.Listen(inheritedListeners, "telnet", telnetAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	webAddress := net.JoinHostPort(config.WebAddress, strconv.Itoa(config.WebPort))
+	webListener, err := listeners.Listen(inheritedListeners, "web", webAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create the JSON RPC API (web client sessions can be resumed until they've been unused for
+	// the session timeout) and the web client websocket handler (served after everything else is
+	// set up)
+	sessionTimeout := time.Duration(config.SessionTimeout) * time.Second
+	if sessionTimeout == 0 {
+		sessionTimeout = 300 * time.Second
+	}
+	sessionStore := sessions.NewStore(sessionTimeout, model.Now)
+
+	webapiOptions := webapi.InstanceOptions{
+		Version:     serverVersion(),
+		Credentials: credentialStore,
+		Attachments: attachmentStore,
+	}
+	clientErrors := clienterrors.NewBuffer(maxClientErrors)
+	webapiInstance := webapi.NewInstance(model, subsEngine, sessionStore, replicas, searchIndex, clientErrors, webapiOptions)
+	webapiHandler := webapi.NewConnectionHandler(subsEngine, webapiInstance, tracer)
+
 	// Serve telnet
-	telnetHandler := telnetapi.NewConnectionHandler(model, subsEngine)
-	telnetPort := ":" + strconv.Itoa(config.TelnetPort)
+	telnetOutputOptions := telnetapi.OutputOptions{
+		QueueBytes:    config.TelnetOutputQueueBytes,
+		Overflow:      config.TelnetOverflow,
+		FlushInterval: time.Duration(config.TelnetFlushMillis) * time.Millisecond,
+	}
+	telnetHandler := telnetapi.NewConnectionHandler(model, subsEngine, credentialStore, tracer, telnetOutputOptions)
+
+	// The connections accepted on each listener are tracked, so they can be drained once the
+	// listeners are handed over in a hot restart
+	servedListeners := map[string]*listeners.Tracked{
+		"telnet": listeners.Track(telnetListener),
+		"web":    listeners.Track(webListener),
+	}
+
 	go func() {
-		err := gotelnet.ListenAndServe(telnetPort, telnetHandler)
+		err := telnetapi.Serve(servedListeners["telnet"], telnetHandler)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}()
 
+	// Serve the admin API (a separate service from the public JSON RPC API) on a local Unix socket
+	// and/or a token protected HTTP listener
+	connectionCounters := map[string]adminapi.ConnectionCounter{
+		"telnet": telnetHandler,
+		"web":    webapiHandler,
+	}
+
+	requestLoggers := map[string]adminapi.RequestLogger{
+		"web": webapiHandler,
+	}
+
+	adminServer := rpc.NewServer()
+	err = adminServer.RegisterName("chatserveradmin", adminapi.NewInstance(model, subsEngine, credentialStore, archiveConfig, connectionCounters, requestLoggers, clientErrors, diskGuard, objectStore, attachmentStore))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if config.AdminSocketPath != "" {
+		adminListener, err := listeners.ListenUnix(inheritedListeners, "admin", config.AdminSocketPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		servedListeners["admin"] = listeners.Track(adminListener)
+
+		go func() {
+			err := adminapi.Serve(servedListeners["admin"], adminServer)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if config.AdminPort > 0 {
+		adminAddress := net.JoinHostPort(config.AdminAddress, strconv.Itoa(config.AdminPort))
+		adminHTTPListener, err := listeners.Listen(inheritedListeners, "adminhttp", adminAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		servedListeners["adminhttp"] = listeners.Track(adminHTTPListener)
+
+		go func() {
+			err := http.Serve(servedListeners["adminhttp"], adminapi.Handler(adminServer, config.AdminToken))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// Hot restart on SIGUSR2: the listeners are handed over to a new process, which replays the
+	// actions log while our model is suspended (so the log doesn't change under it), so no
+	// connections are refused during an upgrade.  Once the new process reports that it's serving,
+	// our connections are closed (their clients reconnect to it) and we exit; if it fails to come
+	// up, we resume serving instead.
+	hotRestart := make(chan os.Signal, 1)
+	signal.Notify(hotRestart, syscall.SIGUSR2)
+	go func() {
+		for range hotRestart {
+			if config.LogFilePath == "" {
+				log.Println("hot restart: a log file path is required to hand over state")
+				continue
+			}
+
+			model.Suspend()
+
+			// Flush anything the sync policy hasn't yet, before the new process reads the log
+			err := logFileLogger.Sync()
+			if err != nil {
+				log.Println("hot restart:", err)
+			}
+
+			child, err := listeners.Handover(servedListeners)
+			if err != nil {
+				log.Println("hot restart:", err)
+				model.Resume()
+				continue
+			}
+
+			log.Println("hot restart: handed over to process", child.Process.Pid)
+
+			err = child.WaitReady(time.Duration(config.HotRestartTimeout) * time.Second)
+			if err != nil {
+				log.Println("hot restart:", err)
+				model.Resume()
+				continue
+			}
+
+			for _, listener := range servedListeners {
+				listener.Drain()
+			}
+
+			log.Println("hot restart: process", child.Process.Pid, "is serving, exiting")
+			os.Exit(0)
+		}
+	}()
+
 	// Set up JSON RPC
-	err = rpc.RegisterName("chatserver", webapi.NewInstance(model))
+	err = rpc.RegisterName("chatserver", webapiInstance)
 	if err != nil {
 		log.Fatal(err)
 	}
-	webapiHandler := webapi.NewConnectionHandler(subsEngine)
 
 	// Serve HTTP
 	http.Handle("/", http.FileServer(http.Dir(config.WebClientPath)))
 	http.Handle("/ws", webapiHandler)
-	webPort := ":" + strconv.Itoa(config.WebPort)
-	err = http.ListenAndServe(webPort, nil)
+	if attachmentStore != nil {
+		http.Handle("/attachments/", attachments.Handler(attachmentStore, sessionStore, "/attachments/"))
+	}
+
+	// Report that we're serving to the process that handed its listeners over to us (if any)
+	err = listeners.Ready()
+	if err != nil {
+		log.Println("hot restart:", err)
+	}
+
+	err = http.Serve(servedListeners["web"], nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// serverVersion returns the version of the chatserver module the executable was built from
+// ("(devel)" when it wasn't built from a tagged version).
+func serverVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+
+	return info.Main.Version
+}
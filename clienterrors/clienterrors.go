@@ -0,0 +1,76 @@
+// Package clienterrors keeps the errors reported by clients (e.g. JavaScript errors and protocol
+// mismatches in the web client) so admins can look into client bugs that are hard to reproduce.
+// Only the most recent reports are kept, in memory.
+package clienterrors
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxFieldLength bounds the length of each field of a report.
+const maxFieldLength int = 2000
+
+// Report contains a single error reported by a client.
+type Report struct {
+	Time      time.Time
+	Kind      string
+	Message   string
+	Details   string
+	UserAgent string
+}
+
+// Buffer keeps the most recent reports, dropping the oldest once it's full.
+type Buffer struct {
+	reports  []Report
+	capacity int
+	dropped  int
+	mutex    sync.Mutex
+}
+
+// NewBuffer creates/initializes/returns a new Buffer keeping up to capacity reports.
+func NewBuffer(capacity int) *Buffer {
+	buffer := Buffer{
+		reports:  make([]Report, 0, capacity),
+		capacity: capacity,
+	}
+
+	return &buffer
+}
+
+// Add adds a report (with its fields truncated), timestamped now.
+func (b *Buffer) Add(kind string, message string, details string, userAgent string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	report := Report{
+		Time:      time.Now(),
+		Kind:      truncate(kind),
+		Message:   truncate(message),
+		Details:   truncate(details),
+		UserAgent: truncate(userAgent),
+	}
+
+	if len(b.reports) == b.capacity {
+		b.reports = append(b.reports[:0], b.reports[1:]...)
+		b.dropped++
+	}
+	b.reports = append(b.reports, report)
+}
+
+// Reports returns the reports (oldest first), and the number of reports dropped to make room.
+func (b *Buffer) Reports() ([]Report, int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return append([]Report(nil), b.reports...), b.dropped
+}
+
+func truncate(field string) string {
+	if len(field) > maxFieldLength {
+		return strings.ToValidUTF8(field[:maxFieldLength], "")
+	}
+
+	return field
+}
@@ -0,0 +1,100 @@
+// Command chatserver-loadgen puts a chat server under load with simulated telnet and web clients
+// posting at a steady rate, and reports the latencies they see.
+//
+// Each client posts as its own user in the same channel.  A telnet client's latency is the time
+// from sending a message until it's printed back to the client (through the subscriptions, along
+// with everyone else's messages), a web client's is the time until its PostMessage request is
+// answered.  Messages that aren't seen by the end (within the timeout) are reported as lost.
+//
+// Usage
+//
+//	chatserver-loadgen -telnet localhost:8023 -web localhost:8080 -telnet-clients 50 -web-clients 50 -rate 2 -duration 1m
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// clientOptions provides the settings shared by the simulated clients.
+type clientOptions struct {
+	channelname string
+	interval    time.Duration
+	timeout     time.Duration
+	stop        <-chan struct{}
+}
+
+func main() {
+	telnetAddress := flag.String("telnet", "localhost:8023", "telnet address of the server (host:port)")
+	webAddress := flag.String("web", "localhost:8080", "web address of the server (host:port)")
+	numTelnetClients := flag.Int("telnet-clients", 10, "number of simulated telnet clients")
+	numWebClients := flag.Int("web-clients", 10, "number of simulated web clients")
+	rate := flag.Float64("rate", 1, "messages posted per second by each client")
+	duration := flag.Duration("duration", 30*time.Second, "how long the clients post for")
+	timeout := flag.Duration("timeout", 5*time.Second, "how long to wait for the last messages once the clients stop posting")
+	channelname := flag.String("channel", "General", "channel the clients post in")
+	prefix := flag.String("prefix", "loadgen", "prefix of the names of the clients' users")
+	flag.Parse()
+
+	if *rate <= 0 || *duration <= 0 || *numTelnetClients < 0 || *numWebClients < 0 {
+		flag.Usage()
+		log.Fatalln("error: rate and duration must be positive, and the numbers of clients can't be negative")
+	}
+
+	stop := make(chan struct{})
+	options := clientOptions{
+		channelname: *channelname,
+		interval:    time.Duration(float64(time.Second) / *rate),
+		timeout:     *timeout,
+		stop:        stop,
+	}
+
+	telnetStats := newStats()
+	webStats := newStats()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= *numTelnetClients; i++ {
+		username := *prefix + "-t" + strconv.Itoa(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := runTelnetClient(*telnetAddress, username, options, telnetStats)
+			if err != nil {
+				telnetStats.addFailedClient()
+				log.Printf("telnet client %s: %v", username, err)
+			}
+		}()
+	}
+
+	for i := 1; i <= *numWebClients; i++ {
+		username := *prefix + "-w" + strconv.Itoa(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := runWebClient(*webAddress, username, options, webStats)
+			if err != nil {
+				webStats.addFailedClient()
+				log.Printf("web client %s: %v", username, err)
+			}
+		}()
+	}
+
+	log.Printf("%d telnet and %d web clients posting %.2f messages per second each for %v", *numTelnetClients, *numWebClients, *rate, *duration)
+	time.Sleep(*duration)
+	close(stop)
+	wg.Wait()
+
+	if *numTelnetClients > 0 {
+		fmt.Println(telnetStats.report("telnet", *numTelnetClients, *duration))
+	}
+
+	if *numWebClients > 0 {
+		fmt.Println(webStats.report("web", *numWebClients, *duration))
+	}
+}
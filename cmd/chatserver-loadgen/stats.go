@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stats collects what the clients of one kind saw.
+type stats struct {
+	mutex         sync.Mutex
+	latencies     []time.Duration
+	sent          int
+	errors        int
+	lost          int
+	failedClients int
+}
+
+// newStats creates/initializes/returns a new stats.
+func newStats() *stats {
+	s := stats{
+		latencies: make([]time.Duration, 0),
+	}
+
+	return &s
+}
+
+func (s *stats) addSent() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sent++
+}
+
+func (s *stats) addLatency(latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.latencies = append(s.latencies, latency)
+}
+
+func (s *stats) addError() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.errors++
+}
+
+func (s *stats) addLost(lost int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lost += lost
+}
+
+func (s *stats) addFailedClient() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failedClients++
+}
+
+// report formats the stats (with the latency percentiles) for clients that posted for a duration.
+func (s *stats) report(kind string, numClients int, duration time.Duration) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	latencies := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "%s: %d clients (%d failed), %d sent, %d received (%.1f/s), %d errors, %d lost\n",
+		kind, numClients, s.failedClients, s.sent, len(latencies), float64(len(latencies))/duration.Seconds(), s.errors, s.lost)
+	fmt.Fprintf(&report, "  latency p50 %v, p90 %v, p99 %v, max %v",
+		percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), percentile(latencies, 100))
+
+	return report.String()
+}
+
+// percentile returns the latency that p percent of the (sorted) latencies are at or below.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	index := int(math.Ceil(p/100*float64(len(latencies)))) - 1
+	if index < 0 {
+		index = 0
+	}
+
+	return latencies[index]
+}
+
+// pendingPosts keeps the time each post that hasn't been seen yet was sent, by a key identifying
+// the post.
+type pendingPosts struct {
+	mutex sync.Mutex
+	sent  map[string]time.Time
+}
+
+// newPendingPosts creates/initializes/returns a new pendingPosts.
+func newPendingPosts() *pendingPosts {
+	p := pendingPosts{
+		sent: make(map[string]time.Time),
+	}
+
+	return &p
+}
+
+func (p *pendingPosts) add(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.sent[key] = time.Now()
+}
+
+// remove forgets a post, returning the time it was sent (false if it isn't pending).
+func (p *pendingPosts) remove(key string) (time.Time, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sent, ok := p.sent[key]
+	delete(p.sent, key)
+	return sent, ok
+}
+
+func (p *pendingPosts) len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.sent)
+}
+
+// wait waits until no posts are pending, or the timeout passes.
+func (p *pendingPosts) wait(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for p.len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
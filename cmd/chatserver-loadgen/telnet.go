@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postMarker marks the messages posted by the clients, followed by the post's key.
+const postMarker string = "loadgen post "
+
+// dialTimeout bounds how long connecting to the server can take.
+const dialTimeout time.Duration = 10 * time.Second
+
+// runTelnetClient runs a simulated telnet client until the clients are stopped.  It switches to its
+// own user in the channel, then posts a message every interval and times how long each takes to be
+// printed back.
+func runTelnetClient(address string, username string, options clientOptions, clientStats *stats) error {
+	conn, err := net.DialTimeout("tcp", address, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Read the output (the telnet negotiation the server starts is left unanswered, as a client
+	// that doesn't support the options would)
+	pending := newPendingPosts()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			index := strings.LastIndex(line, postMarker)
+			if index == -1 {
+				continue
+			}
+
+			fields := strings.Fields(line[index+len(postMarker):])
+			if len(fields) == 0 {
+				continue
+			}
+
+			if sent, ok := pending.remove(fields[0]); ok {
+				clientStats.addLatency(time.Since(sent))
+			}
+		}
+	}()
+
+	// The user may exist from an earlier run, which is fine
+	for _, command := range []string{"/createuser " + username, "/user " + username, "/join " + options.channelname} {
+		_, err = conn.Write([]byte(command + "\r\n"))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Spread the clients' posts over the interval
+	time.Sleep(time.Duration(rand.Int63n(int64(options.interval))))
+	ticker := time.NewTicker(options.interval)
+	defer ticker.Stop()
+
+	for seq := 1; ; seq++ {
+		select {
+		case <-options.stop:
+			pending.wait(options.timeout)
+			clientStats.addLost(pending.len())
+			conn.Close()
+			<-done
+			return nil
+		case <-ticker.C:
+		}
+
+		key := username + "-" + strconv.Itoa(seq)
+		pending.add(key)
+		clientStats.addSent()
+
+		_, err = conn.Write([]byte(postMarker + key + "\r\n"))
+		if err != nil {
+			pending.remove(key)
+			clientStats.addError()
+			clientStats.addLost(pending.len())
+			return err
+		}
+	}
+}
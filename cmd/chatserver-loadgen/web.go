@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"golang.org/x/net/websocket"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// webProtocolVersion is the version of the web API the requests are made with (rejected posts are
+// only errors from version 3).
+const webProtocolVersion int = 3
+
+// webRequest is a JSON RPC request to the web API.
+type webRequest struct {
+	ID     int           `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// webResponse is a JSON RPC response (or, with an ID of -1, a notification) from the web API.
+type webResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  interface{}     `json:"error"`
+}
+
+// runWebClient runs a simulated web client until the clients are stopped.  It creates its own
+// user in the channel, then posts a message every interval and times how long each post takes to
+// be answered.
+func runWebClient(address string, username string, options clientOptions, clientStats *stats) error {
+	conn, err := websocket.Dial("ws://"+address+"/ws", "", "http://"+address+"/")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Read the responses (the setup requests, which may be rejected if the user exists from an
+	// earlier run, and the notifications aren't timed)
+	pending := newPendingPosts()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for {
+			var response webResponse
+			err := websocket.JSON.Receive(conn, &response)
+			if err != nil {
+				return
+			}
+
+			sent, ok := pending.remove(strconv.Itoa(response.ID))
+			if !ok {
+				continue
+			}
+
+			if response.Error != nil {
+				clientStats.addError()
+			} else {
+				clientStats.addLatency(time.Since(sent))
+			}
+		}
+	}()
+
+	id := 1
+	send := func(method string, params interface{}) error {
+		request := webRequest{
+			ID:     id,
+			Method: "chatserver.v" + strconv.Itoa(webProtocolVersion) + "." + method,
+			Params: []interface{}{params},
+		}
+		id++
+
+		return websocket.JSON.Send(conn, request)
+	}
+
+	err = send("CreateUser", map[string]string{"Username": username})
+	if err != nil {
+		return err
+	}
+
+	err = send("JoinChannel", map[string]string{"Username": username, "Channelname": options.channelname})
+	if err != nil {
+		return err
+	}
+
+	// Spread the clients' posts over the interval
+	time.Sleep(time.Duration(rand.Int63n(int64(options.interval))))
+	ticker := time.NewTicker(options.interval)
+	defer ticker.Stop()
+
+	for seq := 1; ; seq++ {
+		select {
+		case <-options.stop:
+			pending.wait(options.timeout)
+			clientStats.addLost(pending.len())
+			conn.Close()
+			<-done
+			return nil
+		case <-ticker.C:
+		}
+
+		key := strconv.Itoa(id)
+		pending.add(key)
+		clientStats.addSent()
+
+		err = send("PostMessage", map[string]string{
+			"Channelname": options.channelname,
+			"Username":    username,
+			"Text":        postMarker + username + "-" + strconv.Itoa(seq),
+		})
+		if err != nil {
+			pending.remove(key)
+			clientStats.addError()
+			clientStats.addLost(pending.len())
+			return err
+		}
+	}
+}
@@ -7,14 +7,85 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
 // Config contains configuration data.
 type Config struct {
-	TelnetPort    int
-	WebPort       int
-	WebClientPath string
-	LogFilePath   string
+	TelnetAddress          string
+	TelnetPort             int
+	WebAddress             string
+	WebPort                int
+	AdminSocketPath        string
+	AdminAddress           string
+	AdminPort              int
+	AdminToken             string
+	WebClientPath          string
+	LogFilePath            string
+	LogSync                string
+	LogSyncMillis          int
+	HotRestartTimeout      int
+	BuiltinUsername        string
+	BuiltinChannelname     string
+	DefaultChannels        []string
+	ProtectedUsers         []string
+	ProtectedChannels      []string
+	BootstrapFilePath      string
+	ReconcileFilePath      string
+	ReconcileInterval      int
+	ReconcilePrune         bool
+	TracingEndpoint        string
+	EventsSink             string
+	EventsTarget           string
+	EventsSubject          string
+	EventsSalt             string
+	LanguageWordLists      map[string][]string
+	ReadReplicas           int
+	MessageSearch          bool
+	TelnetOutputQueueBytes int
+	TelnetOverflow         string
+	TelnetFlushMillis      int
+	SessionTimeout         int
+	MaxUsers               int
+	MaxChannelsPerUser     int
+	MaxMessagesPerDay      int
+	DiskCheckInterval      int
+	DiskAlertFreeMB        int
+	DiskCompactFreeMB      int
+	DiskReadOnlyFreeMB     int
+	LogAlertSizeMB         int
+	LogCompactSizeMB       int
+	AlertWebhookURL        string
+	AlertChannelname       string
+	WelcomeBotUsername     string
+	WelcomeBotGreeting     string
+	WelcomeBotHelp         string
+	WelcomeBotTriggers     map[string]string
+	KarmaBotUsername       string
+	PluginBots             map[string][]string
+	ScriptBots             map[string]string
+	CredentialStore        string
+	CredentialFilePath     string
+	StorageBackend         string
+	StorageDirectory       string
+	StorageEndpoint        string
+	StorageRegion          string
+	StorageBucket          string
+	StoragePrefix          string
+	StorageAccessKeyID     string
+	StorageSecretKey       string
+	SnapshotInterval       int
+	AttachmentMaxMB        int
+	ThumbnailSizes         []int
+	EventReminderMins      int
+	DuplicateWindow        int
+	DuplicatePosts         string
+	AutomationUsername     string
+	AutomationMoves        map[string]string
+	AutomationEmoji        string
+	AutomationPinReactions int
+	MirrorUsername         string
+	MirrorChannels         map[string]string
 }
 
 // ParseFile attempts to open a JSON config file at a given location, parse it
@@ -42,11 +113,233 @@ func ParseFile(configFilePath string) (*Config, error) {
 		return nil, errors.New("invalid web port")
 	}
 
+	// Validate the admin HTTP listener (a zero port disables it, a token is required otherwise)
+	if config.AdminPort < 0 || (config.AdminPort > 0 && config.AdminToken == "") {
+		return nil, errors.New("invalid admin port/token")
+	}
+
 	// Validate the web client path
 	info, err := os.Stat(config.WebClientPath)
 	if (err != nil && os.IsNotExist(err)) || !info.IsDir() {
 		return nil, errors.New("invalid web client path")
 	}
 
+	// Validate the built-in names (empty selects the default)
+	if strings.Contains(config.BuiltinUsername, " ") {
+		return nil, errors.New("invalid built-in username")
+	}
+
+	if strings.Contains(config.BuiltinChannelname, " ") {
+		return nil, errors.New("invalid built-in channelname")
+	}
+
+	// Validate the reconcile interval
+	if config.ReconcileFilePath != "" && config.ReconcileInterval <= 0 {
+		return nil, errors.New("invalid reconcile interval")
+	}
+
+	// Validate the events sink
+	if config.EventsSink != "" && config.EventsSink != "file" && config.EventsSink != "nats" {
+		return nil, errors.New("invalid events sink")
+	}
+
+	if config.EventsSink != "" && config.EventsTarget == "" {
+		return nil, errors.New("invalid events target")
+	}
+
+	// Validate the read replicas
+	if config.ReadReplicas < 0 {
+		return nil, errors.New("invalid read replicas")
+	}
+
+	// Validate the telnet output queue (empty/zero select the defaults)
+	if config.TelnetOutputQueueBytes < 0 {
+		return nil, errors.New("invalid telnet output queue")
+	}
+
+	if config.TelnetOverflow != "" && config.TelnetOverflow != "drop-oldest" && config.TelnetOverflow != "disconnect" {
+		return nil, errors.New("invalid telnet overflow")
+	}
+
+	if config.TelnetFlushMillis < 0 {
+		return nil, errors.New("invalid telnet flush interval")
+	}
+
+	// Validate the session timeout (zero selects the default)
+	if config.SessionTimeout < 0 {
+		return nil, errors.New("invalid session timeout")
+	}
+
+	// Validate the quotas (zero disables each of them)
+	if config.MaxUsers < 0 || config.MaxChannelsPerUser < 0 || config.MaxMessagesPerDay < 0 {
+		return nil, errors.New("invalid quotas")
+	}
+
+	// Validate the log sync policy (empty selects none, and the interval defaults to a second)
+	if config.LogSync != "" && config.LogSync != "none" && config.LogSync != "interval" && config.LogSync != "always" {
+		return nil, errors.New("invalid log sync policy")
+	}
+
+	if config.LogSyncMillis < 0 {
+		return nil, errors.New("invalid log sync interval")
+	}
+
+	if config.LogSync == "" {
+		config.LogSync = "none"
+	}
+
+	if config.LogSyncMillis == 0 {
+		config.LogSyncMillis = 1000
+	}
+
+	// Validate the hot restart timeout (defaults to five minutes, for the new process to replay the
+	// log)
+	if config.HotRestartTimeout < 0 {
+		return nil, errors.New("invalid hot restart timeout")
+	}
+
+	if config.HotRestartTimeout == 0 {
+		config.HotRestartTimeout = 300
+	}
+
+	// Validate the disk safeguards (zero disables each threshold, and the check interval defaults to
+	// a minute)
+	if config.DiskCheckInterval < 0 || config.DiskAlertFreeMB < 0 || config.DiskCompactFreeMB < 0 || config.DiskReadOnlyFreeMB < 0 ||
+		config.LogAlertSizeMB < 0 || config.LogCompactSizeMB < 0 {
+		return nil, errors.New("invalid disk safeguards")
+	}
+
+	if config.DiskCheckInterval == 0 {
+		config.DiskCheckInterval = 60
+	}
+
+	if strings.Contains(config.AlertChannelname, " ") {
+		return nil, errors.New("invalid alert channelname")
+	}
+
+	// Validate the welcome bot username (empty disables the bot)
+	if strings.Contains(config.WelcomeBotUsername, " ") {
+		return nil, errors.New("invalid welcome bot username")
+	}
+
+	// Validate the karma bot username (empty disables the bot)
+	if strings.Contains(config.KarmaBotUsername, " ") {
+		return nil, errors.New("invalid karma bot username")
+	}
+
+	// Validate the automation bot (empty disables the bot)
+	if strings.Contains(config.AutomationUsername, " ") || strings.Contains(config.AutomationEmoji, " ") {
+		return nil, errors.New("invalid automation bot")
+	}
+
+	for keyword, channelname := range config.AutomationMoves {
+		if keyword == "" || strings.Contains(keyword, " ") || channelname == "" || strings.Contains(channelname, " ") {
+			return nil, errors.New("invalid automation move")
+		}
+	}
+
+	if config.AutomationPinReactions < 0 {
+		return nil, errors.New("invalid automation pin reactions")
+	}
+
+	// Validate the mirror bot (empty disables the bot) and its mirrors
+	if strings.Contains(config.MirrorUsername, " ") {
+		return nil, errors.New("invalid mirror bot username")
+	}
+
+	for mirrorChannelname, channelname := range config.MirrorChannels {
+		if mirrorChannelname == "" || strings.Contains(mirrorChannelname, " ") || channelname == "" || strings.Contains(channelname, " ") || mirrorChannelname == channelname {
+			return nil, errors.New("invalid mirror channel")
+		}
+	}
+
+	// Validate the plugin bots
+	for username, command := range config.PluginBots {
+		if username == "" || strings.Contains(username, " ") || len(command) == 0 || command[0] == "" {
+			return nil, errors.New("invalid plugin bot")
+		}
+	}
+
+	// Validate the script bots
+	for username, scriptFilePath := range config.ScriptBots {
+		if username == "" || strings.Contains(username, " ") || scriptFilePath == "" {
+			return nil, errors.New("invalid script bot")
+		}
+	}
+
+	// Validate the credential store (empty disables passwords)
+	if config.CredentialStore != "" && config.CredentialStore != "log" && config.CredentialStore != "file" {
+		return nil, errors.New("invalid credential store")
+	}
+
+	if config.CredentialStore == "file" && config.CredentialFilePath == "" {
+		return nil, errors.New("invalid credential file path")
+	}
+
+	// Validate the object storage (empty disables it), with the S3 credentials defaulting to the
+	// usual environment variables
+	if config.StorageBackend != "" && config.StorageBackend != "local" && config.StorageBackend != "s3" {
+		return nil, errors.New("invalid storage backend")
+	}
+
+	if config.StorageBackend == "local" && config.StorageDirectory == "" {
+		return nil, errors.New("invalid storage directory")
+	}
+
+	if config.StorageBackend == "s3" && (config.StorageEndpoint == "" || config.StorageBucket == "") {
+		return nil, errors.New("invalid storage endpoint/bucket")
+	}
+
+	if config.StorageAccessKeyID == "" {
+		config.StorageAccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+
+	if config.StorageSecretKey == "" {
+		config.StorageSecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	// Validate the snapshot interval (zero disables snapshots, which need the storage and a log)
+	if config.SnapshotInterval < 0 || (config.SnapshotInterval > 0 && (config.StorageBackend == "" || config.LogFilePath == "")) {
+		return nil, errors.New("invalid snapshot interval")
+	}
+
+	// Validate the attachment size limit (zero disables attachments, which need the storage)
+	if config.AttachmentMaxMB < 0 || (config.AttachmentMaxMB > 0 && config.StorageBackend == "") {
+		return nil, errors.New("invalid attachment max MB")
+	}
+
+	// Validate the thumbnail sizes (which need attachments)
+	for _, size := range config.ThumbnailSizes {
+		if size <= 0 || config.AttachmentMaxMB == 0 {
+			return nil, errors.New("invalid thumbnail size")
+		}
+	}
+
+	// Validate the event reminder lead (zero selects the default)
+	if config.EventReminderMins < 0 {
+		return nil, errors.New("invalid event reminder minutes")
+	}
+
+	if config.EventReminderMins == 0 {
+		config.EventReminderMins = 15
+	}
+
+	// Validate the duplicate message suppression (a zero window allows duplicates, which are collapsed
+	// by default)
+	if config.DuplicateWindow < 0 {
+		return nil, errors.New("invalid duplicate window")
+	}
+
+	if config.DuplicatePosts != "" && config.DuplicatePosts != "collapse" && config.DuplicatePosts != "reject" {
+		return nil, errors.New("invalid duplicate posts")
+	}
+
+	// Validate the default channels
+	for _, channelname := range config.DefaultChannels {
+		if channelname == "" || strings.Contains(channelname, " ") {
+			return nil, errors.New("invalid default channel")
+		}
+	}
+
 	return &config, nil
 }
@@ -0,0 +1,408 @@
+// Package credentials provides password storage for user accounts.  Passwords are only kept as
+// salted PBKDF2-SHA256 hashes, in a backend selected in the config: the actions log (as model
+// plugin data, so the hashes are kept with the rest of the state) or a separate file (so not even
+// the hashes end up in the human-readable log).
+package credentials
+
+import (
+	"chatserver/model"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Namespace is the plugin data namespace the log backend keeps the hashes in.
+const Namespace string = "credentials"
+
+// hashIterations is the PBKDF2 iteration count for new hashes (checking uses the stored count).
+const hashIterations int = 100000
+
+// maxAddresses is the number of a user's most recent login addresses remembered, a login from any
+// other address being a security event.
+const maxAddresses int = 10
+
+const saltSize int = 16
+const keySize int = sha256.Size
+
+// ErrRegistered is returned when a name is already taken by a registered account's password (e.g.
+// one left behind by a user deleted without it), so it can't be claimed by someone else.
+var ErrRegistered = errors.New("name belongs to a registered account")
+
+// ErrInvalidLogin is returned when a registered account is used without its password.
+var ErrInvalidLogin = errors.New("invalid username or password")
+
+// Store provides an interface for credential storage backends, which keep a hash per username.
+type Store interface {
+	Get(username string) (string, bool)
+	Put(username string, hash string) error
+	Delete(username string) error
+}
+
+// Credentials sets and checks passwords (and TOTP codes, for the accounts with two-factor
+// authentication) against a Store, throttling the logins (see Throttle).
+type Credentials struct {
+	store    Store
+	throttle *Throttle
+
+	// usedSteps is the last TOTP step each user logged in with, which can't be used again
+	usedSteps map[string]int64
+	mutex     sync.Mutex
+
+	// notify tells a user about a security event on their account (see SetNotifier)
+	notify func(username string, text string)
+}
+
+// New creates/initializes/returns a new Credentials using the given store.
+func New(store Store) *Credentials {
+	credentials := Credentials{
+		store:     store,
+		throttle:  NewThrottle(time.Now),
+		usedSteps: make(map[string]int64),
+	}
+
+	return &credentials
+}
+
+// SetNotifier sets the function called to tell a user about security events on their account: a
+// password change, a login from an address they haven't logged in from lately, and two-factor
+// authentication being enabled or disabled.  It must be set before the credentials are used.
+func (c *Credentials) SetNotifier(notify func(username string, text string)) {
+	c.notify = notify
+}
+
+// SetPassword sets a user's password.
+func (c *Credentials) SetPassword(username string, password string) error {
+	if username == "" || password == "" {
+		return errors.New("invalid username or password")
+	}
+
+	salt := make([]byte, saltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err
+	}
+
+	changed := c.HasPassword(username)
+	err = c.store.Put(username, encodeHash(hashIterations, salt, pbkdf2([]byte(password), salt, hashIterations)))
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		c.notifyUser(username, "password changed")
+	}
+
+	return nil
+}
+
+// CheckPassword returns whether the password is the user's password (false if they have none).
+func (c *Credentials) CheckPassword(username string, password string) bool {
+	hash, ok := c.store.Get(username)
+	if !ok {
+		return false
+	}
+
+	iterations, salt, key, err := decodeHash(hash)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(pbkdf2([]byte(password), salt, iterations), key) == 1
+}
+
+// Login checks a user's password (and, if they have two-factor authentication enabled, the code
+// from their authenticator or a recovery code, see CheckCode) for a login from a client address
+// (empty if unknown).  It returns ErrThrottled if the account or address has failed too many logins
+// lately (see Throttle), or ErrInvalidLogin if the password or code isn't the user's.
+func (c *Credentials) Login(username string, password string, code string, address string) error {
+	err := c.throttle.Begin(username, address)
+	if err != nil {
+		return err
+	}
+
+	if !c.CheckPassword(username, password) {
+		return ErrInvalidLogin
+	}
+
+	if c.HasTOTP(username) && !c.CheckCode(username, code) {
+		return ErrInvalidLogin
+	}
+
+	c.throttle.Succeeded(username, address)
+
+	if c.rememberAddress(username, address) {
+		c.notifyUser(username, "login from a new address "+address)
+	}
+
+	return nil
+}
+
+// Unlock forgets the failed logins of an account and of a client address (either may be empty),
+// so they can log in again straight away.  It returns whether either of them had any.
+func (c *Credentials) Unlock(username string, address string) bool {
+	return c.throttle.Unlock(username, address)
+}
+
+// HasPassword returns whether the user has a password (i.e. is a registered account).
+func (c *Credentials) HasPassword(username string) bool {
+	_, ok := c.store.Get(username)
+	return ok
+}
+
+// DeletePassword deletes a user's password (along with their two-factor authentication and login
+// addresses).
+func (c *Credentials) DeletePassword(username string) error {
+	for _, key := range []string{totpKey(username), addressesKey(username)} {
+		err := c.store.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.store.Delete(username)
+}
+
+// RenamePassword moves a user's password, two-factor authentication and login addresses (if they
+// have them) to their new username.
+func (c *Credentials) RenamePassword(username string, newUsername string) error {
+	keys := [][2]string{
+		{username, newUsername},
+		{totpKey(username), totpKey(newUsername)},
+		{addressesKey(username), addressesKey(newUsername)},
+	}
+
+	for _, key := range keys {
+		value, ok := c.store.Get(key[0])
+		if !ok {
+			continue
+		}
+
+		err := c.store.Put(key[1], value)
+		if err != nil {
+			return err
+		}
+
+		err = c.store.Delete(key[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addressesKey returns the key a user's recent login addresses are stored under (as with totpKey,
+// it's never a user's).
+func addressesKey(username string) string {
+	return "addresses " + username
+}
+
+// rememberAddress adds the address (if known) to the user's recent login addresses, returning
+// whether it's new to them.  The first address a user logs in from isn't new, as there's nothing to
+// compare it with.
+func (c *Credentials) rememberAddress(username string, address string) bool {
+	if address == "" {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	addresses := make([]string, 0)
+	if value, ok := c.store.Get(addressesKey(username)); ok {
+		addresses = strings.Split(value, ",")
+	}
+
+	known := len(addresses) == 0
+	for i := range addresses {
+		if addresses[i] == address {
+			addresses = append(addresses[:i], addresses[i+1:]...)
+			known = true
+			break
+		}
+	}
+
+	// Keep the most recent addresses, last
+	addresses = append(addresses, address)
+	if len(addresses) > maxAddresses {
+		addresses = addresses[len(addresses)-maxAddresses:]
+	}
+
+	err := c.store.Put(addressesKey(username), strings.Join(addresses, ","))
+	return err == nil && !known
+}
+
+// notifyUser tells a user about a security event on their account, if there's a notifier.
+func (c *Credentials) notifyUser(username string, text string) {
+	if c.notify != nil {
+		c.notify(username, text)
+	}
+}
+
+// LogStore keeps the hashes as model plugin data, which is persisted in the actions log.
+type LogStore struct {
+	store *model.PluginStore
+}
+
+// NewLogStore creates/initializes/returns a new LogStore for a model.
+func NewLogStore(m *model.Model) *LogStore {
+	logStore := LogStore{
+		store: m.PluginStore(Namespace),
+	}
+
+	return &logStore
+}
+
+// Get returns a user's hash.
+func (l *LogStore) Get(username string) (string, bool) {
+	return l.store.Get(username)
+}
+
+// Put sets a user's hash.
+func (l *LogStore) Put(username string, hash string) error {
+	l.store.Put(username, hash)
+	return nil
+}
+
+// Delete deletes a user's hash.
+func (l *LogStore) Delete(username string) error {
+	l.store.Delete(username)
+	return nil
+}
+
+// FileStore keeps the hashes in a JSON file (only readable by the server's user), which is
+// rewritten on every change.
+type FileStore struct {
+	path   string
+	hashes map[string]string
+	mutex  sync.Mutex
+}
+
+// NewFileStore creates/initializes/returns a new FileStore, reading the file if it exists.
+func NewFileStore(path string) (*FileStore, error) {
+	fileStore := FileStore{
+		path:   path,
+		hashes: make(map[string]string),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		err = json.Unmarshal(data, &fileStore.hashes)
+		if err != nil {
+			return nil, errors.New("invalid credentials file")
+		}
+	}
+
+	return &fileStore, nil
+}
+
+// Get returns a user's hash.
+func (f *FileStore) Get(username string) (string, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	hash, ok := f.hashes[username]
+	return hash, ok
+}
+
+// Put sets a user's hash.
+func (f *FileStore) Put(username string, hash string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.hashes[username] = hash
+	return f.write()
+}
+
+// Delete deletes a user's hash.
+func (f *FileStore) Delete(username string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.hashes[username]; !ok {
+		return nil
+	}
+
+	delete(f.hashes, username)
+	return f.write()
+}
+
+// write replaces the file (through a temporary file, so it's never left half written).
+func (f *FileStore) write() error {
+	data, err := json.MarshalIndent(f.hashes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(f.path+".tmp", data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.path+".tmp", f.path)
+}
+
+func encodeHash(iterations int, salt []byte, key []byte) string {
+	return "pbkdf2-sha256$" + strconv.Itoa(iterations) + "$" + base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(key)
+}
+
+func decodeHash(hash string) (int, []byte, []byte, error) {
+	fields := strings.Split(hash, "$")
+	if len(fields) != 4 || fields[0] != "pbkdf2-sha256" {
+		return 0, nil, nil, errors.New("invalid hash")
+	}
+
+	iterations, err := strconv.Atoi(fields[1])
+	if err != nil || iterations <= 0 {
+		return 0, nil, nil, errors.New("invalid hash")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return 0, nil, nil, errors.New("invalid hash")
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(fields[3])
+	if err != nil || len(key) != keySize {
+		return 0, nil, nil, errors.New("invalid hash")
+	}
+
+	return iterations, salt, key, nil
+}
+
+// pbkdf2 derives a key (a single SHA-256 sized block) from a password as specified in RFC 8018.
+func pbkdf2(password []byte, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	blockIndex := make([]byte, 4)
+	binary.BigEndian.PutUint32(blockIndex, 1)
+	mac.Write(blockIndex)
+
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+
+	return key
+}
@@ -0,0 +1,370 @@
+package credentials_test
+
+import (
+	"chatserver/credentials"
+	"chatserver/model"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestStore keeps the hashes in memory.
+type TestStore struct {
+	hashes map[string]string
+}
+
+func NewTestStore() *TestStore {
+	return &TestStore{hashes: make(map[string]string)}
+}
+
+func (t *TestStore) Get(username string) (string, bool) {
+	hash, ok := t.hashes[username]
+	return hash, ok
+}
+
+func (t *TestStore) Put(username string, hash string) error {
+	t.hashes[username] = hash
+	return nil
+}
+
+func (t *TestStore) Delete(username string) error {
+	delete(t.hashes, username)
+	return nil
+}
+
+func TestKnownHashes(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 test vectors for the password "password" and the salt "salt"
+	vectors := map[int]string{
+		1:    "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b",
+		2:    "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43",
+		4096: "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a",
+	}
+
+	testStore := NewTestStore()
+	testCredentials := credentials.New(testStore)
+	for iterations, hexKey := range vectors {
+		key, _ := hex.DecodeString(hexKey)
+		testStore.Put("user1", "pbkdf2-sha256$"+strconv.Itoa(iterations)+"$"+base64.RawStdEncoding.EncodeToString([]byte("salt"))+
+			"$"+base64.RawStdEncoding.EncodeToString(key))
+
+		if !testCredentials.CheckPassword("user1", "password") {
+			t.Error("Failed to check the password against a known hash with", iterations, "iterations")
+		}
+
+		if testCredentials.CheckPassword("user1", "passwore") {
+			t.Error("Accepted the wrong password against a known hash with", iterations, "iterations")
+		}
+	}
+}
+
+func TestSetAndCheckPassword(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create model")
+	}
+
+	testCredentials := credentials.New(credentials.NewLogStore(testModel))
+	if testCredentials.HasPassword("user1") || testCredentials.CheckPassword("user1", "") {
+		t.Error("User has a password before it's set")
+	}
+
+	if testCredentials.SetPassword("user1", "") == nil || testCredentials.SetPassword("", "password1") == nil {
+		t.Error("Set an empty username or password")
+	}
+
+	// Ensure that only the password that was set is accepted
+	if testCredentials.SetPassword("user1", "password1") != nil || !testCredentials.HasPassword("user1") {
+		t.Error("Failed to set password")
+	}
+
+	if !testCredentials.CheckPassword("user1", "password1") || testCredentials.CheckPassword("user1", "password2") ||
+		testCredentials.CheckPassword("user2", "password1") {
+		t.Error("Failed to check password")
+	}
+
+	// The hash is salted, so the same password is hashed differently
+	firstHash, _ := testModel.GetPluginData(credentials.Namespace, "user1")
+	testCredentials.SetPassword("user1", "password1")
+	secondHash, _ := testModel.GetPluginData(credentials.Namespace, "user1")
+	if firstHash == secondHash {
+		t.Error("Failed to salt the hash")
+	}
+
+	// Ensure that the password follows the user when renamed, and is gone when deleted
+	if testCredentials.RenamePassword("user1", "user2") != nil || testCredentials.HasPassword("user1") ||
+		!testCredentials.CheckPassword("user2", "password1") {
+		t.Error("Failed to rename password")
+	}
+
+	if testCredentials.DeletePassword("user2") != nil || testCredentials.HasPassword("user2") {
+		t.Error("Failed to delete password")
+	}
+}
+
+func TestMalformedHashes(t *testing.T) {
+	testStore := NewTestStore()
+	testCredentials := credentials.New(testStore)
+	testCredentials.SetPassword("user1", "password1")
+	valid, _ := testStore.Get("user1")
+
+	// Ensure that a malformed hash never accepts a password (though the user still has one)
+	salt := base64.RawStdEncoding.EncodeToString([]byte("salt"))
+	key := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+	malformed := []string{
+		"",
+		"password1",
+		valid + "$",
+		"pbkdf2-sha1$1000$" + salt + "$" + key,
+		"pbkdf2-sha256$0$" + salt + "$" + key,
+		"pbkdf2-sha256$-1$" + salt + "$" + key,
+		"pbkdf2-sha256$many$" + salt + "$" + key,
+		"pbkdf2-sha256$1000$!salt$" + key,
+		"pbkdf2-sha256$1000$" + salt + "$!key",
+		"pbkdf2-sha256$1000$" + salt + "$" + base64.RawStdEncoding.EncodeToString(make([]byte, 16)),
+	}
+
+	for _, hash := range malformed {
+		testStore.Put("user1", hash)
+		if testCredentials.CheckPassword("user1", "password1") || testCredentials.CheckPassword("user1", "") {
+			t.Errorf("Accepted a password against the malformed hash %q", hash)
+		}
+
+		if !testCredentials.HasPassword("user1") {
+			t.Errorf("User with the malformed hash %q has no password", hash)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "credentials.json")
+	fileStore, err := credentials.NewFileStore(path)
+	if err != nil {
+		t.Fatal("Failed to create FileStore")
+	}
+
+	testCredentials := credentials.New(fileStore)
+	testCredentials.SetPassword("user1", "password1")
+	testCredentials.SetPassword("user2", "password2")
+	testCredentials.DeletePassword("user2")
+
+	// The file is only readable by the server's user
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Error("Failed to write the file privately")
+	}
+
+	// Ensure that the passwords are kept when the file is read again
+	fileStore, err = credentials.NewFileStore(path)
+	if err != nil {
+		t.Fatal("Failed to reload FileStore")
+	}
+
+	testCredentials = credentials.New(fileStore)
+	if !testCredentials.CheckPassword("user1", "password1") || testCredentials.HasPassword("user2") {
+		t.Error("Failed to reload the passwords")
+	}
+
+	// An invalid file is an error, rather than losing the passwords
+	ioutil.WriteFile(path, []byte("{"), 0600)
+	if _, err := credentials.NewFileStore(path); err == nil {
+		t.Error("Loaded an invalid file")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	now := time.Now()
+	throttle := credentials.NewThrottle(func() time.Time {
+		return now
+	})
+
+	// Ensure that the first failed logins don't wait, and the ones after them do
+	for i := 0; i < 3; i++ {
+		if throttle.Begin("user1", "192.0.2.1") != nil {
+			t.Error("Throttled a login before the free failures were used up")
+		}
+	}
+
+	if throttle.Begin("user1", "192.0.2.2") != credentials.ErrThrottled || throttle.Begin("user2", "192.0.2.1") != credentials.ErrThrottled {
+		t.Error("Failed to throttle the account and the address")
+	}
+
+	if throttle.Begin("user2", "192.0.2.2") != nil {
+		t.Error("Throttled another account from another address")
+	}
+
+	// The wait doubles with each failure
+	now = now.Add(time.Second)
+	if throttle.Begin("user1", "") != nil {
+		t.Error("Failed to allow a login after the wait")
+	}
+
+	now = now.Add(time.Second)
+	if throttle.Begin("user1", "") != credentials.ErrThrottled {
+		t.Error("Failed to double the wait")
+	}
+
+	now = now.Add(time.Second)
+	if throttle.Begin("user1", "") != nil {
+		t.Error("Failed to allow a login after the doubled wait")
+	}
+
+	// Enough failures lock the account out until an admin unlocks it (or the lockout passes)
+	for i := 0; i < 5; i++ {
+		now = now.Add(5 * time.Minute)
+		throttle.Begin("user1", "")
+	}
+
+	now = now.Add(10 * time.Minute)
+	if throttle.Begin("user1", "") != credentials.ErrThrottled {
+		t.Error("Failed to lock the account out")
+	}
+
+	if !throttle.Unlock("user1", "") || throttle.Begin("user1", "") != nil {
+		t.Error("Failed to unlock the account")
+	}
+
+	now = now.Add(time.Hour)
+	if throttle.Begin("user2", "192.0.2.1") != nil {
+		t.Error("Failed to forget the failures after the lockout")
+	}
+
+	// A successful login forgets the account's failures
+	throttle.Begin("user3", "")
+	throttle.Begin("user3", "")
+	throttle.Succeeded("user3", "")
+	throttle.Begin("user3", "")
+	throttle.Begin("user3", "")
+	if throttle.Begin("user3", "") != nil {
+		t.Error("Failed to forget the failures after a login")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	testCredentials := credentials.New(NewTestStore())
+	testCredentials.SetPassword("user1", "password1")
+	if testCredentials.Login("user1", "password1", "", "192.0.2.1") != nil {
+		t.Error("Failed to log in")
+	}
+
+	for i := 0; i < 3; i++ {
+		if testCredentials.Login("user1", "password2", "", "192.0.2.1") != credentials.ErrInvalidLogin {
+			t.Error("Logged in with the wrong password")
+		}
+	}
+
+	// Ensure that even the right password is refused while the account waits, until it's unlocked
+	if testCredentials.Login("user1", "password1", "", "192.0.2.3") != credentials.ErrThrottled {
+		t.Error("Failed to throttle the logins")
+	}
+
+	if !testCredentials.Unlock("user1", "192.0.2.1") || testCredentials.Login("user1", "password1", "", "192.0.2.1") != nil {
+		t.Error("Failed to unlock the logins")
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 test vector (SHA1, the secret "12345678901234567890")
+	code, err := credentials.TOTP("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", time.Unix(59, 0))
+	if err != nil || code != "287082" {
+		t.Error("Failed to generate the TOTP code")
+	}
+
+	testCredentials := credentials.New(NewTestStore())
+	if _, _, err := testCredentials.EnableTOTP("user1"); err != credentials.ErrNotRegistered {
+		t.Error("Failed to refuse an unregistered account")
+	}
+
+	testCredentials.SetPassword("user1", "password1")
+	secret, codes, err := testCredentials.EnableTOTP("user1")
+	if err != nil || len(codes) == 0 || !testCredentials.HasTOTP("user1") {
+		t.Error("Failed to enable TOTP")
+	}
+
+	if testCredentials.Login("user1", "password1", "", "") != credentials.ErrInvalidLogin {
+		t.Error("Logged in without a code")
+	}
+
+	code, _ = credentials.TOTP(secret, time.Now())
+	if testCredentials.Login("user1", "password1", code, "") != nil {
+		t.Error("Failed to log in with a code")
+	}
+
+	// Ensure that a code can't be used twice
+	if testCredentials.Login("user1", "password1", code, "") != credentials.ErrInvalidLogin {
+		t.Error("Logged in with a used code")
+	}
+
+	// Ensure that a recovery code can be used once
+	if testCredentials.Login("user1", "password1", codes[0], "") != nil {
+		t.Error("Failed to log in with a recovery code")
+	}
+
+	if testCredentials.Login("user1", "password1", codes[0], "") != credentials.ErrInvalidLogin {
+		t.Error("Logged in with a used recovery code")
+	}
+
+	// Ensure that the TOTP follows the account's password
+	testCredentials.RenamePassword("user1", "user2")
+	if testCredentials.HasTOTP("user1") || !testCredentials.HasTOTP("user2") {
+		t.Error("Failed to rename TOTP")
+	}
+
+	testCredentials.DisableTOTP("user2")
+	if testCredentials.Login("user2", "password1", "", "") != nil {
+		t.Error("Failed to disable TOTP")
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	testCredentials := credentials.New(NewTestStore())
+	notifications := make([]string, 0)
+	testCredentials.SetNotifier(func(username string, text string) {
+		notifications = append(notifications, username+": "+text)
+	})
+
+	// Ensure that setting the first password isn't an event, but changing it is
+	testCredentials.SetPassword("user1", "password1")
+	testCredentials.SetPassword("user1", "password2")
+	if len(notifications) != 1 || notifications[0] != "user1: password changed" {
+		t.Error("Failed to notify of a password change")
+	}
+
+	// Ensure that only logins from a new address (after the first) are events
+	testCredentials.Login("user1", "password2", "", "192.0.2.1")
+	testCredentials.Login("user1", "password2", "", "192.0.2.1")
+	testCredentials.Login("user1", "password2", "", "")
+	if len(notifications) != 1 {
+		t.Error("Notified of a login from a known address")
+	}
+
+	testCredentials.Login("user1", "password2", "", "192.0.2.2")
+	if len(notifications) != 2 || notifications[1] != "user1: login from a new address 192.0.2.2" {
+		t.Error("Failed to notify of a login from a new address")
+	}
+
+	// Ensure that the addresses follow the account's password
+	testCredentials.RenamePassword("user1", "user2")
+	testCredentials.Login("user2", "password2", "", "192.0.2.1")
+	if len(notifications) != 2 {
+		t.Error("Failed to rename the login addresses")
+	}
+
+	testCredentials.EnableTOTP("user2")
+	testCredentials.DisableTOTP("user2")
+	testCredentials.DisableTOTP("user2")
+	if len(notifications) != 4 || notifications[3] != "user2: two-factor authentication disabled" {
+		t.Error("Failed to notify of two-factor authentication being disabled")
+	}
+}
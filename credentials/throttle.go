@@ -0,0 +1,158 @@
+package credentials
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// freeFailures is the number of failed logins in a row allowed without waiting.
+const freeFailures int = 3
+
+// baseDelay is the wait after the first failed login past the free ones, doubling with each one
+// after it (up to maxDelay).
+const baseDelay time.Duration = time.Second
+const maxDelay time.Duration = 5 * time.Minute
+
+// lockoutFailures is the number of failed logins in a row that locks the account (or address) out
+// for lockoutDuration.  Failures are forgotten once there have been none for lockoutDuration.
+const lockoutFailures int = 10
+const lockoutDuration time.Duration = 15 * time.Minute
+
+// maxTracked bounds the accounts (and addresses) with failures kept before the forgotten ones are
+// dropped.
+const maxTracked int = 10000
+
+// ErrThrottled is returned when a login is tried too soon after failed ones (see Throttle).
+var ErrThrottled = errors.New("too many failed logins, try again later")
+
+// failures tracks the failed logins in a row of an account or address.
+type failures struct {
+	count int
+	last  time.Time
+}
+
+// Throttle tracks the failed logins of each account and of each client address, so password
+// guessing slows down: after the free failures, each one doubles the wait before the next login
+// can be tried, and enough of them lock the account or address out for a while (until an admin
+// unlocks it).
+type Throttle struct {
+	mutex     sync.Mutex
+	clock     func() time.Time
+	accounts  map[string]*failures
+	addresses map[string]*failures
+}
+
+// NewThrottle creates/initializes/returns a new Throttle using the given clock.
+func NewThrottle(clock func() time.Time) *Throttle {
+	throttle := Throttle{
+		clock:     clock,
+		accounts:  make(map[string]*failures),
+		addresses: make(map[string]*failures),
+	}
+
+	return &throttle
+}
+
+// Begin starts a login for an account from an address (empty if unknown), returning ErrThrottled
+// if either of them has to wait.  The login is counted as failed until Succeeded is called, so
+// logins tried at the same time can't get around the wait.
+func (t *Throttle) Begin(username string, address string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.clock()
+	if t.waiting(t.accounts, username, now) || (address != "" && t.waiting(t.addresses, address, now)) {
+		return ErrThrottled
+	}
+
+	t.fail(t.accounts, username, now)
+	if address != "" {
+		t.fail(t.addresses, address, now)
+	}
+
+	return nil
+}
+
+// Succeeded forgets the failed logins of an account once a login begun for it succeeded, and takes
+// the login back off the address's failures.
+func (t *Throttle) Succeeded(username string, address string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.accounts, username)
+	if tracked, ok := t.addresses[address]; ok {
+		tracked.count--
+		if tracked.count <= 0 {
+			delete(t.addresses, address)
+		}
+	}
+}
+
+// Unlock forgets the failed logins of an account and of an address (either may be empty),
+// returning whether either of them had any.
+func (t *Throttle) Unlock(username string, address string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	_, accountOK := t.accounts[username]
+	_, addressOK := t.addresses[address]
+	delete(t.accounts, username)
+	delete(t.addresses, address)
+
+	return accountOK || addressOK
+}
+
+// waiting returns whether the account or address has to wait before its next login.  The lock
+// must be held.
+func (t *Throttle) waiting(tracked map[string]*failures, key string, now time.Time) bool {
+	entry, ok := tracked[key]
+	if !ok {
+		return false
+	}
+
+	if now.Sub(entry.last) > lockoutDuration {
+		delete(tracked, key)
+		return false
+	}
+
+	return now.Before(entry.last.Add(delay(entry.count)))
+}
+
+// fail counts a failed login for the account or address.  The lock must be held.
+func (t *Throttle) fail(tracked map[string]*failures, key string, now time.Time) {
+	if _, ok := tracked[key]; !ok && len(tracked) >= maxTracked {
+		for trackedKey, entry := range tracked {
+			if now.Sub(entry.last) > lockoutDuration {
+				delete(tracked, trackedKey)
+			}
+		}
+	}
+
+	entry, ok := tracked[key]
+	if !ok {
+		entry = &failures{}
+		tracked[key] = entry
+	}
+
+	entry.count++
+	entry.last = now
+}
+
+// delay returns the wait after a number of failed logins in a row.
+func delay(count int) time.Duration {
+	if count < freeFailures {
+		return 0
+	}
+
+	if count >= lockoutFailures {
+		return lockoutDuration
+	}
+
+	wait := baseDelay << uint(count-freeFailures)
+	if wait > maxDelay {
+		wait = maxDelay
+	}
+
+	return wait
+}
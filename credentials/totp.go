@@ -0,0 +1,201 @@
+package credentials
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// totpStep is the time each TOTP code is valid for, and totpSkew the number of steps either side of
+// the current one also accepted (for clients whose clocks are a little off).
+const totpStep time.Duration = 30 * time.Second
+const totpSkew int64 = 1
+
+const totpDigits int = 6
+const totpSecretSize int = 20
+
+// recoveryCodes is the number of single-use recovery codes given when TOTP is enabled (for logging
+// in without the authenticator).
+const recoveryCodes int = 8
+const recoveryCodeSize int = 5
+
+// ErrNotRegistered is returned when two-factor authentication is set up for a user without a
+// password.
+var ErrNotRegistered = errors.New("not a registered account")
+
+// ErrTOTPEnabled is returned when TOTP is enabled for a user that already has it.
+var ErrTOTPEnabled = errors.New("two-factor authentication already enabled")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpKey returns the key a user's TOTP secret and recovery codes are stored under (usernames can't
+// contain spaces, so it's never a user's).
+func totpKey(username string) string {
+	return "totp " + username
+}
+
+// EnableTOTP enables two-factor authentication for a registered account, returning its new TOTP
+// secret (base32 encoded, for the user's authenticator) and single-use recovery codes.  From then
+// on, logging in needs a code from the authenticator (or one of the recovery codes) as well as the
+// password.
+func (c *Credentials) EnableTOTP(username string) (string, []string, error) {
+	if !c.HasPassword(username) {
+		return "", nil, ErrNotRegistered
+	}
+
+	if c.HasTOTP(username) {
+		return "", nil, ErrTOTPEnabled
+	}
+
+	secret := make([]byte, totpSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", nil, err
+	}
+
+	codes := make([]string, recoveryCodes)
+	hashes := make([]string, recoveryCodes)
+	for i := range codes {
+		code := make([]byte, recoveryCodeSize)
+		_, err := rand.Read(code)
+		if err != nil {
+			return "", nil, err
+		}
+
+		codes[i] = strings.ToLower(totpEncoding.EncodeToString(code))
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	encodedSecret := totpEncoding.EncodeToString(secret)
+	err = c.store.Put(totpKey(username), encodedSecret+"$"+strings.Join(hashes, ","))
+	if err != nil {
+		return "", nil, err
+	}
+
+	c.notifyUser(username, "two-factor authentication enabled")
+	return encodedSecret, codes, nil
+}
+
+// DisableTOTP disables two-factor authentication for a user (e.g. when an admin resets an account
+// whose authenticator was lost).
+func (c *Credentials) DisableTOTP(username string) error {
+	if !c.HasTOTP(username) {
+		return nil
+	}
+
+	err := c.store.Delete(totpKey(username))
+	if err != nil {
+		return err
+	}
+
+	c.notifyUser(username, "two-factor authentication disabled")
+	return nil
+}
+
+// HasTOTP returns whether a user has two-factor authentication enabled.
+func (c *Credentials) HasTOTP(username string) bool {
+	_, ok := c.store.Get(totpKey(username))
+	return ok
+}
+
+// CheckCode returns whether a code is the user's current TOTP code (which can then no longer be
+// used) or one of their recovery codes (which is then used up).  It's false if they don't have
+// two-factor authentication enabled.
+func (c *Credentials) CheckCode(username string, code string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	record, ok := c.store.Get(totpKey(username))
+	if !ok {
+		return false
+	}
+
+	fields := strings.SplitN(record, "$", 2)
+	if len(fields) != 2 {
+		return false
+	}
+
+	// A TOTP code, from a step after the last one used (so an overheard code can't be used again)
+	step := time.Now().Unix() / int64(totpStep/time.Second)
+	for offset := -totpSkew; offset <= totpSkew; offset++ {
+		if step+offset <= c.usedSteps[username] {
+			continue
+		}
+
+		expected, err := totpCode(fields[0], step+offset)
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			c.usedSteps[username] = step + offset
+			return true
+		}
+	}
+
+	// A recovery code, which is used up
+	hashes := strings.Split(fields[1], ",")
+	hash := hashRecoveryCode(code)
+	for i := range hashes {
+		if subtle.ConstantTimeCompare([]byte(hashes[i]), []byte(hash)) == 1 {
+			hashes = append(hashes[:i], hashes[i+1:]...)
+			return c.store.Put(totpKey(username), fields[0]+"$"+strings.Join(hashes, ",")) == nil
+		}
+	}
+
+	return false
+}
+
+// TOTP returns the TOTP code (RFC 6238, HMAC-SHA1 with 30 second steps and 6 digits, as
+// authenticator apps expect) for a base32 encoded secret at a time.
+func TOTP(secret string, at time.Time) (string, error) {
+	return totpCode(secret, at.Unix()/int64(totpStep/time.Second))
+}
+
+// TOTPURI returns the otpauth URI for enrolling a user's TOTP secret in an authenticator (usually
+// shown as a QR code).
+func TOTPURI(issuer string, username string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+username) + "?" + query.Encode()
+}
+
+// totpCode returns the HOTP code (RFC 4226) for a base32 encoded secret and a counter.
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", errors.New("invalid secret")
+	}
+
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulus *= 10
+	}
+
+	code := strconv.FormatUint(uint64(value%modulus), 10)
+	return strings.Repeat("0", totpDigits-len(code)) + code, nil
+}
+
+// hashRecoveryCode returns the hash a recovery code is stored as.
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(code)))
+	return hex.EncodeToString(sum[:])
+}
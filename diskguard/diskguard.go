@@ -0,0 +1,290 @@
+// Package diskguard watches the free disk space and the size of the actions log, so the server
+// degrades gracefully rather than failing part way through a change when the disk fills up.  As the
+// configured thresholds are crossed it alerts the admins (with a system message and a webhook),
+// compacts the log and switches the model to read-only until there's space again.
+package diskguard
+
+import (
+	"bytes"
+	"chatserver/model"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Options provides the thresholds the Guard acts on.  Zero thresholds are disabled.
+type Options struct {
+	// AlertFreeBytes alerts the admins when the free space drops below it
+	AlertFreeBytes uint64
+
+	// CompactFreeBytes compacts the log when the free space drops below it
+	CompactFreeBytes uint64
+
+	// ReadOnlyFreeBytes switches the model to read-only while the free space is below it
+	ReadOnlyFreeBytes uint64
+
+	// AlertLogBytes alerts the admins when the log grows beyond it
+	AlertLogBytes int64
+
+	// CompactLogBytes compacts the log when it grows beyond it
+	CompactLogBytes int64
+
+	// AlertChannelname is the channel the built-in user posts the alerts to (defaults to the
+	// built-in channel)
+	AlertChannelname string
+
+	// WebhookURL is sent each alert as a JSON POST (empty for none)
+	WebhookURL string
+
+	// FreeSpace returns the space available on the file system holding a path (defaults to the
+	// space available to unprivileged users, so tests can fake it)
+	FreeSpace func(path string) (uint64, error)
+}
+
+// Alert is a single alert, as sent to the webhook.
+type Alert struct {
+	Kind      string    `json:"kind"`
+	Message   string    `json:"message"`
+	FreeBytes uint64    `json:"freeBytes"`
+	LogBytes  int64     `json:"logBytes"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Status provides the latest free space and log size checked, and whether the model is read-only.
+type Status struct {
+	FreeBytes uint64
+	LogBytes  int64
+	ReadOnly  bool
+}
+
+// Guard checks the disk space and log size (see Check) and acts on the thresholds crossed.
+type Guard struct {
+	model       *model.Model
+	logFilePath string
+	compact     func() error
+	options     Options
+	mutex       sync.Mutex
+	raised      map[string]bool
+	readOnly    bool
+	compacted   int64
+	status      Status
+}
+
+// NewGuard creates/initializes/returns a new Guard for the actions log at a path.  The compact
+// function compacts the log (e.g. the model's Compact with the Logger's).
+func NewGuard(chatModel *model.Model, logFilePath string, compact func() error, options Options) *Guard {
+	if options.AlertChannelname == "" {
+		options.AlertChannelname = chatModel.BuiltinChannelname()
+	}
+
+	if options.FreeSpace == nil {
+		options.FreeSpace = freeSpace
+	}
+
+	guard := Guard{
+		model:       chatModel,
+		logFilePath: logFilePath,
+		compact:     compact,
+		options:     options,
+		raised:      make(map[string]bool),
+	}
+
+	return &guard
+}
+
+// Check checks the free space and the log size against the thresholds.  The alerts are only sent
+// when a threshold is first crossed (and again if it's crossed again after recovering).  The log
+// is compacted when it's over a threshold, unless it hasn't grown by half since it was last
+// compacted (when there's little left to gain).
+func (g *Guard) Check() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	freeBytes, logBytes, err := g.measure()
+	if err != nil {
+		log.Println("diskguard:", err)
+		return
+	}
+
+	g.raise("low_disk_space", g.options.AlertFreeBytes > 0 && freeBytes < g.options.AlertFreeBytes,
+		fmt.Sprintf("free disk space is low: %s left", formatBytes(int64(freeBytes))), freeBytes, logBytes)
+	g.raise("large_log", g.options.AlertLogBytes > 0 && logBytes > g.options.AlertLogBytes,
+		fmt.Sprintf("the actions log is large: %s", formatBytes(logBytes)), freeBytes, logBytes)
+
+	compactForSpace := g.options.CompactFreeBytes > 0 && freeBytes < g.options.CompactFreeBytes
+	compactForSize := g.options.CompactLogBytes > 0 && logBytes > g.options.CompactLogBytes
+	if (compactForSpace || compactForSize) && logBytes >= g.compacted+g.compacted/2 {
+		g.compactLog(freeBytes, logBytes)
+
+		freeBytes, logBytes, err = g.measure()
+		if err != nil {
+			log.Println("diskguard:", err)
+			return
+		}
+	}
+
+	// The alert is posted before switching to read-only (it couldn't be posted after)
+	lowSpace := g.options.ReadOnlyFreeBytes > 0 && freeBytes < g.options.ReadOnlyFreeBytes
+	if lowSpace && !g.readOnly {
+		g.alert("read_only", fmt.Sprintf("the server is read-only until there's more disk space: %s left", formatBytes(int64(freeBytes))), freeBytes, logBytes, true)
+		g.model.SetReadOnly(true)
+		g.readOnly = true
+	} else if !lowSpace && g.readOnly {
+		g.model.SetReadOnly(false)
+		g.readOnly = false
+		g.alert("writable", fmt.Sprintf("the server is writable again: %s left", formatBytes(int64(freeBytes))), freeBytes, logBytes, true)
+	}
+}
+
+// Compact compacts the log now (e.g. when requested by an admin), and returns the error if it
+// couldn't be compacted.
+func (g *Guard) Compact() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	freeBytes, logBytes, err := g.measure()
+	if err != nil {
+		return err
+	}
+
+	return g.compactLog(freeBytes, logBytes)
+}
+
+// Status returns the free space and log size from the latest check.
+func (g *Guard) Status() Status {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	status := g.status
+	status.ReadOnly = g.model.IsReadOnly()
+
+	return status
+}
+
+// LogWriteFailed switches the model to read-only after an action couldn't be written to the log
+// (it's the Logger's error handler), so the change is rejected as read-only and the changes that
+// follow aren't lost as well.  The model
+// stays read-only until an admin makes it writable again, or there's enough space again after
+// Check switched it to read-only for being short of space.  It's called while the model is
+// locked, so the alert is only logged and sent to the webhook.
+func (g *Guard) LogWriteFailed(err error) {
+	g.model.SetReadOnly(true)
+	g.alert("log_write_failed", "the server is read-only after failing to write to the actions log: "+err.Error(), 0, 0, false)
+}
+
+// measure returns the free space and the log size (recording them for Status).  The lock must be
+// held.
+func (g *Guard) measure() (uint64, int64, error) {
+	freeBytes, err := g.options.FreeSpace(filepath.Dir(g.logFilePath))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var logBytes int64
+	info, err := os.Stat(g.logFilePath)
+	if err == nil {
+		logBytes = info.Size()
+	} else if !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+
+	g.status.FreeBytes = freeBytes
+	g.status.LogBytes = logBytes
+
+	return freeBytes, logBytes, nil
+}
+
+// compactLog compacts the log and alerts the admins with the outcome.  The lock must be held.
+func (g *Guard) compactLog(freeBytes uint64, logBytes int64) error {
+	// A failure is only alerted the first time (until compacting succeeds), as it's likely to fail
+	// again at every check
+	err := g.compact()
+	g.raise("compaction_failed", err != nil, "failed to compact the actions log: "+fmt.Sprint(err), freeBytes, logBytes)
+	if err != nil {
+		return err
+	}
+
+	compactedFreeBytes, compactedLogBytes, err := g.measure()
+	if err != nil {
+		return err
+	}
+	g.compacted = compactedLogBytes
+
+	// Compacting is routine, so it isn't posted to the alert channel
+	g.alert("compacted", fmt.Sprintf("compacted the actions log from %s to %s", formatBytes(logBytes), formatBytes(compactedLogBytes)), compactedFreeBytes, compactedLogBytes, false)
+	return nil
+}
+
+// raise sends an alert when its condition first becomes true.  The lock must be held.
+func (g *Guard) raise(kind string, condition bool, message string, freeBytes uint64, logBytes int64) {
+	if condition && !g.raised[kind] {
+		g.alert(kind, message, freeBytes, logBytes, true)
+	}
+	g.raised[kind] = condition
+}
+
+// alert logs an alert, sends it to the webhook, and (if asked to) posts it to the alert channel as
+// the built-in user.
+func (g *Guard) alert(kind string, message string, freeBytes uint64, logBytes int64, post bool) {
+	log.Println("diskguard:", message)
+
+	if post {
+		_, err := g.model.PostMessage(g.options.AlertChannelname, g.model.BuiltinUsername(), time.Time{}, "[disk] "+message)
+		if err != nil {
+			log.Println("diskguard: failed to post alert:", err)
+		}
+	}
+
+	if g.options.WebhookURL == "" {
+		return
+	}
+
+	alert := Alert{
+		Kind:      kind,
+		Message:   message,
+		FreeBytes: freeBytes,
+		LogBytes:  logBytes,
+		Timestamp: time.Now().UTC(),
+	}
+
+	go func() {
+		body, err := json.Marshal(alert)
+		if err != nil {
+			log.Println("diskguard:", err)
+			return
+		}
+
+		response, err := http.Post(g.options.WebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Println("diskguard: webhook:", err)
+			return
+		}
+		response.Body.Close()
+
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			log.Println("diskguard: webhook failed with status", response.Status)
+		}
+	}()
+}
+
+// freeSpace returns the space available to unprivileged users on the file system holding a path.
+func freeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// formatBytes formats a number of bytes in MB (the unit of the config thresholds).
+func formatBytes(numBytes int64) string {
+	return fmt.Sprintf("%.1f MB", float64(numBytes)/(1024*1024))
+}
@@ -0,0 +1,146 @@
+package diskguard_test
+
+import (
+	"chatserver/diskguard"
+	"chatserver/model"
+	"chatserver/model/actions"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newGuard returns a model logging to a temp file, and a guard for it that reports the free space
+// given and counts the compactions.
+func newGuard(t *testing.T, freeBytes *uint64, compactions *int, options diskguard.Options) (*model.Model, *diskguard.Guard, func()) {
+	tempFile, err := ioutil.TempFile("", "test.*.txt")
+	if err != nil {
+		t.Fatal("Couldn't create temp file")
+	}
+	tempFile.Close()
+
+	logger, err := actions.NewLogger(tempFile.Name())
+	if err != nil {
+		t.Fatal("Failed to create Logger")
+	}
+
+	testModel, err := model.NewModel(model.Options{}, nil, logger, nil)
+	if err != nil {
+		t.Fatal("Failed to create model")
+	}
+
+	options.FreeSpace = func(path string) (uint64, error) {
+		return *freeBytes, nil
+	}
+	guard := diskguard.NewGuard(testModel, tempFile.Name(), func() error {
+		*compactions++
+		return testModel.Compact(logger.Compact)
+	}, options)
+	logger.SetErrorHandler(guard.LogWriteFailed)
+
+	return testModel, guard, func() { os.Remove(tempFile.Name()) }
+}
+
+// alerts returns the alerts posted to the built-in channel.
+func alerts(testModel *model.Model) []string {
+	posted := make([]string, 0)
+	for _, message := range testModel.GetChannelHistory(testModel.BuiltinChannelname(), testModel.BuiltinUsername(), -1) {
+		if strings.HasPrefix(message.Text, "[disk] ") {
+			posted = append(posted, message.Text)
+		}
+	}
+
+	return posted
+}
+
+func TestReadOnly(t *testing.T) {
+	freeBytes := uint64(100)
+	compactions := 0
+	testModel, guard, cleanup := newGuard(t, &freeBytes, &compactions, diskguard.Options{AlertFreeBytes: 50, ReadOnlyFreeBytes: 10})
+	defer cleanup()
+
+	guard.Check()
+	if len(alerts(testModel)) != 0 || testModel.IsReadOnly() {
+		t.Error("Guard acted with enough free space")
+	}
+
+	// The alert is only sent once while the space stays low
+	freeBytes = 40
+	guard.Check()
+	guard.Check()
+	if len(alerts(testModel)) != 1 || testModel.IsReadOnly() {
+		t.Error("Guard didn't alert (once) for low space")
+	}
+
+	freeBytes = 5
+	guard.Check()
+	if !testModel.IsReadOnly() || len(alerts(testModel)) != 2 || !guard.Status().ReadOnly || guard.Status().FreeBytes != 5 {
+		t.Error("Guard didn't switch to read-only")
+	}
+
+	if testModel.CreateUser("user1") != model.ErrReadOnly {
+		t.Error("Model accepted a change while read-only")
+	}
+
+	freeBytes = 100
+	guard.Check()
+	if testModel.IsReadOnly() || testModel.CreateUser("user1") != nil {
+		t.Error("Guard didn't leave read-only")
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	freeBytes := uint64(100)
+	compactions := 0
+	testModel, guard, cleanup := newGuard(t, &freeBytes, &compactions, diskguard.Options{CompactLogBytes: 1000})
+	defer cleanup()
+
+	// Edits are replaced by the edited message when compacting
+	message, _ := testModel.PostMessage(testModel.BuiltinChannelname(), testModel.BuiltinUsername(), testModel.Now(), "message")
+	for i := 0; i < 20; i++ {
+		testModel.EditMessage(testModel.BuiltinChannelname(), message.ID, testModel.BuiltinUsername(), "edited message")
+	}
+
+	guard.Check()
+	if compactions != 1 || guard.Status().LogBytes >= 1000 {
+		t.Fatal("Guard didn't compact the log")
+	}
+
+	// The log isn't compacted again until it's grown by half
+	guard.Check()
+	if compactions != 1 {
+		t.Error("Guard compacted the log again without it growing")
+	}
+
+	for i := 0; i < 20; i++ {
+		testModel.EditMessage(testModel.BuiltinChannelname(), message.ID, testModel.BuiltinUsername(), "edited message")
+	}
+
+	guard.Check()
+	if compactions != 2 {
+		t.Error("Guard didn't compact the log again after it grew")
+	}
+
+	if guard.Compact() != nil || compactions != 3 {
+		t.Error("Failed to compact on request")
+	}
+}
+
+func TestLogWriteFailed(t *testing.T) {
+	freeBytes := uint64(100)
+	compactions := 0
+	testModel, guard, cleanup := newGuard(t, &freeBytes, &compactions, diskguard.Options{})
+	defer cleanup()
+
+	guard.LogWriteFailed(errors.New("no space left on device"))
+	if !testModel.IsReadOnly() {
+		t.Error("Guard didn't switch to read-only after a failed write")
+	}
+
+	// It stays read-only until an admin makes it writable
+	guard.Check()
+	if !testModel.IsReadOnly() {
+		t.Error("Guard left read-only after a failed write")
+	}
+}
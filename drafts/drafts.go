@@ -0,0 +1,104 @@
+// Package drafts provides per-user drafts of the messages being composed in each channel, so a
+// draft survives a web client reloading and follows the user to their other devices.  They're kept
+// as model plugin data (a JSON object of the drafts by channel, keyed by username), so they persist
+// with the rest of the state.
+package drafts
+
+import (
+	"chatserver/model"
+	"encoding/json"
+	"errors"
+	"unicode/utf8"
+)
+
+// Namespace is the plugin data namespace the drafts are stored in.
+const Namespace string = "drafts"
+
+// MaxLength is the longest draft (in characters).
+const MaxLength int = 4000
+
+// ErrTooLong is returned when a draft is longer than MaxLength.
+var ErrTooLong = errors.New("draft too long")
+
+// Get returns a user's drafts by channel (empty if they have none).
+func Get(m *model.Model, username string) map[string]string {
+	drafts := make(map[string]string)
+
+	value, ok := m.PluginStore(Namespace).Get(username)
+	if !ok {
+		return drafts
+	}
+
+	// Drafts that can't be read are as good as lost, so they're dropped rather than failing
+	if json.Unmarshal([]byte(value), &drafts) != nil {
+		return make(map[string]string)
+	}
+
+	return drafts
+}
+
+// Set sets a user's draft for a channel (an empty draft deletes it).  The user and the channel
+// must exist.
+func Set(m *model.Model, username string, channelname string, text string) error {
+	if m.GetUserInfo(username).Name == "" {
+		return model.ErrUserNotFound
+	}
+
+	if _, ok := m.GetChannels()[channelname]; !ok {
+		return model.ErrChannelNotFound
+	}
+
+	if utf8.RuneCountInString(text) > MaxLength {
+		return ErrTooLong
+	}
+
+	drafts := Get(m, username)
+	if drafts[channelname] == text {
+		return nil
+	}
+
+	if text == "" {
+		delete(drafts, channelname)
+	} else {
+		drafts[channelname] = text
+	}
+
+	return put(m, username, drafts)
+}
+
+// Rename moves the drafts of a user to their new username.
+func Rename(m *model.Model, username string, newUsername string) {
+	store := m.PluginStore(Namespace)
+	if value, ok := store.Get(username); ok {
+		store.Put(newUsername, value)
+		store.Delete(username)
+	}
+}
+
+// RenameChannel moves the drafts for a channel to its new name.
+func RenameChannel(m *model.Model, channelname string, newChannelname string) {
+	for username := range m.PluginStore(Namespace).Keys() {
+		drafts := Get(m, username)
+		if text, ok := drafts[channelname]; ok {
+			delete(drafts, channelname)
+			drafts[newChannelname] = text
+			put(m, username, drafts)
+		}
+	}
+}
+
+// put stores a user's drafts (deleting the key once they have none).
+func put(m *model.Model, username string, drafts map[string]string) error {
+	if len(drafts) == 0 {
+		m.PluginStore(Namespace).Delete(username)
+		return nil
+	}
+
+	value, err := json.Marshal(drafts)
+	if err != nil {
+		return err
+	}
+
+	m.PluginStore(Namespace).Put(username, string(value))
+	return nil
+}
@@ -0,0 +1,217 @@
+// Package events provides an optional stream of anonymized activity events (e.g. message_posted,
+// user_created, channel_switched) for external analytics pipelines.  The stream is decoupled from
+// the actions log: events are written asynchronously to a sink (a JSON lines file or a NATS
+// subject) and are dropped rather than slowing down the server if the sink can't keep up.
+package events
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// maxPendingEvents bounds the events buffered for the sink.
+const maxPendingEvents int = 1000
+
+// Event is a single anonymized activity event.
+type Event struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	User      string    `json:"user,omitempty"`
+	Channel   string    `json:"channel,omitempty"`
+}
+
+// Sink provides an interface for event destinations.
+type Sink interface {
+	Write(event Event) error
+}
+
+// Stream anonymizes events and forwards them to a sink.  It satisfies the model EventEmitter
+// interface.
+type Stream struct {
+	sink   Sink
+	salt   []byte
+	events chan Event
+}
+
+// NewStream creates/initializes/returns a new Stream writing to the given sink.  Usernames are
+// replaced by a keyed hash using the salt, so events for the same user can be correlated without
+// revealing who they are.
+func NewStream(sink Sink, salt string) *Stream {
+	stream := Stream{
+		sink:   sink,
+		salt:   []byte(salt),
+		events: make(chan Event, maxPendingEvents),
+	}
+
+	go func() {
+		for event := range stream.events {
+			err := stream.sink.Write(event)
+			if err != nil {
+				log.Println("events:", err)
+			}
+		}
+	}()
+
+	return &stream
+}
+
+// Emit queues an event for the sink.
+func (s *Stream) Emit(eventType string, username string, channelname string) {
+	event := Event{
+		Type:      eventType,
+		Timestamp: time.Now().UTC(),
+		Channel:   channelname,
+	}
+
+	if username != "" {
+		mac := hmac.New(sha256.New, s.salt)
+		mac.Write([]byte(username))
+		event.User = hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+
+	select {
+	case s.events <- event:
+	default:
+		// The sink is falling behind, drop the event
+	}
+}
+
+// NewSink creates the sink for a config sink type ("file" or "nats") and target (the file path, or
+// the NATS server address).  The NATS subject is only used by the "nats" sink.
+func NewSink(sinkType string, target string, subject string) (Sink, error) {
+	switch sinkType {
+	case "file":
+		return NewFileSink(target)
+	case "nats":
+		return NewNATSSink(target, subject)
+	default:
+		return nil, errors.New("invalid events sink")
+	}
+}
+
+// FileSink appends events to a file as JSON lines.
+type FileSink struct {
+	file *os.File
+}
+
+// NewFileSink creates/initializes/returns a new FileSink appending to the file at the given path.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := FileSink{
+		file: file,
+	}
+
+	return &sink, nil
+}
+
+// Write appends an event to the file.
+func (f *FileSink) Write(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.file.Write(append(data, '\n'))
+	return err
+}
+
+// NATSSink publishes events as JSON to a NATS subject (using the NATS text protocol, so no client
+// library is required).  The connection is re-established on the next event if it fails.
+type NATSSink struct {
+	address string
+	subject string
+	conn    net.Conn
+	writer  *bufio.Writer
+}
+
+// NewNATSSink creates/initializes/returns a new NATSSink publishing to the given subject on the
+// NATS server at the given address (host:port).
+func NewNATSSink(address string, subject string) (*NATSSink, error) {
+	if subject == "" {
+		return nil, errors.New("invalid events subject")
+	}
+
+	sink := NATSSink{
+		address: address,
+		subject: subject,
+	}
+
+	err := sink.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sink, nil
+}
+
+// Write publishes an event to the subject.
+func (n *NATSSink) Write(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if n.conn == nil {
+		err := n.connect()
+		if err != nil {
+			return err
+		}
+	}
+
+	n.writer.WriteString("PUB " + n.subject + " " + strconv.Itoa(len(data)) + "\r\n")
+	n.writer.Write(data)
+	n.writer.WriteString("\r\n")
+	err = n.writer.Flush()
+	if err != nil {
+		n.conn.Close()
+		n.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+func (n *NATSSink) connect() error {
+	conn, err := net.Dial("tcp", n.address)
+	if err != nil {
+		return err
+	}
+
+	// The server responds to PINGs with PONG, anything else it sends (INFO, +OK, PING) is not
+	// needed, so just discard it
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "PING\r\n" {
+				conn.Write([]byte("PONG\r\n"))
+			}
+		}
+	}()
+
+	_, err = conn.Write([]byte("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"chatserver\"}\r\n"))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	n.conn = conn
+	n.writer = bufio.NewWriter(conn)
+	return nil
+}
@@ -0,0 +1,254 @@
+// Package export renders a channel's full history into a self-contained static HTML bundle (pages of
+// messages, a stylesheet and a client-side search index) that can be archived or published without
+// the chat server.
+package export
+
+import (
+	"bytes"
+	"chatserver/model"
+	"chatserver/storage"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// messagesPerPage is the number of messages rendered on each page of the bundle.
+const messagesPerPage int = 100
+
+// Message is a single message as rendered in the bundle.
+type Message struct {
+	ID        string `json:"id"`
+	Page      int    `json:"page"`
+	Author    string `json:"author"`
+	Timestamp string `json:"timestamp"`
+	Text      string `json:"text"`
+	Language  string `json:"language,omitempty"`
+}
+
+// Page is a single page of messages in the bundle.
+type Page struct {
+	Channel  model.ChannelInfo
+	Number   int
+	NumPages int
+	Messages []Message
+}
+
+// Filename returns the name of the page's file.
+func (p Page) Filename() string {
+	return pageFilename(p.Number)
+}
+
+// PreviousFilename returns the name of the previous page's file (empty on the first page).
+func (p Page) PreviousFilename() string {
+	if p.Number <= 1 {
+		return ""
+	}
+
+	return pageFilename(p.Number - 1)
+}
+
+// NextFilename returns the name of the next page's file (empty on the last page).
+func (p Page) NextFilename() string {
+	if p.Number >= p.NumPages {
+		return ""
+	}
+
+	return pageFilename(p.Number + 1)
+}
+
+// WriteChannel writes the bundle for a channel into a directory (created if needed).  The full
+// history is exported, unfiltered by any user's blocked users.
+func WriteChannel(m *model.Model, channelname string, dir string) error {
+	if _, ok := m.GetChannels()[channelname]; !ok {
+		return errors.New("channel not found")
+	}
+
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return writeChannel(m, channelname, func(filename string, contents []byte) error {
+		return ioutil.WriteFile(filepath.Join(dir, filename), contents, 0644)
+	})
+}
+
+// PutChannel writes the bundle for a channel to objects in a store, with the file names appended
+// to a key prefix (e.g. "exports/General/").
+func PutChannel(m *model.Model, channelname string, store storage.Store, prefix string) error {
+	if _, ok := m.GetChannels()[channelname]; !ok {
+		return errors.New("channel not found")
+	}
+
+	return writeChannel(m, channelname, func(filename string, contents []byte) error {
+		return store.Put(prefix+filename, contents)
+	})
+}
+
+// writeChannel renders the bundle for a channel, passing each of its files to a write function.
+func writeChannel(m *model.Model, channelname string, write func(filename string, contents []byte) error) error {
+	channelInfo := m.GetChannelInfo(channelname)
+	history := m.GetChannelHistory(channelname, m.BuiltinUsername(), -1)
+
+	// Split the history into pages
+	pages := make([]Page, 0)
+	messages := make([]Message, 0)
+	for i, message := range history {
+		if i%messagesPerPage == 0 {
+			pages = append(pages, Page{Channel: channelInfo, Number: len(pages) + 1})
+		}
+
+		// Deleted messages keep their place, so the links to the messages after them still work
+		text, language := message.Text, message.SnippetLanguage
+		if message.Deleted {
+			text, language = "(message deleted)", ""
+		}
+
+		page := &pages[len(pages)-1]
+		exportedMessage := Message{
+			ID:        "m" + strconv.Itoa(i+1),
+			Page:      page.Number,
+			Author:    message.DisplayAuthor(),
+			Timestamp: message.Timestamp.Format("2006-01-02 15:04:05"),
+			Text:      text,
+			Language:  language,
+		}
+		page.Messages = append(page.Messages, exportedMessage)
+		messages = append(messages, exportedMessage)
+	}
+
+	// Always write at least one (empty) page
+	if len(pages) == 0 {
+		pages = append(pages, Page{Channel: channelInfo, Number: 1})
+	}
+
+	for i := range pages {
+		pages[i].NumPages = len(pages)
+
+		err := writeTemplate(write, pages[i].Filename(), pageTemplate, pages[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	err := writeTemplate(write, "index.html", indexTemplate, pages)
+	if err != nil {
+		return err
+	}
+
+	// The search index is a script (rather than JSON) so it loads from the local file system
+	searchIndex, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+
+	err = write("search-index.js", []byte("var searchIndex = "+string(searchIndex)+";\n"))
+	if err != nil {
+		return err
+	}
+
+	err = write("search.js", []byte(searchScript))
+	if err != nil {
+		return err
+	}
+
+	return write("style.css", []byte(styleSheet))
+}
+
+func pageFilename(number int) string {
+	return "page-" + strconv.Itoa(number) + ".html"
+}
+
+func writeTemplate(write func(filename string, contents []byte) error, filename string, tmpl *template.Template, data interface{}) error {
+	var buffer bytes.Buffer
+	err := tmpl.Execute(&buffer, data)
+	if err != nil {
+		return err
+	}
+
+	return write(filename, buffer.Bytes())
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+    <head>
+        <meta charset="utf-8">
+        <title>{{(index . 0).Channel.Name}}</title>
+        <link rel="stylesheet" href="style.css">
+    </head>
+    <body>
+        <h1>{{(index . 0).Channel.Name}}</h1>
+        <p>{{(index . 0).Channel.Topic}}</p>
+        <p>{{(index . 0).Channel.NumMessages}} messages</p>
+        <input id="search" type="text" placeholder="Search messages">
+        <ul id="results"></ul>
+        <h2>Pages</h2>
+        <ul>
+            {{range .}}<li><a href="{{.Filename}}">Page {{.Number}}</a></li>
+            {{end}}
+        </ul>
+        <script src="search-index.js"></script>
+        <script src="search.js"></script>
+    </body>
+</html>
+`))
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+    <head>
+        <meta charset="utf-8">
+        <title>{{.Channel.Name}} - Page {{.Number}}</title>
+        <link rel="stylesheet" href="style.css">
+    </head>
+    <body>
+        <h1>{{.Channel.Name}} - Page {{.Number}} of {{.NumPages}}</h1>
+        <nav>
+            <a href="index.html">Index</a>
+            {{with .PreviousFilename}}<a href="{{.}}">Previous</a>{{end}}
+            {{with .NextFilename}}<a href="{{.}}">Next</a>{{end}}
+        </nav>
+        <ul class="messages">
+            {{range .Messages}}<li id="{{.ID}}"><span class="timestamp">[{{.Timestamp}}]</span> <span class="author">{{.Author}}</span> {{if .Language}}<pre><code class="language-{{.Language}}">{{.Text}}</code></pre>{{else}}{{.Text}}{{end}}</li>
+            {{end}}
+        </ul>
+    </body>
+</html>
+`))
+
+const searchScript = `var searchElement = document.getElementById("search")
+var resultsElement = document.getElementById("results")
+searchElement.oninput = function() {
+    resultsElement.innerHTML = ""
+    var query = searchElement.value.toLowerCase()
+    if (query === "") {
+        return
+    }
+
+    for (var i = 0; i < searchIndex.length; i++) {
+        var message = searchIndex[i]
+        if (message.text.toLowerCase().indexOf(query) === -1 && message.author.toLowerCase().indexOf(query) === -1) {
+            continue
+        }
+
+        var link = document.createElement("a")
+        link.href = "page-" + message.page + ".html#" + message.id
+        link.textContent = "[" + message.timestamp + "] " + message.author + ": " + message.text
+        var item = document.createElement("li")
+        item.appendChild(link)
+        resultsElement.appendChild(item)
+    }
+}
+`
+
+const styleSheet = `body { font-family: sans-serif; margin: 2em; }
+.messages { list-style: none; padding: 0; }
+.messages li { padding: 0.2em 0; }
+.messages li:target { background: #ffffcc; }
+.timestamp { color: #888888; }
+.author { font-weight: bold; }
+pre { background: #f4f4f4; margin: 0.2em 0; padding: 0.5em; overflow-x: auto; }
+`
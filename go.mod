@@ -3,6 +3,7 @@ module chatserver
 go 1.13
 
 require (
+	github.com/d5/tengo/v2 v2.17.0
 	github.com/golangci/golangci-lint v1.21.0 // indirect
 	github.com/reiver/go-oi v1.0.0
 	github.com/reiver/go-telnet v0.0.0-20180421082511-9ff0b2ab096e
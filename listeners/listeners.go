@@ -0,0 +1,336 @@
+// Package listeners provides the network listeners that the chat server serves on.  A
+// listener is either inherited from the process that started the server (systemd socket
+// activation, a hot restart handover, or any launcher following the same LISTEN_FDS protocol)
+// or bound to a configured address.
+package listeners
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// listenFdsStart is the first file descriptor passed by the LISTEN_FDS protocol.
+const listenFdsStart int = 3
+
+// readyFile is the pipe a hot restart handover passed us to report that we're serving (nil when we
+// weren't started by a handover, or have already reported).
+var readyFile *os.File
+
+// Inherited returns the listeners that were passed to this process by the LISTEN_FDS
+// protocol, keyed by their LISTEN_FDNAMES name (unnamed listeners are keyed by their
+// index).  The environment variables are cleared so child processes don't inherit them.
+func Inherited() (map[string]net.Listener, error) {
+	inherited := make(map[string]net.Listener)
+
+	if os.Getenv("LISTEN_FDS") == "" {
+		return inherited, nil
+	}
+
+	// The listeners are only meant for us if the pid matches (a handover from a previous
+	// chatserver process can't know our pid, so it leaves it unset)
+	if os.Getenv("LISTEN_PID") != "" {
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return inherited, nil
+		}
+	}
+
+	numFds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || numFds <= 0 {
+		return nil, errors.New("invalid LISTEN_FDS")
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	defer os.Unsetenv("LISTEN_READY_FD")
+
+	// A handover also passes the pipe to report on once we're serving (see Ready)
+	if os.Getenv("LISTEN_READY_FD") != "" {
+		readyFd, err := strconv.Atoi(os.Getenv("LISTEN_READY_FD"))
+		if err != nil || readyFd < listenFdsStart {
+			return nil, errors.New("invalid LISTEN_READY_FD")
+		}
+
+		syscall.CloseOnExec(readyFd)
+		readyFile = os.NewFile(uintptr(readyFd), "ready")
+	}
+
+	for i := 0; i < numFds; i++ {
+		name := strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(listenFdsStart+i), name)
+		listener, err := net.FileListener(file)
+		if err != nil {
+			return nil, err
+		}
+
+		// The listener holds its own duplicate of the descriptor
+		err = file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		inherited[name] = listener
+	}
+
+	return inherited, nil
+}
+
+// Listen returns the inherited listener with the given name if there is one, otherwise it
+// binds a new TCP listener to the given address.
+func Listen(inherited map[string]net.Listener, name string, address string) (net.Listener, error) {
+	if listener, ok := inherited[name]; ok {
+		return listener, nil
+	}
+
+	return net.Listen("tcp", address)
+}
+
+// ListenUnix returns the inherited listener with the given name if there is one, otherwise it
+// binds a new Unix domain socket listener at the given path.  Any stale socket file left behind
+// by a previous run is removed, and the socket is only accessible by the owning user.
+func ListenUnix(inherited map[string]net.Listener, name string, path string) (net.Listener, error) {
+	if listener, ok := inherited[name]; ok {
+		return listener, nil
+	}
+
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// Ready reports to the process that handed its listeners over to us that we're serving, so it can
+// drain its connections and exit.  It does nothing if we weren't started by a handover.
+func Ready() error {
+	if readyFile == nil {
+		return nil
+	}
+
+	_, err := readyFile.Write([]byte{1})
+	closeErr := readyFile.Close()
+	readyFile = nil
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// Tracked provides a listener that keeps track of the connections it accepts, so they can be
+// drained once its listening socket has been handed over to another process.
+type Tracked struct {
+	net.Listener
+	conns    map[*trackedConn]struct{}
+	draining bool
+	mutex    sync.Mutex
+}
+
+type trackedConn struct {
+	net.Conn
+	listener *Tracked
+	once     sync.Once
+}
+
+// Track creates/initializes/returns a new Tracked listener accepting on the given listener.
+func Track(listener net.Listener) *Tracked {
+	tracked := Tracked{
+		Listener: listener,
+		conns:    make(map[*trackedConn]struct{}),
+	}
+
+	return &tracked
+}
+
+// Accept waits for and returns the next connection.  Once the listener is drained it blocks
+// forever rather than failing, so the servers accepting on it keep running until the process exits.
+func (t *Tracked) Accept() (net.Conn, error) {
+	conn, err := t.Listener.Accept()
+
+	t.mutex.Lock()
+	if t.draining {
+		t.mutex.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+		select {}
+	}
+	if err != nil {
+		t.mutex.Unlock()
+		return nil, err
+	}
+
+	tracked := &trackedConn{Conn: conn, listener: t}
+	t.conns[tracked] = struct{}{}
+	t.mutex.Unlock()
+
+	return tracked, nil
+}
+
+// File returns a duplicate of the listening socket's file (for a handover).
+func (t *Tracked) File() (*os.File, error) {
+	filer, ok := t.Listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, errors.New("listener can't be handed over")
+	}
+
+	return filer.File()
+}
+
+// Drain stops accepting connections (leaving the listening socket to the process it was handed
+// over to, which accepts the connections still queued on it) and closes the open connections, so
+// their clients reconnect to the new process rather than waiting on this one.
+func (t *Tracked) Drain() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.draining {
+		return
+	}
+	t.draining = true
+
+	// Closing a Unix socket listener removes its socket file, which the new process is serving on
+	if unixListener, ok := t.Listener.(*net.UnixListener); ok {
+		unixListener.SetUnlinkOnClose(false)
+	}
+	t.Listener.Close()
+
+	for conn := range t.conns {
+		conn.Conn.Close()
+	}
+	t.conns = make(map[*trackedConn]struct{})
+}
+
+// Close closes the connection, no longer tracking it.
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.listener.mutex.Lock()
+		delete(c.listener.conns, c)
+		c.listener.mutex.Unlock()
+	})
+
+	return c.Conn.Close()
+}
+
+// Child provides a process started by Handover, which reports on a pipe once it's serving.
+type Child struct {
+	Process *os.Process
+	ready   *os.File
+}
+
+// WaitReady waits for the child to report that it's serving (see Ready).  If it exits first, or
+// doesn't report within the timeout, it's killed and an error is returned, so the listeners are
+// still only served by us.
+func (c *Child) WaitReady(timeout time.Duration) error {
+	defer c.ready.Close()
+
+	readyChan := make(chan error, 1)
+	go func() {
+		report := make([]byte, 1)
+		_, err := c.ready.Read(report)
+		readyChan <- err
+	}()
+
+	var err error
+	select {
+	case err = <-readyChan:
+		if err != nil {
+			err = errors.New("process " + strconv.Itoa(c.Process.Pid) + " exited before serving")
+		}
+	case <-time.After(timeout):
+		err = errors.New("process " + strconv.Itoa(c.Process.Pid) + " didn't start serving in time")
+	}
+
+	if err != nil {
+		c.Process.Kill()
+		go c.Process.Wait()
+	}
+
+	return err
+}
+
+// Handover starts a new instance of the running executable (with the same arguments) and passes
+// it the given listeners, keyed by name, using the LISTEN_FDS protocol.  The new process picks
+// them up with Inherited, so connections queued on the listeners aren't refused while it starts.
+// It's also passed a pipe to report on once it's serving, which the returned Child waits for.
+func Handover(named map[string]*Tracked) (*Child, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	names := make([]string, 0)
+	for name, listener := range named {
+		file, err := listener.File()
+		if err != nil {
+			return nil, errors.New("listener " + name + " can't be handed over: " + err.Error())
+		}
+		defer file.Close()
+
+		cmd.ExtraFiles = append(cmd.ExtraFiles, file)
+		names = append(names, name)
+	}
+
+	// Pass the listeners along (dropping any LISTEN_* variables we were started with)
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, "LISTEN_") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env, "LISTEN_FDS="+strconv.Itoa(len(names)))
+	cmd.Env = append(cmd.Env, "LISTEN_FDNAMES="+strings.Join(names, ":"))
+
+	// The pipe follows the listeners (our copy of its write end is closed once the process has
+	// started, so reading it fails if the process exits without reporting)
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyWriter.Close()
+
+	cmd.ExtraFiles = append(cmd.ExtraFiles, readyWriter)
+	cmd.Env = append(cmd.Env, "LISTEN_READY_FD="+strconv.Itoa(listenFdsStart+len(names)))
+
+	err = cmd.Start()
+	if err != nil {
+		readyReader.Close()
+		return nil, err
+	}
+
+	child := Child{
+		Process: cmd.Process,
+		ready:   readyReader,
+	}
+
+	return &child, nil
+}
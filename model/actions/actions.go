@@ -9,18 +9,65 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
-// Actor provides an interface for responding to model actions.
+// Actor provides an interface for responding to model actions.  The claimed timestamp of a posted
+// message is the time claimed by the client, when it was kept because it was too far off from the
+// one assigned (zero otherwise).  The actions between BeginBatch and EndBatch are made as a single
+// change (see model.Batch), and are logged as a single Batch action.
 type Actor interface {
 	CreateUser(username string)
+	CreateVirtualUser(ownerUsername string, username string)
 	DeleteUser(username string)
 	BlockUser(username string, usernameToBlock string)
 	UnblockUser(username string, usernameToUnblock string)
+	SetUserProfile(username string, displayName string, bio string, pronouns string)
+	SetUserStatus(username string, text string, away bool)
+	MuteChannel(username string, channelname string)
+	UnmuteChannel(username string, channelname string)
 	CreateChannel(channelname string)
 	DeleteChannel(channelname string)
-	PostMessage(channelname string, username string, timestamp time.Time, text string)
+	RestoreChannel(channelname string)
+	SetChannelTopic(channelname string, topic string)
+	SetChannelRules(channelname string, language string, rules string)
+	JoinChannel(username string, channelname string)
+	LeaveChannel(username string, channelname string)
+	PostMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string)
+	PostBridgedMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string, originSystem string, originAuthor string)
+	PostSnippet(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, language string, text string)
+	EditMessage(channelname string, messageID uint64, username string, timestamp time.Time, text string)
+	DeleteMessage(channelname string, messageID uint64, username string)
+	PostDirectMessage(username string, recipient string, timestamp time.Time, claimedTimestamp time.Time, text string)
+	CreateGroup(username string, members []string)
+	PostGroupMessage(groupID uint64, username string, timestamp time.Time, claimedTimestamp time.Time, text string)
+	PutPluginData(namespace string, key string, value string)
+	RestoreSnapshot(snapshot *Snapshot)
+	AttachFile(channelname string, messageID uint64, username string, attachment Attachment)
+	RenameChannel(channelname string, newChannelname string)
+	RenameUser(username string, newUsername string)
+	CreateTeam(teamname string)
+	DeleteTeam(teamname string)
+	AddTeamMember(teamname string, username string)
+	RemoveTeamMember(teamname string, username string)
+	CreateEvent(channelname string, username string, start time.Time, title string)
+	RSVPEvent(eventID uint64, username string, going bool)
+	MarkEventReminded(eventID uint64)
+	MarkRead(username string, channelname string, messageID uint64)
+	PostMessageMulti(channelnames []string, username string, timestamp time.Time, claimedTimestamp time.Time, text string)
+	StarMessage(username string, channelname string, messageID uint64, starred bool)
+	SetChannelNotes(channelname string, username string, timestamp time.Time, text string)
+	BeginBatch()
+	EndBatch()
+}
+
+// Recorder may be implemented by Actors that can fail to record an action (e.g. the Logger, when
+// the log can't be written).  Err returns the error recording the latest action (nil if it was
+// recorded), so the change can be left out rather than made without being recorded.
+type Recorder interface {
+	Actor
+	Err() error
 }
 
 // Action contains information about an action.
@@ -35,6 +82,13 @@ type CreateUserAction struct {
 	Username string
 }
 
+// CreateVirtualUserAction contains information about a CreateVirtualUser action.
+type CreateVirtualUserAction struct {
+	Action        Action `json:"Action"`
+	OwnerUsername string
+	Username      string
+}
+
 // DeleteUserAction contains information about a DeleteUser action.
 type DeleteUserAction struct {
 	Action   Action `json:"Action"`
@@ -55,6 +109,20 @@ type UnblockUserAction struct {
 	UsernameToUnblock string
 }
 
+// MuteChannelAction contains information about a MuteChannel action.
+type MuteChannelAction struct {
+	Action      Action `json:"Action"`
+	Username    string
+	Channelname string
+}
+
+// UnmuteChannelAction contains information about a UnmuteChannel action.
+type UnmuteChannelAction struct {
+	Action      Action `json:"Action"`
+	Username    string
+	Channelname string
+}
+
 // CreateChannelAction contains information about a CreateChannel action.
 type CreateChannelAction struct {
 	Action      Action `json:"Action"`
@@ -67,8 +135,258 @@ type DeleteChannelAction struct {
 	Channelname string
 }
 
+// RestoreChannelAction contains information about a RestoreChannel action.
+type RestoreChannelAction struct {
+	Action      Action `json:"Action"`
+	Channelname string
+}
+
+// SetChannelTopicAction contains information about a SetChannelTopic action.
+type SetChannelTopicAction struct {
+	Action      Action `json:"Action"`
+	Channelname string
+	Topic       string
+}
+
+// SetChannelRulesAction contains information about a SetChannelRules action.
+type SetChannelRulesAction struct {
+	Action      Action `json:"Action"`
+	Channelname string
+	Language    string
+	Rules       string
+}
+
+// JoinChannelAction contains information about a JoinChannel action.
+type JoinChannelAction struct {
+	Action      Action `json:"Action"`
+	Username    string
+	Channelname string
+}
+
+// LeaveChannelAction contains information about a LeaveChannel action.
+type LeaveChannelAction struct {
+	Action      Action `json:"Action"`
+	Username    string
+	Channelname string
+}
+
 // PostMessageAction contains information about a PostMessage action.
 type PostMessageAction struct {
+	Action           Action `json:"Action"`
+	Channelname      string
+	Username         string
+	Timestamp        time.Time
+	ClaimedTimestamp *time.Time `json:",omitempty"`
+	Text             string
+}
+
+// PostBridgedMessageAction contains information about a PostBridgedMessage action.
+type PostBridgedMessageAction struct {
+	Action           Action `json:"Action"`
+	Channelname      string
+	Username         string
+	Timestamp        time.Time
+	ClaimedTimestamp *time.Time `json:",omitempty"`
+	Text             string
+	OriginSystem     string
+	OriginAuthor     string
+}
+
+// SetUserProfileAction contains information about a SetUserProfile action.
+type SetUserProfileAction struct {
+	Action      Action `json:"Action"`
+	Username    string
+	DisplayName string
+	Bio         string
+	Pronouns    string
+}
+
+// SetUserStatusAction contains information about a SetUserStatus action.
+type SetUserStatusAction struct {
+	Action   Action `json:"Action"`
+	Username string
+	Text     string
+	Away     bool
+}
+
+// PostSnippetAction contains information about a PostSnippet action.
+type PostSnippetAction struct {
+	Action           Action `json:"Action"`
+	Channelname      string
+	Username         string
+	Timestamp        time.Time
+	ClaimedTimestamp *time.Time `json:",omitempty"`
+	Language         string
+	Text             string
+}
+
+// EditMessageAction contains information about an EditMessage action.
+type EditMessageAction struct {
+	Action      Action `json:"Action"`
+	Channelname string
+	MessageID   uint64
+	Username    string
+	Timestamp   time.Time
+	Text        string
+}
+
+// DeleteMessageAction contains information about a DeleteMessage action (the username is empty
+// for a moderator's deletion).
+type DeleteMessageAction struct {
+	Action      Action `json:"Action"`
+	Channelname string
+	MessageID   uint64
+	Username    string
+}
+
+// PostDirectMessageAction contains information about a PostDirectMessage action.
+type PostDirectMessageAction struct {
+	Action           Action `json:"Action"`
+	Username         string
+	Recipient        string
+	Timestamp        time.Time
+	ClaimedTimestamp *time.Time `json:",omitempty"`
+	Text             string
+}
+
+// CreateGroupAction contains information about a CreateGroup action.
+type CreateGroupAction struct {
+	Action   Action `json:"Action"`
+	Username string
+	Members  []string
+}
+
+// PostGroupMessageAction contains information about a PostGroupMessage action.
+type PostGroupMessageAction struct {
+	Action           Action `json:"Action"`
+	GroupID          uint64
+	Username         string
+	Timestamp        time.Time
+	ClaimedTimestamp *time.Time `json:",omitempty"`
+	Text             string
+}
+
+// PutPluginDataAction contains information about a PutPluginData action.
+type PutPluginDataAction struct {
+	Action    Action `json:"Action"`
+	Namespace string
+	Key       string
+	Value     string
+}
+
+// RestoreSnapshotAction contains information about a RestoreSnapshot action.
+type RestoreSnapshotAction struct {
+	Action   Action `json:"Action"`
+	Snapshot *Snapshot
+}
+
+// BatchAction contains information about a Batch action (the actions of a single change).
+type BatchAction struct {
+	Action  Action `json:"Action"`
+	Actions []interface{}
+}
+
+// AttachFileAction contains information about an AttachFile action.
+type AttachFileAction struct {
+	Action      Action `json:"Action"`
+	Channelname string
+	MessageID   uint64
+	Username    string
+	Attachment  Attachment
+}
+
+// RenameChannelAction contains information about a RenameChannel action.
+type RenameChannelAction struct {
+	Action         Action `json:"Action"`
+	Channelname    string
+	NewChannelname string
+}
+
+// RenameUserAction contains information about a RenameUser action.
+type RenameUserAction struct {
+	Action      Action `json:"Action"`
+	Username    string
+	NewUsername string
+}
+
+// CreateTeamAction contains information about a CreateTeam action.
+type CreateTeamAction struct {
+	Action   Action `json:"Action"`
+	Teamname string
+}
+
+// DeleteTeamAction contains information about a DeleteTeam action.
+type DeleteTeamAction struct {
+	Action   Action `json:"Action"`
+	Teamname string
+}
+
+// AddTeamMemberAction contains information about a AddTeamMember action.
+type AddTeamMemberAction struct {
+	Action   Action `json:"Action"`
+	Teamname string
+	Username string
+}
+
+// RemoveTeamMemberAction contains information about a RemoveTeamMember action.
+type RemoveTeamMemberAction struct {
+	Action   Action `json:"Action"`
+	Teamname string
+	Username string
+}
+
+// CreateEventAction contains information about a CreateEvent action.
+type CreateEventAction struct {
+	Action      Action `json:"Action"`
+	Channelname string
+	Username    string
+	Start       time.Time
+	Title       string
+}
+
+// RSVPEventAction contains information about a RSVPEvent action.
+type RSVPEventAction struct {
+	Action   Action `json:"Action"`
+	EventID  uint64
+	Username string
+	Going    bool
+}
+
+// MarkEventRemindedAction contains information about a MarkEventReminded action.
+type MarkEventRemindedAction struct {
+	Action  Action `json:"Action"`
+	EventID uint64
+}
+
+// MarkReadAction contains information about a MarkRead action.
+type MarkReadAction struct {
+	Action      Action `json:"Action"`
+	Username    string
+	Channelname string
+	MessageID   uint64
+}
+
+// PostMessageMultiAction contains information about a PostMessageMulti action.
+type PostMessageMultiAction struct {
+	Action           Action `json:"Action"`
+	Channelnames     []string
+	Username         string
+	Timestamp        time.Time
+	ClaimedTimestamp *time.Time `json:",omitempty"`
+	Text             string
+}
+
+// StarMessageAction contains information about a StarMessage action.
+type StarMessageAction struct {
+	Action      Action `json:"Action"`
+	Username    string
+	Channelname string
+	MessageID   uint64
+	Starred     bool
+}
+
+// SetChannelNotesAction contains information about a SetChannelNotes action.
+type SetChannelNotesAction struct {
 	Action      Action `json:"Action"`
 	Channelname string
 	Username    string
@@ -76,10 +394,152 @@ type PostMessageAction struct {
 	Text        string
 }
 
+// Attachment contains a file attached to a message (stored under its ID, the hash of its contents).
+type Attachment struct {
+	ID          string
+	Name        string
+	ContentType string
+	Size        int64
+}
+
+// Snapshot contains the full state of a model, which a compacted log starts from instead of the
+// actions that led to it.  The messages' seqs are their positions (from 1), and the names are
+// sorted so the same state always gives the same snapshot.
+type Snapshot struct {
+	Users           []SnapshotUser
+	Channels        []SnapshotChannel
+	DeletedChannels []SnapshotDeletedChannel
+	Conversations   []SnapshotConversation
+	Groups          []SnapshotGroup
+	Teams           []SnapshotTeam `json:",omitempty"`
+	PluginData      map[string]map[string]string
+	LastMessageID   uint64
+	LastGroupID     uint64
+	LastEventID     uint64 `json:",omitempty"`
+
+	// PostsDay and PostsToday are the messages each user posted on the latest day (for the quota)
+	PostsDay   string
+	PostsToday map[string]int
+}
+
+// SnapshotUser contains the state of a single user.
+type SnapshotUser struct {
+	Name          string
+	Owner         string
+	BlockedUsers  []string
+	MutedChannels []string
+	DisplayName   string `json:",omitempty"`
+	Bio           string `json:",omitempty"`
+	Pronouns      string `json:",omitempty"`
+	StatusText    string `json:",omitempty"`
+	Away          bool   `json:",omitempty"`
+}
+
+// SnapshotChannel contains the state of a single channel.  PostCounts counts the messages each
+// user posted on each day (by "2006-01-02" then username), ReadMarkers the ID of the last
+// message each user has read (by username), Stars the IDs of the messages each user has
+// starred (by username, in ID order), and Notes the channel's notes (nil until they're first
+// set).
+type SnapshotChannel struct {
+	Name         string
+	Topic        string
+	Language     string
+	Rules        string
+	Members      []string
+	LastActivity time.Time
+	Messages     []SnapshotMessage
+	PostCounts   map[string]map[string]int
+	Events       []SnapshotEvent     `json:",omitempty"`
+	ReadMarkers  map[string]uint64   `json:",omitempty"`
+	Stars        map[string][]uint64 `json:",omitempty"`
+	Notes        *SnapshotNotes      `json:",omitempty"`
+}
+
+// SnapshotNotes contains a channel's notes, along with their version and who last edited them.
+type SnapshotNotes struct {
+	Text    string
+	Version uint64
+	Editor  string
+	Edited  time.Time
+}
+
+// SnapshotEvent contains an event on a channel's calendar.
+type SnapshotEvent struct {
+	ID       uint64
+	Creator  string
+	Start    time.Time
+	Title    string
+	Going    []string
+	Reminded bool
+}
+
+// SnapshotDeletedChannel contains a deleted channel that can still be restored (so a RestoreChannel
+// logged after the snapshot can be replayed), along with the users that had it muted.
+type SnapshotDeletedChannel struct {
+	Channel   SnapshotChannel
+	MutedBy   []string
+	DeletedAt time.Time
+}
+
+// SnapshotConversation contains the direct messages between two users.
+type SnapshotConversation struct {
+	Usernames []string
+	Messages  []SnapshotMessage
+}
+
+// SnapshotGroup contains a group conversation.
+type SnapshotGroup struct {
+	ID       uint64
+	Members  []string
+	Messages []SnapshotMessage
+}
+
+// SnapshotTeam contains a team of users.
+type SnapshotTeam struct {
+	Name    string
+	Members []string
+}
+
+// SnapshotMessage contains a single message (a deleted one has no text).
+type SnapshotMessage struct {
+	ID               uint64
+	Username         string
+	Timestamp        time.Time
+	ClaimedTimestamp time.Time
+	Edited           time.Time
+	Deleted          bool
+	Text             string
+	OriginSystem     string
+	OriginAuthor     string
+	SnippetLanguage  string       `json:",omitempty"`
+	Attachments      []Attachment `json:",omitempty"`
+	Mentions         []string     `json:",omitempty"`
+	CrossPost        uint64       `json:",omitempty"`
+}
+
+// Log sync policies (see SetSyncPolicy)
+const (
+	SyncNone     string = "none"
+	SyncInterval string = "interval"
+	SyncAlways   string = "always"
+)
+
 // Logger provides a means to log model actions to a file.  It provides the Actor interface
 // and will persist the actions sequentially.
+//
+// An action that can't be written (e.g. because the disk is full) is left out of the log, which
+// is kept intact, and the error is passed to the error handler (see SetErrorHandler) and reported
+// by Err, so the change is left out too rather than exiting part way through it.
 type Logger struct {
-	logFilePath string
+	logFilePath  string
+	err          error
+	errorHandler func(err error)
+	syncPolicy   string
+	syncInterval time.Duration
+	syncMutex    sync.Mutex
+	syncTimer    *time.Timer
+	batching     bool
+	batch        []interface{}
 }
 
 // NewLogger creates/initializes/returns a new Logger.
@@ -130,6 +590,7 @@ func NewLogger(logFilePath string) (*Logger, error) {
 	// At this point, we have a valid log file
 	logger := Logger{
 		logFilePath: logFilePath,
+		syncPolicy:  SyncNone,
 	}
 
 	return &logger, nil
@@ -148,6 +609,20 @@ func (l *Logger) CreateUser(username string) {
 	l.commitAction(&action)
 }
 
+// CreateVirtualUser logs the CreateVirtualUser action.
+func (l *Logger) CreateVirtualUser(ownerUsername string, username string) {
+	action := CreateVirtualUserAction{
+		Action: Action{
+			Name:      "CreateVirtualUser",
+			Timestamp: time.Now(),
+		},
+		OwnerUsername: ownerUsername,
+		Username:      username,
+	}
+
+	l.commitAction(&action)
+}
+
 // DeleteUser logs the DeleteUser action.
 func (l *Logger) DeleteUser(username string) {
 	action := DeleteUserAction{
@@ -189,6 +664,34 @@ func (l *Logger) UnblockUser(username string, usernameToUnblock string) {
 	l.commitAction(&action)
 }
 
+// MuteChannel logs the MuteChannel action.
+func (l *Logger) MuteChannel(username string, channelname string) {
+	action := MuteChannelAction{
+		Action: Action{
+			Name:      "MuteChannel",
+			Timestamp: time.Now(),
+		},
+		Username:    username,
+		Channelname: channelname,
+	}
+
+	l.commitAction(&action)
+}
+
+// UnmuteChannel logs the UnmuteChannel action.
+func (l *Logger) UnmuteChannel(username string, channelname string) {
+	action := UnmuteChannelAction{
+		Action: Action{
+			Name:      "UnmuteChannel",
+			Timestamp: time.Now(),
+		},
+		Username:    username,
+		Channelname: channelname,
+	}
+
+	l.commitAction(&action)
+}
+
 // CreateChannel logs the CreateChannel action.
 func (l *Logger) CreateChannel(channelname string) {
 	action := CreateChannelAction{
@@ -215,298 +718,2172 @@ func (l *Logger) DeleteChannel(channelname string) {
 	l.commitAction(&action)
 }
 
-// PostMessage logs the PostMessage action.
-func (l *Logger) PostMessage(channelname string, username string, timestamp time.Time, text string) {
-	action := PostMessageAction{
+// RestoreChannel logs the RestoreChannel action.
+func (l *Logger) RestoreChannel(channelname string) {
+	action := RestoreChannelAction{
 		Action: Action{
-			Name:      "PostMessage",
+			Name:      "RestoreChannel",
 			Timestamp: time.Now(),
 		},
 		Channelname: channelname,
-		Username:    username,
-		Timestamp:   timestamp,
-		Text:        text,
 	}
 
 	l.commitAction(&action)
 }
 
-func (l *Logger) commitAction(action interface{}) {
-	// Marshal the JSON
-	jsonAction, err := json.Marshal(action)
-	if err != nil {
-		log.Fatal(err)
+// SetChannelTopic logs the SetChannelTopic action.
+func (l *Logger) SetChannelTopic(channelname string, topic string) {
+	action := SetChannelTopicAction{
+		Action: Action{
+			Name:      "SetChannelTopic",
+			Timestamp: time.Now(),
+		},
+		Channelname: channelname,
+		Topic:       topic,
 	}
 
-	logFile, err := os.OpenFile(l.logFilePath, os.O_CREATE|os.O_RDWR, 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
+	l.commitAction(&action)
+}
 
-	// Seek to the end of the file minus 2 bytes (to overwrite the last entry's trailing newline)
-	_, err = logFile.Seek(-2, 2)
-	if err != nil {
-		log.Fatal(err)
+// SetChannelRules logs the SetChannelRules action.
+func (l *Logger) SetChannelRules(channelname string, language string, rules string) {
+	action := SetChannelRulesAction{
+		Action: Action{
+			Name:      "SetChannelRules",
+			Timestamp: time.Now(),
+		},
+		Channelname: channelname,
+		Language:    language,
+		Rules:       rules,
 	}
 
-	// Write the action to the file
-	_, err = logFile.WriteString(",\n" + string(jsonAction) + "\n]")
-	if err != nil {
-		log.Fatal(err)
-	}
+	l.commitAction(&action)
+}
 
-	// Close the file
-	err = logFile.Close()
-	if err != nil {
-		log.Fatal(err)
+// JoinChannel logs the JoinChannel action.
+func (l *Logger) JoinChannel(username string, channelname string) {
+	action := JoinChannelAction{
+		Action: Action{
+			Name:      "JoinChannel",
+			Timestamp: time.Now(),
+		},
+		Username:    username,
+		Channelname: channelname,
 	}
-}
 
-// Replayer provides a means to replay model actions sequentially that were written to a log file.
-type Replayer struct {
-	logFilePath string
-	actor       Actor
+	l.commitAction(&action)
 }
 
-// NewReplayer creates/initializes/returns a new Replayer.
-func NewReplayer(logFilePath string) (*Replayer, error) {
-	// Validate the path
-	if logFilePath == "" {
-		return nil, errors.New("invalid log file path")
+// LeaveChannel logs the LeaveChannel action.
+func (l *Logger) LeaveChannel(username string, channelname string) {
+	action := LeaveChannelAction{
+		Action: Action{
+			Name:      "LeaveChannel",
+			Timestamp: time.Now(),
+		},
+		Username:    username,
+		Channelname: channelname,
 	}
 
-	// Validate the log file
-	info, err := os.Stat(logFilePath)
-	if err != nil {
-		return nil, err
-	}
+	l.commitAction(&action)
+}
 
-	if info.IsDir() {
-		return nil, errors.New("log file path points to a directory")
+// claimedTime returns the time claimed by the client for a posted message to log (nil if it wasn't
+// kept, so it's left out).
+func claimedTime(claimedTimestamp time.Time) *time.Time {
+	if claimedTimestamp.IsZero() {
+		return nil
 	}
 
-	if info.Size() == 0 {
-		return nil, errors.New("log file is empty")
-	}
+	return &claimedTimestamp
+}
 
-	replayer := Replayer{
-		logFilePath: logFilePath,
-		actor:       nil,
+// PostMessage logs the PostMessage action.
+func (l *Logger) PostMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	action := PostMessageAction{
+		Action: Action{
+			Name:      "PostMessage",
+			Timestamp: time.Now(),
+		},
+		Channelname:      channelname,
+		Username:         username,
+		Timestamp:        timestamp,
+		ClaimedTimestamp: claimedTime(claimedTimestamp),
+		Text:             text,
 	}
 
-	return &replayer, nil
+	l.commitAction(&action)
+}
+
+// PostBridgedMessage logs the PostBridgedMessage action.
+func (l *Logger) PostBridgedMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string, originSystem string, originAuthor string) {
+	action := PostBridgedMessageAction{
+		Action: Action{
+			Name:      "PostBridgedMessage",
+			Timestamp: time.Now(),
+		},
+		Channelname:      channelname,
+		Username:         username,
+		Timestamp:        timestamp,
+		ClaimedTimestamp: claimedTime(claimedTimestamp),
+		Text:             text,
+		OriginSystem:     originSystem,
+		OriginAuthor:     originAuthor,
+	}
+
+	l.commitAction(&action)
+}
+
+// SetUserProfile logs the SetUserProfile action.
+func (l *Logger) SetUserProfile(username string, displayName string, bio string, pronouns string) {
+	action := SetUserProfileAction{
+		Action: Action{
+			Name:      "SetUserProfile",
+			Timestamp: time.Now(),
+		},
+		Username:    username,
+		DisplayName: displayName,
+		Bio:         bio,
+		Pronouns:    pronouns,
+	}
+
+	l.commitAction(&action)
+}
+
+// SetUserStatus logs the SetUserStatus action.
+func (l *Logger) SetUserStatus(username string, text string, away bool) {
+	action := SetUserStatusAction{
+		Action: Action{
+			Name:      "SetUserStatus",
+			Timestamp: time.Now(),
+		},
+		Username: username,
+		Text:     text,
+		Away:     away,
+	}
+
+	l.commitAction(&action)
+}
+
+// PostSnippet logs the PostSnippet action.
+func (l *Logger) PostSnippet(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, language string, text string) {
+	action := PostSnippetAction{
+		Action: Action{
+			Name:      "PostSnippet",
+			Timestamp: time.Now(),
+		},
+		Channelname:      channelname,
+		Username:         username,
+		Timestamp:        timestamp,
+		ClaimedTimestamp: claimedTime(claimedTimestamp),
+		Language:         language,
+		Text:             text,
+	}
+
+	l.commitAction(&action)
+}
+
+// EditMessage logs the EditMessage action.
+func (l *Logger) EditMessage(channelname string, messageID uint64, username string, timestamp time.Time, text string) {
+	action := EditMessageAction{
+		Action: Action{
+			Name:      "EditMessage",
+			Timestamp: time.Now(),
+		},
+		Channelname: channelname,
+		MessageID:   messageID,
+		Username:    username,
+		Timestamp:   timestamp,
+		Text:        text,
+	}
+
+	l.commitAction(&action)
+}
+
+// DeleteMessage logs the DeleteMessage action.
+func (l *Logger) DeleteMessage(channelname string, messageID uint64, username string) {
+	action := DeleteMessageAction{
+		Action: Action{
+			Name:      "DeleteMessage",
+			Timestamp: time.Now(),
+		},
+		Channelname: channelname,
+		MessageID:   messageID,
+		Username:    username,
+	}
+
+	l.commitAction(&action)
+}
+
+// PostDirectMessage logs the PostDirectMessage action.
+func (l *Logger) PostDirectMessage(username string, recipient string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	action := PostDirectMessageAction{
+		Action: Action{
+			Name:      "PostDirectMessage",
+			Timestamp: time.Now(),
+		},
+		Username:         username,
+		Recipient:        recipient,
+		Timestamp:        timestamp,
+		ClaimedTimestamp: claimedTime(claimedTimestamp),
+		Text:             text,
+	}
+
+	l.commitAction(&action)
+}
+
+// CreateGroup logs the CreateGroup action.
+func (l *Logger) CreateGroup(username string, members []string) {
+	action := CreateGroupAction{
+		Action: Action{
+			Name:      "CreateGroup",
+			Timestamp: time.Now(),
+		},
+		Username: username,
+		Members:  members,
+	}
+
+	l.commitAction(&action)
+}
+
+// PostGroupMessage logs the PostGroupMessage action.
+func (l *Logger) PostGroupMessage(groupID uint64, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	action := PostGroupMessageAction{
+		Action: Action{
+			Name:      "PostGroupMessage",
+			Timestamp: time.Now(),
+		},
+		GroupID:          groupID,
+		Username:         username,
+		Timestamp:        timestamp,
+		ClaimedTimestamp: claimedTime(claimedTimestamp),
+		Text:             text,
+	}
+
+	l.commitAction(&action)
+}
+
+// RestoreSnapshot logs the RestoreSnapshot action.
+func (l *Logger) RestoreSnapshot(snapshot *Snapshot) {
+	action := RestoreSnapshotAction{
+		Action: Action{
+			Name:      "RestoreSnapshot",
+			Timestamp: time.Now(),
+		},
+		Snapshot: snapshot,
+	}
+
+	l.commitAction(&action)
+}
+
+// AttachFile logs the AttachFile action.
+func (l *Logger) AttachFile(channelname string, messageID uint64, username string, attachment Attachment) {
+	action := AttachFileAction{
+		Action: Action{
+			Name:      "AttachFile",
+			Timestamp: time.Now(),
+		},
+		Channelname: channelname,
+		MessageID:   messageID,
+		Username:    username,
+		Attachment:  attachment,
+	}
+
+	l.commitAction(&action)
+}
+
+// RenameChannel logs the RenameChannel action.
+func (l *Logger) RenameChannel(channelname string, newChannelname string) {
+	action := RenameChannelAction{
+		Action: Action{
+			Name:      "RenameChannel",
+			Timestamp: time.Now(),
+		},
+		Channelname:    channelname,
+		NewChannelname: newChannelname,
+	}
+
+	l.commitAction(&action)
+}
+
+// RenameUser logs the RenameUser action.
+func (l *Logger) RenameUser(username string, newUsername string) {
+	action := RenameUserAction{
+		Action: Action{
+			Name:      "RenameUser",
+			Timestamp: time.Now(),
+		},
+		Username:    username,
+		NewUsername: newUsername,
+	}
+
+	l.commitAction(&action)
+}
+
+// CreateTeam logs the CreateTeam action.
+func (l *Logger) CreateTeam(teamname string) {
+	action := CreateTeamAction{
+		Action: Action{
+			Name:      "CreateTeam",
+			Timestamp: time.Now(),
+		},
+		Teamname: teamname,
+	}
+
+	l.commitAction(&action)
+}
+
+// DeleteTeam logs the DeleteTeam action.
+func (l *Logger) DeleteTeam(teamname string) {
+	action := DeleteTeamAction{
+		Action: Action{
+			Name:      "DeleteTeam",
+			Timestamp: time.Now(),
+		},
+		Teamname: teamname,
+	}
+
+	l.commitAction(&action)
+}
+
+// AddTeamMember logs the AddTeamMember action.
+func (l *Logger) AddTeamMember(teamname string, username string) {
+	action := AddTeamMemberAction{
+		Action: Action{
+			Name:      "AddTeamMember",
+			Timestamp: time.Now(),
+		},
+		Teamname: teamname,
+		Username: username,
+	}
+
+	l.commitAction(&action)
+}
+
+// RemoveTeamMember logs the RemoveTeamMember action.
+func (l *Logger) RemoveTeamMember(teamname string, username string) {
+	action := RemoveTeamMemberAction{
+		Action: Action{
+			Name:      "RemoveTeamMember",
+			Timestamp: time.Now(),
+		},
+		Teamname: teamname,
+		Username: username,
+	}
+
+	l.commitAction(&action)
+}
+
+// CreateEvent logs the CreateEvent action.
+func (l *Logger) CreateEvent(channelname string, username string, start time.Time, title string) {
+	action := CreateEventAction{
+		Action: Action{
+			Name:      "CreateEvent",
+			Timestamp: time.Now(),
+		},
+		Channelname: channelname,
+		Username:    username,
+		Start:       start,
+		Title:       title,
+	}
+
+	l.commitAction(&action)
+}
+
+// RSVPEvent logs the RSVPEvent action.
+func (l *Logger) RSVPEvent(eventID uint64, username string, going bool) {
+	action := RSVPEventAction{
+		Action: Action{
+			Name:      "RSVPEvent",
+			Timestamp: time.Now(),
+		},
+		EventID:  eventID,
+		Username: username,
+		Going:    going,
+	}
+
+	l.commitAction(&action)
+}
+
+// MarkEventReminded logs the MarkEventReminded action.
+func (l *Logger) MarkEventReminded(eventID uint64) {
+	action := MarkEventRemindedAction{
+		Action: Action{
+			Name:      "MarkEventReminded",
+			Timestamp: time.Now(),
+		},
+		EventID: eventID,
+	}
+
+	l.commitAction(&action)
+}
+
+// MarkRead logs the MarkRead action.
+func (l *Logger) MarkRead(username string, channelname string, messageID uint64) {
+	action := MarkReadAction{
+		Action: Action{
+			Name:      "MarkRead",
+			Timestamp: time.Now(),
+		},
+		Username:    username,
+		Channelname: channelname,
+		MessageID:   messageID,
+	}
+
+	l.commitAction(&action)
+}
+
+// PostMessageMulti logs the PostMessageMulti action.
+func (l *Logger) PostMessageMulti(channelnames []string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	action := PostMessageMultiAction{
+		Action: Action{
+			Name:      "PostMessageMulti",
+			Timestamp: time.Now(),
+		},
+		Channelnames:     channelnames,
+		Username:         username,
+		Timestamp:        timestamp,
+		ClaimedTimestamp: claimedTime(claimedTimestamp),
+		Text:             text,
+	}
+
+	l.commitAction(&action)
+}
+
+// StarMessage logs the StarMessage action.
+func (l *Logger) StarMessage(username string, channelname string, messageID uint64, starred bool) {
+	action := StarMessageAction{
+		Action: Action{
+			Name:      "StarMessage",
+			Timestamp: time.Now(),
+		},
+		Username:    username,
+		Channelname: channelname,
+		MessageID:   messageID,
+		Starred:     starred,
+	}
+
+	l.commitAction(&action)
+}
+
+// SetChannelNotes logs the SetChannelNotes action.
+func (l *Logger) SetChannelNotes(channelname string, username string, timestamp time.Time, text string) {
+	action := SetChannelNotesAction{
+		Action: Action{
+			Name:      "SetChannelNotes",
+			Timestamp: time.Now(),
+		},
+		Channelname: channelname,
+		Username:    username,
+		Timestamp:   timestamp,
+		Text:        text,
+	}
+
+	l.commitAction(&action)
+}
+
+// BeginBatch starts a Batch action: the actions until EndBatch are kept rather than written.
+func (l *Logger) BeginBatch() {
+	l.batching = true
+	l.batch = nil
+	l.err = nil
+}
+
+// EndBatch logs the Batch action with the actions kept since BeginBatch, so either all of them are
+// written or none are.
+func (l *Logger) EndBatch() {
+	action := BatchAction{
+		Action: Action{
+			Name:      "Batch",
+			Timestamp: time.Now(),
+		},
+		Actions: l.batch,
+	}
+
+	l.batching = false
+	l.batch = nil
+	l.commitAction(&action)
+}
+
+// PutPluginData logs the PutPluginData action.
+func (l *Logger) PutPluginData(namespace string, key string, value string) {
+	action := PutPluginDataAction{
+		Action: Action{
+			Name:      "PutPluginData",
+			Timestamp: time.Now(),
+		},
+		Namespace: namespace,
+		Key:       key,
+		Value:     value,
+	}
+
+	l.commitAction(&action)
+}
+
+// SetErrorHandler sets the function called with the error when an action can't be written to the
+// log (by default the error is only printed).  It's called while the model is logging the change
+// (before rejecting it), or from the background sync of the SyncInterval policy, so it mustn't wait
+// on the model.
+func (l *Logger) SetErrorHandler(handler func(err error)) {
+	l.errorHandler = handler
+}
+
+// SetSyncPolicy sets when the log is flushed to disk (fsync), trading durability for throughput.
+// Each action is written to the log before the model makes its change either way (an action that
+// can't be written is reported by Err, and the model rejects its change with the error), so the
+// changes made are kept if the server crashes; the policy only matters if the machine itself fails
+// (e.g. loses power) before the OS has written them out:
+//
+// SyncNone (the default) leaves it to the OS, which may lose the last few seconds of actions (or
+// more, depending on its settings) but adds no latency to changes.
+//
+// SyncInterval flushes the log in the background no later than the interval after an action is
+// written, so at most the last interval's worth of actions can be lost, for an fsync per interval
+// at most.
+//
+// SyncAlways flushes each action before the change is made, so none can be lost, but every change
+// waits on the disk (while holding the model's lock), limiting the changes per second to the
+// fsyncs per second the disk can do.  An action that was written but couldn't be flushed stays in
+// the log (so Err doesn't report it, and its change is made), and the error is passed to the error
+// handler.
+func (l *Logger) SetSyncPolicy(policy string, interval time.Duration) {
+	l.syncPolicy = policy
+	l.syncInterval = interval
+}
+
+// Sync flushes the log to disk.
+func (l *Logger) Sync() error {
+	logFile, err := os.OpenFile(l.logFilePath, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = logFile.Sync()
+	closeErr := logFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Compact replaces the log with a single RestoreSnapshot action, so it no longer holds the history
+// of changes that are no longer part of the state (deleted users and channels, edits, etc.).  The
+// state mustn't change until it returns.  The new log is written next to the old one and renamed
+// over it, so the old one is left as it was if the new one can't be written (e.g. because the
+// disk is full).
+func (l *Logger) Compact(snapshot *Snapshot) error {
+	compactLog, err := EncodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	compactFile, err := ioutil.TempFile(filepath.Dir(l.logFilePath), filepath.Base(l.logFilePath)+".compact.*")
+	if err != nil {
+		return err
+	}
+
+	_, err = compactFile.Write(compactLog)
+	if err == nil {
+		err = compactFile.Sync()
+	}
+	if err == nil {
+		err = compactFile.Chmod(0644)
+	}
+
+	closeErr := compactFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(compactFile.Name())
+		return err
+	}
+
+	return os.Rename(compactFile.Name(), l.logFilePath)
+}
+
+// EncodeSnapshot returns the contents of a log holding a single RestoreSnapshot action (as a log is
+// compacted to), which replays to the state in the snapshot.
+func EncodeSnapshot(snapshot *Snapshot) ([]byte, error) {
+	action := RestoreSnapshotAction{
+		Action: Action{
+			Name:      "RestoreSnapshot",
+			Timestamp: time.Now(),
+		},
+		Snapshot: snapshot,
+	}
+
+	jsonAction, err := json.Marshal(&action)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte("[\n{},\n" + string(jsonAction) + "\n]"), nil
+}
+
+// Err returns the error writing the latest action (nil if it was written).
+func (l *Logger) Err() error {
+	return l.err
+}
+
+func (l *Logger) commitAction(action interface{}) {
+	if l.batching {
+		l.batch = append(l.batch, action)
+		l.err = nil
+		return
+	}
+
+	l.err = l.writeAction(action)
+	if l.err != nil {
+		l.handleError(l.err)
+		return
+	}
+
+	// An action that was written but couldn't be flushed stays in the log (so it's replayed, and
+	// its change is made)
+	switch l.syncPolicy {
+	case SyncAlways:
+		err := l.Sync()
+		if err != nil {
+			l.handleError(err)
+		}
+	case SyncInterval:
+		l.scheduleSync()
+	}
+}
+
+// scheduleSync flushes the log after the sync interval, unless a flush is already scheduled (which
+// will include the action just written).
+func (l *Logger) scheduleSync() {
+	l.syncMutex.Lock()
+	defer l.syncMutex.Unlock()
+
+	if l.syncTimer != nil {
+		return
+	}
+
+	l.syncTimer = time.AfterFunc(l.syncInterval, func() {
+		// Actions written from here on schedule the next flush
+		l.syncMutex.Lock()
+		l.syncTimer = nil
+		l.syncMutex.Unlock()
+
+		err := l.Sync()
+		if err != nil {
+			l.handleError(err)
+		}
+	})
+}
+
+func (l *Logger) handleError(err error) {
+	if l.errorHandler != nil {
+		l.errorHandler(err)
+	} else {
+		log.Println("actions log:", err)
+	}
+}
+
+func (l *Logger) writeAction(action interface{}) error {
+	// Marshal the JSON
+	jsonAction, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+
+	logFile, err := os.OpenFile(l.logFilePath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	// Seek to the end of the file minus 2 bytes (to overwrite the last entry's trailing newline)
+	end, err := logFile.Seek(-2, 2)
+	if err != nil {
+		logFile.Close()
+		return err
+	}
+
+	// Write the action to the file
+	_, err = logFile.WriteString(",\n" + string(jsonAction) + "\n]")
+	if err != nil {
+		// Put the end of the log back as it was, so the part that was written doesn't corrupt it
+		// (overwriting the last 2 bytes doesn't need any more space)
+		logFile.Truncate(end + 2)
+		logFile.WriteAt([]byte("\n]"), end)
+		logFile.Close()
+		return err
+	}
+
+	// Close the file
+	return logFile.Close()
+}
+
+// Replayer provides a means to replay model actions sequentially that were written to a log file.
+type Replayer struct {
+	logFilePath string
+	actor       Actor
+}
+
+// NewReplayer creates/initializes/returns a new Replayer.
+func NewReplayer(logFilePath string) (*Replayer, error) {
+	// Validate the path
+	if logFilePath == "" {
+		return nil, errors.New("invalid log file path")
+	}
+
+	// Validate the log file
+	info, err := os.Stat(logFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, errors.New("log file path points to a directory")
+	}
+
+	if info.Size() == 0 {
+		return nil, errors.New("log file is empty")
+	}
+
+	replayer := Replayer{
+		logFilePath: logFilePath,
+		actor:       nil,
+	}
+
+	return &replayer, nil
 }
 
 // Replay will replay the model actions sequentially on the Actor.
 func (r *Replayer) Replay(actor Actor) error {
 	r.actor = actor
 
-	// Read the entire file
-	wholeFile, err := ioutil.ReadFile(r.logFilePath)
+	// Read the entire file
+	wholeFile, err := ioutil.ReadFile(r.logFilePath)
+	if err != nil {
+		return err
+	}
+
+	// Parse the json string
+	var result []map[string]interface{}
+	err = json.Unmarshal(wholeFile, &result)
+	if err != nil {
+		return errors.New("invalid input log file - malformed json")
+	}
+
+	// Parse the action entries
+	for _, action := range result {
+		// Disregard empty entries
+		if len(action) == 0 {
+			continue
+		}
+
+		// Parse the individual action
+		err = r.parseAction(&action)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Replayer) parseAction(action *map[string]interface{}) error {
+	if _, ok := (*action)["Action"]; !ok {
+		return errors.New("invalid input log file - action not found")
+	}
+
+	actionStruct := (*action)["Action"].(map[string]interface{})
+
+	if _, ok := actionStruct["Name"]; !ok {
+		return errors.New("invalid input log file - name not found")
+	}
+
+	actionName, ok := actionStruct["Name"].(string)
+	if !ok {
+		return errors.New("invalid input log file - name not string")
+	}
+
+	switch actionName {
+	case "CreateUser":
+		err := r.parseCreateUser(action)
+		if err != nil {
+			return err
+		}
+	case "CreateVirtualUser":
+		err := r.parseCreateVirtualUser(action)
+		if err != nil {
+			return err
+		}
+	case "DeleteUser":
+		err := r.parseDeleteUser(action)
+		if err != nil {
+			return err
+		}
+	case "BlockUser":
+		err := r.parseBlockUser(action)
+		if err != nil {
+			return err
+		}
+	case "UnblockUser":
+		err := r.parseUnblockUser(action)
+		if err != nil {
+			return err
+		}
+	case "MuteChannel":
+		err := r.parseMuteChannel(action)
+		if err != nil {
+			return err
+		}
+	case "UnmuteChannel":
+		err := r.parseUnmuteChannel(action)
+		if err != nil {
+			return err
+		}
+	case "CreateChannel":
+		err := r.parseCreateChannel(action)
+		if err != nil {
+			return err
+		}
+	case "DeleteChannel":
+		err := r.parseDeleteChannel(action)
+		if err != nil {
+			return err
+		}
+	case "RestoreChannel":
+		err := r.parseRestoreChannel(action)
+		if err != nil {
+			return err
+		}
+	case "SetChannelTopic":
+		err := r.parseSetChannelTopic(action)
+		if err != nil {
+			return err
+		}
+	case "SetChannelRules":
+		err := r.parseSetChannelRules(action)
+		if err != nil {
+			return err
+		}
+	case "JoinChannel":
+		err := r.parseJoinChannel(action)
+		if err != nil {
+			return err
+		}
+	case "LeaveChannel":
+		err := r.parseLeaveChannel(action)
+		if err != nil {
+			return err
+		}
+	case "PostMessage":
+		err := r.parsePostMessage(action)
+		if err != nil {
+			return err
+		}
+	case "PostBridgedMessage":
+		err := r.parsePostBridgedMessage(action)
+		if err != nil {
+			return err
+		}
+	case "PostSnippet":
+		err := r.parsePostSnippet(action)
+		if err != nil {
+			return err
+		}
+	case "SetUserProfile":
+		err := r.parseSetUserProfile(action)
+		if err != nil {
+			return err
+		}
+	case "SetUserStatus":
+		err := r.parseSetUserStatus(action)
+		if err != nil {
+			return err
+		}
+	case "EditMessage":
+		err := r.parseEditMessage(action)
+		if err != nil {
+			return err
+		}
+	case "DeleteMessage":
+		err := r.parseDeleteMessage(action)
+		if err != nil {
+			return err
+		}
+	case "PostDirectMessage":
+		err := r.parsePostDirectMessage(action)
+		if err != nil {
+			return err
+		}
+	case "CreateGroup":
+		err := r.parseCreateGroup(action)
+		if err != nil {
+			return err
+		}
+	case "PostGroupMessage":
+		err := r.parsePostGroupMessage(action)
+		if err != nil {
+			return err
+		}
+	case "PutPluginData":
+		err := r.parsePutPluginData(action)
+		if err != nil {
+			return err
+		}
+	case "RestoreSnapshot":
+		err := r.parseRestoreSnapshot(action)
+		if err != nil {
+			return err
+		}
+	case "Batch":
+		err := r.parseBatch(action)
+		if err != nil {
+			return err
+		}
+	case "AttachFile":
+		err := r.parseAttachFile(action)
+		if err != nil {
+			return err
+		}
+	case "RenameChannel":
+		err := r.parseRenameChannel(action)
+		if err != nil {
+			return err
+		}
+	case "RenameUser":
+		err := r.parseRenameUser(action)
+		if err != nil {
+			return err
+		}
+	case "CreateTeam":
+		err := r.parseCreateTeam(action)
+		if err != nil {
+			return err
+		}
+	case "DeleteTeam":
+		err := r.parseDeleteTeam(action)
+		if err != nil {
+			return err
+		}
+	case "AddTeamMember":
+		err := r.parseAddTeamMember(action)
+		if err != nil {
+			return err
+		}
+	case "RemoveTeamMember":
+		err := r.parseRemoveTeamMember(action)
+		if err != nil {
+			return err
+		}
+	case "CreateEvent":
+		err := r.parseCreateEvent(action)
+		if err != nil {
+			return err
+		}
+	case "RSVPEvent":
+		err := r.parseRSVPEvent(action)
+		if err != nil {
+			return err
+		}
+	case "MarkEventReminded":
+		err := r.parseMarkEventReminded(action)
+		if err != nil {
+			return err
+		}
+	case "MarkRead":
+		err := r.parseMarkRead(action)
+		if err != nil {
+			return err
+		}
+	case "PostMessageMulti":
+		err := r.parsePostMessageMulti(action)
+		if err != nil {
+			return err
+		}
+	case "StarMessage":
+		err := r.parseStarMessage(action)
+		if err != nil {
+			return err
+		}
+	case "SetChannelNotes":
+		err := r.parseSetChannelNotes(action)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("invalid input log file - unknown action")
+	}
+
+	return nil
+}
+
+func (r *Replayer) parseCreateUser(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - CreateUser - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - CreateUser - Username not a string")
+	}
+
+	r.actor.CreateUser(username)
+	return nil
+}
+
+func (r *Replayer) parseCreateVirtualUser(action *map[string]interface{}) error {
+	if _, ok := (*action)["OwnerUsername"]; !ok {
+		return errors.New("invalid input log file - CreateVirtualUser - missing OwnerUsername")
+	}
+	ownerUsername, ok := (*action)["OwnerUsername"].(string)
+	if !ok {
+		return errors.New("invalid input log file - CreateVirtualUser - OwnerUsername not a string")
+	}
+
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - CreateVirtualUser - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - CreateVirtualUser - Username not a string")
+	}
+
+	r.actor.CreateVirtualUser(ownerUsername, username)
+	return nil
+}
+
+func (r *Replayer) parseDeleteUser(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - DeleteUser - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - DeleteUser - Username not a string")
+	}
+
+	r.actor.DeleteUser(username)
+	return nil
+}
+
+func (r *Replayer) parseBlockUser(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - BlockUser - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - BlockUser - Username not a string")
+	}
+
+	if _, ok := (*action)["UsernameToBlock"]; !ok {
+		return errors.New("invalid input log file - BlockUser - missing UsernameToBlock")
+	}
+	usernameToBlock, ok := (*action)["UsernameToBlock"].(string)
+	if !ok {
+		return errors.New("invalid input log file - BlockUser - UsernameToBlock not a string")
+	}
+
+	r.actor.BlockUser(username, usernameToBlock)
+	return nil
+}
+
+func (r *Replayer) parseUnblockUser(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - UnblockUser - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - UnblockUser - Username not a string")
+	}
+
+	if _, ok := (*action)["UsernameToUnblock"]; !ok {
+		return errors.New("invalid input log file - UnblockUser - missing UsernameToUnblock")
+	}
+	usernameToUnblock, ok := (*action)["UsernameToUnblock"].(string)
+	if !ok {
+		return errors.New("invalid input log file - UnblockUser - UsernameToUnblock not a string")
+	}
+
+	r.actor.UnblockUser(username, usernameToUnblock)
+	return nil
+}
+
+func (r *Replayer) parseMuteChannel(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - MuteChannel - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - MuteChannel - Username not a string")
+	}
+
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - MuteChannel - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - MuteChannel - Channelname not a string")
+	}
+
+	r.actor.MuteChannel(username, channelname)
+	return nil
+}
+
+func (r *Replayer) parseUnmuteChannel(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - UnmuteChannel - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - UnmuteChannel - Username not a string")
+	}
+
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - UnmuteChannel - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - UnmuteChannel - Channelname not a string")
+	}
+
+	r.actor.UnmuteChannel(username, channelname)
+	return nil
+}
+
+func (r *Replayer) parseCreateChannel(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - CreateChannel - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - CreateChannel - Channelname not a string")
+	}
+
+	r.actor.CreateChannel(channelname)
+	return nil
+}
+
+func (r *Replayer) parseDeleteChannel(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - DeleteChannel - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - DeleteChannel - Channelname not a string")
+	}
+
+	r.actor.DeleteChannel(channelname)
+	return nil
+}
+
+func (r *Replayer) parseRestoreChannel(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - RestoreChannel - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - RestoreChannel - Channelname not a string")
+	}
+
+	r.actor.RestoreChannel(channelname)
+	return nil
+}
+
+func (r *Replayer) parseSetChannelTopic(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - SetChannelTopic - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - SetChannelTopic - Channelname not a string")
+	}
+
+	if _, ok := (*action)["Topic"]; !ok {
+		return errors.New("invalid input log file - SetChannelTopic - missing Topic")
+	}
+	topic, ok := (*action)["Topic"].(string)
+	if !ok {
+		return errors.New("invalid input log file - SetChannelTopic - Topic not a string")
+	}
+
+	r.actor.SetChannelTopic(channelname, topic)
+	return nil
+}
+
+func (r *Replayer) parseSetChannelRules(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - SetChannelRules - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - SetChannelRules - Channelname not a string")
+	}
+
+	if _, ok := (*action)["Language"]; !ok {
+		return errors.New("invalid input log file - SetChannelRules - missing Language")
+	}
+	language, ok := (*action)["Language"].(string)
+	if !ok {
+		return errors.New("invalid input log file - SetChannelRules - Language not a string")
+	}
+
+	if _, ok := (*action)["Rules"]; !ok {
+		return errors.New("invalid input log file - SetChannelRules - missing Rules")
+	}
+	rules, ok := (*action)["Rules"].(string)
+	if !ok {
+		return errors.New("invalid input log file - SetChannelRules - Rules not a string")
+	}
+
+	r.actor.SetChannelRules(channelname, language, rules)
+	return nil
+}
+
+func (r *Replayer) parseJoinChannel(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - JoinChannel - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - JoinChannel - Username not a string")
+	}
+
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - JoinChannel - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - JoinChannel - Channelname not a string")
+	}
+
+	r.actor.JoinChannel(username, channelname)
+	return nil
+}
+
+func (r *Replayer) parseLeaveChannel(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - LeaveChannel - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - LeaveChannel - Username not a string")
+	}
+
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - LeaveChannel - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - LeaveChannel - Channelname not a string")
+	}
+
+	r.actor.LeaveChannel(username, channelname)
+	return nil
+}
+
+func (r *Replayer) parsePostMessage(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - PostMessage - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostMessage - Channelname not a string")
+	}
+
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - PostMessage - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostMessage - Username not a string")
+	}
+
+	if _, ok := (*action)["Timestamp"]; !ok {
+		return errors.New("invalid input log file - PostMessage - missing Timestamp")
+	}
+	timestampString, ok := (*action)["Timestamp"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostMessage - Timestamp not a string")
+	}
+	timestamp, err := time.Parse(time.RFC3339, timestampString)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := (*action)["Text"]; !ok {
+		return errors.New("invalid input log file - PostMessage - missing Text")
+	}
+	text, ok := (*action)["Text"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostMessage - Text not a string")
+	}
+
+	claimedTimestamp, err := parseClaimedTimestamp(action, "PostMessage")
+	if err != nil {
+		return err
+	}
+
+	r.actor.PostMessage(channelname, username, timestamp, claimedTimestamp, text)
+	return nil
+}
+
+// parseClaimedTimestamp parses the time claimed by the client for a posted message, which is only
+// logged when it was kept on the message (see model.MaxClockSkew).
+func parseClaimedTimestamp(action *map[string]interface{}, name string) (time.Time, error) {
+	if _, ok := (*action)["ClaimedTimestamp"]; !ok {
+		return time.Time{}, nil
+	}
+	claimedTimestampString, ok := (*action)["ClaimedTimestamp"].(string)
+	if !ok {
+		return time.Time{}, errors.New("invalid input log file - " + name + " - ClaimedTimestamp not a string")
+	}
+
+	return time.Parse(time.RFC3339, claimedTimestampString)
+}
+
+func (r *Replayer) parsePostBridgedMessage(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - Channelname not a string")
+	}
+
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - Username not a string")
+	}
+
+	if _, ok := (*action)["Timestamp"]; !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - missing Timestamp")
+	}
+	timestampString, ok := (*action)["Timestamp"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - Timestamp not a string")
+	}
+	timestamp, err := time.Parse(time.RFC3339, timestampString)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := (*action)["Text"]; !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - missing Text")
+	}
+	text, ok := (*action)["Text"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - Text not a string")
+	}
+
+	if _, ok := (*action)["OriginSystem"]; !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - missing OriginSystem")
+	}
+	originSystem, ok := (*action)["OriginSystem"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - OriginSystem not a string")
+	}
+
+	if _, ok := (*action)["OriginAuthor"]; !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - missing OriginAuthor")
+	}
+	originAuthor, ok := (*action)["OriginAuthor"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostBridgedMessage - OriginAuthor not a string")
+	}
+
+	claimedTimestamp, err := parseClaimedTimestamp(action, "PostBridgedMessage")
+	if err != nil {
+		return err
+	}
+
+	r.actor.PostBridgedMessage(channelname, username, timestamp, claimedTimestamp, text, originSystem, originAuthor)
+	return nil
+}
+
+func (r *Replayer) parseSetUserProfile(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - SetUserProfile - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - SetUserProfile - Username not a string")
+	}
+
+	if _, ok := (*action)["DisplayName"]; !ok {
+		return errors.New("invalid input log file - SetUserProfile - missing DisplayName")
+	}
+	displayName, ok := (*action)["DisplayName"].(string)
+	if !ok {
+		return errors.New("invalid input log file - SetUserProfile - DisplayName not a string")
+	}
+
+	if _, ok := (*action)["Bio"]; !ok {
+		return errors.New("invalid input log file - SetUserProfile - missing Bio")
+	}
+	bio, ok := (*action)["Bio"].(string)
+	if !ok {
+		return errors.New("invalid input log file - SetUserProfile - Bio not a string")
+	}
+
+	if _, ok := (*action)["Pronouns"]; !ok {
+		return errors.New("invalid input log file - SetUserProfile - missing Pronouns")
+	}
+	pronouns, ok := (*action)["Pronouns"].(string)
+	if !ok {
+		return errors.New("invalid input log file - SetUserProfile - Pronouns not a string")
+	}
+
+	r.actor.SetUserProfile(username, displayName, bio, pronouns)
+	return nil
+}
+
+func (r *Replayer) parseSetUserStatus(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - SetUserStatus - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - SetUserStatus - Username not a string")
+	}
+
+	if _, ok := (*action)["Text"]; !ok {
+		return errors.New("invalid input log file - SetUserStatus - missing Text")
+	}
+	text, ok := (*action)["Text"].(string)
+	if !ok {
+		return errors.New("invalid input log file - SetUserStatus - Text not a string")
+	}
+
+	if _, ok := (*action)["Away"]; !ok {
+		return errors.New("invalid input log file - SetUserStatus - missing Away")
+	}
+	away, ok := (*action)["Away"].(bool)
+	if !ok {
+		return errors.New("invalid input log file - SetUserStatus - Away not a bool")
+	}
+
+	r.actor.SetUserStatus(username, text, away)
+	return nil
+}
+
+func (r *Replayer) parsePostSnippet(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - PostSnippet - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostSnippet - Channelname not a string")
+	}
+
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - PostSnippet - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostSnippet - Username not a string")
+	}
+
+	if _, ok := (*action)["Timestamp"]; !ok {
+		return errors.New("invalid input log file - PostSnippet - missing Timestamp")
+	}
+	timestampString, ok := (*action)["Timestamp"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostSnippet - Timestamp not a string")
+	}
+	timestamp, err := time.Parse(time.RFC3339, timestampString)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := (*action)["Language"]; !ok {
+		return errors.New("invalid input log file - PostSnippet - missing Language")
+	}
+	language, ok := (*action)["Language"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostSnippet - Language not a string")
+	}
+
+	if _, ok := (*action)["Text"]; !ok {
+		return errors.New("invalid input log file - PostSnippet - missing Text")
+	}
+	text, ok := (*action)["Text"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostSnippet - Text not a string")
+	}
+
+	claimedTimestamp, err := parseClaimedTimestamp(action, "PostSnippet")
+	if err != nil {
+		return err
+	}
+
+	r.actor.PostSnippet(channelname, username, timestamp, claimedTimestamp, language, text)
+	return nil
+}
+
+func (r *Replayer) parseEditMessage(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - EditMessage - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - EditMessage - Channelname not a string")
+	}
+
+	if _, ok := (*action)["MessageID"]; !ok {
+		return errors.New("invalid input log file - EditMessage - missing MessageID")
+	}
+	messageID, ok := (*action)["MessageID"].(float64)
+	if !ok {
+		return errors.New("invalid input log file - EditMessage - MessageID not a number")
+	}
+
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - EditMessage - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - EditMessage - Username not a string")
+	}
+
+	if _, ok := (*action)["Timestamp"]; !ok {
+		return errors.New("invalid input log file - EditMessage - missing Timestamp")
+	}
+	timestampString, ok := (*action)["Timestamp"].(string)
+	if !ok {
+		return errors.New("invalid input log file - EditMessage - Timestamp not a string")
+	}
+	timestamp, err := time.Parse(time.RFC3339, timestampString)
 	if err != nil {
 		return err
 	}
 
-	// Parse the json string
-	var result []map[string]interface{}
-	err = json.Unmarshal(wholeFile, &result)
+	if _, ok := (*action)["Text"]; !ok {
+		return errors.New("invalid input log file - EditMessage - missing Text")
+	}
+	text, ok := (*action)["Text"].(string)
+	if !ok {
+		return errors.New("invalid input log file - EditMessage - Text not a string")
+	}
+
+	r.actor.EditMessage(channelname, uint64(messageID), username, timestamp, text)
+	return nil
+}
+
+func (r *Replayer) parseDeleteMessage(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - DeleteMessage - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - DeleteMessage - Channelname not a string")
+	}
+
+	if _, ok := (*action)["MessageID"]; !ok {
+		return errors.New("invalid input log file - DeleteMessage - missing MessageID")
+	}
+	messageID, ok := (*action)["MessageID"].(float64)
+	if !ok {
+		return errors.New("invalid input log file - DeleteMessage - MessageID not a number")
+	}
+
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - DeleteMessage - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - DeleteMessage - Username not a string")
+	}
+
+	r.actor.DeleteMessage(channelname, uint64(messageID), username)
+	return nil
+}
+
+func (r *Replayer) parsePostDirectMessage(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - PostDirectMessage - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostDirectMessage - Username not a string")
+	}
+
+	if _, ok := (*action)["Recipient"]; !ok {
+		return errors.New("invalid input log file - PostDirectMessage - missing Recipient")
+	}
+	recipient, ok := (*action)["Recipient"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostDirectMessage - Recipient not a string")
+	}
+
+	if _, ok := (*action)["Timestamp"]; !ok {
+		return errors.New("invalid input log file - PostDirectMessage - missing Timestamp")
+	}
+	timestampString, ok := (*action)["Timestamp"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostDirectMessage - Timestamp not a string")
+	}
+	timestamp, err := time.Parse(time.RFC3339, timestampString)
 	if err != nil {
-		return errors.New("invalid input log file - malformed json")
+		return err
 	}
 
-	// Parse the action entries
-	for _, action := range result {
-		// Disregard empty entries
-		if len(action) == 0 {
-			continue
+	if _, ok := (*action)["Text"]; !ok {
+		return errors.New("invalid input log file - PostDirectMessage - missing Text")
+	}
+	text, ok := (*action)["Text"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostDirectMessage - Text not a string")
+	}
+
+	claimedTimestamp, err := parseClaimedTimestamp(action, "PostDirectMessage")
+	if err != nil {
+		return err
+	}
+
+	r.actor.PostDirectMessage(username, recipient, timestamp, claimedTimestamp, text)
+	return nil
+}
+
+func (r *Replayer) parseCreateGroup(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - CreateGroup - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - CreateGroup - Username not a string")
+	}
+
+	if _, ok := (*action)["Members"]; !ok {
+		return errors.New("invalid input log file - CreateGroup - missing Members")
+	}
+	memberValues, ok := (*action)["Members"].([]interface{})
+	if !ok {
+		return errors.New("invalid input log file - CreateGroup - Members not a list")
+	}
+	members := make([]string, len(memberValues))
+	for i, memberValue := range memberValues {
+		members[i], ok = memberValue.(string)
+		if !ok {
+			return errors.New("invalid input log file - CreateGroup - Members not a list of strings")
 		}
+	}
 
-		// Parse the individual action
-		err = r.parseAction(&action)
+	r.actor.CreateGroup(username, members)
+	return nil
+}
+
+func (r *Replayer) parsePostGroupMessage(action *map[string]interface{}) error {
+	if _, ok := (*action)["GroupID"]; !ok {
+		return errors.New("invalid input log file - PostGroupMessage - missing GroupID")
+	}
+	groupID, ok := (*action)["GroupID"].(float64)
+	if !ok {
+		return errors.New("invalid input log file - PostGroupMessage - GroupID not a number")
+	}
+
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - PostGroupMessage - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostGroupMessage - Username not a string")
+	}
+
+	if _, ok := (*action)["Timestamp"]; !ok {
+		return errors.New("invalid input log file - PostGroupMessage - missing Timestamp")
+	}
+	timestampString, ok := (*action)["Timestamp"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostGroupMessage - Timestamp not a string")
+	}
+	timestamp, err := time.Parse(time.RFC3339, timestampString)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := (*action)["Text"]; !ok {
+		return errors.New("invalid input log file - PostGroupMessage - missing Text")
+	}
+	text, ok := (*action)["Text"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostGroupMessage - Text not a string")
+	}
+
+	claimedTimestamp, err := parseClaimedTimestamp(action, "PostGroupMessage")
+	if err != nil {
+		return err
+	}
+
+	r.actor.PostGroupMessage(uint64(groupID), username, timestamp, claimedTimestamp, text)
+	return nil
+}
+
+func (r *Replayer) parsePutPluginData(action *map[string]interface{}) error {
+	if _, ok := (*action)["Namespace"]; !ok {
+		return errors.New("invalid input log file - PutPluginData - missing Namespace")
+	}
+	namespace, ok := (*action)["Namespace"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PutPluginData - Namespace not a string")
+	}
+
+	if _, ok := (*action)["Key"]; !ok {
+		return errors.New("invalid input log file - PutPluginData - missing Key")
+	}
+	key, ok := (*action)["Key"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PutPluginData - Key not a string")
+	}
+
+	if _, ok := (*action)["Value"]; !ok {
+		return errors.New("invalid input log file - PutPluginData - missing Value")
+	}
+	value, ok := (*action)["Value"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PutPluginData - Value not a string")
+	}
+
+	r.actor.PutPluginData(namespace, key, value)
+	return nil
+}
+
+func (r *Replayer) parseRestoreSnapshot(action *map[string]interface{}) error {
+	if _, ok := (*action)["Snapshot"]; !ok {
+		return errors.New("invalid input log file - RestoreSnapshot - missing Snapshot")
+	}
+
+	// The snapshot is nested too deeply to check field by field, so decode it again as a Snapshot
+	jsonSnapshot, err := json.Marshal((*action)["Snapshot"])
+	if err != nil {
+		return err
+	}
+
+	snapshot := Snapshot{}
+	err = json.Unmarshal(jsonSnapshot, &snapshot)
+	if err != nil {
+		return errors.New("invalid input log file - RestoreSnapshot - malformed Snapshot")
+	}
+
+	r.actor.RestoreSnapshot(&snapshot)
+	return nil
+}
+
+func (r *Replayer) parseBatch(action *map[string]interface{}) error {
+	if _, ok := (*action)["Actions"]; !ok {
+		return errors.New("invalid input log file - Batch - missing Actions")
+	}
+	batchActions, ok := (*action)["Actions"].([]interface{})
+	if !ok {
+		return errors.New("invalid input log file - Batch - Actions not array")
+	}
+
+	// Check the actions before replaying any of them, so a batch is replayed as a unit
+	parsedActions := make([]map[string]interface{}, len(batchActions))
+	for i, batchAction := range batchActions {
+		parsedAction, ok := batchAction.(map[string]interface{})
+		if !ok {
+			return errors.New("invalid input log file - Batch - action not object")
+		}
+		actionStruct, ok := parsedAction["Action"].(map[string]interface{})
+		if !ok || actionStruct["Name"] == "Batch" {
+			return errors.New("invalid input log file - Batch - invalid action")
+		}
+		parsedActions[i] = parsedAction
+	}
+
+	r.actor.BeginBatch()
+	for i := range parsedActions {
+		err := r.parseAction(&parsedActions[i])
 		if err != nil {
 			return err
 		}
 	}
+	r.actor.EndBatch()
+
+	return nil
+}
+
+func (r *Replayer) parseAttachFile(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - AttachFile - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - AttachFile - Channelname not a string")
+	}
+
+	if _, ok := (*action)["MessageID"]; !ok {
+		return errors.New("invalid input log file - AttachFile - missing MessageID")
+	}
+	messageID, ok := (*action)["MessageID"].(float64)
+	if !ok {
+		return errors.New("invalid input log file - AttachFile - MessageID not a number")
+	}
+
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - AttachFile - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - AttachFile - Username not a string")
+	}
+
+	if _, ok := (*action)["Attachment"]; !ok {
+		return errors.New("invalid input log file - AttachFile - missing Attachment")
+	}
+
+	// Decode the attachment again as an Attachment, as with the snapshots
+	jsonAttachment, err := json.Marshal((*action)["Attachment"])
+	if err != nil {
+		return err
+	}
+
+	attachment := Attachment{}
+	err = json.Unmarshal(jsonAttachment, &attachment)
+	if err != nil {
+		return errors.New("invalid input log file - AttachFile - malformed Attachment")
+	}
+
+	r.actor.AttachFile(channelname, uint64(messageID), username, attachment)
+	return nil
+}
+
+func (r *Replayer) parseRenameChannel(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - RenameChannel - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - RenameChannel - Channelname not a string")
+	}
+
+	if _, ok := (*action)["NewChannelname"]; !ok {
+		return errors.New("invalid input log file - RenameChannel - missing NewChannelname")
+	}
+	newChannelname, ok := (*action)["NewChannelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - RenameChannel - NewChannelname not a string")
+	}
+
+	r.actor.RenameChannel(channelname, newChannelname)
+	return nil
+}
+
+func (r *Replayer) parseRenameUser(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - RenameUser - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - RenameUser - Username not a string")
+	}
+
+	if _, ok := (*action)["NewUsername"]; !ok {
+		return errors.New("invalid input log file - RenameUser - missing NewUsername")
+	}
+	newUsername, ok := (*action)["NewUsername"].(string)
+	if !ok {
+		return errors.New("invalid input log file - RenameUser - NewUsername not a string")
+	}
+
+	r.actor.RenameUser(username, newUsername)
+	return nil
+}
+
+func (r *Replayer) parseCreateTeam(action *map[string]interface{}) error {
+	if _, ok := (*action)["Teamname"]; !ok {
+		return errors.New("invalid input log file - CreateTeam - missing Teamname")
+	}
+	teamname, ok := (*action)["Teamname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - CreateTeam - Teamname not a string")
+	}
+
+	r.actor.CreateTeam(teamname)
+	return nil
+}
+
+func (r *Replayer) parseDeleteTeam(action *map[string]interface{}) error {
+	if _, ok := (*action)["Teamname"]; !ok {
+		return errors.New("invalid input log file - DeleteTeam - missing Teamname")
+	}
+	teamname, ok := (*action)["Teamname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - DeleteTeam - Teamname not a string")
+	}
+
+	r.actor.DeleteTeam(teamname)
+	return nil
+}
+
+func (r *Replayer) parseAddTeamMember(action *map[string]interface{}) error {
+	if _, ok := (*action)["Teamname"]; !ok {
+		return errors.New("invalid input log file - AddTeamMember - missing Teamname")
+	}
+	teamname, ok := (*action)["Teamname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - AddTeamMember - Teamname not a string")
+	}
+
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - AddTeamMember - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - AddTeamMember - Username not a string")
+	}
+
+	r.actor.AddTeamMember(teamname, username)
+	return nil
+}
+
+func (r *Replayer) parseRemoveTeamMember(action *map[string]interface{}) error {
+	if _, ok := (*action)["Teamname"]; !ok {
+		return errors.New("invalid input log file - RemoveTeamMember - missing Teamname")
+	}
+	teamname, ok := (*action)["Teamname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - RemoveTeamMember - Teamname not a string")
+	}
+
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - RemoveTeamMember - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - RemoveTeamMember - Username not a string")
+	}
 
+	r.actor.RemoveTeamMember(teamname, username)
 	return nil
 }
 
-func (r *Replayer) parseAction(action *map[string]interface{}) error {
-	if _, ok := (*action)["Action"]; !ok {
-		return errors.New("invalid input log file - action not found")
+func (r *Replayer) parseCreateEvent(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - CreateEvent - missing Channelname")
+	}
+	channelname, ok := (*action)["Channelname"].(string)
+	if !ok {
+		return errors.New("invalid input log file - CreateEvent - Channelname not a string")
 	}
 
-	actionStruct := (*action)["Action"].(map[string]interface{})
-
-	if _, ok := actionStruct["Name"]; !ok {
-		return errors.New("invalid input log file - name not found")
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - CreateEvent - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - CreateEvent - Username not a string")
 	}
 
-	actionName, ok := actionStruct["Name"].(string)
+	if _, ok := (*action)["Start"]; !ok {
+		return errors.New("invalid input log file - CreateEvent - missing Start")
+	}
+	startString, ok := (*action)["Start"].(string)
 	if !ok {
-		return errors.New("invalid input log file - name not string")
+		return errors.New("invalid input log file - CreateEvent - Start not a string")
+	}
+	start, err := time.Parse(time.RFC3339, startString)
+	if err != nil {
+		return err
 	}
 
-	switch actionName {
-	case "CreateUser":
-		err := r.parseCreateUser(action)
-		if err != nil {
-			return err
-		}
-	case "DeleteUser":
-		err := r.parseDeleteUser(action)
-		if err != nil {
-			return err
-		}
-	case "BlockUser":
-		err := r.parseBlockUser(action)
-		if err != nil {
-			return err
-		}
-	case "UnblockUser":
-		err := r.parseUnblockUser(action)
-		if err != nil {
-			return err
-		}
-	case "CreateChannel":
-		err := r.parseCreateChannel(action)
-		if err != nil {
-			return err
-		}
-	case "DeleteChannel":
-		err := r.parseDeleteChannel(action)
-		if err != nil {
-			return err
-		}
-	case "PostMessage":
-		err := r.parsePostMessage(action)
-		if err != nil {
-			return err
-		}
-	default:
-		return errors.New("invalid input log file - unknown action")
+	if _, ok := (*action)["Title"]; !ok {
+		return errors.New("invalid input log file - CreateEvent - missing Title")
+	}
+	title, ok := (*action)["Title"].(string)
+	if !ok {
+		return errors.New("invalid input log file - CreateEvent - Title not a string")
 	}
 
+	r.actor.CreateEvent(channelname, username, start, title)
 	return nil
 }
 
-func (r *Replayer) parseCreateUser(action *map[string]interface{}) error {
+func (r *Replayer) parseRSVPEvent(action *map[string]interface{}) error {
+	if _, ok := (*action)["EventID"]; !ok {
+		return errors.New("invalid input log file - RSVPEvent - missing EventID")
+	}
+	eventID, ok := (*action)["EventID"].(float64)
+	if !ok {
+		return errors.New("invalid input log file - RSVPEvent - EventID not a number")
+	}
+
 	if _, ok := (*action)["Username"]; !ok {
-		return errors.New("invalid input log file - CreateUser - missing Username")
+		return errors.New("invalid input log file - RSVPEvent - missing Username")
 	}
 	username, ok := (*action)["Username"].(string)
 	if !ok {
-		return errors.New("invalid input log file - CreateUser - Username not a string")
+		return errors.New("invalid input log file - RSVPEvent - Username not a string")
 	}
 
-	r.actor.CreateUser(username)
+	if _, ok := (*action)["Going"]; !ok {
+		return errors.New("invalid input log file - RSVPEvent - missing Going")
+	}
+	going, ok := (*action)["Going"].(bool)
+	if !ok {
+		return errors.New("invalid input log file - RSVPEvent - Going not a bool")
+	}
+
+	r.actor.RSVPEvent(uint64(eventID), username, going)
 	return nil
 }
 
-func (r *Replayer) parseDeleteUser(action *map[string]interface{}) error {
-	if _, ok := (*action)["Username"]; !ok {
-		return errors.New("invalid input log file - DeleteUser - missing Username")
+func (r *Replayer) parseMarkEventReminded(action *map[string]interface{}) error {
+	if _, ok := (*action)["EventID"]; !ok {
+		return errors.New("invalid input log file - MarkEventReminded - missing EventID")
 	}
-	username, ok := (*action)["Username"].(string)
+	eventID, ok := (*action)["EventID"].(float64)
 	if !ok {
-		return errors.New("invalid input log file - DeleteUser - Username not a string")
+		return errors.New("invalid input log file - MarkEventReminded - EventID not a number")
 	}
 
-	r.actor.DeleteUser(username)
+	r.actor.MarkEventReminded(uint64(eventID))
 	return nil
 }
 
-func (r *Replayer) parseBlockUser(action *map[string]interface{}) error {
+func (r *Replayer) parseMarkRead(action *map[string]interface{}) error {
 	if _, ok := (*action)["Username"]; !ok {
-		return errors.New("invalid input log file - BlockUser - missing Username")
+		return errors.New("invalid input log file - MarkRead - missing Username")
 	}
 	username, ok := (*action)["Username"].(string)
 	if !ok {
-		return errors.New("invalid input log file - BlockUser - Username not a string")
+		return errors.New("invalid input log file - MarkRead - Username not a string")
 	}
 
-	if _, ok := (*action)["UsernameToBlock"]; !ok {
-		return errors.New("invalid input log file - BlockUser - missing UsernameToBlock")
+	if _, ok := (*action)["Channelname"]; !ok {
+		return errors.New("invalid input log file - MarkRead - missing Channelname")
 	}
-	usernameToBlock, ok := (*action)["UsernameToBlock"].(string)
+	channelname, ok := (*action)["Channelname"].(string)
 	if !ok {
-		return errors.New("invalid input log file - BlockUser - UsernameToBlock not a string")
+		return errors.New("invalid input log file - MarkRead - Channelname not a string")
 	}
 
-	r.actor.BlockUser(username, usernameToBlock)
+	if _, ok := (*action)["MessageID"]; !ok {
+		return errors.New("invalid input log file - MarkRead - missing MessageID")
+	}
+	messageID, ok := (*action)["MessageID"].(float64)
+	if !ok {
+		return errors.New("invalid input log file - MarkRead - MessageID not a number")
+	}
+
+	r.actor.MarkRead(username, channelname, uint64(messageID))
 	return nil
 }
 
-func (r *Replayer) parseUnblockUser(action *map[string]interface{}) error {
+func (r *Replayer) parsePostMessageMulti(action *map[string]interface{}) error {
+	if _, ok := (*action)["Channelnames"]; !ok {
+		return errors.New("invalid input log file - PostMessageMulti - missing Channelnames")
+	}
+	channelnameValues, ok := (*action)["Channelnames"].([]interface{})
+	if !ok {
+		return errors.New("invalid input log file - PostMessageMulti - Channelnames not a list")
+	}
+	channelnames := make([]string, len(channelnameValues))
+	for i, channelnameValue := range channelnameValues {
+		channelnames[i], ok = channelnameValue.(string)
+		if !ok {
+			return errors.New("invalid input log file - PostMessageMulti - Channelnames not a list of strings")
+		}
+	}
+
 	if _, ok := (*action)["Username"]; !ok {
-		return errors.New("invalid input log file - UnblockUser - missing Username")
+		return errors.New("invalid input log file - PostMessageMulti - missing Username")
 	}
 	username, ok := (*action)["Username"].(string)
 	if !ok {
-		return errors.New("invalid input log file - UnblockUser - Username not a string")
+		return errors.New("invalid input log file - PostMessageMulti - Username not a string")
 	}
 
-	if _, ok := (*action)["UsernameToUnblock"]; !ok {
-		return errors.New("invalid input log file - UnblockUser - missing UsernameToUnblock")
+	if _, ok := (*action)["Timestamp"]; !ok {
+		return errors.New("invalid input log file - PostMessageMulti - missing Timestamp")
 	}
-	usernameToUnblock, ok := (*action)["UsernameToUnblock"].(string)
+	timestampString, ok := (*action)["Timestamp"].(string)
 	if !ok {
-		return errors.New("invalid input log file - UnblockUser - UsernameToUnblock not a string")
+		return errors.New("invalid input log file - PostMessageMulti - Timestamp not a string")
+	}
+	timestamp, err := time.Parse(time.RFC3339, timestampString)
+	if err != nil {
+		return err
 	}
 
-	r.actor.UnblockUser(username, usernameToUnblock)
+	if _, ok := (*action)["Text"]; !ok {
+		return errors.New("invalid input log file - PostMessageMulti - missing Text")
+	}
+	text, ok := (*action)["Text"].(string)
+	if !ok {
+		return errors.New("invalid input log file - PostMessageMulti - Text not a string")
+	}
+
+	claimedTimestamp, err := parseClaimedTimestamp(action, "PostMessageMulti")
+	if err != nil {
+		return err
+	}
+
+	r.actor.PostMessageMulti(channelnames, username, timestamp, claimedTimestamp, text)
 	return nil
 }
 
-func (r *Replayer) parseCreateChannel(action *map[string]interface{}) error {
+func (r *Replayer) parseStarMessage(action *map[string]interface{}) error {
+	if _, ok := (*action)["Username"]; !ok {
+		return errors.New("invalid input log file - StarMessage - missing Username")
+	}
+	username, ok := (*action)["Username"].(string)
+	if !ok {
+		return errors.New("invalid input log file - StarMessage - Username not a string")
+	}
+
 	if _, ok := (*action)["Channelname"]; !ok {
-		return errors.New("invalid input log file - CreateChannel - missing Channelname")
+		return errors.New("invalid input log file - StarMessage - missing Channelname")
 	}
 	channelname, ok := (*action)["Channelname"].(string)
 	if !ok {
-		return errors.New("invalid input log file - CreateChannel - Channelname not a string")
+		return errors.New("invalid input log file - StarMessage - Channelname not a string")
 	}
 
-	r.actor.CreateChannel(channelname)
-	return nil
-}
+	if _, ok := (*action)["MessageID"]; !ok {
+		return errors.New("invalid input log file - StarMessage - missing MessageID")
+	}
+	messageID, ok := (*action)["MessageID"].(float64)
+	if !ok {
+		return errors.New("invalid input log file - StarMessage - MessageID not a number")
+	}
 
-func (r *Replayer) parseDeleteChannel(action *map[string]interface{}) error {
-	if _, ok := (*action)["Channelname"]; !ok {
-		return errors.New("invalid input log file - DeleteChannel - missing Channelname")
+	if _, ok := (*action)["Starred"]; !ok {
+		return errors.New("invalid input log file - StarMessage - missing Starred")
 	}
-	channelname, ok := (*action)["Channelname"].(string)
+	starred, ok := (*action)["Starred"].(bool)
 	if !ok {
-		return errors.New("invalid input log file - DeleteChannel - Channelname not a string")
+		return errors.New("invalid input log file - StarMessage - Starred not a bool")
 	}
 
-	r.actor.DeleteChannel(channelname)
+	r.actor.StarMessage(username, channelname, uint64(messageID), starred)
 	return nil
 }
 
-func (r *Replayer) parsePostMessage(action *map[string]interface{}) error {
+func (r *Replayer) parseSetChannelNotes(action *map[string]interface{}) error {
 	if _, ok := (*action)["Channelname"]; !ok {
-		return errors.New("invalid input log file - PostMessage - missing Channelname")
+		return errors.New("invalid input log file - SetChannelNotes - missing Channelname")
 	}
 	channelname, ok := (*action)["Channelname"].(string)
 	if !ok {
-		return errors.New("invalid input log file - PostMessage - Channelname not a string")
+		return errors.New("invalid input log file - SetChannelNotes - Channelname not a string")
 	}
 
 	if _, ok := (*action)["Username"]; !ok {
-		return errors.New("invalid input log file - PostMessage - missing Username")
+		return errors.New("invalid input log file - SetChannelNotes - missing Username")
 	}
 	username, ok := (*action)["Username"].(string)
 	if !ok {
-		return errors.New("invalid input log file - PostMessage - Username not a string")
+		return errors.New("invalid input log file - SetChannelNotes - Username not a string")
 	}
 
 	if _, ok := (*action)["Timestamp"]; !ok {
-		return errors.New("invalid input log file - PostMessage - missing Timestamp")
+		return errors.New("invalid input log file - SetChannelNotes - missing Timestamp")
 	}
 	timestampString, ok := (*action)["Timestamp"].(string)
 	if !ok {
-		return errors.New("invalid input log file - PostMessage - Timestamp not a string")
+		return errors.New("invalid input log file - SetChannelNotes - Timestamp not a string")
 	}
 	timestamp, err := time.Parse(time.RFC3339, timestampString)
 	if err != nil {
@@ -514,13 +2891,360 @@ func (r *Replayer) parsePostMessage(action *map[string]interface{}) error {
 	}
 
 	if _, ok := (*action)["Text"]; !ok {
-		return errors.New("invalid input log file - PostMessage - missing Text")
+		return errors.New("invalid input log file - SetChannelNotes - missing Text")
 	}
 	text, ok := (*action)["Text"].(string)
 	if !ok {
-		return errors.New("invalid input log file - PostMessage - Text not a string")
+		return errors.New("invalid input log file - SetChannelNotes - Text not a string")
 	}
 
-	r.actor.PostMessage(channelname, username, timestamp, text)
+	r.actor.SetChannelNotes(channelname, username, timestamp, text)
 	return nil
 }
+
+// Fanout provides the Actor interface and forwards each action, in order, to every one of
+// its Actors (e.g. the Logger and any read replicas of the model).  If an Actor fails to record an
+// action (see Recorder), it isn't forwarded to the Actors after it, so the read replicas never
+// apply an action the log left out.
+type Fanout struct {
+	actors   []Actor
+	err      error
+	batching bool
+	batch    []func(actor Actor)
+}
+
+// NewFanout creates/initializes/returns a new Fanout.
+func NewFanout(actors ...Actor) *Fanout {
+	fanout := Fanout{
+		actors: actors,
+	}
+
+	return &fanout
+}
+
+// Err returns the error of the Actor that failed to record the latest action (nil if none did).
+func (f *Fanout) Err() error {
+	return f.err
+}
+
+// forward passes an action to the Actors in order, stopping at the first one that fails to
+// record it.  The actions of a batch are kept until it ends.
+func (f *Fanout) forward(action func(actor Actor)) {
+	f.err = nil
+	if f.batching {
+		f.batch = append(f.batch, action)
+		return
+	}
+
+	for _, actor := range f.actors {
+		action(actor)
+		if recorder, ok := actor.(Recorder); ok {
+			f.err = recorder.Err()
+			if f.err != nil {
+				return
+			}
+		}
+	}
+}
+
+// CreateUser forwards a CreateUser action.
+func (f *Fanout) CreateUser(username string) {
+	f.forward(func(actor Actor) {
+		actor.CreateUser(username)
+	})
+}
+
+// CreateVirtualUser forwards a CreateVirtualUser action.
+func (f *Fanout) CreateVirtualUser(ownerUsername string, username string) {
+	f.forward(func(actor Actor) {
+		actor.CreateVirtualUser(ownerUsername, username)
+	})
+}
+
+// DeleteUser forwards a DeleteUser action.
+func (f *Fanout) DeleteUser(username string) {
+	f.forward(func(actor Actor) {
+		actor.DeleteUser(username)
+	})
+}
+
+// BlockUser forwards a BlockUser action.
+func (f *Fanout) BlockUser(username string, usernameToBlock string) {
+	f.forward(func(actor Actor) {
+		actor.BlockUser(username, usernameToBlock)
+	})
+}
+
+// UnblockUser forwards an UnblockUser action.
+func (f *Fanout) UnblockUser(username string, usernameToUnblock string) {
+	f.forward(func(actor Actor) {
+		actor.UnblockUser(username, usernameToUnblock)
+	})
+}
+
+// MuteChannel forwards a MuteChannel action.
+func (f *Fanout) MuteChannel(username string, channelname string) {
+	f.forward(func(actor Actor) {
+		actor.MuteChannel(username, channelname)
+	})
+}
+
+// UnmuteChannel forwards an UnmuteChannel action.
+func (f *Fanout) UnmuteChannel(username string, channelname string) {
+	f.forward(func(actor Actor) {
+		actor.UnmuteChannel(username, channelname)
+	})
+}
+
+// CreateChannel forwards a CreateChannel action.
+func (f *Fanout) CreateChannel(channelname string) {
+	f.forward(func(actor Actor) {
+		actor.CreateChannel(channelname)
+	})
+}
+
+// DeleteChannel forwards a DeleteChannel action.
+func (f *Fanout) DeleteChannel(channelname string) {
+	f.forward(func(actor Actor) {
+		actor.DeleteChannel(channelname)
+	})
+}
+
+// RestoreChannel forwards a RestoreChannel action.
+func (f *Fanout) RestoreChannel(channelname string) {
+	f.forward(func(actor Actor) {
+		actor.RestoreChannel(channelname)
+	})
+}
+
+// SetChannelTopic forwards a SetChannelTopic action.
+func (f *Fanout) SetChannelTopic(channelname string, topic string) {
+	f.forward(func(actor Actor) {
+		actor.SetChannelTopic(channelname, topic)
+	})
+}
+
+// SetChannelRules forwards a SetChannelRules action.
+func (f *Fanout) SetChannelRules(channelname string, language string, rules string) {
+	f.forward(func(actor Actor) {
+		actor.SetChannelRules(channelname, language, rules)
+	})
+}
+
+// JoinChannel forwards a JoinChannel action.
+func (f *Fanout) JoinChannel(username string, channelname string) {
+	f.forward(func(actor Actor) {
+		actor.JoinChannel(username, channelname)
+	})
+}
+
+// LeaveChannel forwards a LeaveChannel action.
+func (f *Fanout) LeaveChannel(username string, channelname string) {
+	f.forward(func(actor Actor) {
+		actor.LeaveChannel(username, channelname)
+	})
+}
+
+// PostMessage forwards a PostMessage action.
+func (f *Fanout) PostMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	f.forward(func(actor Actor) {
+		actor.PostMessage(channelname, username, timestamp, claimedTimestamp, text)
+	})
+}
+
+// PostBridgedMessage forwards a PostBridgedMessage action.
+func (f *Fanout) PostBridgedMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string, originSystem string, originAuthor string) {
+	f.forward(func(actor Actor) {
+		actor.PostBridgedMessage(channelname, username, timestamp, claimedTimestamp, text, originSystem, originAuthor)
+	})
+}
+
+// SetUserProfile forwards a SetUserProfile action.
+func (f *Fanout) SetUserProfile(username string, displayName string, bio string, pronouns string) {
+	f.forward(func(actor Actor) {
+		actor.SetUserProfile(username, displayName, bio, pronouns)
+	})
+}
+
+// SetUserStatus forwards a SetUserStatus action.
+func (f *Fanout) SetUserStatus(username string, text string, away bool) {
+	f.forward(func(actor Actor) {
+		actor.SetUserStatus(username, text, away)
+	})
+}
+
+// PostSnippet forwards a PostSnippet action.
+func (f *Fanout) PostSnippet(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, language string, text string) {
+	f.forward(func(actor Actor) {
+		actor.PostSnippet(channelname, username, timestamp, claimedTimestamp, language, text)
+	})
+}
+
+// EditMessage forwards an EditMessage action.
+func (f *Fanout) EditMessage(channelname string, messageID uint64, username string, timestamp time.Time, text string) {
+	f.forward(func(actor Actor) {
+		actor.EditMessage(channelname, messageID, username, timestamp, text)
+	})
+}
+
+// DeleteMessage forwards a DeleteMessage action.
+func (f *Fanout) DeleteMessage(channelname string, messageID uint64, username string) {
+	f.forward(func(actor Actor) {
+		actor.DeleteMessage(channelname, messageID, username)
+	})
+}
+
+// PostDirectMessage forwards a PostDirectMessage action.
+func (f *Fanout) PostDirectMessage(username string, recipient string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	f.forward(func(actor Actor) {
+		actor.PostDirectMessage(username, recipient, timestamp, claimedTimestamp, text)
+	})
+}
+
+// CreateGroup forwards a CreateGroup action.
+func (f *Fanout) CreateGroup(username string, members []string) {
+	f.forward(func(actor Actor) {
+		actor.CreateGroup(username, members)
+	})
+}
+
+// PostGroupMessage forwards a PostGroupMessage action.
+func (f *Fanout) PostGroupMessage(groupID uint64, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	f.forward(func(actor Actor) {
+		actor.PostGroupMessage(groupID, username, timestamp, claimedTimestamp, text)
+	})
+}
+
+// PutPluginData forwards a PutPluginData action.
+func (f *Fanout) PutPluginData(namespace string, key string, value string) {
+	f.forward(func(actor Actor) {
+		actor.PutPluginData(namespace, key, value)
+	})
+}
+
+// RestoreSnapshot forwards a RestoreSnapshot action.
+func (f *Fanout) RestoreSnapshot(snapshot *Snapshot) {
+	f.forward(func(actor Actor) {
+		actor.RestoreSnapshot(snapshot)
+	})
+}
+
+// AttachFile forwards an AttachFile action.
+func (f *Fanout) AttachFile(channelname string, messageID uint64, username string, attachment Attachment) {
+	f.forward(func(actor Actor) {
+		actor.AttachFile(channelname, messageID, username, attachment)
+	})
+}
+
+// RenameChannel forwards a RenameChannel action.
+func (f *Fanout) RenameChannel(channelname string, newChannelname string) {
+	f.forward(func(actor Actor) {
+		actor.RenameChannel(channelname, newChannelname)
+	})
+}
+
+// RenameUser forwards a RenameUser action.
+func (f *Fanout) RenameUser(username string, newUsername string) {
+	f.forward(func(actor Actor) {
+		actor.RenameUser(username, newUsername)
+	})
+}
+
+// CreateTeam forwards a CreateTeam action.
+func (f *Fanout) CreateTeam(teamname string) {
+	f.forward(func(actor Actor) {
+		actor.CreateTeam(teamname)
+	})
+}
+
+// DeleteTeam forwards a DeleteTeam action.
+func (f *Fanout) DeleteTeam(teamname string) {
+	f.forward(func(actor Actor) {
+		actor.DeleteTeam(teamname)
+	})
+}
+
+// AddTeamMember forwards a AddTeamMember action.
+func (f *Fanout) AddTeamMember(teamname string, username string) {
+	f.forward(func(actor Actor) {
+		actor.AddTeamMember(teamname, username)
+	})
+}
+
+// RemoveTeamMember forwards a RemoveTeamMember action.
+func (f *Fanout) RemoveTeamMember(teamname string, username string) {
+	f.forward(func(actor Actor) {
+		actor.RemoveTeamMember(teamname, username)
+	})
+}
+
+// CreateEvent forwards a CreateEvent action.
+func (f *Fanout) CreateEvent(channelname string, username string, start time.Time, title string) {
+	f.forward(func(actor Actor) {
+		actor.CreateEvent(channelname, username, start, title)
+	})
+}
+
+// RSVPEvent forwards a RSVPEvent action.
+func (f *Fanout) RSVPEvent(eventID uint64, username string, going bool) {
+	f.forward(func(actor Actor) {
+		actor.RSVPEvent(eventID, username, going)
+	})
+}
+
+// MarkEventReminded forwards a MarkEventReminded action.
+func (f *Fanout) MarkEventReminded(eventID uint64) {
+	f.forward(func(actor Actor) {
+		actor.MarkEventReminded(eventID)
+	})
+}
+
+// MarkRead forwards a MarkRead action.
+func (f *Fanout) MarkRead(username string, channelname string, messageID uint64) {
+	f.forward(func(actor Actor) {
+		actor.MarkRead(username, channelname, messageID)
+	})
+}
+
+// PostMessageMulti forwards a PostMessageMulti action.
+func (f *Fanout) PostMessageMulti(channelnames []string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	f.forward(func(actor Actor) {
+		actor.PostMessageMulti(channelnames, username, timestamp, claimedTimestamp, text)
+	})
+}
+
+// StarMessage forwards a StarMessage action.
+func (f *Fanout) StarMessage(username string, channelname string, messageID uint64, starred bool) {
+	f.forward(func(actor Actor) {
+		actor.StarMessage(username, channelname, messageID, starred)
+	})
+}
+
+// SetChannelNotes forwards a SetChannelNotes action.
+func (f *Fanout) SetChannelNotes(channelname string, username string, timestamp time.Time, text string) {
+	f.forward(func(actor Actor) {
+		actor.SetChannelNotes(channelname, username, timestamp, text)
+	})
+}
+
+// BeginBatch starts a batch: the actions until EndBatch are kept rather than forwarded.
+func (f *Fanout) BeginBatch() {
+	f.batching = true
+	f.batch = nil
+	f.err = nil
+}
+
+// EndBatch forwards the actions kept since BeginBatch as a single batch, so an Actor after one that
+// fails to record the batch (e.g. a read replica) gets none of them.
+func (f *Fanout) EndBatch() {
+	batch := f.batch
+	f.batching = false
+	f.batch = nil
+	f.forward(func(actor Actor) {
+		actor.BeginBatch()
+		for _, action := range batch {
+			action(actor)
+		}
+		actor.EndBatch()
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"chatserver/model/actions"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -12,6 +13,11 @@ type CreateUserAction struct {
 	Username string
 }
 
+type CreateVirtualUserAction struct {
+	OwnerUsername string
+	Username      string
+}
+
 type DeleteUserAction struct {
 	Username string
 }
@@ -26,6 +32,16 @@ type UnblockUserAction struct {
 	UsernameToUnblock string
 }
 
+type MuteChannelAction struct {
+	Username    string
+	Channelname string
+}
+
+type UnmuteChannelAction struct {
+	Username    string
+	Channelname string
+}
+
 type CreateChannelAction struct {
 	Channelname string
 }
@@ -34,13 +50,195 @@ type DeleteChannelAction struct {
 	Channelname string
 }
 
+type SetChannelTopicAction struct {
+	Channelname string
+	Topic       string
+}
+
+type SetChannelRulesAction struct {
+	Channelname string
+	Language    string
+	Rules       string
+}
+
+type JoinChannelAction struct {
+	Username    string
+	Channelname string
+}
+
+type LeaveChannelAction struct {
+	Username    string
+	Channelname string
+}
+
 type PostMessageAction struct {
+	Channelname      string
+	Username         string
+	Timestamp        time.Time
+	ClaimedTimestamp time.Time
+	Text             string
+}
+
+type PostBridgedMessageAction struct {
+	Channelname  string
+	Username     string
+	Timestamp    time.Time
+	Text         string
+	OriginSystem string
+	OriginAuthor string
+}
+
+type SetUserProfileAction struct {
+	Username    string
+	DisplayName string
+	Bio         string
+	Pronouns    string
+}
+
+type SetUserStatusAction struct {
+	Username string
+	Text     string
+	Away     bool
+}
+
+type PostSnippetAction struct {
+	Channelname string
+	Username    string
+	Timestamp   time.Time
+	Language    string
+	Text        string
+}
+
+type RestoreChannelAction struct {
+	Channelname string
+}
+
+type EditMessageAction struct {
+	Channelname string
+	MessageID   uint64
+	Username    string
+	Timestamp   time.Time
+	Text        string
+}
+
+type DeleteMessageAction struct {
+	Channelname string
+	MessageID   uint64
+	Username    string
+}
+
+type PostDirectMessageAction struct {
+	Username  string
+	Recipient string
+	Timestamp time.Time
+	Text      string
+}
+
+type CreateGroupAction struct {
+	Username string
+	Members  []string
+}
+
+type PostGroupMessageAction struct {
+	GroupID   uint64
+	Username  string
+	Timestamp time.Time
+	Text      string
+}
+
+type PutPluginDataAction struct {
+	Namespace string
+	Key       string
+	Value     string
+}
+
+type RestoreSnapshotAction struct {
+	Snapshot *actions.Snapshot
+}
+
+type AttachFileAction struct {
+	Channelname string
+	MessageID   uint64
+	Username    string
+	Attachment  actions.Attachment
+}
+
+type RenameChannelAction struct {
+	Channelname    string
+	NewChannelname string
+}
+
+type RenameUserAction struct {
+	Username    string
+	NewUsername string
+}
+
+type CreateTeamAction struct {
+	Teamname string
+}
+
+type DeleteTeamAction struct {
+	Teamname string
+}
+
+type AddTeamMemberAction struct {
+	Teamname string
+	Username string
+}
+
+type RemoveTeamMemberAction struct {
+	Teamname string
+	Username string
+}
+
+type CreateEventAction struct {
+	Channelname string
+	Username    string
+	Start       time.Time
+	Title       string
+}
+
+type RSVPEventAction struct {
+	EventID  uint64
+	Username string
+	Going    bool
+}
+
+type MarkEventRemindedAction struct {
+	EventID uint64
+}
+
+type MarkReadAction struct {
+	Username    string
+	Channelname string
+	MessageID   uint64
+}
+
+type PostMessageMultiAction struct {
+	Channelnames []string
+	Username     string
+	Timestamp    time.Time
+	Text         string
+}
+
+type SetChannelNotesAction struct {
 	Channelname string
 	Username    string
 	Timestamp   time.Time
 	Text        string
 }
 
+type BeginBatchAction struct{}
+
+type EndBatchAction struct{}
+
+type StarMessageAction struct {
+	Username    string
+	Channelname string
+	MessageID   uint64
+	Starred     bool
+}
+
 type TestActor struct {
 	Actions []interface{}
 }
@@ -63,6 +261,15 @@ func (t *TestActor) CreateUser(username string) {
 	t.Actions = append(t.Actions, action)
 }
 
+func (t *TestActor) CreateVirtualUser(ownerUsername string, username string) {
+	action := CreateVirtualUserAction{
+		OwnerUsername: ownerUsername,
+		Username:      username,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
 func (t *TestActor) DeleteUser(username string) {
 	action := DeleteUserAction{
 		Username: username,
@@ -89,6 +296,24 @@ func (t *TestActor) UnblockUser(username string, usernameToUnblock string) {
 	t.Actions = append(t.Actions, action)
 }
 
+func (t *TestActor) MuteChannel(username string, channelname string) {
+	action := MuteChannelAction{
+		Username:    username,
+		Channelname: channelname,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) UnmuteChannel(username string, channelname string) {
+	action := UnmuteChannelAction{
+		Username:    username,
+		Channelname: channelname,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
 func (t *TestActor) CreateChannel(channelname string) {
 	action := CreateChannelAction{
 		Channelname: channelname,
@@ -105,9 +330,80 @@ func (t *TestActor) DeleteChannel(channelname string) {
 	t.Actions = append(t.Actions, action)
 }
 
-func (t *TestActor) PostMessage(channelname string, username string, timestamp time.Time, text string) {
+func (t *TestActor) SetChannelTopic(channelname string, topic string) {
+	action := SetChannelTopicAction{
+		Channelname: channelname,
+		Topic:       topic,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) SetChannelRules(channelname string, language string, rules string) {
+	action := SetChannelRulesAction{
+		Channelname: channelname,
+		Language:    language,
+		Rules:       rules,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) JoinChannel(username string, channelname string) {
+	action := JoinChannelAction{
+		Username:    username,
+		Channelname: channelname,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) LeaveChannel(username string, channelname string) {
+	action := LeaveChannelAction{
+		Username:    username,
+		Channelname: channelname,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) PostMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
 	action := PostMessageAction{
+		Channelname:      channelname,
+		Username:         username,
+		Timestamp:        timestamp,
+		ClaimedTimestamp: claimedTimestamp,
+		Text:             text,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) PostBridgedMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string, originSystem string, originAuthor string) {
+	action := PostBridgedMessageAction{
+		Channelname:  channelname,
+		Username:     username,
+		Timestamp:    timestamp,
+		Text:         text,
+		OriginSystem: originSystem,
+		OriginAuthor: originAuthor,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) RestoreChannel(channelname string) {
+	action := RestoreChannelAction{
+		Channelname: channelname,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) EditMessage(channelname string, messageID uint64, username string, timestamp time.Time, text string) {
+	action := EditMessageAction{
 		Channelname: channelname,
+		MessageID:   messageID,
 		Username:    username,
 		Timestamp:   timestamp,
 		Text:        text,
@@ -116,6 +412,241 @@ func (t *TestActor) PostMessage(channelname string, username string, timestamp t
 	t.Actions = append(t.Actions, action)
 }
 
+func (t *TestActor) DeleteMessage(channelname string, messageID uint64, username string) {
+	action := DeleteMessageAction{
+		Channelname: channelname,
+		MessageID:   messageID,
+		Username:    username,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) PostDirectMessage(username string, recipient string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	action := PostDirectMessageAction{
+		Username:  username,
+		Recipient: recipient,
+		Timestamp: timestamp,
+		Text:      text,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) CreateGroup(username string, members []string) {
+	action := CreateGroupAction{
+		Username: username,
+		Members:  members,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) PostGroupMessage(groupID uint64, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	action := PostGroupMessageAction{
+		GroupID:   groupID,
+		Username:  username,
+		Timestamp: timestamp,
+		Text:      text,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) PutPluginData(namespace string, key string, value string) {
+	action := PutPluginDataAction{
+		Namespace: namespace,
+		Key:       key,
+		Value:     value,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) RestoreSnapshot(snapshot *actions.Snapshot) {
+	action := RestoreSnapshotAction{
+		Snapshot: snapshot,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) AttachFile(channelname string, messageID uint64, username string, attachment actions.Attachment) {
+	action := AttachFileAction{
+		Channelname: channelname,
+		MessageID:   messageID,
+		Username:    username,
+		Attachment:  attachment,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) RenameChannel(channelname string, newChannelname string) {
+	action := RenameChannelAction{
+		Channelname:    channelname,
+		NewChannelname: newChannelname,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) SetUserProfile(username string, displayName string, bio string, pronouns string) {
+	action := SetUserProfileAction{
+		Username:    username,
+		DisplayName: displayName,
+		Bio:         bio,
+		Pronouns:    pronouns,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) SetUserStatus(username string, text string, away bool) {
+	action := SetUserStatusAction{
+		Username: username,
+		Text:     text,
+		Away:     away,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) PostSnippet(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, language string, text string) {
+	action := PostSnippetAction{
+		Channelname: channelname,
+		Username:    username,
+		Timestamp:   timestamp,
+		Language:    language,
+		Text:        text,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) RenameUser(username string, newUsername string) {
+	action := RenameUserAction{
+		Username:    username,
+		NewUsername: newUsername,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) CreateTeam(teamname string) {
+	action := CreateTeamAction{
+		Teamname: teamname,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) DeleteTeam(teamname string) {
+	action := DeleteTeamAction{
+		Teamname: teamname,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) AddTeamMember(teamname string, username string) {
+	action := AddTeamMemberAction{
+		Teamname: teamname,
+		Username: username,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) RemoveTeamMember(teamname string, username string) {
+	action := RemoveTeamMemberAction{
+		Teamname: teamname,
+		Username: username,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) CreateEvent(channelname string, username string, start time.Time, title string) {
+	action := CreateEventAction{
+		Channelname: channelname,
+		Username:    username,
+		Start:       start,
+		Title:       title,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) RSVPEvent(eventID uint64, username string, going bool) {
+	action := RSVPEventAction{
+		EventID:  eventID,
+		Username: username,
+		Going:    going,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) MarkEventReminded(eventID uint64) {
+	action := MarkEventRemindedAction{
+		EventID: eventID,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) MarkRead(username string, channelname string, messageID uint64) {
+	action := MarkReadAction{
+		Username:    username,
+		Channelname: channelname,
+		MessageID:   messageID,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) PostMessageMulti(channelnames []string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	action := PostMessageMultiAction{
+		Channelnames: channelnames,
+		Username:     username,
+		Timestamp:    timestamp,
+		Text:         text,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) StarMessage(username string, channelname string, messageID uint64, starred bool) {
+	action := StarMessageAction{
+		Username:    username,
+		Channelname: channelname,
+		MessageID:   messageID,
+		Starred:     starred,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) SetChannelNotes(channelname string, username string, timestamp time.Time, text string) {
+	action := SetChannelNotesAction{
+		Channelname: channelname,
+		Username:    username,
+		Timestamp:   timestamp,
+		Text:        text,
+	}
+
+	t.Actions = append(t.Actions, action)
+}
+
+func (t *TestActor) BeginBatch() {
+	t.Actions = append(t.Actions, BeginBatchAction{})
+}
+
+func (t *TestActor) EndBatch() {
+	t.Actions = append(t.Actions, EndBatchAction{})
+}
+
 func TestLoggerReplayerIntegrationTest(t *testing.T) {
 	// NOTE: we shouldn't be doing file I/O in the unit test
 	tempFile, err := ioutil.TempFile("", "test.*.txt")
@@ -141,9 +672,50 @@ func TestLoggerReplayerIntegrationTest(t *testing.T) {
 	logger.DeleteChannel("channel1")
 	logger.DeleteUser("user1")
 	timestamp := time.Now()
-	logger.PostMessage("General", "Anonymous", timestamp, "message1")
+	logger.PostMessage("General", "Anonymous", timestamp, timestamp.Add(-time.Hour), "message1")
 	logger.UnblockUser("user1", "Anonymous")
 	logger.CreateUser("user3")
+	logger.MuteChannel("user3", "General")
+	logger.UnmuteChannel("user3", "General")
+	logger.JoinChannel("user3", "General")
+	logger.LeaveChannel("user3", "General")
+	logger.SetChannelTopic("General", "topic1")
+	logger.SetChannelRules("General", "en", "rules1")
+	logger.PostBridgedMessage("General", "user2", timestamp, time.Time{}, "message2", "Slack", "alice")
+	logger.CreateVirtualUser("user2", "virtual1")
+	logger.PutPluginData("plugin1", "key1", "value1")
+	logger.RestoreChannel("channel1")
+	logger.EditMessage("General", 2, "user2", timestamp, "message3")
+	logger.DeleteMessage("General", 1, "")
+	logger.PostDirectMessage("user1", "user2", timestamp, time.Time{}, "message4")
+	logger.CreateGroup("user1", []string{"user2", "user3"})
+	logger.PostGroupMessage(1, "user2", timestamp, time.Time{}, "message5")
+	logger.RestoreSnapshot(&actions.Snapshot{
+		Users:         []actions.SnapshotUser{{Name: "user2", BlockedUsers: []string{"user3"}}},
+		Channels:      []actions.SnapshotChannel{{Name: "General", Members: []string{"user2"}, Messages: []actions.SnapshotMessage{{ID: 2, Username: "user2", Timestamp: timestamp, Text: "message3"}}}},
+		LastMessageID: 5,
+	})
+	logger.AttachFile("General", 2, "user2", actions.Attachment{ID: "hash1", Name: "file1.png", ContentType: "image/png", Size: 100})
+	logger.RenameChannel("channel1", "channel2")
+	logger.RenameUser("user1", "user3")
+	logger.PostSnippet("General", "user2", timestamp, time.Time{}, "go", "fmt.Println()\n")
+	logger.SetUserProfile("user2", "User Two", "bio1\nbio2", "they/them")
+	logger.CreateTeam("team1")
+	logger.AddTeamMember("team1", "user2")
+	logger.RemoveTeamMember("team1", "user2")
+	logger.DeleteTeam("team1")
+	logger.SetUserStatus("user2", "lunch", true)
+	logger.CreateEvent("General", "user2", timestamp, "Standup")
+	logger.RSVPEvent(1, "user2", true)
+	logger.MarkEventReminded(1)
+	logger.MarkRead("user2", "General", 3)
+	logger.PostMessageMulti([]string{"General", "Channel2"}, "user2", timestamp, time.Time{}, "message4")
+	logger.StarMessage("user2", "General", 3, true)
+	logger.SetChannelNotes("General", "user2", timestamp, "notes1")
+	logger.BeginBatch()
+	logger.CreateUser("user4")
+	logger.JoinChannel("user4", "General")
+	logger.EndBatch()
 
 	// Create the replayer
 	replayer, err := actions.NewReplayer(logFilePath)
@@ -192,7 +764,9 @@ func TestLoggerReplayerIntegrationTest(t *testing.T) {
 	action6 := testActor.Actions[6].(PostMessageAction)
 	expectedTimestamp := timestamp.Format(time.RFC3339)
 	action6Timestamp := action6.Timestamp.Format(time.RFC3339)
-	if action6.Channelname != "General" || action6.Username != "Anonymous" || action6Timestamp != expectedTimestamp || action6.Text != "message1" {
+	action6ClaimedTimestamp := action6.ClaimedTimestamp.Format(time.RFC3339)
+	if action6.Channelname != "General" || action6.Username != "Anonymous" || action6Timestamp != expectedTimestamp ||
+		action6ClaimedTimestamp != timestamp.Add(-time.Hour).Format(time.RFC3339) || action6.Text != "message1" {
 		t.Error("Failed to replay PostMessage action")
 	}
 
@@ -205,4 +779,421 @@ func TestLoggerReplayerIntegrationTest(t *testing.T) {
 	if action8.Username != "user3" {
 		t.Error("Failed to replay CreateUser action")
 	}
+
+	action9 := testActor.Actions[9].(MuteChannelAction)
+	if action9.Username != "user3" || action9.Channelname != "General" {
+		t.Error("Failed to replay MuteChannel action")
+	}
+
+	action10 := testActor.Actions[10].(UnmuteChannelAction)
+	if action10.Username != "user3" || action10.Channelname != "General" {
+		t.Error("Failed to replay UnmuteChannel action")
+	}
+
+	action11 := testActor.Actions[11].(JoinChannelAction)
+	if action11.Username != "user3" || action11.Channelname != "General" {
+		t.Error("Failed to replay JoinChannel action")
+	}
+
+	action12 := testActor.Actions[12].(LeaveChannelAction)
+	if action12.Username != "user3" || action12.Channelname != "General" {
+		t.Error("Failed to replay LeaveChannel action")
+	}
+
+	action13 := testActor.Actions[13].(SetChannelTopicAction)
+	if action13.Channelname != "General" || action13.Topic != "topic1" {
+		t.Error("Failed to replay SetChannelTopic action")
+	}
+
+	action14 := testActor.Actions[14].(SetChannelRulesAction)
+	if action14.Channelname != "General" || action14.Language != "en" || action14.Rules != "rules1" {
+		t.Error("Failed to replay SetChannelRules action")
+	}
+
+	action15 := testActor.Actions[15].(PostBridgedMessageAction)
+	action15Timestamp := action15.Timestamp.Format(time.RFC3339)
+	if action15.Channelname != "General" || action15.Username != "user2" || action15Timestamp != expectedTimestamp || action15.Text != "message2" ||
+		action15.OriginSystem != "Slack" || action15.OriginAuthor != "alice" {
+		t.Error("Failed to replay PostBridgedMessage action")
+	}
+
+	action16 := testActor.Actions[16].(CreateVirtualUserAction)
+	if action16.OwnerUsername != "user2" || action16.Username != "virtual1" {
+		t.Error("Failed to replay CreateVirtualUser action")
+	}
+
+	action17 := testActor.Actions[17].(PutPluginDataAction)
+	if action17.Namespace != "plugin1" || action17.Key != "key1" || action17.Value != "value1" {
+		t.Error("Failed to replay PutPluginData action")
+	}
+
+	action18 := testActor.Actions[18].(RestoreChannelAction)
+	if action18.Channelname != "channel1" {
+		t.Error("Failed to replay RestoreChannel action")
+	}
+
+	action19 := testActor.Actions[19].(EditMessageAction)
+	action19Timestamp := action19.Timestamp.Format(time.RFC3339)
+	if action19.Channelname != "General" || action19.MessageID != 2 || action19.Username != "user2" || action19Timestamp != expectedTimestamp || action19.Text != "message3" {
+		t.Error("Failed to replay EditMessage action")
+	}
+
+	action20 := testActor.Actions[20].(DeleteMessageAction)
+	if action20.Channelname != "General" || action20.MessageID != 1 || action20.Username != "" {
+		t.Error("Failed to replay DeleteMessage action")
+	}
+
+	action21 := testActor.Actions[21].(PostDirectMessageAction)
+	action21Timestamp := action21.Timestamp.Format(time.RFC3339)
+	if action21.Username != "user1" || action21.Recipient != "user2" || action21Timestamp != expectedTimestamp || action21.Text != "message4" {
+		t.Error("Failed to replay PostDirectMessage action")
+	}
+
+	action22 := testActor.Actions[22].(CreateGroupAction)
+	if action22.Username != "user1" || len(action22.Members) != 2 || action22.Members[0] != "user2" || action22.Members[1] != "user3" {
+		t.Error("Failed to replay CreateGroup action")
+	}
+
+	action23 := testActor.Actions[23].(PostGroupMessageAction)
+	action23Timestamp := action23.Timestamp.Format(time.RFC3339)
+	if action23.GroupID != 1 || action23.Username != "user2" || action23Timestamp != expectedTimestamp || action23.Text != "message5" {
+		t.Error("Failed to replay PostGroupMessage action")
+	}
+
+	action24 := testActor.Actions[24].(RestoreSnapshotAction)
+	if len(action24.Snapshot.Users) != 1 || action24.Snapshot.Users[0].Name != "user2" || len(action24.Snapshot.Users[0].BlockedUsers) != 1 ||
+		len(action24.Snapshot.Channels) != 1 || len(action24.Snapshot.Channels[0].Messages) != 1 || action24.Snapshot.Channels[0].Messages[0].Text != "message3" ||
+		!action24.Snapshot.Channels[0].Messages[0].Timestamp.Equal(timestamp) || action24.Snapshot.LastMessageID != 5 {
+		t.Error("Failed to replay RestoreSnapshot action")
+	}
+
+	action25 := testActor.Actions[25].(AttachFileAction)
+	if action25.Channelname != "General" || action25.MessageID != 2 || action25.Username != "user2" ||
+		action25.Attachment != (actions.Attachment{ID: "hash1", Name: "file1.png", ContentType: "image/png", Size: 100}) {
+		t.Error("Failed to replay AttachFile action")
+	}
+
+	action26 := testActor.Actions[26].(RenameChannelAction)
+	if action26.Channelname != "channel1" || action26.NewChannelname != "channel2" {
+		t.Error("Failed to replay RenameChannel action")
+	}
+
+	action27 := testActor.Actions[27].(RenameUserAction)
+	if action27.Username != "user1" || action27.NewUsername != "user3" {
+		t.Error("Failed to replay RenameUser action")
+	}
+
+	action28 := testActor.Actions[28].(PostSnippetAction)
+	action28Timestamp := action28.Timestamp.Format(time.RFC3339)
+	if action28.Channelname != "General" || action28.Username != "user2" || action28Timestamp != expectedTimestamp || action28.Language != "go" || action28.Text != "fmt.Println()\n" {
+		t.Error("Failed to replay PostSnippet action")
+	}
+
+	action29 := testActor.Actions[29].(SetUserProfileAction)
+	if action29.Username != "user2" || action29.DisplayName != "User Two" || action29.Bio != "bio1\nbio2" || action29.Pronouns != "they/them" {
+		t.Error("Failed to replay SetUserProfile action")
+	}
+
+	action30 := testActor.Actions[30].(CreateTeamAction)
+	if action30.Teamname != "team1" {
+		t.Error("Failed to replay CreateTeam action")
+	}
+
+	action31 := testActor.Actions[31].(AddTeamMemberAction)
+	if action31.Teamname != "team1" || action31.Username != "user2" {
+		t.Error("Failed to replay AddTeamMember action")
+	}
+
+	action32 := testActor.Actions[32].(RemoveTeamMemberAction)
+	if action32.Teamname != "team1" || action32.Username != "user2" {
+		t.Error("Failed to replay RemoveTeamMember action")
+	}
+
+	action33 := testActor.Actions[33].(DeleteTeamAction)
+	if action33.Teamname != "team1" {
+		t.Error("Failed to replay DeleteTeam action")
+	}
+
+	action34 := testActor.Actions[34].(SetUserStatusAction)
+	if action34.Username != "user2" || action34.Text != "lunch" || !action34.Away {
+		t.Error("Failed to replay SetUserStatus action")
+	}
+
+	action35 := testActor.Actions[35].(CreateEventAction)
+	if action35.Channelname != "General" || action35.Username != "user2" || action35.Start.Format(time.RFC3339) != expectedTimestamp || action35.Title != "Standup" {
+		t.Error("Failed to replay CreateEvent action")
+	}
+
+	action36 := testActor.Actions[36].(RSVPEventAction)
+	if action36.EventID != 1 || action36.Username != "user2" || !action36.Going {
+		t.Error("Failed to replay RSVPEvent action")
+	}
+
+	action37 := testActor.Actions[37].(MarkEventRemindedAction)
+	if action37.EventID != 1 {
+		t.Error("Failed to replay MarkEventReminded action")
+	}
+
+	action38 := testActor.Actions[38].(MarkReadAction)
+	if action38.Username != "user2" || action38.Channelname != "General" || action38.MessageID != 3 {
+		t.Error("Failed to replay MarkRead action")
+	}
+
+	action39 := testActor.Actions[39].(PostMessageMultiAction)
+	action39Timestamp := action39.Timestamp.Format(time.RFC3339)
+	if len(action39.Channelnames) != 2 || action39.Channelnames[0] != "General" || action39.Channelnames[1] != "Channel2" ||
+		action39.Username != "user2" || action39Timestamp != expectedTimestamp || action39.Text != "message4" {
+		t.Error("Failed to replay PostMessageMulti action")
+	}
+
+	action40 := testActor.Actions[40].(StarMessageAction)
+	if action40.Username != "user2" || action40.Channelname != "General" || action40.MessageID != 3 || !action40.Starred {
+		t.Error("Failed to replay StarMessage action")
+	}
+
+	action41 := testActor.Actions[41].(SetChannelNotesAction)
+	action41Timestamp := action41.Timestamp.Format(time.RFC3339)
+	if action41.Channelname != "General" || action41.Username != "user2" || action41Timestamp != expectedTimestamp || action41.Text != "notes1" {
+		t.Error("Failed to replay SetChannelNotes action")
+	}
+
+	// The batch is replayed as a unit
+	if len(testActor.Actions) != 46 {
+		t.Fatal("Failed to replay Batch action")
+	}
+
+	_, ok42 := testActor.Actions[42].(BeginBatchAction)
+	action43, ok43 := testActor.Actions[43].(CreateUserAction)
+	action44, ok44 := testActor.Actions[44].(JoinChannelAction)
+	_, ok45 := testActor.Actions[45].(EndBatchAction)
+	if !ok42 || !ok43 || !ok44 || !ok45 || action43.Username != "user4" || action44.Username != "user4" || action44.Channelname != "General" {
+		t.Error("Failed to replay Batch action")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "test.*.txt")
+	if err != nil {
+		t.Error("Couldn't create temp file")
+	}
+
+	defer os.Remove(tempFile.Name())
+
+	logger, err := actions.NewLogger(tempFile.Name())
+	if err != nil {
+		t.Error("Failed to create Logger")
+	}
+
+	logger.CreateUser("user1")
+	logger.CreateUser("user2")
+	logger.DeleteUser("user1")
+
+	// Compacting replaces the logged actions, and the actions logged after it follow the snapshot
+	err = logger.Compact(&actions.Snapshot{Users: []actions.SnapshotUser{{Name: "user2"}}})
+	if err != nil {
+		t.Error(err)
+	}
+
+	logger.CreateUser("user3")
+
+	replayer, err := actions.NewReplayer(tempFile.Name())
+	if err != nil {
+		t.Error("Failed to create Replayer")
+	}
+
+	testActor := NewTestActor()
+	err = replayer.Replay(testActor)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(testActor.Actions) != 2 {
+		t.Fatal("Failed to replay the compacted log")
+	}
+
+	action0 := testActor.Actions[0].(RestoreSnapshotAction)
+	if len(action0.Snapshot.Users) != 1 || action0.Snapshot.Users[0].Name != "user2" {
+		t.Error("Failed to replay the snapshot")
+	}
+
+	action1 := testActor.Actions[1].(CreateUserAction)
+	if action1.Username != "user3" {
+		t.Error("Failed to replay the action logged after compacting")
+	}
+
+	// The compacted log replaces the old one (no temporary files are left behind)
+	compactFiles, _ := filepath.Glob(tempFile.Name() + ".compact.*")
+	if len(compactFiles) != 0 {
+		t.Error("Compacting left a temporary file")
+	}
+}
+
+func TestLoggerErrorHandler(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	logFilePath := filepath.Join(tempDir, "log.txt")
+	logger, err := actions.NewLogger(logFilePath)
+	if err != nil {
+		t.Error("Failed to create Logger")
+	}
+
+	var handledErr error
+	logger.SetErrorHandler(func(err error) {
+		handledErr = err
+	})
+
+	testActor := NewTestActor()
+	fanout := actions.NewFanout(logger, testActor)
+	fanout.CreateUser("user1")
+	if handledErr != nil || logger.Err() != nil || fanout.Err() != nil {
+		t.Error("Error handler called for a logged action")
+	}
+
+	// An action that can't be written is passed to the error handler (and reported by Err) rather
+	// than exiting
+	os.Remove(logFilePath)
+	os.Mkdir(logFilePath, 0755)
+	fanout.CreateUser("user2")
+	if handledErr == nil || logger.Err() == nil {
+		t.Error("Error handler not called for an action that couldn't be logged")
+	}
+
+	// It isn't forwarded to the Actors after the Logger
+	if fanout.Err() != logger.Err() || len(testActor.Actions) != 1 {
+		t.Error("Fanout forwarded an action that couldn't be logged")
+	}
+}
+
+func TestSyncPolicy(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	// The actions are logged the same whatever the policy
+	for _, policy := range []string{actions.SyncNone, actions.SyncInterval, actions.SyncAlways} {
+		logFilePath := filepath.Join(tempDir, policy+".txt")
+		logger, err := actions.NewLogger(logFilePath)
+		if err != nil {
+			t.Fatal("Failed to create Logger")
+		}
+
+		var handledErr error
+		logger.SetErrorHandler(func(err error) {
+			handledErr = err
+		})
+		logger.SetSyncPolicy(policy, time.Millisecond)
+
+		logger.CreateUser("user1")
+		logger.CreateChannel("channel1")
+		if logger.Sync() != nil {
+			t.Error("Failed to sync the log with policy", policy)
+		}
+
+		// Give the interval policy's background sync time to run
+		time.Sleep(10 * time.Millisecond)
+
+		replayer, err := actions.NewReplayer(logFilePath)
+		if err != nil {
+			t.Fatal("Failed to create Replayer")
+		}
+
+		testActor := NewTestActor()
+		if replayer.Replay(testActor) != nil || len(testActor.Actions) != 2 || handledErr != nil {
+			t.Error("Failed to log the actions with policy", policy)
+		}
+	}
+}
+
+func TestIntervalSyncError(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	logFilePath := filepath.Join(tempDir, "log.txt")
+	logger, err := actions.NewLogger(logFilePath)
+	if err != nil {
+		t.Fatal("Failed to create Logger")
+	}
+
+	handledErrs := make(chan error, 1)
+	logger.SetErrorHandler(func(err error) {
+		handledErrs <- err
+	})
+	logger.SetSyncPolicy(actions.SyncInterval, time.Millisecond)
+
+	// A background sync that fails is passed to the error handler
+	logger.CreateUser("user1")
+	os.Remove(logFilePath)
+
+	select {
+	case <-handledErrs:
+	case <-time.After(time.Second):
+		t.Error("Error handler not called for a failed background sync")
+	}
+}
+
+func TestFanout(t *testing.T) {
+	testActor1 := NewTestActor()
+	testActor2 := NewTestActor()
+	fanout := actions.NewFanout(testActor1, testActor2)
+
+	// Forward some actions
+	fanout.CreateUser("user1")
+	fanout.JoinChannel("user1", "General")
+	fanout.PostMessage("General", "user1", time.Now(), time.Time{}, "message1")
+
+	for _, testActor := range []*TestActor{testActor1, testActor2} {
+		if len(testActor.Actions) != 3 {
+			t.Error("Failed to forward all of the actions")
+			continue
+		}
+
+		action0 := testActor.Actions[0].(CreateUserAction)
+		if action0.Username != "user1" {
+			t.Error("Failed to forward CreateUser action")
+		}
+
+		action1 := testActor.Actions[1].(JoinChannelAction)
+		if action1.Username != "user1" || action1.Channelname != "General" {
+			t.Error("Failed to forward JoinChannel action")
+		}
+
+		action2 := testActor.Actions[2].(PostMessageAction)
+		if action2.Channelname != "General" || action2.Username != "user1" || action2.Text != "message1" {
+			t.Error("Failed to forward PostMessage action")
+		}
+	}
+
+	// The actions of a batch are only forwarded when it ends
+	fanout.BeginBatch()
+	fanout.CreateUser("user2")
+	fanout.JoinChannel("user2", "General")
+	if len(testActor1.Actions) != 3 || len(testActor2.Actions) != 3 {
+		t.Error("Forwarded the actions of a batch before it ended")
+	}
+
+	fanout.EndBatch()
+	for _, testActor := range []*TestActor{testActor1, testActor2} {
+		if len(testActor.Actions) != 7 {
+			t.Error("Failed to forward the batch")
+			continue
+		}
+
+		_, ok3 := testActor.Actions[3].(BeginBatchAction)
+		_, ok6 := testActor.Actions[6].(EndBatchAction)
+		if !ok3 || !ok6 || testActor.Actions[4].(CreateUserAction).Username != "user2" {
+			t.Error("Failed to forward the batch as a unit")
+		}
+	}
 }
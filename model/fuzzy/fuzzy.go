@@ -0,0 +1,200 @@
+// Package fuzzy provides an index of names (e.g. channels and users) that can be searched with
+// a few typed characters, for quick switchers.  A name matches a query if it contains the query's
+// characters in order (ignoring case), and the matches are ranked so exact names, prefixes and
+// characters at the start of words come first.
+package fuzzy
+
+import (
+	"sort"
+	"unicode"
+)
+
+// Match provides a name matching a query, along with its kind and how well it matched (higher is
+// better).
+type Match struct {
+	Kind  string
+	Name  string
+	Score int
+}
+
+// Index provides the searchable names.  Each name is kept with its lower case characters and a
+// bitmask of the characters it contains, so a search skips most of the names that can't match
+// without comparing them.  An Index isn't safe for concurrent changes (the model changes its
+// index with its lock held).
+type Index struct {
+	entries map[entryKey]*entry
+}
+
+type entryKey struct {
+	kind string
+	name string
+}
+
+type entry struct {
+	runes []rune
+	lower []rune
+	mask  uint64
+}
+
+// NewIndex creates/initializes/returns a new, empty Index.
+func NewIndex() *Index {
+	index := Index{
+		entries: make(map[entryKey]*entry),
+	}
+
+	return &index
+}
+
+// Add adds a name of a kind to the index (adding it again does nothing).
+func (i *Index) Add(kind string, name string) {
+	runes := []rune(name)
+	lower := lowerRunes(name)
+	i.entries[entryKey{kind: kind, name: name}] = &entry{
+		runes: runes,
+		lower: lower,
+		mask:  runeMask(lower),
+	}
+}
+
+// Remove removes a name of a kind from the index (removing a name that isn't there does nothing).
+func (i *Index) Remove(kind string, name string) {
+	delete(i.entries, entryKey{kind: kind, name: name})
+}
+
+// Copy returns a copy of the index that can be changed without affecting this one.
+func (i *Index) Copy() *Index {
+	index := NewIndex()
+	for key, entry := range i.entries {
+		index.entries[key] = entry
+	}
+
+	return index
+}
+
+// Find returns up to limit names matching a query, best first (all of them if limit isn't
+// positive).  An empty query matches nothing.
+func (i *Index) Find(query string, limit int) []Match {
+	matches := make([]Match, 0)
+	queryLower := lowerRunes(query)
+	if len(queryLower) == 0 {
+		return matches
+	}
+
+	queryMask := runeMask(queryLower)
+	for key, entry := range i.entries {
+		if entry.mask&queryMask != queryMask {
+			continue
+		}
+
+		if score, ok := entry.score(queryLower); ok {
+			matches = append(matches, Match{Kind: key.kind, Name: key.name, Score: score})
+		}
+	}
+
+	return Rank(matches, limit)
+}
+
+// Rank sorts matches best first (higher scores, then shorter names, then alphabetically) and
+// returns up to limit of them (all of them if limit isn't positive), e.g. to merge the matches
+// found in several indexes.
+func Rank(matches []Match, limit int) []Match {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		if len(matches[i].Name) != len(matches[j].Name) {
+			return len(matches[i].Name) < len(matches[j].Name)
+		}
+		if matches[i].Name != matches[j].Name {
+			return matches[i].Name < matches[j].Name
+		}
+		return matches[i].Kind < matches[j].Kind
+	})
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches
+}
+
+// score returns how well the name matches a (lower case) query, and whether it matches at all.
+// Each query character is matched with the name's next occurrence of it, scoring more at the
+// start of a word (or of a capitalized part, e.g. the "C" of "chatClient") and right after the
+// previous match, and each name character left unmatched costs a point.
+func (e *entry) score(query []rune) (int, bool) {
+	score := 0
+	position := 0
+	previous := -2
+	for _, r := range query {
+		for position < len(e.lower) && e.lower[position] != r {
+			position++
+		}
+		if position == len(e.lower) {
+			return 0, false
+		}
+
+		score++
+		if e.isWordStart(position) {
+			score += 10
+		}
+		if position == previous+1 {
+			score += 5
+		}
+		previous = position
+		position++
+	}
+
+	switch {
+	case len(query) == len(e.lower) && string(query) == string(e.lower):
+		score += 100
+	case len(query) < len(e.lower) && string(query) == string(e.lower[:len(query)]):
+		score += 50
+	}
+
+	return score - (len(e.lower) - len(query)), true
+}
+
+// isWordStart returns whether a name character starts a word: it's the first character, follows
+// a character that isn't a letter or digit, or is an upper case letter following a lower case one.
+func (e *entry) isWordStart(position int) bool {
+	if position == 0 {
+		return true
+	}
+
+	previous := e.runes[position-1]
+	if !unicode.IsLetter(previous) && !unicode.IsDigit(previous) {
+		return true
+	}
+
+	return unicode.IsUpper(e.runes[position]) && unicode.IsLower(previous)
+}
+
+// runeMask returns a bitmask of the characters in a (lower case) string: a bit for each letter
+// and digit, and the other characters spread over the remaining bits.
+func runeMask(lower []rune) uint64 {
+	mask := uint64(0)
+	for _, r := range lower {
+		switch {
+		case r >= 'a' && r <= 'z':
+			mask |= 1 << uint(r-'a')
+		case r >= '0' && r <= '9':
+			mask |= 1 << uint(26+r-'0')
+		default:
+			mask |= 1 << uint(36+r%28)
+		}
+	}
+
+	return mask
+}
+
+// lowerRunes returns the characters of a string in lower case (one for each of its characters, so
+// the positions of a name's characters are the same in both).
+func lowerRunes(text string) []rune {
+	lower := []rune(text)
+	for i, r := range lower {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	return lower
+}
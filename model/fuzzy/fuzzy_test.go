@@ -0,0 +1,98 @@
+package fuzzy_test
+
+import (
+	"chatserver/model/fuzzy"
+	"testing"
+)
+
+func names(matches []fuzzy.Match) []string {
+	matchNames := make([]string, 0, len(matches))
+	for _, match := range matches {
+		matchNames = append(matchNames, match.Name)
+	}
+
+	return matchNames
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestFind(t *testing.T) {
+	testIndex := fuzzy.NewIndex()
+	testIndex.Add("channel", "General")
+	testIndex.Add("channel", "dev-general")
+	testIndex.Add("channel", "random")
+	testIndex.Add("user", "Gene")
+	testIndex.Add("user", "Greg")
+
+	// Ensure that the names need all of the query's characters, in order
+	if !equal(names(testIndex.Find("gen", 0)), []string{"Gene", "General", "dev-general"}) {
+		t.Error("Failed to rank prefixes before other matches")
+	}
+	if !equal(names(testIndex.Find("rg", 0)), []string{"Greg"}) {
+		t.Error("Failed to match characters in order")
+	}
+	if len(testIndex.Find("xyz", 0)) != 0 || len(testIndex.Find("", 0)) != 0 {
+		t.Error("Matched names without the query's characters")
+	}
+
+	// Ensure that an exact name comes first, ignoring case, and that the kind is kept
+	matches := testIndex.Find("GENERAL", 0)
+	if len(matches) != 2 || matches[0].Name != "General" || matches[0].Kind != "channel" {
+		t.Error("Failed to rank an exact name first")
+	}
+
+	// Ensure that characters starting words are preferred
+	testIndex.Add("channel", "deploy-general")
+	if !equal(names(testIndex.Find("dg", 0)), []string{"dev-general", "deploy-general"}) {
+		t.Error("Failed to prefer the start of words")
+	}
+
+	// Ensure that the limit is applied after ranking
+	if !equal(names(testIndex.Find("e", 2)), []string{"Gene", "Greg"}) {
+		t.Error("Failed to limit matches")
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	testIndex := fuzzy.NewIndex()
+	testIndex.Add("channel", "channel1")
+	testIndex.Add("user", "channel1")
+	testIndex.Add("user", "channel1")
+	if len(testIndex.Find("channel1", 0)) != 2 {
+		t.Error("Failed to add a name of each kind once")
+	}
+
+	// Ensure that a copy is independent of the original
+	testCopy := testIndex.Copy()
+	testCopy.Remove("user", "channel1")
+	testCopy.Remove("user", "channel2")
+	if len(testCopy.Find("channel1", 0)) != 1 || len(testIndex.Find("channel1", 0)) != 2 {
+		t.Error("Failed to remove a name from a copy")
+	}
+}
+
+func TestRank(t *testing.T) {
+	matches := []fuzzy.Match{
+		{Kind: "user", Name: "b", Score: 1},
+		{Kind: "command", Name: "aa", Score: 2},
+		{Kind: "channel", Name: "b", Score: 1},
+		{Kind: "channel", Name: "c", Score: 2},
+	}
+
+	ranked := fuzzy.Rank(matches, 3)
+	if len(ranked) != 3 || ranked[0].Name != "c" || ranked[1].Name != "aa" || ranked[2].Kind != "channel" {
+		t.Error("Failed to rank matches")
+	}
+}
@@ -5,36 +5,319 @@ package model
 
 import (
 	"chatserver/model/actions"
+	"chatserver/model/fuzzy"
+	"chatserver/model/policy"
+	"errors"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // User provides information about a user.
 type User struct {
-	Name         string
-	BlockedUsers []string
+	Name          string
+	BlockedUsers  []string
+	MutedChannels []string
+
+	// Owner is the user (e.g. a bridge) that owns this virtual user, empty for regular users
+	Owner string
+
+	Profile Profile
+	Status  Status
+}
+
+// Profile provides the details a user chose to show about themselves (each of them empty if
+// they weren't given).
+type Profile struct {
+	DisplayName string
+	Bio         string
+	Pronouns    string
+}
+
+// The longest profile fields (in characters).
+const (
+	MaxDisplayNameLength int = 64
+	MaxBioLength         int = 500
+	MaxPronounsLength    int = 32
+)
+
+// Status provides what a user is up to (e.g. "lunch"), and whether they're away.
+type Status struct {
+	Text string
+	Away bool
+}
+
+// MaxStatusLength is the longest status text (in characters).
+const MaxStatusLength int = 100
+
+// Origin provides information about where a bridged message came from (zero for messages posted
+// directly to the chat server).
+type Origin struct {
+	System string
+	Author string
 }
 
-// Message provides data contained by a message.
+// Message provides data contained by a message.  The ID is unique across channels (and direct
+// messages) and assigned in the order messages are posted, so it's stable across restarts (replaying the actions log posts
+// the same messages in the same order) and can be used to refer to the message.
+//
+// The seq numbers the messages in a channel (or a conversation or group) starting at 1,
+// with no gaps, so a client can tell which messages it's missing.
+//
+// The timestamp is assigned by the model when the message is posted.  The claimed timestamp is the
+// time the client said the message was posted, which is only kept when it's skewed from the
+// assigned one by more than MaxClockSkew (it isn't kept across restarts).  The edited time is when
+// the text was last changed by EditMessage (zero if it never was).
+//
+// A deleted message stays in the channel as a tombstone (so the seqs keep having no gaps), with
+// Deleted set and no text.
+//
+// A snippet is a message whose text is code, with the language it's in (e.g. "go") as the snippet
+// language so clients can highlight it.  The snippet language is empty for other messages.
 type Message struct {
-	Username  string
-	Timestamp time.Time
-	Text      string
+	ID               uint64
+	Seq              uint64
+	Username         string
+	Timestamp        time.Time
+	ClaimedTimestamp time.Time
+	Edited           time.Time
+	Deleted          bool
+	Text             string
+	Origin           Origin
+	SnippetLanguage  string
+	Attachments      []Attachment
+
+	// Mentions is the users mentioned in the message with @username (sorted alphabetically)
+	Mentions []string
+
+	// CrossPost is the ID of the first copy of a message cross-posted to several channels (see
+	// PostMessageMulti), shared by all of its copies (zero if it wasn't cross-posted)
+	CrossPost uint64
+}
+
+// Attachment is a file attached to a message.  The file is kept in the attachments store under its
+// ID, the (hex encoded) SHA-256 hash of its contents, so messages with the same file share it.
+type Attachment struct {
+	ID          string
+	Name        string
+	ContentType string
+	Size        int64
+}
+
+// MaxSnippetLanguageLength is the longest language a snippet can be in.
+const MaxSnippetLanguageLength int = 32
+
+// MaxClockSkew is how far a client's claimed timestamp can be from the assigned one before it's
+// kept on the message.
+const MaxClockSkew time.Duration = time.Minute
+
+// DisplayAuthor returns the author to display for a message (bridged messages show the external
+// author and the system they came from, e.g. "alice via Slack").
+func (m Message) DisplayAuthor() string {
+	if m.Origin.System == "" {
+		return m.Username
+	}
+
+	author := m.Origin.Author
+	if author == "" {
+		author = m.Username
+	}
+
+	return author + " via " + m.Origin.System
 }
 
 // ChannelInfo provides information about a channel.
 type ChannelInfo struct {
-	Name        string
-	NumMessages int
+	Name         string
+	Topic        string
+	Language     string
+	Rules        string
+	NumMessages  int
+	NumMembers   int
+	LastActivity time.Time
+	LastSeq      uint64
+}
+
+// GroupInfo provides information about a group conversation.  NumMessages is the seq of the
+// latest message in the group.
+type GroupInfo struct {
+	ID          uint64
+	Members     []string
+	NumMessages uint64
 }
 
 // Channel provides data contained by a channel.
 type Channel struct {
-	Name     string
-	Messages []Message
+	Name         string
+	Topic        string
+	Language     string
+	Rules        string
+	Messages     []Message
+	Members      map[string]struct{}
+	LastActivity time.Time
+
+	// postCounts counts the messages each user posted on each day (by "2006-01-02" then username)
+	postCounts map[string]map[string]int
+
+	// events is the channel's calendar (by event ID), kept with the channel so the events go along
+	// when it's renamed, deleted or restored
+	events map[uint64]*calendarEvent
+
+	// readMarkers is the ID of the last message each user has read (by username), kept with the
+	// channel for the same reason
+	readMarkers map[string]uint64
+
+	// stars is the IDs of the messages each user has starred (by username, in ID order)
+	stars map[string][]uint64
+
+	// notes is the channel's notes document
+	notes ChannelNotes
+}
+
+// ChannelNotes provides a channel's notes: reference text its users keep up to date, separate from
+// the channel's history.  Version counts the edits (zero until the notes are first set), and
+// Editor and Edited are who last edited them and when.
+type ChannelNotes struct {
+	Text    string
+	Version uint64
+	Editor  string
+	Edited  time.Time
+}
+
+// MaxChannelNotesLength is the longest channel notes (in characters).
+const MaxChannelNotesLength int = 10000
+
+// CalendarEvent provides information about an event on a channel's calendar.
+type CalendarEvent struct {
+	ID          uint64
+	Channelname string
+	Creator     string
+	Start       time.Time
+	Title       string
+
+	// Going is the users that RSVPed they're going (sorted alphabetically)
+	Going []string
+
+	// Reminded is whether the reminder for the event has been posted
+	Reminded bool
+}
+
+// MaxEventTitleLength is the longest event title (in characters).
+const MaxEventTitleLength int = 100
+
+// calendarEvent is an event on a channel's calendar.
+type calendarEvent struct {
+	creator  string
+	start    time.Time
+	title    string
+	going    map[string]struct{}
+	reminded bool
+}
+
+// StarredMessage provides a channel message a user has starred.
+type StarredMessage struct {
+	Channelname string
+	Message     Message
 }
 
+// Mention provides a channel message that mentions a user.
+type Mention struct {
+	Channelname string
+	Message     Message
+}
+
+// The kinds of names found by FuzzyFind.
+const (
+	FuzzyKindChannel string = "channel"
+	FuzzyKindUser    string = "user"
+)
+
+// PosterCount provides the number of messages a user posted in a channel.
+type PosterCount struct {
+	Username    string
+	NumMessages int
+}
+
+// PluginStore provides a plugin (or bot) with the plugin data of its own namespace.
+type PluginStore struct {
+	model     *Model
+	namespace string
+}
+
+// Mutation provides a single change applied by Batch.  The type is the name of the model method
+// making the change (CreateUser, CreateVirtualUser, DeleteUser, BlockUser, UnblockUser,
+// MuteChannel, UnmuteChannel, CreateChannel, DeleteChannel, JoinChannel, LeaveChannel,
+// SetChannelTopic, SetChannelRules, PostMessage, PostSnippet or PutPluginData), and the fields that
+// method doesn't take are ignored.
+type Mutation struct {
+	Type          string
+	Username      string
+	OwnerUsername string
+	OtherUsername string
+	Channelname   string
+	Topic         string
+	Language      string
+	Rules         string
+	Text          string
+	Namespace     string
+	Key           string
+	Value         string
+}
+
+// Errors returned by the mutators when they reject a change (nothing is changed or logged).
+var (
+	ErrUserExists        = errors.New("user already exists")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrUserProtected     = errors.New("user is protected")
+	ErrInvalidName       = errors.New("invalid name")
+	ErrInvalidOwner      = errors.New("owner must be an existing regular user")
+	ErrBuiltinUser       = errors.New("not allowed for the built-in user")
+	ErrInvalidProfile    = errors.New("invalid profile")
+	ErrInvalidStatus     = errors.New("invalid status")
+	ErrBlockSelf         = errors.New("users can't block themselves")
+	ErrNotBlocked        = errors.New("user not blocked")
+	ErrMessageSelf       = errors.New("users can't message themselves")
+	ErrGroupTooSmall     = errors.New("a group needs at least one other member")
+	ErrGroupNotFound     = errors.New("group not found")
+	ErrNotGroupMember    = errors.New("not a member of the group")
+	ErrTeamExists        = errors.New("team already exists")
+	ErrTeamNotFound      = errors.New("team not found")
+	ErrAlreadyTeamMember = errors.New("already a member of the team")
+	ErrNotTeamMember     = errors.New("not a member of the team")
+	ErrInvalidEvent      = errors.New("invalid event")
+	ErrEventNotFound     = errors.New("event not found")
+	ErrEventReminded     = errors.New("event reminder already posted")
+	ErrChannelExists     = errors.New("channel already exists")
+	ErrChannelNotFound   = errors.New("channel not found")
+	ErrChannelProtected  = errors.New("channel is protected")
+	ErrChannelReadOnly   = errors.New("channel is read-only")
+	ErrNotMuted          = errors.New("channel not muted")
+	ErrNotRestorable     = errors.New("channel can't be restored")
+	ErrAlreadyMember     = errors.New("already a member of the channel")
+	ErrNotMember         = errors.New("not a member of the channel")
+	ErrInvalidLanguage   = errors.New("invalid language")
+	ErrInvalidNotes      = errors.New("invalid notes")
+	ErrNotesChanged      = errors.New("notes changed since the version edited")
+	ErrInvalidSnippet    = errors.New("invalid snippet language")
+	ErrEmptyMessage      = errors.New("empty message")
+	ErrDuplicateMessage  = errors.New("duplicate message")
+	ErrInvalidCrossPost  = errors.New("a cross-post needs at least two different channels")
+	ErrMessageNotFound   = errors.New("message not found")
+	ErrNotAuthor         = errors.New("not the author of the message")
+	ErrMissingOrigin     = errors.New("missing origin system")
+	ErrUnknownMutation   = errors.New("unknown mutation")
+	ErrUserQuota         = errors.New("quota exceeded: too many users")
+	ErrChannelQuota      = errors.New("quota exceeded: member of too many channels")
+	ErrMessageQuota      = errors.New("quota exceeded: too many messages today")
+	ErrReadOnly          = errors.New("server is read-only")
+	ErrInvalidAttachment = errors.New("invalid attachment")
+	ErrHasHistory        = errors.New("server already has message history")
+)
+
 // ActionsReplayer is the interface required to replay actions.
 type ActionsReplayer interface {
 	Replay(actor actions.Actor) error
@@ -46,438 +329,5261 @@ type SubsEngine interface {
 	UserChanged(username string)
 	ChannelsChanged()
 	ChannelChanged(channelname string)
+	ChannelRenamed(channelname string, newChannelname string)
+	UserRenamed(username string, newUsername string)
+	MessageChanged(channelname string, messageID uint64)
+	DirectMessagesChanged(username string, otherUsername string)
+	GroupChanged(groupID uint64, members []string)
+	PresenceChanged(username string)
+	ReadMarkerChanged(username string, channelname string)
+	Mentioned(username string, channelname string, messageID uint64)
+}
+
+// EventEmitter is the interface required to emit analytics events.  Events are separate from the
+// actions log, they aren't replayed and carry no more than the event type and the names involved.
+type EventEmitter interface {
+	Emit(eventType string, username string, channelname string)
+}
+
+// MessageFilter filters the text of a posted message (e.g. masking words from a word list).  An
+// empty result rejects the message.
+type MessageFilter func(text string) string
+
+// Options provides configurable behavior for the Model.  Zero values select the defaults.
+type Options struct {
+	// BuiltinUsername is the shared user that always exists (defaults to Anonymous)
+	BuiltinUsername string
+
+	// BuiltinChannelname is the fallback channel that always exists (defaults to General)
+	BuiltinChannelname string
+
+	// DefaultChannels are created at startup and joined by every new user (defaults to the
+	// built-in channel)
+	DefaultChannels []string
+
+	// ProtectedUsers can't be deleted (the built-in user is always protected)
+	ProtectedUsers []string
+
+	// ProtectedChannels can't be deleted (the built-in channel is always protected)
+	ProtectedChannels []string
+
+	// ReadOnlyChannels can only be posted to by one user each (e.g. a bot mirroring another
+	// channel), keyed by channel name
+	ReadOnlyChannels map[string]string
+
+	// Events receives analytics events as the model changes (defaults to none)
+	Events EventEmitter
+
+	// LanguageFilters selects the filter applied to messages posted in channels of each language
+	// (defaults to none)
+	LanguageFilters map[string]MessageFilter
+
+	// Clock provides the current time (the timestamps assigned to posted and edited messages, and
+	// when channels were deleted) and is shared with the API layers through Now, so tests can control
+	// the time (defaults to time.Now)
+	Clock func() time.Time
+
+	// TrustTimestamps keeps the timestamps given to posted (and edited) messages rather than
+	// assigning them
+	// (for read replicas fed with the actions logged by another model)
+	TrustTimestamps bool
+
+	// UndoWindow is how long a deleted channel can be restored for (defaults to DefaultUndoWindow)
+	UndoWindow time.Duration
+
+	// MaxUsers is the most users there can be, including the built-in and virtual users (0 for no
+	// limit)
+	MaxUsers int
+
+	// MaxChannelsPerUser is the most channels a user can be a member of, so joining (or creating
+	// and joining) another one is rejected (0 for no limit)
+	MaxChannelsPerUser int
+
+	// MaxMessagesPerDay is the most messages (including direct and group messages) a user can post a day
+	// (0 for no limit)
+	MaxMessagesPerDay int
+
+	// DuplicateWindow is how long after a user posts a message to a channel that posting the same
+	// message again (e.g. a flaky client retrying) is a duplicate (0 allows duplicates)
+	DuplicateWindow time.Duration
+
+	// RejectDuplicates rejects duplicate messages with ErrDuplicateMessage, rather than collapsing
+	// them into the earlier message (which is returned as if it had just been posted)
+	RejectDuplicates bool
+}
+
+// DefaultUndoWindow is how long a deleted channel can be restored for by default.
+const DefaultUndoWindow time.Duration = 10 * time.Minute
+
+// conversation is the direct messages between two users.
+type conversation struct {
+	usernames [2]string
+	messages  []Message
+}
+
+// group is the messages of a group conversation between its members.
+type group struct {
+	members  map[string]struct{}
+	messages []Message
+}
+
+// deletedChannel is a deleted channel that can still be restored.
+type deletedChannel struct {
+	channel   *Channel
+	mutedBy   []string
+	deletedAt time.Time
 }
 
 // Model provides an in memory store of the current state of the chat server.
 type Model struct {
+	options       Options
+	policy        *policy.Policy
 	actionsLogger actions.Actor
 	subsEngine    SubsEngine
+	events        EventEmitter
+	replaying     bool
+	readOnly      int32
+
+	// replayedMembers is whether the replayed log had any memberships (JoinChannel actions, or a
+	// snapshot), see joinDefaultChannels
+	replayedMembers bool
+
 	mutex         sync.Mutex
 	users         map[string]*User
 	channels      map[string]*Channel
+	lastMessageID uint64
+	conversations map[string]*conversation
+	lastGroupID   uint64
+	groups        map[uint64]*group
+	teams         map[string]map[string]struct{}
+	lastEventID   uint64
+	postedKeys    map[string]Message
+	postedKeyList []string
+	postsDay      string
+	postsToday    map[string]int
+	pluginData    map[string]map[string]string
+	deleted       map[string]deletedChannel
+
+	// batchTimes are the times a batch's changes were tried at, for making them at the same times
+	// (see Batch)
+	batchTimes []time.Time
+
+	// names indexes the user and channel names for FuzzyFind, kept up to date as users and
+	// channels come and go (rather than built for each search)
+	names *fuzzy.Index
+
+	// connections is the user each connected client is acting as (by connection ID), which isn't
+	// logged (a restarted server has no connections)
+	connections      map[uint64]string
+	lastConnectionID uint64
 }
 
+// MaxIdempotencyKeys is the number of recent idempotency keys remembered by PostMessageOnce.
+const MaxIdempotencyKeys int = 10000
+
 // NewModel creates/initializes/returns a new Model.
-func NewModel(actionsReplayer ActionsReplayer, actionsLogger actions.Actor, subsEngine SubsEngine) (*Model, error) {
+func NewModel(options Options, actionsReplayer ActionsReplayer, actionsLogger actions.Actor, subsEngine SubsEngine) (*Model, error) {
+	// Fill in the defaults for any options that weren't provided
+	if options.BuiltinUsername == "" {
+		options.BuiltinUsername = "Anonymous"
+	}
+
+	if options.BuiltinChannelname == "" {
+		options.BuiltinChannelname = "General"
+	}
+
+	if len(options.DefaultChannels) == 0 {
+		options.DefaultChannels = []string{options.BuiltinChannelname}
+	}
+
+	if options.Clock == nil {
+		options.Clock = time.Now
+	}
+
+	if options.UndoWindow == 0 {
+		options.UndoWindow = DefaultUndoWindow
+	}
+
+	// Register the protected entities
+	modelPolicy := policy.NewPolicy()
+	modelPolicy.ProtectUser(options.BuiltinUsername)
+	for _, username := range options.ProtectedUsers {
+		modelPolicy.ProtectUser(username)
+	}
+
+	modelPolicy.ProtectChannel(options.BuiltinChannelname)
+	for _, channelname := range options.ProtectedChannels {
+		modelPolicy.ProtectChannel(channelname)
+	}
+
+	for channelname, poster := range options.ReadOnlyChannels {
+		modelPolicy.MakeChannelReadOnly(channelname, poster)
+	}
+
 	model := Model{
+		options:       options,
+		policy:        modelPolicy,
 		actionsLogger: actionsLogger,
 		subsEngine:    subsEngine,
+		events:        options.Events,
 		users:         make(map[string]*User),
 		channels:      make(map[string]*Channel),
+		conversations: make(map[string]*conversation),
+		groups:        make(map[uint64]*group),
+		teams:         make(map[string]map[string]struct{}),
+		postedKeys:    make(map[string]Message),
+		postsToday:    make(map[string]int),
+		pluginData:    make(map[string]map[string]string),
+		deleted:       make(map[string]deletedChannel),
+		names:         fuzzy.NewIndex(),
+		connections:   make(map[uint64]string),
 	}
 
 	if actionsReplayer == nil {
 		// We are not restoring from an existing log, we need to create a new default state
-		model.CreateUser("Anonymous")
-		model.CreateChannel("General")
+		model.CreateChannel(options.BuiltinChannelname)
+		for _, channelname := range options.DefaultChannels {
+			model.CreateChannel(channelname)
+		}
+		model.CreateUser(options.BuiltinUsername)
 	} else {
-		// Disable logging and subscriptions
+		// Disable logging, subscriptions and events
 		model.actionsLogger = nil
 		model.subsEngine = nil
+		model.events = nil
+		model.replaying = true
 
 		// We've been given an actions replayer, replay the actions to initialize our state
-		err := actionsReplayer.Replay(&model)
+		err := actionsReplayer.Replay(model.Actor())
 		if err != nil {
 			return nil, err
 		}
 
-		// Enable logging and subscriptions
+		// Enable logging, subscriptions and events
 		model.actionsLogger = actionsLogger
 		model.subsEngine = subsEngine
+		model.events = options.Events
+		model.replaying = false
+
+		// When the channels were deleted isn't kept in the log, so they can't be restored after
+		// a restart
+		model.deleted = make(map[string]deletedChannel)
+
+		// The built-in and default names may have changed since the log was written, create any
+		// that are missing
+		model.CreateChannel(options.BuiltinChannelname)
+		for _, channelname := range options.DefaultChannels {
+			model.CreateChannel(channelname)
+		}
+
+		if !model.replayedMembers {
+			model.joinDefaultChannels()
+		}
+		model.CreateUser(options.BuiltinUsername)
 	}
 
 	return &model, nil
 }
 
-// CreateUser creates a new user in the model.
-func (m *Model) CreateUser(username string) {
+// joinDefaultChannels joins every regular user to the default channels.  Logs written before the
+// memberships were logged have no JoinChannel actions, as new users joined the default channels
+// implicitly, so this gives their users those joins after replaying them.  The joins are logged,
+// so it only happens once.
+func (m *Model) joinDefaultChannels() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	// If the user already exists, do nothing
-	if _, ok := m.users[username]; ok {
-		return
+	usernames := make([]string, 0, len(m.users))
+	for username, user := range m.users {
+		if user.Owner == "" {
+			usernames = append(usernames, username)
+		}
 	}
+	sort.Strings(usernames)
 
-	// Disallow adding of empty user
-	if username == "" {
-		return
+	for _, username := range usernames {
+		for _, channelname := range m.options.DefaultChannels {
+			if channel, ok := m.channels[channelname]; ok {
+				if _, ok := channel.Members[username]; !ok {
+					m.joinChannel(username, channelname)
+				}
+			}
+		}
 	}
+}
 
-	// Disallow adding of user with space in username
-	if strings.Contains(username, " ") {
-		return
+// BuiltinUsername returns the name of the shared user that always exists.
+func (m *Model) BuiltinUsername() string {
+	return m.options.BuiltinUsername
+}
+
+// BuiltinChannelname returns the name of the fallback channel that always exists.
+func (m *Model) BuiltinChannelname() string {
+	return m.options.BuiltinChannelname
+}
+
+// DefaultChannels returns the names of the channels that new users join.
+func (m *Model) DefaultChannels() []string {
+	return append([]string(nil), m.options.DefaultChannels...)
+}
+
+// Now returns the current time according to the model's clock.
+func (m *Model) Now() time.Time {
+	return m.options.Clock()
+}
+
+// Policy returns the protected entity registry consulted by the model.
+func (m *Model) Policy() *policy.Policy {
+	return m.policy
+}
+
+// EmitEvent emits an analytics event for activity that happens outside of the model (e.g. a
+// connection switching channels).  It does nothing if events aren't enabled.
+func (m *Model) EmitEvent(eventType string, username string, channelname string) {
+	if m.options.Events != nil {
+		m.options.Events.Emit(eventType, username, channelname)
+	}
+}
+
+// Suspend blocks all access to the model (including logging actions) until Resume is called.  This
+// is used to keep the actions log stable while another process replays it.
+func (m *Model) Suspend() {
+	m.mutex.Lock()
+}
+
+// Resume allows access to the model after a call to Suspend.
+func (m *Model) Resume() {
+	m.mutex.Unlock()
+}
+
+// SetReadOnly switches the model to (or from) read-only, where every change is rejected with
+// ErrReadOnly (e.g. while there isn't the disk space to log them).  It doesn't wait for the lock, so
+// it can be called while a change is being made (the change itself isn't undone).  Read replicas
+// and replaying the log aren't affected.
+func (m *Model) SetReadOnly(readOnly bool) {
+	if readOnly {
+		atomic.StoreInt32(&m.readOnly, 1)
+	} else {
+		atomic.StoreInt32(&m.readOnly, 0)
+	}
+}
+
+// IsReadOnly returns whether the model is read-only.
+func (m *Model) IsReadOnly() bool {
+	return atomic.LoadInt32(&m.readOnly) != 0
+}
+
+// Compact passes a snapshot of the current state to a function that compacts the actions log with
+// it (e.g. the Logger's Compact).  The model is locked until the function returns, so nothing can
+// change (or be logged) in between.
+func (m *Model) Compact(compact func(snapshot *actions.Snapshot) error) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return compact(m.snapshot())
+}
+
+// Snapshot returns a snapshot of the current state (e.g. to save a copy of it elsewhere, see
+// actions.EncodeSnapshot).
+func (m *Model) Snapshot() *actions.Snapshot {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.snapshot()
+}
+
+// Restore replaces the state with a snapshot (e.g. of an archive exported by another server),
+// keeping its message, group and event IDs.  It's logged as a single RestoreSnapshot action, so
+// it's replayed (and fed to the read replicas) as a whole.  Only a server that hasn't had any
+// messages posted can be restored (otherwise ErrHasHistory is returned), so no history is lost and
+// no message ID is reused.  The built-in user and channel and the default channels are created
+// if the snapshot doesn't have them.
+func (m *Model) Restore(snapshot *actions.Snapshot) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	if m.lastMessageID > 0 {
+		return ErrHasHistory
+	}
+
+	err := m.restoreSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	m.createChannel(m.options.BuiltinChannelname)
+	for _, channelname := range m.options.DefaultChannels {
+		m.createChannel(channelname)
+	}
+	m.createUser(m.options.BuiltinUsername)
+
+	return nil
+}
+
+// Actor returns the model as an actions.Actor, to replay logged actions into it (or to feed it the
+// actions logged by another model, as a read replica).  The errors are dropped, the actions were
+// applied when they were logged.
+func (m *Model) Actor() actions.Actor {
+	return &modelActor{model: m}
+}
+
+// modelActor adapts the Model to the actions.Actor interface.
+type modelActor struct {
+	model *Model
+}
+
+func (a *modelActor) CreateUser(username string) {
+	a.model.CreateUser(username)
+}
+
+func (a *modelActor) CreateVirtualUser(ownerUsername string, username string) {
+	a.model.CreateVirtualUser(ownerUsername, username)
+}
+
+func (a *modelActor) DeleteUser(username string) {
+	a.model.DeleteUser(username)
+}
+
+func (a *modelActor) BlockUser(username string, usernameToBlock string) {
+	a.model.BlockUser(username, usernameToBlock)
+}
+
+func (a *modelActor) UnblockUser(username string, usernameToUnblock string) {
+	a.model.UnblockUser(username, usernameToUnblock)
+}
+
+func (a *modelActor) MuteChannel(username string, channelname string) {
+	a.model.MuteChannel(username, channelname)
+}
+
+func (a *modelActor) UnmuteChannel(username string, channelname string) {
+	a.model.UnmuteChannel(username, channelname)
+}
+
+func (a *modelActor) CreateChannel(channelname string) {
+	a.model.CreateChannel(channelname)
+}
+
+func (a *modelActor) DeleteChannel(channelname string) {
+	a.model.DeleteChannel(channelname)
+}
+
+func (a *modelActor) RestoreChannel(channelname string) {
+	a.model.RestoreChannel(channelname)
+}
+
+func (a *modelActor) SetChannelTopic(channelname string, topic string) {
+	a.model.SetChannelTopic(channelname, topic)
+}
+
+func (a *modelActor) SetChannelRules(channelname string, language string, rules string) {
+	a.model.SetChannelRules(channelname, language, rules)
+}
+
+func (a *modelActor) JoinChannel(username string, channelname string) {
+	a.model.JoinChannel(username, channelname)
+}
+
+func (a *modelActor) LeaveChannel(username string, channelname string) {
+	a.model.LeaveChannel(username, channelname)
+}
+
+func (a *modelActor) PostMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.postMessage(channelname, username, timestamp, claimedTimestamp, text, "", Origin{}, true)
+}
+
+func (a *modelActor) PostBridgedMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string, originSystem string, originAuthor string) {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.postMessage(channelname, username, timestamp, claimedTimestamp, text, "", Origin{System: originSystem, Author: originAuthor}, true)
+}
+
+func (a *modelActor) SetUserProfile(username string, displayName string, bio string, pronouns string) {
+	a.model.SetUserProfile(username, Profile{DisplayName: displayName, Bio: bio, Pronouns: pronouns})
+}
+
+func (a *modelActor) SetUserStatus(username string, text string, away bool) {
+	a.model.SetUserStatus(username, Status{Text: text, Away: away})
+}
+
+func (a *modelActor) CreateEvent(channelname string, username string, start time.Time, title string) {
+	a.model.CreateEvent(channelname, username, start, title)
+}
+
+func (a *modelActor) RSVPEvent(eventID uint64, username string, going bool) {
+	a.model.RSVPEvent(eventID, username, going)
+}
+
+func (a *modelActor) MarkEventReminded(eventID uint64) {
+	a.model.MarkEventReminded(eventID)
+}
+
+func (a *modelActor) MarkRead(username string, channelname string, messageID uint64) {
+	a.model.MarkRead(username, channelname, messageID)
+}
+
+func (a *modelActor) StarMessage(username string, channelname string, messageID uint64, starred bool) {
+	a.model.StarMessage(username, channelname, messageID, starred)
+}
+
+func (a *modelActor) SetChannelNotes(channelname string, username string, timestamp time.Time, text string) {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.setChannelNotes(channelname, username, timestamp, 0, text)
+}
+
+func (a *modelActor) PostMessageMulti(channelnames []string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.postMessageMulti(channelnames, username, timestamp, claimedTimestamp, text)
+}
+
+func (a *modelActor) PostSnippet(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, language string, text string) {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.postSnippet(channelname, username, timestamp, claimedTimestamp, language, text)
+}
+
+func (a *modelActor) EditMessage(channelname string, messageID uint64, username string, timestamp time.Time, text string) {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.editMessage(channelname, messageID, username, timestamp, text)
+}
+
+func (a *modelActor) DeleteMessage(channelname string, messageID uint64, username string) {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.deleteMessage(channelname, messageID, username)
+}
+
+func (a *modelActor) PostDirectMessage(username string, recipient string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.postDirectMessage(username, recipient, timestamp, claimedTimestamp, text)
+}
+
+func (a *modelActor) CreateGroup(username string, members []string) {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.createGroup(username, members)
+}
+
+func (a *modelActor) PostGroupMessage(groupID uint64, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.postGroupMessage(groupID, username, timestamp, claimedTimestamp, text)
+}
+
+func (a *modelActor) PutPluginData(namespace string, key string, value string) {
+	a.model.PutPluginData(namespace, key, value)
+}
+
+func (a *modelActor) BeginBatch() {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.logAction(func(logger actions.Actor) {
+		logger.BeginBatch()
+	})
+}
+
+func (a *modelActor) EndBatch() {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.logAction(func(logger actions.Actor) {
+		logger.EndBatch()
+	})
+}
+
+func (a *modelActor) RestoreSnapshot(snapshot *actions.Snapshot) {
+	a.model.mutex.Lock()
+	defer a.model.mutex.Unlock()
+
+	a.model.restoreSnapshot(snapshot)
+}
+
+func (a *modelActor) AttachFile(channelname string, messageID uint64, username string, attachment actions.Attachment) {
+	a.model.AttachFile(channelname, messageID, username, Attachment(attachment))
+}
+
+func (a *modelActor) RenameChannel(channelname string, newChannelname string) {
+	a.model.RenameChannel(channelname, newChannelname)
+}
+
+func (a *modelActor) RenameUser(username string, newUsername string) {
+	a.model.RenameUser(username, newUsername)
+}
+
+func (a *modelActor) CreateTeam(teamname string) {
+	a.model.CreateTeam(teamname)
+}
+
+func (a *modelActor) DeleteTeam(teamname string) {
+	a.model.DeleteTeam(teamname)
+}
+
+func (a *modelActor) AddTeamMember(teamname string, username string) {
+	a.model.AddTeamMember(teamname, username)
+}
+
+func (a *modelActor) RemoveTeamMember(teamname string, username string) {
+	a.model.RemoveTeamMember(teamname, username)
+}
+
+// CreateUser creates a new user in the model.
+func (m *Model) CreateUser(username string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.createUser(username)
+}
+
+// ReserveUser creates a new user and returns true, or returns false if the user already exists
+// (or the username is invalid).  Unlike checking GetUsers before CreateUser, only one of the
+// callers racing for the same username gets true, so it can be used to claim a username.
+func (m *Model) ReserveUser(username string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.createUser(username) == nil
+}
+
+// CreateUserAndJoin creates a new user that (besides the default channels) joins a requested
+// channel, as a single change: the channel is checked before the user is created, and no other
+// change can be made in between (e.g. deleting the channel, or claiming the username).
+func (m *Model) CreateUserAndJoin(username string, channelname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Validate that channel exists
+	if _, ok := m.channels[channelname]; !ok {
+		return ErrChannelNotFound
+	}
+
+	err := m.createUser(username)
+	if err != nil {
+		return err
+	}
+
+	// The channel may be one of the default channels, which the user has already joined
+	if _, ok := m.channels[channelname].Members[username]; !ok {
+		m.joinChannel(username, channelname)
+	}
+
+	return nil
+}
+
+func (m *Model) createUser(username string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the user already exists, do nothing
+	if _, ok := m.users[username]; ok {
+		return ErrUserExists
+	}
+
+	// Disallow adding of empty user
+	if username == "" {
+		return ErrInvalidName
+	}
+
+	// Disallow adding of user with space in username
+	if strings.Contains(username, " ") {
+		return ErrInvalidName
+	}
+
+	if err := m.checkUserQuota(); err != nil {
+		return err
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.CreateUser(username)
+	}); err != nil {
+		return err
+	}
+
+	// Add the new user
+	newUser := User{
+		Name:          username,
+		BlockedUsers:  make([]string, 0),
+		MutedChannels: make([]string, 0),
+	}
+	m.users[newUser.Name] = &newUser
+	m.names.Add(FuzzyKindUser, newUser.Name)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.UsersChanged()
+	}
+
+	if m.events != nil {
+		m.events.Emit("user_created", username, "")
+	}
+
+	// New users join the default channels (when replaying, the joins are part of the log, see
+	// joinDefaultChannels)
+	if !m.replaying {
+		for _, channelname := range m.options.DefaultChannels {
+			m.joinChannel(username, channelname)
+		}
+	}
+
+	return nil
+}
+
+// CreateVirtualUser creates a new virtual user owned by an existing (regular) user such as a bridge
+// or bot.  Virtual users can't be used directly and are deleted along with their owner.
+func (m *Model) CreateVirtualUser(ownerUsername string, username string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.createVirtualUser(ownerUsername, username)
+}
+
+func (m *Model) createVirtualUser(ownerUsername string, username string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the user already exists, do nothing
+	if _, ok := m.users[username]; ok {
+		return ErrUserExists
+	}
+
+	// Disallow adding of empty user
+	if username == "" {
+		return ErrInvalidName
+	}
+
+	// Disallow adding of user with space in username
+	if strings.Contains(username, " ") {
+		return ErrInvalidName
+	}
+
+	// The owner must be an existing regular user
+	owner, ok := m.users[ownerUsername]
+	if !ok || owner.Owner != "" {
+		return ErrInvalidOwner
+	}
+
+	if err := m.checkUserQuota(); err != nil {
+		return err
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.CreateVirtualUser(ownerUsername, username)
+	}); err != nil {
+		return err
+	}
+
+	// Add the new virtual user
+	newUser := User{
+		Name:          username,
+		BlockedUsers:  make([]string, 0),
+		MutedChannels: make([]string, 0),
+		Owner:         ownerUsername,
+	}
+	m.users[newUser.Name] = &newUser
+	m.names.Add(FuzzyKindUser, newUser.Name)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.UsersChanged()
+	}
+
+	if m.events != nil {
+		m.events.Emit("user_created", username, "")
+	}
+
+	return nil
+}
+
+// DeleteUser deletes an existing user from the model (along with any virtual users it owns).
+func (m *Model) DeleteUser(username string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.deleteUser(username)
+}
+
+func (m *Model) deleteUser(username string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the user doesn't exist, do nothing
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	// Disallow deleting of protected users
+	if m.policy.IsUserProtected(username) {
+		return ErrUserProtected
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.DeleteUser(username)
+	}); err != nil {
+		return err
+	}
+
+	// Remove the virtual users owned by the user (when replaying, this happens again as part of
+	// the logged DeleteUser)
+	ownedUsernames := make([]string, 0)
+	for _, user := range m.users {
+		if user.Owner == username {
+			ownedUsernames = append(ownedUsernames, user.Name)
+		}
+	}
+
+	for _, ownedUsername := range ownedUsernames {
+		m.removeUser(ownedUsername)
+	}
+
+	// Remove the user
+	m.removeUser(username)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.UsersChanged()
+	}
+
+	if m.events != nil {
+		for _, ownedUsername := range ownedUsernames {
+			m.events.Emit("user_deleted", ownedUsername, "")
+		}
+		m.events.Emit("user_deleted", username, "")
+	}
+
+	return nil
+}
+
+// RenameUser renames a user, rewriting every reference to them (their messages, memberships,
+// conversations and groups, other users' blocks of them and the virtual users they own) to the new
+// name.  Protected users can't be renamed, since they're configured by name.
+func (m *Model) RenameUser(username string, newUsername string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the user doesn't exist, do nothing
+	user, ok := m.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	// Disallow renaming of protected users
+	if m.policy.IsUserProtected(username) {
+		return ErrUserProtected
+	}
+
+	// Disallow renaming to an empty username, or one with a space in it
+	if newUsername == "" || strings.Contains(newUsername, " ") {
+		return ErrInvalidName
+	}
+
+	// If the new name is taken, do nothing
+	if _, ok := m.users[newUsername]; ok {
+		return ErrUserExists
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.RenameUser(username, newUsername)
+	}); err != nil {
+		return err
+	}
+
+	// Move the user to their new name
+	delete(m.users, username)
+	user.Name = newUsername
+	m.users[newUsername] = user
+	m.names.Remove(FuzzyKindUser, username)
+	m.names.Add(FuzzyKindUser, newUsername)
+
+	m.renameUserReferences(username, newUsername)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.UserRenamed(username, newUsername)
+	}
+
+	if m.events != nil {
+		m.events.Emit("user_renamed", newUsername, "")
+	}
+
+	return nil
+}
+
+// SetUserProfile replaces the profile of a requested user.  The display name and pronouns are a
+// single line, the bio can span several, and none of them can be longer than their maximum length.
+func (m *Model) SetUserProfile(username string, profile Profile) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the user doesn't exist, do nothing
+	user, ok := m.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	// Don't allow the built-in user (which everyone shares) to have a profile
+	if username == m.options.BuiltinUsername {
+		return ErrBuiltinUser
+	}
+
+	if !validProfileField(profile.DisplayName, MaxDisplayNameLength, false) || !validProfileField(profile.Bio, MaxBioLength, true) ||
+		!validProfileField(profile.Pronouns, MaxPronounsLength, false) {
+		return ErrInvalidProfile
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.SetUserProfile(username, profile.DisplayName, profile.Bio, profile.Pronouns)
+	}); err != nil {
+		return err
+	}
+
+	user.Profile = profile
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.UserChanged(username)
+	}
+
+	return nil
+}
+
+// SetUserStatus replaces the status of a requested user.  The text is a single line no longer than
+// MaxStatusLength, and an empty status clears it.
+func (m *Model) SetUserStatus(username string, status Status) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the user doesn't exist, do nothing
+	user, ok := m.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	// Don't allow the built-in user (which everyone shares) to have a status
+	if username == m.options.BuiltinUsername {
+		return ErrBuiltinUser
+	}
+
+	if !validProfileField(status.Text, MaxStatusLength, false) {
+		return ErrInvalidStatus
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.SetUserStatus(username, status.Text, status.Away)
+	}); err != nil {
+		return err
+	}
+
+	user.Status = status
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.UserChanged(username)
+	}
+
+	return nil
+}
+
+// validProfileField returns whether a profile field is no longer than a maximum length, and has no
+// control characters (apart from newlines, where they're allowed).
+func validProfileField(field string, maxLength int, multiline bool) bool {
+	if utf8.RuneCountInString(field) > maxLength {
+		return false
+	}
+
+	for _, c := range field {
+		if unicode.IsControl(c) && (c != '\n' || !multiline) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetUserInfo returns information about a requested user.
+func (m *Model) GetUserInfo(username string) User {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// If the user doesn't exist, do nothing
+	if _, ok := m.users[username]; !ok {
+		return User{}
+	}
+
+	// Copy and return the user
+	user := m.users[username]
+	userInfo := User{
+		Name:          user.Name,
+		BlockedUsers:  make([]string, len(user.BlockedUsers)),
+		MutedChannels: make([]string, len(user.MutedChannels)),
+		Owner:         user.Owner,
+		Profile:       user.Profile,
+		Status:        user.Status,
+	}
+	copy(userInfo.BlockedUsers, user.BlockedUsers)
+	copy(userInfo.MutedChannels, user.MutedChannels)
+
+	return userInfo
+}
+
+// GetUsers returns a list of all users.
+func (m *Model) GetUsers() map[string]struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	users := make(map[string]struct{})
+	for _, user := range m.users {
+		users[user.Name] = struct{}{}
+	}
+
+	return users
+}
+
+// GetUserStatuses returns the statuses of the users that have set one, by username.
+func (m *Model) GetUserStatuses() map[string]Status {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	statuses := make(map[string]Status)
+	for _, user := range m.users {
+		if user.Status != (Status{}) {
+			statuses[user.Name] = user.Status
+		}
+	}
+
+	return statuses
+}
+
+// Connect records a client connecting as a requested user (e.g. a telnet or web connection) and
+// returns the ID of the connection, to pass to SetConnectionUser and Disconnect.  A user is online
+// (see GetPresence) while at least one connection is acting as them.  Presence isn't logged, and
+// isn't affected by the model being read-only.
+func (m *Model) Connect(username string) uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.lastConnectionID++
+	m.connections[m.lastConnectionID] = ""
+	m.setConnectionUser(m.lastConnectionID, username)
+
+	return m.lastConnectionID
+}
+
+// SetConnectionUser records a connection switching to act as another user.
+func (m *Model) SetConnectionUser(connectionID uint64, username string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.connections[connectionID]; ok {
+		m.setConnectionUser(connectionID, username)
+	}
+}
+
+// Disconnect records a connection closing.
+func (m *Model) Disconnect(connectionID uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.connections[connectionID]; ok {
+		m.setConnectionUser(connectionID, "")
+		delete(m.connections, connectionID)
+	}
+}
+
+// GetPresence returns a list of the users that are online.  The built-in user, which everyone
+// shares, never is.
+func (m *Model) GetPresence() map[string]struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	online := make(map[string]struct{})
+	for _, username := range m.connections {
+		if m.isOnline(username) {
+			online[username] = struct{}{}
+		}
+	}
+
+	return online
+}
+
+// setConnectionUser changes the user of a connection (empty for none), notifying the users that
+// went online or offline.  The lock must be held.
+func (m *Model) setConnectionUser(connectionID uint64, username string) {
+	previousUsername := m.connections[connectionID]
+	if previousUsername == username {
+		return
+	}
+
+	previousOnline, online := m.isOnline(previousUsername), m.isOnline(username)
+	m.connections[connectionID] = username
+
+	if m.subsEngine != nil {
+		if m.isOnline(previousUsername) != previousOnline {
+			m.subsEngine.PresenceChanged(previousUsername)
+		}
+
+		if m.isOnline(username) != online {
+			m.subsEngine.PresenceChanged(username)
+		}
+	}
+}
+
+// isOnline returns whether a user (that exists, and isn't the built-in user) has a connection.  The
+// lock must be held.
+func (m *Model) isOnline(username string) bool {
+	if _, ok := m.users[username]; !ok || username == m.options.BuiltinUsername {
+		return false
+	}
+
+	for _, connectionUsername := range m.connections {
+		if connectionUsername == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BlockUser blocks a user for a requested user.
+func (m *Model) BlockUser(username string, usernameToBlock string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.blockUser(username, usernameToBlock)
+}
+
+func (m *Model) blockUser(username string, usernameToBlock string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the user doesn't exist, do nothing
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	// If the user to block doesn't exist, do nothing
+	if _, ok := m.users[usernameToBlock]; !ok {
+		return ErrUserNotFound
+	}
+
+	// Don't allow the built-in user to block
+	if username == m.options.BuiltinUsername {
+		return ErrBuiltinUser
+	}
+
+	// Don't allow blocking yourself
+	if username == usernameToBlock {
+		return ErrBlockSelf
+	}
+
+	// Look through the user's blockedUsers list and add the username if new
+	user := m.users[username]
+
+	found := false
+	for _, blockedUser := range user.BlockedUsers {
+		if blockedUser == usernameToBlock {
+			found = true
+			break
+		}
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.BlockUser(username, usernameToBlock)
+	}); err != nil {
+		return err
+	}
+
+	if !found {
+		user.BlockedUsers = append(user.BlockedUsers, usernameToBlock)
+	}
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.UserChanged(username)
+	}
+
+	return nil
+}
+
+// UnblockUser unblocks a user for a requested user.
+func (m *Model) UnblockUser(username string, usernameToUnblock string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.unblockUser(username, usernameToUnblock)
+}
+
+func (m *Model) unblockUser(username string, usernameToUnblock string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the user doesn't exist, do nothing
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	// If the user to block doesn't exist, do nothing
+	if _, ok := m.users[usernameToUnblock]; !ok {
+		return ErrUserNotFound
+	}
+
+	// Look through the user's blockedUsers list and remove the username if found
+	user := m.users[username]
+
+	foundIndex := -1
+	for i, blockedUser := range user.BlockedUsers {
+		if blockedUser == usernameToUnblock {
+			foundIndex = i
+			break
+		}
+	}
+
+	if foundIndex == -1 {
+		return ErrNotBlocked
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.UnblockUser(username, usernameToUnblock)
+	}); err != nil {
+		return err
+	}
+
+	user.BlockedUsers = append(user.BlockedUsers[:foundIndex], user.BlockedUsers[foundIndex+1:]...)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.UserChanged(username)
+	}
+
+	return nil
+}
+
+// MuteChannel mutes a channel for a requested user.  Muted channels don't generate
+// notifications for that user.
+func (m *Model) MuteChannel(username string, channelname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.muteChannel(username, channelname)
+}
+
+func (m *Model) muteChannel(username string, channelname string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the user doesn't exist, do nothing
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	// If the channel to mute doesn't exist, do nothing
+	if _, ok := m.channels[channelname]; !ok {
+		return ErrChannelNotFound
+	}
+
+	// Don't allow the built-in user to mute
+	if username == m.options.BuiltinUsername {
+		return ErrBuiltinUser
+	}
+
+	// Look through the user's mutedChannels list and add the channelname if new
+	user := m.users[username]
+
+	found := false
+	for _, mutedChannel := range user.MutedChannels {
+		if mutedChannel == channelname {
+			found = true
+			break
+		}
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.MuteChannel(username, channelname)
+	}); err != nil {
+		return err
+	}
+
+	if !found {
+		user.MutedChannels = append(user.MutedChannels, channelname)
+	}
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.UserChanged(username)
+	}
+
+	return nil
+}
+
+// UnmuteChannel unmutes a channel for a requested user.
+func (m *Model) UnmuteChannel(username string, channelname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.unmuteChannel(username, channelname)
+}
+
+func (m *Model) unmuteChannel(username string, channelname string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the user doesn't exist, do nothing
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	// If the channel to unmute doesn't exist, do nothing
+	if _, ok := m.channels[channelname]; !ok {
+		return ErrChannelNotFound
+	}
+
+	// Look through the user's mutedChannels list and remove the channelname if found
+	user := m.users[username]
+
+	foundIndex := -1
+	for i, mutedChannel := range user.MutedChannels {
+		if mutedChannel == channelname {
+			foundIndex = i
+			break
+		}
+	}
+
+	if foundIndex == -1 {
+		return ErrNotMuted
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.UnmuteChannel(username, channelname)
+	}); err != nil {
+		return err
+	}
+
+	user.MutedChannels = append(user.MutedChannels[:foundIndex], user.MutedChannels[foundIndex+1:]...)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.UserChanged(username)
+	}
+
+	return nil
+}
+
+// IsChannelMuted returns whether a requested user has muted a requested channel.
+func (m *Model) IsChannelMuted(username string, channelname string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// If the user doesn't exist, nothing is muted
+	if _, ok := m.users[username]; !ok {
+		return false
+	}
+
+	for _, mutedChannel := range m.users[username].MutedChannels {
+		if mutedChannel == channelname {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateChannel creates a new channel in the model.
+func (m *Model) CreateChannel(channelname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.createChannel(channelname)
+}
+
+// CreateChannelAndJoin creates a new channel with a topic (empty for none) and adds a requested
+// user to its members, as a single change: the user is checked before the channel is created, and
+// no other change can be made in between (e.g. deleting the user, or claiming the channelname).
+func (m *Model) CreateChannelAndJoin(channelname string, topic string, username string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Validate that user exists (and can join another channel)
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	if err := m.checkChannelQuota(username); err != nil {
+		return err
+	}
+
+	err := m.createChannel(channelname)
+	if err != nil {
+		return err
+	}
+
+	if topic != "" {
+		m.setChannelTopic(channelname, topic)
+	}
+	m.joinChannel(username, channelname)
+
+	return nil
+}
+
+func (m *Model) createChannel(channelname string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the channel already exists, do nothing
+	if _, ok := m.channels[channelname]; ok {
+		return ErrChannelExists
+	}
+
+	// Disallow adding of empty channel
+	if channelname == "" {
+		return ErrInvalidName
+	}
+
+	// Disallow adding of channel with space in channelname
+	if strings.Contains(channelname, " ") {
+		return ErrInvalidName
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.CreateChannel(channelname)
+	}); err != nil {
+		return err
+	}
+
+	// A deleted channel can't be restored once its name is reused
+	delete(m.deleted, channelname)
+
+	// Add the channel
+	newChannel := Channel{
+		Name:       channelname,
+		Messages:   make([]Message, 0),
+		Members:    make(map[string]struct{}),
+		postCounts: make(map[string]map[string]int),
+	}
+	m.channels[channelname] = &newChannel
+	m.names.Add(FuzzyKindChannel, channelname)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.ChannelsChanged()
+	}
+
+	if m.events != nil {
+		m.events.Emit("channel_created", "", channelname)
+	}
+
+	return nil
+}
+
+// DeleteChannel deletes an existing channel from the model.
+func (m *Model) DeleteChannel(channelname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.deleteChannel(channelname)
+}
+
+func (m *Model) deleteChannel(channelname string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the channel doesn't exist, do nothing
+	if _, ok := m.channels[channelname]; !ok {
+		return ErrChannelNotFound
+	}
+
+	// Disallow deleting of protected channels
+	if m.policy.IsChannelProtected(channelname) {
+		return ErrChannelProtected
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.DeleteChannel(channelname)
+	}); err != nil {
+		return err
+	}
+
+	// Remove the channel (keeping it for RestoreChannel, and forgetting the ones that can no
+	// longer be restored)
+	m.purgeDeletedChannels()
+	deleted := deletedChannel{
+		channel:   m.channels[channelname],
+		mutedBy:   make([]string, 0),
+		deletedAt: m.now(),
+	}
+	delete(m.channels, channelname)
+	m.names.Remove(FuzzyKindChannel, channelname)
+
+	// Forget the idempotency keys of the channel's messages (so retrying them posts again rather
+	// than returning a deleted message)
+	m.forgetPostedKeys(func(postedKey string, message Message) bool {
+		return findMessage(deleted.channel, message.ID) != -1
+	})
+
+	// Remove the channel from all users' mutedChannels list
+	for _, user := range m.users {
+		removalIndex := -1
+		for i, mutedChannelname := range user.MutedChannels {
+			if mutedChannelname == channelname {
+				removalIndex = i
+				break
+			}
+		}
+
+		if removalIndex != -1 {
+			user.MutedChannels = append(user.MutedChannels[:removalIndex], user.MutedChannels[removalIndex+1:]...)
+			deleted.mutedBy = append(deleted.mutedBy, user.Name)
+		}
+	}
+	m.deleted[channelname] = deleted
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.ChannelsChanged()
+	}
+
+	if m.events != nil {
+		m.events.Emit("channel_deleted", "", channelname)
+	}
+
+	return nil
+}
+
+// RestoreChannel restores a channel deleted within the undo window, along with its history and
+// the members (and mutes) of the users that still exist.
+func (m *Model) RestoreChannel(channelname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the channel can't be restored (anymore), do nothing (replicas follow the model that
+	// checked the window)
+	if !m.replaying && !m.options.TrustTimestamps {
+		m.purgeDeletedChannels()
+	}
+
+	deleted, ok := m.deleted[channelname]
+	if !ok {
+		return ErrNotRestorable
+	}
+
+	// If the name has been taken since, do nothing
+	if _, ok := m.channels[channelname]; ok {
+		return ErrChannelExists
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.RestoreChannel(channelname)
+	}); err != nil {
+		return err
+	}
+
+	// Restore the channel, leaving out the members, read markers, stars and RSVPs of the users
+	// that no longer exist
+	delete(m.deleted, channelname)
+	channel := deleted.channel
+	for member := range channel.Members {
+		if _, ok := m.users[member]; !ok {
+			delete(channel.Members, member)
+		}
+	}
+	for username := range channel.readMarkers {
+		if _, ok := m.users[username]; !ok {
+			delete(channel.readMarkers, username)
+		}
+	}
+	for username := range channel.stars {
+		if _, ok := m.users[username]; !ok {
+			delete(channel.stars, username)
+		}
+	}
+	for _, event := range channel.events {
+		for username := range event.going {
+			if _, ok := m.users[username]; !ok {
+				delete(event.going, username)
+			}
+		}
+	}
+	m.channels[channelname] = channel
+	m.names.Add(FuzzyKindChannel, channelname)
+
+	for _, username := range deleted.mutedBy {
+		if user, ok := m.users[username]; ok {
+			user.MutedChannels = append(user.MutedChannels, channelname)
+		}
+	}
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.ChannelsChanged()
+	}
+
+	if m.events != nil {
+		m.events.Emit("channel_restored", "", channelname)
+	}
+
+	return nil
+}
+
+// RenameChannel renames a channel, keeping its history, members and settings (and the users'
+// mutes of it) under the new name.  Protected channels can't be renamed, since they're configured
+// by name.
+func (m *Model) RenameChannel(channelname string, newChannelname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the channel doesn't exist, do nothing
+	channel, ok := m.channels[channelname]
+	if !ok {
+		return ErrChannelNotFound
+	}
+
+	// Disallow renaming of protected channels
+	if m.policy.IsChannelProtected(channelname) {
+		return ErrChannelProtected
+	}
+
+	// Disallow renaming to an empty channelname, or one with a space in it
+	if newChannelname == "" || strings.Contains(newChannelname, " ") {
+		return ErrInvalidName
+	}
+
+	// If the new name is taken, do nothing
+	if _, ok := m.channels[newChannelname]; ok {
+		return ErrChannelExists
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.RenameChannel(channelname, newChannelname)
+	}); err != nil {
+		return err
+	}
+
+	// A deleted channel can't be restored once its name is reused
+	delete(m.deleted, newChannelname)
+
+	// Move the channel to its new name
+	delete(m.channels, channelname)
+	channel.Name = newChannelname
+	m.channels[newChannelname] = channel
+	m.names.Remove(FuzzyKindChannel, channelname)
+	m.names.Add(FuzzyKindChannel, newChannelname)
+
+	for _, user := range m.users {
+		for i, mutedChannelname := range user.MutedChannels {
+			if mutedChannelname == channelname {
+				user.MutedChannels[i] = newChannelname
+			}
+		}
+	}
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.ChannelRenamed(channelname, newChannelname)
+	}
+
+	if m.events != nil {
+		m.events.Emit("channel_renamed", "", newChannelname)
+	}
+
+	return nil
+}
+
+// GetDeletedChannels returns the deleted channels that can still be restored, with the time they
+// were deleted.
+func (m *Model) GetDeletedChannels() map[string]time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.purgeDeletedChannels()
+
+	deletedChannels := make(map[string]time.Time)
+	for channelname, deleted := range m.deleted {
+		deletedChannels[channelname] = deleted.deletedAt
+	}
+
+	return deletedChannels
+}
+
+// purgeDeletedChannels forgets the deleted channels that are past the undo window.
+func (m *Model) purgeDeletedChannels() {
+	now := m.now()
+	for channelname, deleted := range m.deleted {
+		if now.Sub(deleted.deletedAt) > m.options.UndoWindow {
+			delete(m.deleted, channelname)
+		}
+	}
+}
+
+// JoinChannel adds a requested user to the members of a requested channel.
+func (m *Model) JoinChannel(username string, channelname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.joinChannel(username, channelname)
+}
+
+// LeaveChannel removes a requested user from the members of a requested channel.
+func (m *Model) LeaveChannel(username string, channelname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.leaveChannel(username, channelname)
+}
+
+func (m *Model) leaveChannel(username string, channelname string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the channel doesn't exist, do nothing
+	if _, ok := m.channels[channelname]; !ok {
+		return ErrChannelNotFound
+	}
+
+	// If the user isn't a member, do nothing
+	channel := m.channels[channelname]
+	if _, ok := channel.Members[username]; !ok {
+		return ErrNotMember
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.LeaveChannel(username, channelname)
+	}); err != nil {
+		return err
+	}
+
+	// Remove the member
+	delete(channel.Members, username)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.ChannelChanged(channelname)
+	}
+
+	return nil
+}
+
+// GetJoinedChannels returns a list of all channels that a requested user is a member of.
+func (m *Model) GetJoinedChannels(username string) map[string]struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	channels := make(map[string]struct{})
+	for _, channel := range m.channels {
+		if _, ok := channel.Members[username]; ok {
+			channels[channel.Name] = struct{}{}
+		}
+	}
+
+	return channels
+}
+
+// FuzzyFind returns up to limit channel and user names matching a query (e.g. a few characters
+// typed into a quick switcher), best first: exact names, then prefixes, then the names with the
+// query's characters at the start of their words (see the fuzzy package).  Deleted channels aren't
+// found.
+func (m *Model) FuzzyFind(query string, limit int) []fuzzy.Match {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.names.Find(query, limit)
+}
+
+// GetChannelMembers returns a list of all users that are members of a requested channel (empty if
+// the channel doesn't exist).  Joining or leaving a channel notifies its subscribers with
+// ChannelChanged, so clients showing the list know to get it again.
+func (m *Model) GetChannelMembers(channelname string) map[string]struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	members := make(map[string]struct{})
+	if channel, ok := m.channels[channelname]; ok {
+		for member := range channel.Members {
+			members[member] = struct{}{}
+		}
+	}
+
+	return members
+}
+
+// GetPublicChannels returns a list of all channels that can be discovered and joined
+// by any user.  Every channel is currently public.
+func (m *Model) GetPublicChannels() map[string]struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	channels := make(map[string]struct{})
+	for _, channel := range m.channels {
+		channels[channel.Name] = struct{}{}
+	}
+
+	return channels
+}
+
+// GetChannelInfo returns information about a requested channel.
+func (m *Model) GetChannelInfo(channelname string) ChannelInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// If the user doesn't exist, do nothing
+	if _, ok := m.channels[channelname]; !ok {
+		return ChannelInfo{}
+	}
+
+	// Copy and return the channel info
+	return newChannelInfo(m.channels[channelname])
+}
+
+// BrowseChannels returns information about all public channels, ordered by most recent
+// activity first.
+func (m *Model) BrowseChannels() []ChannelInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	channelInfos := make([]ChannelInfo, 0, len(m.channels))
+	for _, channel := range m.channels {
+		channelInfos = append(channelInfos, newChannelInfo(channel))
+	}
+
+	// Sort by most recent activity, falling back to the channel name for ties
+	sort.Slice(channelInfos, func(i, j int) bool {
+		if !channelInfos[i].LastActivity.Equal(channelInfos[j].LastActivity) {
+			return channelInfos[i].LastActivity.After(channelInfos[j].LastActivity)
+		}
+		return channelInfos[i].Name < channelInfos[j].Name
+	})
+
+	return channelInfos
+}
+
+// SetChannelTopic sets the topic of a requested channel.
+func (m *Model) SetChannelTopic(channelname string, topic string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.setChannelTopic(channelname, topic)
+}
+
+func (m *Model) setChannelTopic(channelname string, topic string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the channel doesn't exist, do nothing
+	if _, ok := m.channels[channelname]; !ok {
+		return ErrChannelNotFound
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.SetChannelTopic(channelname, topic)
+	}); err != nil {
+		return err
+	}
+
+	// Update the topic
+	m.channels[channelname].Topic = topic
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.ChannelChanged(channelname)
+	}
+
+	return nil
+}
+
+// SetChannelRules sets the language and content rules of a requested channel.  The language
+// selects the message filter used for the channel.
+func (m *Model) SetChannelRules(channelname string, language string, rules string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.setChannelRules(channelname, language, rules)
+}
+
+func (m *Model) setChannelRules(channelname string, language string, rules string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the channel doesn't exist, do nothing
+	if _, ok := m.channels[channelname]; !ok {
+		return ErrChannelNotFound
+	}
+
+	// Disallow language with space
+	if strings.Contains(language, " ") {
+		return ErrInvalidLanguage
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.SetChannelRules(channelname, language, rules)
+	}); err != nil {
+		return err
+	}
+
+	// Update the language and rules
+	m.channels[channelname].Language = language
+	m.channels[channelname].Rules = rules
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.ChannelChanged(channelname)
+	}
+
+	return nil
+}
+
+// GetChannelHistory returns message history for a requested channel
+// filtered for a requested user up to some requested number of messages
+// (-1 for all).
+func (m *Model) GetChannelHistory(channelname string, username string, numMessages int) []Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Validate that channel exists
+	if _, ok := m.channels[channelname]; !ok {
+		return make([]Message, 0)
+	}
+
+	// Validate that user exists
+	if _, ok := m.users[username]; !ok {
+		return make([]Message, 0)
+	}
+
+	// Figure out which message to start copying from
+	channel := m.channels[channelname]
+	user := m.users[username]
+
+	startingMessageIndex := len(channel.Messages) - numMessages
+	if startingMessageIndex < 0 {
+		startingMessageIndex = 0
+	}
+
+	// Copy all messages when numMessages is -1 (and none for other negative numbers)
+	if numMessages == -1 {
+		startingMessageIndex = 0
+	} else if startingMessageIndex > len(channel.Messages) {
+		startingMessageIndex = len(channel.Messages)
+	}
+
+	return filterMessages(channel.Messages[startingMessageIndex:], user)
+}
+
+// GetChannelHistoryRange returns the messages in a requested channel with seqs from one seq to
+// another (inclusive) filtered for a requested user.  Messages from blocked users are left out,
+// so the seqs returned may have gaps.
+func (m *Model) GetChannelHistoryRange(channelname string, username string, fromSeq uint64, toSeq uint64) []Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Validate that channel exists
+	if _, ok := m.channels[channelname]; !ok {
+		return make([]Message, 0)
+	}
+
+	// Validate that user exists
+	if _, ok := m.users[username]; !ok {
+		return make([]Message, 0)
+	}
+
+	// Seqs start at 1 and have no gaps, so they map directly to message indexes
+	channel := m.channels[channelname]
+	user := m.users[username]
+
+	if fromSeq < 1 {
+		fromSeq = 1
+	}
+
+	if toSeq > uint64(len(channel.Messages)) {
+		toSeq = uint64(len(channel.Messages))
+	}
+
+	if fromSeq > toSeq {
+		return make([]Message, 0)
+	}
+
+	return filterMessages(channel.Messages[fromSeq-1:toSeq], user)
+}
+
+// GetMessage returns a message in a requested channel by its ID, filtered for a requested user
+// (false if there's no such message, or it's from one of the user's blocked users).
+func (m *Model) GetMessage(channelname string, username string, messageID uint64) (Message, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Validate that channel exists
+	channel, ok := m.channels[channelname]
+	if !ok {
+		return Message{}, false
+	}
+
+	// Validate that user exists
+	user, ok := m.users[username]
+	if !ok {
+		return Message{}, false
+	}
+
+	messageIndex := findMessage(channel, messageID)
+	if messageIndex == -1 {
+		return Message{}, false
+	}
+
+	messages := filterMessages(channel.Messages[messageIndex:messageIndex+1], user)
+	if len(messages) == 0 {
+		return Message{}, false
+	}
+
+	return messages[0], true
+}
+
+// GetChannels returns a list of all channels.
+func (m *Model) GetChannels() map[string]struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	channels := make(map[string]struct{})
+	for _, channel := range m.channels {
+		channels[channel.Name] = struct{}{}
+	}
+
+	return channels
+}
+
+// PostMessage posts a message to a requested channel for a requested user, and returns the posted
+// message (with its ID and timestamp).  The timestamp is the time claimed by the client (zero if it
+// didn't claim one), the message is given the model's time.
+func (m *Model) PostMessage(channelname string, username string, timestamp time.Time, text string) (Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.postMessage(channelname, username, timestamp, time.Time{}, text, "", Origin{}, true)
+}
+
+// PostMessageOnce posts a message to a requested channel for a requested user, unless the user
+// already posted a message with the same idempotency key (so a client can safely retry a post
+// it didn't hear back about).  It returns the posted message (or the one posted earlier with the
+// key), or the error if the message wasn't posted.  Only the most recent keys are remembered (see
+// MaxIdempotencyKeys), they're forgotten when their channel or user is deleted, and they aren't
+// kept across restarts.
+func (m *Model) PostMessageOnce(channelname string, username string, timestamp time.Time, text string, idempotencyKey string) (Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Without a key, this is a plain post
+	if idempotencyKey == "" {
+		return m.postMessage(channelname, username, timestamp, time.Time{}, text, "", Origin{}, true)
+	}
+
+	postedKey := username + "\x00" + idempotencyKey
+	if message, ok := m.postedKeys[postedKey]; ok {
+		return message, nil
+	}
+
+	message, err := m.postMessage(channelname, username, timestamp, time.Time{}, text, "", Origin{}, true)
+	if err != nil {
+		return Message{}, err
+	}
+
+	// Remember the key (forgetting the oldest one once the limit is reached)
+	if len(m.postedKeyList) >= MaxIdempotencyKeys {
+		delete(m.postedKeys, m.postedKeyList[0])
+		m.postedKeyList = m.postedKeyList[1:]
+	}
+	m.postedKeys[postedKey] = message
+	m.postedKeyList = append(m.postedKeyList, postedKey)
+
+	return message, nil
+}
+
+// PostBridgedMessage posts a message to a requested channel for a requested user on behalf of an
+// external author from another system (e.g. a bridge or webhook), and returns the posted message.
+// The timestamp is the time claimed by the other system, as with PostMessage.
+func (m *Model) PostBridgedMessage(channelname string, username string, timestamp time.Time, text string, originSystem string, originAuthor string) (Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Disallow bridged messages without an originating system
+	if originSystem == "" {
+		return Message{}, ErrMissingOrigin
+	}
+
+	// Call the private (lock held) version
+	return m.postMessage(channelname, username, timestamp, time.Time{}, text, "", Origin{System: originSystem, Author: originAuthor}, true)
+}
+
+// PostMessageMulti cross-posts a message to several requested channels for a requested user, and
+// returns the posted copies (in the order of the channels).  Either it's posted to all of the
+// channels or (if any of them would reject it) none of them.  The copies share their identity (see
+// Message.CrossPost), so editing or deleting any of them edits or deletes them all.  The timestamp
+// is the time claimed by the client, as with PostMessage.
+func (m *Model) PostMessageMulti(channelnames []string, username string, timestamp time.Time, text string) ([]Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.postMessageMulti(channelnames, username, timestamp, time.Time{}, text)
+}
+
+// PostSnippet posts a code snippet in a requested language (e.g. "go") to a requested channel for
+// a requested user, and returns the posted message.  The timestamp is the time claimed by the
+// client, as with PostMessage.
+func (m *Model) PostSnippet(channelname string, username string, timestamp time.Time, language string, text string) (Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.postSnippet(channelname, username, timestamp, time.Time{}, language, text)
+}
+
+func (m *Model) postSnippet(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, language string, text string) (Message, error) {
+	// Disallow snippets without a language (which would be posted as plain messages)
+	if language == "" {
+		return Message{}, ErrInvalidSnippet
+	}
+
+	return m.postMessage(channelname, username, timestamp, claimedTimestamp, text, language, Origin{}, true)
+}
+
+// ImportMessage posts a message restored from elsewhere (e.g. an archive) to a requested channel,
+// keeping its timestamp.  Only the message's username, timestamp, text, snippet language and origin
+// are used.
+func (m *Model) ImportMessage(channelname string, message Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	_, err := m.postMessage(channelname, message.Username, message.Timestamp, time.Time{}, message.Text, message.SnippetLanguage, message.Origin, false)
+	return err
+}
+
+// EditMessage replaces the text of a message in a requested channel, which only the user that
+// posted it can do (a bridged message can be edited by the user that posted it on behalf of the
+// external author).  The new text is filtered the same as a posted message, and the message is
+// marked with the model's time as when it was edited.
+func (m *Model) EditMessage(channelname string, messageID uint64, username string, text string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.editMessage(channelname, messageID, username, time.Time{}, text)
+}
+
+func (m *Model) editMessage(channelname string, messageID uint64, username string, timestamp time.Time, text string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// Validate that channel exists
+	channel, ok := m.channels[channelname]
+	if !ok {
+		return ErrChannelNotFound
+	}
+
+	// Validate that user exists
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	// Find the message (deleted messages can't be edited)
+	messageIndex := findMessage(channel, messageID)
+	if messageIndex == -1 || channel.Messages[messageIndex].Deleted {
+		return ErrMessageNotFound
+	}
+
+	// Only the author can edit the message
+	if channel.Messages[messageIndex].Username != username {
+		return ErrNotAuthor
+	}
+
+	// Apply the channel language's filter, and the other channels' of a cross-posted message so its
+	// copies keep the same text (replayed edits were filtered when first made)
+	copies := m.messageCopies(channel, messageIndex)
+	for _, messageCopy := range copies {
+		if filter, ok := m.options.LanguageFilters[messageCopy.channel.Language]; ok && !m.replaying {
+			text = filter(text)
+		}
+	}
+
+	// Disregard empty messages
+	if len(text) == 0 {
+		return ErrEmptyMessage
+	}
+
+	// Assign the edited time (replayed edits keep the one assigned when they were first made)
+	if !m.replaying && !m.options.TrustTimestamps {
+		timestamp = m.now()
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged), once for the copy
+	// that was edited
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.EditMessage(channelname, messageID, username, timestamp, text)
+	}); err != nil {
+		return err
+	}
+
+	// Update the message's copies (in place, as the history handed out is always copied), and their
+	// mentions
+	mentions := channel.Messages[messageIndex].Mentions
+	for _, messageCopy := range copies {
+		copyMessage := &messageCopy.channel.Messages[messageCopy.messageIndex]
+		if copyMessage.SnippetLanguage == "" {
+			copyMessage.Mentions = m.parseMentions(text, username)
+		}
+		copyMessage.Text = text
+		copyMessage.Edited = timestamp
+	}
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		for _, messageCopy := range copies {
+			m.subsEngine.MessageChanged(messageCopy.channel.Name, messageCopy.channel.Messages[messageCopy.messageIndex].ID)
+		}
+	}
+
+	// Only the users the edit newly mentions are told about it (about the copy that was edited)
+	for _, mention := range channel.Messages[messageIndex].Mentions {
+		if !containsName(mentions, mention) {
+			m.notifyMention(channel, channel.Messages[messageIndex], mention)
+		}
+	}
+
+	if m.events != nil {
+		m.events.Emit("message_edited", username, channelname)
+	}
+
+	return nil
+}
+
+// DeleteMessage deletes a message in a requested channel (leaving a tombstone in its place), which
+// only the user that posted it can do.
+func (m *Model) DeleteMessage(channelname string, messageID uint64, username string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Disallow the empty username, which stands for a moderator
+	if username == "" {
+		return ErrUserNotFound
+	}
+
+	// Call the private (lock held) version
+	return m.deleteMessage(channelname, messageID, username)
+}
+
+// ModerateMessage deletes any message in a requested channel (leaving a tombstone in its place).
+// It's meant for privileged callers (e.g. the admin API), and is logged as a deletion by the empty
+// username.
+func (m *Model) ModerateMessage(channelname string, messageID uint64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.deleteMessage(channelname, messageID, "")
+}
+
+// deleteMessage deletes a message for its author, or for a moderator when the username is empty.
+func (m *Model) deleteMessage(channelname string, messageID uint64, username string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// Validate that channel exists
+	channel, ok := m.channels[channelname]
+	if !ok {
+		return ErrChannelNotFound
+	}
+
+	// Validate that user exists
+	if _, ok := m.users[username]; !ok && username != "" {
+		return ErrUserNotFound
+	}
+
+	// Find the message (deleting it again is rejected)
+	messageIndex := findMessage(channel, messageID)
+	if messageIndex == -1 || channel.Messages[messageIndex].Deleted {
+		return ErrMessageNotFound
+	}
+
+	// Only the author (or a moderator) can delete the message
+	if username != "" && channel.Messages[messageIndex].Username != username {
+		return ErrNotAuthor
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged), once for the copy
+	// that was deleted
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.DeleteMessage(channelname, messageID, username)
+	}); err != nil {
+		return err
+	}
+
+	// Leave a tombstone for each of the message's copies (in place, as the history handed out is
+	// always copied), which no longer refers to the attachments and isn't starred
+	copies := m.messageCopies(channel, messageIndex)
+	for _, messageCopy := range copies {
+		copyMessage := &messageCopy.channel.Messages[messageCopy.messageIndex]
+		copyMessage.Deleted = true
+		copyMessage.Text = ""
+		copyMessage.Attachments = nil
+		copyMessage.Mentions = nil
+		unstarMessage(messageCopy.channel, copyMessage.ID)
+	}
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		for _, messageCopy := range copies {
+			m.subsEngine.MessageChanged(messageCopy.channel.Name, messageCopy.channel.Messages[messageCopy.messageIndex].ID)
+		}
+	}
+
+	if m.events != nil {
+		m.events.Emit("message_deleted", username, channelname)
+	}
+
+	return nil
+}
+
+// AttachFile attaches a file (already in the attachments store) to a message in a requested
+// channel, which only the user that posted it can do.
+func (m *Model) AttachFile(channelname string, messageID uint64, username string, attachment Attachment) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.attachFile(channelname, messageID, username, attachment)
+}
+
+func (m *Model) attachFile(channelname string, messageID uint64, username string, attachment Attachment) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// Validate that channel exists
+	channel, ok := m.channels[channelname]
+	if !ok {
+		return ErrChannelNotFound
+	}
+
+	// Validate that user exists
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	// Find the message (deleted messages can't have attachments)
+	messageIndex := findMessage(channel, messageID)
+	if messageIndex == -1 || channel.Messages[messageIndex].Deleted {
+		return ErrMessageNotFound
+	}
+
+	// Only the author can attach files to the message
+	if channel.Messages[messageIndex].Username != username {
+		return ErrNotAuthor
+	}
+
+	// Validate the attachment (the ID is a hex encoded SHA-256 hash)
+	if !validAttachmentID(attachment.ID) || attachment.Name == "" || attachment.Size < 0 {
+		return ErrInvalidAttachment
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.AttachFile(channelname, messageID, username, actions.Attachment(attachment))
+	}); err != nil {
+		return err
+	}
+
+	// Add the attachment (to a new slice, as the attachments of the history handed out aren't copied)
+	attachments := make([]Attachment, 0, len(channel.Messages[messageIndex].Attachments)+1)
+	attachments = append(attachments, channel.Messages[messageIndex].Attachments...)
+	channel.Messages[messageIndex].Attachments = append(attachments, attachment)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.MessageChanged(channelname, messageID)
+	}
+
+	return nil
+}
+
+// GetAttachmentRefs returns the number of messages each file is attached to (by attachment ID),
+// including the messages of deleted channels that can still be restored.  The files in the
+// attachments store that aren't referenced can be deleted.
+func (m *Model) GetAttachmentRefs() map[string]int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	refs := make(map[string]int)
+	countRefs := func(messages []Message) {
+		for _, message := range messages {
+			for _, attachment := range message.Attachments {
+				refs[attachment.ID]++
+			}
+		}
+	}
+
+	for _, channel := range m.channels {
+		countRefs(channel.Messages)
+	}
+
+	for _, deleted := range m.deleted {
+		countRefs(deleted.channel.Messages)
+	}
+
+	return refs
+}
+
+// MarkRead records that a requested user has read a channel up to a message (by its ID), so all of
+// the user's clients agree on what they've read.  Read markers only move forward: marking an
+// earlier message does nothing.  The built-in user (which everyone shares) has no read markers.
+func (m *Model) MarkRead(username string, channelname string, messageID uint64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// Validate that the user and the channel exist
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	channel, ok := m.channels[channelname]
+	if !ok {
+		return ErrChannelNotFound
+	}
+
+	if username == m.options.BuiltinUsername {
+		return ErrBuiltinUser
+	}
+
+	if findMessage(channel, messageID) == -1 {
+		return ErrMessageNotFound
+	}
+
+	// If the user has already read the message, do nothing
+	if channel.readMarkers[username] >= messageID {
+		return nil
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.MarkRead(username, channelname, messageID)
+	}); err != nil {
+		return err
+	}
+
+	if channel.readMarkers == nil {
+		channel.readMarkers = make(map[string]uint64)
+	}
+	channel.readMarkers[username] = messageID
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.ReadMarkerChanged(username, channelname)
+	}
+
+	return nil
+}
+
+// GetReadMarkers returns the ID of the last message a requested user has read in each channel (by
+// channel name), leaving out the channels they haven't marked.
+func (m *Model) GetReadMarkers(username string) map[string]uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	readMarkers := make(map[string]uint64)
+	for channelname, channel := range m.channels {
+		if messageID, ok := channel.readMarkers[username]; ok {
+			readMarkers[channelname] = messageID
+		}
+	}
+
+	return readMarkers
+}
+
+// GetUnreadCounts returns the number of unread messages in each channel a requested user has joined
+// (by channel name): the messages posted by others after the user's read marker, leaving out deleted
+// messages and the messages from the user's blocked users.  Channels with nothing unread are left
+// out, as are the channels the user has muted and all of them for the built-in user (which has no
+// read markers).
+func (m *Model) GetUnreadCounts(username string) map[string]int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	unreadCounts := make(map[string]int)
+	user, ok := m.users[username]
+	if !ok || username == m.options.BuiltinUsername {
+		return unreadCounts
+	}
+
+	for channelname, channel := range m.channels {
+		if _, ok := channel.Members[username]; !ok || containsName(user.MutedChannels, channelname) {
+			continue
+		}
+
+		// The messages in a channel are in ID order, so the unread ones follow the read marker
+		readMarker := channel.readMarkers[username]
+		firstUnread := sort.Search(len(channel.Messages), func(i int) bool { return channel.Messages[i].ID > readMarker })
+		for _, message := range filterMessages(channel.Messages[firstUnread:], user) {
+			if message.Username != username && !message.Deleted {
+				unreadCounts[channelname]++
+			}
+		}
+	}
+
+	return unreadCounts
+}
+
+// StarMessage stars (or, when starred is false, unstars) a message in a requested channel for a
+// requested user, to find it later with GetStarredMessages.  Starring a message that's already
+// starred (or unstarring one that isn't) does nothing.  Deleted messages are unstarred for everyone,
+// and the built-in user (which everyone shares) can't star messages.
+func (m *Model) StarMessage(username string, channelname string, messageID uint64, starred bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// Validate that the user and the channel exist
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	channel, ok := m.channels[channelname]
+	if !ok {
+		return ErrChannelNotFound
+	}
+
+	if username == m.options.BuiltinUsername {
+		return ErrBuiltinUser
+	}
+
+	messageIndex := findMessage(channel, messageID)
+	if messageIndex == -1 || channel.Messages[messageIndex].Deleted {
+		return ErrMessageNotFound
+	}
+
+	// If the message is already starred (or not), do nothing
+	stars := channel.stars[username]
+	starIndex := sort.Search(len(stars), func(i int) bool { return stars[i] >= messageID })
+	if (starIndex < len(stars) && stars[starIndex] == messageID) == starred {
+		return nil
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.StarMessage(username, channelname, messageID, starred)
+	}); err != nil {
+		return err
+	}
+
+	// Keep the stars in ID order (in a new slice, so copies of the model don't share it)
+	newStars := make([]uint64, 0, len(stars)+1)
+	newStars = append(newStars, stars[:starIndex]...)
+	if starred {
+		newStars = append(newStars, messageID)
+		newStars = append(newStars, stars[starIndex:]...)
+	} else {
+		newStars = append(newStars, stars[starIndex+1:]...)
+	}
+
+	if channel.stars == nil {
+		channel.stars = make(map[string][]uint64)
+	}
+	if len(newStars) == 0 {
+		delete(channel.stars, username)
+	} else {
+		channel.stars[username] = newStars
+	}
+
+	return nil
+}
+
+// unstarMessage unstars a message for every user that starred it.
+func unstarMessage(channel *Channel, messageID uint64) {
+	for username, stars := range channel.stars {
+		newStars := make([]uint64, 0, len(stars))
+		for _, starredID := range stars {
+			if starredID != messageID {
+				newStars = append(newStars, starredID)
+			}
+		}
+
+		if len(newStars) == 0 {
+			delete(channel.stars, username)
+		} else {
+			channel.stars[username] = newStars
+		}
+	}
+}
+
+// SetChannelNotes replaces the notes of a requested channel, returning their new version.  The
+// version is the one being edited, so an edit made without seeing the latest version is rejected
+// (ErrNotesChanged) rather than overwriting it.  Empty text clears the notes.
+func (m *Model) SetChannelNotes(channelname string, username string, version uint64, text string) (uint64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	if err := m.setChannelNotes(channelname, username, time.Time{}, version, text); err != nil {
+		return 0, err
+	}
+
+	return m.channels[channelname].notes.Version, nil
+}
+
+func (m *Model) setChannelNotes(channelname string, username string, timestamp time.Time, version uint64, text string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// Validate that the channel and the user exist
+	channel, ok := m.channels[channelname]
+	if !ok {
+		return ErrChannelNotFound
+	}
+
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	if !validProfileField(text, MaxChannelNotesLength, true) {
+		return ErrInvalidNotes
+	}
+
+	// Only a read-only channel's poster can edit its notes, and only the latest version can be
+	// edited (replayed and replicated edits were accepted when first made)
+	if m.enforcingQuotas() && !m.policy.CanPost(channelname, username) {
+		return ErrChannelReadOnly
+	}
+
+	if m.enforcingQuotas() && version != channel.notes.Version {
+		return ErrNotesChanged
+	}
+
+	// Assign the edited time (replayed edits keep the one assigned when they were first made)
+	if !m.replaying && !m.options.TrustTimestamps {
+		timestamp = m.now()
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.SetChannelNotes(channelname, username, timestamp, text)
+	}); err != nil {
+		return err
+	}
+
+	channel.notes = ChannelNotes{
+		Text:    text,
+		Version: channel.notes.Version + 1,
+		Editor:  username,
+		Edited:  timestamp,
+	}
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.ChannelChanged(channelname)
+	}
+
+	return nil
+}
+
+// GetChannelNotes returns the notes of a requested channel (zero if the channel doesn't exist or
+// its notes have never been set).
+func (m *Model) GetChannelNotes(channelname string) ChannelNotes {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	channel, ok := m.channels[channelname]
+	if !ok {
+		return ChannelNotes{}
+	}
+
+	return channel.notes
+}
+
+// GetStarredMessages returns the channel messages a requested user has starred, oldest first
+// (leaving out the messages from the user's blocked users).
+func (m *Model) GetStarredMessages(username string) []StarredMessage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	starredMessages := make([]StarredMessage, 0)
+	user, ok := m.users[username]
+	if !ok {
+		return starredMessages
+	}
+
+	for _, channel := range m.channels {
+		messages := make([]Message, 0, len(channel.stars[username]))
+		for _, messageID := range channel.stars[username] {
+			if messageIndex := findMessage(channel, messageID); messageIndex != -1 {
+				messages = append(messages, channel.Messages[messageIndex])
+			}
+		}
+
+		for _, message := range filterMessages(messages, user) {
+			starredMessages = append(starredMessages, StarredMessage{Channelname: channel.Name, Message: message})
+		}
+	}
+	sort.Slice(starredMessages, func(i, j int) bool { return starredMessages[i].Message.ID < starredMessages[j].Message.ID })
+
+	return starredMessages
+}
+
+// validSnippetLanguage returns whether a snippet language is a short name made of letters, digits
+// and the punctuation languages are usually named with (e.g. "c++", "c#" or "objective-c").
+func validSnippetLanguage(language string) bool {
+	if len(language) > MaxSnippetLanguageLength {
+		return false
+	}
+
+	for _, c := range language {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && !strings.ContainsRune("+#-._", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validAttachmentID returns whether an attachment ID is a hex encoded SHA-256 hash (in lower case).
+func validAttachmentID(id string) bool {
+	if len(id) != 64 {
+		return false
+	}
+
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetChannelHistoryByTime returns the messages in a requested channel posted from one time
+// (inclusive) until another (exclusive) filtered for a requested user.  A zero until time returns
+// everything posted since the from time.
+func (m *Model) GetChannelHistoryByTime(channelname string, username string, fromTime time.Time, untilTime time.Time) []Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Validate that channel exists
+	if _, ok := m.channels[channelname]; !ok {
+		return make([]Message, 0)
+	}
+
+	// Validate that user exists
+	if _, ok := m.users[username]; !ok {
+		return make([]Message, 0)
+	}
+
+	// Imported messages may be out of order, so check every message
+	channel := m.channels[channelname]
+	user := m.users[username]
+
+	channelMessages := make([]Message, 0)
+	for _, message := range channel.Messages {
+		if message.Timestamp.Before(fromTime) {
+			continue
+		}
+
+		if !untilTime.IsZero() && !message.Timestamp.Before(untilTime) {
+			continue
+		}
+
+		channelMessages = append(channelMessages, message)
+	}
+
+	return filterMessages(channelMessages, user)
+}
+
+// GetFirstMessageSince returns the first message posted in a requested channel at or after a
+// requested time (e.g. to jump to a date in the history), or false if there isn't one.
+func (m *Model) GetFirstMessageSince(channelname string, since time.Time) (Message, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Validate that channel exists
+	if _, ok := m.channels[channelname]; !ok {
+		return Message{}, false
+	}
+
+	for _, message := range m.channels[channelname].Messages {
+		if !message.Timestamp.Before(since) {
+			return message, true
+		}
+	}
+
+	return Message{}, false
+}
+
+// GetTopPosters returns the users who posted the most messages in a requested channel since a
+// requested day (a zero time for all time), most first, up to some requested number of users (-1
+// for all).  Posts are counted by the day they were made, so the whole of the since day counts.
+func (m *Model) GetTopPosters(channelname string, since time.Time, numPosters int) []PosterCount {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Validate that channel exists
+	if _, ok := m.channels[channelname]; !ok {
+		return make([]PosterCount, 0)
+	}
+
+	// Add up the counts for each day since the requested one
+	sinceDay := ""
+	if !since.IsZero() {
+		sinceDay = since.Format("2006-01-02")
+	}
+
+	counts := make(map[string]int)
+	for day, dayCounts := range m.channels[channelname].postCounts {
+		if day < sinceDay {
+			continue
+		}
+
+		for username, count := range dayCounts {
+			counts[username] += count
+		}
+	}
+
+	// Order the posters by count (then by name)
+	posters := make([]PosterCount, 0)
+	for username, count := range counts {
+		posters = append(posters, PosterCount{Username: username, NumMessages: count})
+	}
+
+	sort.Slice(posters, func(i, j int) bool {
+		if posters[i].NumMessages != posters[j].NumMessages {
+			return posters[i].NumMessages > posters[j].NumMessages
+		}
+
+		return posters[i].Username < posters[j].Username
+	})
+
+	if numPosters != -1 && numPosters < len(posters) {
+		if numPosters < 0 {
+			numPosters = 0
+		}
+		posters = posters[:numPosters]
+	}
+
+	return posters
+}
+
+// PeriodSince returns the day a leaderboard period starts for GetTopPosters: "day" (today),
+// "week" (the last 7 days), "month" (the last 30 days) or "all" (a zero time).  It returns false
+// for an unknown period.
+func PeriodSince(period string, now time.Time) (time.Time, bool) {
+	switch period {
+	case "day":
+		return now, true
+	case "week":
+		return now.AddDate(0, 0, -6), true
+	case "month":
+		return now.AddDate(0, 0, -29), true
+	case "all":
+		return time.Time{}, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// PostDirectMessage posts a message from a requested user to another (in their conversation, which
+// is started by the first message), and returns the posted message.  The timestamp is the time
+// claimed by the client, as with PostMessage.  The built-in user is shared, so it can't take part
+// in conversations.
+func (m *Model) PostDirectMessage(username string, recipient string, timestamp time.Time, text string) (Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.postDirectMessage(username, recipient, timestamp, time.Time{}, text)
+}
+
+func (m *Model) postDirectMessage(username string, recipient string, timestamp time.Time, claimedTimestamp time.Time, text string) (Message, error) {
+	if err := m.checkWritable(); err != nil {
+		return Message{}, err
+	}
+
+	// Validate that both users exist
+	if _, ok := m.users[username]; !ok {
+		return Message{}, ErrUserNotFound
+	}
+
+	if _, ok := m.users[recipient]; !ok {
+		return Message{}, ErrUserNotFound
+	}
+
+	// Don't allow the built-in user in conversations
+	if username == m.options.BuiltinUsername || recipient == m.options.BuiltinUsername {
+		return Message{}, ErrBuiltinUser
+	}
+
+	// Don't allow messaging yourself
+	if username == recipient {
+		return Message{}, ErrMessageSelf
+	}
+
+	// Disregard empty messages
+	if len(text) == 0 {
+		return Message{}, ErrEmptyMessage
+	}
+
+	if err := m.checkMessageQuota(username, 1); err != nil {
+		return Message{}, err
+	}
+
+	// Assign the timestamp
+	key := conversationKey(username, recipient)
+	var messages []Message
+	if directMessages, ok := m.conversations[key]; ok {
+		messages = directMessages.messages
+	}
+	timestamp, claimedTimestamp = m.assignPrivateTimestamp(messages, timestamp, claimedTimestamp)
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.PostDirectMessage(username, recipient, timestamp, claimedTimestamp, text)
+	}); err != nil {
+		return Message{}, err
+	}
+
+	// Start the conversation with the first message
+	if _, ok := m.conversations[key]; !ok {
+		m.conversations[key] = &conversation{
+			usernames: [2]string{username, recipient},
+			messages:  make([]Message, 0),
+		}
+	}
+	directMessages := m.conversations[key]
+
+	newMessage := m.appendPrivateMessage(&directMessages.messages, username, timestamp, claimedTimestamp, text)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.DirectMessagesChanged(username, recipient)
+	}
+
+	if m.events != nil {
+		m.events.Emit("direct_message_posted", username, "")
+	}
+
+	return newMessage, nil
+}
+
+// GetDirectMessageHistory returns the messages between a requested user and another, filtered for
+// the requested user, up to some requested number of messages (-1 for all).
+func (m *Model) GetDirectMessageHistory(username string, otherUsername string, numMessages int) []Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Validate that user exists
+	user, ok := m.users[username]
+	if !ok {
+		return make([]Message, 0)
+	}
+
+	directMessages, ok := m.conversations[conversationKey(username, otherUsername)]
+	if !ok {
+		return make([]Message, 0)
+	}
+
+	messages := directMessages.messages
+	if numMessages != -1 {
+		if numMessages < 0 {
+			numMessages = 0
+		}
+
+		if numMessages < len(messages) {
+			messages = messages[len(messages)-numMessages:]
+		}
+	}
+
+	return filterMessages(messages, user)
+}
+
+// GetConversations returns the users that a requested user has direct messages with, along with
+// the number of messages in each conversation (the seq of the latest one).
+func (m *Model) GetConversations(username string) map[string]uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	conversations := make(map[string]uint64)
+	for _, directMessages := range m.conversations {
+		for i, conversationUsername := range directMessages.usernames {
+			if conversationUsername == username {
+				conversations[directMessages.usernames[1-i]] = uint64(len(directMessages.messages))
+			}
+		}
+	}
+
+	return conversations
+}
+
+// conversationKey returns the key of the conversation between two users (the same either way
+// round).
+func conversationKey(username string, otherUsername string) string {
+	if otherUsername < username {
+		username, otherUsername = otherUsername, username
+	}
+
+	return username + "\x00" + otherUsername
+}
+
+// CreateGroup creates a group conversation between a requested user and some other users, and
+// returns its ID.  Groups are private to their members, so they aren't listed with the channels,
+// and the members are fixed when the group is created (repeated members, or the requested user
+// among them, are ignored).  As with conversations, the built-in user can't take part.
+func (m *Model) CreateGroup(username string, members []string) (uint64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.createGroup(username, members)
+}
+
+func (m *Model) createGroup(username string, members []string) (uint64, error) {
+	if err := m.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	// Validate that all of the members exist (and aren't the built-in user)
+	groupMembers := make(map[string]struct{})
+	for _, member := range append([]string{username}, members...) {
+		if _, ok := m.users[member]; !ok {
+			return 0, ErrUserNotFound
+		}
+
+		if member == m.options.BuiltinUsername {
+			return 0, ErrBuiltinUser
+		}
+
+		groupMembers[member] = struct{}{}
+	}
+
+	// Don't allow a group of one
+	if len(groupMembers) < 2 {
+		return 0, ErrGroupTooSmall
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.CreateGroup(username, members)
+	}); err != nil {
+		return 0, err
+	}
+
+	// Group IDs are assigned in order, so replaying the log creates the same groups
+	m.lastGroupID++
+	m.groups[m.lastGroupID] = &group{
+		members:  groupMembers,
+		messages: make([]Message, 0),
+	}
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.GroupChanged(m.lastGroupID, sortedNames(groupMembers))
+	}
+
+	if m.events != nil {
+		m.events.Emit("group_created", username, "")
+	}
+
+	return m.lastGroupID, nil
+}
+
+// PostGroupMessage posts a message from a requested user to a group they're a member of, and
+// returns the posted message.  The timestamp is the time claimed by the client, as with
+// PostMessage.
+func (m *Model) PostGroupMessage(groupID uint64, username string, timestamp time.Time, text string) (Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Call the private (lock held) version
+	return m.postGroupMessage(groupID, username, timestamp, time.Time{}, text)
+}
+
+func (m *Model) postGroupMessage(groupID uint64, username string, timestamp time.Time, claimedTimestamp time.Time, text string) (Message, error) {
+	if err := m.checkWritable(); err != nil {
+		return Message{}, err
+	}
+
+	// Validate that the user and group exist
+	if _, ok := m.users[username]; !ok {
+		return Message{}, ErrUserNotFound
+	}
+
+	groupMessages, ok := m.groups[groupID]
+	if !ok {
+		return Message{}, ErrGroupNotFound
+	}
+
+	// Only the members can post
+	if _, ok := groupMessages.members[username]; !ok {
+		return Message{}, ErrNotGroupMember
+	}
+
+	// Disregard empty messages
+	if len(text) == 0 {
+		return Message{}, ErrEmptyMessage
+	}
+
+	if err := m.checkMessageQuota(username, 1); err != nil {
+		return Message{}, err
+	}
+
+	// Assign the timestamp
+	timestamp, claimedTimestamp = m.assignPrivateTimestamp(groupMessages.messages, timestamp, claimedTimestamp)
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.PostGroupMessage(groupID, username, timestamp, claimedTimestamp, text)
+	}); err != nil {
+		return Message{}, err
+	}
+
+	newMessage := m.appendPrivateMessage(&groupMessages.messages, username, timestamp, claimedTimestamp, text)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.GroupChanged(groupID, sortedNames(groupMessages.members))
+	}
+
+	if m.events != nil {
+		m.events.Emit("group_message_posted", username, "")
+	}
+
+	return newMessage, nil
+}
+
+// GetGroupMessageHistory returns the messages in a group, filtered for a requested user, up to
+// some requested number of messages (-1 for all).  Users that aren't members of the group get no
+// messages.
+func (m *Model) GetGroupMessageHistory(groupID uint64, username string, numMessages int) []Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Validate that user exists
+	user, ok := m.users[username]
+	if !ok {
+		return make([]Message, 0)
+	}
+
+	groupMessages, ok := m.groups[groupID]
+	if !ok {
+		return make([]Message, 0)
+	}
+
+	if _, ok := groupMessages.members[username]; !ok {
+		return make([]Message, 0)
+	}
+
+	messages := groupMessages.messages
+	if numMessages != -1 {
+		if numMessages < 0 {
+			numMessages = 0
+		}
+
+		if numMessages < len(messages) {
+			messages = messages[len(messages)-numMessages:]
+		}
+	}
+
+	return filterMessages(messages, user)
+}
+
+// GetGroups returns the groups a requested user is a member of, in the order they were created.
+func (m *Model) GetGroups(username string) []GroupInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	groups := make([]GroupInfo, 0)
+	for groupID, groupMessages := range m.groups {
+		if _, ok := groupMessages.members[username]; ok {
+			groups = append(groups, GroupInfo{
+				ID:          groupID,
+				Members:     sortedNames(groupMessages.members),
+				NumMessages: uint64(len(groupMessages.messages)),
+			})
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+
+	return groups
+}
+
+// CreateEvent adds an event to a channel's calendar, and returns the event's ID.  The title is a
+// single line no longer than MaxEventTitleLength.
+func (m *Model) CreateEvent(channelname string, username string, start time.Time, title string) (uint64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	// Validate that the channel and the user exist
+	channel, ok := m.channels[channelname]
+	if !ok {
+		return 0, ErrChannelNotFound
+	}
+
+	if _, ok := m.users[username]; !ok {
+		return 0, ErrUserNotFound
+	}
+
+	if start.IsZero() || strings.TrimSpace(title) == "" || !validProfileField(title, MaxEventTitleLength, false) {
+		return 0, ErrInvalidEvent
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.CreateEvent(channelname, username, start, title)
+	}); err != nil {
+		return 0, err
+	}
+
+	// Event IDs are assigned in order, so replaying the log creates the same events
+	if channel.events == nil {
+		channel.events = make(map[uint64]*calendarEvent)
+	}
+	m.lastEventID++
+	channel.events[m.lastEventID] = &calendarEvent{
+		creator: username,
+		start:   start,
+		title:   title,
+		going:   make(map[string]struct{}),
+	}
+
+	return m.lastEventID, nil
+}
+
+// RSVPEvent records whether a requested user is going to an event.  The built-in user (which
+// everyone shares) can't RSVP.
+func (m *Model) RSVPEvent(eventID uint64, username string, going bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	event := m.findEvent(eventID)
+	if event == nil {
+		return ErrEventNotFound
+	}
+
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	if username == m.options.BuiltinUsername {
+		return ErrBuiltinUser
+	}
+
+	// If the user already answered the same, do nothing
+	if _, ok := event.going[username]; ok == going {
+		return nil
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.RSVPEvent(eventID, username, going)
+	}); err != nil {
+		return err
+	}
+
+	if going {
+		event.going[username] = struct{}{}
+	} else {
+		delete(event.going, username)
+	}
+
+	return nil
+}
+
+// MarkEventReminded records that the reminder for an event has been posted, so it's only posted
+// once.
+func (m *Model) MarkEventReminded(eventID uint64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	event := m.findEvent(eventID)
+	if event == nil {
+		return ErrEventNotFound
+	}
+
+	if event.reminded {
+		return ErrEventReminded
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.MarkEventReminded(eventID)
+	}); err != nil {
+		return err
+	}
+
+	event.reminded = true
+
+	return nil
+}
+
+// GetEvents returns the events on a channel's calendar, in the order they start.
+func (m *Model) GetEvents(channelname string) []CalendarEvent {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	events := make([]CalendarEvent, 0)
+	channel, ok := m.channels[channelname]
+	if !ok {
+		return events
+	}
+
+	for eventID, event := range channel.events {
+		events = append(events, CalendarEvent{
+			ID:          eventID,
+			Channelname: channel.Name,
+			Creator:     event.creator,
+			Start:       event.start,
+			Title:       event.title,
+			Going:       sortedNames(event.going),
+			Reminded:    event.reminded,
+		})
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Start.Equal(events[j].Start) {
+			return events[i].ID < events[j].ID
+		}
+		return events[i].Start.Before(events[j].Start)
+	})
+
+	return events
+}
+
+// findEvent returns an event (nil if there's no such event, or its channel was deleted).  The lock
+// must be held.
+func (m *Model) findEvent(eventID uint64) *calendarEvent {
+	for _, channel := range m.channels {
+		if event, ok := channel.events[eventID]; ok {
+			return event
+		}
+	}
+
+	return nil
+}
+
+// CreateTeam creates a new (empty) team.  A team is a named set of users (e.g. "devs") that other
+// features can target as a whole, unlike a group it has no messages of its own.
+func (m *Model) CreateTeam(teamname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the team already exists, do nothing
+	if _, ok := m.teams[teamname]; ok {
+		return ErrTeamExists
+	}
+
+	// Disallow adding of empty team, or one with a space in its name
+	if teamname == "" || strings.Contains(teamname, " ") {
+		return ErrInvalidName
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.CreateTeam(teamname)
+	}); err != nil {
+		return err
+	}
+
+	m.teams[teamname] = make(map[string]struct{})
+
+	return nil
+}
+
+// DeleteTeam deletes a team (its members are left as they were).
+func (m *Model) DeleteTeam(teamname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the team doesn't exist, do nothing
+	if _, ok := m.teams[teamname]; !ok {
+		return ErrTeamNotFound
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.DeleteTeam(teamname)
+	}); err != nil {
+		return err
+	}
+
+	delete(m.teams, teamname)
+
+	return nil
+}
+
+// AddTeamMember adds a requested user to a team.  As with groups, the built-in user can't be a
+// member.
+func (m *Model) AddTeamMember(teamname string, username string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// Validate that the team and the user exist
+	members, ok := m.teams[teamname]
+	if !ok {
+		return ErrTeamNotFound
+	}
+
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	if username == m.options.BuiltinUsername {
+		return ErrBuiltinUser
+	}
+
+	if _, ok := members[username]; ok {
+		return ErrAlreadyTeamMember
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.AddTeamMember(teamname, username)
+	}); err != nil {
+		return err
+	}
+
+	members[username] = struct{}{}
+
+	return nil
+}
+
+// RemoveTeamMember removes a requested user from a team (the team is kept when it has no members
+// left).
+func (m *Model) RemoveTeamMember(teamname string, username string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// Validate that the team exists and the user is a member
+	members, ok := m.teams[teamname]
+	if !ok {
+		return ErrTeamNotFound
+	}
+
+	if _, ok := members[username]; !ok {
+		return ErrNotTeamMember
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.RemoveTeamMember(teamname, username)
+	}); err != nil {
+		return err
+	}
+
+	delete(members, username)
+
+	return nil
+}
+
+// GetTeams returns a list of all teams.
+func (m *Model) GetTeams() map[string]struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	teams := make(map[string]struct{})
+	for teamname := range m.teams {
+		teams[teamname] = struct{}{}
+	}
+
+	return teams
+}
+
+// GetTeamMembers returns a list of the members of a requested team (empty if it doesn't exist).
+func (m *Model) GetTeamMembers(teamname string) map[string]struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	members := make(map[string]struct{})
+	for member := range m.teams[teamname] {
+		members[member] = struct{}{}
+	}
+
+	return members
+}
+
+// GetUserTeams returns a list of the teams a requested user is a member of.
+func (m *Model) GetUserTeams(username string) map[string]struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	teams := make(map[string]struct{})
+	for teamname, members := range m.teams {
+		if _, ok := members[username]; ok {
+			teams[teamname] = struct{}{}
+		}
+	}
+
+	return teams
+}
+
+// assignPrivateTimestamp returns the timestamp of a new message posted to a conversation or group
+// with its messages, along with the time claimed by the client if it's too far off (replayed
+// messages keep the ones logged when they were first posted).  The lock must be held.
+func (m *Model) assignPrivateTimestamp(messages []Message, timestamp time.Time, claimedTimestamp time.Time) (time.Time, time.Time) {
+	if !m.replaying && !m.options.TrustTimestamps {
+		var lastTimestamp time.Time
+		if len(messages) > 0 {
+			lastTimestamp = messages[len(messages)-1].Timestamp
+		}
+		timestamp, claimedTimestamp = m.assignTimestamp(timestamp, lastTimestamp)
+	}
+
+	return timestamp, claimedTimestamp
+}
+
+// appendPrivateMessage adds a new message to the messages of a conversation or group, and returns
+// it.  The lock must be held.
+func (m *Model) appendPrivateMessage(messages *[]Message, username string, timestamp time.Time, claimedTimestamp time.Time, text string) Message {
+	// Message IDs are shared with the channels
+	m.lastMessageID++
+	newMessage := Message{
+		ID:               m.lastMessageID,
+		Seq:              uint64(len(*messages)) + 1,
+		Username:         username,
+		Timestamp:        timestamp,
+		ClaimedTimestamp: claimedTimestamp,
+		Text:             text,
+	}
+	*messages = append(*messages, newMessage)
+	m.countPost(username, timestamp)
+
+	return newMessage
+}
+
+// sortedNames returns a set of names as a sorted slice.
+func sortedNames(names map[string]struct{}) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+// findMessage returns the index of a message in a channel by its ID (-1 if it isn't there).
+func findMessage(channel *Channel, messageID uint64) int {
+	// The messages in a channel are in ID order
+	messageIndex := sort.Search(len(channel.Messages), func(i int) bool { return channel.Messages[i].ID >= messageID })
+	if messageIndex == len(channel.Messages) || channel.Messages[messageIndex].ID != messageID {
+		return -1
+	}
+
+	return messageIndex
+}
+
+// filterMessages copies the messages that aren't from a user's blocked users.
+func filterMessages(channelMessages []Message, user *User) []Message {
+	messages := make([]Message, 0)
+	for _, message := range channelMessages {
+		fromBlockedUser := false
+		for _, blockedUser := range user.BlockedUsers {
+			if message.Username == blockedUser {
+				fromBlockedUser = true
+				break
+			}
+		}
+
+		if !fromBlockedUser {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
+
+func newChannelInfo(channel *Channel) ChannelInfo {
+	channelInfo := ChannelInfo{
+		Name:         channel.Name,
+		Topic:        channel.Topic,
+		Language:     channel.Language,
+		Rules:        channel.Rules,
+		NumMessages:  len(channel.Messages),
+		NumMembers:   len(channel.Members),
+		LastActivity: channel.LastActivity,
+		LastSeq:      uint64(len(channel.Messages)),
+	}
+
+	return channelInfo
+}
+
+func (m *Model) removeUser(username string) {
+	delete(m.users, username)
+	m.names.Remove(FuzzyKindUser, username)
+
+	// Remove the user from all other users' blockedUsers list
+	for _, user := range m.users {
+		removalIndex := -1
+		for i, blockedUsername := range user.BlockedUsers {
+			if blockedUsername == username {
+				removalIndex = i
+				break
+			}
+		}
+
+		if removalIndex != -1 {
+			user.BlockedUsers = append(user.BlockedUsers[:removalIndex], user.BlockedUsers[removalIndex+1:]...)
+		}
+	}
+
+	// Remove the user from all channels' members, post counts, event RSVPs, read markers, stars and
+	// mentions (including the deleted channels that can still be restored, and their mutes)
+	channels := make([]*Channel, 0, len(m.channels)+len(m.deleted))
+	for _, channel := range m.channels {
+		channels = append(channels, channel)
+	}
+	for channelname, deleted := range m.deleted {
+		channels = append(channels, deleted.channel)
+		deleted.mutedBy = removeFromList(deleted.mutedBy, username)
+		m.deleted[channelname] = deleted
+	}
+
+	for _, channel := range channels {
+		delete(channel.Members, username)
+		for _, dayCounts := range channel.postCounts {
+			delete(dayCounts, username)
+		}
+		for _, event := range channel.events {
+			delete(event.going, username)
+		}
+		delete(channel.readMarkers, username)
+		delete(channel.stars, username)
+		renameMention(channel.Messages, username, "")
+	}
+
+	// Remove the user's conversations (so a new user with the name can't read them)
+	for key, directMessages := range m.conversations {
+		if directMessages.usernames[0] == username || directMessages.usernames[1] == username {
+			delete(m.conversations, key)
+		}
+	}
+
+	// Remove the user from their groups (and the groups with no members left)
+	for groupID, groupMessages := range m.groups {
+		delete(groupMessages.members, username)
+		if len(groupMessages.members) == 0 {
+			delete(m.groups, groupID)
+		}
+	}
+
+	// Remove the user from their teams (which are kept, even with no members left)
+	for _, members := range m.teams {
+		delete(members, username)
+	}
+
+	// Forget the user's messages posted today, and the idempotency keys they posted with (so a new
+	// user with the name starts afresh)
+	delete(m.postsToday, username)
+	m.forgetPostedKeys(func(postedKey string, message Message) bool {
+		return strings.HasPrefix(postedKey, username+"\x00")
+	})
+}
+
+func (m *Model) renameUserReferences(username string, newUsername string) {
+	// Rename the user in all other users' blockedUsers list, and as the owner of virtual users
+	for _, user := range m.users {
+		renameInList(user.BlockedUsers, username, newUsername)
+		if user.Owner == username {
+			user.Owner = newUsername
+		}
+	}
+
+	// Rename the user in all channels' members, post counts, messages (and their mentions), events
+	// and read markers (including the deleted channels that can still be restored)
+	channels := make([]*Channel, 0, len(m.channels)+len(m.deleted))
+	for _, channel := range m.channels {
+		channels = append(channels, channel)
+	}
+	for _, deleted := range m.deleted {
+		channels = append(channels, deleted.channel)
+		renameInList(deleted.mutedBy, username, newUsername)
+	}
+
+	for _, channel := range channels {
+		if _, ok := channel.Members[username]; ok {
+			delete(channel.Members, username)
+			channel.Members[newUsername] = struct{}{}
+		}
+
+		for _, dayCounts := range channel.postCounts {
+			if count, ok := dayCounts[username]; ok {
+				delete(dayCounts, username)
+				dayCounts[newUsername] = count
+			}
+		}
+
+		renameAuthor(channel.Messages, username, newUsername)
+		renameMention(channel.Messages, username, newUsername)
+
+		for _, event := range channel.events {
+			if event.creator == username {
+				event.creator = newUsername
+			}
+			if _, ok := event.going[username]; ok {
+				delete(event.going, username)
+				event.going[newUsername] = struct{}{}
+			}
+		}
+
+		if messageID, ok := channel.readMarkers[username]; ok {
+			delete(channel.readMarkers, username)
+			channel.readMarkers[newUsername] = messageID
+		}
+
+		if stars, ok := channel.stars[username]; ok {
+			delete(channel.stars, username)
+			channel.stars[newUsername] = stars
+		}
+
+		if channel.notes.Editor == username {
+			channel.notes.Editor = newUsername
+		}
+	}
+
+	// Rename the user in their conversations (which are keyed by both usernames)
+	for key, directMessages := range m.conversations {
+		for i, conversationUsername := range directMessages.usernames {
+			if conversationUsername != username {
+				continue
+			}
+
+			directMessages.usernames[i] = newUsername
+			renameAuthor(directMessages.messages, username, newUsername)
+			delete(m.conversations, key)
+			m.conversations[conversationKey(directMessages.usernames[0], directMessages.usernames[1])] = directMessages
+		}
+	}
+
+	// Rename the user in their groups
+	for _, groupMessages := range m.groups {
+		if _, ok := groupMessages.members[username]; ok {
+			delete(groupMessages.members, username)
+			groupMessages.members[newUsername] = struct{}{}
+			renameAuthor(groupMessages.messages, username, newUsername)
+		}
+	}
+
+	// Rename the user in the connections acting as them (they stay online)
+	for connectionID, connectionUsername := range m.connections {
+		if connectionUsername == username {
+			m.connections[connectionID] = newUsername
+		}
+	}
+
+	// Rename the user in their teams
+	for _, members := range m.teams {
+		if _, ok := members[username]; ok {
+			delete(members, username)
+			members[newUsername] = struct{}{}
+		}
+	}
+
+	// Move the user's messages posted today, and the idempotency keys they posted with
+	if count, ok := m.postsToday[username]; ok {
+		delete(m.postsToday, username)
+		m.postsToday[newUsername] = count
+	}
+
+	for i, postedKey := range m.postedKeyList {
+		if !strings.HasPrefix(postedKey, username+"\x00") {
+			continue
+		}
+
+		message := m.postedKeys[postedKey]
+		message.Username = newUsername
+		delete(m.postedKeys, postedKey)
+		m.postedKeyList[i] = newUsername + strings.TrimPrefix(postedKey, username)
+		m.postedKeys[m.postedKeyList[i]] = message
+	}
+}
+
+// renameInList renames a name in a list of names (in place).
+func renameInList(names []string, name string, newName string) {
+	for i, n := range names {
+		if n == name {
+			names[i] = newName
+		}
+	}
+}
+
+// forgetPostedKeys forgets the idempotency keys (see PostMessageOnce) selected by a function of the
+// key and the message posted with it.  The lock must be held.
+func (m *Model) forgetPostedKeys(forget func(postedKey string, message Message) bool) {
+	kept := make([]string, 0, len(m.postedKeyList))
+	for _, postedKey := range m.postedKeyList {
+		if forget(postedKey, m.postedKeys[postedKey]) {
+			delete(m.postedKeys, postedKey)
+		} else {
+			kept = append(kept, postedKey)
+		}
+	}
+	m.postedKeyList = kept
+}
+
+// removeFromList returns a list of names without a name.
+func removeFromList(names []string, name string) []string {
+	kept := make([]string, 0, len(names))
+	for _, n := range names {
+		if n != name {
+			kept = append(kept, n)
+		}
+	}
+
+	return kept
+}
+
+// renameAuthor renames the author of the messages posted by a user (in place).
+func renameAuthor(messages []Message, username string, newUsername string) {
+	for i := range messages {
+		if messages[i].Username == username {
+			messages[i].Username = newUsername
+		}
+	}
+}
+
+// renameMention renames a user in the mentions of messages (removing them when the new name is
+// empty).  The mentions are replaced rather than changed in place, as the history handed out
+// shares them.
+func renameMention(messages []Message, username string, newUsername string) {
+	for i := range messages {
+		if !containsName(messages[i].Mentions, username) {
+			continue
+		}
+
+		mentions := make([]string, 0, len(messages[i].Mentions))
+		for _, mention := range messages[i].Mentions {
+			if mention != username {
+				mentions = append(mentions, mention)
+			} else if newUsername != "" {
+				mentions = append(mentions, newUsername)
+			}
+		}
+		sort.Strings(mentions)
+		if len(mentions) == 0 {
+			mentions = nil
+		}
+		messages[i].Mentions = mentions
+	}
+}
+
+func (m *Model) joinChannel(username string, channelname string) error {
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
+
+	// If the user doesn't exist, do nothing
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+
+	// If the channel doesn't exist, do nothing
+	if _, ok := m.channels[channelname]; !ok {
+		return ErrChannelNotFound
+	}
+
+	// If the user is already a member, do nothing
+	channel := m.channels[channelname]
+	if _, ok := channel.Members[username]; ok {
+		return ErrAlreadyMember
+	}
+
+	if err := m.checkChannelQuota(username); err != nil {
+		return err
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.JoinChannel(username, channelname)
+	}); err != nil {
+		return err
+	}
+
+	// Add the member
+	channel.Members[username] = struct{}{}
+	if m.replaying {
+		m.replayedMembers = true
+	}
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.ChannelChanged(channelname)
+	}
+
+	return nil
+}
+
+// postMessage posts a message (see PostMessage).  The claimed timestamp is the one logged for a
+// replayed (or replicated) message, the one claimed by the client is kept otherwise if it's too
+// far off.  The lock must be held.
+func (m *Model) postMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string, snippetLanguage string, origin Origin, assignTimestamp bool) (Message, error) {
+	if err := m.checkWritable(); err != nil {
+		return Message{}, err
+	}
+
+	// Validate the language of a snippet
+	if snippetLanguage != "" && !validSnippetLanguage(snippetLanguage) {
+		return Message{}, ErrInvalidSnippet
+	}
+
+	// Validate that channel exists
+	if _, ok := m.channels[channelname]; !ok {
+		return Message{}, ErrChannelNotFound
+	}
+
+	// Validate that user exists
+	if _, ok := m.users[username]; !ok {
+		return Message{}, ErrUserNotFound
+	}
+
+	// Only a read-only channel's poster can post to it (imported, replayed and replicated messages
+	// were accepted when first posted)
+	if assignTimestamp && m.enforcingQuotas() && !m.policy.CanPost(channelname, username) {
+		return Message{}, ErrChannelReadOnly
+	}
+
+	// Apply the channel language's filter (replayed messages were filtered when first posted)
+	channel := m.channels[channelname]
+	if filter, ok := m.options.LanguageFilters[channel.Language]; ok && !m.replaying {
+		text = filter(text)
+	}
+
+	// Disregard empty messages
+	if len(text) == 0 {
+		return Message{}, ErrEmptyMessage
+	}
+
+	// Collapse (or reject) a duplicate of the user's last message (imported, replayed and replicated
+	// messages were accepted when first posted)
+	if assignTimestamp && m.enforcingQuotas() {
+		if duplicate, ok := m.findDuplicate(channel, username, text, snippetLanguage, origin); ok {
+			if m.options.RejectDuplicates {
+				return Message{}, ErrDuplicateMessage
+			}
+			return duplicate, nil
+		}
+	}
+
+	if err := m.checkMessageQuota(username, 1); err != nil {
+		return Message{}, err
+	}
+
+	// Assign the timestamp (replayed messages keep the ones logged when they were first posted),
+	// never going back in time within a channel even if the clock steps backwards, and keep the
+	// claimed one if it's too far off
+	if assignTimestamp && !m.replaying && !m.options.TrustTimestamps {
+		timestamp, claimedTimestamp = m.assignTimestamp(timestamp, channel.LastActivity)
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		if snippetLanguage != "" {
+			logger.PostSnippet(channelname, username, timestamp, claimedTimestamp, snippetLanguage, text)
+		} else if origin.System == "" {
+			logger.PostMessage(channelname, username, timestamp, claimedTimestamp, text)
+		} else {
+			logger.PostBridgedMessage(channelname, username, timestamp, claimedTimestamp, text, origin.System, origin.Author)
+		}
+	}); err != nil {
+		return Message{}, err
+	}
+
+	// Create the new message (replaying assigns the same IDs, as the same messages are posted in
+	// the same order), with its mentions (code snippets don't mention anyone)
+	m.lastMessageID++
+	newMessage := Message{
+		ID:               m.lastMessageID,
+		Seq:              uint64(len(channel.Messages)) + 1,
+		Username:         username,
+		Timestamp:        timestamp,
+		ClaimedTimestamp: claimedTimestamp,
+		Text:             text,
+		Origin:           origin,
+		SnippetLanguage:  snippetLanguage,
+	}
+	if snippetLanguage == "" {
+		newMessage.Mentions = m.parseMentions(text, username)
+	}
+
+	// Add the new message to the channel (and count it for the user)
+	m.appendMessage(channel, newMessage)
+
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.ChannelChanged(channelname)
+	}
+
+	// Tell the mentioned users (imported messages were posted long ago)
+	if assignTimestamp {
+		for _, mention := range newMessage.Mentions {
+			m.notifyMention(channel, newMessage, mention)
+		}
+	}
+
+	if m.events != nil {
+		m.events.Emit("message_posted", username, channelname)
+	}
+
+	return newMessage, nil
+}
+
+// postMessageMulti cross-posts a message to several channels (see PostMessageMulti).  Posting the same
+// text to several channels is the point, so the copies aren't checked for duplicates.
+func (m *Model) postMessageMulti(channelnames []string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) ([]Message, error) {
+	if err := m.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	// Validate that there are at least two channels, each only once
+	if len(channelnames) < 2 {
+		return nil, ErrInvalidCrossPost
+	}
+	for i, channelname := range channelnames {
+		if containsName(channelnames[:i], channelname) {
+			return nil, ErrInvalidCrossPost
+		}
+	}
+
+	// Validate that the channels exist
+	channels := make([]*Channel, len(channelnames))
+	var lastActivity time.Time
+	for i, channelname := range channelnames {
+		channel, ok := m.channels[channelname]
+		if !ok {
+			return nil, ErrChannelNotFound
+		}
+		channels[i] = channel
+		if channel.LastActivity.After(lastActivity) {
+			lastActivity = channel.LastActivity
+		}
+	}
+
+	// Validate that user exists
+	if _, ok := m.users[username]; !ok {
+		return nil, ErrUserNotFound
+	}
+
+	// Only a read-only channel's poster can post to it (replayed messages were accepted when first
+	// posted)
+	for _, channelname := range channelnames {
+		if m.enforcingQuotas() && !m.policy.CanPost(channelname, username) {
+			return nil, ErrChannelReadOnly
+		}
+	}
+
+	// Apply every channel language's filter, so the copies have the same text (replayed messages
+	// were filtered when first posted)
+	for _, channel := range channels {
+		if filter, ok := m.options.LanguageFilters[channel.Language]; ok && !m.replaying {
+			text = filter(text)
+		}
+	}
+
+	// Disregard empty messages
+	if len(text) == 0 {
+		return nil, ErrEmptyMessage
+	}
+
+	// Each copy counts towards the user's messages for the day
+	if err := m.checkMessageQuota(username, len(channels)); err != nil {
+		return nil, err
+	}
+
+	// Assign the timestamp once for all of the copies, not going back in time in any of the channels
+	// (replayed messages keep the ones logged)
+	if !m.replaying && !m.options.TrustTimestamps {
+		timestamp, claimedTimestamp = m.assignTimestamp(timestamp, lastActivity)
+	}
+
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.PostMessageMulti(channelnames, username, timestamp, claimedTimestamp, text)
+	}); err != nil {
+		return nil, err
+	}
+
+	// Create the copies, which share the first one's ID as their identity
+	crossPost := m.lastMessageID + 1
+	messages := make([]Message, len(channels))
+	for i, channel := range channels {
+		m.lastMessageID++
+		messages[i] = Message{
+			ID:               m.lastMessageID,
+			Seq:              uint64(len(channel.Messages)) + 1,
+			Username:         username,
+			Timestamp:        timestamp,
+			ClaimedTimestamp: claimedTimestamp,
+			Text:             text,
+			Mentions:         m.parseMentions(text, username),
+			CrossPost:        crossPost,
+		}
+		m.appendMessage(channel, messages[i])
+	}
+
+	// Handle subscriptions (the mentioned users are only told about the first copy they haven't muted
+	// the channel of)
+	notified := make(map[string]struct{})
+	for i, channel := range channels {
+		if m.subsEngine != nil {
+			m.subsEngine.ChannelChanged(channel.Name)
+		}
+
+		for _, mention := range messages[i].Mentions {
+			if user, ok := m.users[mention]; !ok || containsName(user.MutedChannels, channel.Name) {
+				continue
+			}
+			if _, ok := notified[mention]; !ok {
+				notified[mention] = struct{}{}
+				m.notifyMention(channel, messages[i], mention)
+			}
+		}
+
+		if m.events != nil {
+			m.events.Emit("message_posted", username, channel.Name)
+		}
+	}
+
+	return messages, nil
+}
+
+// appendMessage adds a new message to a channel, and counts it for its author.
+func (m *Model) appendMessage(channel *Channel, message Message) {
+	channel.Messages = append(channel.Messages, message)
+	day := message.Timestamp.Format("2006-01-02")
+	if _, ok := channel.postCounts[day]; !ok {
+		channel.postCounts[day] = make(map[string]int)
+	}
+	channel.postCounts[day][message.Username]++
+	m.countPost(message.Username, message.Timestamp)
+	if message.Timestamp.After(channel.LastActivity) {
+		channel.LastActivity = message.Timestamp
+	}
+}
+
+// messageCopies returns the copies of a message: every copy of a cross-posted message (in the order
+// of the channels' names), or else just the message itself.
+func (m *Model) messageCopies(channel *Channel, messageIndex int) []messageCopy {
+	crossPost := channel.Messages[messageIndex].CrossPost
+	if crossPost == 0 {
+		return []messageCopy{{channel: channel, messageIndex: messageIndex}}
+	}
+
+	// The copies were given consecutive IDs from the cross-post's, so each channel's is the first
+	// one from there
+	copies := make([]messageCopy, 0)
+	for _, otherChannel := range m.channels {
+		otherIndex := sort.Search(len(otherChannel.Messages), func(i int) bool { return otherChannel.Messages[i].ID >= crossPost })
+		if otherIndex < len(otherChannel.Messages) && otherChannel.Messages[otherIndex].CrossPost == crossPost {
+			copies = append(copies, messageCopy{channel: otherChannel, messageIndex: otherIndex})
+		}
+	}
+	sort.Slice(copies, func(i, j int) bool { return copies[i].channel.Name < copies[j].channel.Name })
+
+	return copies
+}
+
+// messageCopy is a copy of a message, by its channel and its index in the channel's messages.
+type messageCopy struct {
+	channel      *Channel
+	messageIndex int
+}
+
+// parseMentions returns the users mentioned in the text of a message with @username (sorted, nil if
+// there are none).  Only existing users are mentioned, apart from the author and the built-in user
+// (which everyone shares), and punctuation after a name (e.g. "@user1, hi") isn't part of it unless
+// a user has that name.
+func (m *Model) parseMentions(text string, author string) []string {
+	var mentions []string
+	for _, field := range strings.Fields(text) {
+		if !strings.HasPrefix(field, "@") {
+			continue
+		}
+
+		username := field[1:]
+		if _, ok := m.users[username]; !ok {
+			username = strings.TrimRightFunc(username, unicode.IsPunct)
+		}
+
+		if _, ok := m.users[username]; !ok || username == author || username == m.options.BuiltinUsername || containsName(mentions, username) {
+			continue
+		}
+		mentions = append(mentions, username)
+	}
+	sort.Strings(mentions)
+
+	return mentions
+}
+
+// notifyMention tells a user they were mentioned in a channel message, unless they've blocked the
+// author or muted the channel.
+func (m *Model) notifyMention(channel *Channel, message Message, username string) {
+	user, ok := m.users[username]
+	if m.subsEngine == nil || !ok || containsName(user.BlockedUsers, message.Username) || containsName(user.MutedChannels, channel.Name) {
+		return
+	}
+
+	m.subsEngine.Mentioned(username, channel.Name, message.ID)
+}
+
+// containsName returns whether a list of names contains a name.
+func containsName(names []string, name string) bool {
+	for _, other := range names {
+		if other == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetMentions returns the channel messages that mention a requested user, oldest first (leaving out
+// the messages from the user's blocked users).
+func (m *Model) GetMentions(username string) []Mention {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	mentions := make([]Mention, 0)
+	user, ok := m.users[username]
+	if !ok {
+		return mentions
+	}
+
+	for _, channel := range m.channels {
+		for _, message := range filterMessages(channel.Messages, user) {
+			if containsName(message.Mentions, username) {
+				mentions = append(mentions, Mention{Channelname: channel.Name, Message: message})
+			}
+		}
+	}
+
+	sort.Slice(mentions, func(i, j int) bool { return mentions[i].Message.ID < mentions[j].Message.ID })
+
+	return mentions
+}
+
+// findDuplicate returns a user's last message in a channel if it was posted within the duplicate
+// window with the same text, snippet language and origin.  The built-in user is shared by everyone,
+// so its messages are never duplicates.
+func (m *Model) findDuplicate(channel *Channel, username string, text string, snippetLanguage string, origin Origin) (Message, bool) {
+	if m.options.DuplicateWindow <= 0 || username == m.options.BuiltinUsername {
+		return Message{}, false
+	}
+
+	since := m.now().Add(-m.options.DuplicateWindow)
+	for i := len(channel.Messages) - 1; i >= 0 && channel.Messages[i].Timestamp.After(since); i-- {
+		message := channel.Messages[i]
+		if message.Username != username {
+			continue
+		}
+
+		return message, !message.Deleted && message.Text == text && message.SnippetLanguage == snippetLanguage && message.Origin == origin
+	}
+
+	return Message{}, false
+}
+
+// checkWritable returns ErrReadOnly if the model is read-only (except for the changes being
+// replayed or fed to a read replica, which were accepted when first made).
+func (m *Model) checkWritable() error {
+	if m.IsReadOnly() && !m.replaying && !m.options.TrustTimestamps {
+		return ErrReadOnly
+	}
+
+	return nil
+}
+
+// logAction writes an action to the actions log before its change is made.  If the log couldn't
+// record it (see actions.Recorder), the change mustn't be made: the error is returned instead (as
+// ErrReadOnly if the failure switched the model to read-only).  The lock must be held.
+func (m *Model) logAction(action func(logger actions.Actor)) error {
+	if m.actionsLogger == nil {
+		return nil
+	}
+
+	action(m.actionsLogger)
+	recorder, ok := m.actionsLogger.(actions.Recorder)
+	if !ok || recorder.Err() == nil {
+		return nil
+	}
+
+	if m.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	return recorder.Err()
+}
+
+// enforcingQuotas returns whether the quotas apply to the changes being made.  They don't apply to
+// the changes being replayed (or fed to a read replica), which were accepted when first made.
+func (m *Model) enforcingQuotas() bool {
+	return !m.replaying && !m.options.TrustTimestamps
+}
+
+// checkUserQuota returns ErrUserQuota if there are already as many users as the quota allows.
+func (m *Model) checkUserQuota() error {
+	if m.options.MaxUsers > 0 && m.enforcingQuotas() && len(m.users) >= m.options.MaxUsers {
+		return ErrUserQuota
+	}
+
+	return nil
+}
+
+// checkChannelQuota returns ErrChannelQuota if a user is already a member of as many channels as
+// the quota allows.
+func (m *Model) checkChannelQuota(username string) error {
+	if m.options.MaxChannelsPerUser <= 0 || !m.enforcingQuotas() {
+		return nil
+	}
+
+	numChannels := 0
+	for _, channel := range m.channels {
+		if _, ok := channel.Members[username]; ok {
+			numChannels++
+		}
+	}
+
+	if numChannels >= m.options.MaxChannelsPerUser {
+		return ErrChannelQuota
+	}
+
+	return nil
+}
+
+// checkMessageQuota returns ErrMessageQuota if posting a number of messages would take a user over
+// the number of messages the quota allows them today.
+func (m *Model) checkMessageQuota(username string, numMessages int) error {
+	if m.options.MaxMessagesPerDay <= 0 || !m.enforcingQuotas() {
+		return nil
+	}
+
+	if m.postsDay == m.now().Format("2006-01-02") && m.postsToday[username]+numMessages > m.options.MaxMessagesPerDay {
+		return ErrMessageQuota
+	}
+
+	return nil
+}
+
+// countPost counts a message posted by a user towards their messages for the day it was posted on
+// (only the latest day's are kept, which replaying the log rebuilds).
+func (m *Model) countPost(username string, timestamp time.Time) {
+	day := timestamp.Format("2006-01-02")
+	if day < m.postsDay {
+		return
+	}
+
+	if day > m.postsDay {
+		m.postsDay = day
+		m.postsToday = make(map[string]int)
+	}
+	m.postsToday[username]++
+}
+
+// assignTimestamp returns the model's time for a message posted after another one (never going
+// back in time, even if the clock steps backwards), along with the time claimed by the client if
+// it's too far off (zero otherwise).
+func (m *Model) assignTimestamp(claimedTimestamp time.Time, lastTimestamp time.Time) (time.Time, time.Time) {
+	assignedTimestamp := m.now()
+	if assignedTimestamp.Before(lastTimestamp) {
+		assignedTimestamp = lastTimestamp
+	}
+
+	if claimedTimestamp.IsZero() || (claimedTimestamp.Sub(assignedTimestamp) <= MaxClockSkew && assignedTimestamp.Sub(claimedTimestamp) <= MaxClockSkew) {
+		claimedTimestamp = time.Time{}
+	}
+
+	return assignedTimestamp, claimedTimestamp
+}
+
+// Batch applies the mutations in order as a single change: either all of them are applied, or
+// (if any of them would be rejected, e.g. creating a user that already exists or joining a channel
+// that doesn't) none of them are and the index of the first one that would be rejected is returned
+// along with its error.  The model is locked once for the whole batch, so no other change can be
+// made in between.  The batch is tried on a copy of the model first, so large batches are cheaper
+// than many small ones.  The batch's actions are logged as a single Batch action (replayed as a
+// unit), so if the log fails nothing is changed.
+func (m *Model) Batch(mutations []Mutation) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	// Try the mutations on a copy, keeping the actions they log (so nothing is changed or logged if
+	// one of them is rejected) and the times they're made at
+	scratch := m.copyState()
+	var batch *actions.Fanout
+	if m.actionsLogger != nil {
+		batch = actions.NewFanout(m.actionsLogger)
+		batch.BeginBatch()
+		scratch.actionsLogger = batch
+	}
+
+	var batchTimes []time.Time
+	scratch.options.Clock = func() time.Time {
+		now := m.options.Clock()
+		batchTimes = append(batchTimes, now)
+		return now
+	}
+
+	for i, mutation := range mutations {
+		err := scratch.applyMutation(mutation)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	// Log the actions as a single Batch action before making the changes
+	if batch != nil {
+		if err := m.logAction(func(actions.Actor) {
+			batch.EndBatch()
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	// Make the same changes (at the same times, so they match the logged actions) without logging
+	// them again
+	actionsLogger := m.actionsLogger
+	m.actionsLogger = nil
+	m.batchTimes = batchTimes
+	defer func() {
+		m.actionsLogger = actionsLogger
+		m.batchTimes = nil
+	}()
+
+	for _, mutation := range mutations {
+		m.applyMutation(mutation)
+	}
+
+	return 0, nil
+}
+
+// now returns the current time according to the model's clock, or the next of the times a batch's
+// changes were tried at while they're being made (see Batch).  The lock must be held.
+func (m *Model) now() time.Time {
+	if len(m.batchTimes) > 0 {
+		now := m.batchTimes[0]
+		m.batchTimes = m.batchTimes[1:]
+		return now
+	}
+
+	return m.options.Clock()
+}
+
+// applyMutation applies a single mutation, or returns the error it was rejected with.  The lock
+// must be held.
+func (m *Model) applyMutation(mutation Mutation) error {
+	switch mutation.Type {
+	case "CreateUser":
+		return m.createUser(mutation.Username)
+	case "CreateVirtualUser":
+		return m.createVirtualUser(mutation.OwnerUsername, mutation.Username)
+	case "DeleteUser":
+		return m.deleteUser(mutation.Username)
+	case "BlockUser":
+		return m.blockUser(mutation.Username, mutation.OtherUsername)
+	case "UnblockUser":
+		return m.unblockUser(mutation.Username, mutation.OtherUsername)
+	case "MuteChannel":
+		return m.muteChannel(mutation.Username, mutation.Channelname)
+	case "UnmuteChannel":
+		return m.unmuteChannel(mutation.Username, mutation.Channelname)
+	case "CreateChannel":
+		return m.createChannel(mutation.Channelname)
+	case "DeleteChannel":
+		return m.deleteChannel(mutation.Channelname)
+	case "JoinChannel":
+		return m.joinChannel(mutation.Username, mutation.Channelname)
+	case "LeaveChannel":
+		return m.leaveChannel(mutation.Username, mutation.Channelname)
+	case "SetChannelTopic":
+		return m.setChannelTopic(mutation.Channelname, mutation.Topic)
+	case "SetChannelRules":
+		return m.setChannelRules(mutation.Channelname, mutation.Language, mutation.Rules)
+	case "PostMessage":
+		_, err := m.postMessage(mutation.Channelname, mutation.Username, time.Time{}, time.Time{}, mutation.Text, "", Origin{}, true)
+		return err
+	case "PostSnippet":
+		_, err := m.postSnippet(mutation.Channelname, mutation.Username, time.Time{}, time.Time{}, mutation.Language, mutation.Text)
+		return err
+	case "PutPluginData":
+		return m.putPluginData(mutation.Namespace, mutation.Key, mutation.Value)
+	}
+
+	return ErrUnknownMutation
+}
+
+// copyState returns a model with a copy of the state (and no logging, subscriptions or events)
+// that can be changed without affecting this one.  The lock must be held.
+func (m *Model) copyState() *Model {
+	model := Model{
+		options:       m.options,
+		policy:        m.policy,
+		replaying:     m.replaying,
+		readOnly:      atomic.LoadInt32(&m.readOnly),
+		users:         make(map[string]*User),
+		channels:      make(map[string]*Channel),
+		lastMessageID: m.lastMessageID,
+		conversations: make(map[string]*conversation),
+		lastGroupID:   m.lastGroupID,
+		groups:        make(map[uint64]*group),
+		teams:         make(map[string]map[string]struct{}),
+		lastEventID:   m.lastEventID,
+		postedKeys:    make(map[string]Message),
+		postsDay:      m.postsDay,
+		postsToday:    make(map[string]int),
+		pluginData:    make(map[string]map[string]string),
+		deleted:       make(map[string]deletedChannel),
+		names:         m.names.Copy(),
+	}
+
+	for username, user := range m.users {
+		userCopy := *user
+		userCopy.BlockedUsers = append([]string(nil), user.BlockedUsers...)
+		userCopy.MutedChannels = append([]string(nil), user.MutedChannels...)
+		model.users[username] = &userCopy
+	}
+
+	for channelname, channel := range m.channels {
+		channelCopy := *channel
+
+		// Limiting the capacity makes appending to the copy's messages reallocate them
+		channelCopy.Messages = channel.Messages[:len(channel.Messages):len(channel.Messages)]
+		channelCopy.Members = make(map[string]struct{})
+		for member := range channel.Members {
+			channelCopy.Members[member] = struct{}{}
+		}
+
+		channelCopy.postCounts = make(map[string]map[string]int)
+		for day, dayCounts := range channel.postCounts {
+			channelCopy.postCounts[day] = make(map[string]int)
+			for username, count := range dayCounts {
+				channelCopy.postCounts[day][username] = count
+			}
+		}
+
+		channelCopy.events = make(map[uint64]*calendarEvent)
+		for eventID, event := range channel.events {
+			eventCopy := *event
+			eventCopy.going = make(map[string]struct{})
+			for username := range event.going {
+				eventCopy.going[username] = struct{}{}
+			}
+			channelCopy.events[eventID] = &eventCopy
+		}
+
+		channelCopy.readMarkers = make(map[string]uint64)
+		for username, messageID := range channel.readMarkers {
+			channelCopy.readMarkers[username] = messageID
+		}
+
+		channelCopy.stars = make(map[string][]uint64)
+		for username, stars := range channel.stars {
+			channelCopy.stars[username] = append([]uint64(nil), stars...)
+		}
+		model.channels[channelname] = &channelCopy
+	}
+
+	for key, directMessages := range m.conversations {
+		conversationCopy := *directMessages
+		conversationCopy.messages = directMessages.messages[:len(directMessages.messages):len(directMessages.messages)]
+		model.conversations[key] = &conversationCopy
+	}
+
+	for groupID, groupMessages := range m.groups {
+		groupCopy := group{
+			members:  make(map[string]struct{}),
+			messages: groupMessages.messages[:len(groupMessages.messages):len(groupMessages.messages)],
+		}
+		for member := range groupMessages.members {
+			groupCopy.members[member] = struct{}{}
+		}
+		model.groups[groupID] = &groupCopy
 	}
 
-	// Add the new user
-	newUser := User{
-		Name:         username,
-		BlockedUsers: make([]string, 0),
+	for teamname, members := range m.teams {
+		model.teams[teamname] = make(map[string]struct{})
+		for member := range members {
+			model.teams[teamname][member] = struct{}{}
+		}
 	}
-	m.users[newUser.Name] = &newUser
 
-	// Handle logging and subscriptions
-	if m.actionsLogger != nil {
-		m.actionsLogger.CreateUser(username)
+	for username, count := range m.postsToday {
+		model.postsToday[username] = count
 	}
 
-	if m.subsEngine != nil {
-		m.subsEngine.UsersChanged()
+	for channelname, deleted := range m.deleted {
+		model.deleted[channelname] = deleted
 	}
+
+	return &model
 }
 
-// DeleteUser deletes an existing user from the model.
-func (m *Model) DeleteUser(username string) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// snapshot returns a snapshot of the state (with everything sorted, so the same state always gives
+// the same snapshot).  The lock must be held.
+func (m *Model) snapshot() *actions.Snapshot {
+	snapshot := actions.Snapshot{
+		Users:           make([]actions.SnapshotUser, 0, len(m.users)),
+		Channels:        make([]actions.SnapshotChannel, 0, len(m.channels)),
+		DeletedChannels: make([]actions.SnapshotDeletedChannel, 0, len(m.deleted)),
+		Conversations:   make([]actions.SnapshotConversation, 0, len(m.conversations)),
+		Groups:          make([]actions.SnapshotGroup, 0, len(m.groups)),
+		Teams:           make([]actions.SnapshotTeam, 0, len(m.teams)),
+		PluginData:      make(map[string]map[string]string),
+		LastMessageID:   m.lastMessageID,
+		LastGroupID:     m.lastGroupID,
+		LastEventID:     m.lastEventID,
+		PostsDay:        m.postsDay,
+		PostsToday:      make(map[string]int),
+	}
 
-	// If the user doesn't exist, do nothing
-	if _, ok := m.users[username]; !ok {
-		return
+	usernames := make([]string, 0, len(m.users))
+	for username := range m.users {
+		usernames = append(usernames, username)
 	}
+	sort.Strings(usernames)
 
-	// Disallow deleting of Anonymous user
-	if username == "Anonymous" {
-		return
+	for _, username := range usernames {
+		user := m.users[username]
+		snapshot.Users = append(snapshot.Users, actions.SnapshotUser{
+			Name:          user.Name,
+			Owner:         user.Owner,
+			BlockedUsers:  append(make([]string, 0, len(user.BlockedUsers)), user.BlockedUsers...),
+			MutedChannels: append(make([]string, 0, len(user.MutedChannels)), user.MutedChannels...),
+			DisplayName:   user.Profile.DisplayName,
+			Bio:           user.Profile.Bio,
+			Pronouns:      user.Profile.Pronouns,
+			StatusText:    user.Status.Text,
+			Away:          user.Status.Away,
+		})
 	}
 
-	// Remove the user
-	delete(m.users, username)
+	channelnames := make([]string, 0, len(m.channels))
+	for channelname := range m.channels {
+		channelnames = append(channelnames, channelname)
+	}
+	sort.Strings(channelnames)
 
-	// Remove the user from all other users' blockedUsers list
-	for _, user := range m.users {
-		removalIndex := -1
-		for i, blockedUsername := range user.BlockedUsers {
-			if blockedUsername == username {
-				removalIndex = i
-				break
-			}
-		}
+	for _, channelname := range channelnames {
+		snapshot.Channels = append(snapshot.Channels, snapshotChannel(m.channels[channelname]))
+	}
 
-		if removalIndex != -1 {
-			user.BlockedUsers = append(user.BlockedUsers[:removalIndex], user.BlockedUsers[removalIndex+1:]...)
-		}
+	deletedChannelnames := make([]string, 0, len(m.deleted))
+	for channelname := range m.deleted {
+		deletedChannelnames = append(deletedChannelnames, channelname)
 	}
+	sort.Strings(deletedChannelnames)
 
-	// Handle logging and subscriptions
-	if m.actionsLogger != nil {
-		m.actionsLogger.DeleteUser(username)
+	for _, channelname := range deletedChannelnames {
+		deleted := m.deleted[channelname]
+		snapshot.DeletedChannels = append(snapshot.DeletedChannels, actions.SnapshotDeletedChannel{
+			Channel:   snapshotChannel(deleted.channel),
+			MutedBy:   append(make([]string, 0, len(deleted.mutedBy)), deleted.mutedBy...),
+			DeletedAt: deleted.deletedAt,
+		})
 	}
 
-	if m.subsEngine != nil {
-		m.subsEngine.UsersChanged()
+	keys := make([]string, 0, len(m.conversations))
+	for key := range m.conversations {
+		keys = append(keys, key)
 	}
-}
+	sort.Strings(keys)
 
-// GetUserInfo returns information about a requested user.
-func (m *Model) GetUserInfo(username string) User {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	for _, key := range keys {
+		directMessages := m.conversations[key]
+		snapshot.Conversations = append(snapshot.Conversations, actions.SnapshotConversation{
+			Usernames: []string{directMessages.usernames[0], directMessages.usernames[1]},
+			Messages:  snapshotMessages(directMessages.messages),
+		})
+	}
 
-	// If the user doesn't exist, do nothing
-	if _, ok := m.users[username]; !ok {
-		return User{}
+	groupIDs := make([]uint64, 0, len(m.groups))
+	for groupID := range m.groups {
+		groupIDs = append(groupIDs, groupID)
 	}
+	sort.Slice(groupIDs, func(i, j int) bool { return groupIDs[i] < groupIDs[j] })
 
-	// Copy and return the user
-	user := m.users[username]
-	userInfo := User{
-		Name:         user.Name,
-		BlockedUsers: make([]string, len(user.BlockedUsers)),
+	for _, groupID := range groupIDs {
+		groupMessages := m.groups[groupID]
+		snapshot.Groups = append(snapshot.Groups, actions.SnapshotGroup{
+			ID:       groupID,
+			Members:  sortedNames(groupMessages.members),
+			Messages: snapshotMessages(groupMessages.messages),
+		})
 	}
-	copy(userInfo.BlockedUsers, user.BlockedUsers)
 
-	return userInfo
-}
+	teamnames := make([]string, 0, len(m.teams))
+	for teamname := range m.teams {
+		teamnames = append(teamnames, teamname)
+	}
+	sort.Strings(teamnames)
 
-// GetUsers returns a list of all users.
-func (m *Model) GetUsers() map[string]struct{} {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	for _, teamname := range teamnames {
+		snapshot.Teams = append(snapshot.Teams, actions.SnapshotTeam{
+			Name:    teamname,
+			Members: sortedNames(m.teams[teamname]),
+		})
+	}
 
-	users := make(map[string]struct{})
-	for _, user := range m.users {
-		users[user.Name] = struct{}{}
+	for namespace, values := range m.pluginData {
+		snapshot.PluginData[namespace] = make(map[string]string)
+		for key, value := range values {
+			snapshot.PluginData[namespace][key] = value
+		}
 	}
 
-	return users
+	for username, count := range m.postsToday {
+		snapshot.PostsToday[username] = count
+	}
+
+	return &snapshot
 }
 
-// BlockUser blocks a user for a requested user.
-func (m *Model) BlockUser(username string, usernameToBlock string) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// restoreSnapshot replaces the state with a snapshot (a compacted log starts with one).  The lock
+// must be held.
+func (m *Model) restoreSnapshot(snapshot *actions.Snapshot) error {
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.RestoreSnapshot(snapshot)
+	}); err != nil {
+		return err
+	}
 
-	// If the user doesn't exist, do nothing
-	if _, ok := m.users[username]; !ok {
-		return
+	if m.replaying {
+		m.replayedMembers = true
 	}
 
-	// If the user to block doesn't exist, do nothing
-	if _, ok := m.users[usernameToBlock]; !ok {
-		return
+	m.users = make(map[string]*User)
+	m.channels = make(map[string]*Channel)
+	m.names = fuzzy.NewIndex()
+	m.conversations = make(map[string]*conversation)
+	m.groups = make(map[uint64]*group)
+	m.teams = make(map[string]map[string]struct{})
+	m.pluginData = make(map[string]map[string]string)
+	m.deleted = make(map[string]deletedChannel)
+	m.lastMessageID = snapshot.LastMessageID
+	m.lastGroupID = snapshot.LastGroupID
+	m.lastEventID = snapshot.LastEventID
+	m.postsDay = snapshot.PostsDay
+	m.postsToday = make(map[string]int)
+
+	for _, snapshotUser := range snapshot.Users {
+		m.users[snapshotUser.Name] = &User{
+			Name:          snapshotUser.Name,
+			BlockedUsers:  append(make([]string, 0, len(snapshotUser.BlockedUsers)), snapshotUser.BlockedUsers...),
+			MutedChannels: append(make([]string, 0, len(snapshotUser.MutedChannels)), snapshotUser.MutedChannels...),
+			Owner:         snapshotUser.Owner,
+			Profile:       Profile{DisplayName: snapshotUser.DisplayName, Bio: snapshotUser.Bio, Pronouns: snapshotUser.Pronouns},
+			Status:        Status{Text: snapshotUser.StatusText, Away: snapshotUser.Away},
+		}
+		m.names.Add(FuzzyKindUser, snapshotUser.Name)
 	}
 
-	// Don't allow the anonymous user to block
-	if username == "Anonymous" {
-		return
+	for _, snapshotChannel := range snapshot.Channels {
+		m.channels[snapshotChannel.Name] = restoreChannel(snapshotChannel)
+		m.names.Add(FuzzyKindChannel, snapshotChannel.Name)
 	}
 
-	// Don't allow blocking yourself
-	if username == usernameToBlock {
-		return
+	for _, snapshotDeleted := range snapshot.DeletedChannels {
+		m.deleted[snapshotDeleted.Channel.Name] = deletedChannel{
+			channel:   restoreChannel(snapshotDeleted.Channel),
+			mutedBy:   append(make([]string, 0, len(snapshotDeleted.MutedBy)), snapshotDeleted.MutedBy...),
+			deletedAt: snapshotDeleted.DeletedAt,
+		}
 	}
 
-	// Look through the user's blockedUsers list and add the username if new
-	user := m.users[username]
+	for _, snapshotConversation := range snapshot.Conversations {
+		if len(snapshotConversation.Usernames) != 2 {
+			continue
+		}
 
-	found := false
-	for _, blockedUser := range user.BlockedUsers {
-		if blockedUser == usernameToBlock {
-			found = true
-			break
+		username, otherUsername := snapshotConversation.Usernames[0], snapshotConversation.Usernames[1]
+		m.conversations[conversationKey(username, otherUsername)] = &conversation{
+			usernames: [2]string{username, otherUsername},
+			messages:  restoreMessages(snapshotConversation.Messages),
 		}
 	}
 
-	if !found {
-		user.BlockedUsers = append(user.BlockedUsers, usernameToBlock)
-	}
+	for _, snapshotGroup := range snapshot.Groups {
+		groupMessages := group{
+			members:  make(map[string]struct{}),
+			messages: restoreMessages(snapshotGroup.Messages),
+		}
 
-	// Handle logging and subscriptions
-	if m.actionsLogger != nil {
-		m.actionsLogger.BlockUser(username, usernameToBlock)
+		for _, member := range snapshotGroup.Members {
+			groupMessages.members[member] = struct{}{}
+		}
+		m.groups[snapshotGroup.ID] = &groupMessages
 	}
 
-	if m.subsEngine != nil {
-		m.subsEngine.UserChanged(username)
+	for _, snapshotTeam := range snapshot.Teams {
+		m.teams[snapshotTeam.Name] = make(map[string]struct{})
+		for _, member := range snapshotTeam.Members {
+			m.teams[snapshotTeam.Name][member] = struct{}{}
+		}
 	}
-}
 
-// UnblockUser unblocks a user for a requested user.
-func (m *Model) UnblockUser(username string, usernameToUnblock string) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	for namespace, values := range snapshot.PluginData {
+		m.pluginData[namespace] = make(map[string]string)
+		for key, value := range values {
+			m.pluginData[namespace][key] = value
+		}
+	}
 
-	// If the user doesn't exist, do nothing
-	if _, ok := m.users[username]; !ok {
-		return
+	for username, count := range snapshot.PostsToday {
+		m.postsToday[username] = count
 	}
 
-	// If the user to block doesn't exist, do nothing
-	if _, ok := m.users[usernameToUnblock]; !ok {
-		return
+	// Handle subscriptions
+	if m.subsEngine != nil {
+		m.subsEngine.UsersChanged()
+		m.subsEngine.ChannelsChanged()
 	}
 
-	// Look through the user's blockedUsers list and add the username if new
-	user := m.users[username]
+	return nil
+}
 
-	foundIndex := -1
-	for i, blockedUser := range user.BlockedUsers {
-		if blockedUser == usernameToUnblock {
-			foundIndex = i
-			break
-		}
+// snapshotChannel copies a channel into a snapshot.
+func snapshotChannel(channel *Channel) actions.SnapshotChannel {
+	snapshotChannel := actions.SnapshotChannel{
+		Name:         channel.Name,
+		Topic:        channel.Topic,
+		Language:     channel.Language,
+		Rules:        channel.Rules,
+		Members:      sortedNames(channel.Members),
+		LastActivity: channel.LastActivity,
+		Messages:     snapshotMessages(channel.Messages),
+		PostCounts:   make(map[string]map[string]int),
 	}
 
-	if foundIndex != -1 {
-		user.BlockedUsers = append(user.BlockedUsers[:foundIndex], user.BlockedUsers[foundIndex+1:]...)
+	for day, dayCounts := range channel.postCounts {
+		snapshotChannel.PostCounts[day] = make(map[string]int)
+		for username, count := range dayCounts {
+			snapshotChannel.PostCounts[day][username] = count
+		}
 	}
 
-	// Handle logging and subscriptions
-	if m.actionsLogger != nil {
-		m.actionsLogger.UnblockUser(username, usernameToUnblock)
+	eventIDs := make([]uint64, 0, len(channel.events))
+	for eventID := range channel.events {
+		eventIDs = append(eventIDs, eventID)
 	}
+	sort.Slice(eventIDs, func(i, j int) bool { return eventIDs[i] < eventIDs[j] })
 
-	if m.subsEngine != nil {
-		m.subsEngine.UserChanged(username)
+	for _, eventID := range eventIDs {
+		event := channel.events[eventID]
+		snapshotChannel.Events = append(snapshotChannel.Events, actions.SnapshotEvent{
+			ID:       eventID,
+			Creator:  event.creator,
+			Start:    event.start,
+			Title:    event.title,
+			Going:    sortedNames(event.going),
+			Reminded: event.reminded,
+		})
 	}
-}
 
-// CreateChannel creates a new channel in the model.
-func (m *Model) CreateChannel(channelname string) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	if len(channel.readMarkers) > 0 {
+		snapshotChannel.ReadMarkers = make(map[string]uint64)
+		for username, messageID := range channel.readMarkers {
+			snapshotChannel.ReadMarkers[username] = messageID
+		}
+	}
 
-	// If the channel already exists, do nothing
-	if _, ok := m.channels[channelname]; ok {
-		return
+	if len(channel.stars) > 0 {
+		snapshotChannel.Stars = make(map[string][]uint64)
+		for username, stars := range channel.stars {
+			snapshotChannel.Stars[username] = append([]uint64(nil), stars...)
+		}
 	}
 
-	// Disallow adding of empty channel
-	if channelname == "" {
-		return
+	if channel.notes.Version > 0 {
+		snapshotChannel.Notes = &actions.SnapshotNotes{
+			Text:    channel.notes.Text,
+			Version: channel.notes.Version,
+			Editor:  channel.notes.Editor,
+			Edited:  channel.notes.Edited,
+		}
 	}
 
-	// Disallow adding of channel with space in channelname
-	if strings.Contains(channelname, " ") {
-		return
+	return snapshotChannel
+}
+
+// restoreChannel copies a channel from a snapshot.
+func restoreChannel(snapshotChannel actions.SnapshotChannel) *Channel {
+	channel := Channel{
+		Name:         snapshotChannel.Name,
+		Topic:        snapshotChannel.Topic,
+		Language:     snapshotChannel.Language,
+		Rules:        snapshotChannel.Rules,
+		Messages:     restoreMessages(snapshotChannel.Messages),
+		Members:      make(map[string]struct{}),
+		LastActivity: snapshotChannel.LastActivity,
+		postCounts:   make(map[string]map[string]int),
 	}
 
-	// Add the channel
-	newChannel := Channel{
-		Name:     channelname,
-		Messages: make([]Message, 0),
+	for _, member := range snapshotChannel.Members {
+		channel.Members[member] = struct{}{}
 	}
-	m.channels[channelname] = &newChannel
 
-	// Handle logging and subscriptions
-	if m.actionsLogger != nil {
-		m.actionsLogger.CreateChannel(channelname)
+	for day, dayCounts := range snapshotChannel.PostCounts {
+		channel.postCounts[day] = make(map[string]int)
+		for username, count := range dayCounts {
+			channel.postCounts[day][username] = count
+		}
 	}
 
-	if m.subsEngine != nil {
-		m.subsEngine.ChannelsChanged()
+	channel.events = make(map[uint64]*calendarEvent)
+	for _, snapshotEvent := range snapshotChannel.Events {
+		event := calendarEvent{
+			creator:  snapshotEvent.Creator,
+			start:    snapshotEvent.Start,
+			title:    snapshotEvent.Title,
+			going:    make(map[string]struct{}),
+			reminded: snapshotEvent.Reminded,
+		}
+		for _, username := range snapshotEvent.Going {
+			event.going[username] = struct{}{}
+		}
+		channel.events[snapshotEvent.ID] = &event
 	}
-}
 
-// DeleteChannel deletes an existing channel from the model.
-func (m *Model) DeleteChannel(channelname string) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	channel.readMarkers = make(map[string]uint64)
+	for username, messageID := range snapshotChannel.ReadMarkers {
+		channel.readMarkers[username] = messageID
+	}
 
-	// If the channel doesn't exist, do nothing
-	if _, ok := m.channels[channelname]; !ok {
-		return
+	channel.stars = make(map[string][]uint64)
+	for username, stars := range snapshotChannel.Stars {
+		channel.stars[username] = append([]uint64(nil), stars...)
 	}
 
-	// Disallow deleting of the General channel
-	if channelname == "General" {
-		return
+	if snapshotChannel.Notes != nil {
+		channel.notes = ChannelNotes{
+			Text:    snapshotChannel.Notes.Text,
+			Version: snapshotChannel.Notes.Version,
+			Editor:  snapshotChannel.Notes.Editor,
+			Edited:  snapshotChannel.Notes.Edited,
+		}
 	}
 
-	// Remove the channel
-	delete(m.channels, channelname)
+	return &channel
+}
 
-	// Handle logging and subscriptions
-	if m.actionsLogger != nil {
-		m.actionsLogger.DeleteChannel(channelname)
+// snapshotMessages copies messages into a snapshot.
+func snapshotMessages(messages []Message) []actions.SnapshotMessage {
+	snapshotMessages := make([]actions.SnapshotMessage, 0, len(messages))
+	for _, message := range messages {
+		snapshotMessages = append(snapshotMessages, actions.SnapshotMessage{
+			ID:               message.ID,
+			Username:         message.Username,
+			Timestamp:        message.Timestamp,
+			ClaimedTimestamp: message.ClaimedTimestamp,
+			Edited:           message.Edited,
+			Deleted:          message.Deleted,
+			Text:             message.Text,
+			OriginSystem:     message.Origin.System,
+			OriginAuthor:     message.Origin.Author,
+			SnippetLanguage:  message.SnippetLanguage,
+			Attachments:      snapshotAttachments(message.Attachments),
+			Mentions:         append([]string(nil), message.Mentions...),
+			CrossPost:        message.CrossPost,
+		})
 	}
 
-	if m.subsEngine != nil {
-		m.subsEngine.ChannelsChanged()
-	}
+	return snapshotMessages
 }
 
-// GetChannelInfo returns information about a requested channel.
-func (m *Model) GetChannelInfo(channelname string) ChannelInfo {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// restoreMessages copies messages from a snapshot (numbering them in order).
+func restoreMessages(snapshotMessages []actions.SnapshotMessage) []Message {
+	messages := make([]Message, 0, len(snapshotMessages))
+	for i, snapshotMessage := range snapshotMessages {
+		messages = append(messages, Message{
+			ID:               snapshotMessage.ID,
+			Seq:              uint64(i) + 1,
+			Username:         snapshotMessage.Username,
+			Timestamp:        snapshotMessage.Timestamp,
+			ClaimedTimestamp: snapshotMessage.ClaimedTimestamp,
+			Edited:           snapshotMessage.Edited,
+			Deleted:          snapshotMessage.Deleted,
+			Text:             snapshotMessage.Text,
+			Origin:           Origin{System: snapshotMessage.OriginSystem, Author: snapshotMessage.OriginAuthor},
+			SnippetLanguage:  snapshotMessage.SnippetLanguage,
+			Mentions:         append([]string(nil), snapshotMessage.Mentions...),
+			CrossPost:        snapshotMessage.CrossPost,
+		})
 
-	// If the user doesn't exist, do nothing
-	if _, ok := m.channels[channelname]; !ok {
-		return ChannelInfo{}
+		for _, attachment := range snapshotMessage.Attachments {
+			messages[i].Attachments = append(messages[i].Attachments, Attachment(attachment))
+		}
 	}
 
-	// Copy and return the channel info
-	channel := m.channels[channelname]
-	channelInfo := ChannelInfo{
-		Name:        channel.Name,
-		NumMessages: len(channel.Messages),
+	return messages
+}
+
+// snapshotAttachments copies a message's attachments for a snapshot (nil if there are none, so
+// they're left out of snapshots of messages without any).
+func snapshotAttachments(attachments []Attachment) []actions.Attachment {
+	var snapshotAttachments []actions.Attachment
+	for _, attachment := range attachments {
+		snapshotAttachments = append(snapshotAttachments, actions.Attachment(attachment))
 	}
 
-	return channelInfo
+	return snapshotAttachments
 }
 
-// GetChannelHistory returns message history for a requested channel
-// filtered for a requested user up to some requested number of messages
-// (-1 for all).
-func (m *Model) GetChannelHistory(channelname string, username string, numMessages int) []Message {
+// PutPluginData stores a value for a plugin (or bot) under a key in its namespace, persisted with
+// the rest of the model's state.  An empty value deletes the key.
+func (m *Model) PutPluginData(namespace string, key string, value string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	// Validate that channel exists
-	if _, ok := m.channels[channelname]; !ok {
-		return make([]Message, 0)
-	}
+	m.putPluginData(namespace, key, value)
+}
 
-	// Validate that user exists
-	if _, ok := m.users[username]; !ok {
-		return make([]Message, 0)
+// putPluginData stores a value for a plugin, or returns the error it was rejected with (an empty
+// namespace or key is ErrInvalidName).  The lock must be held.
+func (m *Model) putPluginData(namespace string, key string, value string) error {
+	// Disallow empty namespaces and keys (and any changes while read-only)
+	if namespace == "" || key == "" {
+		return ErrInvalidName
 	}
 
-	// Figure out which message to start copying from
-	channel := m.channels[channelname]
-	user := m.users[username]
+	if err := m.checkWritable(); err != nil {
+		return err
+	}
 
-	startingMessageIndex := len(channel.Messages) - numMessages
-	if startingMessageIndex < 0 {
-		startingMessageIndex = 0
+	// If there's no value to delete, do nothing
+	if _, ok := m.pluginData[namespace][key]; !ok && value == "" {
+		return nil
 	}
 
-	// Copy all messages when numMessages is -1
-	if numMessages == -1 {
-		startingMessageIndex = 0
+	// Log the change before making it (leaving it out if it can't be logged)
+	if err := m.logAction(func(logger actions.Actor) {
+		logger.PutPluginData(namespace, key, value)
+	}); err != nil {
+		return err
 	}
 
-	// Copy messages
-	messages := make([]Message, 0)
-	for i := startingMessageIndex; i < len(channel.Messages); i++ {
-		fromBlockedUser := false
-		for _, blockedUser := range user.BlockedUsers {
-			if channel.Messages[i].Username == blockedUser {
-				fromBlockedUser = true
-				break
-			}
+	// Store the value (removing the namespace once it's empty)
+	if value == "" {
+		delete(m.pluginData[namespace], key)
+		if len(m.pluginData[namespace]) == 0 {
+			delete(m.pluginData, namespace)
 		}
-
-		if !fromBlockedUser {
-			messages = append(messages, channel.Messages[i])
+	} else {
+		if _, ok := m.pluginData[namespace]; !ok {
+			m.pluginData[namespace] = make(map[string]string)
 		}
+
+		m.pluginData[namespace][key] = value
 	}
 
-	return messages
+	return nil
 }
 
-// GetChannels returns a list of all channels.
-func (m *Model) GetChannels() map[string]struct{} {
+// GetPluginData returns the value stored for a plugin (or bot) under a key in its namespace, or
+// false if there isn't one.
+func (m *Model) GetPluginData(namespace string, key string) (string, bool) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	channels := make(map[string]struct{})
-	for _, channel := range m.channels {
-		channels[channel.Name] = struct{}{}
+	value, ok := m.pluginData[namespace][key]
+	return value, ok
+}
+
+// GetPluginNamespaces returns the namespaces that have plugin data.
+func (m *Model) GetPluginNamespaces() map[string]struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	namespaces := make(map[string]struct{})
+	for namespace := range m.pluginData {
+		namespaces[namespace] = struct{}{}
 	}
 
-	return channels
+	return namespaces
 }
 
-// PostMessage posts a message to a requested channel for a requested user.
-func (m *Model) PostMessage(channelname string, username string, timestamp time.Time, text string) {
+// GetPluginKeys returns the keys that have values in a plugin namespace.
+func (m *Model) GetPluginKeys(namespace string) map[string]struct{} {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	// Validate that channel exists
-	if _, ok := m.channels[channelname]; !ok {
-		return
+	keys := make(map[string]struct{})
+	for key := range m.pluginData[namespace] {
+		keys[key] = struct{}{}
 	}
 
-	// Validate that user exists
-	if _, ok := m.users[username]; !ok {
-		return
-	}
+	return keys
+}
 
-	// Disregard empty messages
-	if len(text) == 0 {
-		return
+// PluginStore returns a store for the plugin data of a namespace, so a plugin doesn't need to
+// pass its namespace around (or need files or databases of its own).
+func (m *Model) PluginStore(namespace string) *PluginStore {
+	store := PluginStore{
+		model:     m,
+		namespace: namespace,
 	}
 
-	// Create the new message
-	newMessage := Message{
-		Username:  username,
-		Timestamp: timestamp,
-		Text:      text,
-	}
+	return &store
+}
 
-	// Add the new message to the channel
-	channel := m.channels[channelname]
-	channel.Messages = append(channel.Messages, newMessage)
+// Namespace returns the store's namespace.
+func (s *PluginStore) Namespace() string {
+	return s.namespace
+}
 
-	// Handle logging and subscriptions
-	if m.actionsLogger != nil {
-		m.actionsLogger.PostMessage(channelname, username, timestamp, text)
+// Put stores a value under a key (an empty value deletes the key).
+func (s *PluginStore) Put(key string, value string) {
+	s.model.PutPluginData(s.namespace, key, value)
+}
+
+// Get returns the value stored under a key, or false if there isn't one.
+func (s *PluginStore) Get(key string) (string, bool) {
+	return s.model.GetPluginData(s.namespace, key)
+}
+
+// Delete deletes the value stored under a key.
+func (s *PluginStore) Delete(key string) {
+	s.model.PutPluginData(s.namespace, key, "")
+}
+
+// Keys returns the keys that have values.
+func (s *PluginStore) Keys() map[string]struct{} {
+	return s.model.GetPluginKeys(s.namespace)
+}
+
+// NewWordListFilter returns a MessageFilter that masks each word from the word list (ignoring case)
+// with asterisks.
+func NewWordListFilter(words []string) MessageFilter {
+	wordList := make(map[string]struct{})
+	for _, word := range words {
+		wordList[strings.ToLower(word)] = struct{}{}
 	}
 
-	if m.subsEngine != nil {
-		m.subsEngine.ChannelChanged(channelname)
+	return func(text string) string {
+		fields := strings.Split(text, " ")
+		for i, field := range fields {
+			// Keep any surrounding punctuation
+			word := strings.Trim(field, ".,!?;:\"'()")
+			if _, ok := wordList[strings.ToLower(word)]; ok && word != "" {
+				fields[i] = strings.Replace(field, word, strings.Repeat("*", len(word)), 1)
+			}
+		}
+
+		return strings.Join(fields, " ")
 	}
 }
@@ -4,13 +4,19 @@ import (
 	"chatserver/model"
 	"chatserver/model/actions"
 	"chatserver/model/subs"
+	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestEmptyModelSetup(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
@@ -34,33 +40,165 @@ func TestEmptyModelSetup(t *testing.T) {
 	}
 }
 
+func TestDefaultChannels(t *testing.T) {
+	options := model.Options{
+		DefaultChannels: []string{"channel1", "channel2"},
+	}
+	testModel, err := model.NewModel(options, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	// The default channels are created alongside General
+	channels := testModel.GetChannels()
+	if len(channels) != 3 {
+		t.Error("Incorrect number of channels")
+	}
+
+	if _, ok := channels["channel1"]; !ok {
+		t.Error("Failed to create default channel channel1")
+	}
+
+	if _, ok := channels["channel2"]; !ok {
+		t.Error("Failed to create default channel channel2")
+	}
+
+	// New users join only the default channels
+	testModel.CreateUser("user1")
+	joinedChannels := testModel.GetJoinedChannels("user1")
+	if len(joinedChannels) != 2 {
+		t.Error("Incorrect number of joined channels")
+	}
+
+	if _, ok := joinedChannels["General"]; ok {
+		t.Error("Joined non-default General channel")
+	}
+
+	// Built-in and default channels missing from a replayed log are created after replay
+	testActionsLogger := NewTestActionsLogger()
+	testModel, err = model.NewModel(options, NewTestActionsReplayer(), testActionsLogger, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	if testActionsLogger.CreateChannelCalled != 3 || testActionsLogger.CreateChannelChannelname[1] != "channel1" || testActionsLogger.CreateChannelChannelname[2] != "channel2" {
+		t.Error("Failed to create missing default channels after replay")
+	}
+}
+
+func TestBuiltinNames(t *testing.T) {
+	options := model.Options{
+		BuiltinUsername:    "Invité",
+		BuiltinChannelname: "Général",
+	}
+	testModel, err := model.NewModel(options, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	if testModel.BuiltinUsername() != "Invité" || testModel.BuiltinChannelname() != "Général" {
+		t.Error("Incorrect built-in names")
+	}
+
+	users := testModel.GetUsers()
+	if _, ok := users["Invité"]; !ok || len(users) != 1 {
+		t.Error("Failed to create built-in user")
+	}
+
+	channels := testModel.GetChannels()
+	if _, ok := channels["Général"]; !ok || len(channels) != 1 {
+		t.Error("Failed to create built-in channel")
+	}
+
+	// The configured names are protected
+	testModel.DeleteUser("Invité")
+	testModel.DeleteChannel("Général")
+	if len(testModel.GetUsers()) != 1 || len(testModel.GetChannels()) != 1 {
+		t.Error("Failed to protect built-in names")
+	}
+
+	// The old names are no longer special
+	testModel.CreateUser("Anonymous")
+	testModel.CreateChannel("General")
+	testModel.DeleteUser("Anonymous")
+	testModel.DeleteChannel("General")
+	if len(testModel.GetUsers()) != 1 || len(testModel.GetChannels()) != 1 {
+		t.Error("Failed to delete non built-in names")
+	}
+}
+
+func TestProtectedEntities(t *testing.T) {
+	options := model.Options{
+		ProtectedUsers:    []string{"user1"},
+		ProtectedChannels: []string{"channel1"},
+	}
+	testModel, err := model.NewModel(options, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+
+	// Configured entities are protected
+	testModel.DeleteUser("user1")
+	testModel.DeleteChannel("channel1")
+	if _, ok := testModel.GetUsers()["user1"]; !ok {
+		t.Error("Failed to protect user1")
+	}
+
+	if _, ok := testModel.GetChannels()["channel1"]; !ok {
+		t.Error("Failed to protect channel1")
+	}
+
+	// Entities protected at runtime are protected
+	testModel.Policy().ProtectUser("user2")
+	testModel.Policy().ProtectChannel("channel2")
+	testModel.DeleteUser("user2")
+	testModel.DeleteChannel("channel2")
+	if len(testModel.GetUsers()) != 3 || len(testModel.GetChannels()) != 3 {
+		t.Error("Failed to protect runtime entities")
+	}
+
+	// Unprotected entities can be deleted
+	testModel.Policy().UnprotectUser("user1")
+	testModel.Policy().UnprotectChannel("channel1")
+	testModel.DeleteUser("user1")
+	testModel.DeleteChannel("channel1")
+	if len(testModel.GetUsers()) != 2 || len(testModel.GetChannels()) != 2 {
+		t.Error("Failed to delete unprotected entities")
+	}
+}
+
 func TestCreateUserInputChecking(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
-	testModel.CreateUser("")
+	err = testModel.CreateUser("")
 	users := testModel.GetUsers()
-	if len(users) != 1 {
+	if err != model.ErrInvalidName || len(users) != 1 {
 		t.Error("Incorrect number of users")
 	}
 
-	testModel.CreateUser("user 1")
+	err = testModel.CreateUser("user 1")
 	users = testModel.GetUsers()
-	if len(users) != 1 {
+	if err != model.ErrInvalidName || len(users) != 1 {
 		t.Error("Incorrect number of users")
 	}
 
-	testModel.CreateUser("Anonymous")
+	err = testModel.CreateUser("Anonymous")
 	users = testModel.GetUsers()
-	if len(users) != 1 {
+	if err != model.ErrUserExists || len(users) != 1 {
 		t.Error("Incorrect number of users")
 	}
 }
 
 func TestCreateAndDeleteUser(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
@@ -111,7 +249,7 @@ func TestCreateAndDeleteUser(t *testing.T) {
 }
 
 func TestCreateAndDeleteAnonymousUser(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
@@ -138,440 +276,3446 @@ func TestCreateAndDeleteAnonymousUser(t *testing.T) {
 	}
 }
 
-func TestGetUserInfo(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+func TestVirtualUsers(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
-	userInfo := testModel.GetUserInfo("user1")
-	if userInfo.Name != "" {
-		t.Error("Failed to return empty user info")
-	}
+	testModel.CreateUser("bridge1")
+	testModel.CreateUser("user1")
 
-	userInfo = testModel.GetUserInfo("Anonymous")
-	if userInfo.Name != "Anonymous" {
-		t.Error("Failed to return Anonymous user info")
+	// Invalid input is ignored
+	testModel.CreateVirtualUser("bridge2", "virtual1")
+	testModel.CreateVirtualUser("bridge1", "")
+	testModel.CreateVirtualUser("bridge1", "virtual 1")
+	testModel.CreateVirtualUser("bridge1", "user1")
+	if len(testModel.GetUsers()) != 3 {
+		t.Error("Failed to disregard invalid CreateVirtualUser")
 	}
-}
 
-func TestBlockUserInputChecking(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
-	if err != nil {
-		t.Error("Failed to create model")
+	testModel.CreateVirtualUser("bridge1", "virtual1")
+	testModel.CreateVirtualUser("bridge1", "virtual2")
+	if testModel.GetUserInfo("virtual1").Owner != "bridge1" || testModel.GetUserInfo("bridge1").Owner != "" {
+		t.Error("Incorrect owner for virtual user")
 	}
 
-	testModel.BlockUser("user1", "Anonymous")
-	userInfo := testModel.GetUserInfo("user1")
-	if userInfo.Name != "" {
-		t.Error("Failed to disregard block call for unknown user")
+	// Virtual users can't own virtual users
+	testModel.CreateVirtualUser("virtual1", "virtual3")
+	if _, ok := testModel.GetUsers()["virtual3"]; ok {
+		t.Error("Virtual user created a virtual user")
 	}
 
-	testModel.CreateUser("user1")
-	testModel.BlockUser("user1", "user2")
-	userInfo = testModel.GetUserInfo("user1")
-	if len(userInfo.BlockedUsers) != 0 {
-		t.Error("Failed to disallow blocking of unknown user")
+	// Virtual users don't join the default channels
+	if len(testModel.GetJoinedChannels("virtual1")) != 0 {
+		t.Error("Virtual user joined the default channels")
 	}
 
-	testModel.BlockUser("Anonymous", "user1")
-	userInfo = testModel.GetUserInfo("Anonymous")
-	if len(userInfo.BlockedUsers) != 0 {
-		t.Error("Failed to disallow blocking for Anonymous user")
+	testModel.BlockUser("user1", "virtual1")
+
+	// Deleting the owner deletes its virtual users
+	testModel.DeleteUser("bridge1")
+	users := testModel.GetUsers()
+	if len(users) != 2 {
+		t.Error("Virtual users weren't deleted with their owner")
 	}
 
-	testModel.BlockUser("user1", "user1")
-	userInfo = testModel.GetUserInfo("user1")
-	if len(userInfo.BlockedUsers) != 0 {
-		t.Error("Failed to disallow blocking for same user")
+	if len(testModel.GetUserInfo("user1").BlockedUsers) != 0 {
+		t.Error("Deleted virtual user is still blocked")
 	}
 }
 
-func TestUnblockUserInputChecking(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+func TestGetUserInfo(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
-	testModel.UnblockUser("user1", "Anonymous")
 	userInfo := testModel.GetUserInfo("user1")
 	if userInfo.Name != "" {
-		t.Error("Failed to disregard unblock call for unknown user")
+		t.Error("Failed to return empty user info")
 	}
 
-	testModel.CreateUser("user1")
-	testModel.CreateUser("user2")
-	testModel.BlockUser("user1", "user2")
-	testModel.UnblockUser("user1", "user3")
-	userInfo = testModel.GetUserInfo("user1")
-	if len(userInfo.BlockedUsers) != 1 || userInfo.BlockedUsers[0] != "user2" {
-		t.Error("Failed to disallow unblocking of unknown user")
+	userInfo = testModel.GetUserInfo("Anonymous")
+	if userInfo.Name != "Anonymous" {
+		t.Error("Failed to return Anonymous user info")
 	}
 }
 
-func TestBlockingAndUnblockingUsers(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+func TestTeams(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
-	// Add 2 users
 	testModel.CreateUser("user1")
 	testModel.CreateUser("user2")
 
-	// Verify that their user info is correct
-	user1Info := testModel.GetUserInfo("user1")
-	if user1Info.Name != "user1" || len(user1Info.BlockedUsers) != 0 {
-		t.Error("Invalid initial user info for user1")
+	if testModel.CreateTeam("") != model.ErrInvalidName || testModel.CreateTeam("team 1") != model.ErrInvalidName {
+		t.Error("Incorrect error creating team with an invalid name")
 	}
 
-	user2Info := testModel.GetUserInfo("user2")
-	if user2Info.Name != "user2" || len(user2Info.BlockedUsers) != 0 {
-		t.Error("Invalid initial user info for user2")
+	if testModel.CreateTeam("team1") != nil {
+		t.Error("Failed to create team")
 	}
 
-	// Add user2 to user1's BlockedUsers list and verify that both user infos are correct
-	testModel.BlockUser("user1", "user2")
-	user1Info = testModel.GetUserInfo("user1")
-	if len(user1Info.BlockedUsers) != 1 || user1Info.BlockedUsers[0] != "user2" {
-		t.Error("Failed to block user2 for user1")
+	if testModel.CreateTeam("team1") != model.ErrTeamExists {
+		t.Error("Incorrect error creating existing team")
 	}
 
-	user2Info = testModel.GetUserInfo("user2")
-	if len(user2Info.BlockedUsers) != 0 {
-		t.Error("Invalid user info for user2")
+	if testModel.AddTeamMember("team2", "user1") != model.ErrTeamNotFound {
+		t.Error("Incorrect error adding member to missing team")
 	}
 
-	// Attempt to block user2 again and ensure it's not added twice
-	testModel.BlockUser("user1", "user2")
-	user1Info = testModel.GetUserInfo("user1")
-	if len(user1Info.BlockedUsers) != 1 || user1Info.BlockedUsers[0] != "user2" {
-		t.Error("Failed to block user2 for user1")
+	if testModel.AddTeamMember("team1", "user3") != model.ErrUserNotFound {
+		t.Error("Incorrect error adding missing user to team")
 	}
 
-	// Remove user2 from user1's BlockedUsers list
-	testModel.UnblockUser("user1", "user2")
-	user1Info = testModel.GetUserInfo("user1")
-	if len(user1Info.BlockedUsers) != 0 {
-		t.Error("Failed to unblock user2 for user1")
+	if testModel.AddTeamMember("team1", "Anonymous") != model.ErrBuiltinUser {
+		t.Error("Incorrect error adding the built-in user to team")
 	}
 
-	user2Info = testModel.GetUserInfo("user2")
-	if len(user2Info.BlockedUsers) != 0 {
-		t.Error("Invalid user info for user2")
+	if testModel.AddTeamMember("team1", "user1") != nil || testModel.AddTeamMember("team1", "user2") != nil {
+		t.Error("Failed to add team members")
 	}
 
-	// Attempt to unblock user2 again and ensure it's not removed twice
-	testModel.UnblockUser("user1", "user2")
-	user1Info = testModel.GetUserInfo("user1")
-	if len(user1Info.BlockedUsers) != 0 {
-		t.Error("Failed to unblock user2 for user1")
+	if testModel.AddTeamMember("team1", "user1") != model.ErrAlreadyTeamMember {
+		t.Error("Incorrect error adding existing team member")
 	}
-}
 
-func TestBlockingAndDeletingUsers(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
-	if err != nil {
-		t.Error("Failed to create model")
+	if len(testModel.GetTeamMembers("team1")) != 2 {
+		t.Error("Incorrect number of team members")
 	}
 
-	testModel.CreateUser("user1")
-	testModel.CreateUser("user2")
-	testModel.CreateUser("user3")
-	testModel.CreateUser("user4")
-	testModel.CreateUser("user5")
+	if _, ok := testModel.GetUserTeams("user1")["team1"]; !ok {
+		t.Error("Team missing from the user's teams")
+	}
 
-	users := testModel.GetUsers()
-	if len(users) != 6 {
-		t.Error("Failed to create 5 users")
+	if testModel.RemoveTeamMember("team1", "user2") != nil {
+		t.Error("Failed to remove team member")
 	}
 
-	testModel.BlockUser("user1", "user3")
-	testModel.BlockUser("user1", "user4")
-	testModel.BlockUser("user1", "user5")
+	if testModel.RemoveTeamMember("team1", "user2") != model.ErrNotTeamMember {
+		t.Error("Incorrect error removing user that isn't a team member")
+	}
 
-	testModel.BlockUser("user2", "user3")
-	testModel.BlockUser("user2", "user4")
-	testModel.BlockUser("user2", "user5")
+	// Renaming a user renames them in their teams, deleting a user removes them
+	testModel.RenameUser("user1", "user3")
+	if _, ok := testModel.GetTeamMembers("team1")["user3"]; !ok || len(testModel.GetTeamMembers("team1")) != 1 {
+		t.Error("Failed to rename team member")
+	}
 
-	user1Info := testModel.GetUserInfo("user1")
-	if len(user1Info.BlockedUsers) != 3 {
-		t.Error("Failed to block 3 users for user1")
+	// The teams are kept in a snapshot
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
 	}
 
-	user2Info := testModel.GetUserInfo("user2")
-	if len(user2Info.BlockedUsers) != 3 {
-		t.Error("Failed to block 3 users for user2")
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+	if _, ok := restored.GetTeamMembers("team1")["user3"]; !ok {
+		t.Error("Failed to restore team from a snapshot")
 	}
 
 	testModel.DeleteUser("user3")
-	testModel.DeleteUser("user4")
-	testModel.DeleteUser("user5")
+	if len(testModel.GetTeamMembers("team1")) != 0 {
+		t.Error("Failed to remove deleted user from team")
+	}
 
-	users = testModel.GetUsers()
-	if len(users) != 3 {
-		t.Error("Failed to delete 3 users")
+	if _, ok := testModel.GetTeams()["team1"]; !ok {
+		t.Error("Team without members was deleted")
 	}
 
-	user1Info = testModel.GetUserInfo("user1")
-	if len(user1Info.BlockedUsers) != 0 {
-		t.Error("Failed to clean up blocked users on delete for user1")
+	if testModel.DeleteTeam("team1") != nil || len(testModel.GetTeams()) != 0 {
+		t.Error("Failed to delete team")
 	}
 
-	user2Info = testModel.GetUserInfo("user2")
-	if len(user2Info.BlockedUsers) != 0 {
-		t.Error("Failed to clean up blocked users on delete for user2")
+	if testModel.DeleteTeam("team1") != model.ErrTeamNotFound {
+		t.Error("Incorrect error deleting missing team")
 	}
 }
 
-func TestCreateChannelInputChecking(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+func TestCalendarEvents(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
-	testModel.CreateChannel("")
-	channels := testModel.GetChannels()
-	if len(channels) != 1 {
-		t.Error("Incorrect number of channels")
+	testModel.CreateUser("user1")
+	testModel.CreateChannel("channel1")
+	start := time.Date(2030, 1, 2, 15, 0, 0, 0, time.UTC)
+
+	if _, err := testModel.CreateEvent("channel2", "user1", start, "Standup"); err != model.ErrChannelNotFound {
+		t.Error("Incorrect error creating event in missing channel")
 	}
 
-	testModel.CreateChannel("channel 1")
-	channels = testModel.GetChannels()
-	if len(channels) != 1 {
-		t.Error("Incorrect number of channels")
+	if _, err := testModel.CreateEvent("channel1", "user2", start, "Standup"); err != model.ErrUserNotFound {
+		t.Error("Incorrect error creating event for missing user")
 	}
 
-	testModel.CreateChannel("General")
-	channels = testModel.GetChannels()
-	if len(channels) != 1 {
-		t.Error("Incorrect number of channels")
+	invalidEvents := []struct {
+		start time.Time
+		title string
+	}{
+		{time.Time{}, "Standup"},
+		{start, ""},
+		{start, "Stand\nup"},
+		{start, strings.Repeat("a", model.MaxEventTitleLength+1)},
+	}
+	for _, invalidEvent := range invalidEvents {
+		if _, err := testModel.CreateEvent("channel1", "user1", invalidEvent.start, invalidEvent.title); err != model.ErrInvalidEvent {
+			t.Error("Incorrect error creating invalid event", invalidEvent)
+		}
 	}
-}
 
-func TestCreateAndDeleteChannel(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+	laterID, err := testModel.CreateEvent("channel1", "user1", start.Add(time.Hour), "Retro")
 	if err != nil {
-		t.Error("Failed to create model")
+		t.Error("Failed to create event")
 	}
 
-	// Create a single channel and verify that it is added
-	testModel.CreateChannel("channel1")
-	channels := testModel.GetChannels()
-	if len(channels) != 2 {
-		t.Error("Incorrect number of channels")
+	eventID, err := testModel.CreateEvent("channel1", "user1", start, "Standup")
+	if err != nil || eventID != laterID+1 {
+		t.Error("Failed to create event with the next ID")
 	}
 
-	if _, ok := channels["channel1"]; !ok {
-		t.Error("Failed to create channel1")
+	// The events are listed in the order they start
+	events := testModel.GetEvents("channel1")
+	if len(events) != 2 || events[0].ID != eventID || events[1].ID != laterID || events[0].Channelname != "channel1" ||
+		events[0].Creator != "user1" || !events[0].Start.Equal(start) || events[0].Title != "Standup" {
+		t.Error("Incorrect events", events)
 	}
 
-	// Create another channel with the same name and verify that it is not added again
-	testModel.CreateChannel("channel1")
-	channels = testModel.GetChannels()
-	if len(channels) != 2 {
-		t.Error("Incorrect number of channels")
+	if testModel.RSVPEvent(eventID+1, "user1", true) != model.ErrEventNotFound {
+		t.Error("Incorrect error RSVPing to missing event")
 	}
 
-	if _, ok := channels["channel1"]; !ok {
-		t.Error("Failed to create channel1")
+	if testModel.RSVPEvent(eventID, "Anonymous", true) != model.ErrBuiltinUser {
+		t.Error("Incorrect error RSVPing as the built-in user")
 	}
 
-	// Delete the channel and verify that it is deleted
-	testModel.DeleteChannel("channel1")
-	channels = testModel.GetChannels()
-	if len(channels) != 1 {
-		t.Error("Incorrect number of channels")
+	if testModel.RSVPEvent(eventID, "user1", true) != nil || len(testModel.GetEvents("channel1")[0].Going) != 1 {
+		t.Error("Failed to RSVP to event")
 	}
 
-	if _, ok := channels["channels"]; ok {
-		t.Error("Failed to delete channel1")
+	if testModel.MarkEventReminded(eventID) != nil || !testModel.GetEvents("channel1")[0].Reminded {
+		t.Error("Failed to mark event reminded")
 	}
 
-	// Delete the channel again and verify that it is not deleted again
-	testModel.DeleteChannel("channel1")
-	channels = testModel.GetChannels()
-	if len(channels) != 1 {
-		t.Error("Incorrect number of channels")
+	if testModel.MarkEventReminded(eventID) != model.ErrEventReminded {
+		t.Error("Incorrect error marking event reminded twice")
 	}
 
-	if _, ok := channels["channel1"]; ok {
-		t.Error("Failed to delete channel1")
+	// The events go with their channel when it's renamed, and RSVPs with their user
+	testModel.RenameChannel("channel1", "channel2")
+	testModel.RenameUser("user1", "user2")
+	events = testModel.GetEvents("channel2")
+	if len(events) != 2 || events[0].Channelname != "channel2" || events[0].Creator != "user2" || events[0].Going[0] != "user2" {
+		t.Error("Failed to keep events after renaming", events)
 	}
-}
 
-func TestCreateAndDeleteGeneralChannel(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+	// The events are kept in a snapshot
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
-	// Ensure that we can't create or delete the General channel
-	testModel.CreateChannel("General")
-	channels := testModel.GetChannels()
-	if len(channels) != 1 {
-		t.Error("Incorrect number of channels")
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+	if !reflect.DeepEqual(restored.GetEvents("channel2"), events) {
+		t.Error("Failed to restore events from a snapshot")
 	}
 
-	if _, ok := channels["General"]; !ok {
-		t.Error("Messed up General channel")
+	if nextID, _ := restored.CreateEvent("channel2", "user2", start, "Standup"); nextID != eventID+1 {
+		t.Error("Failed to restore the last event ID from a snapshot")
 	}
 
-	testModel.DeleteChannel("General")
-	channels = testModel.GetChannels()
-	if len(channels) != 1 {
-		t.Error("Incorrect number of channels")
+	// Deleting the user takes back their RSVPs
+	testModel.DeleteUser("user2")
+	if len(testModel.GetEvents("channel2")[0].Going) != 0 {
+		t.Error("Failed to remove deleted user's RSVP")
 	}
 
-	if _, ok := channels["General"]; !ok {
+	// A deleted channel's events can't be RSVPed to, and come back when it's restored
+	testModel.CreateUser("user1")
+	testModel.DeleteChannel("channel2")
+	if len(testModel.GetEvents("channel2")) != 0 || testModel.RSVPEvent(eventID, "user1", true) != model.ErrEventNotFound {
+		t.Error("Deleted channel's events still available")
+	}
+
+	testModel.RestoreChannel("channel2")
+	if len(testModel.GetEvents("channel2")) != 2 {
+		t.Error("Failed to restore channel's events")
+	}
+}
+
+func TestReadMarkers(t *testing.T) {
+	testSubsEngine := NewTestSubsEngine()
+	testModel, err := model.NewModel(model.Options{}, nil, nil, testSubsEngine)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	testModel.CreateChannel("channel1")
+	testModel.PostMessage("channel1", "user1", time.Time{}, "Text1")
+	testModel.PostMessage("channel1", "user1", time.Time{}, "Text2")
+	history := testModel.GetChannelHistory("channel1", "user1", -1)
+	firstID, lastID := history[0].ID, history[1].ID
+
+	if testModel.MarkRead("user2", "channel1", lastID) != model.ErrUserNotFound {
+		t.Error("Incorrect error marking read for missing user")
+	}
+
+	if testModel.MarkRead("user1", "channel2", lastID) != model.ErrChannelNotFound {
+		t.Error("Incorrect error marking missing channel read")
+	}
+
+	if testModel.MarkRead("Anonymous", "channel1", lastID) != model.ErrBuiltinUser {
+		t.Error("Incorrect error marking read for the built-in user")
+	}
+
+	if testModel.MarkRead("user1", "channel1", lastID+1) != model.ErrMessageNotFound {
+		t.Error("Incorrect error marking missing message read")
+	}
+
+	if len(testModel.GetReadMarkers("user1")) != 0 {
+		t.Error("Unmarked channel has a read marker")
+	}
+
+	testSubsEngine.Reset()
+	if testModel.MarkRead("user1", "channel1", lastID) != nil || testModel.GetReadMarkers("user1")["channel1"] != lastID {
+		t.Error("Failed to mark channel read")
+	}
+
+	if testSubsEngine.ReadMarkerChangedCalled != 1 || testSubsEngine.ReadMarkerChangedNames[0] != [2]string{"user1", "channel1"} {
+		t.Error("Failed to notify read marker change")
+	}
+
+	// Read markers don't move back
+	testSubsEngine.Reset()
+	if testModel.MarkRead("user1", "channel1", firstID) != nil || testModel.GetReadMarkers("user1")["channel1"] != lastID ||
+		testSubsEngine.ReadMarkerChangedCalled != 0 {
+		t.Error("Read marker moved back")
+	}
+
+	// The read markers go with their channel when it's renamed, and with their user
+	testModel.RenameChannel("channel1", "channel2")
+	testModel.RenameUser("user1", "user2")
+	if readMarkers := testModel.GetReadMarkers("user2"); len(readMarkers) != 1 || readMarkers["channel2"] != lastID {
+		t.Error("Failed to keep read markers after renaming", readMarkers)
+	}
+
+	// The read markers are kept in a snapshot
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+	if restored.GetReadMarkers("user2")["channel2"] != lastID {
+		t.Error("Failed to restore read markers from a snapshot")
+	}
+
+	// A deleted user's read markers don't carry over to a new user with the name
+	testModel.DeleteUser("user2")
+	testModel.CreateUser("user2")
+	if len(testModel.GetReadMarkers("user2")) != 0 {
+		t.Error("Failed to remove deleted user's read markers")
+	}
+}
+
+func TestFuzzyFind(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	found := func(query string) []string {
+		names := make([]string, 0)
+		for _, match := range testModel.FuzzyFind(query, 0) {
+			names = append(names, match.Kind+":"+match.Name)
+		}
+		return names
+	}
+
+	// Ensure that channels and users are both found, best first
+	testModel.CreateChannel("dev-chat")
+	testModel.CreateUser("Dave")
+	if !reflect.DeepEqual(found("d"), []string{"user:Dave", "channel:dev-chat"}) {
+		t.Error("Failed to find channels and users")
+	}
+	if matches := testModel.FuzzyFind("d", 1); len(matches) != 1 || matches[0].Name != "Dave" {
+		t.Error("Failed to limit matches")
+	}
+
+	// Ensure that the index follows renames, deletions and restores
+	testModel.RenameUser("Dave", "David")
+	testModel.RenameChannel("dev-chat", "dev-talk")
+	if !reflect.DeepEqual(found("dav"), []string{"user:David"}) || !reflect.DeepEqual(found("devt"), []string{"channel:dev-talk"}) {
+		t.Error("Failed to find renamed names")
+	}
+
+	testModel.DeleteChannel("dev-talk")
+	testModel.DeleteUser("David")
+	if len(found("d")) != 0 {
+		t.Error("Found deleted names")
+	}
+
+	testModel.RestoreChannel("dev-talk")
+	if !reflect.DeepEqual(found("d"), []string{"channel:dev-talk"}) {
+		t.Error("Failed to find a restored channel")
+	}
+
+	// Ensure that a rejected batch doesn't add to the index
+	testModel.Batch([]model.Mutation{
+		{Type: "CreateChannel", Channelname: "dev-ops"},
+		{Type: "JoinChannel", Username: "user1", Channelname: "dev-ops"},
+	})
+	if len(found("ops")) != 0 {
+		t.Error("Rejected batch added to the index")
+	}
+
+	// Ensure that the index is rebuilt from a snapshot
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+	if !reflect.DeepEqual(restored.FuzzyFind("e", 0), testModel.FuzzyFind("e", 0)) || len(restored.FuzzyFind("user1", 0)) != 1 {
+		t.Error("Failed to restore the index from a snapshot")
+	}
+}
+
+func TestChannelNotes(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+
+	// Ensure that the notes need a channel, a user and valid text
+	if _, err := testModel.SetChannelNotes("channel2", "user1", 0, "notes1"); err != model.ErrChannelNotFound {
+		t.Error("Set notes of a channel that doesn't exist")
+	}
+	if _, err := testModel.SetChannelNotes("channel1", "user3", 0, "notes1"); err != model.ErrUserNotFound {
+		t.Error("Set notes for a user that doesn't exist")
+	}
+	if _, err := testModel.SetChannelNotes("channel1", "user1", 0, strings.Repeat("a", model.MaxChannelNotesLength+1)); err != model.ErrInvalidNotes {
+		t.Error("Set notes that are too long")
+	}
+	if testModel.GetChannelNotes("channel1").Version != 0 {
+		t.Error("Rejected notes changed the version")
+	}
+
+	// Ensure that each edit is a new version, with its editor
+	version, err := testModel.SetChannelNotes("channel1", "user1", 0, "notes1\nline2")
+	if err != nil || version != 1 {
+		t.Error("Failed to set notes")
+	}
+	version, err = testModel.SetChannelNotes("channel1", "user2", version, "notes2")
+	notes := testModel.GetChannelNotes("channel1")
+	if err != nil || version != 2 || notes.Version != 2 || notes.Text != "notes2" || notes.Editor != "user2" || notes.Edited.IsZero() {
+		t.Error("Failed to edit notes")
+	}
+
+	// Ensure that an edit of an older version is rejected
+	if _, err := testModel.SetChannelNotes("channel1", "user1", 1, "notes3"); err != model.ErrNotesChanged {
+		t.Error("Edited an older version of the notes")
+	}
+	if testModel.GetChannelNotes("channel1").Text != "notes2" {
+		t.Error("Rejected edit changed the notes")
+	}
+
+	// Ensure that the notes follow their editor's rename, and are kept in a snapshot
+	testModel.RenameUser("user2", "user3")
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+	if restored.GetChannelNotes("channel1") != testModel.GetChannelNotes("channel1") || restored.GetChannelNotes("channel1").Editor != "user3" {
+		t.Error("Failed to restore notes from a snapshot")
+	}
+}
+
+func TestStarredMessages(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	message1, _ := testModel.PostMessage("channel1", "user2", time.Time{}, "message1")
+	message2, _ := testModel.PostMessage("channel2", "user2", time.Time{}, "message2")
+	message3, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message3")
+
+	// Ensure that starring needs a user, a channel and a message, and not the built-in user
+	if testModel.StarMessage("user3", "channel1", message1.ID, true) != model.ErrUserNotFound {
+		t.Error("Starred message for a user that doesn't exist")
+	}
+	if testModel.StarMessage("user1", "channel3", message1.ID, true) != model.ErrChannelNotFound {
+		t.Error("Starred message in a channel that doesn't exist")
+	}
+	if testModel.StarMessage("Anonymous", "channel1", message1.ID, true) != model.ErrBuiltinUser {
+		t.Error("Starred message for the built-in user")
+	}
+	if testModel.StarMessage("user1", "channel1", message2.ID, true) != model.ErrMessageNotFound {
+		t.Error("Starred message in the wrong channel")
+	}
+
+	// Ensure that the starred messages are listed oldest first across the channels
+	testModel.StarMessage("user1", "channel1", message3.ID, true)
+	testModel.StarMessage("user1", "channel2", message2.ID, true)
+	testModel.StarMessage("user1", "channel1", message1.ID, true)
+	testModel.StarMessage("user1", "channel1", message1.ID, true)
+	starred := testModel.GetStarredMessages("user1")
+	if len(starred) != 3 || starred[0].Message.ID != message1.ID || starred[1].Channelname != "channel2" || starred[2].Message.Text != "message3" {
+		t.Error("Failed to get starred messages")
+	}
+	if len(testModel.GetStarredMessages("user2")) != 0 {
+		t.Error("Stars shared between users")
+	}
+
+	// Ensure that unstarring removes the star
+	if testModel.StarMessage("user1", "channel1", message3.ID, false) != nil || len(testModel.GetStarredMessages("user1")) != 2 {
+		t.Error("Failed to unstar message")
+	}
+
+	// Ensure that the stars are kept in a snapshot, and follow a user rename
+	testModel.RenameUser("user1", "user3")
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+	if len(restored.GetStarredMessages("user3")) != 2 {
+		t.Error("Failed to restore stars from a snapshot")
+	}
+
+	// Ensure that deleted messages and channels are unstarred, and a deleted user's stars don't
+	// carry over to a new user with the name
+	testModel.DeleteMessage("channel1", message1.ID, "user2")
+	testModel.DeleteChannel("channel2")
+	if len(testModel.GetStarredMessages("user3")) != 0 {
+		t.Error("Failed to unstar deleted messages")
+	}
+
+	testModel.CreateChannel("channel2")
+	message4, _ := testModel.PostMessage("channel2", "user2", time.Time{}, "message4")
+	testModel.StarMessage("user3", "channel2", message4.ID, true)
+	testModel.DeleteUser("user3")
+	testModel.CreateUser("user3")
+	if len(testModel.GetStarredMessages("user3")) != 0 {
+		t.Error("Deleted user's stars carried over")
+	}
+}
+
+func TestUnreadCounts(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.CreateUser("user3")
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+	testModel.JoinChannel("user1", "channel1")
+	testModel.PostMessage("channel1", "user2", time.Time{}, "Text1")
+	testModel.PostMessage("channel1", "user1", time.Time{}, "Text2")
+	testModel.PostMessage("channel2", "user2", time.Time{}, "Text3")
+
+	// Only the others' messages in the joined channels are unread
+	unreadCounts := testModel.GetUnreadCounts("user1")
+	if len(unreadCounts) != 1 || unreadCounts["channel1"] != 1 {
+		t.Error("Incorrect unread counts", unreadCounts)
+	}
+
+	// Messages after the read marker are unread, apart from deleted ones and those from blocked
+	// users
+	history := testModel.GetChannelHistory("channel1", "user1", -1)
+	testModel.MarkRead("user1", "channel1", history[1].ID)
+	message, _ := testModel.PostMessage("channel1", "user2", time.Time{}, "Text4")
+	testModel.PostMessage("channel1", "user3", time.Time{}, "Text5")
+	testModel.PostMessage("channel1", "user2", time.Time{}, "Text6")
+	testModel.DeleteMessage("channel1", message.ID, "user2")
+	if unreadCounts := testModel.GetUnreadCounts("user1"); unreadCounts["channel1"] != 2 {
+		t.Error("Incorrect unread count after read marker", unreadCounts)
+	}
+
+	testModel.BlockUser("user1", "user3")
+	if unreadCounts := testModel.GetUnreadCounts("user1"); unreadCounts["channel1"] != 1 {
+		t.Error("Counted blocked user's message as unread", unreadCounts)
+	}
+
+	// Muted channels have no unread counts
+	testModel.MuteChannel("user1", "channel1")
+	if len(testModel.GetUnreadCounts("user1")) != 0 {
+		t.Error("Counted muted channel's messages as unread")
+	}
+	testModel.UnmuteChannel("user1", "channel1")
+
+	// Reading up to the last message leaves nothing unread
+	history = testModel.GetChannelHistory("channel1", "user1", 1)
+	testModel.MarkRead("user1", "channel1", history[0].ID)
+	if len(testModel.GetUnreadCounts("user1")) != 0 {
+		t.Error("Unread messages left after reading channel")
+	}
+
+	// The built-in user has no unread counts
+	if len(testModel.GetUnreadCounts("Anonymous")) != 0 {
+		t.Error("Built-in user has unread counts")
+	}
+}
+
+func TestMentions(t *testing.T) {
+	testSubsEngine := NewTestSubsEngine()
+	testModel, err := model.NewModel(model.Options{}, nil, nil, testSubsEngine)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.CreateUser("user3")
+	testModel.CreateChannel("channel1")
+
+	// Only existing users other than the author and the built-in user are mentioned, once each, and
+	// punctuation after a name isn't part of it
+	testSubsEngine.Reset()
+	message, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "@user3, @user2 @user2 @user1 @user4 @Anonymous user3@ hi")
+	if !reflect.DeepEqual(message.Mentions, []string{"user2", "user3"}) {
+		t.Error("Incorrect mentions", message.Mentions)
+	}
+
+	if testSubsEngine.MentionedCalled != 2 || testSubsEngine.MentionedNames[0] != [2]string{"user2", "channel1"} ||
+		testSubsEngine.MentionedMessageID[0] != message.ID {
+		t.Error("Failed to notify mentioned users")
+	}
+
+	// Snippets don't mention anyone
+	snippet, _ := testModel.PostSnippet("channel1", "user1", time.Time{}, "java", "@user2\nclass A {}\n")
+	if len(snippet.Mentions) != 0 {
+		t.Error("Snippet mentioned users")
+	}
+
+	// Users that blocked the author aren't notified (or shown the mention)
+	testModel.BlockUser("user3", "user1")
+	testSubsEngine.Reset()
+	testModel.PostMessage("channel1", "user1", time.Time{}, "@user3 again")
+	if testSubsEngine.MentionedCalled != 0 || len(testModel.GetMentions("user3")) != 0 {
+		t.Error("Notified user of mention by blocked user")
+	}
+
+	// Users that muted the channel aren't notified either
+	testModel.UnblockUser("user3", "user1")
+	testModel.MuteChannel("user3", "channel1")
+	testSubsEngine.Reset()
+	mutedMessage, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "@user3 muted")
+	if testSubsEngine.MentionedCalled != 0 {
+		t.Error("Notified user of mention in muted channel")
+	}
+	testModel.UnmuteChannel("user3", "channel1")
+	testModel.DeleteMessage("channel1", mutedMessage.ID, "user1")
+
+	// Editing a message only notifies the newly mentioned users
+	testSubsEngine.Reset()
+	testModel.EditMessage("channel1", message.ID, "user1", "@user2 @user3 and @user1")
+	if testSubsEngine.MentionedCalled != 0 {
+		t.Error("Notified already mentioned users of edit")
+	}
+
+	testModel.EditMessage("channel1", message.ID, "user1", "@user2 only")
+	testSubsEngine.Reset()
+	testModel.EditMessage("channel1", message.ID, "user1", "@user2 and @user3")
+	if testSubsEngine.MentionedCalled != 1 || testSubsEngine.MentionedNames[0][0] != "user3" {
+		t.Error("Failed to notify newly mentioned user of edit")
+	}
+
+	mentions := testModel.GetMentions("user3")
+	if len(mentions) != 2 || mentions[0].Channelname != "channel1" || mentions[0].Message.ID != message.ID {
+		t.Error("Incorrect mentions for user", mentions)
+	}
+
+	// The mentions follow renamed users, and are kept in a snapshot
+	testModel.RenameUser("user3", "user4")
+	if len(testModel.GetMentions("user4")) != 2 {
+		t.Error("Failed to rename user in mentions")
+	}
+
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+	if !reflect.DeepEqual(restored.GetMentions("user4"), testModel.GetMentions("user4")) {
+		t.Error("Failed to restore mentions from a snapshot")
+	}
+
+	// Deleted messages and users don't mention anyone
+	testModel.DeleteMessage("channel1", message.ID, "user1")
+	if len(testModel.GetMentions("user2")) != 0 {
+		t.Error("Deleted message still mentions user")
+	}
+
+	testModel.DeleteUser("user4")
+	testModel.CreateUser("user4")
+	if len(testModel.GetMentions("user4")) != 0 {
+		t.Error("Failed to remove deleted user's mentions")
+	}
+}
+
+func TestPresence(t *testing.T) {
+	testSubsEngine := NewTestSubsEngine()
+	testModel, err := model.NewModel(model.Options{}, nil, nil, testSubsEngine)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+
+	// The built-in user is never online
+	connection1 := testModel.Connect("Anonymous")
+	if len(testModel.GetPresence()) != 0 || testSubsEngine.PresenceChangedCalled != 0 {
+		t.Error("Built-in user is online")
+	}
+
+	testModel.SetConnectionUser(connection1, "user1")
+	if _, ok := testModel.GetPresence()["user1"]; !ok || testSubsEngine.PresenceChangedCalled != 1 ||
+		testSubsEngine.PresenceChangedUsername[0] != "user1" {
+		t.Error("Failed to put user online")
+	}
+
+	// A second connection as the same user doesn't change their presence
+	testSubsEngine.Reset()
+	connection2 := testModel.Connect("user1")
+	testModel.Disconnect(connection1)
+	if _, ok := testModel.GetPresence()["user1"]; !ok || testSubsEngine.PresenceChangedCalled != 0 {
+		t.Error("User went offline with a connection left")
+	}
+
+	// Renaming the user keeps them online
+	testModel.RenameUser("user1", "user3")
+	if _, ok := testModel.GetPresence()["user3"]; !ok || len(testModel.GetPresence()) != 1 {
+		t.Error("Renamed user isn't online")
+	}
+
+	testSubsEngine.Reset()
+	testModel.SetConnectionUser(connection2, "user2")
+	if _, ok := testModel.GetPresence()["user2"]; !ok || len(testModel.GetPresence()) != 1 ||
+		testSubsEngine.PresenceChangedCalled != 2 {
+		t.Error("Failed to switch the online user")
+	}
+
+	// Deleted users aren't online
+	testModel.DeleteUser("user2")
+	if len(testModel.GetPresence()) != 0 {
+		t.Error("Deleted user is online")
+	}
+
+	testModel.Disconnect(connection2)
+	testModel.Disconnect(connection2)
+	testModel.SetConnectionUser(connection2, "user3")
+	if len(testModel.GetPresence()) != 0 {
+		t.Error("Disconnected user is online")
+	}
+}
+
+func TestSetUserProfile(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	profile := model.Profile{DisplayName: "User One", Bio: "line1\nline2", Pronouns: "they/them"}
+
+	if testModel.SetUserProfile("user2", profile) != model.ErrUserNotFound {
+		t.Error("Incorrect error setting a missing user's profile")
+	}
+
+	if testModel.SetUserProfile("Anonymous", profile) != model.ErrBuiltinUser {
+		t.Error("Incorrect error setting the built-in user's profile")
+	}
+
+	invalidProfiles := []model.Profile{
+		{DisplayName: "User\nOne"},
+		{DisplayName: strings.Repeat("a", model.MaxDisplayNameLength+1)},
+		{Bio: strings.Repeat("a", model.MaxBioLength+1)},
+		{Bio: "bell\a"},
+		{Pronouns: "they\tthem"},
+	}
+	for _, invalidProfile := range invalidProfiles {
+		if testModel.SetUserProfile("user1", invalidProfile) != model.ErrInvalidProfile {
+			t.Error("Incorrect error setting invalid profile", invalidProfile)
+		}
+	}
+
+	// The lengths are in characters, not bytes
+	if testModel.SetUserProfile("user1", model.Profile{DisplayName: strings.Repeat("é", model.MaxDisplayNameLength)}) != nil {
+		t.Error("Failed to set a display name of the maximum length")
+	}
+
+	if testModel.SetUserProfile("user1", profile) != nil || testModel.GetUserInfo("user1").Profile != profile {
+		t.Error("Failed to set profile")
+	}
+
+	// The profile goes with the user when they're renamed, and is kept in a snapshot
+	testModel.RenameUser("user1", "user2")
+	if testModel.GetUserInfo("user2").Profile != profile {
+		t.Error("Failed to keep profile after renaming the user")
+	}
+
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+	if restored.GetUserInfo("user2").Profile != profile {
+		t.Error("Failed to restore profile from a snapshot")
+	}
+
+	// Clearing the fields clears the profile
+	if testModel.SetUserProfile("user2", model.Profile{}) != nil || testModel.GetUserInfo("user2").Profile != (model.Profile{}) {
+		t.Error("Failed to clear profile")
+	}
+}
+
+func TestSetUserStatus(t *testing.T) {
+	testSubsEngine := NewTestSubsEngine()
+	testModel, err := model.NewModel(model.Options{}, nil, nil, testSubsEngine)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	status := model.Status{Text: "lunch", Away: true}
+
+	if testModel.SetUserStatus("user2", status) != model.ErrUserNotFound {
+		t.Error("Incorrect error setting a missing user's status")
+	}
+
+	if testModel.SetUserStatus("Anonymous", status) != model.ErrBuiltinUser {
+		t.Error("Incorrect error setting the built-in user's status")
+	}
+
+	invalidStatuses := []model.Status{
+		{Text: "out\nto lunch"},
+		{Text: strings.Repeat("a", model.MaxStatusLength+1)},
+	}
+	for _, invalidStatus := range invalidStatuses {
+		if testModel.SetUserStatus("user1", invalidStatus) != model.ErrInvalidStatus {
+			t.Error("Incorrect error setting invalid status", invalidStatus)
+		}
+	}
+
+	// Setting the status notifies the user's subscribers
+	testSubsEngine.Reset()
+	if testModel.SetUserStatus("user1", status) != nil || testModel.GetUserInfo("user1").Status != status {
+		t.Error("Failed to set status")
+	}
+	if testSubsEngine.UserChangedCalled != 1 || testSubsEngine.UserChangedUsername[0] != "user1" {
+		t.Error("Setting the status didn't notify the user changed")
+	}
+
+	// Only the users that have set a status are listed with the statuses
+	testModel.CreateUser("user3")
+	statuses := testModel.GetUserStatuses()
+	if len(statuses) != 1 || statuses["user1"] != status {
+		t.Error("Failed to get the user statuses")
+	}
+
+	// The status goes with the user when they're renamed, and is kept in a snapshot
+	testModel.RenameUser("user1", "user2")
+	if testModel.GetUserInfo("user2").Status != status {
+		t.Error("Failed to keep status after renaming the user")
+	}
+
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+	if restored.GetUserInfo("user2").Status != status {
+		t.Error("Failed to restore status from a snapshot")
+	}
+
+	// An empty status clears it
+	if testModel.SetUserStatus("user2", model.Status{}) != nil || testModel.GetUserInfo("user2").Status != (model.Status{}) {
+		t.Error("Failed to clear status")
+	}
+}
+
+func TestBlockUserInputChecking(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	err = testModel.BlockUser("user1", "Anonymous")
+	userInfo := testModel.GetUserInfo("user1")
+	if err != model.ErrUserNotFound || userInfo.Name != "" {
+		t.Error("Failed to disregard block call for unknown user")
+	}
+
+	testModel.CreateUser("user1")
+	err = testModel.BlockUser("user1", "user2")
+	userInfo = testModel.GetUserInfo("user1")
+	if err != model.ErrUserNotFound || len(userInfo.BlockedUsers) != 0 {
+		t.Error("Failed to disallow blocking of unknown user")
+	}
+
+	err = testModel.BlockUser("Anonymous", "user1")
+	userInfo = testModel.GetUserInfo("Anonymous")
+	if err != model.ErrBuiltinUser || len(userInfo.BlockedUsers) != 0 {
+		t.Error("Failed to disallow blocking for Anonymous user")
+	}
+
+	err = testModel.BlockUser("user1", "user1")
+	userInfo = testModel.GetUserInfo("user1")
+	if err != model.ErrBlockSelf || len(userInfo.BlockedUsers) != 0 {
+		t.Error("Failed to disallow blocking for same user")
+	}
+}
+
+func TestUnblockUserInputChecking(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.UnblockUser("user1", "Anonymous")
+	userInfo := testModel.GetUserInfo("user1")
+	if userInfo.Name != "" {
+		t.Error("Failed to disregard unblock call for unknown user")
+	}
+
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.BlockUser("user1", "user2")
+	testModel.UnblockUser("user1", "user3")
+	userInfo = testModel.GetUserInfo("user1")
+	if len(userInfo.BlockedUsers) != 1 || userInfo.BlockedUsers[0] != "user2" {
+		t.Error("Failed to disallow unblocking of unknown user")
+	}
+}
+
+func TestBlockingAndUnblockingUsers(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	// Add 2 users
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+
+	// Verify that their user info is correct
+	user1Info := testModel.GetUserInfo("user1")
+	if user1Info.Name != "user1" || len(user1Info.BlockedUsers) != 0 {
+		t.Error("Invalid initial user info for user1")
+	}
+
+	user2Info := testModel.GetUserInfo("user2")
+	if user2Info.Name != "user2" || len(user2Info.BlockedUsers) != 0 {
+		t.Error("Invalid initial user info for user2")
+	}
+
+	// Add user2 to user1's BlockedUsers list and verify that both user infos are correct
+	testModel.BlockUser("user1", "user2")
+	user1Info = testModel.GetUserInfo("user1")
+	if len(user1Info.BlockedUsers) != 1 || user1Info.BlockedUsers[0] != "user2" {
+		t.Error("Failed to block user2 for user1")
+	}
+
+	user2Info = testModel.GetUserInfo("user2")
+	if len(user2Info.BlockedUsers) != 0 {
+		t.Error("Invalid user info for user2")
+	}
+
+	// Attempt to block user2 again and ensure it's not added twice
+	testModel.BlockUser("user1", "user2")
+	user1Info = testModel.GetUserInfo("user1")
+	if len(user1Info.BlockedUsers) != 1 || user1Info.BlockedUsers[0] != "user2" {
+		t.Error("Failed to block user2 for user1")
+	}
+
+	// Remove user2 from user1's BlockedUsers list
+	testModel.UnblockUser("user1", "user2")
+	user1Info = testModel.GetUserInfo("user1")
+	if len(user1Info.BlockedUsers) != 0 {
+		t.Error("Failed to unblock user2 for user1")
+	}
+
+	user2Info = testModel.GetUserInfo("user2")
+	if len(user2Info.BlockedUsers) != 0 {
+		t.Error("Invalid user info for user2")
+	}
+
+	// Attempt to unblock user2 again and ensure it's rejected
+	if err := testModel.UnblockUser("user1", "user2"); err != model.ErrNotBlocked {
+		t.Error("Incorrect error unblocking a user that isn't blocked")
+	}
+	user1Info = testModel.GetUserInfo("user1")
+	if len(user1Info.BlockedUsers) != 0 {
+		t.Error("Failed to unblock user2 for user1")
+	}
+}
+
+func TestBlockingAndDeletingUsers(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.CreateUser("user3")
+	testModel.CreateUser("user4")
+	testModel.CreateUser("user5")
+
+	users := testModel.GetUsers()
+	if len(users) != 6 {
+		t.Error("Failed to create 5 users")
+	}
+
+	testModel.BlockUser("user1", "user3")
+	testModel.BlockUser("user1", "user4")
+	testModel.BlockUser("user1", "user5")
+
+	testModel.BlockUser("user2", "user3")
+	testModel.BlockUser("user2", "user4")
+	testModel.BlockUser("user2", "user5")
+
+	user1Info := testModel.GetUserInfo("user1")
+	if len(user1Info.BlockedUsers) != 3 {
+		t.Error("Failed to block 3 users for user1")
+	}
+
+	user2Info := testModel.GetUserInfo("user2")
+	if len(user2Info.BlockedUsers) != 3 {
+		t.Error("Failed to block 3 users for user2")
+	}
+
+	testModel.DeleteUser("user3")
+	testModel.DeleteUser("user4")
+	testModel.DeleteUser("user5")
+
+	users = testModel.GetUsers()
+	if len(users) != 3 {
+		t.Error("Failed to delete 3 users")
+	}
+
+	user1Info = testModel.GetUserInfo("user1")
+	if len(user1Info.BlockedUsers) != 0 {
+		t.Error("Failed to clean up blocked users on delete for user1")
+	}
+
+	user2Info = testModel.GetUserInfo("user2")
+	if len(user2Info.BlockedUsers) != 0 {
+		t.Error("Failed to clean up blocked users on delete for user2")
+	}
+}
+
+func TestMuteChannelInputChecking(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	err = testModel.MuteChannel("user1", "General")
+	userInfo := testModel.GetUserInfo("user1")
+	if err != model.ErrUserNotFound || userInfo.Name != "" {
+		t.Error("Failed to disregard mute call for unknown user")
+	}
+
+	testModel.CreateUser("user1")
+	err = testModel.MuteChannel("user1", "channel1")
+	userInfo = testModel.GetUserInfo("user1")
+	if err != model.ErrChannelNotFound || len(userInfo.MutedChannels) != 0 {
+		t.Error("Failed to disallow muting of unknown channel")
+	}
+
+	err = testModel.MuteChannel("Anonymous", "General")
+	userInfo = testModel.GetUserInfo("Anonymous")
+	if err != model.ErrBuiltinUser || len(userInfo.MutedChannels) != 0 {
+		t.Error("Failed to disallow muting for Anonymous user")
+	}
+}
+
+func TestMutingAndUnmutingChannels(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	testModel.CreateChannel("channel1")
+
+	// Mute channel1 for user1 and verify that it is muted
+	testModel.MuteChannel("user1", "channel1")
+	userInfo := testModel.GetUserInfo("user1")
+	if len(userInfo.MutedChannels) != 1 || userInfo.MutedChannels[0] != "channel1" {
+		t.Error("Failed to mute channel1 for user1")
+	}
+
+	if !testModel.IsChannelMuted("user1", "channel1") || testModel.IsChannelMuted("user1", "General") {
+		t.Error("Incorrect muted state for user1")
+	}
+
+	// Attempt to mute channel1 again and ensure it's not added twice
+	testModel.MuteChannel("user1", "channel1")
+	userInfo = testModel.GetUserInfo("user1")
+	if len(userInfo.MutedChannels) != 1 {
+		t.Error("Failed to mute channel1 for user1")
+	}
+
+	// Unmute channel1 and verify that it is unmuted
+	testModel.UnmuteChannel("user1", "channel1")
+	userInfo = testModel.GetUserInfo("user1")
+	if len(userInfo.MutedChannels) != 0 || testModel.IsChannelMuted("user1", "channel1") {
+		t.Error("Failed to unmute channel1 for user1")
+	}
+
+	// Attempt to unmute channel1 again and ensure it's rejected
+	if err := testModel.UnmuteChannel("user1", "channel1"); err != model.ErrNotMuted {
+		t.Error("Incorrect error unmuting a channel that isn't muted")
+	}
+
+	// Mute channel1 again, delete it, and ensure the muted list is cleaned up
+	testModel.MuteChannel("user1", "channel1")
+	testModel.DeleteChannel("channel1")
+	userInfo = testModel.GetUserInfo("user1")
+	if len(userInfo.MutedChannels) != 0 {
+		t.Error("Failed to clean up muted channels on delete for user1")
+	}
+}
+
+func TestCreateChannelInputChecking(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	err = testModel.CreateChannel("")
+	channels := testModel.GetChannels()
+	if err != model.ErrInvalidName || len(channels) != 1 {
+		t.Error("Incorrect number of channels")
+	}
+
+	err = testModel.CreateChannel("channel 1")
+	channels = testModel.GetChannels()
+	if err != model.ErrInvalidName || len(channels) != 1 {
+		t.Error("Incorrect number of channels")
+	}
+
+	err = testModel.CreateChannel("General")
+	channels = testModel.GetChannels()
+	if err != model.ErrChannelExists || len(channels) != 1 {
+		t.Error("Incorrect number of channels")
+	}
+}
+
+func TestCreateAndDeleteChannel(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	// Create a single channel and verify that it is added
+	testModel.CreateChannel("channel1")
+	channels := testModel.GetChannels()
+	if len(channels) != 2 {
+		t.Error("Incorrect number of channels")
+	}
+
+	if _, ok := channels["channel1"]; !ok {
+		t.Error("Failed to create channel1")
+	}
+
+	// Create another channel with the same name and verify that it is not added again
+	testModel.CreateChannel("channel1")
+	channels = testModel.GetChannels()
+	if len(channels) != 2 {
+		t.Error("Incorrect number of channels")
+	}
+
+	if _, ok := channels["channel1"]; !ok {
+		t.Error("Failed to create channel1")
+	}
+
+	// Delete the channel and verify that it is deleted
+	testModel.DeleteChannel("channel1")
+	channels = testModel.GetChannels()
+	if len(channels) != 1 {
+		t.Error("Incorrect number of channels")
+	}
+
+	if _, ok := channels["channels"]; ok {
+		t.Error("Failed to delete channel1")
+	}
+
+	// Delete the channel again and verify that it is not deleted again
+	testModel.DeleteChannel("channel1")
+	channels = testModel.GetChannels()
+	if len(channels) != 1 {
+		t.Error("Incorrect number of channels")
+	}
+
+	if _, ok := channels["channel1"]; ok {
+		t.Error("Failed to delete channel1")
+	}
+}
+
+func TestCreateAndDeleteGeneralChannel(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	// Ensure that we can't create or delete the General channel
+	testModel.CreateChannel("General")
+	channels := testModel.GetChannels()
+	if len(channels) != 1 {
+		t.Error("Incorrect number of channels")
+	}
+
+	if _, ok := channels["General"]; !ok {
+		t.Error("Messed up General channel")
+	}
+
+	testModel.DeleteChannel("General")
+	channels = testModel.GetChannels()
+	if len(channels) != 1 {
+		t.Error("Incorrect number of channels")
+	}
+
+	if _, ok := channels["General"]; !ok {
 		t.Error("Messed up General channel")
 	}
 }
 
-func TestGetChannelInfo(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+func TestGetChannelInfo(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	channelInfo := testModel.GetChannelInfo("channel1")
+	if channelInfo.Name != "" {
+		t.Error("Failed to return empty channel info")
+	}
+
+	channelInfo = testModel.GetChannelInfo("General")
+	if channelInfo.Name != "General" {
+		t.Error("Failed to return General channel info")
+	}
+}
+
+func TestCreatingAndDeletingMultipleChannels(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+	testModel.CreateChannel("channel3")
+	testModel.CreateChannel("channel4")
+	testModel.CreateChannel("channel5")
+
+	channels := testModel.GetChannels()
+	if len(channels) != 6 {
+		t.Error("Failed to create 5 channels")
+	}
+
+	testModel.DeleteChannel("channel2")
+	testModel.DeleteChannel("channel4")
+	testModel.DeleteChannel("channel5")
+
+	channels = testModel.GetChannels()
+	if len(channels) != 3 {
+		t.Error("Failed to delete 3 channels")
+	}
+
+	channel1Info := testModel.GetChannelInfo("channel1")
+	if channel1Info.Name != "channel1" || channel1Info.NumMessages != 0 {
+		t.Error("Messed up channel1 info")
+	}
+
+	channel3Info := testModel.GetChannelInfo("channel3")
+	if channel3Info.Name != "channel3" || channel3Info.NumMessages != 0 {
+		t.Error("Messed up channel3 info")
+	}
+}
+
+func TestJoiningAndLeavingChannels(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	// New users are members of General by default
+	testModel.CreateUser("user1")
+	joinedChannels := testModel.GetJoinedChannels("user1")
+	if _, ok := joinedChannels["General"]; !ok || len(joinedChannels) != 1 {
+		t.Error("New user didn't join General")
+	}
+
+	// Disregard joins for unknown users/channels
+	testModel.JoinChannel("user2", "General")
+	joinedChannels = testModel.GetJoinedChannels("user2")
+	if len(joinedChannels) != 0 {
+		t.Error("Failed to disregard join for unknown user")
+	}
+
+	testModel.JoinChannel("user1", "channel1")
+	joinedChannels = testModel.GetJoinedChannels("user1")
+	if len(joinedChannels) != 1 {
+		t.Error("Failed to disregard join for unknown channel")
+	}
+
+	// Join and leave a channel
+	testModel.CreateChannel("channel1")
+	publicChannels := testModel.GetPublicChannels()
+	if _, ok := publicChannels["channel1"]; !ok || len(publicChannels) != 2 {
+		t.Error("Incorrect public channels")
+	}
+
+	testModel.JoinChannel("user1", "channel1")
+	joinedChannels = testModel.GetJoinedChannels("user1")
+	if _, ok := joinedChannels["channel1"]; !ok || len(joinedChannels) != 2 {
+		t.Error("Failed to join channel1")
+	}
+
+	members := testModel.GetChannelMembers("channel1")
+	if _, ok := members["user1"]; !ok || len(members) != 1 {
+		t.Error("Incorrect channel1 members")
+	}
+
+	testModel.LeaveChannel("user1", "channel1")
+	joinedChannels = testModel.GetJoinedChannels("user1")
+	if _, ok := joinedChannels["channel1"]; ok || len(joinedChannels) != 1 {
+		t.Error("Failed to leave channel1")
+	}
+
+	if len(testModel.GetChannelMembers("channel1")) != 0 || len(testModel.GetChannelMembers("channel2")) != 0 {
+		t.Error("Incorrect members after leaving channel1 (or for an unknown channel)")
+	}
+
+	// Deleting a user removes their memberships
+	testModel.JoinChannel("user1", "channel1")
+	testModel.DeleteUser("user1")
+	testModel.CreateUser("user1")
+	joinedChannels = testModel.GetJoinedChannels("user1")
+	if _, ok := joinedChannels["channel1"]; ok {
+		t.Error("Failed to clean up memberships on user delete")
+	}
+}
+
+func TestBrowseChannels(t *testing.T) {
+	now := time.Now()
+	testModel, err := model.NewModel(model.Options{Clock: func() time.Time { return now }}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+	testModel.SetChannelTopic("channel1", "topic1")
+	testModel.SetChannelTopic("channel3", "topic3")
+	testModel.JoinChannel("user1", "channel1")
+
+	// Channels without activity are ordered by name
+	channelInfos := testModel.BrowseChannels()
+	if len(channelInfos) != 3 || channelInfos[0].Name != "General" || channelInfos[1].Name != "channel1" || channelInfos[2].Name != "channel2" {
+		t.Error("Incorrect channel ordering without activity")
+	}
+
+	if channelInfos[1].Topic != "topic1" || channelInfos[1].NumMembers != 1 {
+		t.Error("Incorrect channel info for channel1")
+	}
+
+	if channelInfos[0].NumMembers != 2 {
+		t.Error("Incorrect member count for General")
+	}
+
+	// Posting moves a channel to the front
+	timestamp := now
+	testModel.PostMessage("channel2", "user1", time.Time{}, "message1")
+	now = now.Add(time.Second)
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message2")
+
+	channelInfos = testModel.BrowseChannels()
+	if channelInfos[0].Name != "channel1" || channelInfos[1].Name != "channel2" || channelInfos[2].Name != "General" {
+		t.Error("Incorrect channel ordering with activity")
+	}
+
+	if !channelInfos[1].LastActivity.Equal(timestamp) {
+		t.Error("Incorrect last activity for channel2")
+	}
+}
+
+func TestChannelRules(t *testing.T) {
+	options := model.Options{
+		LanguageFilters: map[string]model.MessageFilter{
+			"en": model.NewWordListFilter([]string{"darn"}),
+		},
+	}
+	testModel, err := model.NewModel(options, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	testModel.CreateChannel("channel1")
+
+	// Invalid input is ignored
+	testModel.SetChannelRules("channel2", "en", "rules1")
+	testModel.SetChannelRules("channel1", "e n", "rules1")
+	channelInfo := testModel.GetChannelInfo("channel1")
+	if channelInfo.Language != "" || channelInfo.Rules != "" {
+		t.Error("Invalid channel rules were set")
+	}
+
+	testModel.SetChannelRules("channel1", "en", "be nice")
+	channelInfo = testModel.GetChannelInfo("channel1")
+	if channelInfo.Language != "en" || channelInfo.Rules != "be nice" {
+		t.Error("Channel rules weren't set")
+	}
+
+	// Messages are filtered by the channel language's filter
+	testModel.PostMessage("channel1", "user1", time.Now(), "Darn, it broke")
+	testModel.PostMessage("General", "user1", time.Now(), "darn it")
+	history := testModel.GetChannelHistory("channel1", "user1", -1)
+	if len(history) != 1 || history[0].Text != "****, it broke" {
+		t.Error("Message wasn't filtered")
+	}
+
+	history = testModel.GetChannelHistory("General", "user1", -1)
+	if len(history) != 1 || history[0].Text != "darn it" {
+		t.Error("Message was filtered in a channel without a language filter")
+	}
+}
+
+func TestGetChannelHistoryInputChecking(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	messages := testModel.GetChannelHistory("", "Anonymous", -1)
+	if len(messages) != 0 {
+		t.Error("Failed to disregard GetChannelHistory for unknown channel")
+	}
+
+	messages = testModel.GetChannelHistory("General", "", -1)
+	if len(messages) != 0 {
+		t.Error("Failed to disregard GetChannelHistory for unknown user")
+	}
+}
+
+func TestPostMessageInputChecking(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	_, err = testModel.PostMessage("", "Anonymous", time.Now(), "message1")
+	channelInfo := testModel.GetChannelInfo("General")
+	if err != model.ErrChannelNotFound || channelInfo.NumMessages != 0 {
+		t.Error("Failed to disregard PostMessage for unknown channel")
+	}
+
+	_, err = testModel.PostMessage("General", "", time.Now(), "message1")
+	channelInfo = testModel.GetChannelInfo("General")
+	if err != model.ErrUserNotFound || channelInfo.NumMessages != 0 {
+		t.Error("Failed to disregard PostMessage for unknown user")
+	}
+
+	_, err = testModel.PostMessage("General", "Anonymous", time.Now(), "")
+	channelInfo = testModel.GetChannelInfo("General")
+	if err != model.ErrEmptyMessage || channelInfo.NumMessages != 0 {
+		t.Error("Failed to disregard PostMessage for empty message")
+	}
+}
+
+func TestPostMessage(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+
+	testModel.PostMessage("channel1", "user1", time.Now(), "message1")
+	testModel.PostMessage("channel1", "user1", time.Now(), "message2")
+	testModel.PostMessage("channel1", "user1", time.Now(), "message3")
+	testModel.PostMessage("channel1", "user1", time.Now(), "message4")
+
+	channel1Info := testModel.GetChannelInfo("channel1")
+	if channel1Info.NumMessages != 4 {
+		t.Error("Failed to count message after PostMessage")
+	}
+
+	// Ensure that we get the newest messages
+	messages := testModel.GetChannelHistory("channel1", "Anonymous", 1)
+	if len(messages) != 1 || messages[0].Username != "user1" || messages[0].Text != "message4" {
+		t.Error("Failed to get message after PostMessage")
+	}
+
+	// Ensure that we can get all of the messages
+	messages = testModel.GetChannelHistory("channel1", "Anonymous", 5)
+	if len(messages) != 4 {
+		t.Error("Failed to get multiple messages after PostMessage")
+	}
+
+	if messages[0].Text != "message1" || messages[1].Text != "message2" || messages[2].Text != "message3" || messages[3].Text != "message4" {
+		t.Error("Failed to get correct messages after PostMessage")
+	}
+
+	// Ensure that we can get all of the messages
+	messages = testModel.GetChannelHistory("channel1", "Anonymous", -1)
+	if len(messages) != 4 {
+		t.Error("Failed to get multiple messages after PostMessage")
+	}
+
+	if messages[0].Text != "message1" || messages[1].Text != "message2" || messages[2].Text != "message3" || messages[3].Text != "message4" {
+		t.Error("Failed to get correct messages after PostMessage")
+	}
+
+	// Ensure that the posted message is returned with the ID it has in the history
+	message, err := testModel.PostMessage("channel1", "user1", time.Now(), "message5")
+	if err != nil || message.ID <= messages[3].ID || message.Text != "message5" {
+		t.Error("Failed to return message from PostMessage")
+	}
+
+	messages = testModel.GetChannelHistory("channel1", "Anonymous", 1)
+	if len(messages) != 1 || messages[0].ID != message.ID {
+		t.Error("Failed to get message ID in channel history")
+	}
+}
+
+func TestGetChannelHistoryRange(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	testModel.PostMessage("channel2", "user1", time.Time{}, "message2")
+	testModel.PostMessage("channel1", "user2", time.Time{}, "message3")
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message4")
+
+	// Ensure that seqs are dense per channel
+	if testModel.GetChannelInfo("channel1").LastSeq != 3 || testModel.GetChannelInfo("channel2").LastSeq != 1 {
+		t.Error("Incorrect last seq in channel info")
+	}
+
+	messages := testModel.GetChannelHistoryRange("channel1", "Anonymous", 2, 3)
+	if len(messages) != 2 || messages[0].Seq != 2 || messages[0].Text != "message3" || messages[1].Seq != 3 {
+		t.Error("Failed to get channel history range")
+	}
+
+	// Ensure that out of range seqs are clamped
+	messages = testModel.GetChannelHistoryRange("channel1", "Anonymous", 0, 100)
+	if len(messages) != 3 || messages[0].Seq != 1 {
+		t.Error("Failed to clamp channel history range")
+	}
+
+	messages = testModel.GetChannelHistoryRange("channel1", "Anonymous", 3, 2)
+	if len(messages) != 0 {
+		t.Error("Got messages for empty channel history range")
+	}
+
+	// Ensure that blocked users' messages are left out
+	testModel.BlockUser("user1", "user2")
+	messages = testModel.GetChannelHistoryRange("channel1", "user1", 1, 3)
+	if len(messages) != 2 || messages[0].Seq != 1 || messages[1].Seq != 3 {
+		t.Error("Failed to filter blocked user from channel history range")
+	}
+}
+
+func TestGetChannelHistoryByTime(t *testing.T) {
+	now := time.Now()
+	testModel, err := model.NewModel(model.Options{Clock: func() time.Time { return now }}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+
+	start := now
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	now = now.Add(time.Hour)
+	testModel.PostMessage("channel1", "user2", time.Time{}, "message2")
+	now = now.Add(time.Hour)
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message3")
+
+	messages := testModel.GetChannelHistoryByTime("channel1", "Anonymous", start.Add(time.Hour), start.Add(2*time.Hour))
+	if len(messages) != 1 || messages[0].Text != "message2" {
+		t.Error("Failed to get channel history by time")
+	}
+
+	// Ensure that a zero until time gets everything since the from time
+	messages = testModel.GetChannelHistoryByTime("channel1", "Anonymous", start.Add(time.Minute), time.Time{})
+	if len(messages) != 2 || messages[0].Text != "message2" || messages[1].Text != "message3" {
+		t.Error("Failed to get channel history since time")
+	}
+
+	// Ensure that blocked users' messages are left out
+	testModel.BlockUser("user1", "user2")
+	messages = testModel.GetChannelHistoryByTime("channel1", "user1", start, time.Time{})
+	if len(messages) != 2 || messages[0].Text != "message1" || messages[1].Text != "message3" {
+		t.Error("Failed to filter blocked user from channel history by time")
+	}
+
+	if len(testModel.GetChannelHistoryByTime("channel2", "user1", start, time.Time{})) != 0 {
+		t.Error("Got channel history by time for channel that doesn't exist")
+	}
+
+	// Ensure that we can find the first message since a time
+	message, ok := testModel.GetFirstMessageSince("channel1", start.Add(time.Minute))
+	if !ok || message.Seq != 2 || message.Text != "message2" {
+		t.Error("Failed to get first message since time")
+	}
+
+	if _, ok := testModel.GetFirstMessageSince("channel1", now.Add(time.Minute)); ok {
+		t.Error("Got first message since time after the last message")
+	}
+}
+
+func TestGetTopPosters(t *testing.T) {
+	now := time.Now()
+	testModel, err := model.NewModel(model.Options{Clock: func() time.Time { return now }}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.CreateUser("user3")
+
+	// Older messages only count for longer periods
+	testModel.ImportMessage("channel1", model.Message{Username: "user3", Timestamp: now.AddDate(0, 0, -10), Text: "message1"})
+	testModel.ImportMessage("channel1", model.Message{Username: "user3", Timestamp: now.AddDate(0, 0, -10), Text: "message2"})
+	testModel.ImportMessage("channel1", model.Message{Username: "user3", Timestamp: now.AddDate(0, 0, -10), Text: "message3"})
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message4")
+	testModel.PostMessage("channel1", "user2", time.Time{}, "message5")
+	testModel.PostMessage("channel1", "user2", time.Time{}, "message6")
+
+	week, _ := model.PeriodSince("week", now)
+	posters := testModel.GetTopPosters("channel1", week, -1)
+	if len(posters) != 2 || posters[0].Username != "user2" || posters[0].NumMessages != 2 || posters[1].Username != "user1" {
+		t.Error("Failed to get top posters for week")
+	}
+
+	posters = testModel.GetTopPosters("channel1", time.Time{}, 1)
+	if len(posters) != 1 || posters[0].Username != "user3" || posters[0].NumMessages != 3 {
+		t.Error("Failed to get top poster for all time")
+	}
+
+	// Ensure that deleted users are no longer counted
+	testModel.DeleteUser("user3")
+	posters = testModel.GetTopPosters("channel1", time.Time{}, -1)
+	if len(posters) != 2 || posters[0].Username != "user2" {
+		t.Error("Failed to remove deleted user from top posters")
+	}
+
+	if _, ok := model.PeriodSince("year", now); ok {
+		t.Error("Accepted unknown period")
+	}
+}
+
+func TestPostMessageOnce(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+
+	// Ensure that posted messages get increasing IDs
+	message1, err := testModel.PostMessageOnce("channel1", "user1", time.Now(), "message1", "key1")
+	if err != nil || message1.ID == 0 || message1.Text != "message1" {
+		t.Error("Failed to post message with PostMessageOnce")
+	}
+
+	message2, err := testModel.PostMessageOnce("channel1", "user1", time.Now(), "message2", "")
+	if err != nil || message2.ID <= message1.ID {
+		t.Error("Failed to assign increasing message IDs")
+	}
+
+	// Ensure that retrying with the same key returns the original message without posting again
+	retried, err := testModel.PostMessageOnce("channel1", "user1", time.Now(), "message1", "key1")
+	if err != nil || retried.ID != message1.ID || !retried.Timestamp.Equal(message1.Timestamp) {
+		t.Error("Failed to return original message for retried idempotency key")
+	}
+
+	if testModel.GetChannelInfo("channel1").NumMessages != 2 {
+		t.Error("Posted message again for retried idempotency key")
+	}
+
+	// Ensure that keys are per user
+	_, err = testModel.PostMessageOnce("channel1", "user2", time.Now(), "message3", "key1")
+	if err != nil || testModel.GetChannelInfo("channel1").NumMessages != 3 {
+		t.Error("Failed to post message with another user's idempotency key")
+	}
+
+	// Ensure that rejected messages aren't remembered
+	_, err = testModel.PostMessageOnce("channel2", "user1", time.Now(), "message4", "key2")
+	if err != model.ErrChannelNotFound {
+		t.Error("Posted message to channel that doesn't exist")
+	}
+
+	testModel.CreateChannel("channel2")
+	_, err = testModel.PostMessageOnce("channel2", "user1", time.Now(), "message4", "key2")
+	if err != nil || testModel.GetChannelInfo("channel2").NumMessages != 1 {
+		t.Error("Failed to post message after rejected idempotency key")
+	}
+
+	// Ensure that the history includes the IDs
+	messages := testModel.GetChannelHistory("channel1", "Anonymous", -1)
+	if len(messages) != 3 || messages[0].ID != message1.ID || messages[1].ID != message2.ID {
+		t.Error("Failed to get message IDs in channel history")
+	}
+
+	// Ensure that the keys are forgotten along with their channel or user
+	testModel.DeleteChannel("channel2")
+	testModel.CreateChannel("channel2")
+	_, err = testModel.PostMessageOnce("channel2", "user1", time.Now(), "message4", "key2")
+	if err != nil || testModel.GetChannelInfo("channel2").NumMessages != 1 {
+		t.Error("Kept the idempotency key of a deleted channel's message")
+	}
+
+	testModel.DeleteUser("user2")
+	testModel.CreateUser("user2")
+	_, err = testModel.PostMessageOnce("channel1", "user2", time.Now(), "message3", "key1")
+	if err != nil || testModel.GetChannelInfo("channel1").NumMessages != 4 {
+		t.Error("Kept the idempotency key of a deleted user")
+	}
+}
+
+func TestDuplicateMessages(t *testing.T) {
+	now := time.Now()
+	options := model.Options{Clock: func() time.Time { return now }, DuplicateWindow: 10 * time.Second}
+	testModel, err := model.NewModel(options, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+
+	// Ensure that a duplicate is collapsed into the earlier message
+	message1, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	duplicate, err := testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	if err != nil || duplicate.ID != message1.ID || testModel.GetChannelInfo("channel1").NumMessages != 1 {
+		t.Error("Failed to collapse duplicate message")
+	}
+
+	// Ensure that the same text from another user, or as a snippet, isn't a duplicate
+	testModel.PostMessage("channel1", "user2", time.Time{}, "message1")
+	testModel.PostSnippet("channel1", "user1", time.Time{}, "go", "message1")
+	if testModel.GetChannelInfo("channel1").NumMessages != 3 {
+		t.Error("Collapsed message that isn't a duplicate")
+	}
+
+	// Ensure that only the user's last message counts, and only within the window
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	now = now.Add(11 * time.Second)
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	if testModel.GetChannelInfo("channel1").NumMessages != 5 {
+		t.Error("Collapsed message that isn't a duplicate")
+	}
+
+	// Ensure that the built-in user (which everyone shares) never posts duplicates
+	testModel.PostMessage("channel1", "Anonymous", time.Time{}, "message2")
+	testModel.PostMessage("channel1", "Anonymous", time.Time{}, "message2")
+	if testModel.GetChannelInfo("channel1").NumMessages != 7 {
+		t.Error("Collapsed built-in user's message")
+	}
+
+	// Ensure that duplicates can be rejected instead
+	options.RejectDuplicates = true
+	testModel, err = model.NewModel(options, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	if _, err := testModel.PostMessage("channel1", "user1", time.Time{}, "message1"); err != model.ErrDuplicateMessage {
+		t.Error("Failed to reject duplicate message")
+	}
+}
+
+func TestPostMessageMulti(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+
+	// Ensure that a cross-post needs at least two different channels that all exist, and that
+	// nothing is posted if it's rejected
+	if _, err := testModel.PostMessageMulti([]string{"channel1"}, "user1", time.Time{}, "message1"); err != model.ErrInvalidCrossPost {
+		t.Error("Cross-posted to a single channel")
+	}
+	if _, err := testModel.PostMessageMulti([]string{"channel1", "channel1"}, "user1", time.Time{}, "message1"); err != model.ErrInvalidCrossPost {
+		t.Error("Cross-posted to the same channel twice")
+	}
+	if _, err := testModel.PostMessageMulti([]string{"channel1", "channel3"}, "user1", time.Time{}, "message1"); err != model.ErrChannelNotFound {
+		t.Error("Cross-posted to a channel that doesn't exist")
+	}
+	if _, err := testModel.PostMessageMulti([]string{"channel1", "channel2"}, "user3", time.Time{}, "message1"); err != model.ErrUserNotFound {
+		t.Error("Cross-posted for a user that doesn't exist")
+	}
+	if testModel.GetChannelInfo("channel1").NumMessages != 0 {
+		t.Error("Posted part of a rejected cross-post")
+	}
+
+	// Ensure that the copies share their identity
+	messages, err := testModel.PostMessageMulti([]string{"channel1", "channel2"}, "user1", time.Time{}, "message1")
+	if err != nil || len(messages) != 2 || messages[0].CrossPost != messages[0].ID || messages[1].CrossPost != messages[0].ID ||
+		messages[1].Text != "message1" || !messages[1].Timestamp.Equal(messages[0].Timestamp) {
+		t.Error("Failed to cross-post message")
+	}
+
+	// Ensure that editing a copy edits them all, but only by the author
+	if testModel.EditMessage("channel2", messages[1].ID, "user2", "message2") != model.ErrNotAuthor {
+		t.Error("Edited another user's cross-post")
+	}
+	if testModel.EditMessage("channel2", messages[1].ID, "user1", "message2") != nil {
+		t.Error("Failed to edit cross-post")
+	}
+	message, _ := testModel.GetMessage("channel1", "user1", messages[0].ID)
+	if message.Text != "message2" || message.Edited.IsZero() {
+		t.Error("Failed to edit the other copies of a cross-post")
+	}
+
+	// Ensure that the copies are linked after a snapshot is restored
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+
+	// Ensure that deleting a copy deletes them all (as a moderator too)
+	for _, deletingModel := range []*model.Model{testModel, restored} {
+		if deletingModel.DeleteMessage("channel1", messages[0].ID, "user1") != nil {
+			t.Error("Failed to delete cross-post")
+		}
+		message, _ = deletingModel.GetMessage("channel2", "user1", messages[1].ID)
+		if !message.Deleted || message.Text != "" {
+			t.Error("Failed to delete the other copies of a cross-post")
+		}
+	}
+
+	messages, _ = testModel.PostMessageMulti([]string{"channel1", "channel2"}, "user1", time.Time{}, "message3")
+	testModel.ModerateMessage("channel2", messages[1].ID)
+	if message, _ := testModel.GetMessage("channel1", "user1", messages[0].ID); !message.Deleted {
+		t.Error("Failed to moderate the other copies of a cross-post")
+	}
+
+	// Ensure that a plain message isn't linked to the messages around it
+	message, _ = testModel.PostMessage("channel1", "user1", time.Time{}, "message4")
+	testModel.PostMessage("channel2", "user1", time.Time{}, "message4")
+	testModel.EditMessage("channel1", message.ID, "user1", "message5")
+	if history := testModel.GetChannelHistory("channel2", "user1", 1); message.CrossPost != 0 || history[0].Text != "message4" {
+		t.Error("Linked message that wasn't cross-posted")
+	}
+}
+
+func TestReadOnlyChannels(t *testing.T) {
+	options := model.Options{ReadOnlyChannels: map[string]string{"channel1": "user1"}}
+	testModel, err := model.NewModel(options, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+
+	// Ensure that only the channel's poster can post to it
+	if _, err := testModel.PostMessage("channel1", "user2", time.Time{}, "message1"); err != model.ErrChannelReadOnly {
+		t.Error("Posted to a read-only channel")
+	}
+	if _, err := testModel.PostMessageMulti([]string{"channel2", "channel1"}, "user2", time.Time{}, "message1"); err != model.ErrChannelReadOnly {
+		t.Error("Cross-posted to a read-only channel")
+	}
+	if _, err := testModel.PostMessage("channel1", "user1", time.Time{}, "message1"); err != nil {
+		t.Error("Failed to post to a read-only channel as its poster")
+	}
+	if _, err := testModel.PostMessage("channel2", "user2", time.Time{}, "message1"); err != nil {
+		t.Error("Failed to post to a writable channel")
+	}
+
+	// Ensure that imported messages were accepted when first posted
+	if testModel.ImportMessage("channel1", model.Message{Username: "user2", Timestamp: time.Now(), Text: "message2"}) != nil {
+		t.Error("Failed to import message to a read-only channel")
+	}
+}
+
+func TestMessageTimestamps(t *testing.T) {
+	replica, err := model.NewModel(model.Options{TrustTimestamps: true}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create replica")
+	}
+
+	now := time.Now()
+	testModel, err := model.NewModel(model.Options{Clock: func() time.Time { return now }}, nil, replica.Actor(), nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+
+	if !testModel.Now().Equal(now) {
+		t.Error("Failed to use the clock for the current time")
+	}
+
+	// Ensure that the model assigns the timestamp, keeping the claimed one only if it's skewed
+	testModel.PostMessage("channel1", "user1", now.Add(time.Second), "message1")
+	testModel.PostMessage("channel1", "user1", now.Add(-time.Hour), "message2")
+
+	// Ensure that timestamps never go backwards within a channel
+	now = now.Add(-time.Minute)
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message3")
+
+	messages := testModel.GetChannelHistory("channel1", "Anonymous", -1)
+	if len(messages) != 3 {
+		t.Error("Failed to post messages")
+		return
+	}
+
+	if !messages[0].Timestamp.Equal(now.Add(time.Minute)) || !messages[0].ClaimedTimestamp.IsZero() {
+		t.Error("Failed to assign timestamp to message")
+	}
+
+	if !messages[1].Timestamp.Equal(now.Add(time.Minute)) || !messages[1].ClaimedTimestamp.Equal(now.Add(time.Minute-time.Hour)) {
+		t.Error("Failed to keep skewed claimed timestamp")
+	}
+
+	if messages[2].Timestamp.Before(messages[1].Timestamp) {
+		t.Error("Message timestamp went backwards when the clock stepped back")
+	}
+
+	// Ensure that the claimed timestamp is logged (so the replicas have it), and kept in snapshots
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+
+	for _, other := range []*model.Model{replica, restored} {
+		otherMessages := other.GetChannelHistory("channel1", "Anonymous", -1)
+		if len(otherMessages) != 3 || !otherMessages[1].ClaimedTimestamp.Equal(messages[1].ClaimedTimestamp) ||
+			!otherMessages[0].ClaimedTimestamp.IsZero() {
+			t.Error("Failed to keep claimed timestamp")
+		}
+	}
+
+	// Ensure that imported messages keep their timestamps
+	imported := now.Add(-24 * time.Hour)
+	testModel.ImportMessage("channel1", model.Message{Username: "user1", Timestamp: imported, Text: "message4"})
+	messages = testModel.GetChannelHistory("channel1", "Anonymous", 1)
+	if len(messages) != 1 || !messages[0].Timestamp.Equal(imported) {
+		t.Error("Failed to keep timestamp of imported message")
+	}
+}
+
+func TestEditMessage(t *testing.T) {
+	now := time.Now()
+	options := model.Options{
+		Clock:           func() time.Time { return now },
+		LanguageFilters: map[string]model.MessageFilter{"en": model.NewWordListFilter([]string{"darn"})},
+	}
+	testModel, err := model.NewModel(options, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	message, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+
+	// Ensure that only the author can edit an existing message
+	if err := testModel.EditMessage("channel2", message.ID, "user1", "message2"); err != model.ErrChannelNotFound {
+		t.Error("Incorrect error editing a message in a nonexistent channel")
+	}
+
+	if err := testModel.EditMessage("channel1", message.ID, "user3", "message2"); err != model.ErrUserNotFound {
+		t.Error("Incorrect error editing a message as a nonexistent user")
+	}
+
+	if err := testModel.EditMessage("channel1", message.ID+1, "user1", "message2"); err != model.ErrMessageNotFound {
+		t.Error("Incorrect error editing a nonexistent message")
+	}
+
+	if err := testModel.EditMessage("General", message.ID, "user1", "message2"); err != model.ErrMessageNotFound {
+		t.Error("Incorrect error editing a message in another channel")
+	}
+
+	if err := testModel.EditMessage("channel1", message.ID, "user2", "message2"); err != model.ErrNotAuthor {
+		t.Error("Incorrect error editing another user's message")
+	}
+
+	if err := testModel.EditMessage("channel1", message.ID, "user1", ""); err != model.ErrEmptyMessage {
+		t.Error("Incorrect error emptying a message")
+	}
+
+	if edited, _ := testModel.GetMessage("channel1", "user1", message.ID); edited.Text != "message1" || !edited.Edited.IsZero() {
+		t.Error("Rejected edit changed the message")
+	}
+
+	// Ensure that the edit replaces the text (filtered like a post) and marks when it was made
+	testModel.SetChannelRules("channel1", "en", "")
+	now = now.Add(time.Minute)
+	err = testModel.EditMessage("channel1", message.ID, "user1", "darn message2")
+	if err != nil {
+		t.Error(err)
+	}
+
+	edited, ok := testModel.GetMessage("channel1", "user2", message.ID)
+	if !ok || edited.Text != "**** message2" || !edited.Edited.Equal(now) || !edited.Timestamp.Equal(message.Timestamp) || edited.Seq != message.Seq {
+		t.Error("Failed to edit message")
+	}
+
+	messages := testModel.GetChannelHistory("channel1", "user2", -1)
+	if len(messages) != 1 || messages[0].Text != "**** message2" {
+		t.Error("Edited message not in the channel history")
+	}
+
+	// Ensure that messages from blocked users aren't returned
+	testModel.BlockUser("user2", "user1")
+	if _, ok := testModel.GetMessage("channel1", "user2", message.ID); ok {
+		t.Error("Got message from a blocked user")
+	}
+}
+
+func TestDeleteMessage(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	message1, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	message2, _ := testModel.PostMessage("channel1", "user2", time.Time{}, "message2")
+	message3, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message3")
+
+	// Ensure that only the author can delete an existing message
+	if err := testModel.DeleteMessage("channel2", message1.ID, "user1"); err != model.ErrChannelNotFound {
+		t.Error("Incorrect error deleting a message in a nonexistent channel")
+	}
+
+	if err := testModel.DeleteMessage("channel1", message1.ID, ""); err != model.ErrUserNotFound {
+		t.Error("Incorrect error deleting a message without a user")
+	}
+
+	if err := testModel.DeleteMessage("channel1", message3.ID+1, "user1"); err != model.ErrMessageNotFound {
+		t.Error("Incorrect error deleting a nonexistent message")
+	}
+
+	if err := testModel.DeleteMessage("channel1", message1.ID, "user2"); err != model.ErrNotAuthor {
+		t.Error("Incorrect error deleting another user's message")
+	}
+
+	// Ensure that a deleted message leaves a tombstone (so the seqs have no gaps)
+	err = testModel.DeleteMessage("channel1", message1.ID, "user1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	messages := testModel.GetChannelHistory("channel1", "user2", -1)
+	if len(messages) != 3 || !messages[0].Deleted || messages[0].Text != "" || messages[0].Seq != 1 || messages[1].Deleted || messages[1].Text != "message2" {
+		t.Error("Failed to delete message")
+	}
+
+	if testModel.GetChannelInfo("channel1").LastSeq != 3 {
+		t.Error("Deleting a message changed the channel's seqs")
+	}
+
+	// Ensure that a deleted message can't be deleted or edited again
+	if err := testModel.DeleteMessage("channel1", message1.ID, "user1"); err != model.ErrMessageNotFound {
+		t.Error("Incorrect error deleting a deleted message")
+	}
+
+	if err := testModel.EditMessage("channel1", message1.ID, "user1", "message4"); err != model.ErrMessageNotFound {
+		t.Error("Incorrect error editing a deleted message")
+	}
+
+	// Ensure that a moderator can delete any message
+	err = testModel.ModerateMessage("channel1", message2.ID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if deleted, ok := testModel.GetMessage("channel1", "user1", message2.ID); !ok || !deleted.Deleted {
+		t.Error("Failed to moderate message")
+	}
+
+	if err := testModel.ModerateMessage("channel1", message2.ID); err != model.ErrMessageNotFound {
+		t.Error("Incorrect error moderating a deleted message")
+	}
+}
+
+func TestAttachFile(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	message1, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	message2, _ := testModel.PostMessage("channel2", "user1", time.Time{}, "message2")
+	attachment1 := model.Attachment{ID: strings.Repeat("a", 64), Name: "file1.png", ContentType: "image/png", Size: 100}
+	attachment2 := model.Attachment{ID: strings.Repeat("b", 64), Name: "file2.txt", ContentType: "text/plain", Size: 10}
+
+	// Ensure that only the author can attach a valid file to an existing message
+	if err := testModel.AttachFile("channel3", message1.ID, "user1", attachment1); err != model.ErrChannelNotFound {
+		t.Error("Incorrect error attaching to a message in a nonexistent channel")
+	}
+
+	if err := testModel.AttachFile("channel1", message1.ID+10, "user1", attachment1); err != model.ErrMessageNotFound {
+		t.Error("Incorrect error attaching to a nonexistent message")
+	}
+
+	if err := testModel.AttachFile("channel1", message1.ID, "user2", attachment1); err != model.ErrNotAuthor {
+		t.Error("Incorrect error attaching to another user's message")
+	}
+
+	if err := testModel.AttachFile("channel1", message1.ID, "user1", model.Attachment{ID: "../file", Name: "file"}); err != model.ErrInvalidAttachment {
+		t.Error("Incorrect error attaching an invalid file")
+	}
+
+	// The same file can be attached to several messages, and is counted once for each of them
+	if testModel.AttachFile("channel1", message1.ID, "user1", attachment1) != nil ||
+		testModel.AttachFile("channel1", message1.ID, "user1", attachment2) != nil ||
+		testModel.AttachFile("channel2", message2.ID, "user1", attachment1) != nil {
+		t.Fatal("Failed to attach files")
+	}
+
+	messages := testModel.GetChannelHistory("channel1", "user2", -1)
+	if len(messages) != 1 || len(messages[0].Attachments) != 2 || messages[0].Attachments[0] != attachment1 || messages[0].Attachments[1] != attachment2 {
+		t.Error("Failed to attach files to message")
+	}
+
+	refs := testModel.GetAttachmentRefs()
+	if len(refs) != 2 || refs[attachment1.ID] != 2 || refs[attachment2.ID] != 1 {
+		t.Error("Incorrect attachment references")
+	}
+
+	// Deleted messages (and channels, once they can't be restored) no longer refer to their files
+	testModel.DeleteMessage("channel1", message1.ID, "user1")
+	if messages := testModel.GetChannelHistory("channel1", "user2", -1); len(messages[0].Attachments) != 0 {
+		t.Error("Deleted message kept its attachments")
+	}
+
+	testModel.DeleteChannel("channel2")
+	refs = testModel.GetAttachmentRefs()
+	if len(refs) != 1 || refs[attachment1.ID] != 1 {
+		t.Error("Incorrect attachment references after deleting")
+	}
+}
+
+func TestDirectMessages(t *testing.T) {
+	testSubsEngine := NewTestSubsEngine()
+	testModel, err := model.NewModel(model.Options{}, nil, nil, testSubsEngine)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.CreateUser("user3")
+
+	// Ensure that only existing (non built-in) users can message each other
+	if _, err := testModel.PostDirectMessage("user1", "user4", time.Time{}, "direct1"); err != model.ErrUserNotFound {
+		t.Error("Incorrect error messaging a nonexistent user")
+	}
+
+	if _, err := testModel.PostDirectMessage("user1", "Anonymous", time.Time{}, "direct1"); err != model.ErrBuiltinUser {
+		t.Error("Incorrect error messaging the built-in user")
+	}
+
+	if _, err := testModel.PostDirectMessage("user1", "user1", time.Time{}, "direct1"); err != model.ErrMessageSelf {
+		t.Error("Incorrect error messaging yourself")
+	}
+
+	if _, err := testModel.PostDirectMessage("user1", "user2", time.Time{}, ""); err != model.ErrEmptyMessage {
+		t.Error("Incorrect error posting an empty direct message")
+	}
+
+	// Ensure that the conversation is shared by both users, with its own seqs
+	channelMessage, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	testSubsEngine.Reset()
+	direct1, err := testModel.PostDirectMessage("user1", "user2", time.Time{}, "direct1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	direct2, _ := testModel.PostDirectMessage("user2", "user1", time.Time{}, "direct2")
+	if direct1.Seq != 1 || direct2.Seq != 2 || direct1.ID <= channelMessage.ID || direct2.ID <= direct1.ID {
+		t.Error("Incorrect direct message seqs or IDs")
+	}
+
+	if testSubsEngine.DirectMessagesCalled != 2 || testSubsEngine.DirectMessagesUsername[0] != "user1" || testSubsEngine.DirectMessagesOther[0] != "user2" ||
+		testSubsEngine.MessageChangedCalled != 0 {
+		t.Error("Posting direct messages didn't notify the users")
+	}
+
+	for _, username := range []string{"user1", "user2"} {
+		otherUsername := "user2"
+		if username == "user2" {
+			otherUsername = "user1"
+		}
+
+		messages := testModel.GetDirectMessageHistory(username, otherUsername, -1)
+		if len(messages) != 2 || messages[0].Text != "direct1" || messages[1].Text != "direct2" {
+			t.Error("Failed to get direct messages for", username)
+		}
+	}
+
+	if messages := testModel.GetDirectMessageHistory("user1", "user2", 1); len(messages) != 1 || messages[0].Text != "direct2" {
+		t.Error("Failed to get latest direct message")
+	}
+
+	if messages := testModel.GetDirectMessageHistory("user3", "user1", -1); len(messages) != 0 {
+		t.Error("Got another conversation's direct messages")
+	}
+
+	if messages := testModel.GetChannelHistory("channel1", "user1", -1); len(messages) != 1 {
+		t.Error("Direct messages were posted to a channel")
+	}
+
+	// Ensure that the users' conversations are listed with their number of messages
+	testModel.PostDirectMessage("user3", "user1", time.Time{}, "direct3")
+	conversations := testModel.GetConversations("user1")
+	if len(conversations) != 2 || conversations["user2"] != 2 || conversations["user3"] != 1 {
+		t.Error("Incorrect conversations for user1")
+	}
+
+	if conversations := testModel.GetConversations("user2"); len(conversations) != 1 || conversations["user1"] != 2 {
+		t.Error("Incorrect conversations for user2")
+	}
+
+	// Ensure that blocked users' direct messages are filtered
+	testModel.BlockUser("user1", "user2")
+	if messages := testModel.GetDirectMessageHistory("user1", "user2", -1); len(messages) != 1 || messages[0].Text != "direct1" {
+		t.Error("Failed to filter blocked user's direct messages")
+	}
+
+	// Ensure that deleting a user deletes their conversations
+	testModel.DeleteUser("user2")
+	testModel.CreateUser("user2")
+	if messages := testModel.GetDirectMessageHistory("user2", "user1", -1); len(messages) != 0 {
+		t.Error("Deleted user's direct messages weren't deleted")
+	}
+
+	if conversations := testModel.GetConversations("user1"); len(conversations) != 1 || conversations["user3"] != 1 {
+		t.Error("Deleted user's conversation is still listed")
+	}
+}
+
+func TestGroups(t *testing.T) {
+	testSubsEngine := NewTestSubsEngine()
+	testModel, err := model.NewModel(model.Options{}, nil, nil, testSubsEngine)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.CreateUser("user3")
+	testModel.CreateUser("user4")
+
+	// Ensure that groups are only created between existing (non built-in) users
+	if _, err := testModel.CreateGroup("user1", []string{"user2", "user5"}); err != model.ErrUserNotFound {
+		t.Error("Incorrect error creating a group with a nonexistent user")
+	}
+
+	if _, err := testModel.CreateGroup("user1", []string{"user2", "Anonymous"}); err != model.ErrBuiltinUser {
+		t.Error("Incorrect error creating a group with the built-in user")
+	}
+
+	if _, err := testModel.CreateGroup("user1", []string{"user1"}); err != model.ErrGroupTooSmall {
+		t.Error("Incorrect error creating a group of one")
+	}
+
+	// Ensure that repeated members are ignored, and the members are notified
+	testSubsEngine.Reset()
+	groupID, err := testModel.CreateGroup("user1", []string{"user3", "user2", "user3"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if testSubsEngine.GroupChangedCalled != 1 || testSubsEngine.GroupChangedID[0] != groupID ||
+		strings.Join(testSubsEngine.GroupChangedMembers[0], " ") != "user1 user2 user3" || testSubsEngine.ChannelsChangedCalled != 0 {
+		t.Error("Creating a group didn't notify the members")
+	}
+
+	if len(testModel.GetChannels()) != 2 || len(testModel.BrowseChannels()) != 2 {
+		t.Error("Group was listed with the channels")
+	}
+
+	// Ensure that only the members can post, and the group has its own seqs
+	if _, err := testModel.PostGroupMessage(groupID+1, "user1", time.Time{}, "group1"); err != model.ErrGroupNotFound {
+		t.Error("Incorrect error posting to a nonexistent group")
+	}
+
+	if _, err := testModel.PostGroupMessage(groupID, "user4", time.Time{}, "group1"); err != model.ErrNotGroupMember {
+		t.Error("Incorrect error posting to a group by a non-member")
+	}
+
+	if _, err := testModel.PostGroupMessage(groupID, "user1", time.Time{}, ""); err != model.ErrEmptyMessage {
+		t.Error("Incorrect error posting an empty group message")
+	}
+
+	channelMessage, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	testSubsEngine.Reset()
+	group1, err := testModel.PostGroupMessage(groupID, "user1", time.Time{}, "group1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	group2, _ := testModel.PostGroupMessage(groupID, "user2", time.Time{}, "group2")
+	if group1.Seq != 1 || group2.Seq != 2 || group1.ID <= channelMessage.ID || group2.ID <= group1.ID {
+		t.Error("Incorrect group message seqs or IDs")
+	}
+
+	if testSubsEngine.GroupChangedCalled != 2 || testSubsEngine.GroupChangedID[1] != groupID || testSubsEngine.MessageChangedCalled != 0 {
+		t.Error("Posting group messages didn't notify the members")
+	}
+
+	for _, username := range []string{"user1", "user2", "user3"} {
+		messages := testModel.GetGroupMessageHistory(groupID, username, -1)
+		if len(messages) != 2 || messages[0].Text != "group1" || messages[1].Text != "group2" {
+			t.Error("Failed to get group messages for", username)
+		}
+	}
+
+	if messages := testModel.GetGroupMessageHistory(groupID, "user1", 1); len(messages) != 1 || messages[0].Text != "group2" {
+		t.Error("Failed to get latest group message")
+	}
+
+	if messages := testModel.GetGroupMessageHistory(groupID, "user4", -1); len(messages) != 0 {
+		t.Error("Non-member got the group's messages")
+	}
+
+	// Ensure that the users' groups are listed with their members and number of messages
+	otherGroupID, _ := testModel.CreateGroup("user4", []string{"user1"})
+	groups := testModel.GetGroups("user1")
+	if len(groups) != 2 || groups[0].ID != groupID || groups[1].ID != otherGroupID || groups[0].NumMessages != 2 ||
+		strings.Join(groups[0].Members, " ") != "user1 user2 user3" {
+		t.Error("Incorrect groups for user1")
+	}
+
+	if groups := testModel.GetGroups("user3"); len(groups) != 1 || groups[0].ID != groupID {
+		t.Error("Incorrect groups for user3")
+	}
+
+	// Ensure that blocked users' group messages are filtered
+	testModel.BlockUser("user1", "user2")
+	if messages := testModel.GetGroupMessageHistory(groupID, "user1", -1); len(messages) != 1 || messages[0].Text != "group1" {
+		t.Error("Failed to filter blocked user's group messages")
+	}
+
+	// Ensure that deleting a user removes them from their groups
+	testModel.DeleteUser("user2")
+	testModel.CreateUser("user2")
+	if messages := testModel.GetGroupMessageHistory(groupID, "user2", -1); len(messages) != 0 {
+		t.Error("Deleted user is still a member of the group")
+	}
+
+	if groups := testModel.GetGroups("user3"); len(groups) != 1 || strings.Join(groups[0].Members, " ") != "user1 user3" {
+		t.Error("Deleted user is still listed in the group")
+	}
+}
+
+func TestRestoreChannel(t *testing.T) {
+	now := time.Now()
+	testModel, err := model.NewModel(model.Options{Clock: func() time.Time { return now }, UndoWindow: time.Minute}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.JoinChannel("user1", "channel1")
+	testModel.JoinChannel("user2", "channel1")
+	testModel.MuteChannel("user1", "channel1")
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+
+	// Ensure that the history, members and mutes come back (without the users deleted since)
+	testModel.DeleteChannel("channel1")
+	if _, ok := testModel.GetDeletedChannels()["channel1"]; !ok {
+		t.Error("Failed to keep a deleted channel")
+	}
+
+	testModel.DeleteUser("user2")
+	testModel.RestoreChannel("channel1")
+	if _, ok := testModel.GetChannels()["channel1"]; !ok {
+		t.Error("Failed to restore a deleted channel")
+	}
+
+	messages := testModel.GetChannelHistory("channel1", "Anonymous", -1)
+	if len(messages) != 1 || messages[0].Text != "message1" {
+		t.Error("Failed to restore the history of a deleted channel")
+	}
+
+	if _, ok := testModel.GetJoinedChannels("user1")["channel1"]; !ok || testModel.GetChannelInfo("channel1").NumMembers != 1 {
+		t.Error("Failed to restore the members of a deleted channel")
+	}
+
+	if len(testModel.GetUserInfo("user1").MutedChannels) != 1 {
+		t.Error("Failed to restore the mutes of a deleted channel")
+	}
+
+	if len(testModel.GetDeletedChannels()) != 0 {
+		t.Error("Failed to forget a restored channel")
+	}
+
+	// Ensure that a deleted user's memberships, mutes, read markers, stars and RSVPs don't come back
+	// for a new user with the name
+	testModel.CreateUser("user2")
+	testModel.JoinChannel("user2", "channel1")
+	testModel.MuteChannel("user2", "channel1")
+	messages = testModel.GetChannelHistory("channel1", "user2", -1)
+	testModel.MarkRead("user2", "channel1", messages[0].ID)
+	testModel.StarMessage("user2", "channel1", messages[0].ID, true)
+	eventID, _ := testModel.CreateEvent("channel1", "user1", now.Add(time.Hour), "event1")
+	testModel.RSVPEvent(eventID, "user2", true)
+
+	testModel.DeleteChannel("channel1")
+	testModel.DeleteUser("user2")
+	testModel.CreateUser("user2")
+	testModel.RestoreChannel("channel1")
+	if _, ok := testModel.GetJoinedChannels("user2")["channel1"]; ok || len(testModel.GetUserInfo("user2").MutedChannels) != 0 {
+		t.Error("Restored a deleted user's membership or mute for a new user")
+	}
+
+	if len(testModel.GetReadMarkers("user2")) != 0 || len(testModel.GetStarredMessages("user2")) != 0 ||
+		len(testModel.GetEvents("channel1")[0].Going) != 0 {
+		t.Error("Restored a deleted user's read marker, star or RSVP for a new user")
+	}
+
+	// Ensure that channels can't be restored after the undo window
+	testModel.DeleteChannel("channel1")
+	now = now.Add(2 * time.Minute)
+	testModel.RestoreChannel("channel1")
+	if _, ok := testModel.GetChannels()["channel1"]; ok {
+		t.Error("Restored a channel after the undo window")
+	}
+
+	// Ensure that channels can't be restored once their name is reused
+	testModel.CreateChannel("channel2")
+	testModel.DeleteChannel("channel2")
+	testModel.CreateChannel("channel2")
+	testModel.PostMessage("channel2", "user1", time.Time{}, "message2")
+	testModel.RestoreChannel("channel2")
+	if testModel.GetChannelInfo("channel2").NumMessages != 1 {
+		t.Error("Restored a channel whose name was reused")
+	}
+}
+
+func TestRenameChannel(t *testing.T) {
+	testSubsEngine := NewTestSubsEngine()
+	testModel, err := model.NewModel(model.Options{}, nil, nil, testSubsEngine)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+	testModel.CreateUser("user1")
+	testModel.JoinChannel("user1", "channel1")
+	testModel.MuteChannel("user1", "channel1")
+	testModel.SetChannelTopic("channel1", "topic1")
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+
+	// Ensure that channels are only renamed to valid names that aren't taken
+	if testModel.RenameChannel("channel3", "channel4") != model.ErrChannelNotFound {
+		t.Error("Incorrect error renaming a nonexistent channel")
+	}
+
+	if testModel.RenameChannel("General", "channel4") != model.ErrChannelProtected {
+		t.Error("Incorrect error renaming a protected channel")
+	}
+
+	if testModel.RenameChannel("channel1", "channel 4") != model.ErrInvalidName {
+		t.Error("Incorrect error renaming a channel to an invalid name")
+	}
+
+	if testModel.RenameChannel("channel1", "channel2") != model.ErrChannelExists {
+		t.Error("Incorrect error renaming a channel to a taken name")
+	}
+
+	// Ensure that the history, members, settings and mutes move to the new name
+	testSubsEngine.Reset()
+	if testModel.RenameChannel("channel1", "channel4") != nil {
+		t.Fatal("Failed to rename channel")
+	}
+
+	if _, ok := testModel.GetChannels()["channel1"]; ok {
+		t.Error("Renamed channel kept its old name")
+	}
+
+	channelInfo := testModel.GetChannelInfo("channel4")
+	if channelInfo.Name != "channel4" || channelInfo.Topic != "topic1" || channelInfo.NumMessages != 1 || channelInfo.NumMembers != 1 {
+		t.Error("Failed to keep the renamed channel's state")
+	}
+
+	messages := testModel.GetChannelHistory("channel4", "user1", -1)
+	if len(messages) != 1 || messages[0].Text != "message1" {
+		t.Error("Failed to keep the renamed channel's history")
+	}
+
+	if _, ok := testModel.GetJoinedChannels("user1")["channel4"]; !ok || !testModel.IsChannelMuted("user1", "channel4") {
+		t.Error("Failed to keep the renamed channel's members and mutes")
+	}
+
+	if testSubsEngine.ChannelRenamedCalled != 1 || testSubsEngine.ChannelRenamedNames[0] != [2]string{"channel1", "channel4"} {
+		t.Error("Failed to notify the rename")
+	}
+
+	// Ensure that a deleted channel can't be restored once its name is reused
+	testModel.DeleteChannel("channel2")
+	testModel.RenameChannel("channel4", "channel2")
+	if testModel.RestoreChannel("channel2") != model.ErrNotRestorable {
+		t.Error("Restored a channel whose name was reused")
+	}
+}
+
+func TestRenameUser(t *testing.T) {
+	testSubsEngine := NewTestSubsEngine()
+	testModel, err := model.NewModel(model.Options{}, nil, nil, testSubsEngine)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.CreateUser("user3")
+	testModel.CreateVirtualUser("user1", "virtual1")
+	testModel.JoinChannel("user1", "channel1")
+	testModel.BlockUser("user2", "user1")
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+	testModel.PostDirectMessage("user1", "user2", time.Time{}, "direct1")
+	groupID, _ := testModel.CreateGroup("user1", []string{"user2", "user3"})
+	testModel.PostGroupMessage(groupID, "user1", time.Time{}, "group1")
+
+	// Ensure that users are only renamed to valid names that aren't taken
+	if testModel.RenameUser("user4", "user5") != model.ErrUserNotFound {
+		t.Error("Incorrect error renaming a nonexistent user")
+	}
+
+	if testModel.RenameUser("Anonymous", "user5") != model.ErrUserProtected {
+		t.Error("Incorrect error renaming a protected user")
+	}
+
+	if testModel.RenameUser("user1", "user 5") != model.ErrInvalidName {
+		t.Error("Incorrect error renaming a user to an invalid name")
+	}
+
+	if testModel.RenameUser("user1", "user2") != model.ErrUserExists {
+		t.Error("Incorrect error renaming a user to a taken name")
+	}
+
+	// Ensure that every reference to the user is renamed
+	testSubsEngine.Reset()
+	if testModel.RenameUser("user1", "user5") != nil {
+		t.Fatal("Failed to rename user")
+	}
+
+	if _, ok := testModel.GetUsers()["user1"]; ok {
+		t.Error("Renamed user kept their old name")
+	}
+
+	if testModel.GetUserInfo("user5").Name != "user5" || testModel.GetUserInfo("virtual1").Owner != "user5" {
+		t.Error("Failed to rename the user and the owner of their virtual users")
+	}
+
+	if blockedUsers := testModel.GetUserInfo("user2").BlockedUsers; len(blockedUsers) != 1 || blockedUsers[0] != "user5" {
+		t.Error("Failed to rename the blocks of the user")
+	}
+
+	members := testModel.GetChannelMembers("channel1")
+	if _, ok := members["user5"]; !ok || len(members) != 1 {
+		t.Error("Failed to rename the memberships of the user")
+	}
+
+	messages := testModel.GetChannelHistory("channel1", "user3", -1)
+	if len(messages) != 1 || messages[0].Username != "user5" {
+		t.Error("Failed to rename the author of the user's messages")
+	}
+
+	if len(testModel.GetChannelHistory("channel1", "user2", -1)) != 0 {
+		t.Error("Showed the messages of a blocked user after renaming them")
+	}
+
+	messages = testModel.GetDirectMessageHistory("user5", "user2", -1)
+	if len(messages) != 1 || messages[0].Username != "user5" || testModel.GetConversations("user2")["user5"] != 1 {
+		t.Error("Failed to rename the user's conversations")
+	}
+
+	groups := testModel.GetGroups("user5")
+	if len(groups) != 1 || groups[0].ID != groupID {
+		t.Error("Failed to rename the user's groups")
+	}
+
+	messages = testModel.GetGroupMessageHistory(groupID, "user3", -1)
+	if len(messages) != 1 || messages[0].Username != "user5" {
+		t.Error("Failed to rename the author of the user's group messages")
+	}
+
+	if testSubsEngine.UserRenamedCalled != 1 || testSubsEngine.UserRenamedNames[0] != [2]string{"user1", "user5"} {
+		t.Error("Failed to notify the rename")
+	}
+
+	// Ensure that a new user with the old name doesn't inherit anything
+	testModel.CreateUser("user1")
+	if len(testModel.GetConversations("user1")) != 0 || len(testModel.GetGroups("user1")) != 0 {
+		t.Error("New user inherited the renamed user's state")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	testActionsLogger := NewTestActionsLogger()
+	testModel, err := model.NewModel(model.Options{}, nil, testActionsLogger, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	// Ensure that a batch with an ignored mutation changes (and logs) nothing
+	testActionsLogger.Reset()
+	index, err := testModel.Batch([]model.Mutation{
+		{Type: "CreateUser", Username: "user1"},
+		{Type: "CreateChannel", Channelname: "channel1"},
+		{Type: "JoinChannel", Username: "user1", Channelname: "channel2"},
+	})
+	if err != model.ErrChannelNotFound || index != 2 {
+		t.Error("Failed to reject a batch with an ignored mutation")
+	}
+
+	if _, ok := testModel.GetUsers()["user1"]; ok {
+		t.Error("Applied part of a rejected batch")
+	}
+
+	if _, ok := testModel.GetChannels()["channel1"]; ok {
+		t.Error("Applied part of a rejected batch")
+	}
+
+	if testActionsLogger.CreateUserCalled != 0 || testActionsLogger.CreateChannelCalled != 0 || testActionsLogger.EndBatchCalled != 0 {
+		t.Error("Logged part of a rejected batch")
+	}
+
+	// Ensure that a batch of mutations that depend on each other is applied in order
+	_, err = testModel.Batch([]model.Mutation{
+		{Type: "CreateUser", Username: "user1"},
+		{Type: "CreateChannel", Channelname: "channel1"},
+		{Type: "JoinChannel", Username: "user1", Channelname: "channel1"},
+		{Type: "SetChannelTopic", Channelname: "channel1", Topic: "topic1"},
+		{Type: "PostMessage", Username: "user1", Channelname: "channel1", Text: "message1"},
+	})
+	if err != nil {
+		t.Error("Failed to apply a batch")
+	}
+
+	if _, ok := testModel.GetJoinedChannels("user1")["channel1"]; !ok || testModel.GetChannelInfo("channel1").Topic != "topic1" {
+		t.Error("Failed to apply a batch")
+	}
+
+	messages := testModel.GetChannelHistory("channel1", "user1", -1)
+	if len(messages) != 1 || messages[0].Text != "message1" || messages[0].ID != 1 {
+		t.Error("Failed to post a message in a batch")
+	}
+
+	if testActionsLogger.CreateUserCalled != 1 || testActionsLogger.JoinChannelCalled != 2 || testActionsLogger.PostMessageCalled != 1 {
+		t.Error("Failed to log a batch")
+	}
+
+	if testActionsLogger.BeginBatchCalled != 1 || testActionsLogger.EndBatchCalled != 1 {
+		t.Error("Failed to log the batch as a single action")
+	}
+
+	// Ensure that messages posted in a rejected batch don't use up IDs or show up in the history
+	_, err = testModel.Batch([]model.Mutation{
+		{Type: "PostMessage", Username: "user1", Channelname: "channel1", Text: "message2"},
+		{Type: "CreateUser", Username: "user1"},
+	})
+	if err != model.ErrUserExists {
+		t.Error("Failed to reject a batch with an ignored mutation")
+	}
+
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message3")
+	messages = testModel.GetChannelHistory("channel1", "user1", -1)
+	if len(messages) != 2 || messages[1].Text != "message3" || messages[1].ID != 2 {
+		t.Error("Applied part of a rejected batch")
+	}
+
+	// Ensure that unknown mutations are rejected
+	index, err = testModel.Batch([]model.Mutation{{Type: "RenameChannel", Channelname: "channel1"}})
+	if err != model.ErrUnknownMutation || index != 0 {
+		t.Error("Failed to reject an unknown mutation")
+	}
+}
+
+func TestBatchReplay(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	logFilePath := filepath.Join(tempDir, "log.txt")
+	logger, err := actions.NewLogger(logFilePath)
+	if err != nil {
+		t.Fatal("Failed to create Logger")
+	}
+
+	replica, err := model.NewModel(model.Options{TrustTimestamps: true}, nil, nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create replica")
+	}
+
+	testModel, err := model.NewModel(model.Options{}, nil, actions.NewFanout(logger, replica.Actor()), nil)
+	if err != nil {
+		t.Fatal("Failed to create model")
+	}
+
+	// New users join the default channels, and the messages are given timestamps, as part of the batch
+	if _, err := testModel.Batch([]model.Mutation{
+		{Type: "CreateUser", Username: "user1"},
+		{Type: "CreateChannel", Channelname: "channel1"},
+		{Type: "JoinChannel", Username: "user1", Channelname: "channel1"},
+		{Type: "PostMessage", Username: "user1", Channelname: "channel1", Text: "message1"},
+		{Type: "PostMessage", Username: "user1", Channelname: "General", Text: "message2"},
+	}); err != nil {
+		t.Fatal("Failed to apply a batch")
+	}
+
+	// Ensure that the replayed log and the replica have the same state as the model
+	replayer, err := actions.NewReplayer(logFilePath)
+	if err != nil {
+		t.Fatal("Failed to create Replayer")
+	}
+
+	replayedModel, err := model.NewModel(model.Options{}, replayer, nil, nil)
+	if err != nil {
+		t.Fatal("Failed to replay the log")
+	}
+
+	expected, _ := json.Marshal(testModel.Snapshot())
+	for _, otherModel := range []*model.Model{replayedModel, replica} {
+		if actual, _ := json.Marshal(otherModel.Snapshot()); string(actual) != string(expected) {
+			t.Errorf("Failed to make the same changes as the batch\n%s\n%s", actual, expected)
+		}
+	}
+}
+
+func TestReserveUser(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	if !testModel.ReserveUser("user1") {
+		t.Error("Failed to reserve a new user")
+	}
+
+	if _, ok := testModel.GetUsers()["user1"]; !ok {
+		t.Error("Failed to create a reserved user")
+	}
+
+	// Ensure that only the first reservation succeeds
+	if testModel.ReserveUser("user1") || testModel.ReserveUser("Anonymous") {
+		t.Error("Reserved an existing user")
+	}
+
+	if testModel.ReserveUser("") || testModel.ReserveUser("user 2") {
+		t.Error("Reserved an invalid user")
+	}
+
+	// Ensure that concurrent reservations of the same user have a single winner
+	results := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			results <- testModel.ReserveUser("user2")
+		}()
+	}
+
+	numReserved := 0
+	for i := 0; i < 10; i++ {
+		if <-results {
+			numReserved++
+		}
+	}
+
+	if numReserved != 1 {
+		t.Error("Reserved a user more than once")
+	}
+}
+
+func TestCreateUserAndJoin(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateChannel("channel1")
+	if testModel.CreateUserAndJoin("user1", "channel1") != nil {
+		t.Error("Failed to create and join")
+	}
+
+	joinedChannels := testModel.GetJoinedChannels("user1")
+	if _, ok := joinedChannels["channel1"]; !ok || len(joinedChannels) != 2 {
+		t.Error("Failed to join the default and requested channels")
+	}
+
+	// Joining one of the default channels isn't an error
+	if testModel.CreateUserAndJoin("user2", "General") != nil || len(testModel.GetJoinedChannels("user2")) != 1 {
+		t.Error("Failed to create and join a default channel")
+	}
+
+	// Ensure that nothing is created when either part is rejected
+	if testModel.CreateUserAndJoin("user3", "channel2") != model.ErrChannelNotFound {
+		t.Error("Created a user joining a channel that doesn't exist")
+	}
+
+	if _, ok := testModel.GetUsers()["user3"]; ok {
+		t.Error("Created a user when the channel doesn't exist")
+	}
+
+	if testModel.CreateUserAndJoin("user1", "channel1") != model.ErrUserExists || testModel.CreateUserAndJoin("user 3", "channel1") != model.ErrInvalidName {
+		t.Error("Created an existing or invalid user")
+	}
+}
+
+func TestCreateChannelAndJoin(t *testing.T) {
+	testSubsEngine := NewTestSubsEngine()
+	testModel, err := model.NewModel(model.Options{}, nil, nil, testSubsEngine)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("user1")
+	if testModel.CreateChannelAndJoin("channel1", "topic1", "user1") != nil {
+		t.Error("Failed to create and join")
+	}
+
+	if testModel.GetChannelInfo("channel1").Topic != "topic1" || testModel.GetChannelInfo("channel1").NumMembers != 1 {
+		t.Error("Failed to set the topic and join")
+	}
+
+	if testModel.CreateChannelAndJoin("channel2", "", "user1") != nil || testModel.GetChannelInfo("channel2").NumMembers != 1 {
+		t.Error("Failed to create and join without a topic")
+	}
+
+	// Ensure that nothing is created when either part is rejected
+	testSubsEngine.Reset()
+	if testModel.CreateChannelAndJoin("channel3", "topic3", "user2") != model.ErrUserNotFound {
+		t.Error("Created a channel for a user that doesn't exist")
+	}
+
+	if _, ok := testModel.GetChannels()["channel3"]; ok || testSubsEngine.ChannelsChangedCalled != 0 {
+		t.Error("Created a channel when the user doesn't exist")
+	}
+
+	if testModel.CreateChannelAndJoin("channel1", "topic3", "user1") != model.ErrChannelExists || testModel.GetChannelInfo("channel1").Topic != "topic1" {
+		t.Error("Changed an existing channel")
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	options := model.Options{Clock: func() time.Time { return now }, MaxUsers: 3, MaxChannelsPerUser: 2, MaxMessagesPerDay: 2}
+	testModel, err := model.NewModel(options, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	// Ensure that the users (counting the built-in one) are limited
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	if err := testModel.CreateUser("user3"); err != model.ErrUserQuota {
+		t.Error("Incorrect error creating a user past the quota")
+	}
+
+	if err := testModel.CreateVirtualUser("user1", "virtual1"); err != model.ErrUserQuota {
+		t.Error("Incorrect error creating a virtual user past the quota")
+	}
+
+	// Ensure that the channels a user is in (counting the default ones) are limited
+	testModel.CreateChannel("channel1")
+	testModel.CreateChannel("channel2")
+	if err := testModel.JoinChannel("user1", "channel1"); err != nil {
+		t.Error(err)
+	}
+
+	if err := testModel.JoinChannel("user1", "channel2"); err != model.ErrChannelQuota {
+		t.Error("Incorrect error joining a channel past the quota")
+	}
+
+	if err := testModel.CreateChannelAndJoin("channel3", "", "user1"); err != model.ErrChannelQuota {
+		t.Error("Incorrect error creating and joining a channel past the quota")
+	}
+
+	if _, ok := testModel.GetChannels()["channel3"]; ok {
+		t.Error("Created a channel the user couldn't join")
+	}
+
+	testModel.LeaveChannel("user1", "channel1")
+	if err := testModel.JoinChannel("user1", "channel2"); err != nil {
+		t.Error("Failed to join a channel after leaving another")
+	}
+
+	// Ensure that a user's messages (counting direct messages) are limited per day
+	testModel.PostMessage("General", "user1", time.Time{}, "message1")
+	testModel.PostDirectMessage("user1", "user2", time.Time{}, "direct1")
+	if _, err := testModel.PostMessage("General", "user1", time.Time{}, "message2"); err != model.ErrMessageQuota {
+		t.Error("Incorrect error posting a message past the quota")
+	}
+
+	if _, err := testModel.PostDirectMessage("user1", "user2", time.Time{}, "direct2"); err != model.ErrMessageQuota {
+		t.Error("Incorrect error posting a direct message past the quota")
+	}
+
+	if _, err := testModel.PostMessage("General", "user2", time.Time{}, "message3"); err != nil {
+		t.Error("Another user's messages were limited")
+	}
+
+	// A new user with a deleted user's name doesn't inherit their messages
+	testModel.DeleteUser("user1")
+	testModel.CreateUser("user1")
+	if _, err := testModel.PostMessage("General", "user1", time.Time{}, "message1"); err != nil {
+		t.Error("New user inherited a deleted user's messages")
+	}
+
+	now = now.Add(24 * time.Hour)
+	if _, err := testModel.PostMessage("General", "user1", time.Time{}, "message4"); err != nil {
+		t.Error("Failed to post a message the next day")
+	}
+
+	// Ensure that the quotas don't apply to the actions fed to a read replica (they were accepted
+	// by the model that logged them)
+	replicaOptions := options
+	replicaOptions.TrustTimestamps = true
+	replica, err := model.NewModel(replicaOptions, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create replica")
+	}
+
+	unlimited, err := model.NewModel(model.Options{}, nil, actions.NewFanout(replica.Actor()), nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	for _, username := range []string{"user1", "user2", "user3"} {
+		unlimited.CreateUser(username)
+		unlimited.PostMessage("General", username, time.Time{}, "message1")
+		unlimited.PostMessage("General", username, time.Time{}, "message2")
+		unlimited.PostMessage("General", username, time.Time{}, "message3")
+	}
+
+	if len(replica.GetUsers()) != 4 || len(replica.GetChannelHistory("General", "user1", -1)) != 9 {
+		t.Error("Quotas were applied to the replica")
+	}
+}
+
+func TestMutatorErrors(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	if testModel.CreateUser("user1") != nil || testModel.CreateChannel("channel1") != nil {
+		t.Error("Failed to create user/channel")
+	}
+
+	// Ensure that each rejected change says why it was rejected
+	if testModel.DeleteUser("user2") != model.ErrUserNotFound || testModel.DeleteUser("Anonymous") != model.ErrUserProtected {
+		t.Error("Incorrect error deleting user")
+	}
+
+	if testModel.CreateVirtualUser("user2", "virtual1") != model.ErrInvalidOwner || testModel.CreateVirtualUser("user1", "user1") != model.ErrUserExists {
+		t.Error("Incorrect error creating virtual user")
+	}
+
+	if testModel.DeleteChannel("channel2") != model.ErrChannelNotFound || testModel.DeleteChannel("General") != model.ErrChannelProtected {
+		t.Error("Incorrect error deleting channel")
+	}
+
+	if testModel.JoinChannel("user1", "General") != model.ErrAlreadyMember || testModel.JoinChannel("user1", "channel2") != model.ErrChannelNotFound {
+		t.Error("Incorrect error joining channel")
+	}
+
+	if testModel.LeaveChannel("user1", "channel1") != model.ErrNotMember {
+		t.Error("Incorrect error leaving channel")
+	}
+
+	if testModel.SetChannelTopic("channel2", "topic1") != model.ErrChannelNotFound || testModel.SetChannelRules("channel1", "en us", "") != model.ErrInvalidLanguage {
+		t.Error("Incorrect error setting channel topic/rules")
+	}
+
+	if _, err := testModel.PostBridgedMessage("channel1", "user1", time.Now(), "message1", "", "alice"); err != model.ErrMissingOrigin {
+		t.Error("Incorrect error posting bridged message")
+	}
+
+	if testModel.RestoreChannel("channel1") != model.ErrNotRestorable {
+		t.Error("Incorrect error restoring channel")
+	}
+
+	// Ensure that a deleted channel can only be restored once
+	if testModel.DeleteChannel("channel1") != nil || testModel.RestoreChannel("channel1") != nil {
+		t.Error("Failed to delete and restore channel")
+	}
+
+	if testModel.RestoreChannel("channel1") != model.ErrNotRestorable {
+		t.Error("Incorrect error restoring channel twice")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	replica, err := model.NewModel(model.Options{TrustTimestamps: true}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create replica")
+	}
+
+	testModel, err := model.NewModel(model.Options{}, nil, actions.NewFanout(replica.Actor()), nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
-	channelInfo := testModel.GetChannelInfo("channel1")
-	if channelInfo.Name != "" {
-		t.Error("Failed to return empty channel info")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.CreateChannel("channel1")
+	message, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+
+	// Ensure that every change is rejected while the model is read-only
+	testModel.SetReadOnly(true)
+	replica.SetReadOnly(true)
+	if !testModel.IsReadOnly() {
+		t.Error("Model isn't read-only")
 	}
 
-	channelInfo = testModel.GetChannelInfo("General")
-	if channelInfo.Name != "General" {
-		t.Error("Failed to return General channel info")
+	if testModel.CreateUser("user3") != model.ErrReadOnly || testModel.DeleteUser("user2") != model.ErrReadOnly ||
+		testModel.CreateVirtualUser("user1", "virtual1") != model.ErrReadOnly || testModel.BlockUser("user1", "user2") != model.ErrReadOnly {
+		t.Error("Users changed while read-only")
+	}
+
+	if testModel.CreateChannel("channel2") != model.ErrReadOnly || testModel.DeleteChannel("channel1") != model.ErrReadOnly ||
+		testModel.JoinChannel("user1", "channel1") != model.ErrReadOnly || testModel.SetChannelTopic("channel1", "topic1") != model.ErrReadOnly {
+		t.Error("Channels changed while read-only")
+	}
+
+	if _, err := testModel.PostMessage("channel1", "user1", time.Time{}, "message2"); err != model.ErrReadOnly {
+		t.Error("Message posted while read-only")
+	}
+
+	if testModel.EditMessage("channel1", message.ID, "user1", "message2") != model.ErrReadOnly || testModel.ModerateMessage("channel1", message.ID) != model.ErrReadOnly {
+		t.Error("Message changed while read-only")
+	}
+
+	if _, err := testModel.PostDirectMessage("user1", "user2", time.Time{}, "direct1"); err != model.ErrReadOnly {
+		t.Error("Direct message posted while read-only")
+	}
+
+	if _, err := testModel.CreateGroup("user1", []string{"user2"}); err != model.ErrReadOnly {
+		t.Error("Group created while read-only")
+	}
+
+	if _, err := testModel.Batch([]model.Mutation{{Type: "CreateUser", Username: "user3"}}); err != model.ErrReadOnly {
+		t.Error("Batch applied while read-only")
+	}
+
+	testModel.PutPluginData("plugin1", "key1", "value1")
+	if _, ok := testModel.GetPluginData("plugin1", "key1"); ok {
+		t.Error("Plugin data stored while read-only")
+	}
+
+	// The state can still be read
+	if len(testModel.GetUsers()) != 3 || len(testModel.GetChannelHistory("channel1", "user1", -1)) != 1 {
+		t.Error("Failed to read state while read-only")
+	}
+
+	// Ensure that changes are accepted again once the model is writable
+	testModel.SetReadOnly(false)
+	if testModel.CreateUser("user3") != nil {
+		t.Error("Failed to create user after leaving read-only")
+	}
+
+	// A read replica follows the model even while read-only
+	if _, ok := replica.GetUsers()["user3"]; !ok {
+		t.Error("Read-only replica didn't follow the model")
 	}
 }
 
-func TestCreatingAndDeletingMultipleChannels(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+func TestLogWriteFailure(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	logFilePath := filepath.Join(tempDir, "log.txt")
+	logger, err := actions.NewLogger(logFilePath)
+	if err != nil {
+		t.Error("Failed to create Logger")
+	}
+
+	replica, err := model.NewModel(model.Options{TrustTimestamps: true}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create replica")
+	}
+
+	testModel, err := model.NewModel(model.Options{}, nil, actions.NewFanout(logger, replica.Actor()), nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
+	testModel.CreateUser("user1")
 	testModel.CreateChannel("channel1")
-	testModel.CreateChannel("channel2")
-	testModel.CreateChannel("channel3")
-	testModel.CreateChannel("channel4")
-	testModel.CreateChannel("channel5")
+	testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
 
-	channels := testModel.GetChannels()
-	if len(channels) != 6 {
-		t.Error("Failed to create 5 channels")
+	// Ensure that a change that can't be logged isn't made (the error is returned instead)
+	os.Rename(logFilePath, logFilePath+".bak")
+	os.Mkdir(logFilePath, 0755)
+	if err := testModel.CreateUser("user2"); err == nil || err == model.ErrReadOnly {
+		t.Error("Failed to return the error writing the log")
+	}
+
+	if _, ok := testModel.GetUsers()["user2"]; ok {
+		t.Error("User created without being logged")
+	}
+
+	if _, err := testModel.PostMessage("channel1", "user1", time.Time{}, "message2"); err == nil {
+		t.Error("Message posted without being logged")
+	}
+
+	if len(testModel.GetChannelHistory("channel1", "user1", -1)) != 1 {
+		t.Error("Message added without being logged")
+	}
+
+	// A batch that can't be logged changes nothing (not even the part before the failure)
+	if _, err := testModel.Batch([]model.Mutation{
+		{Type: "CreateUser", Username: "user2"},
+		{Type: "PostMessage", Username: "user1", Channelname: "channel1", Text: "message2"},
+	}); err == nil {
+		t.Error("Batch applied without being logged")
+	}
+
+	if _, ok := testModel.GetUsers()["user2"]; ok || len(testModel.GetChannelHistory("channel1", "user1", -1)) != 1 {
+		t.Error("Applied part of a batch that wasn't logged")
+	}
+
+	// The read replica only follows the changes that were logged
+	if _, ok := replica.GetUsers()["user2"]; ok || len(replica.GetChannelHistory("channel1", "user1", -1)) != 1 {
+		t.Error("Replica followed a change that wasn't logged")
+	}
+
+	// When the failure switches the model to read-only, the change is rejected as read-only
+	logger.SetErrorHandler(func(err error) {
+		testModel.SetReadOnly(true)
+	})
+	if testModel.JoinChannel("user1", "channel1") != model.ErrReadOnly || !testModel.IsReadOnly() {
+		t.Error("Failed to reject the change as read-only")
+	}
+
+	// Once the log can be written again, the changes are made
+	testModel.SetReadOnly(false)
+	os.Remove(logFilePath)
+	os.Rename(logFilePath+".bak", logFilePath)
+	if testModel.CreateUser("user2") != nil {
+		t.Error("Failed to create user once the log can be written")
+	}
+
+	if _, ok := replica.GetUsers()["user2"]; !ok {
+		t.Error("Replica didn't follow the model once the log can be written")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
 	}
 
+	testModel.CreateUser("bridge1")
+	testModel.CreateVirtualUser("bridge1", "virtual1")
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user2")
+	testModel.CreateChannel("channel1")
+	testModel.SetChannelRules("channel1", "en", "rules1")
+	testModel.JoinChannel("user1", "channel1")
+	testModel.MuteChannel("user2", "channel1")
+	testModel.BlockUser("user1", "user2")
+	message, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message0")
+	testModel.EditMessage("channel1", message.ID, "user1", "message1")
+	deleted, _ := testModel.PostBridgedMessage("channel1", "virtual1", time.Time{}, "message2", "Slack", "alice")
+	testModel.ModerateMessage("channel1", deleted.ID)
+	testModel.PostDirectMessage("user1", "user2", time.Time{}, "direct1")
+	groupID, _ := testModel.CreateGroup("user1", []string{"user2"})
+	testModel.PostGroupMessage(groupID, "user2", time.Time{}, "group1")
+	testModel.PutPluginData("plugin1", "key1", "value1")
+	testModel.CreateChannel("channel2")
 	testModel.DeleteChannel("channel2")
-	testModel.DeleteChannel("channel4")
-	testModel.DeleteChannel("channel5")
 
-	channels = testModel.GetChannels()
-	if len(channels) != 3 {
-		t.Error("Failed to delete 3 channels")
+	var snapshot *actions.Snapshot
+	err = testModel.Compact(func(compacted *actions.Snapshot) error {
+		snapshot = compacted
+		return nil
+	})
+	if err != nil || snapshot == nil {
+		t.Fatal("Failed to compact")
 	}
 
-	channel1Info := testModel.GetChannelInfo("channel1")
-	if channel1Info.Name != "channel1" || channel1Info.NumMessages != 0 {
-		t.Error("Messed up channel1 info")
+	// Ensure that restoring the snapshot gives the same state
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
 	}
+	restored.Actor().RestoreSnapshot(snapshot)
 
-	channel3Info := testModel.GetChannelInfo("channel3")
-	if channel3Info.Name != "channel3" || channel3Info.NumMessages != 0 {
-		t.Error("Messed up channel3 info")
+	if len(restored.GetUsers()) != len(testModel.GetUsers()) || len(restored.GetChannels()) != 2 || len(restored.GetDeletedChannels()) != 1 {
+		t.Error("Restored users/channels differ from the model")
+	}
+
+	if restored.GetUserInfo("virtual1").Owner != "bridge1" || len(restored.GetUserInfo("user1").BlockedUsers) != 1 || !restored.IsChannelMuted("user2", "channel1") {
+		t.Error("Restored user info differs from the model")
+	}
+
+	channelInfo := restored.GetChannelInfo("channel1")
+	if channelInfo.Rules != "rules1" || channelInfo.NumMembers != testModel.GetChannelInfo("channel1").NumMembers || !channelInfo.LastActivity.Equal(testModel.GetChannelInfo("channel1").LastActivity) {
+		t.Error("Restored channel info differs from the model")
+	}
+
+	restoredMessages := restored.GetChannelHistory("channel1", "user2", -1)
+	modelMessages := testModel.GetChannelHistory("channel1", "user2", -1)
+	if len(restoredMessages) != 2 || restoredMessages[0].Text != "message1" || restoredMessages[0].Seq != 1 || !restoredMessages[0].Edited.Equal(modelMessages[0].Edited) ||
+		!restoredMessages[1].Deleted || restoredMessages[1].Origin.System != "Slack" {
+		t.Error("Restored messages differ from the model")
+	}
+
+	if len(restored.GetDirectMessageHistory("user2", "user1", -1)) != 1 || len(restored.GetGroupMessageHistory(groupID, "user2", -1)) != 1 {
+		t.Error("Restored direct/group messages differ from the model")
+	}
+
+	if value, _ := restored.GetPluginData("plugin1", "key1"); value != "value1" {
+		t.Error("Restored plugin data differs from the model")
+	}
+
+	// The deleted channel can still be restored
+	if restored.RestoreChannel("channel2") != nil {
+		t.Error("Failed to restore deleted channel after restoring the snapshot")
+	}
+
+	// Ensure that the restored model numbers new messages and groups after the compacted ones
+	newMessage, _ := restored.PostMessage("channel1", "user1", time.Time{}, "message3")
+	newGroupID, _ := restored.CreateGroup("user2", []string{"user1"})
+	if newMessage.ID != snapshot.LastMessageID+1 || newMessage.Seq != 3 || newGroupID != groupID+1 {
+		t.Error("Restored model reused message or group IDs")
+	}
+
+	// A failed compaction is passed back
+	compactErr := errors.New("disk full")
+	if testModel.Compact(func(*actions.Snapshot) error { return compactErr }) != compactErr {
+		t.Error("Compact didn't return the error")
 	}
 }
 
-func TestGetChannelHistoryInputChecking(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+func TestPluginData(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
-	messages := testModel.GetChannelHistory("", "Anonymous", -1)
-	if len(messages) != 0 {
-		t.Error("Failed to disregard GetChannelHistory for unknown channel")
+	testModel.PutPluginData("plugin1", "key1", "value1")
+	testModel.PutPluginData("plugin2", "key1", "value2")
+	testModel.PutPluginData("", "key1", "value3")
+	testModel.PutPluginData("plugin1", "", "value3")
+
+	// Ensure that namespaces keep their own values
+	if value, ok := testModel.GetPluginData("plugin1", "key1"); !ok || value != "value1" {
+		t.Error("Failed to get plugin data")
 	}
 
-	messages = testModel.GetChannelHistory("General", "", -1)
-	if len(messages) != 0 {
-		t.Error("Failed to disregard GetChannelHistory for unknown user")
+	if value, ok := testModel.GetPluginData("plugin2", "key1"); !ok || value != "value2" {
+		t.Error("Failed to get plugin data from another namespace")
+	}
+
+	if _, ok := testModel.GetPluginData("", "key1"); ok {
+		t.Error("Stored plugin data with an empty namespace")
+	}
+
+	// Ensure that an empty value deletes the key
+	testModel.PutPluginData("plugin1", "key1", "")
+	if _, ok := testModel.GetPluginData("plugin1", "key1"); ok {
+		t.Error("Failed to delete plugin data")
+	}
+
+	// Ensure that empty namespaces are removed
+	if _, ok := testModel.GetPluginNamespaces()["plugin1"]; ok {
+		t.Error("Failed to remove an empty plugin namespace")
+	}
+
+	if _, ok := testModel.GetPluginNamespaces()["plugin2"]; !ok {
+		t.Error("Failed to get plugin namespaces")
+	}
+
+	// Ensure that a store is limited to its namespace
+	store := testModel.PluginStore("plugin2")
+	store.Put("key2", "value4")
+	if value, ok := testModel.GetPluginData("plugin2", "key2"); !ok || value != "value4" {
+		t.Error("Failed to put plugin data through a store")
+	}
+
+	if value, ok := store.Get("key1"); !ok || value != "value2" {
+		t.Error("Failed to get plugin data through a store")
+	}
+
+	if _, ok := testModel.PluginStore("plugin1").Get("key2"); ok {
+		t.Error("Got plugin data from another namespace through a store")
+	}
+
+	keys := store.Keys()
+	if _, ok := keys["key1"]; !ok || len(keys) != 2 {
+		t.Error("Failed to get plugin keys")
+	}
+
+	store.Delete("key1")
+	if _, ok := store.Get("key1"); ok || len(store.Keys()) != 1 {
+		t.Error("Failed to delete plugin data through a store")
 	}
 }
 
-func TestPostMessageInputChecking(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+func TestPostBridgedMessage(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
-	testModel.PostMessage("", "Anonymous", time.Now(), "message1")
-	channelInfo := testModel.GetChannelInfo("General")
-	if channelInfo.NumMessages != 0 {
-		t.Error("Failed to disregard PostMessage for unknown channel")
+	testModel.CreateUser("bridge1")
+
+	// Bridged messages need an originating system
+	testModel.PostBridgedMessage("General", "bridge1", time.Now(), "message1", "", "alice")
+	if testModel.GetChannelInfo("General").NumMessages != 0 {
+		t.Error("Failed to disregard PostBridgedMessage without an origin")
 	}
 
-	testModel.PostMessage("General", "", time.Now(), "message1")
-	channelInfo = testModel.GetChannelInfo("General")
-	if channelInfo.NumMessages != 0 {
-		t.Error("Failed to disregard PostMessage for unknown user")
+	testModel.PostMessage("General", "bridge1", time.Now(), "message1")
+	testModel.PostBridgedMessage("General", "bridge1", time.Now(), "message2", "Slack", "alice")
+	messages := testModel.GetChannelHistory("General", "Anonymous", -1)
+	if len(messages) != 2 || messages[0].Origin != (model.Origin{}) {
+		t.Error("Incorrect origin for a direct message")
 	}
 
-	testModel.PostMessage("General", "Anonymous", time.Now(), "")
-	channelInfo = testModel.GetChannelInfo("General")
-	if channelInfo.NumMessages != 0 {
-		t.Error("Failed to disregard PostMessage for empty message")
+	if messages[1].Text != "message2" || messages[1].Origin.System != "Slack" || messages[1].Origin.Author != "alice" {
+		t.Error("Incorrect origin for a bridged message")
+	}
+
+	if messages[0].DisplayAuthor() != "bridge1" || messages[1].DisplayAuthor() != "alice via Slack" {
+		t.Error("Incorrect display author")
 	}
 }
 
-func TestPostMessage(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+func TestPostSnippet(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
-	testModel.CreateChannel("channel1")
 	testModel.CreateUser("user1")
 
-	testModel.PostMessage("channel1", "user1", time.Now(), "message1")
-	testModel.PostMessage("channel1", "user1", time.Now(), "message2")
-	testModel.PostMessage("channel1", "user1", time.Now(), "message3")
-	testModel.PostMessage("channel1", "user1", time.Now(), "message4")
+	// Snippets need a language made of the characters languages are named with
+	for _, language := range []string{"", "go lang", "<script>", strings.Repeat("a", model.MaxSnippetLanguageLength+1)} {
+		if _, err := testModel.PostSnippet("General", "user1", time.Now(), language, "code"); err != model.ErrInvalidSnippet {
+			t.Error("Incorrect error posting snippet in language", language)
+		}
+	}
 
-	channel1Info := testModel.GetChannelInfo("channel1")
-	if channel1Info.NumMessages != 4 {
-		t.Error("Failed to count message after PostMessage")
+	if _, err := testModel.PostSnippet("General", "user1", time.Now(), "go", ""); err != model.ErrEmptyMessage {
+		t.Error("Incorrect error posting empty snippet")
 	}
 
-	// Ensure that we get the newest messages
-	messages := testModel.GetChannelHistory("channel1", "Anonymous", 1)
-	if len(messages) != 1 || messages[0].Username != "user1" || messages[0].Text != "message4" {
-		t.Error("Failed to get message after PostMessage")
+	testModel.PostMessage("General", "user1", time.Now(), "message1")
+	snippet, err := testModel.PostSnippet("General", "user1", time.Now(), "c++", "int main() {\n}\n")
+	if err != nil || snippet.SnippetLanguage != "c++" || snippet.Seq != 2 {
+		t.Error("Failed to post snippet")
 	}
 
-	// Ensure that we can get all of the messages
-	messages = testModel.GetChannelHistory("channel1", "Anonymous", 5)
-	if len(messages) != 4 {
-		t.Error("Failed to get multiple messages after PostMessage")
+	messages := testModel.GetChannelHistory("General", "user1", -1)
+	if len(messages) != 2 || messages[0].SnippetLanguage != "" {
+		t.Error("Incorrect snippet language for a message")
 	}
 
-	if messages[0].Text != "message1" || messages[1].Text != "message2" || messages[2].Text != "message3" || messages[3].Text != "message4" {
-		t.Error("Failed to get correct messages after PostMessage")
+	if messages[1].Text != "int main() {\n}\n" || messages[1].SnippetLanguage != "c++" {
+		t.Error("Incorrect snippet in the history")
 	}
 
-	// Ensure that we can get all of the messages
-	messages = testModel.GetChannelHistory("channel1", "Anonymous", -1)
-	if len(messages) != 4 {
-		t.Error("Failed to get multiple messages after PostMessage")
+	// Snippets keep their language in a snapshot
+	restored, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
 	}
 
-	if messages[0].Text != "message1" || messages[1].Text != "message2" || messages[2].Text != "message3" || messages[3].Text != "message4" {
-		t.Error("Failed to get correct messages after PostMessage")
+	restored.Actor().RestoreSnapshot(testModel.Snapshot())
+	messages = restored.GetChannelHistory("General", "user1", -1)
+	if len(messages) != 2 || messages[1].SnippetLanguage != "c++" {
+		t.Error("Failed to restore snippet language from a snapshot")
 	}
 }
 
 func TestFilteringBlockedUserMessages(t *testing.T) {
-	testModel, err := model.NewModel(nil, nil, nil)
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
@@ -630,6 +3774,26 @@ type TestSubsEngine struct {
 	ChannelsChangedCalled     int
 	ChannelChangedCalled      int
 	ChannelChangedChannelname []string
+	MessageChangedCalled      int
+	MessageChangedChannelname []string
+	MessageChangedID          []uint64
+	DirectMessagesCalled      int
+	DirectMessagesUsername    []string
+	DirectMessagesOther       []string
+	GroupChangedCalled        int
+	GroupChangedID            []uint64
+	GroupChangedMembers       [][]string
+	ChannelRenamedCalled      int
+	ChannelRenamedNames       [][2]string
+	UserRenamedCalled         int
+	UserRenamedNames          [][2]string
+	PresenceChangedCalled     int
+	PresenceChangedUsername   []string
+	ReadMarkerChangedCalled   int
+	ReadMarkerChangedNames    [][2]string
+	MentionedCalled           int
+	MentionedNames            [][2]string
+	MentionedMessageID        []uint64
 }
 
 func NewTestSubsEngine() *TestSubsEngine {
@@ -646,6 +3810,26 @@ func (t *TestSubsEngine) Reset() {
 	t.ChannelsChangedCalled = 0
 	t.ChannelChangedCalled = 0
 	t.ChannelChangedChannelname = make([]string, 0)
+	t.MessageChangedCalled = 0
+	t.MessageChangedChannelname = make([]string, 0)
+	t.MessageChangedID = make([]uint64, 0)
+	t.DirectMessagesCalled = 0
+	t.DirectMessagesUsername = make([]string, 0)
+	t.DirectMessagesOther = make([]string, 0)
+	t.GroupChangedCalled = 0
+	t.GroupChangedID = make([]uint64, 0)
+	t.GroupChangedMembers = make([][]string, 0)
+	t.ChannelRenamedCalled = 0
+	t.ChannelRenamedNames = make([][2]string, 0)
+	t.UserRenamedCalled = 0
+	t.UserRenamedNames = make([][2]string, 0)
+	t.PresenceChangedCalled = 0
+	t.PresenceChangedUsername = make([]string, 0)
+	t.ReadMarkerChangedCalled = 0
+	t.ReadMarkerChangedNames = make([][2]string, 0)
+	t.MentionedCalled = 0
+	t.MentionedNames = make([][2]string, 0)
+	t.MentionedMessageID = make([]uint64, 0)
 }
 
 func (t *TestSubsEngine) Connect(client subs.Client) error {
@@ -674,9 +3858,53 @@ func (t *TestSubsEngine) ChannelChanged(channelname string) {
 	t.ChannelChangedChannelname = append(t.ChannelChangedChannelname, channelname)
 }
 
+func (t *TestSubsEngine) ChannelRenamed(channelname string, newChannelname string) {
+	t.ChannelRenamedCalled++
+	t.ChannelRenamedNames = append(t.ChannelRenamedNames, [2]string{channelname, newChannelname})
+}
+
+func (t *TestSubsEngine) UserRenamed(username string, newUsername string) {
+	t.UserRenamedCalled++
+	t.UserRenamedNames = append(t.UserRenamedNames, [2]string{username, newUsername})
+}
+
+func (t *TestSubsEngine) MessageChanged(channelname string, messageID uint64) {
+	t.MessageChangedCalled++
+	t.MessageChangedChannelname = append(t.MessageChangedChannelname, channelname)
+	t.MessageChangedID = append(t.MessageChangedID, messageID)
+}
+
+func (t *TestSubsEngine) DirectMessagesChanged(username string, otherUsername string) {
+	t.DirectMessagesCalled++
+	t.DirectMessagesUsername = append(t.DirectMessagesUsername, username)
+	t.DirectMessagesOther = append(t.DirectMessagesOther, otherUsername)
+}
+
+func (t *TestSubsEngine) GroupChanged(groupID uint64, members []string) {
+	t.GroupChangedCalled++
+	t.GroupChangedID = append(t.GroupChangedID, groupID)
+	t.GroupChangedMembers = append(t.GroupChangedMembers, members)
+}
+
+func (t *TestSubsEngine) PresenceChanged(username string) {
+	t.PresenceChangedCalled++
+	t.PresenceChangedUsername = append(t.PresenceChangedUsername, username)
+}
+
+func (t *TestSubsEngine) ReadMarkerChanged(username string, channelname string) {
+	t.ReadMarkerChangedCalled++
+	t.ReadMarkerChangedNames = append(t.ReadMarkerChangedNames, [2]string{username, channelname})
+}
+
+func (t *TestSubsEngine) Mentioned(username string, channelname string, messageID uint64) {
+	t.MentionedCalled++
+	t.MentionedNames = append(t.MentionedNames, [2]string{username, channelname})
+	t.MentionedMessageID = append(t.MentionedMessageID, messageID)
+}
+
 func TestSubscriptions(t *testing.T) {
 	testSubsEngine := NewTestSubsEngine()
-	testModel, err := model.NewModel(nil, nil, testSubsEngine)
+	testModel, err := model.NewModel(model.Options{}, nil, nil, testSubsEngine)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
@@ -695,6 +3923,12 @@ func TestSubscriptions(t *testing.T) {
 		t.Error("CreateUser didn't correctly notify subscriptions")
 	}
 
+	testSubsEngine.Reset()
+	testModel.CreateVirtualUser("user1", "virtual1")
+	if testSubsEngine.UsersChangedCalled != 1 {
+		t.Error("CreateVirtualUser didn't correctly notify subscriptions")
+	}
+
 	testSubsEngine.Reset()
 	testModel.DeleteUser("user1")
 	if testSubsEngine.UsersChangedCalled != 1 {
@@ -709,28 +3943,78 @@ func TestSubscriptions(t *testing.T) {
 	}
 
 	testSubsEngine.Reset()
-	testModel.UnblockUser("user1", "Anonymous")
-	if testSubsEngine.UserChangedCalled != 1 || testSubsEngine.UserChangedUsername[0] != "user1" {
-		t.Error("UnblockUser didn't correctly notify subscriptions")
+	testModel.UnblockUser("user1", "Anonymous")
+	if testSubsEngine.UserChangedCalled != 1 || testSubsEngine.UserChangedUsername[0] != "user1" {
+		t.Error("UnblockUser didn't correctly notify subscriptions")
+	}
+
+	testSubsEngine.Reset()
+	testModel.MuteChannel("user1", "General")
+	if testSubsEngine.UserChangedCalled != 1 || testSubsEngine.UserChangedUsername[0] != "user1" {
+		t.Error("MuteChannel didn't correctly notify subscriptions")
+	}
+
+	testSubsEngine.Reset()
+	testModel.UnmuteChannel("user1", "General")
+	if testSubsEngine.UserChangedCalled != 1 || testSubsEngine.UserChangedUsername[0] != "user1" {
+		t.Error("UnmuteChannel didn't correctly notify subscriptions")
+	}
+
+	testSubsEngine.Reset()
+	testModel.CreateChannel("channel1")
+	if testSubsEngine.ChannelsChangedCalled != 1 {
+		t.Error("CreateChannel didn't correctly notify subscriptions")
+	}
+
+	testSubsEngine.Reset()
+	testModel.DeleteChannel("channel1")
+	if testSubsEngine.ChannelsChangedCalled != 1 {
+		t.Error("DeleteChannel didn't correctly notify subscriptions")
+	}
+
+	testModel.CreateChannel("channel1")
+	testSubsEngine.Reset()
+	testModel.SetChannelTopic("channel1", "topic1")
+	if testSubsEngine.ChannelChangedCalled != 1 || testSubsEngine.ChannelChangedChannelname[0] != "channel1" {
+		t.Error("SetChannelTopic didn't correctly notify subscriptions")
+	}
+
+	testSubsEngine.Reset()
+	testModel.SetChannelRules("channel1", "en", "rules1")
+	if testSubsEngine.ChannelChangedCalled != 1 || testSubsEngine.ChannelChangedChannelname[0] != "channel1" {
+		t.Error("SetChannelRules didn't correctly notify subscriptions")
+	}
+
+	testSubsEngine.Reset()
+	testModel.JoinChannel("user1", "channel1")
+	if testSubsEngine.ChannelChangedCalled != 1 || testSubsEngine.ChannelChangedChannelname[0] != "channel1" {
+		t.Error("JoinChannel didn't correctly notify subscriptions")
+	}
+
+	testSubsEngine.Reset()
+	testModel.LeaveChannel("user1", "channel1")
+	if testSubsEngine.ChannelChangedCalled != 1 || testSubsEngine.ChannelChangedChannelname[0] != "channel1" {
+		t.Error("LeaveChannel didn't correctly notify subscriptions")
 	}
 
 	testSubsEngine.Reset()
-	testModel.CreateChannel("channel1")
-	if testSubsEngine.ChannelsChangedCalled != 1 {
-		t.Error("CreateChannel didn't correctly notify subscriptions")
+	message, _ := testModel.PostMessage("channel1", "user1", time.Now(), "message1")
+	if testSubsEngine.ChannelChangedCalled != 1 || testSubsEngine.ChannelChangedChannelname[0] != "channel1" {
+		t.Error("PostMessage didn't correctly notify subscriptions")
 	}
 
 	testSubsEngine.Reset()
-	testModel.DeleteChannel("channel1")
-	if testSubsEngine.ChannelsChangedCalled != 1 {
-		t.Error("DeleteChannel didn't correctly notify subscriptions")
+	testModel.EditMessage("channel1", message.ID, "user1", "message2")
+	if testSubsEngine.MessageChangedCalled != 1 || testSubsEngine.MessageChangedChannelname[0] != "channel1" ||
+		testSubsEngine.MessageChangedID[0] != message.ID || testSubsEngine.ChannelChangedCalled != 0 {
+		t.Error("EditMessage didn't correctly notify subscriptions")
 	}
 
-	testModel.CreateChannel("channel1")
 	testSubsEngine.Reset()
-	testModel.PostMessage("channel1", "user1", time.Now(), "message1")
-	if testSubsEngine.ChannelChangedCalled != 1 || testSubsEngine.ChannelChangedChannelname[0] != "channel1" {
-		t.Error("PostMessage didn't correctly notify subscriptions")
+	testModel.DeleteMessage("channel1", message.ID, "user1")
+	if testSubsEngine.MessageChangedCalled != 1 || testSubsEngine.MessageChangedChannelname[0] != "channel1" ||
+		testSubsEngine.MessageChangedID[0] != message.ID || testSubsEngine.ChannelChangedCalled != 0 {
+		t.Error("DeleteMessage didn't correctly notify subscriptions")
 	}
 }
 
@@ -759,29 +4043,154 @@ func (t *TestActionsReplayer) Replay(actor actions.Actor) error {
 	return t.ReplayError
 }
 
+func TestReplayWithoutJoins(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	// A log written before the memberships were logged has no JoinChannel actions
+	logFilePath := filepath.Join(tempDir, "log.txt")
+	logger, err := actions.NewLogger(logFilePath)
+	if err != nil {
+		t.Fatal("Failed to create Logger")
+	}
+
+	logger.CreateChannel("General")
+	logger.CreateUser("Anonymous")
+	logger.CreateUser("user1")
+	logger.CreateVirtualUser("user1", "virtual1")
+	logger.CreateChannel("channel1")
+	logger.PostMessage("channel1", "user1", time.Now(), time.Time{}, "message1")
+
+	// Ensure that its users join the default channels after it's replayed, logging the joins
+	for i := 0; i < 2; i++ {
+		replayer, err := actions.NewReplayer(logFilePath)
+		if err != nil {
+			t.Fatal("Failed to create Replayer")
+		}
+
+		testModel, err := model.NewModel(model.Options{}, replayer, logger, nil)
+		if err != nil {
+			t.Fatal("Failed to create model")
+		}
+
+		if len(testModel.GetJoinedChannels("user1")) != 1 || len(testModel.GetJoinedChannels("Anonymous")) != 1 {
+			t.Error("Failed to join the users to the default channels")
+		}
+
+		if len(testModel.GetJoinedChannels("virtual1")) != 0 {
+			t.Error("Joined a virtual user to the default channels")
+		}
+
+		// The users that left a default channel stay out of it once the joins are logged
+		if i == 0 {
+			testModel.LeaveChannel("user1", "General")
+			testModel.JoinChannel("user1", "channel1")
+		} else if _, ok := testModel.GetJoinedChannels("user1")["General"]; ok {
+			t.Error("Joined the default channels again")
+		}
+	}
+}
+
 func TestActionReplay(t *testing.T) {
 	testActionsReplayer := NewTestActionsReplayer()
 
 	testActionsReplayer.ReplayError = errors.New("Failed replay")
-	testModel, err := model.NewModel(testActionsReplayer, nil, nil)
+	testModel, err := model.NewModel(model.Options{}, testActionsReplayer, nil, nil)
 	if err == nil {
 		t.Error("NewModel didn't fail when replayer did")
 	}
 
 	testActionsReplayer.Reset()
-	testModel, err = model.NewModel(testActionsReplayer, nil, nil)
+	testModel, err = model.NewModel(model.Options{}, testActionsReplayer, nil, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
 
-	if testActionsReplayer.ReplayCalled != 1 || testActionsReplayer.ReplayActor[0] != testModel {
+	if testActionsReplayer.ReplayCalled != 1 {
+		t.Error("Incorrect usage of the actionsReplayer")
+	}
+
+	// The replayed actions are applied to the model
+	testActionsReplayer.ReplayActor[0].CreateUser("user1")
+	if _, ok := testModel.GetUsers()["user1"]; !ok {
 		t.Error("Incorrect usage of the actionsReplayer")
 	}
 }
 
+func TestReadReplica(t *testing.T) {
+	replica, err := model.NewModel(model.Options{TrustTimestamps: true}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create replica")
+	}
+
+	// The replica is fed every action the model logs
+	testModel, err := model.NewModel(model.Options{}, nil, actions.NewFanout(replica.Actor()), nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testModel.CreateUser("bridge1")
+	testModel.CreateVirtualUser("bridge1", "virtual1")
+	testModel.CreateUser("user1")
+	testModel.CreateChannel("channel1")
+	testModel.SetChannelTopic("channel1", "topic1")
+	testModel.JoinChannel("user1", "channel1")
+	message, _ := testModel.PostMessage("channel1", "user1", time.Now(), "message0")
+	testModel.PostBridgedMessage("channel1", "virtual1", time.Now(), "message2", "Slack", "alice")
+	testModel.EditMessage("channel1", message.ID, "user1", "message1")
+	deleted, _ := testModel.PostMessage("channel1", "user1", time.Now(), "message3")
+	testModel.ModerateMessage("channel1", deleted.ID)
+	testModel.BlockUser("user1", "Anonymous")
+	testModel.CreateUser("user2")
+	direct, _ := testModel.PostDirectMessage("user2", "user1", time.Now(), "direct1")
+	groupID, _ := testModel.CreateGroup("user1", []string{"user2"})
+	groupMessage, _ := testModel.PostGroupMessage(groupID, "user2", time.Now(), "group1")
+	testModel.DeleteUser("bridge1")
+
+	if len(replica.GetUsers()) != len(testModel.GetUsers()) || len(replica.GetChannels()) != len(testModel.GetChannels()) {
+		t.Error("Replica users/channels differ from the model")
+	}
+
+	if replica.GetChannelInfo("channel1").Topic != "topic1" || len(replica.GetJoinedChannels("user1")) != len(testModel.GetJoinedChannels("user1")) {
+		t.Error("Replica channel info differs from the model")
+	}
+
+	replicaMessages := replica.GetChannelHistory("channel1", "user1", 10)
+	modelMessages := testModel.GetChannelHistory("channel1", "user1", 10)
+	if len(replicaMessages) != 3 || len(replicaMessages) != len(modelMessages) || !replicaMessages[2].Deleted {
+		t.Error("Replica history differs from the model")
+	} else if replicaMessages[1].DisplayAuthor() != modelMessages[1].DisplayAuthor() || replicaMessages[0].Text != "message1" ||
+		replicaMessages[1].ID != modelMessages[1].ID || !replicaMessages[1].Timestamp.Equal(modelMessages[1].Timestamp) ||
+		!replicaMessages[0].Edited.Equal(modelMessages[0].Edited) {
+		t.Error("Replica messages differ from the model")
+	}
+
+	blockedUsers := replica.GetUserInfo("user1").BlockedUsers
+	if len(blockedUsers) != 1 || blockedUsers[0] != "Anonymous" {
+		t.Error("Replica didn't block user")
+	}
+
+	replicaDirect := replica.GetDirectMessageHistory("user1", "user2", -1)
+	if len(replicaDirect) != 1 || replicaDirect[0].ID != direct.ID || replicaDirect[0].Text != "direct1" || !replicaDirect[0].Timestamp.Equal(direct.Timestamp) {
+		t.Error("Replica direct messages differ from the model")
+	}
+
+	replicaGroup := replica.GetGroupMessageHistory(groupID, "user1", -1)
+	if len(replicaGroup) != 1 || replicaGroup[0].ID != groupMessage.ID || replicaGroup[0].Text != "group1" || !replicaGroup[0].Timestamp.Equal(groupMessage.Timestamp) {
+		t.Error("Replica group messages differ from the model")
+	}
+}
+
 type TestActionsLogger struct {
 	CreateUserCalled             int
 	CreateUserUsername           []string
+	CreateVirtualUserCalled      int
+	CreateVirtualUserOwner       []string
+	CreateVirtualUserUsername    []string
 	DeleteUserCalled             int
 	DeleteUserUsername           []string
 	BlockUserCalled              int
@@ -790,15 +4199,132 @@ type TestActionsLogger struct {
 	UnblockUserCalled            int
 	UnblockUserUsername          []string
 	UnblockUserUsernameToUnblock []string
+	MuteChannelCalled            int
+	MuteChannelUsername          []string
+	MuteChannelChannelname       []string
+	UnmuteChannelCalled          int
+	UnmuteChannelUsername        []string
+	UnmuteChannelChannelname     []string
 	CreateChannelCalled          int
 	CreateChannelChannelname     []string
 	DeleteChannelCalled          int
 	DeleteChannelChannelname     []string
+	SetChannelTopicCalled        int
+	SetChannelTopicChannelname   []string
+	SetChannelTopicTopic         []string
+	SetChannelRulesCalled        int
+	SetChannelRulesChannelname   []string
+	SetChannelRulesLanguage      []string
+	SetChannelRulesRules         []string
+	JoinChannelCalled            int
+	JoinChannelUsername          []string
+	JoinChannelChannelname       []string
+	LeaveChannelCalled           int
+	LeaveChannelUsername         []string
+	LeaveChannelChannelname      []string
 	PostMessageCalled            int
 	PostMessageChannelname       []string
 	PostMessageUsername          []string
 	PostMessageTimestamp         []time.Time
 	PostMessageText              []string
+	PostBridgedMessageCalled     int
+	PostBridgedMessageUsername   []string
+	PostBridgedMessageText       []string
+	PostBridgedMessageSystem     []string
+	PostBridgedMessageAuthor     []string
+	PostSnippetCalled            int
+	SetUserProfileCalled         int
+	SetUserProfileUsername       []string
+	SetUserProfileProfile        []model.Profile
+	SetUserStatusCalled          int
+	SetUserStatusUsername        []string
+	SetUserStatusStatus          []model.Status
+	PostSnippetUsername          []string
+	PostSnippetLanguage          []string
+	PostSnippetText              []string
+	EditMessageCalled            int
+	EditMessageChannelname       []string
+	EditMessageID                []uint64
+	EditMessageUsername          []string
+	EditMessageTimestamp         []time.Time
+	EditMessageText              []string
+	DeleteMessageCalled          int
+	DeleteMessageChannelname     []string
+	DeleteMessageID              []uint64
+	DeleteMessageUsername        []string
+	PostDirectMessageCalled      int
+	PostDirectMessageUsername    []string
+	PostDirectMessageRecipient   []string
+	PostDirectMessageTimestamp   []time.Time
+	PostDirectMessageText        []string
+	CreateGroupCalled            int
+	CreateGroupUsername          []string
+	CreateGroupMembers           [][]string
+	PostGroupMessageCalled       int
+	PostGroupMessageID           []uint64
+	PostGroupMessageUsername     []string
+	PostGroupMessageTimestamp    []time.Time
+	PostGroupMessageText         []string
+	RestoreChannelCalled         int
+	RestoreChannelChannelname    []string
+	PutPluginDataCalled          int
+	PutPluginDataNamespace       []string
+	PutPluginDataKey             []string
+	PutPluginDataValue           []string
+	RestoreSnapshotCalled        int
+	RestoreSnapshotSnapshot      []*actions.Snapshot
+	AttachFileCalled             int
+	AttachFileChannelname        []string
+	AttachFileMessageID          []uint64
+	AttachFileUsername           []string
+	AttachFileAttachment         []actions.Attachment
+	RenameChannelCalled          int
+	RenameChannelChannelname     []string
+	RenameChannelNewChannelname  []string
+	RenameUserCalled             int
+	RenameUserUsername           []string
+	RenameUserNewUsername        []string
+	CreateTeamCalled             int
+	CreateTeamTeamname           []string
+	DeleteTeamCalled             int
+	DeleteTeamTeamname           []string
+	AddTeamMemberCalled          int
+	AddTeamMemberTeamname        []string
+	AddTeamMemberUsername        []string
+	RemoveTeamMemberCalled       int
+	RemoveTeamMemberTeamname     []string
+	RemoveTeamMemberUsername     []string
+	CreateEventCalled            int
+	CreateEventChannelname       []string
+	CreateEventUsername          []string
+	CreateEventStart             []time.Time
+	CreateEventTitle             []string
+	RSVPEventCalled              int
+	RSVPEventEventID             []uint64
+	RSVPEventUsername            []string
+	RSVPEventGoing               []bool
+	MarkEventRemindedCalled      int
+	MarkEventRemindedEventID     []uint64
+	MarkReadCalled               int
+	MarkReadUsername             []string
+	MarkReadChannelname          []string
+	MarkReadMessageID            []uint64
+	PostMessageMultiCalled       int
+	PostMessageMultiChannelnames [][]string
+	PostMessageMultiUsername     []string
+	PostMessageMultiTimestamp    []time.Time
+	PostMessageMultiText         []string
+	StarMessageCalled            int
+	StarMessageUsername          []string
+	StarMessageChannelname       []string
+	StarMessageMessageID         []uint64
+	StarMessageStarred           []bool
+	SetChannelNotesCalled        int
+	SetChannelNotesChannelname   []string
+	SetChannelNotesUsername      []string
+	SetChannelNotesText          []string
+	BeginBatchCalled             int
+	EndBatchCalled               int
 }
 
 func NewTestActionsLogger() *TestActionsLogger {
@@ -811,6 +4337,9 @@ func NewTestActionsLogger() *TestActionsLogger {
 func (t *TestActionsLogger) Reset() {
 	t.CreateUserCalled = 0
 	t.CreateUserUsername = make([]string, 0)
+	t.CreateVirtualUserCalled = 0
+	t.CreateVirtualUserOwner = make([]string, 0)
+	t.CreateVirtualUserUsername = make([]string, 0)
 	t.DeleteUserCalled = 0
 	t.DeleteUserUsername = make([]string, 0)
 	t.BlockUserCalled = 0
@@ -819,15 +4348,132 @@ func (t *TestActionsLogger) Reset() {
 	t.UnblockUserCalled = 0
 	t.UnblockUserUsername = make([]string, 0)
 	t.UnblockUserUsernameToUnblock = make([]string, 0)
+	t.MuteChannelCalled = 0
+	t.MuteChannelUsername = make([]string, 0)
+	t.MuteChannelChannelname = make([]string, 0)
+	t.UnmuteChannelCalled = 0
+	t.UnmuteChannelUsername = make([]string, 0)
+	t.UnmuteChannelChannelname = make([]string, 0)
 	t.CreateChannelCalled = 0
 	t.CreateChannelChannelname = make([]string, 0)
 	t.DeleteChannelCalled = 0
 	t.DeleteChannelChannelname = make([]string, 0)
+	t.SetChannelTopicCalled = 0
+	t.SetChannelTopicChannelname = make([]string, 0)
+	t.SetChannelTopicTopic = make([]string, 0)
+	t.SetChannelRulesCalled = 0
+	t.SetChannelRulesChannelname = make([]string, 0)
+	t.SetChannelRulesLanguage = make([]string, 0)
+	t.SetChannelRulesRules = make([]string, 0)
+	t.JoinChannelCalled = 0
+	t.JoinChannelUsername = make([]string, 0)
+	t.JoinChannelChannelname = make([]string, 0)
+	t.LeaveChannelCalled = 0
+	t.LeaveChannelUsername = make([]string, 0)
+	t.LeaveChannelChannelname = make([]string, 0)
 	t.PostMessageCalled = 0
 	t.PostMessageChannelname = make([]string, 0)
 	t.PostMessageUsername = make([]string, 0)
 	t.PostMessageTimestamp = make([]time.Time, 0)
 	t.PostMessageText = make([]string, 0)
+	t.PostBridgedMessageCalled = 0
+	t.PostBridgedMessageUsername = make([]string, 0)
+	t.PostBridgedMessageText = make([]string, 0)
+	t.PostBridgedMessageSystem = make([]string, 0)
+	t.PostBridgedMessageAuthor = make([]string, 0)
+	t.PostSnippetCalled = 0
+	t.SetUserProfileCalled = 0
+	t.SetUserProfileUsername = make([]string, 0)
+	t.SetUserProfileProfile = make([]model.Profile, 0)
+	t.SetUserStatusCalled = 0
+	t.SetUserStatusUsername = make([]string, 0)
+	t.SetUserStatusStatus = make([]model.Status, 0)
+	t.PostSnippetUsername = make([]string, 0)
+	t.PostSnippetLanguage = make([]string, 0)
+	t.PostSnippetText = make([]string, 0)
+	t.EditMessageCalled = 0
+	t.EditMessageChannelname = make([]string, 0)
+	t.EditMessageID = make([]uint64, 0)
+	t.EditMessageUsername = make([]string, 0)
+	t.EditMessageTimestamp = make([]time.Time, 0)
+	t.EditMessageText = make([]string, 0)
+	t.DeleteMessageCalled = 0
+	t.DeleteMessageChannelname = make([]string, 0)
+	t.DeleteMessageID = make([]uint64, 0)
+	t.DeleteMessageUsername = make([]string, 0)
+	t.PostDirectMessageCalled = 0
+	t.PostDirectMessageUsername = make([]string, 0)
+	t.PostDirectMessageRecipient = make([]string, 0)
+	t.PostDirectMessageTimestamp = make([]time.Time, 0)
+	t.PostDirectMessageText = make([]string, 0)
+	t.CreateGroupCalled = 0
+	t.CreateGroupUsername = make([]string, 0)
+	t.CreateGroupMembers = make([][]string, 0)
+	t.PostGroupMessageCalled = 0
+	t.PostGroupMessageID = make([]uint64, 0)
+	t.PostGroupMessageUsername = make([]string, 0)
+	t.PostGroupMessageTimestamp = make([]time.Time, 0)
+	t.PostGroupMessageText = make([]string, 0)
+	t.RestoreChannelCalled = 0
+	t.RestoreChannelChannelname = make([]string, 0)
+	t.PutPluginDataCalled = 0
+	t.PutPluginDataNamespace = make([]string, 0)
+	t.PutPluginDataKey = make([]string, 0)
+	t.PutPluginDataValue = make([]string, 0)
+	t.RestoreSnapshotCalled = 0
+	t.RestoreSnapshotSnapshot = make([]*actions.Snapshot, 0)
+	t.AttachFileCalled = 0
+	t.AttachFileChannelname = make([]string, 0)
+	t.AttachFileMessageID = make([]uint64, 0)
+	t.AttachFileUsername = make([]string, 0)
+	t.AttachFileAttachment = make([]actions.Attachment, 0)
+	t.RenameChannelCalled = 0
+	t.RenameChannelChannelname = make([]string, 0)
+	t.RenameChannelNewChannelname = make([]string, 0)
+	t.RenameUserCalled = 0
+	t.RenameUserUsername = make([]string, 0)
+	t.RenameUserNewUsername = make([]string, 0)
+	t.CreateTeamCalled = 0
+	t.CreateTeamTeamname = make([]string, 0)
+	t.DeleteTeamCalled = 0
+	t.DeleteTeamTeamname = make([]string, 0)
+	t.AddTeamMemberCalled = 0
+	t.AddTeamMemberTeamname = make([]string, 0)
+	t.AddTeamMemberUsername = make([]string, 0)
+	t.RemoveTeamMemberCalled = 0
+	t.RemoveTeamMemberTeamname = make([]string, 0)
+	t.RemoveTeamMemberUsername = make([]string, 0)
+	t.CreateEventCalled = 0
+	t.CreateEventChannelname = make([]string, 0)
+	t.CreateEventUsername = make([]string, 0)
+	t.CreateEventStart = make([]time.Time, 0)
+	t.CreateEventTitle = make([]string, 0)
+	t.RSVPEventCalled = 0
+	t.RSVPEventEventID = make([]uint64, 0)
+	t.RSVPEventUsername = make([]string, 0)
+	t.RSVPEventGoing = make([]bool, 0)
+	t.MarkEventRemindedCalled = 0
+	t.MarkEventRemindedEventID = make([]uint64, 0)
+	t.MarkReadCalled = 0
+	t.MarkReadUsername = make([]string, 0)
+	t.MarkReadChannelname = make([]string, 0)
+	t.MarkReadMessageID = make([]uint64, 0)
+	t.PostMessageMultiCalled = 0
+	t.PostMessageMultiChannelnames = make([][]string, 0)
+	t.PostMessageMultiUsername = make([]string, 0)
+	t.PostMessageMultiTimestamp = make([]time.Time, 0)
+	t.PostMessageMultiText = make([]string, 0)
+	t.StarMessageCalled = 0
+	t.StarMessageUsername = make([]string, 0)
+	t.StarMessageChannelname = make([]string, 0)
+	t.StarMessageMessageID = make([]uint64, 0)
+	t.StarMessageStarred = make([]bool, 0)
+	t.SetChannelNotesCalled = 0
+	t.SetChannelNotesChannelname = make([]string, 0)
+	t.SetChannelNotesUsername = make([]string, 0)
+	t.SetChannelNotesText = make([]string, 0)
+	t.BeginBatchCalled = 0
+	t.EndBatchCalled = 0
 }
 
 func (t *TestActionsLogger) CreateUser(username string) {
@@ -835,6 +4481,12 @@ func (t *TestActionsLogger) CreateUser(username string) {
 	t.CreateUserUsername = append(t.CreateUserUsername, username)
 }
 
+func (t *TestActionsLogger) CreateVirtualUser(ownerUsername string, username string) {
+	t.CreateVirtualUserCalled++
+	t.CreateVirtualUserOwner = append(t.CreateVirtualUserOwner, ownerUsername)
+	t.CreateVirtualUserUsername = append(t.CreateVirtualUserUsername, username)
+}
+
 func (t *TestActionsLogger) DeleteUser(username string) {
 	t.DeleteUserCalled++
 	t.DeleteUserUsername = append(t.DeleteUserUsername, username)
@@ -852,6 +4504,18 @@ func (t *TestActionsLogger) UnblockUser(username string, usernameToUnblock strin
 	t.UnblockUserUsernameToUnblock = append(t.UnblockUserUsernameToUnblock, usernameToUnblock)
 }
 
+func (t *TestActionsLogger) MuteChannel(username string, channelname string) {
+	t.MuteChannelCalled++
+	t.MuteChannelUsername = append(t.MuteChannelUsername, username)
+	t.MuteChannelChannelname = append(t.MuteChannelChannelname, channelname)
+}
+
+func (t *TestActionsLogger) UnmuteChannel(username string, channelname string) {
+	t.UnmuteChannelCalled++
+	t.UnmuteChannelUsername = append(t.UnmuteChannelUsername, username)
+	t.UnmuteChannelChannelname = append(t.UnmuteChannelChannelname, channelname)
+}
+
 func (t *TestActionsLogger) CreateChannel(channelname string) {
 	t.CreateChannelCalled++
 	t.CreateChannelChannelname = append(t.CreateChannelChannelname, channelname)
@@ -862,7 +4526,32 @@ func (t *TestActionsLogger) DeleteChannel(channelname string) {
 	t.DeleteChannelChannelname = append(t.DeleteChannelChannelname, channelname)
 }
 
-func (t *TestActionsLogger) PostMessage(channelname string, username string, timestamp time.Time, text string) {
+func (t *TestActionsLogger) SetChannelTopic(channelname string, topic string) {
+	t.SetChannelTopicCalled++
+	t.SetChannelTopicChannelname = append(t.SetChannelTopicChannelname, channelname)
+	t.SetChannelTopicTopic = append(t.SetChannelTopicTopic, topic)
+}
+
+func (t *TestActionsLogger) SetChannelRules(channelname string, language string, rules string) {
+	t.SetChannelRulesCalled++
+	t.SetChannelRulesChannelname = append(t.SetChannelRulesChannelname, channelname)
+	t.SetChannelRulesLanguage = append(t.SetChannelRulesLanguage, language)
+	t.SetChannelRulesRules = append(t.SetChannelRulesRules, rules)
+}
+
+func (t *TestActionsLogger) JoinChannel(username string, channelname string) {
+	t.JoinChannelCalled++
+	t.JoinChannelUsername = append(t.JoinChannelUsername, username)
+	t.JoinChannelChannelname = append(t.JoinChannelChannelname, channelname)
+}
+
+func (t *TestActionsLogger) LeaveChannel(username string, channelname string) {
+	t.LeaveChannelCalled++
+	t.LeaveChannelUsername = append(t.LeaveChannelUsername, username)
+	t.LeaveChannelChannelname = append(t.LeaveChannelChannelname, channelname)
+}
+
+func (t *TestActionsLogger) PostMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
 	t.PostMessageCalled++
 	t.PostMessageChannelname = append(t.PostMessageChannelname, channelname)
 	t.PostMessageUsername = append(t.PostMessageUsername, username)
@@ -870,9 +4559,192 @@ func (t *TestActionsLogger) PostMessage(channelname string, username string, tim
 	t.PostMessageText = append(t.PostMessageText, text)
 }
 
+func (t *TestActionsLogger) PostBridgedMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string, originSystem string, originAuthor string) {
+	t.PostBridgedMessageCalled++
+	t.PostBridgedMessageUsername = append(t.PostBridgedMessageUsername, username)
+	t.PostBridgedMessageText = append(t.PostBridgedMessageText, text)
+	t.PostBridgedMessageSystem = append(t.PostBridgedMessageSystem, originSystem)
+	t.PostBridgedMessageAuthor = append(t.PostBridgedMessageAuthor, originAuthor)
+}
+
+func (t *TestActionsLogger) SetUserProfile(username string, displayName string, bio string, pronouns string) {
+	t.SetUserProfileCalled++
+	t.SetUserProfileUsername = append(t.SetUserProfileUsername, username)
+	t.SetUserProfileProfile = append(t.SetUserProfileProfile, model.Profile{DisplayName: displayName, Bio: bio, Pronouns: pronouns})
+}
+
+func (t *TestActionsLogger) SetUserStatus(username string, text string, away bool) {
+	t.SetUserStatusCalled++
+	t.SetUserStatusUsername = append(t.SetUserStatusUsername, username)
+	t.SetUserStatusStatus = append(t.SetUserStatusStatus, model.Status{Text: text, Away: away})
+}
+
+func (t *TestActionsLogger) PostSnippet(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, language string, text string) {
+	t.PostSnippetCalled++
+	t.PostSnippetUsername = append(t.PostSnippetUsername, username)
+	t.PostSnippetLanguage = append(t.PostSnippetLanguage, language)
+	t.PostSnippetText = append(t.PostSnippetText, text)
+}
+
+func (t *TestActionsLogger) EditMessage(channelname string, messageID uint64, username string, timestamp time.Time, text string) {
+	t.EditMessageCalled++
+	t.EditMessageChannelname = append(t.EditMessageChannelname, channelname)
+	t.EditMessageID = append(t.EditMessageID, messageID)
+	t.EditMessageUsername = append(t.EditMessageUsername, username)
+	t.EditMessageTimestamp = append(t.EditMessageTimestamp, timestamp)
+	t.EditMessageText = append(t.EditMessageText, text)
+}
+
+func (t *TestActionsLogger) DeleteMessage(channelname string, messageID uint64, username string) {
+	t.DeleteMessageCalled++
+	t.DeleteMessageChannelname = append(t.DeleteMessageChannelname, channelname)
+	t.DeleteMessageID = append(t.DeleteMessageID, messageID)
+	t.DeleteMessageUsername = append(t.DeleteMessageUsername, username)
+}
+
+func (t *TestActionsLogger) PostDirectMessage(username string, recipient string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	t.PostDirectMessageCalled++
+	t.PostDirectMessageUsername = append(t.PostDirectMessageUsername, username)
+	t.PostDirectMessageRecipient = append(t.PostDirectMessageRecipient, recipient)
+	t.PostDirectMessageTimestamp = append(t.PostDirectMessageTimestamp, timestamp)
+	t.PostDirectMessageText = append(t.PostDirectMessageText, text)
+}
+
+func (t *TestActionsLogger) CreateGroup(username string, members []string) {
+	t.CreateGroupCalled++
+	t.CreateGroupUsername = append(t.CreateGroupUsername, username)
+	t.CreateGroupMembers = append(t.CreateGroupMembers, members)
+}
+
+func (t *TestActionsLogger) PostGroupMessage(groupID uint64, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	t.PostGroupMessageCalled++
+	t.PostGroupMessageID = append(t.PostGroupMessageID, groupID)
+	t.PostGroupMessageUsername = append(t.PostGroupMessageUsername, username)
+	t.PostGroupMessageTimestamp = append(t.PostGroupMessageTimestamp, timestamp)
+	t.PostGroupMessageText = append(t.PostGroupMessageText, text)
+}
+
+func (t *TestActionsLogger) RestoreChannel(channelname string) {
+	t.RestoreChannelCalled++
+	t.RestoreChannelChannelname = append(t.RestoreChannelChannelname, channelname)
+}
+
+func (t *TestActionsLogger) PutPluginData(namespace string, key string, value string) {
+	t.PutPluginDataCalled++
+	t.PutPluginDataNamespace = append(t.PutPluginDataNamespace, namespace)
+	t.PutPluginDataKey = append(t.PutPluginDataKey, key)
+	t.PutPluginDataValue = append(t.PutPluginDataValue, value)
+}
+
+func (t *TestActionsLogger) RestoreSnapshot(snapshot *actions.Snapshot) {
+	t.RestoreSnapshotCalled++
+	t.RestoreSnapshotSnapshot = append(t.RestoreSnapshotSnapshot, snapshot)
+}
+
+func (t *TestActionsLogger) AttachFile(channelname string, messageID uint64, username string, attachment actions.Attachment) {
+	t.AttachFileCalled++
+	t.AttachFileChannelname = append(t.AttachFileChannelname, channelname)
+	t.AttachFileMessageID = append(t.AttachFileMessageID, messageID)
+	t.AttachFileUsername = append(t.AttachFileUsername, username)
+	t.AttachFileAttachment = append(t.AttachFileAttachment, attachment)
+}
+
+func (t *TestActionsLogger) RenameChannel(channelname string, newChannelname string) {
+	t.RenameChannelCalled++
+	t.RenameChannelChannelname = append(t.RenameChannelChannelname, channelname)
+	t.RenameChannelNewChannelname = append(t.RenameChannelNewChannelname, newChannelname)
+}
+
+func (t *TestActionsLogger) RenameUser(username string, newUsername string) {
+	t.RenameUserCalled++
+	t.RenameUserUsername = append(t.RenameUserUsername, username)
+	t.RenameUserNewUsername = append(t.RenameUserNewUsername, newUsername)
+}
+
+func (t *TestActionsLogger) CreateTeam(teamname string) {
+	t.CreateTeamCalled++
+	t.CreateTeamTeamname = append(t.CreateTeamTeamname, teamname)
+}
+
+func (t *TestActionsLogger) DeleteTeam(teamname string) {
+	t.DeleteTeamCalled++
+	t.DeleteTeamTeamname = append(t.DeleteTeamTeamname, teamname)
+}
+
+func (t *TestActionsLogger) AddTeamMember(teamname string, username string) {
+	t.AddTeamMemberCalled++
+	t.AddTeamMemberTeamname = append(t.AddTeamMemberTeamname, teamname)
+	t.AddTeamMemberUsername = append(t.AddTeamMemberUsername, username)
+}
+
+func (t *TestActionsLogger) RemoveTeamMember(teamname string, username string) {
+	t.RemoveTeamMemberCalled++
+	t.RemoveTeamMemberTeamname = append(t.RemoveTeamMemberTeamname, teamname)
+	t.RemoveTeamMemberUsername = append(t.RemoveTeamMemberUsername, username)
+}
+
+func (t *TestActionsLogger) CreateEvent(channelname string, username string, start time.Time, title string) {
+	t.CreateEventCalled++
+	t.CreateEventChannelname = append(t.CreateEventChannelname, channelname)
+	t.CreateEventUsername = append(t.CreateEventUsername, username)
+	t.CreateEventStart = append(t.CreateEventStart, start)
+	t.CreateEventTitle = append(t.CreateEventTitle, title)
+}
+
+func (t *TestActionsLogger) RSVPEvent(eventID uint64, username string, going bool) {
+	t.RSVPEventCalled++
+	t.RSVPEventEventID = append(t.RSVPEventEventID, eventID)
+	t.RSVPEventUsername = append(t.RSVPEventUsername, username)
+	t.RSVPEventGoing = append(t.RSVPEventGoing, going)
+}
+
+func (t *TestActionsLogger) MarkEventReminded(eventID uint64) {
+	t.MarkEventRemindedCalled++
+	t.MarkEventRemindedEventID = append(t.MarkEventRemindedEventID, eventID)
+}
+
+func (t *TestActionsLogger) MarkRead(username string, channelname string, messageID uint64) {
+	t.MarkReadCalled++
+	t.MarkReadUsername = append(t.MarkReadUsername, username)
+	t.MarkReadChannelname = append(t.MarkReadChannelname, channelname)
+	t.MarkReadMessageID = append(t.MarkReadMessageID, messageID)
+}
+
+func (t *TestActionsLogger) PostMessageMulti(channelnames []string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	t.PostMessageMultiCalled++
+	t.PostMessageMultiChannelnames = append(t.PostMessageMultiChannelnames, channelnames)
+	t.PostMessageMultiUsername = append(t.PostMessageMultiUsername, username)
+	t.PostMessageMultiTimestamp = append(t.PostMessageMultiTimestamp, timestamp)
+	t.PostMessageMultiText = append(t.PostMessageMultiText, text)
+}
+
+func (t *TestActionsLogger) StarMessage(username string, channelname string, messageID uint64, starred bool) {
+	t.StarMessageCalled++
+	t.StarMessageUsername = append(t.StarMessageUsername, username)
+	t.StarMessageChannelname = append(t.StarMessageChannelname, channelname)
+	t.StarMessageMessageID = append(t.StarMessageMessageID, messageID)
+	t.StarMessageStarred = append(t.StarMessageStarred, starred)
+}
+
+func (t *TestActionsLogger) SetChannelNotes(channelname string, username string, timestamp time.Time, text string) {
+	t.SetChannelNotesCalled++
+	t.SetChannelNotesChannelname = append(t.SetChannelNotesChannelname, channelname)
+	t.SetChannelNotesUsername = append(t.SetChannelNotesUsername, username)
+	t.SetChannelNotesText = append(t.SetChannelNotesText, text)
+}
+
+func (t *TestActionsLogger) BeginBatch() {
+	t.BeginBatchCalled++
+}
+
+func (t *TestActionsLogger) EndBatch() {
+	t.EndBatchCalled++
+}
+
 func TestActionLogging(t *testing.T) {
+	timestamp := time.Now()
 	testActionsLogger := NewTestActionsLogger()
-	testModel, err := model.NewModel(nil, testActionsLogger, nil)
+	testModel, err := model.NewModel(model.Options{Clock: func() time.Time { return timestamp }}, nil, testActionsLogger, nil)
 	if err != nil {
 		t.Error("Failed to create model")
 	}
@@ -885,12 +4757,23 @@ func TestActionLogging(t *testing.T) {
 		t.Error("Didn't create General channel")
 	}
 
+	if testActionsLogger.JoinChannelCalled != 1 || testActionsLogger.JoinChannelUsername[0] != "Anonymous" || testActionsLogger.JoinChannelChannelname[0] != "General" {
+		t.Error("Didn't join Anonymous to General channel")
+	}
+
 	testActionsLogger.Reset()
 	testModel.CreateUser("user1")
 	if testActionsLogger.CreateUserCalled != 1 || testActionsLogger.CreateUserUsername[0] != "user1" {
 		t.Error("CreateUser didn't correctly log action")
 	}
 
+	testActionsLogger.Reset()
+	testModel.CreateVirtualUser("user1", "virtual1")
+	if testActionsLogger.CreateVirtualUserCalled != 1 || testActionsLogger.CreateVirtualUserOwner[0] != "user1" || testActionsLogger.CreateVirtualUserUsername[0] != "virtual1" {
+		t.Error("CreateVirtualUser didn't correctly log action")
+	}
+
+	// Deleting the owner only logs the owner (the virtual users are deleted again on replay)
 	testActionsLogger.Reset()
 	testModel.DeleteUser("user1")
 	if testActionsLogger.DeleteUserCalled != 1 || testActionsLogger.DeleteUserUsername[0] != "user1" {
@@ -910,6 +4793,18 @@ func TestActionLogging(t *testing.T) {
 		t.Error("UnblockUser didn't correctly log action")
 	}
 
+	testActionsLogger.Reset()
+	testModel.MuteChannel("user1", "General")
+	if testActionsLogger.MuteChannelCalled != 1 || testActionsLogger.MuteChannelUsername[0] != "user1" || testActionsLogger.MuteChannelChannelname[0] != "General" {
+		t.Error("MuteChannel didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.UnmuteChannel("user1", "General")
+	if testActionsLogger.UnmuteChannelCalled != 1 || testActionsLogger.UnmuteChannelUsername[0] != "user1" || testActionsLogger.UnmuteChannelChannelname[0] != "General" {
+		t.Error("UnmuteChannel didn't correctly log action")
+	}
+
 	testActionsLogger.Reset()
 	testModel.CreateChannel("channel1")
 	if testActionsLogger.CreateChannelCalled != 1 || testActionsLogger.CreateChannelChannelname[0] != "channel1" {
@@ -924,11 +4819,283 @@ func TestActionLogging(t *testing.T) {
 
 	testModel.CreateChannel("channel1")
 	testActionsLogger.Reset()
-	timestamp := time.Now()
-	testModel.PostMessage("channel1", "user1", timestamp, "message1")
+	testModel.SetChannelTopic("channel1", "topic1")
+	if testActionsLogger.SetChannelTopicCalled != 1 || testActionsLogger.SetChannelTopicChannelname[0] != "channel1" || testActionsLogger.SetChannelTopicTopic[0] != "topic1" {
+		t.Error("SetChannelTopic didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.SetChannelRules("channel1", "en", "rules1")
+	if testActionsLogger.SetChannelRulesCalled != 1 || testActionsLogger.SetChannelRulesChannelname[0] != "channel1" ||
+		testActionsLogger.SetChannelRulesLanguage[0] != "en" || testActionsLogger.SetChannelRulesRules[0] != "rules1" {
+		t.Error("SetChannelRules didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.JoinChannel("user1", "channel1")
+	if testActionsLogger.JoinChannelCalled != 1 || testActionsLogger.JoinChannelUsername[0] != "user1" || testActionsLogger.JoinChannelChannelname[0] != "channel1" {
+		t.Error("JoinChannel didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.LeaveChannel("user1", "channel1")
+	if testActionsLogger.LeaveChannelCalled != 1 || testActionsLogger.LeaveChannelUsername[0] != "user1" || testActionsLogger.LeaveChannelChannelname[0] != "channel1" {
+		t.Error("LeaveChannel didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	message, _ := testModel.PostMessage("channel1", "user1", time.Time{}, "message1")
 	if testActionsLogger.PostMessageCalled != 1 || testActionsLogger.PostMessageChannelname[0] != "channel1" ||
 		testActionsLogger.PostMessageUsername[0] != "user1" || testActionsLogger.PostMessageTimestamp[0] != timestamp ||
 		testActionsLogger.PostMessageText[0] != "message1" {
 		t.Error("PostMessage didn't correctly log action")
 	}
+
+	testActionsLogger.Reset()
+	testModel.EditMessage("channel1", message.ID, "user1", "message3")
+	if testActionsLogger.EditMessageCalled != 1 || testActionsLogger.EditMessageChannelname[0] != "channel1" ||
+		testActionsLogger.EditMessageID[0] != message.ID || testActionsLogger.EditMessageUsername[0] != "user1" ||
+		testActionsLogger.EditMessageTimestamp[0] != timestamp || testActionsLogger.EditMessageText[0] != "message3" {
+		t.Error("EditMessage didn't correctly log action")
+	}
+
+	attachment := model.Attachment{ID: strings.Repeat("a", 64), Name: "file1.txt", ContentType: "text/plain", Size: 10}
+	testActionsLogger.Reset()
+	testModel.AttachFile("channel1", message.ID, "user1", attachment)
+	if testActionsLogger.AttachFileCalled != 1 || testActionsLogger.AttachFileChannelname[0] != "channel1" ||
+		testActionsLogger.AttachFileMessageID[0] != message.ID || testActionsLogger.AttachFileUsername[0] != "user1" ||
+		testActionsLogger.AttachFileAttachment[0] != actions.Attachment(attachment) {
+		t.Error("AttachFile didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.DeleteMessage("channel1", message.ID, "user1")
+	if testActionsLogger.DeleteMessageCalled != 1 || testActionsLogger.DeleteMessageChannelname[0] != "channel1" ||
+		testActionsLogger.DeleteMessageID[0] != message.ID || testActionsLogger.DeleteMessageUsername[0] != "user1" {
+		t.Error("DeleteMessage didn't correctly log action")
+	}
+
+	testModel.CreateUser("user2")
+	testActionsLogger.Reset()
+	testModel.PostDirectMessage("user1", "user2", time.Time{}, "direct1")
+	if testActionsLogger.PostDirectMessageCalled != 1 || testActionsLogger.PostMessageCalled != 0 ||
+		testActionsLogger.PostDirectMessageUsername[0] != "user1" || testActionsLogger.PostDirectMessageRecipient[0] != "user2" ||
+		testActionsLogger.PostDirectMessageTimestamp[0] != timestamp || testActionsLogger.PostDirectMessageText[0] != "direct1" {
+		t.Error("PostDirectMessage didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	groupID, _ := testModel.CreateGroup("user1", []string{"user2"})
+	if testActionsLogger.CreateGroupCalled != 1 || testActionsLogger.CreateGroupUsername[0] != "user1" ||
+		len(testActionsLogger.CreateGroupMembers[0]) != 1 || testActionsLogger.CreateGroupMembers[0][0] != "user2" {
+		t.Error("CreateGroup didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.PostGroupMessage(groupID, "user2", time.Time{}, "group1")
+	if testActionsLogger.PostGroupMessageCalled != 1 || testActionsLogger.PostMessageCalled != 0 ||
+		testActionsLogger.PostGroupMessageID[0] != groupID || testActionsLogger.PostGroupMessageUsername[0] != "user2" ||
+		testActionsLogger.PostGroupMessageTimestamp[0] != timestamp || testActionsLogger.PostGroupMessageText[0] != "group1" {
+		t.Error("PostGroupMessage didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.PostBridgedMessage("channel1", "user1", timestamp, "message2", "Slack", "alice")
+	if testActionsLogger.PostBridgedMessageCalled != 1 || testActionsLogger.PostMessageCalled != 0 ||
+		testActionsLogger.PostBridgedMessageUsername[0] != "user1" || testActionsLogger.PostBridgedMessageText[0] != "message2" ||
+		testActionsLogger.PostBridgedMessageSystem[0] != "Slack" || testActionsLogger.PostBridgedMessageAuthor[0] != "alice" {
+		t.Error("PostBridgedMessage didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.PostSnippet("channel1", "user1", timestamp, "go", "snippet1")
+	if testActionsLogger.PostSnippetCalled != 1 || testActionsLogger.PostMessageCalled != 0 ||
+		testActionsLogger.PostSnippetUsername[0] != "user1" || testActionsLogger.PostSnippetLanguage[0] != "go" ||
+		testActionsLogger.PostSnippetText[0] != "snippet1" {
+		t.Error("PostSnippet didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.SetUserProfile("user1", model.Profile{DisplayName: "User One", Bio: "bio1", Pronouns: "they/them"})
+	if testActionsLogger.SetUserProfileCalled != 1 || testActionsLogger.SetUserProfileUsername[0] != "user1" ||
+		testActionsLogger.SetUserProfileProfile[0] != (model.Profile{DisplayName: "User One", Bio: "bio1", Pronouns: "they/them"}) {
+		t.Error("SetUserProfile didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.SetUserStatus("user1", model.Status{Text: "lunch", Away: true})
+	if testActionsLogger.SetUserStatusCalled != 1 || testActionsLogger.SetUserStatusUsername[0] != "user1" ||
+		testActionsLogger.SetUserStatusStatus[0] != (model.Status{Text: "lunch", Away: true}) {
+		t.Error("SetUserStatus didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.PutPluginData("plugin1", "key1", "value1")
+	if testActionsLogger.PutPluginDataCalled != 1 || testActionsLogger.PutPluginDataNamespace[0] != "plugin1" ||
+		testActionsLogger.PutPluginDataKey[0] != "key1" || testActionsLogger.PutPluginDataValue[0] != "value1" {
+		t.Error("PutPluginData didn't correctly log action")
+	}
+
+	testModel.CreateChannel("channel3")
+	testModel.DeleteChannel("channel3")
+	testActionsLogger.Reset()
+	testModel.RestoreChannel("channel3")
+	if testActionsLogger.RestoreChannelCalled != 1 || testActionsLogger.RestoreChannelChannelname[0] != "channel3" {
+		t.Error("RestoreChannel didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.RenameChannel("channel3", "channel4")
+	if testActionsLogger.RenameChannelCalled != 1 || testActionsLogger.RenameChannelChannelname[0] != "channel3" ||
+		testActionsLogger.RenameChannelNewChannelname[0] != "channel4" {
+		t.Error("RenameChannel didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.RenameUser("user1", "user3")
+	if testActionsLogger.RenameUserCalled != 1 || testActionsLogger.RenameUserUsername[0] != "user1" ||
+		testActionsLogger.RenameUserNewUsername[0] != "user3" {
+		t.Error("RenameUser didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.CreateTeam("team1")
+	if testActionsLogger.CreateTeamCalled != 1 || testActionsLogger.CreateTeamTeamname[0] != "team1" {
+		t.Error("CreateTeam didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.AddTeamMember("team1", "user3")
+	if testActionsLogger.AddTeamMemberCalled != 1 || testActionsLogger.AddTeamMemberTeamname[0] != "team1" ||
+		testActionsLogger.AddTeamMemberUsername[0] != "user3" {
+		t.Error("AddTeamMember didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.RemoveTeamMember("team1", "user3")
+	if testActionsLogger.RemoveTeamMemberCalled != 1 || testActionsLogger.RemoveTeamMemberTeamname[0] != "team1" ||
+		testActionsLogger.RemoveTeamMemberUsername[0] != "user3" {
+		t.Error("RemoveTeamMember didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.DeleteTeam("team1")
+	if testActionsLogger.DeleteTeamCalled != 1 || testActionsLogger.DeleteTeamTeamname[0] != "team1" {
+		t.Error("DeleteTeam didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	eventID, _ := testModel.CreateEvent("General", "user3", timestamp, "Standup")
+	if testActionsLogger.CreateEventCalled != 1 || testActionsLogger.CreateEventChannelname[0] != "General" ||
+		testActionsLogger.CreateEventUsername[0] != "user3" || !testActionsLogger.CreateEventStart[0].Equal(timestamp) ||
+		testActionsLogger.CreateEventTitle[0] != "Standup" {
+		t.Error("CreateEvent didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.RSVPEvent(eventID, "user3", true)
+	if testActionsLogger.RSVPEventCalled != 1 || testActionsLogger.RSVPEventEventID[0] != eventID ||
+		testActionsLogger.RSVPEventUsername[0] != "user3" || !testActionsLogger.RSVPEventGoing[0] {
+		t.Error("RSVPEvent didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.MarkEventReminded(eventID)
+	if testActionsLogger.MarkEventRemindedCalled != 1 || testActionsLogger.MarkEventRemindedEventID[0] != eventID {
+		t.Error("MarkEventReminded didn't correctly log action")
+	}
+
+	testModel.PostMessage("General", "user3", time.Time{}, "Text")
+	history := testModel.GetChannelHistory("General", "user3", 1)
+	testActionsLogger.Reset()
+	testModel.MarkRead("user3", "General", history[0].ID)
+	if testActionsLogger.MarkReadCalled != 1 || testActionsLogger.MarkReadUsername[0] != "user3" ||
+		testActionsLogger.MarkReadChannelname[0] != "General" || testActionsLogger.MarkReadMessageID[0] != history[0].ID {
+		t.Error("MarkRead didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.PostMessageMulti([]string{"General", "channel4"}, "user3", time.Time{}, "Text")
+	if testActionsLogger.PostMessageMultiCalled != 1 || len(testActionsLogger.PostMessageMultiChannelnames[0]) != 2 ||
+		testActionsLogger.PostMessageMultiChannelnames[0][1] != "channel4" || testActionsLogger.PostMessageMultiUsername[0] != "user3" ||
+		!testActionsLogger.PostMessageMultiTimestamp[0].Equal(timestamp) || testActionsLogger.PostMessageMultiText[0] != "Text" {
+		t.Error("PostMessageMulti didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.StarMessage("user3", "General", history[0].ID, true)
+	if testActionsLogger.StarMessageCalled != 1 || testActionsLogger.StarMessageUsername[0] != "user3" ||
+		testActionsLogger.StarMessageChannelname[0] != "General" || testActionsLogger.StarMessageMessageID[0] != history[0].ID ||
+		!testActionsLogger.StarMessageStarred[0] {
+		t.Error("StarMessage didn't correctly log action")
+	}
+
+	testActionsLogger.Reset()
+	testModel.SetChannelNotes("General", "user3", 0, "notes1")
+	if testActionsLogger.SetChannelNotesCalled != 1 || testActionsLogger.SetChannelNotesChannelname[0] != "General" ||
+		testActionsLogger.SetChannelNotesUsername[0] != "user3" || testActionsLogger.SetChannelNotesText[0] != "notes1" {
+		t.Error("SetChannelNotes didn't correctly log action")
+	}
+
+	snapshot := &actions.Snapshot{Users: []actions.SnapshotUser{{Name: "Anonymous"}}, Channels: []actions.SnapshotChannel{{Name: "General"}}}
+	testActionsLogger.Reset()
+	testModel.Actor().RestoreSnapshot(snapshot)
+	if testActionsLogger.RestoreSnapshotCalled != 1 || testActionsLogger.RestoreSnapshotSnapshot[0] != snapshot {
+		t.Error("RestoreSnapshot didn't correctly log action")
+	}
+}
+
+type TestEventEmitter struct {
+	Events []string
+}
+
+func (t *TestEventEmitter) Emit(eventType string, username string, channelname string) {
+	t.Events = append(t.Events, eventType+":"+username+":"+channelname)
+}
+
+func TestEvents(t *testing.T) {
+	testEventEmitter := &TestEventEmitter{}
+	testModel, err := model.NewModel(model.Options{Events: testEventEmitter}, nil, nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	testEventEmitter.Events = nil
+	testModel.CreateUser("user1")
+	testModel.CreateUser("user1")
+	testModel.CreateChannel("channel1")
+	testModel.PostMessage("channel1", "user1", time.Now(), "message1")
+	testModel.PostMessage("channel1", "user1", time.Now(), "")
+	testModel.EmitEvent("channel_switched", "user1", "channel1")
+	testModel.DeleteChannel("channel1")
+	testModel.DeleteUser("user1")
+
+	expectedEvents := []string{
+		"user_created:user1:",
+		"channel_created::channel1",
+		"message_posted:user1:channel1",
+		"channel_switched:user1:channel1",
+		"channel_deleted::channel1",
+		"user_deleted:user1:",
+	}
+	if len(testEventEmitter.Events) != len(expectedEvents) {
+		t.Error("Incorrect number of events emitted")
+		return
+	}
+	for i, expectedEvent := range expectedEvents {
+		if testEventEmitter.Events[i] != expectedEvent {
+			t.Error("Incorrect event emitted")
+		}
+	}
+
+	// Replayed actions don't emit events
+	testEventEmitter.Events = nil
+	_, err = model.NewModel(model.Options{Events: testEventEmitter}, NewTestActionsReplayer(), nil, nil)
+	if err != nil {
+		t.Error("Failed to create model")
+	}
+
+	if len(testEventEmitter.Events) != 2 || testEventEmitter.Events[0] != "channel_created::General" || testEventEmitter.Events[1] != "user_created:Anonymous:" {
+		t.Error("Incorrect events emitted after replay")
+	}
 }
@@ -0,0 +1,130 @@
+// Package policy provides a registry of protected model entities.  Protected users and
+// channels can't be deleted or renamed once they exist (they aren't created by being
+// protected), and read-only channels can only be posted to by one user, so the model consults
+// the registry instead of checking for individual names.
+package policy
+
+import (
+	"sync"
+)
+
+// Policy provides the protected entity registry.  It contains the names of the users and
+// channels that are protected, and of the read-only channels along with their posters.
+type Policy struct {
+	mutex             sync.Mutex
+	protectedUsers    map[string]struct{}
+	protectedChannels map[string]struct{}
+	readOnlyChannels  map[string]string
+}
+
+// NewPolicy creates/initializes/returns a new Policy.
+func NewPolicy() *Policy {
+	policy := Policy{
+		protectedUsers:    make(map[string]struct{}),
+		protectedChannels: make(map[string]struct{}),
+		readOnlyChannels:  make(map[string]string),
+	}
+
+	return &policy
+}
+
+// ProtectUser adds a user to the protected users.
+func (p *Policy) ProtectUser(username string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.protectedUsers[username] = struct{}{}
+}
+
+// UnprotectUser removes a user from the protected users.
+func (p *Policy) UnprotectUser(username string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.protectedUsers, username)
+}
+
+// IsUserProtected returns whether a user is protected.
+func (p *Policy) IsUserProtected(username string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, ok := p.protectedUsers[username]
+	return ok
+}
+
+// GetProtectedUsers returns a list of all protected users.
+func (p *Policy) GetProtectedUsers() map[string]struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	users := make(map[string]struct{})
+	for username := range p.protectedUsers {
+		users[username] = struct{}{}
+	}
+
+	return users
+}
+
+// ProtectChannel adds a channel to the protected channels.
+func (p *Policy) ProtectChannel(channelname string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.protectedChannels[channelname] = struct{}{}
+}
+
+// UnprotectChannel removes a channel from the protected channels.
+func (p *Policy) UnprotectChannel(channelname string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.protectedChannels, channelname)
+}
+
+// IsChannelProtected returns whether a channel is protected.
+func (p *Policy) IsChannelProtected(channelname string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, ok := p.protectedChannels[channelname]
+	return ok
+}
+
+// GetProtectedChannels returns a list of all protected channels.
+func (p *Policy) GetProtectedChannels() map[string]struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	channels := make(map[string]struct{})
+	for channelname := range p.protectedChannels {
+		channels[channelname] = struct{}{}
+	}
+
+	return channels
+}
+
+// MakeChannelReadOnly makes a channel read-only for every user but its poster.
+func (p *Policy) MakeChannelReadOnly(channelname string, poster string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.readOnlyChannels[channelname] = poster
+}
+
+// MakeChannelWritable lets every user post to a read-only channel again.
+func (p *Policy) MakeChannelWritable(channelname string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.readOnlyChannels, channelname)
+}
+
+// CanPost returns whether a user can post to a channel (any user can, unless it's read-only).
+func (p *Policy) CanPost(channelname string, username string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	poster, ok := p.readOnlyChannels[channelname]
+	return !ok || poster == username
+}
@@ -0,0 +1,72 @@
+package policy_test
+
+import (
+	"chatserver/model/policy"
+	"testing"
+)
+
+func TestProtectedUsers(t *testing.T) {
+	testPolicy := policy.NewPolicy()
+
+	if testPolicy.IsUserProtected("user1") {
+		t.Error("User protected by default")
+	}
+
+	testPolicy.ProtectUser("user1")
+	testPolicy.ProtectUser("user1")
+	if !testPolicy.IsUserProtected("user1") || testPolicy.IsUserProtected("user2") {
+		t.Error("Failed to protect user1")
+	}
+
+	users := testPolicy.GetProtectedUsers()
+	if _, ok := users["user1"]; !ok || len(users) != 1 {
+		t.Error("Incorrect protected users")
+	}
+
+	testPolicy.UnprotectUser("user1")
+	if testPolicy.IsUserProtected("user1") || len(testPolicy.GetProtectedUsers()) != 0 {
+		t.Error("Failed to unprotect user1")
+	}
+}
+
+func TestProtectedChannels(t *testing.T) {
+	testPolicy := policy.NewPolicy()
+
+	if testPolicy.IsChannelProtected("channel1") {
+		t.Error("Channel protected by default")
+	}
+
+	testPolicy.ProtectChannel("channel1")
+	testPolicy.ProtectChannel("channel1")
+	if !testPolicy.IsChannelProtected("channel1") || testPolicy.IsChannelProtected("channel2") {
+		t.Error("Failed to protect channel1")
+	}
+
+	channels := testPolicy.GetProtectedChannels()
+	if _, ok := channels["channel1"]; !ok || len(channels) != 1 {
+		t.Error("Incorrect protected channels")
+	}
+
+	testPolicy.UnprotectChannel("channel1")
+	if testPolicy.IsChannelProtected("channel1") || len(testPolicy.GetProtectedChannels()) != 0 {
+		t.Error("Failed to unprotect channel1")
+	}
+}
+
+func TestReadOnlyChannels(t *testing.T) {
+	testPolicy := policy.NewPolicy()
+
+	if !testPolicy.CanPost("channel1", "user1") {
+		t.Error("Channel read-only by default")
+	}
+
+	testPolicy.MakeChannelReadOnly("channel1", "user1")
+	if !testPolicy.CanPost("channel1", "user1") || testPolicy.CanPost("channel1", "user2") || !testPolicy.CanPost("channel2", "user2") {
+		t.Error("Failed to make channel1 read-only")
+	}
+
+	testPolicy.MakeChannelWritable("channel1")
+	if !testPolicy.CanPost("channel1", "user2") {
+		t.Error("Failed to make channel1 writable")
+	}
+}
@@ -0,0 +1,933 @@
+// Package spec provides an executable specification of the model: a deliberately simple reference
+// implementation of the model's mutators, written for clarity rather than speed, that tests can run
+// side by side with the real model (live, replayed from its actions log or fed as a replica) to
+// catch any divergence in behavior.
+//
+// The specification covers the users, channels, memberships, blocks, mutes, messages and teams, and
+// the errors each mutator rejects a change with.  Time isn't part of it (the message timestamps and the
+// undo window for restoring channels), nor are the language filters, quotas, direct messages, groups,
+// attachments, plugin data or analytics events.
+package spec
+
+import (
+	"chatserver/model"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// State provides the observable state of a model (or of the specification), in a form that can be
+// compared with reflect.DeepEqual.  The names in the lists are sorted.
+type State struct {
+	Users           map[string]User
+	Channels        map[string]Channel
+	DeletedChannels []string
+	Teams           map[string][]string
+}
+
+// User provides the observable state of a user.
+type User struct {
+	Name          string
+	Owner         string
+	BlockedUsers  []string
+	MutedChannels []string
+	Profile       model.Profile
+	Status        model.Status
+}
+
+// Channel provides the observable state of a channel.
+type Channel struct {
+	Name     string
+	Topic    string
+	Language string
+	Rules    string
+	Members  []string
+	Messages []Message
+}
+
+// Message provides the observable state of a message (whether it was edited, rather than when).
+type Message struct {
+	ID              uint64
+	Seq             uint64
+	Username        string
+	Text            string
+	Origin          model.Origin
+	SnippetLanguage string
+	Edited          bool
+	Deleted         bool
+}
+
+// Spec provides the reference implementation of the model.
+type Spec struct {
+	builtinUsername    string
+	builtinChannelname string
+	users              map[string]*User
+	channels           map[string]*Channel
+	deleted            map[string]deletedChannel
+	teams              map[string][]string
+	lastMessageID      uint64
+}
+
+// deletedChannel is a deleted channel that can still be restored.
+type deletedChannel struct {
+	channel Channel
+	mutedBy []string
+}
+
+// NewSpec creates/initializes/returns a new Spec, in the state a new model with the built-in user
+// and channel (and no other default channels) starts in.
+func NewSpec(builtinUsername string, builtinChannelname string) *Spec {
+	s := Spec{
+		builtinUsername:    builtinUsername,
+		builtinChannelname: builtinChannelname,
+		users:              make(map[string]*User),
+		channels:           make(map[string]*Channel),
+		deleted:            make(map[string]deletedChannel),
+		teams:              make(map[string][]string),
+	}
+
+	s.CreateChannel(builtinChannelname)
+	s.CreateUser(builtinUsername)
+
+	return &s
+}
+
+// CreateUser specifies Model.CreateUser: new users join the built-in channel.
+func (s *Spec) CreateUser(username string) error {
+	if _, ok := s.users[username]; ok {
+		return model.ErrUserExists
+	}
+
+	if !validName(username) {
+		return model.ErrInvalidName
+	}
+
+	s.users[username] = &User{Name: username}
+	s.channels[s.builtinChannelname].Members = addName(s.channels[s.builtinChannelname].Members, username)
+
+	return nil
+}
+
+// CreateUserAndJoin specifies Model.CreateUserAndJoin: nothing changes unless both the user can
+// be created and the channel exists.
+func (s *Spec) CreateUserAndJoin(username string, channelname string) error {
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return model.ErrChannelNotFound
+	}
+
+	err := s.CreateUser(username)
+	if err != nil {
+		return err
+	}
+	channel.Members = addName(channel.Members, username)
+
+	return nil
+}
+
+// CreateVirtualUser specifies Model.CreateVirtualUser: virtual users are owned by a regular user,
+// and don't join any channels.
+func (s *Spec) CreateVirtualUser(ownerUsername string, username string) error {
+	if _, ok := s.users[username]; ok {
+		return model.ErrUserExists
+	}
+
+	if !validName(username) {
+		return model.ErrInvalidName
+	}
+
+	if owner, ok := s.users[ownerUsername]; !ok || owner.Owner != "" {
+		return model.ErrInvalidOwner
+	}
+
+	s.users[username] = &User{Name: username, Owner: ownerUsername}
+
+	return nil
+}
+
+// DeleteUser specifies Model.DeleteUser: the user's virtual users go with it, and it's removed
+// from the blocks, and the members (and mutes) of the channels, including the deleted ones.  Its
+// messages stay.
+func (s *Spec) DeleteUser(username string) error {
+	if _, ok := s.users[username]; !ok {
+		return model.ErrUserNotFound
+	}
+
+	if username == s.builtinUsername {
+		return model.ErrUserProtected
+	}
+
+	for _, user := range s.users {
+		if user.Owner == username {
+			s.removeUser(user.Name)
+		}
+	}
+	s.removeUser(username)
+
+	return nil
+}
+
+// RenameUser specifies Model.RenameUser: the user's blocks, virtual users, memberships and messages
+// (in the deleted channels too) go with it to the new name.
+func (s *Spec) RenameUser(username string, newUsername string) error {
+	user, ok := s.users[username]
+	if !ok {
+		return model.ErrUserNotFound
+	}
+
+	if username == s.builtinUsername {
+		return model.ErrUserProtected
+	}
+
+	if !validName(newUsername) {
+		return model.ErrInvalidName
+	}
+
+	if _, ok := s.users[newUsername]; ok {
+		return model.ErrUserExists
+	}
+
+	delete(s.users, username)
+	user.Name = newUsername
+	s.users[newUsername] = user
+
+	for _, otherUser := range s.users {
+		if containsName(otherUser.BlockedUsers, username) {
+			otherUser.BlockedUsers = renameName(otherUser.BlockedUsers, username, newUsername)
+		}
+		if otherUser.Owner == username {
+			otherUser.Owner = newUsername
+		}
+	}
+
+	for _, channel := range s.channels {
+		renameMember(channel, username, newUsername)
+	}
+
+	for channelname, deleted := range s.deleted {
+		renameMember(&deleted.channel, username, newUsername)
+		if containsName(deleted.mutedBy, username) {
+			deleted.mutedBy = renameName(deleted.mutedBy, username, newUsername)
+		}
+		s.deleted[channelname] = deleted
+	}
+
+	for teamname, members := range s.teams {
+		if containsName(members, username) {
+			s.teams[teamname] = renameName(members, username, newUsername)
+		}
+	}
+
+	return nil
+}
+
+// BlockUser specifies Model.BlockUser.
+func (s *Spec) BlockUser(username string, usernameToBlock string) error {
+	user, ok := s.users[username]
+	if !ok {
+		return model.ErrUserNotFound
+	}
+
+	if _, ok := s.users[usernameToBlock]; !ok {
+		return model.ErrUserNotFound
+	}
+
+	if username == s.builtinUsername {
+		return model.ErrBuiltinUser
+	}
+
+	if username == usernameToBlock {
+		return model.ErrBlockSelf
+	}
+
+	user.BlockedUsers = addName(user.BlockedUsers, usernameToBlock)
+
+	return nil
+}
+
+// SetUserProfile specifies Model.SetUserProfile.  The display name and pronouns have no control
+// characters, the bio none but newlines.
+func (s *Spec) SetUserProfile(username string, profile model.Profile) error {
+	user, ok := s.users[username]
+	if !ok {
+		return model.ErrUserNotFound
+	}
+
+	if username == s.builtinUsername {
+		return model.ErrBuiltinUser
+	}
+
+	if utf8.RuneCountInString(profile.DisplayName) > model.MaxDisplayNameLength ||
+		utf8.RuneCountInString(profile.Bio) > model.MaxBioLength ||
+		utf8.RuneCountInString(profile.Pronouns) > model.MaxPronounsLength {
+		return model.ErrInvalidProfile
+	}
+
+	singleLine := profile.DisplayName + profile.Pronouns + strings.Replace(profile.Bio, "\n", "", -1)
+	if strings.IndexFunc(singleLine, unicode.IsControl) != -1 {
+		return model.ErrInvalidProfile
+	}
+
+	user.Profile = profile
+
+	return nil
+}
+
+// SetUserStatus specifies Model.SetUserStatus.  The text has no control characters.
+func (s *Spec) SetUserStatus(username string, status model.Status) error {
+	user, ok := s.users[username]
+	if !ok {
+		return model.ErrUserNotFound
+	}
+
+	if username == s.builtinUsername {
+		return model.ErrBuiltinUser
+	}
+
+	if utf8.RuneCountInString(status.Text) > model.MaxStatusLength || strings.IndexFunc(status.Text, unicode.IsControl) != -1 {
+		return model.ErrInvalidStatus
+	}
+
+	user.Status = status
+
+	return nil
+}
+
+// UnblockUser specifies Model.UnblockUser.
+func (s *Spec) UnblockUser(username string, usernameToUnblock string) error {
+	user, ok := s.users[username]
+	if !ok {
+		return model.ErrUserNotFound
+	}
+
+	if _, ok := s.users[usernameToUnblock]; !ok {
+		return model.ErrUserNotFound
+	}
+
+	if !containsName(user.BlockedUsers, usernameToUnblock) {
+		return model.ErrNotBlocked
+	}
+
+	user.BlockedUsers = removeName(user.BlockedUsers, usernameToUnblock)
+
+	return nil
+}
+
+// MuteChannel specifies Model.MuteChannel.
+func (s *Spec) MuteChannel(username string, channelname string) error {
+	user, ok := s.users[username]
+	if !ok {
+		return model.ErrUserNotFound
+	}
+
+	if _, ok := s.channels[channelname]; !ok {
+		return model.ErrChannelNotFound
+	}
+
+	if username == s.builtinUsername {
+		return model.ErrBuiltinUser
+	}
+
+	user.MutedChannels = addName(user.MutedChannels, channelname)
+
+	return nil
+}
+
+// UnmuteChannel specifies Model.UnmuteChannel.
+func (s *Spec) UnmuteChannel(username string, channelname string) error {
+	user, ok := s.users[username]
+	if !ok {
+		return model.ErrUserNotFound
+	}
+
+	if _, ok := s.channels[channelname]; !ok {
+		return model.ErrChannelNotFound
+	}
+
+	if !containsName(user.MutedChannels, channelname) {
+		return model.ErrNotMuted
+	}
+
+	user.MutedChannels = removeName(user.MutedChannels, channelname)
+
+	return nil
+}
+
+// CreateChannel specifies Model.CreateChannel: a deleted channel can't be restored once its name
+// is reused.
+func (s *Spec) CreateChannel(channelname string) error {
+	if _, ok := s.channels[channelname]; ok {
+		return model.ErrChannelExists
+	}
+
+	if !validName(channelname) {
+		return model.ErrInvalidName
+	}
+
+	delete(s.deleted, channelname)
+	s.channels[channelname] = &Channel{Name: channelname}
+
+	return nil
+}
+
+// CreateChannelAndJoin specifies Model.CreateChannelAndJoin: nothing changes unless both the user
+// exists and the channel can be created.
+func (s *Spec) CreateChannelAndJoin(channelname string, topic string, username string) error {
+	if _, ok := s.users[username]; !ok {
+		return model.ErrUserNotFound
+	}
+
+	err := s.CreateChannel(channelname)
+	if err != nil {
+		return err
+	}
+	s.channels[channelname].Topic = topic
+	s.channels[channelname].Members = []string{username}
+
+	return nil
+}
+
+// DeleteChannel specifies Model.DeleteChannel: the channel is kept (with its members and the users
+// that muted it) for RestoreChannel.
+func (s *Spec) DeleteChannel(channelname string) error {
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return model.ErrChannelNotFound
+	}
+
+	if channelname == s.builtinChannelname {
+		return model.ErrChannelProtected
+	}
+
+	deleted := deletedChannel{channel: *channel}
+	for _, user := range s.users {
+		if containsName(user.MutedChannels, channelname) {
+			user.MutedChannels = removeName(user.MutedChannels, channelname)
+			deleted.mutedBy = append(deleted.mutedBy, user.Name)
+		}
+	}
+
+	delete(s.channels, channelname)
+	s.deleted[channelname] = deleted
+
+	return nil
+}
+
+// RestoreChannel specifies Model.RestoreChannel: the members and mutes come back for the users
+// that exist (again) by then.
+func (s *Spec) RestoreChannel(channelname string) error {
+	deleted, ok := s.deleted[channelname]
+	if !ok {
+		return model.ErrNotRestorable
+	}
+
+	if _, ok := s.channels[channelname]; ok {
+		return model.ErrChannelExists
+	}
+
+	channel := deleted.channel
+	channel.Members = nil
+	for _, member := range deleted.channel.Members {
+		if _, ok := s.users[member]; ok {
+			channel.Members = addName(channel.Members, member)
+		}
+	}
+
+	for _, username := range deleted.mutedBy {
+		if user, ok := s.users[username]; ok {
+			user.MutedChannels = addName(user.MutedChannels, channelname)
+		}
+	}
+
+	delete(s.deleted, channelname)
+	s.channels[channelname] = &channel
+
+	return nil
+}
+
+// RenameChannel specifies Model.RenameChannel: the channel keeps its members, messages and mutes
+// under the new name.
+func (s *Spec) RenameChannel(channelname string, newChannelname string) error {
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return model.ErrChannelNotFound
+	}
+
+	if channelname == s.builtinChannelname {
+		return model.ErrChannelProtected
+	}
+
+	if !validName(newChannelname) {
+		return model.ErrInvalidName
+	}
+
+	if _, ok := s.channels[newChannelname]; ok {
+		return model.ErrChannelExists
+	}
+
+	for _, user := range s.users {
+		if containsName(user.MutedChannels, channelname) {
+			user.MutedChannels = addName(removeName(user.MutedChannels, channelname), newChannelname)
+		}
+	}
+
+	delete(s.deleted, newChannelname)
+	delete(s.channels, channelname)
+	channel.Name = newChannelname
+	s.channels[newChannelname] = channel
+
+	return nil
+}
+
+// SetChannelTopic specifies Model.SetChannelTopic.
+func (s *Spec) SetChannelTopic(channelname string, topic string) error {
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return model.ErrChannelNotFound
+	}
+
+	channel.Topic = topic
+
+	return nil
+}
+
+// SetChannelRules specifies Model.SetChannelRules.
+func (s *Spec) SetChannelRules(channelname string, language string, rules string) error {
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return model.ErrChannelNotFound
+	}
+
+	if strings.Contains(language, " ") {
+		return model.ErrInvalidLanguage
+	}
+
+	channel.Language = language
+	channel.Rules = rules
+
+	return nil
+}
+
+// JoinChannel specifies Model.JoinChannel.
+func (s *Spec) JoinChannel(username string, channelname string) error {
+	if _, ok := s.users[username]; !ok {
+		return model.ErrUserNotFound
+	}
+
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return model.ErrChannelNotFound
+	}
+
+	if containsName(channel.Members, username) {
+		return model.ErrAlreadyMember
+	}
+
+	channel.Members = addName(channel.Members, username)
+
+	return nil
+}
+
+// LeaveChannel specifies Model.LeaveChannel.
+func (s *Spec) LeaveChannel(username string, channelname string) error {
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return model.ErrChannelNotFound
+	}
+
+	if !containsName(channel.Members, username) {
+		return model.ErrNotMember
+	}
+
+	channel.Members = removeName(channel.Members, username)
+
+	return nil
+}
+
+// PostMessage specifies Model.PostMessage (and Model.PostBridgedMessage, with an origin), and
+// returns the ID of the posted message.  Users don't have to be members of the channel to post.
+func (s *Spec) PostMessage(channelname string, username string, text string, origin model.Origin) (uint64, error) {
+	return s.postMessage(channelname, username, text, origin, "")
+}
+
+// PostSnippet specifies Model.PostSnippet, and returns the ID of the posted snippet.  The language
+// is a name of up to model.MaxSnippetLanguageLength letters, digits and "+#-._" characters.
+func (s *Spec) PostSnippet(channelname string, username string, language string, text string) (uint64, error) {
+	const characters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789+#-._"
+	if language == "" || len(language) > model.MaxSnippetLanguageLength || strings.Trim(language, characters) != "" {
+		return 0, model.ErrInvalidSnippet
+	}
+
+	return s.postMessage(channelname, username, text, model.Origin{}, language)
+}
+
+func (s *Spec) postMessage(channelname string, username string, text string, origin model.Origin, language string) (uint64, error) {
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return 0, model.ErrChannelNotFound
+	}
+
+	if _, ok := s.users[username]; !ok {
+		return 0, model.ErrUserNotFound
+	}
+
+	if text == "" {
+		return 0, model.ErrEmptyMessage
+	}
+
+	s.lastMessageID++
+	channel.Messages = append(channel.Messages, Message{
+		ID:              s.lastMessageID,
+		Seq:             uint64(len(channel.Messages)) + 1,
+		Username:        username,
+		Text:            text,
+		Origin:          origin,
+		SnippetLanguage: language,
+	})
+
+	return s.lastMessageID, nil
+}
+
+// EditMessage specifies Model.EditMessage.
+func (s *Spec) EditMessage(channelname string, messageID uint64, username string, text string) error {
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return model.ErrChannelNotFound
+	}
+
+	if _, ok := s.users[username]; !ok {
+		return model.ErrUserNotFound
+	}
+
+	message := channel.message(messageID)
+	if message == nil || message.Deleted {
+		return model.ErrMessageNotFound
+	}
+
+	if message.Username != username {
+		return model.ErrNotAuthor
+	}
+
+	if text == "" {
+		return model.ErrEmptyMessage
+	}
+
+	message.Text = text
+	message.Edited = true
+
+	return nil
+}
+
+// DeleteMessage specifies Model.DeleteMessage.
+func (s *Spec) DeleteMessage(channelname string, messageID uint64, username string) error {
+	if username == "" {
+		return model.ErrUserNotFound
+	}
+
+	return s.deleteMessage(channelname, messageID, username)
+}
+
+// ModerateMessage specifies Model.ModerateMessage.
+func (s *Spec) ModerateMessage(channelname string, messageID uint64) error {
+	return s.deleteMessage(channelname, messageID, "")
+}
+
+// deleteMessage leaves a tombstone for the author, or for a moderator when the username is empty.
+func (s *Spec) deleteMessage(channelname string, messageID uint64, username string) error {
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return model.ErrChannelNotFound
+	}
+
+	if _, ok := s.users[username]; !ok && username != "" {
+		return model.ErrUserNotFound
+	}
+
+	message := channel.message(messageID)
+	if message == nil || message.Deleted {
+		return model.ErrMessageNotFound
+	}
+
+	if username != "" && message.Username != username {
+		return model.ErrNotAuthor
+	}
+
+	message.Text = ""
+	message.Deleted = true
+
+	return nil
+}
+
+// CreateTeam specifies Model.CreateTeam: teams start with no members.
+func (s *Spec) CreateTeam(teamname string) error {
+	if _, ok := s.teams[teamname]; ok {
+		return model.ErrTeamExists
+	}
+
+	if !validName(teamname) {
+		return model.ErrInvalidName
+	}
+
+	s.teams[teamname] = []string{}
+
+	return nil
+}
+
+// DeleteTeam specifies Model.DeleteTeam.
+func (s *Spec) DeleteTeam(teamname string) error {
+	if _, ok := s.teams[teamname]; !ok {
+		return model.ErrTeamNotFound
+	}
+
+	delete(s.teams, teamname)
+
+	return nil
+}
+
+// AddTeamMember specifies Model.AddTeamMember: the built-in user can't be a member.
+func (s *Spec) AddTeamMember(teamname string, username string) error {
+	members, ok := s.teams[teamname]
+	if !ok {
+		return model.ErrTeamNotFound
+	}
+
+	if _, ok := s.users[username]; !ok {
+		return model.ErrUserNotFound
+	}
+
+	if username == s.builtinUsername {
+		return model.ErrBuiltinUser
+	}
+
+	if containsName(members, username) {
+		return model.ErrAlreadyTeamMember
+	}
+
+	s.teams[teamname] = addName(members, username)
+
+	return nil
+}
+
+// RemoveTeamMember specifies Model.RemoveTeamMember: the team is kept when it has no members left.
+func (s *Spec) RemoveTeamMember(teamname string, username string) error {
+	members, ok := s.teams[teamname]
+	if !ok {
+		return model.ErrTeamNotFound
+	}
+
+	if !containsName(members, username) {
+		return model.ErrNotTeamMember
+	}
+
+	s.teams[teamname] = removeName(members, username)
+
+	return nil
+}
+
+// State returns a copy of the specification's state.
+func (s *Spec) State() State {
+	state := newState()
+	for username, user := range s.users {
+		state.Users[username] = User{
+			Name:          user.Name,
+			Owner:         user.Owner,
+			BlockedUsers:  sortedNames(user.BlockedUsers),
+			MutedChannels: sortedNames(user.MutedChannels),
+			Profile:       user.Profile,
+			Status:        user.Status,
+		}
+	}
+
+	for channelname, channel := range s.channels {
+		channelCopy := *channel
+		channelCopy.Members = sortedNames(channel.Members)
+		channelCopy.Messages = append([]Message{}, channel.Messages...)
+		state.Channels[channelname] = channelCopy
+	}
+
+	for channelname := range s.deleted {
+		state.DeletedChannels = append(state.DeletedChannels, channelname)
+	}
+	sort.Strings(state.DeletedChannels)
+
+	for teamname, members := range s.teams {
+		state.Teams[teamname] = sortedNames(members)
+	}
+
+	return state
+}
+
+// Observe returns the state of a model, as seen through its getters.
+func Observe(m *model.Model) State {
+	state := newState()
+	members := make(map[string][]string)
+	for username := range m.GetUsers() {
+		userInfo := m.GetUserInfo(username)
+		state.Users[username] = User{
+			Name:          userInfo.Name,
+			Owner:         userInfo.Owner,
+			BlockedUsers:  sortedNames(userInfo.BlockedUsers),
+			MutedChannels: sortedNames(userInfo.MutedChannels),
+			Profile:       userInfo.Profile,
+			Status:        userInfo.Status,
+		}
+
+		for channelname := range m.GetJoinedChannels(username) {
+			members[channelname] = append(members[channelname], username)
+		}
+	}
+
+	// The built-in user can't block anyone, so it sees every message
+	for channelname := range m.GetChannels() {
+		channelInfo := m.GetChannelInfo(channelname)
+		channel := Channel{
+			Name:     channelInfo.Name,
+			Topic:    channelInfo.Topic,
+			Language: channelInfo.Language,
+			Rules:    channelInfo.Rules,
+			Members:  sortedNames(members[channelname]),
+			Messages: []Message{},
+		}
+
+		for _, message := range m.GetChannelHistory(channelname, m.BuiltinUsername(), -1) {
+			channel.Messages = append(channel.Messages, Message{
+				ID:              message.ID,
+				Seq:             message.Seq,
+				Username:        message.Username,
+				Text:            message.Text,
+				Origin:          message.Origin,
+				SnippetLanguage: message.SnippetLanguage,
+				Edited:          !message.Edited.IsZero(),
+				Deleted:         message.Deleted,
+			})
+		}
+		state.Channels[channelname] = channel
+	}
+
+	for channelname := range m.GetDeletedChannels() {
+		state.DeletedChannels = append(state.DeletedChannels, channelname)
+	}
+	sort.Strings(state.DeletedChannels)
+
+	for teamname := range m.GetTeams() {
+		members := make([]string, 0)
+		for member := range m.GetTeamMembers(teamname) {
+			members = append(members, member)
+		}
+		state.Teams[teamname] = sortedNames(members)
+	}
+
+	return state
+}
+
+func newState() State {
+	state := State{
+		Users:           make(map[string]User),
+		Channels:        make(map[string]Channel),
+		DeletedChannels: []string{},
+		Teams:           make(map[string][]string),
+	}
+
+	return state
+}
+
+func (s *Spec) removeUser(username string) {
+	delete(s.users, username)
+
+	for _, user := range s.users {
+		user.BlockedUsers = removeName(user.BlockedUsers, username)
+	}
+
+	for _, channel := range s.channels {
+		channel.Members = removeName(channel.Members, username)
+	}
+
+	for channelname, deleted := range s.deleted {
+		deleted.channel.Members = removeName(deleted.channel.Members, username)
+		deleted.mutedBy = removeName(deleted.mutedBy, username)
+		s.deleted[channelname] = deleted
+	}
+
+	for teamname, members := range s.teams {
+		s.teams[teamname] = removeName(members, username)
+	}
+}
+
+// message returns the message in the channel with an ID (nil if there's none).
+func (c *Channel) message(messageID uint64) *Message {
+	for i := range c.Messages {
+		if c.Messages[i].ID == messageID {
+			return &c.Messages[i]
+		}
+	}
+
+	return nil
+}
+
+// validName returns whether a user or channel name can be created (it's not empty and has no
+// spaces).
+func validName(name string) bool {
+	return name != "" && !strings.Contains(name, " ")
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addName returns the names with a name added (unless it's already there).
+// renameMember renames a user in a channel's members and as the author of its messages (in new
+// slices, so copies aren't changed).
+func renameMember(channel *Channel, username string, newUsername string) {
+	if containsName(channel.Members, username) {
+		channel.Members = renameName(channel.Members, username, newUsername)
+	}
+
+	messages := make([]Message, len(channel.Messages))
+	for i, message := range channel.Messages {
+		if message.Username == username {
+			message.Username = newUsername
+		}
+		messages[i] = message
+	}
+	channel.Messages = messages
+}
+
+// renameName returns the names with a name replaced by a new name (in a new slice).
+func renameName(names []string, name string, newName string) []string {
+	return addName(removeName(names, name), newName)
+}
+
+func addName(names []string, name string) []string {
+	if containsName(names, name) {
+		return names
+	}
+
+	return append(names, name)
+}
+
+// removeName returns the names without a name (in a new slice, so copies aren't changed).
+func removeName(names []string, name string) []string {
+	remaining := []string{}
+	for _, n := range names {
+		if n != name {
+			remaining = append(remaining, n)
+		}
+	}
+
+	return remaining
+}
+
+// sortedNames returns a sorted copy of the names (empty rather than nil, as with the model).
+func sortedNames(names []string) []string {
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+
+	return sorted
+}
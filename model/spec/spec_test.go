@@ -0,0 +1,379 @@
+package spec_test
+
+import (
+	"chatserver/model"
+	"chatserver/model/actions"
+	"chatserver/model/spec"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// The names the random steps pick from are the built-in ones and few enough others that the steps
+// often refer to users, channels and messages that exist, along with (less often) invalid ones.
+var usernames = []string{"Anonymous", "user1", "user2"}
+var channelnames = []string{"General", "channel1", "channel2"}
+var invalidNames = []string{"", "bad name"}
+var texts = []string{"hello", "hello again", ""}
+var languages = []string{"", "en", "bad language"}
+var snippetLanguages = []string{"go", "c++", "", "bad language"}
+var teamnames = []string{"team1", "team2", "", "bad name"}
+var profiles = []model.Profile{
+	{DisplayName: "User", Bio: "line1\nline2", Pronouns: "they/them"},
+	{},
+	{DisplayName: "bad\nname"},
+	{Bio: strings.Repeat("a", model.MaxBioLength+1)},
+}
+var statuses = []model.Status{
+	{Text: "lunch", Away: true},
+	{Text: "in a meeting"},
+	{},
+	{Text: "bad\nstatus"},
+	{Text: strings.Repeat("a", model.MaxStatusLength+1), Away: true},
+}
+var operations = []string{
+	"CreateUser", "CreateUserAndJoin", "CreateVirtualUser", "DeleteUser", "RenameUser", "SetUserProfile", "SetUserStatus", "BlockUser", "UnblockUser",
+	"MuteChannel", "UnmuteChannel", "CreateChannel", "CreateChannelAndJoin", "DeleteChannel",
+	"RestoreChannel", "RenameChannel", "SetChannelTopic",
+	"SetChannelRules", "JoinChannel", "LeaveChannel", "PostMessage", "PostBridgedMessage",
+	"PostSnippet", "EditMessage", "DeleteMessage", "ModerateMessage",
+	"CreateTeam", "DeleteTeam", "AddTeamMember", "RemoveTeamMember",
+}
+
+// step is a single random call to one of the mutators.
+type step struct {
+	Operation     string
+	Username      string
+	OtherUsername string
+	Channelname   string
+	MessageID     uint64
+	Text          string
+}
+
+func (s step) String() string {
+	return fmt.Sprintf("%s(%q, %q, %q, %d, %q)", s.Operation, s.Username, s.OtherUsername, s.Channelname, s.MessageID, s.Text)
+}
+
+// steps is a random sequence of steps, generated for the property tests.
+type steps []step
+
+func (steps) Generate(r *rand.Rand, size int) reflect.Value {
+	pick := func(names []string) string { return names[r.Intn(len(names))] }
+	pickName := func(names []string) string {
+		if r.Intn(10) == 0 {
+			return pick(invalidNames)
+		}
+		return pick(names)
+	}
+
+	generated := make(steps, r.Intn(size*2+1))
+	for i := range generated {
+		generated[i] = step{
+			Operation:     pick(operations),
+			Username:      pickName(usernames),
+			OtherUsername: pickName(usernames),
+			Channelname:   pickName(channelnames),
+			MessageID:     uint64(r.Intn(size/5+1)) + 1,
+			Text:          pick(texts),
+		}
+
+		// Posting is weighted up, so there are messages to edit and delete
+		if r.Intn(3) == 0 {
+			generated[i].Operation = "PostMessage"
+		}
+	}
+
+	return reflect.ValueOf(generated)
+}
+
+// testClock is a clock that moves a second ahead each time it's read (staying well inside the undo
+// window for restoring channels, which the specification leaves out).
+func testClock() func() time.Time {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+// applyStep applies a step to the model and the specification, and returns the errors each
+// rejected it with.
+func applyStep(testModel *model.Model, testSpec *spec.Spec, s step) (error, error) {
+	// Most steps on messages refer to one of the channel's latest messages (counting back from the
+	// newest), the rest to an ID that's likely in another channel or not posted yet
+	if channel, ok := testSpec.State().Channels[s.Channelname]; ok && s.MessageID%4 != 0 && len(channel.Messages) > 0 {
+		back := int(s.MessageID) % len(channel.Messages)
+		s.MessageID = channel.Messages[len(channel.Messages)-1-back].ID
+	}
+
+	switch s.Operation {
+	case "CreateUser":
+		return testModel.CreateUser(s.Username), testSpec.CreateUser(s.Username)
+	case "CreateUserAndJoin":
+		return testModel.CreateUserAndJoin(s.Username, s.Channelname), testSpec.CreateUserAndJoin(s.Username, s.Channelname)
+	case "CreateVirtualUser":
+		return testModel.CreateVirtualUser(s.OtherUsername, s.Username), testSpec.CreateVirtualUser(s.OtherUsername, s.Username)
+	case "DeleteUser":
+		return testModel.DeleteUser(s.Username), testSpec.DeleteUser(s.Username)
+	case "RenameUser":
+		return testModel.RenameUser(s.Username, s.OtherUsername), testSpec.RenameUser(s.Username, s.OtherUsername)
+	case "SetUserProfile":
+		profile := profiles[s.MessageID%uint64(len(profiles))]
+		return testModel.SetUserProfile(s.Username, profile), testSpec.SetUserProfile(s.Username, profile)
+	case "SetUserStatus":
+		status := statuses[s.MessageID%uint64(len(statuses))]
+		return testModel.SetUserStatus(s.Username, status), testSpec.SetUserStatus(s.Username, status)
+	case "BlockUser":
+		return testModel.BlockUser(s.Username, s.OtherUsername), testSpec.BlockUser(s.Username, s.OtherUsername)
+	case "UnblockUser":
+		return testModel.UnblockUser(s.Username, s.OtherUsername), testSpec.UnblockUser(s.Username, s.OtherUsername)
+	case "MuteChannel":
+		return testModel.MuteChannel(s.Username, s.Channelname), testSpec.MuteChannel(s.Username, s.Channelname)
+	case "UnmuteChannel":
+		return testModel.UnmuteChannel(s.Username, s.Channelname), testSpec.UnmuteChannel(s.Username, s.Channelname)
+	case "CreateChannel":
+		return testModel.CreateChannel(s.Channelname), testSpec.CreateChannel(s.Channelname)
+	case "CreateChannelAndJoin":
+		return testModel.CreateChannelAndJoin(s.Channelname, s.Text, s.Username), testSpec.CreateChannelAndJoin(s.Channelname, s.Text, s.Username)
+	case "DeleteChannel":
+		return testModel.DeleteChannel(s.Channelname), testSpec.DeleteChannel(s.Channelname)
+	case "RestoreChannel":
+		return testModel.RestoreChannel(s.Channelname), testSpec.RestoreChannel(s.Channelname)
+	case "RenameChannel":
+		newChannelname := channelnames[s.MessageID%uint64(len(channelnames))]
+		return testModel.RenameChannel(s.Channelname, newChannelname), testSpec.RenameChannel(s.Channelname, newChannelname)
+	case "SetChannelTopic":
+		return testModel.SetChannelTopic(s.Channelname, s.Text), testSpec.SetChannelTopic(s.Channelname, s.Text)
+	case "SetChannelRules":
+		language := languages[s.MessageID%uint64(len(languages))]
+		return testModel.SetChannelRules(s.Channelname, language, s.Text), testSpec.SetChannelRules(s.Channelname, language, s.Text)
+	case "JoinChannel":
+		return testModel.JoinChannel(s.Username, s.Channelname), testSpec.JoinChannel(s.Username, s.Channelname)
+	case "LeaveChannel":
+		return testModel.LeaveChannel(s.Username, s.Channelname), testSpec.LeaveChannel(s.Username, s.Channelname)
+	case "PostMessage":
+		message, modelErr := testModel.PostMessage(s.Channelname, s.Username, time.Time{}, s.Text)
+		messageID, specErr := testSpec.PostMessage(s.Channelname, s.Username, s.Text, model.Origin{})
+		if modelErr == nil && specErr == nil && message.ID != messageID {
+			return fmt.Errorf("posted message %d", message.ID), fmt.Errorf("posted message %d", messageID)
+		}
+		return modelErr, specErr
+	case "PostBridgedMessage":
+		origin := model.Origin{System: "Slack", Author: s.OtherUsername}
+		_, modelErr := testModel.PostBridgedMessage(s.Channelname, s.Username, time.Time{}, s.Text, origin.System, origin.Author)
+		_, specErr := testSpec.PostMessage(s.Channelname, s.Username, s.Text, origin)
+		return modelErr, specErr
+	case "PostSnippet":
+		language := snippetLanguages[s.MessageID%uint64(len(snippetLanguages))]
+		_, modelErr := testModel.PostSnippet(s.Channelname, s.Username, time.Time{}, language, s.Text)
+		_, specErr := testSpec.PostSnippet(s.Channelname, s.Username, language, s.Text)
+		return modelErr, specErr
+	case "EditMessage":
+		return testModel.EditMessage(s.Channelname, s.MessageID, s.Username, s.Text), testSpec.EditMessage(s.Channelname, s.MessageID, s.Username, s.Text)
+	case "DeleteMessage":
+		return testModel.DeleteMessage(s.Channelname, s.MessageID, s.Username), testSpec.DeleteMessage(s.Channelname, s.MessageID, s.Username)
+	case "ModerateMessage":
+		return testModel.ModerateMessage(s.Channelname, s.MessageID), testSpec.ModerateMessage(s.Channelname, s.MessageID)
+	case "CreateTeam":
+		teamname := teamnames[s.MessageID%uint64(len(teamnames))]
+		return testModel.CreateTeam(teamname), testSpec.CreateTeam(teamname)
+	case "DeleteTeam":
+		teamname := teamnames[s.MessageID%uint64(len(teamnames))]
+		return testModel.DeleteTeam(teamname), testSpec.DeleteTeam(teamname)
+	case "AddTeamMember":
+		teamname := teamnames[s.MessageID%uint64(len(teamnames))]
+		return testModel.AddTeamMember(teamname, s.Username), testSpec.AddTeamMember(teamname, s.Username)
+	case "RemoveTeamMember":
+		teamname := teamnames[s.MessageID%uint64(len(teamnames))]
+		return testModel.RemoveTeamMember(teamname, s.Username), testSpec.RemoveTeamMember(teamname, s.Username)
+	}
+
+	return fmt.Errorf("unknown operation %s", s.Operation), nil
+}
+
+func TestModelMatchesSpec(t *testing.T) {
+	property := func(sequence steps) bool {
+		testModel, err := model.NewModel(model.Options{Clock: testClock()}, nil, nil, nil)
+		if err != nil {
+			t.Error("Failed to create model")
+			return false
+		}
+		testSpec := spec.NewSpec(testModel.BuiltinUsername(), testModel.BuiltinChannelname())
+
+		if !reflect.DeepEqual(spec.Observe(testModel), testSpec.State()) {
+			t.Error("New model differs from the spec")
+			return false
+		}
+
+		for i, s := range sequence {
+			modelErr, specErr := applyStep(testModel, testSpec, s)
+			if modelErr != specErr {
+				t.Errorf("Step %d %v: model returned %v, spec returned %v", i, s, modelErr, specErr)
+				return false
+			}
+
+			if !reflect.DeepEqual(spec.Observe(testModel), testSpec.State()) {
+				t.Errorf("Step %d %v: model state differs from the spec", i, s)
+				return false
+			}
+		}
+
+		return true
+	}
+
+	err := quick.Check(property, &quick.Config{MaxCount: 500})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayMatchesLive(t *testing.T) {
+	property := func(sequence steps) bool {
+		tempFile, err := ioutil.TempFile("", "test.*.txt")
+		if err != nil {
+			t.Error("Failed to create temp file")
+			return false
+		}
+		tempFile.Close()
+		defer os.Remove(tempFile.Name())
+
+		logger, err := actions.NewLogger(tempFile.Name())
+		if err != nil {
+			t.Error("Failed to create logger")
+			return false
+		}
+
+		// The live model logs its actions to the file, and feeds them to a read replica
+		replica, err := model.NewModel(model.Options{TrustTimestamps: true}, nil, nil, nil)
+		if err != nil {
+			t.Error("Failed to create replica")
+			return false
+		}
+
+		testModel, err := model.NewModel(model.Options{Clock: testClock()}, nil, actions.NewFanout(logger, replica.Actor()), nil)
+		if err != nil {
+			t.Error("Failed to create model")
+			return false
+		}
+		testSpec := spec.NewSpec(testModel.BuiltinUsername(), testModel.BuiltinChannelname())
+
+		for _, s := range sequence {
+			applyStep(testModel, testSpec, s)
+		}
+
+		replayer, err := actions.NewReplayer(tempFile.Name())
+		if err != nil {
+			t.Error("Failed to create replayer")
+			return false
+		}
+
+		replayed, err := model.NewModel(model.Options{}, replayer, nil, nil)
+		if err != nil {
+			t.Error("Failed to replay the log")
+			return false
+		}
+
+		liveState := spec.Observe(testModel)
+		if !reflect.DeepEqual(spec.Observe(replica), liveState) {
+			t.Error("Replica state differs from the live model")
+			return false
+		}
+
+		// Which channels can be restored isn't kept across restarts
+		liveState.DeletedChannels = []string{}
+		if !reflect.DeepEqual(spec.Observe(replayed), liveState) {
+			t.Error("Replayed state differs from the live model")
+			return false
+		}
+
+		// The replayed messages keep the times they were posted and edited
+		for channelname := range testModel.GetChannels() {
+			liveMessages := testModel.GetChannelHistory(channelname, testModel.BuiltinUsername(), -1)
+			replayedMessages := replayed.GetChannelHistory(channelname, replayed.BuiltinUsername(), -1)
+			for i := range liveMessages {
+				if !replayedMessages[i].Timestamp.Equal(liveMessages[i].Timestamp) || !replayedMessages[i].Edited.Equal(liveMessages[i].Edited) {
+					t.Error("Replayed message times differ from the live model")
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	err := quick.Check(property, &quick.Config{MaxCount: 50})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompactedReplayMatchesLive(t *testing.T) {
+	property := func(sequence steps) bool {
+		tempFile, err := ioutil.TempFile("", "test.*.txt")
+		if err != nil {
+			t.Error("Failed to create temp file")
+			return false
+		}
+		tempFile.Close()
+		defer os.Remove(tempFile.Name())
+
+		logger, err := actions.NewLogger(tempFile.Name())
+		if err != nil {
+			t.Error("Failed to create logger")
+			return false
+		}
+
+		testModel, err := model.NewModel(model.Options{Clock: testClock()}, nil, logger, nil)
+		if err != nil {
+			t.Error("Failed to create model")
+			return false
+		}
+		testSpec := spec.NewSpec(testModel.BuiltinUsername(), testModel.BuiltinChannelname())
+
+		// The log is compacted part way through, and the rest of the steps are logged after the
+		// snapshot
+		for i, s := range sequence {
+			if i == len(sequence)/2 {
+				err := testModel.Compact(logger.Compact)
+				if err != nil {
+					t.Error("Failed to compact the log")
+					return false
+				}
+			}
+			applyStep(testModel, testSpec, s)
+		}
+
+		replayer, err := actions.NewReplayer(tempFile.Name())
+		if err != nil {
+			t.Error("Failed to create replayer")
+			return false
+		}
+
+		replayed, err := model.NewModel(model.Options{}, replayer, nil, nil)
+		if err != nil {
+			t.Error("Failed to replay the compacted log")
+			return false
+		}
+
+		// Which channels can be restored isn't kept across restarts
+		liveState := spec.Observe(testModel)
+		liveState.DeletedChannels = []string{}
+		if !reflect.DeepEqual(spec.Observe(replayed), liveState) {
+			t.Error("Replayed state differs from the live model")
+			return false
+		}
+
+		return true
+	}
+
+	err := quick.Check(property, &quick.Config{MaxCount: 50})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -1,10 +1,24 @@
 // Package subs provides an asynchronous subscription notification engine.  It
 // allows clients to connect (subscribe) and will call Client interface functions
 // when pieces of state are changed (via subscription actions).
+//
+// Each client is notified by its own goroutine, in the order the notifications were made
+// (so changes to a channel are delivered first in, first out).  A notification that is still
+// waiting to be delivered to a client isn't queued again, since the client will see the latest
+// state when it's delivered, so a slow client can't cause unbounded queueing.
+//
+// Every notification is numbered, and the most recent ones are kept so a client that
+// reconnects can catch up on the changes it missed (see EventsSince) instead of refetching
+// everything.  The exceptions are direct messages and group messages, which are only delivered to
+// the clients of the users in the conversation or group (see UserClient), and drafts, read markers
+// and mentions, which are only delivered to the clients of their user (see DraftClient,
+// ReadMarkerClient and MentionClient).  Changes to a channel aren't delivered to the clients whose
+// user muted it (see MuteClient).
 package subs
 
 import (
 	"errors"
+	"strings"
 	"sync"
 )
 
@@ -15,10 +29,348 @@ type Client interface {
 	OnUserChanged(username string)
 	OnChannelsChanged()
 	OnChannelChanged(channelname string)
+	OnMessageChanged(channelname string, messageID uint64)
+}
+
+// Notification kinds
+const (
+	usersChanged int = iota
+	userChanged
+	channelsChanged
+	channelChanged
+	messageChanged
+	directMessagesChanged
+	groupChanged
+	channelRenamed
+	userRenamed
+	draftChanged
+	presenceChanged
+	readMarkerChanged
+	mentioned
+	securityEvent
+)
+
+type notification struct {
+	kind      int
+	name      string
+	id        uint64
+	otherName string
+}
+
+func (n notification) method() string {
+	switch n.kind {
+	case usersChanged:
+		return "OnUsersChanged"
+	case userChanged:
+		return "OnUserChanged"
+	case channelsChanged:
+		return "OnChannelsChanged"
+	case messageChanged:
+		return "OnMessageChanged"
+	case directMessagesChanged:
+		return "OnDirectMessagesChanged"
+	case groupChanged:
+		return "OnGroupChanged"
+	case channelRenamed:
+		return "OnChannelRenamed"
+	case userRenamed:
+		return "OnUserRenamed"
+	case draftChanged:
+		return "OnDraftChanged"
+	case presenceChanged:
+		return "OnPresenceChanged"
+	case readMarkerChanged:
+		return "OnReadMarkerChanged"
+	case mentioned:
+		return "OnMentioned"
+	case securityEvent:
+		return "OnSecurityEvent"
+	default:
+		return "OnChannelChanged"
+	}
+}
+
+// SequencedClient may be implemented by clients that need the sequence number of each
+// notification.  OnSeq is called with the number just before the notification is delivered
+// (a notification that was coalesced keeps the number it was first queued with).
+type SequencedClient interface {
+	Client
+	OnSeq(seq uint64)
+}
+
+// UserClient may be implemented by clients acting as a single user at a time, to be notified of
+// the direct messages and group messages of that user (clients that don't implement it never are).
+// CurrentUser is called when each of these notifications is delivered.  OnDirectMessagesChanged is
+// only called if the current user is in the conversation, with the other user in it, and
+// OnGroupChanged only if the current user is a member of the group.
+type UserClient interface {
+	Client
+	CurrentUser() string
+	OnDirectMessagesChanged(otherUsername string)
+	OnGroupChanged(groupID uint64)
+}
+
+// RenameClient may be implemented by clients that follow a channel or user to its new name when
+// it's renamed (e.g. to keep showing the channel, or acting as the user), rather than seeing it
+// disappear.  Clients that don't implement it are called with OnChannelsChanged instead of
+// OnChannelRenamed, and OnUsersChanged instead of OnUserRenamed.
+type RenameClient interface {
+	Client
+	OnChannelRenamed(channelname string, newChannelname string)
+	OnUserRenamed(username string, newUsername string)
+}
+
+// DraftClient may be implemented by clients acting as a single user at a time, to be notified when
+// one of that user's drafts changes (e.g. from another of their devices).  OnDraftChanged is only
+// called if the current user is the draft's user, with the draft's channel.
+type DraftClient interface {
+	UserClient
+	OnDraftChanged(channelname string)
+}
+
+// ReadMarkerClient may be implemented by clients acting as a single user at a time, to be notified
+// when that user reads a channel (e.g. on another of their devices).  OnReadMarkerChanged is only
+// called if the current user is the read marker's user, with the marker's channel.
+type ReadMarkerClient interface {
+	UserClient
+	OnReadMarkerChanged(channelname string)
+}
+
+// MentionClient may be implemented by clients acting as a single user at a time, to be notified
+// when that user is mentioned in a channel message (e.g. to highlight it).  OnMentioned is only
+// called if the current user is the mentioned user, with the channel and the message ID.
+type MentionClient interface {
+	UserClient
+	OnMentioned(channelname string, messageID uint64)
+}
+
+// SecurityClient may be implemented by clients acting as a single user at a time, to be told about
+// security events on that user's account (e.g. a login from a new address).  OnSecurityEvent is only
+// called if the current user is the event's user.
+type SecurityClient interface {
+	UserClient
+	OnSecurityEvent()
+}
+
+// MuteClient may be implemented by clients acting as a single user at a time, to be left out of the
+// changes to the channels that user has muted.  IsChannelMuted is called when a channel change is
+// delivered, and OnChannelChanged isn't called if it returns true (so a client still showing a
+// muted channel returns false for it).
+type MuteClient interface {
+	UserClient
+	IsChannelMuted(channelname string) bool
+}
+
+// PresenceClient may be implemented by clients that show which users are online.  OnPresenceChanged
+// is called when a user goes online or offline (clients that don't implement it never are).
+type PresenceClient interface {
+	Client
+	OnPresenceChanged(username string)
+}
+
+// MaxRecentEvents is the number of recent notifications kept for EventsSince.
+const MaxRecentEvents int = 1000
+
+// Event is a notification kept by the engine.  Method is the name of the Client function
+// that was called, and Name is its argument (if any), followed by the message ID for
+// OnMessageChanged and the new name for OnChannelRenamed and OnUserRenamed.
+type Event struct {
+	Seq       uint64
+	Method    string
+	Name      string
+	MessageID uint64
+	NewName   string
 }
 
 type clientInfo struct {
-	client Client
+	client  Client
+	pending []notification
+	seqs    map[notification]uint64
+	closed  bool
+	mutex   sync.Mutex
+	cond    *sync.Cond
+}
+
+func newClientInfo(client Client) *clientInfo {
+	info := clientInfo{
+		client:  client,
+		pending: make([]notification, 0),
+		seqs:    make(map[notification]uint64),
+	}
+	info.cond = sync.NewCond(&info.mutex)
+
+	go info.deliver()
+
+	return &info
+}
+
+// notify queues a notification for the client (unless it's already waiting to be delivered).
+func (c *clientInfo) notify(n notification, seq uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.seqs[n]; ok {
+		return
+	}
+
+	c.seqs[n] = seq
+	c.pending = append(c.pending, n)
+	c.cond.Signal()
+}
+
+// close stops delivering notifications to the client.
+func (c *clientInfo) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	c.cond.Signal()
+}
+
+func (c *clientInfo) deliver() {
+	for {
+		c.mutex.Lock()
+		for len(c.pending) == 0 && !c.closed {
+			c.cond.Wait()
+		}
+
+		if c.closed {
+			c.mutex.Unlock()
+			return
+		}
+
+		n := c.pending[0]
+		c.pending = c.pending[1:]
+		seq := c.seqs[n]
+		delete(c.seqs, n)
+		c.mutex.Unlock()
+
+		// Drafts only go to their user
+		if n.kind == draftChanged {
+			draftClient, ok := c.client.(DraftClient)
+			if !ok || draftClient.CurrentUser() != n.name {
+				continue
+			}
+		}
+
+		// Read markers only go to their user
+		if n.kind == readMarkerChanged {
+			readMarkerClient, ok := c.client.(ReadMarkerClient)
+			if !ok || readMarkerClient.CurrentUser() != n.name {
+				continue
+			}
+		}
+
+		// Mentions only go to the mentioned user
+		if n.kind == mentioned {
+			mentionClient, ok := c.client.(MentionClient)
+			if !ok || mentionClient.CurrentUser() != n.name {
+				continue
+			}
+		}
+
+		// Security events only go to their user
+		if n.kind == securityEvent {
+			securityClient, ok := c.client.(SecurityClient)
+			if !ok || securityClient.CurrentUser() != n.name {
+				continue
+			}
+		}
+
+		// Channel changes don't go to the users that muted the channel
+		if n.kind == channelChanged {
+			muteClient, ok := c.client.(MuteClient)
+			if ok && muteClient.IsChannelMuted(n.name) {
+				continue
+			}
+		}
+
+		// Presence only goes to the clients that show it
+		if n.kind == presenceChanged {
+			if _, ok := c.client.(PresenceClient); !ok {
+				continue
+			}
+		}
+
+		// Direct messages only go to the users in the conversation (and group messages to the
+		// members of the group)
+		otherUsername := ""
+		if n.kind == directMessagesChanged || n.kind == groupChanged {
+			userClient, ok := c.client.(UserClient)
+			if !ok {
+				continue
+			}
+
+			currentUser := userClient.CurrentUser()
+			switch {
+			case n.kind == groupChanged:
+				if !isMember(currentUser, n.name) {
+					continue
+				}
+			case currentUser == n.name:
+				otherUsername = n.otherName
+			case currentUser == n.otherName:
+				otherUsername = n.name
+			default:
+				continue
+			}
+		}
+
+		if sequencedClient, ok := c.client.(SequencedClient); ok {
+			sequencedClient.OnSeq(seq)
+		}
+
+		switch n.kind {
+		case usersChanged:
+			c.client.OnUsersChanged()
+		case userChanged:
+			c.client.OnUserChanged(n.name)
+		case channelsChanged:
+			c.client.OnChannelsChanged()
+		case channelChanged:
+			c.client.OnChannelChanged(n.name)
+		case messageChanged:
+			c.client.OnMessageChanged(n.name, n.id)
+		case directMessagesChanged:
+			c.client.(UserClient).OnDirectMessagesChanged(otherUsername)
+		case groupChanged:
+			c.client.(UserClient).OnGroupChanged(n.id)
+		case draftChanged:
+			c.client.(DraftClient).OnDraftChanged(n.otherName)
+		case readMarkerChanged:
+			c.client.(ReadMarkerClient).OnReadMarkerChanged(n.otherName)
+		case mentioned:
+			c.client.(MentionClient).OnMentioned(n.otherName, n.id)
+		case securityEvent:
+			c.client.(SecurityClient).OnSecurityEvent()
+		case presenceChanged:
+			c.client.(PresenceClient).OnPresenceChanged(n.name)
+		case channelRenamed:
+			if renameClient, ok := c.client.(RenameClient); ok {
+				renameClient.OnChannelRenamed(n.name, n.otherName)
+			} else {
+				c.client.OnChannelsChanged()
+			}
+		case userRenamed:
+			if renameClient, ok := c.client.(RenameClient); ok {
+				renameClient.OnUserRenamed(n.name, n.otherName)
+			} else {
+				c.client.OnUsersChanged()
+			}
+		}
+	}
+}
+
+// isMember returns whether a user is one of the members of a group notification (space separated,
+// since names can't contain spaces).
+func isMember(username string, members string) bool {
+	for _, member := range strings.Fields(members) {
+		if member == username {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Engine provides the subscription engine functionality.  It contains information about
@@ -26,12 +378,16 @@ type clientInfo struct {
 type Engine struct {
 	mutex   sync.Mutex
 	clients map[Client]*clientInfo
+	lastSeq uint64
+	recent  []Event
+	oldest  int
 }
 
 // NewEngine creates/initializes/returns a new Engine.
 func NewEngine() *Engine {
 	engine := Engine{
 		clients: make(map[Client]*clientInfo),
+		recent:  make([]Event, 0, MaxRecentEvents),
 	}
 
 	return &engine
@@ -47,13 +403,8 @@ func (e *Engine) Connect(client Client) error {
 		return errors.New("Client already exists")
 	}
 
-	// Create a new client
-	newClient := clientInfo{
-		client: client,
-	}
-
-	// Add the client to the list
-	e.clients[client] = &newClient
+	// Add a new client to the list (which starts delivering its notifications)
+	e.clients[client] = newClientInfo(client)
 
 	return nil
 }
@@ -64,60 +415,195 @@ func (e *Engine) Disconnect(client Client) error {
 	defer e.mutex.Unlock()
 
 	// Make sure the client exists
-	if _, ok := e.clients[client]; !ok {
+	info, ok := e.clients[client]
+	if !ok {
 		return errors.New("Client doesn't exist")
 	}
 
-	// Delete the client from the list
+	// Delete the client from the list (and stop delivering its notifications)
 	delete(e.clients, client)
+	info.close()
 
 	return nil
 }
 
+// NumClients returns the number of connected clients.
+func (e *Engine) NumClients() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return len(e.clients)
+}
+
 // UsersChanged will notify subscribers (asynchronously) that the users have changed.
 func (e *Engine) UsersChanged() {
-	go func() {
-		e.mutex.Lock()
-		defer e.mutex.Unlock()
-
-		for client := range e.clients {
-			client.OnUsersChanged()
-		}
-	}()
+	e.notify(notification{kind: usersChanged})
 }
 
 // UserChanged will notify subscribers (asynchronously) that a user has changed.
 func (e *Engine) UserChanged(username string) {
-	go func() {
-		e.mutex.Lock()
-		defer e.mutex.Unlock()
-
-		for client := range e.clients {
-			client.OnUserChanged(username)
-		}
-	}()
+	e.notify(notification{kind: userChanged, name: username})
 }
 
 // ChannelsChanged will notify subscribers (asynchronously) that the channels have changed.
 func (e *Engine) ChannelsChanged() {
-	go func() {
-		e.mutex.Lock()
-		defer e.mutex.Unlock()
-
-		for client := range e.clients {
-			client.OnChannelsChanged()
-		}
-	}()
+	e.notify(notification{kind: channelsChanged})
 }
 
 // ChannelChanged will notify subscribers (asynchronously) that a channel has changed.
 func (e *Engine) ChannelChanged(channelname string) {
-	go func() {
-		e.mutex.Lock()
-		defer e.mutex.Unlock()
+	e.notify(notification{kind: channelChanged, name: channelname})
+}
 
-		for client := range e.clients {
-			client.OnChannelChanged(channelname)
-		}
-	}()
+// ChannelRenamed will notify subscribers (asynchronously) that a channel has been renamed (which
+// changes the channels, for the clients that aren't RenameClients).
+func (e *Engine) ChannelRenamed(channelname string, newChannelname string) {
+	e.notify(notification{kind: channelRenamed, name: channelname, otherName: newChannelname})
+}
+
+// UserRenamed will notify subscribers (asynchronously) that a user has been renamed (which changes
+// the users, for the clients that aren't RenameClients).
+func (e *Engine) UserRenamed(username string, newUsername string) {
+	e.notify(notification{kind: userRenamed, name: username, otherName: newUsername})
+}
+
+// MessageChanged will notify subscribers (asynchronously) that a message in a channel has changed
+// (it was edited or deleted).
+func (e *Engine) MessageChanged(channelname string, messageID uint64) {
+	e.notify(notification{kind: messageChanged, name: channelname, id: messageID})
+}
+
+// PresenceChanged will notify subscribers (asynchronously) that a user went online or offline.
+func (e *Engine) PresenceChanged(username string) {
+	e.notify(notification{kind: presenceChanged, name: username})
+}
+
+// DirectMessagesChanged will notify the clients of two users (asynchronously) that the direct
+// messages between them have changed.  The notification isn't numbered (the clients are given the
+// number of the latest notification) or kept for EventsSince, so a client that reconnects needs to
+// fetch its direct messages again.
+func (e *Engine) DirectMessagesChanged(username string, otherUsername string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	n := notification{kind: directMessagesChanged, name: username, otherName: otherUsername}
+	for _, info := range e.clients {
+		info.notify(n, e.lastSeq)
+	}
+}
+
+// GroupChanged will notify the clients of the members of a group (asynchronously) that its messages
+// have changed.  As with DirectMessagesChanged, the notification isn't numbered or kept for
+// EventsSince.
+func (e *Engine) GroupChanged(groupID uint64, members []string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	n := notification{kind: groupChanged, name: strings.Join(members, " "), id: groupID}
+	for _, info := range e.clients {
+		info.notify(n, e.lastSeq)
+	}
+}
+
+// DraftChanged will notify the clients of a user (asynchronously) that their draft for a channel
+// has changed.  As with DirectMessagesChanged, the notification isn't numbered or kept for
+// EventsSince.
+func (e *Engine) DraftChanged(username string, channelname string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	n := notification{kind: draftChanged, name: username, otherName: channelname}
+	for _, info := range e.clients {
+		info.notify(n, e.lastSeq)
+	}
+}
+
+// ReadMarkerChanged will notify the clients of a user (asynchronously) that they've read a channel
+// further.  As with DraftChanged, the notification isn't numbered or kept for EventsSince.
+func (e *Engine) ReadMarkerChanged(username string, channelname string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	n := notification{kind: readMarkerChanged, name: username, otherName: channelname}
+	for _, info := range e.clients {
+		info.notify(n, e.lastSeq)
+	}
+}
+
+// Mentioned will notify the clients of a user (asynchronously) that they were mentioned in a
+// message in a channel.  As with DraftChanged, the notification isn't numbered or kept for
+// EventsSince.
+func (e *Engine) Mentioned(username string, channelname string, messageID uint64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	n := notification{kind: mentioned, name: username, id: messageID, otherName: channelname}
+	for _, info := range e.clients {
+		info.notify(n, e.lastSeq)
+	}
+}
+
+// SecurityEvent will notify the clients of a user (asynchronously) of a security event on their
+// account.  As with DraftChanged, the notification isn't numbered or kept for EventsSince.
+func (e *Engine) SecurityEvent(username string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	n := notification{kind: securityEvent, name: username}
+	for _, info := range e.clients {
+		info.notify(n, e.lastSeq)
+	}
+}
+
+// LastSeq returns the sequence number of the most recent notification (0 if there hasn't been
+// one).
+func (e *Engine) LastSeq() uint64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.lastSeq
+}
+
+// EventsSince returns the notifications made after a sequence number, oldest first.  It
+// returns false if some of them are no longer kept (the caller needs to refetch all of the
+// state instead).
+func (e *Engine) EventsSince(seq uint64) ([]Event, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	events := make([]Event, 0)
+	if seq >= e.lastSeq {
+		return events, true
+	}
+
+	// The recent events are consecutive, so the first one after seq can be found directly
+	oldestSeq := e.lastSeq - uint64(len(e.recent)) + 1
+	if seq+1 < oldestSeq {
+		return events, false
+	}
+
+	for i := int(seq + 1 - oldestSeq); i < len(e.recent); i++ {
+		events = append(events, e.recent[(e.oldest+i)%len(e.recent)])
+	}
+
+	return events, true
+}
+
+func (e *Engine) notify(n notification) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// Number and keep the notification (replacing the oldest one once the buffer is full)
+	e.lastSeq++
+	event := Event{Seq: e.lastSeq, Method: n.method(), Name: n.name, MessageID: n.id, NewName: n.otherName}
+	if len(e.recent) < MaxRecentEvents {
+		e.recent = append(e.recent, event)
+	} else {
+		e.recent[e.oldest] = event
+		e.oldest = (e.oldest + 1) % MaxRecentEvents
+	}
+
+	for _, info := range e.clients {
+		info.notify(n, e.lastSeq)
+	}
 }
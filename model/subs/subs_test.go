@@ -14,6 +14,8 @@ type TestClient struct {
 	OnChannelsChangedChan       chan int
 	OnChannelChangedChan        chan string
 	OnChannelChangedChannelname []string
+	OnMessageChangedChan        chan subs.Event
+	OnMessageChangedEvents      []subs.Event
 }
 
 func NewTestClient() *TestClient {
@@ -30,6 +32,8 @@ func (t *TestClient) Reset() {
 	t.OnChannelsChangedChan = make(chan int, 1)
 	t.OnChannelChangedChan = make(chan string, 1)
 	t.OnChannelChangedChannelname = make([]string, 0)
+	t.OnMessageChangedChan = make(chan subs.Event, 1)
+	t.OnMessageChangedEvents = make([]subs.Event, 0)
 }
 
 func (t *TestClient) WaitForOnUsersChanged() error {
@@ -70,6 +74,16 @@ func (t *TestClient) WaitForOnChannelChanged() error {
 	}
 }
 
+func (t *TestClient) WaitForOnMessageChanged() error {
+	select {
+	case event := <-t.OnMessageChangedChan:
+		t.OnMessageChangedEvents = append(t.OnMessageChangedEvents, event)
+		return nil
+	case <-time.After(25 * time.Millisecond):
+		return errors.New("Timed out waiting for OnMessageChanged")
+	}
+}
+
 func (t *TestClient) OnUsersChanged() {
 	t.OnUsersChangedChan <- 0
 }
@@ -86,6 +100,10 @@ func (t *TestClient) OnChannelChanged(channelname string) {
 	t.OnChannelChangedChan <- channelname
 }
 
+func (t *TestClient) OnMessageChanged(channelname string, messageID uint64) {
+	t.OnMessageChangedChan <- subs.Event{Name: channelname, MessageID: messageID}
+}
+
 func TestConnectAndDisconnect(t *testing.T) {
 	testClient := NewTestClient()
 	engine := subs.NewEngine()
@@ -99,11 +117,19 @@ func TestConnectAndDisconnect(t *testing.T) {
 		t.Error("Double connect didn't fail")
 	}
 
+	if engine.NumClients() != 1 {
+		t.Error("Incorrect number of clients after connect")
+	}
+
 	err = engine.Disconnect(testClient)
 	if err != nil {
 		t.Error("Disconnect failed")
 	}
 
+	if engine.NumClients() != 0 {
+		t.Error("Incorrect number of clients after disconnect")
+	}
+
 	err = engine.Disconnect(testClient)
 	if err == nil {
 		t.Error("Double disconntect didn't fail")
@@ -175,6 +201,20 @@ func TestMultiClient(t *testing.T) {
 		t.Error("Incorrect channelname provided to OnChannelChanged")
 	}
 
+	engine.MessageChanged("channel1", 42)
+	err = testClient1.WaitForOnMessageChanged()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(testClient1.OnMessageChangedEvents) != 1 || testClient1.OnMessageChangedEvents[0].Name != "channel1" || testClient1.OnMessageChangedEvents[0].MessageID != 42 {
+		t.Error("Incorrect channelname/message ID provided to OnMessageChanged")
+	}
+
+	err = testClient2.WaitForOnMessageChanged()
+	if err != nil {
+		t.Error(err)
+	}
+
 	engine.Disconnect(testClient2)
 
 	engine.UsersChanged()
@@ -221,3 +261,683 @@ func TestMultiClient(t *testing.T) {
 		t.Error("Got ChannelChanged call after disconnecting")
 	}
 }
+
+func TestDeliveryOrder(t *testing.T) {
+	testClient := NewTestClient()
+	engine := subs.NewEngine()
+	engine.Connect(testClient)
+
+	engine.ChannelChanged("channel1")
+	engine.ChannelChanged("channel2")
+	engine.ChannelChanged("channel3")
+
+	for i := 0; i < 3; i++ {
+		err := testClient.WaitForOnChannelChanged()
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	channelnames := testClient.OnChannelChangedChannelname
+	if len(channelnames) != 3 || channelnames[0] != "channel1" || channelnames[1] != "channel2" || channelnames[2] != "channel3" {
+		t.Error("Notifications delivered out of order")
+	}
+}
+
+type BlockingClient struct {
+	TestClient
+	Delivering chan string
+	Release    chan struct{}
+}
+
+func (b *BlockingClient) OnChannelChanged(channelname string) {
+	b.Delivering <- channelname
+	<-b.Release
+}
+
+func TestPendingNotificationsCoalesce(t *testing.T) {
+	blockingClient := &BlockingClient{
+		TestClient: *NewTestClient(),
+		Delivering: make(chan string, 10),
+		Release:    make(chan struct{}, 10),
+	}
+	engine := subs.NewEngine()
+	engine.Connect(blockingClient)
+
+	// Hold up the client on the first notification
+	engine.ChannelChanged("channel1")
+	if <-blockingClient.Delivering != "channel1" {
+		t.Error("Incorrect first notification")
+	}
+
+	// Repeated notifications are only queued once while they're pending
+	engine.ChannelChanged("channel2")
+	engine.ChannelChanged("channel2")
+	engine.ChannelChanged("channel2")
+
+	for i := 0; i < 3; i++ {
+		blockingClient.Release <- struct{}{}
+	}
+
+	select {
+	case channelname := <-blockingClient.Delivering:
+		if channelname != "channel2" {
+			t.Error("Incorrect second notification")
+		}
+	case <-time.After(25 * time.Millisecond):
+		t.Error("Timed out waiting for OnChannelChanged")
+	}
+
+	select {
+	case <-blockingClient.Delivering:
+		t.Error("Pending notification wasn't coalesced")
+	case <-time.After(25 * time.Millisecond):
+	}
+}
+
+func TestEventsSince(t *testing.T) {
+	engine := subs.NewEngine()
+	if engine.LastSeq() != 0 {
+		t.Error("Incorrect initial LastSeq")
+	}
+
+	engine.UsersChanged()
+	engine.UserChanged("user1")
+	engine.ChannelChanged("channel1")
+	engine.MessageChanged("channel1", 42)
+
+	events, ok := engine.EventsSince(0)
+	if !ok || len(events) != 4 {
+		t.Error("Failed to get all events")
+	} else if events[0].Seq != 1 || events[0].Method != "OnUsersChanged" ||
+		events[1].Seq != 2 || events[1].Method != "OnUserChanged" || events[1].Name != "user1" ||
+		events[2].Seq != 3 || events[2].Method != "OnChannelChanged" || events[2].Name != "channel1" ||
+		events[3].Seq != 4 || events[3].Method != "OnMessageChanged" || events[3].Name != "channel1" || events[3].MessageID != 42 {
+		t.Error("Incorrect events")
+	}
+
+	events, ok = engine.EventsSince(3)
+	if !ok || len(events) != 1 || events[0].Seq != 4 {
+		t.Error("Failed to get events since seq")
+	}
+
+	events, ok = engine.EventsSince(engine.LastSeq())
+	if !ok || len(events) != 0 {
+		t.Error("Got events since the last seq")
+	}
+
+	// Only the most recent events are kept
+	for i := 0; i < subs.MaxRecentEvents; i++ {
+		engine.ChannelsChanged()
+	}
+
+	_, ok = engine.EventsSince(2)
+	if ok {
+		t.Error("Got events that are no longer kept")
+	}
+
+	lastSeq := engine.LastSeq()
+	events, ok = engine.EventsSince(lastSeq - 10)
+	if !ok || len(events) != 10 || events[0].Seq != lastSeq-9 || events[9].Seq != lastSeq {
+		t.Error("Failed to get recent events")
+	}
+}
+
+type SequencedClient struct {
+	TestClient
+	Seqs chan uint64
+}
+
+func (s *SequencedClient) OnSeq(seq uint64) {
+	s.Seqs <- seq
+}
+
+func TestSequencedClient(t *testing.T) {
+	sequencedClient := &SequencedClient{
+		TestClient: *NewTestClient(),
+		Seqs:       make(chan uint64, 10),
+	}
+	engine := subs.NewEngine()
+	engine.UsersChanged()
+	engine.Connect(sequencedClient)
+
+	engine.ChannelChanged("channel1")
+	err := sequencedClient.WaitForOnChannelChanged()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if <-sequencedClient.Seqs != 2 {
+		t.Error("Incorrect notification seq")
+	}
+}
+
+type UserClient struct {
+	TestClient
+	Username                    string
+	OnDirectMessagesChangedChan chan string
+	OnGroupChangedChan          chan uint64
+}
+
+func (u *UserClient) CurrentUser() string {
+	return u.Username
+}
+
+func (u *UserClient) OnDirectMessagesChanged(otherUsername string) {
+	u.OnDirectMessagesChangedChan <- otherUsername
+}
+
+func (u *UserClient) OnGroupChanged(groupID uint64) {
+	u.OnGroupChangedChan <- groupID
+}
+
+func (u *UserClient) WaitForOnGroupChanged() (uint64, error) {
+	select {
+	case groupID := <-u.OnGroupChangedChan:
+		return groupID, nil
+	case <-time.After(25 * time.Millisecond):
+		return 0, errors.New("Timed out waiting for OnGroupChanged")
+	}
+}
+
+func (u *UserClient) WaitForOnDirectMessagesChanged() (string, error) {
+	select {
+	case otherUsername := <-u.OnDirectMessagesChangedChan:
+		return otherUsername, nil
+	case <-time.After(25 * time.Millisecond):
+		return "", errors.New("Timed out waiting for OnDirectMessagesChanged")
+	}
+}
+
+func TestDirectMessagesChanged(t *testing.T) {
+	engine := subs.NewEngine()
+	testClient := NewTestClient()
+	engine.Connect(testClient)
+
+	userClients := make([]*UserClient, 0)
+	for _, username := range []string{"user1", "user2", "user3"} {
+		userClient := &UserClient{
+			TestClient:                  *NewTestClient(),
+			Username:                    username,
+			OnDirectMessagesChangedChan: make(chan string, 10),
+			OnGroupChangedChan:          make(chan uint64, 10),
+		}
+		engine.Connect(userClient)
+		userClients = append(userClients, userClient)
+	}
+
+	engine.ChannelChanged("channel1")
+	engine.DirectMessagesChanged("user1", "user2")
+	engine.ChannelChanged("channel2")
+
+	// Ensure that only the users in the conversation are notified, with the other user in it
+	otherUsername, err := userClients[0].WaitForOnDirectMessagesChanged()
+	if err != nil || otherUsername != "user2" {
+		t.Error("Failed to notify the first user")
+	}
+
+	otherUsername, err = userClients[1].WaitForOnDirectMessagesChanged()
+	if err != nil || otherUsername != "user1" {
+		t.Error("Failed to notify the second user")
+	}
+
+	// Once the notifications after the direct message have been delivered, the direct message would
+	// have been too
+	for _, client := range []*TestClient{testClient, &userClients[2].TestClient} {
+		client.WaitForOnChannelChanged()
+		client.WaitForOnChannelChanged()
+	}
+
+	if _, err := userClients[2].WaitForOnDirectMessagesChanged(); err == nil {
+		t.Error("Notified a user that isn't in the conversation")
+	}
+
+	// Ensure that the notification isn't numbered or kept
+	events, ok := engine.EventsSince(0)
+	if !ok || len(events) != 2 || engine.LastSeq() != 2 {
+		t.Error("Direct message notification was numbered or kept")
+	}
+}
+
+func TestGroupChanged(t *testing.T) {
+	engine := subs.NewEngine()
+	testClient := NewTestClient()
+	engine.Connect(testClient)
+
+	userClients := make([]*UserClient, 0)
+	for _, username := range []string{"user1", "user2", "user3", "user10"} {
+		userClient := &UserClient{
+			TestClient:                  *NewTestClient(),
+			Username:                    username,
+			OnDirectMessagesChangedChan: make(chan string, 10),
+			OnGroupChangedChan:          make(chan uint64, 10),
+		}
+		engine.Connect(userClient)
+		userClients = append(userClients, userClient)
+	}
+
+	engine.ChannelChanged("channel1")
+	engine.GroupChanged(7, []string{"user1", "user2", "user3"})
+	engine.ChannelChanged("channel2")
+
+	// Ensure that only the members of the group are notified, with the group's ID
+	for _, userClient := range userClients[:3] {
+		groupID, err := userClient.WaitForOnGroupChanged()
+		if err != nil || groupID != 7 {
+			t.Error("Failed to notify", userClient.Username)
+		}
+	}
+
+	// Once the notifications after the group message have been delivered, the group message would
+	// have been too
+	for _, client := range []*TestClient{testClient, &userClients[3].TestClient} {
+		client.WaitForOnChannelChanged()
+		client.WaitForOnChannelChanged()
+	}
+
+	if _, err := userClients[3].WaitForOnGroupChanged(); err == nil {
+		t.Error("Notified a user that isn't a member of the group")
+	}
+
+	// Ensure that the notification isn't numbered or kept
+	events, ok := engine.EventsSince(0)
+	if !ok || len(events) != 2 || engine.LastSeq() != 2 {
+		t.Error("Group notification was numbered or kept")
+	}
+}
+
+type RenameClient struct {
+	TestClient
+	OnChannelRenamedChan chan subs.Event
+	OnUserRenamedChan    chan subs.Event
+}
+
+func (r *RenameClient) OnChannelRenamed(channelname string, newChannelname string) {
+	r.OnChannelRenamedChan <- subs.Event{Name: channelname, NewName: newChannelname}
+}
+
+func (r *RenameClient) OnUserRenamed(username string, newUsername string) {
+	r.OnUserRenamedChan <- subs.Event{Name: username, NewName: newUsername}
+}
+
+func NewRenameClient() *RenameClient {
+	return &RenameClient{
+		TestClient:           *NewTestClient(),
+		OnChannelRenamedChan: make(chan subs.Event, 10),
+		OnUserRenamedChan:    make(chan subs.Event, 10),
+	}
+}
+
+func TestChannelRenamed(t *testing.T) {
+	engine := subs.NewEngine()
+	testClient := NewTestClient()
+	renameClient := NewRenameClient()
+	engine.Connect(testClient)
+	engine.Connect(renameClient)
+
+	engine.ChannelRenamed("channel1", "channel2")
+
+	// Ensure that rename clients are told the new name, and other clients that the channels changed
+	select {
+	case event := <-renameClient.OnChannelRenamedChan:
+		if event.Name != "channel1" || event.NewName != "channel2" {
+			t.Error("Incorrect rename notification")
+		}
+	case <-time.After(25 * time.Millisecond):
+		t.Error("Timed out waiting for OnChannelRenamed")
+	}
+
+	if testClient.WaitForOnChannelsChanged() != nil {
+		t.Error("Failed to notify a client that doesn't follow renames")
+	}
+
+	// Ensure that the notification is kept, with both names
+	events, ok := engine.EventsSince(0)
+	if !ok || len(events) != 1 || events[0].Method != "OnChannelRenamed" || events[0].Name != "channel1" || events[0].NewName != "channel2" {
+		t.Error("Incorrect rename event")
+	}
+}
+
+func TestUserRenamed(t *testing.T) {
+	engine := subs.NewEngine()
+	testClient := NewTestClient()
+	renameClient := NewRenameClient()
+	engine.Connect(testClient)
+	engine.Connect(renameClient)
+
+	engine.UserRenamed("user1", "user2")
+
+	// Ensure that rename clients are told the new name, and other clients that the users changed
+	select {
+	case event := <-renameClient.OnUserRenamedChan:
+		if event.Name != "user1" || event.NewName != "user2" {
+			t.Error("Incorrect rename notification")
+		}
+	case <-time.After(25 * time.Millisecond):
+		t.Error("Timed out waiting for OnUserRenamed")
+	}
+
+	if testClient.WaitForOnUsersChanged() != nil {
+		t.Error("Failed to notify a client that doesn't follow renames")
+	}
+
+	// Ensure that the notification is kept, with both names
+	events, ok := engine.EventsSince(0)
+	if !ok || len(events) != 1 || events[0].Method != "OnUserRenamed" || events[0].Name != "user1" || events[0].NewName != "user2" {
+		t.Error("Incorrect rename event")
+	}
+}
+
+type DraftClient struct {
+	UserClient
+	OnDraftChangedChan chan string
+}
+
+func (d *DraftClient) OnDraftChanged(channelname string) {
+	d.OnDraftChangedChan <- channelname
+}
+
+func TestDraftChanged(t *testing.T) {
+	engine := subs.NewEngine()
+
+	// A user client that doesn't handle drafts is never told about them
+	userClient := &UserClient{
+		TestClient:                  *NewTestClient(),
+		Username:                    "user1",
+		OnDirectMessagesChangedChan: make(chan string, 10),
+		OnGroupChangedChan:          make(chan uint64, 10),
+	}
+	engine.Connect(userClient)
+
+	draftClients := make([]*DraftClient, 0)
+	for _, username := range []string{"user1", "user1", "user2"} {
+		draftClient := &DraftClient{
+			UserClient: UserClient{
+				TestClient:                  *NewTestClient(),
+				Username:                    username,
+				OnDirectMessagesChangedChan: make(chan string, 10),
+				OnGroupChangedChan:          make(chan uint64, 10),
+			},
+			OnDraftChangedChan: make(chan string, 10),
+		}
+		engine.Connect(draftClient)
+		draftClients = append(draftClients, draftClient)
+	}
+
+	engine.DraftChanged("user1", "channel1")
+	engine.ChannelChanged("channel2")
+
+	// Ensure that each of the user's clients is notified, with the channel
+	for _, draftClient := range draftClients[:2] {
+		select {
+		case channelname := <-draftClient.OnDraftChangedChan:
+			if channelname != "channel1" {
+				t.Error("Incorrect draft notification")
+			}
+		case <-time.After(25 * time.Millisecond):
+			t.Error("Timed out waiting for OnDraftChanged")
+		}
+	}
+
+	// Once the notification after the draft has been delivered, the draft would have been too
+	draftClients[2].WaitForOnChannelChanged()
+	select {
+	case <-draftClients[2].OnDraftChangedChan:
+		t.Error("Notified another user of a draft")
+	default:
+	}
+
+	// Ensure that the notification isn't numbered or kept
+	events, ok := engine.EventsSince(0)
+	if !ok || len(events) != 1 || engine.LastSeq() != 1 {
+		t.Error("Draft notification was numbered or kept")
+	}
+}
+
+type SecurityClient struct {
+	UserClient
+	OnSecurityEventChan chan bool
+}
+
+func (s *SecurityClient) OnSecurityEvent() {
+	s.OnSecurityEventChan <- true
+}
+
+func TestSecurityEvent(t *testing.T) {
+	engine := subs.NewEngine()
+
+	securityClients := make([]*SecurityClient, 0)
+	for _, username := range []string{"user1", "user2"} {
+		securityClient := &SecurityClient{
+			UserClient: UserClient{
+				TestClient:                  *NewTestClient(),
+				Username:                    username,
+				OnDirectMessagesChangedChan: make(chan string, 10),
+				OnGroupChangedChan:          make(chan uint64, 10),
+			},
+			OnSecurityEventChan: make(chan bool, 10),
+		}
+		engine.Connect(securityClient)
+		securityClients = append(securityClients, securityClient)
+	}
+
+	engine.SecurityEvent("user1")
+	engine.ChannelChanged("channel1")
+
+	// Ensure that the user's client is notified
+	select {
+	case <-securityClients[0].OnSecurityEventChan:
+	case <-time.After(25 * time.Millisecond):
+		t.Error("Timed out waiting for OnSecurityEvent")
+	}
+
+	// Once the notification after the event has been delivered, the event would have been too
+	securityClients[1].WaitForOnChannelChanged()
+	select {
+	case <-securityClients[1].OnSecurityEventChan:
+		t.Error("Notified another user of a security event")
+	default:
+	}
+
+	// Ensure that the notification isn't numbered or kept
+	events, ok := engine.EventsSince(0)
+	if !ok || len(events) != 1 || engine.LastSeq() != 1 {
+		t.Error("Security event notification was numbered or kept")
+	}
+}
+
+type ReadMarkerClient struct {
+	UserClient
+	OnReadMarkerChangedChan chan string
+}
+
+func (r *ReadMarkerClient) OnReadMarkerChanged(channelname string) {
+	r.OnReadMarkerChangedChan <- channelname
+}
+
+func TestReadMarkerChanged(t *testing.T) {
+	engine := subs.NewEngine()
+
+	readMarkerClients := make([]*ReadMarkerClient, 0)
+	for _, username := range []string{"user1", "user2"} {
+		readMarkerClient := &ReadMarkerClient{
+			UserClient: UserClient{
+				TestClient:                  *NewTestClient(),
+				Username:                    username,
+				OnDirectMessagesChangedChan: make(chan string, 10),
+				OnGroupChangedChan:          make(chan uint64, 10),
+			},
+			OnReadMarkerChangedChan: make(chan string, 10),
+		}
+		engine.Connect(readMarkerClient)
+		readMarkerClients = append(readMarkerClients, readMarkerClient)
+	}
+
+	engine.ReadMarkerChanged("user1", "channel1")
+	engine.ChannelChanged("channel2")
+
+	// Ensure that the user's client is notified, with the channel
+	select {
+	case channelname := <-readMarkerClients[0].OnReadMarkerChangedChan:
+		if channelname != "channel1" {
+			t.Error("Incorrect read marker notification")
+		}
+	case <-time.After(25 * time.Millisecond):
+		t.Error("Timed out waiting for OnReadMarkerChanged")
+	}
+
+	// Once the notification after the read marker has been delivered, the read marker would have
+	// been too
+	readMarkerClients[1].WaitForOnChannelChanged()
+	select {
+	case <-readMarkerClients[1].OnReadMarkerChangedChan:
+		t.Error("Notified another user of a read marker")
+	default:
+	}
+
+	// Ensure that the notification isn't numbered or kept
+	events, ok := engine.EventsSince(0)
+	if !ok || len(events) != 1 || engine.LastSeq() != 1 {
+		t.Error("Read marker notification was numbered or kept")
+	}
+}
+
+type MentionClient struct {
+	UserClient
+	OnMentionedChan chan uint64
+}
+
+func (m *MentionClient) OnMentioned(channelname string, messageID uint64) {
+	m.OnMentionedChan <- messageID
+}
+
+func TestMentioned(t *testing.T) {
+	engine := subs.NewEngine()
+
+	mentionClients := make([]*MentionClient, 0)
+	for _, username := range []string{"user1", "user2"} {
+		mentionClient := &MentionClient{
+			UserClient: UserClient{
+				TestClient:                  *NewTestClient(),
+				Username:                    username,
+				OnDirectMessagesChangedChan: make(chan string, 10),
+				OnGroupChangedChan:          make(chan uint64, 10),
+			},
+			OnMentionedChan: make(chan uint64, 10),
+		}
+		engine.Connect(mentionClient)
+		mentionClients = append(mentionClients, mentionClient)
+	}
+
+	engine.Mentioned("user1", "channel1", 5)
+	engine.ChannelChanged("channel2")
+
+	// Ensure that the mentioned user's client is notified, with the message
+	select {
+	case messageID := <-mentionClients[0].OnMentionedChan:
+		if messageID != 5 {
+			t.Error("Incorrect mention notification")
+		}
+	case <-time.After(25 * time.Millisecond):
+		t.Error("Timed out waiting for OnMentioned")
+	}
+
+	// Once the notification after the mention has been delivered, the mention would have been too
+	mentionClients[1].WaitForOnChannelChanged()
+	select {
+	case <-mentionClients[1].OnMentionedChan:
+		t.Error("Notified another user of a mention")
+	default:
+	}
+
+	// Ensure that the notification isn't numbered or kept
+	events, ok := engine.EventsSince(0)
+	if !ok || len(events) != 1 || engine.LastSeq() != 1 {
+		t.Error("Mention notification was numbered or kept")
+	}
+}
+
+type MuteClient struct {
+	UserClient
+	MutedChannels map[string]bool
+}
+
+func (m *MuteClient) IsChannelMuted(channelname string) bool {
+	return m.MutedChannels[channelname]
+}
+
+func TestChannelMuted(t *testing.T) {
+	engine := subs.NewEngine()
+
+	// A client that doesn't know about muting gets every channel change
+	testClient := NewTestClient()
+	engine.Connect(testClient)
+
+	muteClient := &MuteClient{
+		UserClient: UserClient{
+			TestClient:                  *NewTestClient(),
+			Username:                    "user1",
+			OnDirectMessagesChangedChan: make(chan string, 10),
+			OnGroupChangedChan:          make(chan uint64, 10),
+		},
+		MutedChannels: map[string]bool{"channel1": true},
+	}
+	engine.Connect(muteClient)
+
+	engine.ChannelChanged("channel1")
+	engine.ChannelChanged("channel2")
+
+	// Ensure that the muted channel's change is skipped, and the one after it still delivered
+	if testClient.WaitForOnChannelChanged() != nil || testClient.WaitForOnChannelChanged() != nil {
+		t.Error("Timed out waiting for OnChannelChanged")
+	}
+	if muteClient.WaitForOnChannelChanged() != nil || muteClient.OnChannelChangedChannelname[0] != "channel2" {
+		t.Error("Failed to notify client of an unmuted channel's change")
+	}
+	if muteClient.WaitForOnChannelChanged() == nil {
+		t.Error("Notified client of a muted channel's change")
+	}
+}
+
+type PresenceClient struct {
+	TestClient
+	OnPresenceChangedChan chan string
+}
+
+func (p *PresenceClient) OnPresenceChanged(username string) {
+	p.OnPresenceChangedChan <- username
+}
+
+func TestPresenceChanged(t *testing.T) {
+	engine := subs.NewEngine()
+
+	// A client that doesn't show presence is never told about it
+	testClient := NewTestClient()
+	engine.Connect(testClient)
+
+	presenceClient := &PresenceClient{TestClient: *NewTestClient(), OnPresenceChangedChan: make(chan string, 10)}
+	engine.Connect(presenceClient)
+
+	engine.PresenceChanged("user1")
+	engine.ChannelChanged("channel1")
+
+	select {
+	case username := <-presenceClient.OnPresenceChangedChan:
+		if username != "user1" {
+			t.Error("Incorrect presence notification")
+		}
+	case <-time.After(25 * time.Millisecond):
+		t.Error("Timed out waiting for OnPresenceChanged")
+	}
+
+	// The other client still gets the notifications after it
+	if testClient.WaitForOnChannelChanged() != nil {
+		t.Error("Timed out waiting for OnChannelChanged")
+	}
+
+	// Ensure that the notification is numbered and kept
+	events, ok := engine.EventsSince(0)
+	if !ok || len(events) != 2 || events[0].Method != "OnPresenceChanged" || events[0].Name != "user1" {
+		t.Error("Presence notification wasn't kept")
+	}
+}
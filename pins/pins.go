@@ -0,0 +1,128 @@
+// Package pins provides the pinned messages of each channel (e.g. pinned by the automation bot once
+// a message gets enough reactions).  They're kept as model plugin data (a JSON list of the message
+// IDs, keyed by channel name), so they persist with the rest of the state.
+package pins
+
+import (
+	"chatserver/model"
+	"chatserver/model/subs"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// Namespace is the plugin data namespace the pins are stored in.
+const Namespace string = "pins"
+
+// MaxPins is the number of messages that can be pinned in a channel.
+const MaxPins int = 50
+
+// ErrTooManyPins is returned when a channel already has MaxPins pinned messages.
+var ErrTooManyPins = errors.New("too many pinned messages")
+
+// mutex serializes the changes to the pins, so messages pinned at the same time aren't lost.
+var mutex sync.Mutex
+
+// Get returns the IDs of a channel's pinned messages, in the order they were pinned (empty if it
+// has none).
+func Get(m *model.Model, channelname string) []uint64 {
+	messageIDs := make([]uint64, 0)
+
+	value, ok := m.PluginStore(Namespace).Get(channelname)
+	if !ok {
+		return messageIDs
+	}
+
+	// Pins that can't be read are dropped rather than failing, as with drafts
+	if json.Unmarshal([]byte(value), &messageIDs) != nil {
+		return make([]uint64, 0)
+	}
+
+	return messageIDs
+}
+
+// IsPinned returns whether a message is pinned in its channel.
+func IsPinned(m *model.Model, channelname string, messageID uint64) bool {
+	for _, pinnedID := range Get(m, channelname) {
+		if pinnedID == messageID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Pin pins (or, with pinned false, unpins) a message in its channel, and notifies the clients that
+// the channel changed (the engine may be nil).  Deleted messages can't be pinned.  Pinning a
+// message already pinned (or unpinning one that isn't) does nothing.
+func Pin(m *model.Model, engine *subs.Engine, channelname string, messageID uint64, pinned bool) error {
+	if pinned {
+		message, ok := m.GetMessage(channelname, m.BuiltinUsername(), messageID)
+		if !ok || message.Deleted {
+			return model.ErrMessageNotFound
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	messageIDs := Get(m, channelname)
+	index := -1
+	for i, pinnedID := range messageIDs {
+		if pinnedID == messageID {
+			index = i
+		}
+	}
+
+	if (index != -1) == pinned {
+		return nil
+	}
+
+	if pinned {
+		if len(messageIDs) >= MaxPins {
+			return ErrTooManyPins
+		}
+		messageIDs = append(messageIDs, messageID)
+	} else {
+		messageIDs = append(messageIDs[:index], messageIDs[index+1:]...)
+	}
+
+	err := put(m, channelname, messageIDs)
+	if err != nil {
+		return err
+	}
+
+	if engine != nil {
+		engine.ChannelChanged(channelname)
+	}
+
+	return nil
+}
+
+// RenameChannel moves the pins of a channel to its new name.
+func RenameChannel(m *model.Model, channelname string, newChannelname string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	store := m.PluginStore(Namespace)
+	if value, ok := store.Get(channelname); ok {
+		store.Put(newChannelname, value)
+		store.Delete(channelname)
+	}
+}
+
+// put stores a channel's pins (deleting the key once it has none).
+func put(m *model.Model, channelname string, messageIDs []uint64) error {
+	if len(messageIDs) == 0 {
+		m.PluginStore(Namespace).Delete(channelname)
+		return nil
+	}
+
+	value, err := json.Marshal(messageIDs)
+	if err != nil {
+		return err
+	}
+
+	m.PluginStore(Namespace).Put(channelname, string(value))
+	return nil
+}
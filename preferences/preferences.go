@@ -0,0 +1,60 @@
+// Package preferences provides per-user preferences, such as the screen reader friendly output of
+// telnet clients.  They're kept as model plugin data (keyed by the preference name and username),
+// so they persist with the rest of the state.
+package preferences
+
+import (
+	"chatserver/model"
+	"strings"
+)
+
+// Namespace is the plugin data namespace the preferences are stored in.
+const Namespace string = "preferences"
+
+// ScreenReader is the preference ("on" when set) for output without separators and decorations,
+// with each message read out as "message from <user> at <time>".
+const ScreenReader string = "screenreader"
+
+// Get returns the value of a user's preference (empty if it isn't set).
+func Get(m *model.Model, username string, name string) string {
+	value, _ := m.PluginStore(Namespace).Get(key(username, name))
+	return value
+}
+
+// Set sets a user's preference (an empty value unsets it).
+func Set(m *model.Model, username string, name string, value string) {
+	m.PluginStore(Namespace).Put(key(username, name), value)
+}
+
+// GetAll returns the preferences set by a user.
+func GetAll(m *model.Model, username string) map[string]string {
+	store := m.PluginStore(Namespace)
+
+	values := make(map[string]string)
+	for storeKey := range store.Keys() {
+		fields := strings.SplitN(storeKey, "/", 2)
+		if len(fields) != 2 || fields[1] != username {
+			continue
+		}
+
+		if value, ok := store.Get(storeKey); ok {
+			values[fields[0]] = value
+		}
+	}
+
+	return values
+}
+
+// Rename moves the preferences set by a user to their new username.
+func Rename(m *model.Model, username string, newUsername string) {
+	store := m.PluginStore(Namespace)
+	for name, value := range GetAll(m, username) {
+		store.Put(key(newUsername, name), value)
+		store.Delete(key(username, name))
+	}
+}
+
+// The preference names don't contain slashes, so the name comes first
+func key(username string, name string) string {
+	return name + "/" + username
+}
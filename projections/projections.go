@@ -0,0 +1,350 @@
+// Package projections provides query-optimized views of the chat server state.  Projections are
+// kept up to date from the model's actions by a dedicated goroutine instead of by the model itself,
+// so posting only queues an action and queries against a projection never contend with the model's
+// lock.  The message search index is the only projection so far: the per-user views (the channel
+// history filtered for blocked users, unread counts) are still answered by the model, as they
+// depend on the read markers, blocks and mutes it checks changes against.
+package projections
+
+import (
+	"chatserver/model/actions"
+	"time"
+)
+
+// maxPendingActions bounds the actions queued for the projections (the model waits for room
+// rather than dropping actions, since a projection must see every action to stay correct).
+const maxPendingActions int = 1000
+
+// Stream provides the Actor interface and queues each action to be applied, in order, to
+// every one of its projections on the stream's goroutine.
+type Stream struct {
+	projections []actions.Actor
+	actions     chan func()
+}
+
+// NewStream creates/initializes/returns a new Stream feeding the given projections.
+func NewStream(projections ...actions.Actor) *Stream {
+	stream := Stream{
+		projections: projections,
+		actions:     make(chan func(), maxPendingActions),
+	}
+
+	go func() {
+		for action := range stream.actions {
+			action()
+		}
+	}()
+
+	return &stream
+}
+
+// Flush waits until every action queued so far has been applied to the projections.
+func (s *Stream) Flush() {
+	done := make(chan struct{})
+	s.actions <- func() {
+		close(done)
+	}
+	<-done
+}
+
+// CreateUser queues a CreateUser action.
+func (s *Stream) CreateUser(username string) {
+	s.queue(func(projection actions.Actor) {
+		projection.CreateUser(username)
+	})
+}
+
+// CreateVirtualUser queues a CreateVirtualUser action.
+func (s *Stream) CreateVirtualUser(ownerUsername string, username string) {
+	s.queue(func(projection actions.Actor) {
+		projection.CreateVirtualUser(ownerUsername, username)
+	})
+}
+
+// DeleteUser queues a DeleteUser action.
+func (s *Stream) DeleteUser(username string) {
+	s.queue(func(projection actions.Actor) {
+		projection.DeleteUser(username)
+	})
+}
+
+// BlockUser queues a BlockUser action.
+func (s *Stream) BlockUser(username string, usernameToBlock string) {
+	s.queue(func(projection actions.Actor) {
+		projection.BlockUser(username, usernameToBlock)
+	})
+}
+
+// UnblockUser queues an UnblockUser action.
+func (s *Stream) UnblockUser(username string, usernameToUnblock string) {
+	s.queue(func(projection actions.Actor) {
+		projection.UnblockUser(username, usernameToUnblock)
+	})
+}
+
+// MuteChannel queues a MuteChannel action.
+func (s *Stream) MuteChannel(username string, channelname string) {
+	s.queue(func(projection actions.Actor) {
+		projection.MuteChannel(username, channelname)
+	})
+}
+
+// UnmuteChannel queues an UnmuteChannel action.
+func (s *Stream) UnmuteChannel(username string, channelname string) {
+	s.queue(func(projection actions.Actor) {
+		projection.UnmuteChannel(username, channelname)
+	})
+}
+
+// CreateChannel queues a CreateChannel action.
+func (s *Stream) CreateChannel(channelname string) {
+	s.queue(func(projection actions.Actor) {
+		projection.CreateChannel(channelname)
+	})
+}
+
+// DeleteChannel queues a DeleteChannel action.
+func (s *Stream) DeleteChannel(channelname string) {
+	s.queue(func(projection actions.Actor) {
+		projection.DeleteChannel(channelname)
+	})
+}
+
+// RestoreChannel queues a RestoreChannel action.
+func (s *Stream) RestoreChannel(channelname string) {
+	s.queue(func(projection actions.Actor) {
+		projection.RestoreChannel(channelname)
+	})
+}
+
+// SetChannelTopic queues a SetChannelTopic action.
+func (s *Stream) SetChannelTopic(channelname string, topic string) {
+	s.queue(func(projection actions.Actor) {
+		projection.SetChannelTopic(channelname, topic)
+	})
+}
+
+// SetChannelRules queues a SetChannelRules action.
+func (s *Stream) SetChannelRules(channelname string, language string, rules string) {
+	s.queue(func(projection actions.Actor) {
+		projection.SetChannelRules(channelname, language, rules)
+	})
+}
+
+// JoinChannel queues a JoinChannel action.
+func (s *Stream) JoinChannel(username string, channelname string) {
+	s.queue(func(projection actions.Actor) {
+		projection.JoinChannel(username, channelname)
+	})
+}
+
+// LeaveChannel queues a LeaveChannel action.
+func (s *Stream) LeaveChannel(username string, channelname string) {
+	s.queue(func(projection actions.Actor) {
+		projection.LeaveChannel(username, channelname)
+	})
+}
+
+// PostMessage queues a PostMessage action.
+func (s *Stream) PostMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	s.queue(func(projection actions.Actor) {
+		projection.PostMessage(channelname, username, timestamp, claimedTimestamp, text)
+	})
+}
+
+// PostBridgedMessage queues a PostBridgedMessage action.
+func (s *Stream) PostBridgedMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string, originSystem string, originAuthor string) {
+	s.queue(func(projection actions.Actor) {
+		projection.PostBridgedMessage(channelname, username, timestamp, claimedTimestamp, text, originSystem, originAuthor)
+	})
+}
+
+// SetUserProfile queues a SetUserProfile action.
+func (s *Stream) SetUserProfile(username string, displayName string, bio string, pronouns string) {
+	s.queue(func(projection actions.Actor) {
+		projection.SetUserProfile(username, displayName, bio, pronouns)
+	})
+}
+
+// SetUserStatus queues a SetUserStatus action.
+func (s *Stream) SetUserStatus(username string, text string, away bool) {
+	s.queue(func(projection actions.Actor) {
+		projection.SetUserStatus(username, text, away)
+	})
+}
+
+// PostSnippet queues a PostSnippet action.
+func (s *Stream) PostSnippet(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, language string, text string) {
+	s.queue(func(projection actions.Actor) {
+		projection.PostSnippet(channelname, username, timestamp, claimedTimestamp, language, text)
+	})
+}
+
+// EditMessage queues an EditMessage action.
+func (s *Stream) EditMessage(channelname string, messageID uint64, username string, timestamp time.Time, text string) {
+	s.queue(func(projection actions.Actor) {
+		projection.EditMessage(channelname, messageID, username, timestamp, text)
+	})
+}
+
+// DeleteMessage queues a DeleteMessage action.
+func (s *Stream) DeleteMessage(channelname string, messageID uint64, username string) {
+	s.queue(func(projection actions.Actor) {
+		projection.DeleteMessage(channelname, messageID, username)
+	})
+}
+
+// PostDirectMessage queues a PostDirectMessage action.
+func (s *Stream) PostDirectMessage(username string, recipient string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	s.queue(func(projection actions.Actor) {
+		projection.PostDirectMessage(username, recipient, timestamp, claimedTimestamp, text)
+	})
+}
+
+// CreateGroup queues a CreateGroup action.
+func (s *Stream) CreateGroup(username string, members []string) {
+	s.queue(func(projection actions.Actor) {
+		projection.CreateGroup(username, members)
+	})
+}
+
+// PostGroupMessage queues a PostGroupMessage action.
+func (s *Stream) PostGroupMessage(groupID uint64, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	s.queue(func(projection actions.Actor) {
+		projection.PostGroupMessage(groupID, username, timestamp, claimedTimestamp, text)
+	})
+}
+
+// PutPluginData queues a PutPluginData action.
+func (s *Stream) PutPluginData(namespace string, key string, value string) {
+	s.queue(func(projection actions.Actor) {
+		projection.PutPluginData(namespace, key, value)
+	})
+}
+
+// BeginBatch queues a BeginBatch action.
+func (s *Stream) BeginBatch() {
+	s.queue(func(projection actions.Actor) {
+		projection.BeginBatch()
+	})
+}
+
+// EndBatch queues an EndBatch action.
+func (s *Stream) EndBatch() {
+	s.queue(func(projection actions.Actor) {
+		projection.EndBatch()
+	})
+}
+
+// RestoreSnapshot queues a RestoreSnapshot action.
+func (s *Stream) RestoreSnapshot(snapshot *actions.Snapshot) {
+	s.queue(func(projection actions.Actor) {
+		projection.RestoreSnapshot(snapshot)
+	})
+}
+
+// AttachFile queues an AttachFile action.
+func (s *Stream) AttachFile(channelname string, messageID uint64, username string, attachment actions.Attachment) {
+	s.queue(func(projection actions.Actor) {
+		projection.AttachFile(channelname, messageID, username, attachment)
+	})
+}
+
+// RenameChannel queues a RenameChannel action.
+func (s *Stream) RenameChannel(channelname string, newChannelname string) {
+	s.queue(func(projection actions.Actor) {
+		projection.RenameChannel(channelname, newChannelname)
+	})
+}
+
+// RenameUser queues a RenameUser action.
+func (s *Stream) RenameUser(username string, newUsername string) {
+	s.queue(func(projection actions.Actor) {
+		projection.RenameUser(username, newUsername)
+	})
+}
+
+// CreateTeam queues a CreateTeam action.
+func (s *Stream) CreateTeam(teamname string) {
+	s.queue(func(projection actions.Actor) {
+		projection.CreateTeam(teamname)
+	})
+}
+
+// DeleteTeam queues a DeleteTeam action.
+func (s *Stream) DeleteTeam(teamname string) {
+	s.queue(func(projection actions.Actor) {
+		projection.DeleteTeam(teamname)
+	})
+}
+
+// AddTeamMember queues a AddTeamMember action.
+func (s *Stream) AddTeamMember(teamname string, username string) {
+	s.queue(func(projection actions.Actor) {
+		projection.AddTeamMember(teamname, username)
+	})
+}
+
+// RemoveTeamMember queues a RemoveTeamMember action.
+func (s *Stream) RemoveTeamMember(teamname string, username string) {
+	s.queue(func(projection actions.Actor) {
+		projection.RemoveTeamMember(teamname, username)
+	})
+}
+
+// CreateEvent queues a CreateEvent action.
+func (s *Stream) CreateEvent(channelname string, username string, start time.Time, title string) {
+	s.queue(func(projection actions.Actor) {
+		projection.CreateEvent(channelname, username, start, title)
+	})
+}
+
+// RSVPEvent queues a RSVPEvent action.
+func (s *Stream) RSVPEvent(eventID uint64, username string, going bool) {
+	s.queue(func(projection actions.Actor) {
+		projection.RSVPEvent(eventID, username, going)
+	})
+}
+
+// MarkEventReminded queues a MarkEventReminded action.
+func (s *Stream) MarkEventReminded(eventID uint64) {
+	s.queue(func(projection actions.Actor) {
+		projection.MarkEventReminded(eventID)
+	})
+}
+
+// MarkRead queues a MarkRead action.
+func (s *Stream) MarkRead(username string, channelname string, messageID uint64) {
+	s.queue(func(projection actions.Actor) {
+		projection.MarkRead(username, channelname, messageID)
+	})
+}
+
+// PostMessageMulti queues a PostMessageMulti action.
+func (s *Stream) PostMessageMulti(channelnames []string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	s.queue(func(projection actions.Actor) {
+		projection.PostMessageMulti(channelnames, username, timestamp, claimedTimestamp, text)
+	})
+}
+
+// StarMessage queues a StarMessage action.
+func (s *Stream) StarMessage(username string, channelname string, messageID uint64, starred bool) {
+	s.queue(func(projection actions.Actor) {
+		projection.StarMessage(username, channelname, messageID, starred)
+	})
+}
+
+// SetChannelNotes queues a SetChannelNotes action.
+func (s *Stream) SetChannelNotes(channelname string, username string, timestamp time.Time, text string) {
+	s.queue(func(projection actions.Actor) {
+		projection.SetChannelNotes(channelname, username, timestamp, text)
+	})
+}
+
+func (s *Stream) queue(action func(projection actions.Actor)) {
+	s.actions <- func() {
+		for _, projection := range s.projections {
+			action(projection)
+		}
+	}
+}
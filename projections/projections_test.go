@@ -0,0 +1,61 @@
+package projections_test
+
+import (
+	"chatserver/projections"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestProjection records the channels and messages it's given, in order (the embedded search index
+// provides the rest of the Actor interface).
+type TestProjection struct {
+	*projections.SearchIndex
+	applied []string
+}
+
+func NewTestProjection() *TestProjection {
+	return &TestProjection{SearchIndex: projections.NewSearchIndex(), applied: make([]string, 0)}
+}
+
+func (t *TestProjection) CreateChannel(channelname string) {
+	t.applied = append(t.applied, "channel "+channelname)
+}
+
+func (t *TestProjection) PostMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	t.applied = append(t.applied, "message "+text)
+}
+
+func TestStream(t *testing.T) {
+	testProjections := []*TestProjection{NewTestProjection(), NewTestProjection()}
+	searchIndex := projections.NewSearchIndex()
+	stream := projections.NewStream(testProjections[0], testProjections[1], searchIndex)
+
+	expected := []string{"channel channel1"}
+	stream.CreateChannel("channel1")
+	for i := 0; i < 2000; i++ {
+		expected = append(expected, "message word"+strconv.Itoa(i))
+		stream.PostMessage("channel1", "user1", time.Now(), time.Time{}, "word"+strconv.Itoa(i))
+	}
+
+	// Ensure that every projection has been given every action, in order, once flushed
+	stream.Flush()
+	for _, testProjection := range testProjections {
+		if len(testProjection.applied) != len(expected) {
+			t.Error("Failed to apply the actions")
+			continue
+		}
+
+		for i := range expected {
+			if testProjection.applied[i] != expected[i] {
+				t.Error("Failed to apply the actions in order")
+				break
+			}
+		}
+	}
+
+	messages := searchIndex.Search("channel1", "user1", "word1999", -1)
+	if len(messages) != 1 || messages[0].Text != "word1999" {
+		t.Error("Failed to update the search index")
+	}
+}
@@ -0,0 +1,589 @@
+package projections
+
+import (
+	"chatserver/model"
+	"chatserver/model/actions"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// SearchIndex is a projection providing word search of the channel histories.  It satisfies the
+// actions Actor interface, and results are filtered for the searching user's blocked users (the
+// same as the channel history).
+type SearchIndex struct {
+	channels      map[string]*indexedChannel
+	deleted       map[string]*indexedChannel
+	blocked       map[string]map[string]struct{}
+	owners        map[string]string
+	lastMessageID uint64
+	mutex         sync.RWMutex
+}
+
+type indexedChannel struct {
+	messages []model.Message
+	words    map[string][]int
+}
+
+// NewSearchIndex creates/initializes/returns a new (empty) SearchIndex.
+func NewSearchIndex() *SearchIndex {
+	searchIndex := SearchIndex{
+		channels: make(map[string]*indexedChannel),
+		deleted:  make(map[string]*indexedChannel),
+		blocked:  make(map[string]map[string]struct{}),
+		owners:   make(map[string]string),
+	}
+
+	return &searchIndex
+}
+
+// Search returns the most recent messages (up to a number of messages, or all of them when
+// numMessages is -1) in a channel that contain every word of the query, oldest first.
+func (s *SearchIndex) Search(channelname string, username string, query string, numMessages int) []model.Message {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	messages := make([]model.Message, 0)
+
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return messages
+	}
+
+	queryWords := splitWords(query)
+	if len(queryWords) == 0 {
+		return messages
+	}
+
+	// Start from the shortest list of matching messages and check it against the other words
+	candidates := channel.words[queryWords[0]]
+	for _, word := range queryWords[1:] {
+		if len(channel.words[word]) < len(candidates) {
+			candidates = channel.words[word]
+		}
+	}
+
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if numMessages != -1 && len(messages) >= numMessages {
+			break
+		}
+
+		message := channel.messages[candidates[i]]
+		if _, ok := s.blocked[username][message.Username]; ok {
+			continue
+		}
+
+		if channel.containsWords(candidates[i], queryWords) {
+			messages = append(messages, message)
+		}
+	}
+
+	// Order the results oldest first (like the channel history)
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages
+}
+
+// CreateUser has no effect on the search index.
+func (s *SearchIndex) CreateUser(username string) {
+}
+
+// CreateVirtualUser records the owner of a virtual user (so it's removed along with its owner).
+func (s *SearchIndex) CreateVirtualUser(ownerUsername string, username string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.owners[username] = ownerUsername
+}
+
+// DeleteUser removes the user (and the virtual users it owns) from the blocked users.
+func (s *SearchIndex) DeleteUser(username string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deletedUsernames := []string{username}
+	for ownedUsername, ownerUsername := range s.owners {
+		if ownerUsername == username {
+			deletedUsernames = append(deletedUsernames, ownedUsername)
+		}
+	}
+
+	for _, deletedUsername := range deletedUsernames {
+		delete(s.owners, deletedUsername)
+		delete(s.blocked, deletedUsername)
+		for _, blockedUsers := range s.blocked {
+			delete(blockedUsers, deletedUsername)
+		}
+	}
+}
+
+// BlockUser hides the blocked user's messages from the user's results.
+func (s *SearchIndex) BlockUser(username string, usernameToBlock string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.blocked[username]; !ok {
+		s.blocked[username] = make(map[string]struct{})
+	}
+	s.blocked[username][usernameToBlock] = struct{}{}
+}
+
+// UnblockUser shows the unblocked user's messages in the user's results again.
+func (s *SearchIndex) UnblockUser(username string, usernameToUnblock string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.blocked[username], usernameToUnblock)
+}
+
+// MuteChannel has no effect on the search index.
+func (s *SearchIndex) MuteChannel(username string, channelname string) {
+}
+
+// UnmuteChannel has no effect on the search index.
+func (s *SearchIndex) UnmuteChannel(username string, channelname string) {
+}
+
+// CreateChannel adds an empty channel to the search index.
+func (s *SearchIndex) CreateChannel(channelname string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// A deleted channel can't be restored once its name is reused
+	delete(s.deleted, channelname)
+	s.channel(channelname)
+}
+
+// DeleteChannel removes a channel (and its messages) from the search index, keeping it aside in
+// case it's restored.
+func (s *SearchIndex) DeleteChannel(channelname string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if channel, ok := s.channels[channelname]; ok {
+		s.deleted[channelname] = channel
+		delete(s.channels, channelname)
+	}
+}
+
+// RestoreChannel puts a deleted channel (and its messages) back in the search index.
+func (s *SearchIndex) RestoreChannel(channelname string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	channel, ok := s.deleted[channelname]
+	if !ok {
+		return
+	}
+
+	if _, ok := s.channels[channelname]; !ok {
+		delete(s.deleted, channelname)
+		s.channels[channelname] = channel
+	}
+}
+
+// SetChannelTopic has no effect on the search index.
+func (s *SearchIndex) SetChannelTopic(channelname string, topic string) {
+}
+
+// SetChannelRules has no effect on the search index.
+func (s *SearchIndex) SetChannelRules(channelname string, language string, rules string) {
+}
+
+// JoinChannel has no effect on the search index.
+func (s *SearchIndex) JoinChannel(username string, channelname string) {
+}
+
+// LeaveChannel has no effect on the search index.
+func (s *SearchIndex) LeaveChannel(username string, channelname string) {
+}
+
+// SetUserProfile has no effect on the search index.
+func (s *SearchIndex) SetUserProfile(username string, displayName string, bio string, pronouns string) {
+}
+
+// SetUserStatus has no effect on the search index.
+func (s *SearchIndex) SetUserStatus(username string, text string, away bool) {
+}
+
+// PostMessage indexes a message (numbered the same as the model numbers the posted messages).
+func (s *SearchIndex) PostMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastMessageID++
+	s.channel(channelname).add(model.Message{ID: s.lastMessageID, Username: username, Timestamp: timestamp, Text: text})
+}
+
+// PostBridgedMessage indexes a bridged message.
+func (s *SearchIndex) PostBridgedMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string, originSystem string, originAuthor string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	origin := model.Origin{System: originSystem, Author: originAuthor}
+	s.lastMessageID++
+	s.channel(channelname).add(model.Message{ID: s.lastMessageID, Username: username, Timestamp: timestamp, Text: text, Origin: origin})
+}
+
+// PostMessageMulti indexes each copy of a cross-posted message.
+func (s *SearchIndex) PostMessageMulti(channelnames []string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	crossPost := s.lastMessageID + 1
+	for _, channelname := range channelnames {
+		s.lastMessageID++
+		s.channel(channelname).add(model.Message{ID: s.lastMessageID, Username: username, Timestamp: timestamp, Text: text, CrossPost: crossPost})
+	}
+}
+
+// PostSnippet indexes a snippet (searched by its code, like any other message's text).
+func (s *SearchIndex) PostSnippet(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, language string, text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastMessageID++
+	s.channel(channelname).add(model.Message{ID: s.lastMessageID, Username: username, Timestamp: timestamp, Text: text, SnippetLanguage: language})
+}
+
+// EditMessage reindexes an edited message under its new text.
+func (s *SearchIndex) EditMessage(channelname string, messageID uint64, username string, timestamp time.Time, text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return
+	}
+
+	messageIndex := channel.find(messageID)
+	if messageIndex == -1 {
+		return
+	}
+
+	for copyChannel, copyIndex := range s.copies(channel, messageIndex) {
+		copyChannel.replace(copyIndex, text)
+		copyChannel.messages[copyIndex].Edited = timestamp
+	}
+}
+
+// DeleteMessage removes a deleted message's words from the search index.
+func (s *SearchIndex) DeleteMessage(channelname string, messageID uint64, username string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return
+	}
+
+	messageIndex := channel.find(messageID)
+	if messageIndex == -1 {
+		return
+	}
+
+	for copyChannel, copyIndex := range s.copies(channel, messageIndex) {
+		copyChannel.replace(copyIndex, "")
+		copyChannel.messages[copyIndex].Deleted = true
+	}
+}
+
+// PostDirectMessage only takes up a message ID (direct messages are private to the users in the
+// conversation, so they aren't searched).
+func (s *SearchIndex) PostDirectMessage(username string, recipient string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastMessageID++
+}
+
+// CreateGroup has no effect on the search index.
+func (s *SearchIndex) CreateGroup(username string, members []string) {
+}
+
+// PostGroupMessage only takes up a message ID (group messages are private to the members, so they
+// aren't searched).
+func (s *SearchIndex) PostGroupMessage(groupID uint64, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastMessageID++
+}
+
+// PutPluginData has no effect on the search index.
+func (s *SearchIndex) PutPluginData(namespace string, key string, value string) {
+}
+
+// AttachFile has no effect on the search index (only the text of the messages is searched).
+func (s *SearchIndex) AttachFile(channelname string, messageID uint64, username string, attachment actions.Attachment) {
+}
+
+// RenameChannel moves a channel (and its messages) to its new name in the search index.
+func (s *SearchIndex) RenameChannel(channelname string, newChannelname string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	channel, ok := s.channels[channelname]
+	if !ok {
+		return
+	}
+
+	// A deleted channel can't be restored once its name is reused
+	delete(s.deleted, newChannelname)
+	delete(s.channels, channelname)
+	s.channels[newChannelname] = channel
+}
+
+// RenameUser renames a user's messages, blocks and virtual users in the search index.
+func (s *SearchIndex) RenameUser(username string, newUsername string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ownerUsername, ok := s.owners[username]; ok {
+		delete(s.owners, username)
+		s.owners[newUsername] = ownerUsername
+	}
+	for ownedUsername, ownerUsername := range s.owners {
+		if ownerUsername == username {
+			s.owners[ownedUsername] = newUsername
+		}
+	}
+
+	if blockedUsers, ok := s.blocked[username]; ok {
+		delete(s.blocked, username)
+		s.blocked[newUsername] = blockedUsers
+	}
+	for _, blockedUsers := range s.blocked {
+		if _, ok := blockedUsers[username]; ok {
+			delete(blockedUsers, username)
+			blockedUsers[newUsername] = struct{}{}
+		}
+	}
+
+	for _, channels := range []map[string]*indexedChannel{s.channels, s.deleted} {
+		for _, channel := range channels {
+			for i := range channel.messages {
+				if channel.messages[i].Username == username {
+					channel.messages[i].Username = newUsername
+				}
+			}
+		}
+	}
+}
+
+// CreateTeam has no effect on the search index.
+func (s *SearchIndex) CreateTeam(teamname string) {
+}
+
+// DeleteTeam has no effect on the search index.
+func (s *SearchIndex) DeleteTeam(teamname string) {
+}
+
+// AddTeamMember has no effect on the search index.
+func (s *SearchIndex) AddTeamMember(teamname string, username string) {
+}
+
+// RemoveTeamMember has no effect on the search index.
+func (s *SearchIndex) RemoveTeamMember(teamname string, username string) {
+}
+
+// CreateEvent has no effect on the search index.
+func (s *SearchIndex) CreateEvent(channelname string, username string, start time.Time, title string) {
+}
+
+// RSVPEvent has no effect on the search index.
+func (s *SearchIndex) RSVPEvent(eventID uint64, username string, going bool) {
+}
+
+// MarkEventReminded has no effect on the search index.
+func (s *SearchIndex) MarkEventReminded(eventID uint64) {
+}
+
+// MarkRead has no effect on the search index.
+func (s *SearchIndex) MarkRead(username string, channelname string, messageID uint64) {
+}
+
+// StarMessage has no effect on the search index.
+func (s *SearchIndex) StarMessage(username string, channelname string, messageID uint64, starred bool) {
+}
+
+// SetChannelNotes has no effect on the search index.
+func (s *SearchIndex) SetChannelNotes(channelname string, username string, timestamp time.Time, text string) {
+}
+
+// BeginBatch has no effect on the search index (a batch's actions are applied one by one).
+func (s *SearchIndex) BeginBatch() {
+}
+
+// EndBatch has no effect on the search index.
+func (s *SearchIndex) EndBatch() {
+}
+
+// RestoreSnapshot rebuilds the search index from a snapshot (a compacted log starts with one).
+func (s *SearchIndex) RestoreSnapshot(snapshot *actions.Snapshot) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.channels = make(map[string]*indexedChannel)
+	s.deleted = make(map[string]*indexedChannel)
+	s.blocked = make(map[string]map[string]struct{})
+	s.owners = make(map[string]string)
+	s.lastMessageID = snapshot.LastMessageID
+
+	for _, user := range snapshot.Users {
+		if user.Owner != "" {
+			s.owners[user.Name] = user.Owner
+		}
+
+		for _, blockedUsername := range user.BlockedUsers {
+			if _, ok := s.blocked[user.Name]; !ok {
+				s.blocked[user.Name] = make(map[string]struct{})
+			}
+			s.blocked[user.Name][blockedUsername] = struct{}{}
+		}
+	}
+
+	for _, snapshotChannel := range snapshot.Channels {
+		s.channels[snapshotChannel.Name] = newIndexedChannel(snapshotChannel.Messages)
+	}
+
+	// The deleted channels are kept aside in case they're restored
+	for _, snapshotDeleted := range snapshot.DeletedChannels {
+		s.deleted[snapshotDeleted.Channel.Name] = newIndexedChannel(snapshotDeleted.Channel.Messages)
+	}
+}
+
+// copies returns the indices of a message's copies by their channels: every copy of a cross-posted
+// message (which the model edits and deletes together), or else just the message itself.
+func (s *SearchIndex) copies(channel *indexedChannel, messageIndex int) map[*indexedChannel]int {
+	crossPost := channel.messages[messageIndex].CrossPost
+	if crossPost == 0 {
+		return map[*indexedChannel]int{channel: messageIndex}
+	}
+
+	copies := make(map[*indexedChannel]int)
+	for _, otherChannel := range s.channels {
+		otherIndex := sort.Search(len(otherChannel.messages), func(i int) bool { return otherChannel.messages[i].ID >= crossPost })
+		if otherIndex < len(otherChannel.messages) && otherChannel.messages[otherIndex].CrossPost == crossPost {
+			copies[otherChannel] = otherIndex
+		}
+	}
+
+	return copies
+}
+
+func (s *SearchIndex) channel(channelname string) *indexedChannel {
+	channel, ok := s.channels[channelname]
+	if !ok {
+		channel = &indexedChannel{
+			messages: make([]model.Message, 0),
+			words:    make(map[string][]int),
+		}
+		s.channels[channelname] = channel
+	}
+
+	return channel
+}
+
+// newIndexedChannel indexes the messages of a channel from a snapshot.
+func newIndexedChannel(snapshotMessages []actions.SnapshotMessage) *indexedChannel {
+	channel := indexedChannel{
+		messages: make([]model.Message, 0, len(snapshotMessages)),
+		words:    make(map[string][]int),
+	}
+
+	for _, message := range snapshotMessages {
+		channel.add(model.Message{
+			ID:        message.ID,
+			Username:  message.Username,
+			Timestamp: message.Timestamp,
+			Edited:    message.Edited,
+			Deleted:   message.Deleted,
+			Text:      message.Text,
+			Origin:    model.Origin{System: message.OriginSystem, Author: message.OriginAuthor},
+			CrossPost: message.CrossPost,
+		})
+	}
+
+	return &channel
+}
+
+func (c *indexedChannel) add(message model.Message) {
+	messageIndex := len(c.messages)
+	message.Seq = uint64(messageIndex) + 1
+	c.messages = append(c.messages, message)
+
+	for _, word := range splitWords(message.Text) {
+		c.words[word] = append(c.words[word], messageIndex)
+	}
+}
+
+func (c *indexedChannel) find(messageID uint64) int {
+	// Messages are indexed in ID order
+	messageIndex := sort.Search(len(c.messages), func(i int) bool { return c.messages[i].ID >= messageID })
+	if messageIndex == len(c.messages) || c.messages[messageIndex].ID != messageID {
+		return -1
+	}
+
+	return messageIndex
+}
+
+func (c *indexedChannel) replace(messageIndex int, text string) {
+	for _, word := range splitWords(c.messages[messageIndex].Text) {
+		messageIndices := c.words[word]
+		i := sort.SearchInts(messageIndices, messageIndex)
+		if i < len(messageIndices) && messageIndices[i] == messageIndex {
+			c.words[word] = append(messageIndices[:i], messageIndices[i+1:]...)
+		}
+
+		if len(c.words[word]) == 0 {
+			delete(c.words, word)
+		}
+	}
+
+	c.messages[messageIndex].Text = text
+
+	// Keep each word's message indexes sorted
+	for _, word := range splitWords(text) {
+		messageIndices := c.words[word]
+		i := sort.SearchInts(messageIndices, messageIndex)
+		messageIndices = append(messageIndices, 0)
+		copy(messageIndices[i+1:], messageIndices[i:])
+		messageIndices[i] = messageIndex
+		c.words[word] = messageIndices
+	}
+}
+
+func (c *indexedChannel) containsWords(messageIndex int, words []string) bool {
+	for _, word := range words {
+		messageIndices := c.words[word]
+		i := sort.SearchInts(messageIndices, messageIndex)
+		if i == len(messageIndices) || messageIndices[i] != messageIndex {
+			return false
+		}
+	}
+
+	return true
+}
+
+// splitWords returns the distinct lowercase words (runs of letters and digits) in a text.
+func splitWords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	words := make([]string, 0, len(fields))
+	seen := make(map[string]struct{})
+	for _, field := range fields {
+		if _, ok := seen[field]; !ok {
+			seen[field] = struct{}{}
+			words = append(words, field)
+		}
+	}
+
+	return words
+}
@@ -0,0 +1,179 @@
+// Package reactions provides emoji reactions to channel messages.  They're kept as model plugin
+// data (a JSON object of the reacting users by emoji, keyed by the channel and message ID), so they
+// persist with the rest of the state.
+package reactions
+
+import (
+	"chatserver/model"
+	"chatserver/model/subs"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Namespace is the plugin data namespace the reactions are stored in.
+const Namespace string = "reactions"
+
+// MaxLength is the longest reaction (in characters), e.g. an emoji or a :shortcode:.
+const MaxLength int = 32
+
+// ErrInvalidReaction is returned when a reaction is empty, too long or has spaces.
+var ErrInvalidReaction = errors.New("invalid reaction")
+
+// mutex serializes the changes to the reactions, so reactions to the same message made at the same
+// time aren't lost.
+var mutex sync.Mutex
+
+// Get returns the reactions to a message, the users that reacted (sorted) by reaction (empty if
+// there are none).
+func Get(m *model.Model, channelname string, messageID uint64) map[string][]string {
+	reactions := make(map[string][]string)
+
+	value, ok := m.PluginStore(Namespace).Get(key(channelname, messageID))
+	if !ok {
+		return reactions
+	}
+
+	// Reactions that can't be read are dropped rather than failing, as with drafts
+	if json.Unmarshal([]byte(value), &reactions) != nil {
+		return make(map[string][]string)
+	}
+
+	return reactions
+}
+
+// Count returns the number of reactions to a message (each user's reactions counting separately).
+func Count(m *model.Model, channelname string, messageID uint64) int {
+	count := 0
+	for _, usernames := range Get(m, channelname, messageID) {
+		count += len(usernames)
+	}
+
+	return count
+}
+
+// React adds (or, with add false, removes) a user's reaction to a message, and notifies the
+// clients that the message changed (the engine may be nil).  The user must be able to see the
+// message, and deleted messages can't be reacted to.  Adding a reaction the user already made (or
+// removing one they didn't) does nothing.
+func React(m *model.Model, engine *subs.Engine, username string, channelname string, messageID uint64, reaction string, add bool) error {
+	if reaction == "" || utf8.RuneCountInString(reaction) > MaxLength || strings.ContainsAny(reaction, " \t\r\n") {
+		return ErrInvalidReaction
+	}
+
+	message, ok := m.GetMessage(channelname, username, messageID)
+	if !ok || message.Deleted {
+		return model.ErrMessageNotFound
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	reactions := Get(m, channelname, messageID)
+	usernames := reactions[reaction]
+	index := sort.SearchStrings(usernames, username)
+	reacted := index < len(usernames) && usernames[index] == username
+	if reacted == add {
+		return nil
+	}
+
+	if add {
+		usernames = append(usernames, "")
+		copy(usernames[index+1:], usernames[index:])
+		usernames[index] = username
+		reactions[reaction] = usernames
+	} else if len(usernames) == 1 {
+		delete(reactions, reaction)
+	} else {
+		reactions[reaction] = append(usernames[:index], usernames[index+1:]...)
+	}
+
+	err := put(m, key(channelname, messageID), reactions)
+	if err != nil {
+		return err
+	}
+
+	if engine != nil {
+		engine.MessageChanged(channelname, messageID)
+	}
+
+	return nil
+}
+
+// Rename replaces a user's username in their reactions.
+func Rename(m *model.Model, username string, newUsername string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for messageKey := range m.PluginStore(Namespace).Keys() {
+		channelname, messageID := splitKey(messageKey)
+		reactions := Get(m, channelname, messageID)
+
+		renamed := false
+		for reaction, usernames := range reactions {
+			for i := range usernames {
+				if usernames[i] == username {
+					usernames[i] = newUsername
+					renamed = true
+				}
+			}
+			sort.Strings(usernames)
+			reactions[reaction] = usernames
+		}
+
+		if renamed {
+			put(m, messageKey, reactions)
+		}
+	}
+}
+
+// RenameChannel moves the reactions to the messages of a channel to its new name.
+func RenameChannel(m *model.Model, channelname string, newChannelname string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	store := m.PluginStore(Namespace)
+	for messageKey := range store.Keys() {
+		if keyChannelname, messageID := splitKey(messageKey); keyChannelname == channelname {
+			value, _ := store.Get(messageKey)
+			store.Put(key(newChannelname, messageID), value)
+			store.Delete(messageKey)
+		}
+	}
+}
+
+// key returns the key a message's reactions are stored under (channel names can't contain spaces).
+func key(channelname string, messageID uint64) string {
+	return channelname + " " + strconv.FormatUint(messageID, 10)
+}
+
+// splitKey returns the channel name and message ID of a key.
+func splitKey(messageKey string) (string, uint64) {
+	index := strings.LastIndex(messageKey, " ")
+	if index == -1 {
+		return "", 0
+	}
+
+	messageID, _ := strconv.ParseUint(messageKey[index+1:], 10, 64)
+	return messageKey[:index], messageID
+}
+
+// put stores the reactions to a message (deleting the key once there are none).
+func put(m *model.Model, messageKey string, reactions map[string][]string) error {
+	if len(reactions) == 0 {
+		m.PluginStore(Namespace).Delete(messageKey)
+		return nil
+	}
+
+	value, err := json.Marshal(reactions)
+	if err != nil {
+		return err
+	}
+
+	m.PluginStore(Namespace).Put(messageKey, string(value))
+	return nil
+}
@@ -0,0 +1,77 @@
+// Package security provides per-user security events (e.g. a login from a new address, or the
+// password being changed), so users find out about changes to their accounts they didn't make.
+// They're kept as model plugin data (a JSON list of the user's most recent events, keyed by
+// username), so they persist with the rest of the state, and the user's clients are notified of
+// each one.
+package security
+
+import (
+	"chatserver/model"
+	"chatserver/model/subs"
+	"encoding/json"
+	"time"
+)
+
+// Namespace is the plugin data namespace the events are stored in.
+const Namespace string = "security"
+
+// MaxEvents is the number of a user's most recent events kept.
+const MaxEvents int = 50
+
+// Event is a single security event on a user's account.
+type Event struct {
+	Timestamp time.Time
+	Text      string
+}
+
+// Get returns a user's security events, oldest first (empty if they have none).
+func Get(m *model.Model, username string) []Event {
+	events := make([]Event, 0)
+
+	value, ok := m.PluginStore(Namespace).Get(username)
+	if !ok {
+		return events
+	}
+
+	// Events that can't be read are dropped rather than failing, as with drafts
+	if json.Unmarshal([]byte(value), &events) != nil {
+		return make([]Event, 0)
+	}
+
+	return events
+}
+
+// Record adds a security event to a user's account and notifies their clients (the engine may be
+// nil).  The user must exist.
+func Record(m *model.Model, engine *subs.Engine, username string, text string) error {
+	if m.GetUserInfo(username).Name == "" {
+		return model.ErrUserNotFound
+	}
+
+	events := append(Get(m, username), Event{Timestamp: m.Now(), Text: text})
+	if len(events) > MaxEvents {
+		events = events[len(events)-MaxEvents:]
+	}
+
+	value, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	m.PluginStore(Namespace).Put(username, string(value))
+
+	if engine != nil {
+		engine.SecurityEvent(username)
+	}
+
+	return nil
+}
+
+// Rename moves the security events of a user to their new username.
+func Rename(m *model.Model, username string, newUsername string) {
+	store := m.PluginStore(Namespace)
+	if value, ok := store.Get(username); ok {
+		store.Put(newUsername, value)
+		store.Delete(username)
+	}
+}
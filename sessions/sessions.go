@@ -0,0 +1,135 @@
+// Package sessions provides an in-memory store of web client sessions.  A session remembers
+// what a web client was doing (its current user and channel, and the sequence number of the
+// last subscription update it saw) so that it can be resumed after the websocket drops or the
+// page is reloaded.  Sessions that haven't been used for a while expire.
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Session contains the state of a single web client session.
+type Session struct {
+	ID          string
+	Username    string
+	Channelname string
+	LastSeq     uint64
+	LastUsed    time.Time
+}
+
+// Store provides the session store functionality.
+type Store struct {
+	sessions map[string]*Session
+	ttl      time.Duration
+	clock    func() time.Time
+	mutex    sync.Mutex
+}
+
+// NewStore creates/initializes/returns a new Store whose sessions expire after being unused
+// for the ttl, as told by the clock (nil for time.Now).
+func NewStore(ttl time.Duration, clock func() time.Time) *Store {
+	if clock == nil {
+		clock = time.Now
+	}
+
+	store := Store{
+		sessions: make(map[string]*Session),
+		ttl:      ttl,
+		clock:    clock,
+	}
+
+	return &store
+}
+
+// Create starts a new session and returns it.
+func (s *Store) Create(username string, channelname string, lastSeq uint64) Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire()
+
+	session := Session{
+		ID:          newID(),
+		Username:    username,
+		Channelname: channelname,
+		LastSeq:     lastSeq,
+		LastUsed:    s.clock(),
+	}
+	s.sessions[session.ID] = &session
+
+	return session
+}
+
+// Update records the current state of a session.  It returns false if the session doesn't
+// exist (or has expired).  The last seq never moves backwards.
+func (s *Store) Update(id string, username string, channelname string, lastSeq uint64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.get(id)
+	if !ok {
+		return false
+	}
+
+	session.Username = username
+	session.Channelname = channelname
+	if lastSeq > session.LastSeq {
+		session.LastSeq = lastSeq
+	}
+	session.LastUsed = s.clock()
+
+	return true
+}
+
+// Get returns a session (keeping it alive), with the time it was last used before.  It returns
+// false if the session doesn't exist (or has expired).
+func (s *Store) Get(id string) (Session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.get(id)
+	if !ok {
+		return Session{}, false
+	}
+
+	found := *session
+	session.LastUsed = s.clock()
+
+	return found, true
+}
+
+func (s *Store) get(id string) (*Session, bool) {
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, false
+	}
+
+	if s.clock().Sub(session.LastUsed) > s.ttl {
+		delete(s.sessions, id)
+		return nil, false
+	}
+
+	return session, true
+}
+
+func (s *Store) expire() {
+	now := s.clock()
+	for id, session := range s.sessions {
+		if now.Sub(session.LastUsed) > s.ttl {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func newID() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,36 @@
+package sessions_test
+
+import (
+	"chatserver/sessions"
+	"testing"
+	"time"
+)
+
+func TestSessionExpiry(t *testing.T) {
+	now := time.Now()
+	testStore := sessions.NewStore(time.Minute, func() time.Time { return now })
+
+	session := testStore.Create("user1", "channel1", 1)
+
+	// Ensure that a session is kept alive by using it
+	now = now.Add(50 * time.Second)
+	found, ok := testStore.Get(session.ID)
+	if !ok || found.Username != "user1" || !found.LastUsed.Equal(session.LastUsed) {
+		t.Error("Failed to get session")
+	}
+
+	now = now.Add(50 * time.Second)
+	if !testStore.Update(session.ID, "user2", "channel2", 2) {
+		t.Error("Failed to update session kept alive")
+	}
+
+	// Ensure that a session expires once it's been unused for the ttl
+	now = now.Add(61 * time.Second)
+	if _, ok := testStore.Get(session.ID); ok {
+		t.Error("Failed to expire session")
+	}
+
+	if testStore.Update(session.ID, "user2", "channel2", 3) {
+		t.Error("Updated expired session")
+	}
+}
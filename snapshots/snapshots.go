@@ -0,0 +1,54 @@
+// Package snapshots saves snapshots of the server state to object storage, and restores the latest
+// one when the server starts without an actions log, so the state outlives a server running in a
+// stateless container (up to the changes made since the last snapshot was saved).
+package snapshots
+
+import (
+	"chatserver/model"
+	"chatserver/model/actions"
+	"chatserver/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Key is the key of the latest snapshot in the store.
+const Key string = "snapshots/latest.json"
+
+// Save saves a snapshot of the current state of a model to a store, replacing the previous one.
+// The snapshot is a compacted actions log (see actions.EncodeSnapshot).
+func Save(m *model.Model, store storage.Store) error {
+	snapshotLog, err := actions.EncodeSnapshot(m.Snapshot())
+	if err != nil {
+		return err
+	}
+
+	return store.Put(Key, snapshotLog)
+}
+
+// Restore writes the latest snapshot in a store to the actions log file, to be replayed, unless the
+// log file already exists (or there's no snapshot).  It returns whether the snapshot was restored.
+func Restore(store storage.Store, logFilePath string) (bool, error) {
+	if _, err := os.Stat(logFilePath); !os.IsNotExist(err) {
+		return false, err
+	}
+
+	snapshotLog, err := store.Get(Key)
+	if err == storage.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(logFilePath), os.ModePerm)
+	if err != nil {
+		return false, err
+	}
+
+	err = ioutil.WriteFile(logFilePath, snapshotLog, 0644)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,76 @@
+package snapshots_test
+
+import (
+	"chatserver/model"
+	"chatserver/model/actions"
+	"chatserver/snapshots"
+	"chatserver/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveAndRestore(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	store := storage.NewLocalStore(filepath.Join(tempDir, "store"))
+	logFilePath := filepath.Join(tempDir, "log", "log.txt")
+
+	// Nothing is restored without a snapshot
+	restored, err := snapshots.Restore(store, logFilePath)
+	if err != nil || restored {
+		t.Error("Restored a snapshot that wasn't saved")
+	}
+
+	liveModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create model")
+	}
+
+	liveModel.CreateUser("user1")
+	liveModel.CreateChannel("channel1")
+	liveModel.JoinChannel("user1", "channel1")
+	liveModel.PostMessage("channel1", "user1", time.Time{}, "message1")
+
+	if snapshots.Save(liveModel, store) != nil {
+		t.Fatal("Failed to save snapshot")
+	}
+
+	restored, err = snapshots.Restore(store, logFilePath)
+	if err != nil || !restored {
+		t.Fatal("Failed to restore snapshot")
+	}
+
+	// The restored log replays to the saved state
+	replayer, err := actions.NewReplayer(logFilePath)
+	if err != nil {
+		t.Fatal("Failed to create Replayer")
+	}
+
+	restoredModel, err := model.NewModel(model.Options{}, replayer, nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create model")
+	}
+
+	if _, ok := restoredModel.GetChannelMembers("channel1")["user1"]; !ok {
+		t.Error("Restored model is missing the channel members")
+	}
+
+	history := restoredModel.GetChannelHistory("channel1", "user1", -1)
+	if len(history) != 1 || history[0].Text != "message1" {
+		t.Error("Restored model is missing the channel history")
+	}
+
+	// An existing log is never overwritten
+	restored, err = snapshots.Restore(store, logFilePath)
+	if err != nil || restored {
+		t.Error("Restored a snapshot over an existing log")
+	}
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Timeout is the longest a request to the object store can take.
+const s3Timeout time.Duration = 60 * time.Second
+
+// S3Store provides a Store on an S3-compatible object store (AWS S3, MinIO, etc.), with the
+// requests signed with AWS Signature Version 4.
+type S3Store struct {
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	prefix          string
+	accessKeyID     string
+	secretAccessKey string
+	client          *http.Client
+	now             func() time.Time
+}
+
+// NewS3Store creates/initializes/returns a new S3Store for the endpoint, bucket, etc. in the
+// options.
+func NewS3Store(options Options) (*S3Store, error) {
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, errors.New("invalid storage endpoint")
+	}
+
+	if options.Bucket == "" || strings.Contains(options.Bucket, "/") {
+		return nil, errors.New("invalid storage bucket")
+	}
+
+	if options.AccessKeyID == "" || options.SecretAccessKey == "" {
+		return nil, errors.New("invalid storage credentials")
+	}
+
+	if options.Region == "" {
+		options.Region = "us-east-1"
+	}
+
+	store := S3Store{
+		endpoint:        endpoint,
+		region:          options.Region,
+		bucket:          options.Bucket,
+		prefix:          options.Prefix,
+		accessKeyID:     options.AccessKeyID,
+		secretAccessKey: options.SecretAccessKey,
+		client:          &http.Client{Timeout: s3Timeout},
+		now:             time.Now,
+	}
+
+	return &store, nil
+}
+
+// Put creates or replaces the object for a key.
+func (s *S3Store) Put(key string, data []byte) error {
+	response, err := s.do(http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	return checkResponse(response)
+}
+
+// Get returns the object for a key.
+func (s *S3Store) Get(key string) ([]byte, error) {
+	response, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	err = checkResponse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+// Stat returns the details of the object for a key.
+func (s *S3Store) Stat(key string) (Object, error) {
+	response, err := s.do(http.MethodHead, key, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return Object{}, ErrNotFound
+	}
+
+	err = checkResponse(response)
+	if err != nil {
+		return Object{}, err
+	}
+
+	modified, _ := http.ParseTime(response.Header.Get("Last-Modified"))
+	return Object{Key: key, Size: response.ContentLength, Modified: modified}, nil
+}
+
+// Delete deletes the object for a key.
+func (s *S3Store) Delete(key string) error {
+	response, err := s.do(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	return checkResponse(response)
+}
+
+// listBucketResult is the response to a ListObjectsV2 request.
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List returns the details of the objects with keys starting with a prefix, requesting a page of
+// them at a time.
+func (s *S3Store) List(prefix string) ([]Object, error) {
+	objects := []Object{}
+	continuationToken := ""
+	for {
+		query := map[string]string{"list-type": "2", "prefix": s.prefix + prefix}
+		if continuationToken != "" {
+			query["continuation-token"] = continuationToken
+		}
+
+		response, err := s.send(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = checkResponse(response)
+		if err == nil {
+			err = xml.NewDecoder(response.Body).Decode(&result)
+		}
+		response.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, content := range result.Contents {
+			key := strings.TrimPrefix(content.Key, s.prefix)
+			objects = append(objects, Object{Key: key, Size: content.Size, Modified: content.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// do sends a signed request for the object with a key.
+func (s *S3Store) do(method string, key string, data []byte) (*http.Response, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	return s.send(method, s.prefix+key, nil, data)
+}
+
+// send sends a signed request for a path in the bucket, with the query parameters (sorted and
+// encoded as Signature Version 4 expects).
+func (s *S3Store) send(method string, path string, query map[string]string, data []byte) (*http.Response, error) {
+	requestURL := *s.endpoint
+	requestURL.Path = strings.TrimSuffix(requestURL.Path, "/") + "/" + s.bucket + "/" + path
+	requestURL.RawPath = encodePath(requestURL.Path)
+
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parameters := make([]string, len(names))
+	for i, name := range names {
+		parameters[i] = encodeURI(name) + "=" + encodeURI(query[name])
+	}
+	requestURL.RawQuery = strings.Join(parameters, "&")
+
+	request, err := http.NewRequest(method, requestURL.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	s.sign(request, data)
+	return s.client.Do(request)
+}
+
+// sign adds the Signature Version 4 headers to a request (with the payload hash, rather than
+// leaving the payload unsigned), signing the host and the x-amz- headers.
+func (s *S3Store) sign(request *http.Request, payload []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// The canonical headers are the lower case names, sorted, with trimmed values
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// checkResponse returns an error for a response that wasn't successful.
+func checkResponse(response *http.Response) error {
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.New("object storage request failed with status " + response.Status)
+	}
+
+	return nil
+}
+
+// encodePath URI encodes each segment of a path.
+func encodePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = encodeURI(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// encodeURI URI encodes a string as Signature Version 4 expects (everything but the unreserved
+// characters).
+func encodeURI(value string) string {
+	encoded := ""
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '.' || b == '_' || b == '~' {
+			encoded += string(b)
+		} else {
+			encoded += "%" + strings.ToUpper(hex.EncodeToString([]byte{b}))
+		}
+	}
+
+	return encoded
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,241 @@
+// Package storage provides object storage for the files the server writes outside of its actions
+// log (archives, channel exports and state snapshots): a directory on local disk, or a bucket on an
+// S3-compatible object store, so a server running in a stateless container doesn't need a
+// persistent volume.
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Storage backends
+const (
+	BackendLocal string = "local"
+	BackendS3    string = "s3"
+)
+
+// Errors returned by the stores.
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Store provides the objects, which are named by keys of '/' separated path segments (e.g.
+// "exports/General/index.html").
+type Store interface {
+	// Put creates or replaces the object with a key
+	Put(key string, data []byte) error
+
+	// Get returns the object with a key (ErrNotFound if there's none)
+	Get(key string) ([]byte, error)
+
+	// Stat returns the details of the object with a key (ErrNotFound if there's none)
+	Stat(key string) (Object, error)
+
+	// Delete deletes the object with a key (if there is one)
+	Delete(key string) error
+
+	// List returns the details of the objects with keys starting with a prefix, in key order
+	List(prefix string) ([]Object, error)
+}
+
+// Object provides the details of an object.
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Options provides the backend, and its settings.
+type Options struct {
+	// Backend is BackendLocal or BackendS3
+	Backend string
+
+	// Directory is the directory the local backend stores the objects in
+	Directory string
+
+	// Endpoint is the URL of the S3-compatible service (e.g. "https://s3.us-east-1.amazonaws.com"),
+	// which is addressed path-style (the bucket is the first path segment)
+	Endpoint string
+
+	// Region is the region the requests are signed for (defaults to "us-east-1")
+	Region string
+
+	// Bucket is the bucket the objects are stored in
+	Bucket string
+
+	// Prefix is prepended to the keys (e.g. "chatserver/")
+	Prefix string
+
+	// AccessKeyID and SecretAccessKey are the credentials the requests are signed with
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// New creates/initializes/returns a new Store for a backend.
+func New(options Options) (Store, error) {
+	switch options.Backend {
+	case BackendLocal:
+		return NewLocalStore(filepath.Join(options.Directory, filepath.FromSlash(options.Prefix))), nil
+	case BackendS3:
+		return NewS3Store(options)
+	}
+
+	return nil, errors.New("invalid storage backend")
+}
+
+// LocalStore provides a Store on local disk, with an object per file.
+type LocalStore struct {
+	dir string
+}
+
+// NewLocalStore creates/initializes/returns a new LocalStore in a directory (created as needed).
+func NewLocalStore(dir string) *LocalStore {
+	store := LocalStore{
+		dir: dir,
+	}
+
+	return &store
+}
+
+// Put creates or replaces the file for a key.  The file is written alongside and renamed over the
+// old one, so a reader never sees part of it.
+func (s *LocalStore) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".put.*")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// Get returns the contents of the file for a key.
+func (s *LocalStore) Get(key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+// Stat returns the details of the file for a key.
+func (s *LocalStore) Stat(key string) (Object, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return Object{}, ErrNotFound
+	} else if err != nil {
+		return Object{}, err
+	}
+
+	return Object{Key: key, Size: info.Size(), Modified: info.ModTime()}, nil
+}
+
+// Delete deletes the file for a key.
+func (s *LocalStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// List returns the details of the files with keys starting with a prefix (skipping the files Put
+// is still writing).
+func (s *LocalStore) List(prefix string) ([]Object, error) {
+	objects := []Object{}
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(s.dir, path)
+		if err != nil || info.IsDir() || strings.Contains(info.Name(), ".put.") {
+			return err
+		}
+
+		key := filepath.ToSlash(relativePath)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		}
+
+		return nil
+	})
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, err
+}
+
+// path returns the path of the file for a key.
+func (s *LocalStore) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// validKey returns whether a key is a relative path without empty, "." or ".." segments (so it
+// can't name anything outside of the store).
+func validKey(key string) bool {
+	if key == "" || strings.Contains(key, "\\") {
+		return false
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,166 @@
+package storage_test
+
+import (
+	"chatserver/storage"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testStore checks putting, getting, listing and deleting objects with a store.
+func testStore(t *testing.T, store storage.Store) {
+	if _, err := store.Get("missing.txt"); err != storage.ErrNotFound {
+		t.Error("Got an object that doesn't exist")
+	}
+
+	if store.Put("dir/object 1.txt", []byte("data1")) != nil || store.Put("dir/object 1.txt", []byte("data2")) != nil {
+		t.Fatal("Failed to put object")
+	}
+
+	data, err := store.Get("dir/object 1.txt")
+	if err != nil || string(data) != "data2" {
+		t.Error("Failed to get the latest object")
+	}
+
+	for _, key := range []string{"", "/object.txt", "dir/../object.txt", "dir//object.txt", "dir/"} {
+		if store.Put(key, []byte("data")) != storage.ErrInvalidKey {
+			t.Error("Put an object with an invalid key:", key)
+		}
+	}
+
+	object, err := store.Stat("dir/object 1.txt")
+	if err != nil || object.Key != "dir/object 1.txt" || object.Size != 5 || object.Modified.IsZero() {
+		t.Error("Failed to stat object")
+	}
+
+	if _, err := store.Stat("missing.txt"); err != storage.ErrNotFound {
+		t.Error("Stat an object that doesn't exist")
+	}
+
+	if store.Put("dir/object2.txt", []byte("data")) != nil || store.Put("other.txt", []byte("data")) != nil {
+		t.Fatal("Failed to put object")
+	}
+
+	objects, err := store.List("dir/")
+	if err != nil || len(objects) != 2 || objects[0].Key != "dir/object 1.txt" || objects[1].Key != "dir/object2.txt" || objects[1].Size != 4 {
+		t.Error("Failed to list objects")
+	}
+
+	if store.Delete("dir/object 1.txt") != nil || store.Delete("missing.txt") != nil {
+		t.Error("Failed to delete object")
+	}
+
+	if _, err := store.Get("dir/object 1.txt"); err != storage.ErrNotFound {
+		t.Error("Got a deleted object")
+	}
+
+	objects, err = store.List("")
+	if err != nil || len(objects) != 2 || objects[0].Key != "dir/object2.txt" || objects[1].Key != "other.txt" {
+		t.Error("Failed to list objects after deleting")
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal("Couldn't create temp directory")
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	store, err := storage.New(storage.Options{Backend: storage.BackendLocal, Directory: tempDir, Prefix: "prefix/"})
+	if err != nil {
+		t.Fatal("Failed to create store")
+	}
+
+	testStore(t, store)
+}
+
+func TestS3Store(t *testing.T) {
+	// A fake object store, which checks the requests are signed with the credentials
+	var mutex sync.Mutex
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !strings.HasPrefix(request.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") || request.Header.Get("X-Amz-Content-Sha256") == "" {
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch {
+		case request.Method == http.MethodGet && request.URL.Path == "/bucket/":
+			// List the objects a page at a time (in key order, as the sorted query is signed)
+			if request.URL.RawQuery != "list-type=2&prefix="+url.QueryEscape(request.URL.Query().Get("prefix")) &&
+				!strings.HasPrefix(request.URL.RawQuery, "continuation-token=") {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			keys := []string{}
+			for path := range objects {
+				key := strings.TrimPrefix(path, "/bucket/")
+				if strings.HasPrefix(key, request.URL.Query().Get("prefix")) && key > request.URL.Query().Get("continuation-token") {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+
+			fmt.Fprint(writer, "<ListBucketResult>")
+			if len(keys) > 1 {
+				fmt.Fprintf(writer, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[0])
+				keys = keys[:1]
+			}
+			for _, key := range keys {
+				fmt.Fprintf(writer, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>2020-01-01T00:00:00.000Z</LastModified></Contents>", key, len(objects["/bucket/"+key]))
+			}
+			fmt.Fprint(writer, "</ListBucketResult>")
+		case request.Method == http.MethodPut:
+			objects[request.URL.Path], _ = ioutil.ReadAll(request.Body)
+		case request.Method == http.MethodGet || request.Method == http.MethodHead:
+			data, ok := objects[request.URL.Path]
+			if !ok {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writer.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			writer.Header().Set("Last-Modified", "Wed, 01 Jan 2020 00:00:00 GMT")
+			writer.Write(data)
+		case request.Method == http.MethodDelete:
+			delete(objects, request.URL.Path)
+			writer.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	store, err := storage.New(storage.Options{
+		Backend:         storage.BackendS3,
+		Endpoint:        server.URL,
+		Bucket:          "bucket",
+		Prefix:          "prefix/",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatal("Failed to create store")
+	}
+
+	testStore(t, store)
+
+	if _, ok := objects["/bucket/prefix/dir/object2.txt"]; !ok {
+		t.Error("Object not stored in the bucket with the prefix")
+	}
+
+	if _, err := storage.New(storage.Options{Backend: storage.BackendS3, Endpoint: server.URL, Bucket: "bucket"}); err == nil {
+		t.Error("Created store without credentials")
+	}
+}
@@ -0,0 +1,295 @@
+package telnetapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	oi "github.com/reiver/go-oi"
+	gotelnet "github.com/reiver/go-telnet"
+)
+
+// Output overflow policies
+const (
+	OverflowDropOldest string = "drop-oldest"
+	OverflowDisconnect string = "disconnect"
+)
+
+// DefaultOutputQueueBytes is the number of bytes of pending output queued for a client when no
+// queue size is configured.
+const DefaultOutputQueueBytes int = 64 * 1024
+
+// OutputOptions contains the options for the output sent to each telnet client.  When a
+// flush interval is set, output is held for that long after the first of it is queued, so it can
+// be coalesced with any output that follows it.
+type OutputOptions struct {
+	QueueBytes    int
+	Overflow      string
+	FlushInterval time.Duration
+}
+
+// ErrOutputOverflow is reported when a client falls too far behind with the disconnect policy.
+var ErrOutputOverflow = errors.New("output queue overflow")
+
+// pendingOutput is a single write waiting in an OutputQueue.  Once its text has been dropped, only
+// its control sequences are left (see controlSequences).
+type pendingOutput struct {
+	data    []byte
+	dropped bool
+}
+
+// OutputQueue is a queue of pending output for a telnet client, bounded by its size in bytes, and
+// written to the client by its own goroutine so a client on a slow link can't block the model's
+// subscription notifications.  Everything pending is written to the client at once.  When the
+// queue is full the text of the oldest output is dropped (noting how many lines were dropped to
+// the client) or the client is disconnected, depending on the overflow policy.  Dropping output
+// keeps its control sequences (telnet commands and terminal escape sequences), so the client's
+// terminal isn't left in a state the dropped output would have changed.
+type OutputQueue struct {
+	writer   gotelnet.Writer
+	options  OutputOptions
+	pending  []pendingOutput
+	size     int
+	dropped  int
+	closed   bool
+	flush    *time.Timer
+	due      bool
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	finished func(err error)
+	finish   sync.Once
+}
+
+// NewOutputQueue creates/initializes/returns a new OutputQueue and starts writing to the client
+// until the context is done.  The finished callback is called once if the queue stops writing
+// before then (on a write error or an overflow with the disconnect policy).
+func NewOutputQueue(ctx context.Context, writer gotelnet.Writer, options OutputOptions, finished func(err error)) *OutputQueue {
+	if options.QueueBytes <= 0 {
+		options.QueueBytes = DefaultOutputQueueBytes
+	}
+
+	queue := OutputQueue{
+		writer:   writer,
+		options:  options,
+		pending:  make([]pendingOutput, 0),
+		finished: finished,
+	}
+	queue.cond = sync.NewCond(&queue.mutex)
+
+	// The flush timer is started by the first output queued (see Write)
+	if options.FlushInterval > 0 {
+		queue.flush = time.AfterFunc(options.FlushInterval, queue.flushDue)
+		queue.flush.Stop()
+	}
+
+	go queue.run()
+	go func() {
+		<-ctx.Done()
+		queue.close()
+	}()
+
+	return &queue
+}
+
+// Write satisfies the gotelnet Writer interface by queueing the data to be written.
+func (q *OutputQueue) Write(data []byte) (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return 0, errors.New("output queue closed")
+	}
+
+	if q.size+len(data) > q.options.QueueBytes {
+		if q.options.Overflow == OverflowDisconnect {
+			q.closed = true
+			q.pending = nil
+			q.cond.Signal()
+			go q.stop(ErrOutputOverflow)
+			return 0, ErrOutputOverflow
+		}
+
+		// Drop the text of the oldest output until the new output fits (or there's no text left
+		// to drop, as a single write bigger than the queue is still queued whole)
+		for i := 0; i < len(q.pending) && q.size+len(data) > q.options.QueueBytes; i++ {
+			if q.pending[i].dropped {
+				continue
+			}
+
+			controls := controlSequences(q.pending[i].data)
+			q.dropped += bytes.Count(q.pending[i].data, []byte("\n"))
+			q.size -= len(q.pending[i].data) - len(controls)
+			q.pending[i] = pendingOutput{data: controls, dropped: true}
+		}
+	}
+
+	// Output queued after it is held until the timer started by the first output fires (so it isn't
+	// held any longer however much keeps coming)
+	if q.flush != nil && len(q.pending) == 0 {
+		q.flush.Reset(q.options.FlushInterval)
+	}
+
+	q.pending = append(q.pending, pendingOutput{data: append([]byte(nil), data...)})
+	q.size += len(data)
+	q.cond.Signal()
+
+	return len(data), nil
+}
+
+// close stops the queue (any output still pending is discarded).
+func (q *OutputQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closed = true
+	q.pending = nil
+	q.size = 0
+	if q.flush != nil {
+		q.flush.Stop()
+	}
+	q.cond.Signal()
+}
+
+// flushDue lets the pending output be written, once the flush interval has passed since the first
+// of it was queued.
+func (q *OutputQueue) flushDue() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.due = true
+	q.cond.Signal()
+}
+
+// stop closes the queue and reports (only once) that it stopped writing.
+func (q *OutputQueue) stop(err error) {
+	q.close()
+	q.finish.Do(func() {
+		q.finished(err)
+	})
+}
+
+func (q *OutputQueue) run() {
+	var output bytes.Buffer
+	for {
+		// Wait for output (and, with a flush interval, for the interval to pass so any output that
+		// follows can be written along with it)
+		q.mutex.Lock()
+		for (len(q.pending) == 0 || (q.flush != nil && !q.due)) && !q.closed {
+			q.cond.Wait()
+		}
+
+		if q.closed {
+			q.mutex.Unlock()
+			return
+		}
+
+		// Coalesce everything pending into a single write (noting the dropped lines where the
+		// first of them would have been)
+		output.Reset()
+		for _, pending := range q.pending {
+			if pending.dropped && q.dropped > 0 {
+				output.WriteString("*** " + strconv.Itoa(q.dropped) + " lines of output dropped ***\r\n")
+				q.dropped = 0
+			}
+			output.Write(pending.data)
+		}
+		q.pending = q.pending[:0]
+		q.size = 0
+		q.due = false
+		q.mutex.Unlock()
+
+		_, err := oi.LongWrite(q.writer, output.Bytes())
+		if err != nil {
+			q.stop(err)
+			return
+		}
+	}
+}
+
+// controlSequences returns the telnet commands (IAC sequences, including subnegotiations) and
+// terminal escape sequences (ESC sequences, including CSI and OSC sequences) in the data, without
+// the text around them.  An escaped IAC (IAC IAC) is a data byte, so it isn't kept.
+func controlSequences(data []byte) []byte {
+	controls := make([]byte, 0)
+	for i := 0; i < len(data); {
+		start := i
+		switch data[i] {
+		case telnetIAC:
+			i = skipTelnetCommand(data, i)
+			if i-start == 2 && data[start+1] == telnetIAC {
+				continue
+			}
+		case '\x1b':
+			i = skipEscapeSequence(data, i)
+		default:
+			i++
+			continue
+		}
+
+		controls = append(controls, data[start:i]...)
+	}
+
+	return controls
+}
+
+// skipTelnetCommand returns the index just after the telnet command starting at i.
+func skipTelnetCommand(data []byte, i int) int {
+	if i+1 >= len(data) {
+		return len(data)
+	}
+
+	switch data[i+1] {
+	case telnetSB:
+		// A subnegotiation runs up to the IAC SE ending it
+		for j := i + 2; j+1 < len(data); j++ {
+			if data[j] == telnetIAC && data[j+1] == telnetSE {
+				return j + 2
+			}
+			if data[j] == telnetIAC {
+				j++
+			}
+		}
+		return len(data)
+	case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+		if i+3 > len(data) {
+			return len(data)
+		}
+		return i + 3
+	default:
+		return i + 2
+	}
+}
+
+// skipEscapeSequence returns the index just after the terminal escape sequence starting at i.
+func skipEscapeSequence(data []byte, i int) int {
+	if i+1 >= len(data) {
+		return len(data)
+	}
+
+	switch data[i+1] {
+	case '[':
+		// A CSI sequence runs up to its final byte
+		for j := i + 2; j < len(data); j++ {
+			if data[j] >= 0x40 && data[j] <= 0x7e {
+				return j + 1
+			}
+		}
+		return len(data)
+	case ']':
+		// An OSC sequence runs up to BEL or ST (ESC \)
+		for j := i + 2; j < len(data); j++ {
+			if data[j] == '\a' {
+				return j + 1
+			}
+			if data[j] == '\x1b' && j+1 < len(data) && data[j+1] == '\\' {
+				return j + 2
+			}
+		}
+		return len(data)
+	default:
+		return i + 2
+	}
+}
@@ -0,0 +1,186 @@
+package telnetapi_test
+
+import (
+	"bytes"
+	"chatserver/telnetapi"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWriter passes each write on to the test, and holds it until the gate is opened (so the queue
+// backs up behind it).
+type TestWriter struct {
+	writes chan []byte
+	gate   chan struct{}
+}
+
+func NewTestWriter() *TestWriter {
+	return &TestWriter{writes: make(chan []byte, 100), gate: make(chan struct{})}
+}
+
+func (t *TestWriter) Write(data []byte) (int, error) {
+	t.writes <- append([]byte(nil), data...)
+	<-t.gate
+	return len(data), nil
+}
+
+func (t *TestWriter) WaitForWrite() []byte {
+	select {
+	case data := <-t.writes:
+		return data
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+func TestOutputDropOldest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testWriter := NewTestWriter()
+	options := telnetapi.OutputOptions{QueueBytes: 64, Overflow: telnetapi.OverflowDropOldest}
+	queue := telnetapi.NewOutputQueue(ctx, testWriter, options, func(err error) {
+		t.Error("Failed to keep writing")
+	})
+
+	// Hold the writer up with the first output, so the rest backs up in the queue
+	queue.Write([]byte("first\r\n"))
+	if string(testWriter.WaitForWrite()) != "first\r\n" {
+		t.Error("Failed to write the output")
+	}
+
+	// The oldest output (with its colour and telnet command) is dropped to make room for the rest
+	queue.Write([]byte("\x1b[31mred line\r\n\xff\xfb\x01\xff\xff"))
+	for i := 0; i < 4; i++ {
+		if _, err := queue.Write([]byte("some text line\r\n")); err != nil {
+			t.Error("Failed to queue the output")
+		}
+	}
+
+	// A single write bigger than the whole queue is still queued
+	long := strings.Repeat("x", 100) + "\r\n"
+	if _, err := queue.Write([]byte(long)); err != nil {
+		t.Error("Failed to queue output bigger than the queue")
+	}
+
+	close(testWriter.gate)
+	output := testWriter.WaitForWrite()
+
+	// Ensure that the text was dropped, but not the control sequences in it
+	if bytes.Contains(output, []byte("red line")) || bytes.Contains(output, []byte("some text line")) {
+		t.Error("Failed to drop the oldest output")
+	}
+
+	if !bytes.HasPrefix(output, []byte("*** 5 lines of output dropped ***\r\n\x1b[31m\xff\xfb\x01")) {
+		t.Error("Failed to keep the control sequences of the dropped output")
+	}
+
+	if !bytes.HasSuffix(output, []byte(long)) {
+		t.Error("Failed to write the newest output")
+	}
+}
+
+func TestOutputDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testWriter := NewTestWriter()
+	defer close(testWriter.gate)
+
+	finished := make(chan error, 1)
+	options := telnetapi.OutputOptions{QueueBytes: 32, Overflow: telnetapi.OverflowDisconnect}
+	queue := telnetapi.NewOutputQueue(ctx, testWriter, options, func(err error) {
+		finished <- err
+	})
+
+	queue.Write([]byte("first\r\n"))
+	testWriter.WaitForWrite()
+
+	// Ensure that output within the queue's size is queued, and output past it disconnects
+	if _, err := queue.Write([]byte(strings.Repeat("x", 30) + "\r\n")); err != nil {
+		t.Error("Failed to queue the output")
+	}
+
+	if _, err := queue.Write([]byte("y\r\n")); err != telnetapi.ErrOutputOverflow {
+		t.Error("Failed to overflow the queue")
+	}
+
+	select {
+	case err := <-finished:
+		if err != telnetapi.ErrOutputOverflow {
+			t.Error("Incorrect overflow error")
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for the disconnect")
+	}
+
+	if _, err := queue.Write([]byte("z\r\n")); err == nil {
+		t.Error("Failed to stop queueing output")
+	}
+}
+
+// CountingWriter counts the writes made to it, passing each one on to the test.
+type CountingWriter struct {
+	writes chan []byte
+}
+
+func (c *CountingWriter) Write(data []byte) (int, error) {
+	c.writes <- append([]byte(nil), data...)
+	return len(data), nil
+}
+
+func TestOutputFlushInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	countingWriter := &CountingWriter{writes: make(chan []byte, 100)}
+	options := telnetapi.OutputOptions{FlushInterval: 100 * time.Millisecond}
+	queue := telnetapi.NewOutputQueue(ctx, countingWriter, options, func(err error) {
+		t.Error("Failed to keep writing")
+	})
+
+	// Ensure that lines queued within the interval are coalesced into a single write
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		queue.Write([]byte("line\r\n"))
+	}
+
+	select {
+	case data := <-countingWriter.writes:
+		if string(data) != strings.Repeat("line\r\n", 10) {
+			t.Error("Failed to coalesce the lines")
+		}
+		if time.Since(start) < options.FlushInterval {
+			t.Error("Failed to hold the output for the flush interval")
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for the output")
+	}
+
+	// Ensure that output that keeps coming doesn't hold the first of it back past the interval
+	start = time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for time.Since(start) < 3*options.FlushInterval {
+			queue.Write([]byte("line\r\n"))
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	select {
+	case <-countingWriter.writes:
+		if time.Since(start) > 2*options.FlushInterval {
+			t.Error("Held the output past the flush interval")
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for the output")
+	}
+	<-done
+
+	if len(countingWriter.writes) > 3 {
+		t.Error("Failed to coalesce the output")
+	}
+}
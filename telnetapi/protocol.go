@@ -0,0 +1,205 @@
+package telnetapi
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Telnet commands (RFC 854)
+const (
+	telnetSE   byte = 240
+	telnetSB   byte = 250
+	telnetWILL byte = 251
+	telnetWONT byte = 252
+	telnetDO   byte = 253
+	telnetDONT byte = 254
+	telnetIAC  byte = 255
+)
+
+// Telnet options negotiated with clients: the terminal type (RFC 1091) and the window size
+// (NAWS, RFC 1073)
+const (
+	optionTerminalType byte = 24
+	optionWindowSize   byte = 31
+
+	terminalTypeIs   byte = 0
+	terminalTypeSend byte = 1
+)
+
+// maxSubnegotiation bounds the subnegotiation data kept from a client.
+const maxSubnegotiation int = 64
+
+// protocolConn speaks the telnet protocol over a client connection: reads return the data the
+// client sent without the protocol commands, and writes escape the data.  Unlike go-telnet's
+// reader (which discards them) it passes on the window size and keeps the terminal type the
+// client reports.
+type protocolConn struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	writeMutex   sync.Mutex
+	mutex        sync.Mutex
+	terminalType string
+	onWindowSize func(width int, height int)
+}
+
+// newProtocolConn creates/initializes/returns a new protocolConn for a client connection.
+func newProtocolConn(conn net.Conn) *protocolConn {
+	protocol := protocolConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+
+	return &protocol
+}
+
+// negotiate asks the client to report its window size and terminal type (clients that don't
+// support the options refuse them, or ignore the request).
+func (p *protocolConn) negotiate() error {
+	return p.writeCommand(telnetIAC, telnetDO, optionWindowSize, telnetIAC, telnetDO, optionTerminalType)
+}
+
+// setOnWindowSize sets the function called whenever the client reports its window size.
+func (p *protocolConn) setOnWindowSize(onWindowSize func(width int, height int)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.onWindowSize = onWindowSize
+}
+
+// terminal returns the client's terminal type (in upper case, empty if it hasn't reported it).
+func (p *protocolConn) terminal() string {
+	if p == nil {
+		return ""
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.terminalType
+}
+
+// Read satisfies the gotelnet Reader interface by reading (at least a byte of) the client's data,
+// handling the protocol commands in between.
+func (p *protocolConn) Read(data []byte) (int, error) {
+	n := 0
+	for n == 0 || (n < len(data) && p.reader.Buffered() > 0) {
+		b, err := p.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+
+		if b != telnetIAC {
+			data[n] = b
+			n++
+			continue
+		}
+
+		command, err := p.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+
+		switch command {
+		case telnetIAC:
+			data[n] = telnetIAC
+			n++
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			option, err := p.reader.ReadByte()
+			if err != nil {
+				return n, err
+			}
+
+			// The terminal type is sent when asked for, once the client agrees to send it
+			if command == telnetWILL && option == optionTerminalType {
+				err = p.writeCommand(telnetIAC, telnetSB, optionTerminalType, terminalTypeSend, telnetIAC, telnetSE)
+				if err != nil {
+					return n, err
+				}
+			}
+		case telnetSB:
+			err = p.readSubnegotiation()
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// readSubnegotiation reads the subnegotiation of an option (up to the IAC SE ending it) and
+// keeps what the client reported.
+func (p *protocolConn) readSubnegotiation() error {
+	option, err := p.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	var subnegotiation bytes.Buffer
+	for {
+		b, err := p.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if b == telnetIAC {
+			b, err = p.reader.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			if b == telnetSE {
+				break
+			}
+		}
+
+		if subnegotiation.Len() < maxSubnegotiation {
+			subnegotiation.WriteByte(b)
+		}
+	}
+
+	value := subnegotiation.Bytes()
+	switch {
+	case option == optionWindowSize && len(value) == 4:
+		width := int(value[0])<<8 | int(value[1])
+		height := int(value[2])<<8 | int(value[3])
+
+		p.mutex.Lock()
+		onWindowSize := p.onWindowSize
+		p.mutex.Unlock()
+
+		if onWindowSize != nil {
+			onWindowSize(width, height)
+		}
+	case option == optionTerminalType && len(value) > 1 && value[0] == terminalTypeIs:
+		p.mutex.Lock()
+		p.terminalType = strings.ToUpper(string(value[1:]))
+		p.mutex.Unlock()
+	}
+
+	return nil
+}
+
+// Write satisfies the gotelnet Writer interface by writing the data, escaped, to the client.
+func (p *protocolConn) Write(data []byte) (int, error) {
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+
+	_, err := p.conn.Write(bytes.Replace(data, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC}, -1))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (p *protocolConn) writeCommand(command ...byte) error {
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+
+	_, err := p.conn.Write(command)
+	return err
+}
@@ -0,0 +1,307 @@
+package telnetapi
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// The smallest terminal the full screen view is laid out on, and the smallest one that also gets
+// the channel sidebar
+const (
+	minScreenWidth  int = 40
+	minScreenHeight int = 10
+	minSidebarWidth int = 80
+)
+
+// minWrapWidth is the narrowest window output is wrapped for (narrower ones are left to the
+// terminal).
+const minWrapWidth int = 20
+
+// sidebarWidth is the width of the full screen view's channel sidebar (including its border).
+const sidebarWidth int = 20
+
+// ScreenStatus is the function signature that provides what the full screen view shows around
+// the output: the current user and channel, and the channels listed in the sidebar.
+type ScreenStatus = func() (username string, channelname string, channels []string)
+
+// screen writes a telnet client's output, wrapping long lines at the width of the client's window
+// (when it reported it) rather than leaving the terminal to break them mid-word.  It also lays
+// the output out as a full screen (curses style) view when enabled on a terminal that reported
+// its size and type: the output scrolls in a region at the top (next to a sidebar listing the
+// channels), above a status line and the input line pinned at the bottom.  Clients keep editing
+// lines locally, so what's typed is echoed on the input line, and output arriving meanwhile
+// doesn't move the cursor off it.
+type screen struct {
+	writer  io.Writer
+	status  ScreenStatus
+	enabled bool
+	width   int
+	height  int
+	prompt  string
+	mutex   sync.Mutex
+}
+
+// newScreen creates/initializes/returns a new screen (not enabled) writing to the writer.
+func newScreen(writer io.Writer) *screen {
+	screen := screen{
+		writer: writer,
+	}
+
+	return &screen
+}
+
+// setStatus sets the function providing the status line and sidebar.
+func (s *screen) setStatus(status ScreenStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.status = status
+}
+
+// isEnabled returns whether the full screen view is on.
+func (s *screen) isEnabled() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.enabled
+}
+
+// enable turns the full screen view on or off, returning false if it can't be turned on because
+// the terminal didn't report its type or a large enough size.
+func (s *screen) enable(enabled bool, terminalType string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if enabled && (terminalType == "" || terminalType == "DUMB" || terminalType == "UNKNOWN" || s.width < minScreenWidth || s.height < minScreenHeight) {
+		return false, nil
+	}
+
+	s.enabled = enabled
+	if !enabled {
+		// Back to a plain terminal that scrolls as a whole
+		_, err := io.WriteString(s.writer, "\x1b[r\x1b[2J\x1b["+strconv.Itoa(s.height)+";1H")
+		return true, err
+	}
+
+	return true, s.redraw()
+}
+
+// resize notes the client's new window size, laying the full screen view out again for it (or
+// turning it off if the window got too small).  It returns whether the terminal was cleared.
+func (s *screen) resize(width int, height int) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.width = width
+	s.height = height
+	if !s.enabled {
+		return false, nil
+	}
+
+	if width < minScreenWidth || height < minScreenHeight {
+		s.enabled = false
+		_, err := io.WriteString(s.writer, "\x1b[r\x1b[2J\x1b["+strconv.Itoa(height)+";1H")
+		return true, err
+	}
+
+	return true, s.redraw()
+}
+
+// Write satisfies the gotelnet Writer interface.  The complete lines are wrapped, and when the
+// view is enabled they're written to the output region and what follows them (the prompt) to the
+// input line.
+func (s *screen) Write(data []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.enabled && s.width < minWrapWidth {
+		return s.writer.Write(data)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	var output bytes.Buffer
+
+	if !s.enabled {
+		// Writing to the last column would wrap early on some terminals
+		for _, line := range lines[:len(lines)-1] {
+			for _, wrappedLine := range wrapLine(strings.TrimRight(line, "\r"), s.width-1) {
+				output.WriteString(wrappedLine + "\r\n")
+			}
+		}
+		output.WriteString(lines[len(lines)-1])
+
+		_, err := s.writer.Write(output.Bytes())
+		if err != nil {
+			return 0, err
+		}
+
+		return len(data), nil
+	}
+
+	// Write the lines at the bottom of the output region, scrolling it up
+	if len(lines) > 1 {
+		output.WriteString("\x1b7" + cursorPosition(s.height-2, 1))
+		for _, line := range lines[:len(lines)-1] {
+			for _, wrappedLine := range wrapLine(strings.TrimRight(line, "\r"), s.outputWidth()) {
+				output.WriteString("\r\n" + wrappedLine)
+			}
+		}
+		s.writeStatus(&output)
+		output.WriteString("\x1b8")
+	}
+
+	// Anything after the last line is the prompt, which starts a new input line
+	if prompt := lines[len(lines)-1]; prompt != "" {
+		s.prompt = prompt
+		output.WriteString(cursorPosition(s.height, 1) + "\x1b[2K" + prompt)
+	}
+
+	_, err := s.writer.Write(output.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// redraw clears the terminal and draws the view (with an empty output region).
+func (s *screen) redraw() error {
+	var output bytes.Buffer
+	output.WriteString("\x1b[r\x1b[2J\x1b[1;" + strconv.Itoa(s.height-2) + "r")
+	s.writeStatus(&output)
+	output.WriteString(cursorPosition(s.height, 1) + "\x1b[2K" + s.prompt)
+
+	_, err := s.writer.Write(output.Bytes())
+	return err
+}
+
+// writeStatus draws the sidebar and the status line.
+func (s *screen) writeStatus(output *bytes.Buffer) {
+	username, channelname, channels := "", "", []string(nil)
+	if s.status != nil {
+		username, channelname, channels = s.status()
+	}
+
+	if s.width >= minSidebarWidth {
+		column := s.width - sidebarWidth + 1
+		for row := 1; row <= s.height-2; row++ {
+			text := ""
+			switch {
+			case row == 1:
+				text = "Channels"
+			case row-2 < len(channels) && channels[row-2] == channelname:
+				text = "> " + channels[row-2]
+			case row-2 < len(channels):
+				text = "  " + channels[row-2]
+			}
+
+			output.WriteString(cursorPosition(row, column) + "|" + padWidth(text, sidebarWidth-1))
+		}
+	}
+
+	status := " " + username + " @ " + channelname + " | /fullscreen to leave"
+	output.WriteString(cursorPosition(s.height-1, 1) + "\x1b[7m" + padWidth(status, s.width) + "\x1b[0m")
+}
+
+// outputWidth returns the width of the output region.
+func (s *screen) outputWidth() int {
+	if s.width >= minSidebarWidth {
+		return s.width - sidebarWidth
+	}
+
+	// Writing to the last column would wrap early on some terminals
+	return s.width - 1
+}
+
+func cursorPosition(row int, column int) string {
+	return "\x1b[" + strconv.Itoa(row) + ";" + strconv.Itoa(column) + "H"
+}
+
+// wrapLine wraps text at the spaces into lines of at most width characters (splitting words that
+// don't fit on a line of their own), with the lines after the first indented to hang under the
+// text.
+func wrapLine(text string, width int) []string {
+	if utf8.RuneCountInString(text) <= width {
+		return []string{text}
+	}
+
+	indent := []rune(strings.Repeat(" ", hangingIndent(text, width)))
+	lines := make([]string, 0)
+	line := make([]rune, 0)
+	empty := true
+	for _, word := range strings.Split(text, " ") {
+		if word == "" && empty {
+			continue
+		}
+
+		runes := []rune(word)
+		for {
+			space := 1
+			if empty {
+				space = 0
+			}
+
+			// Add the word if it fits, otherwise start a new line for it
+			if len(line)+space+len(runes) <= width {
+				if !empty {
+					line = append(line, ' ')
+				}
+				line = append(line, runes...)
+				empty = false
+				break
+			}
+
+			if empty {
+				// The word doesn't fit on a line of its own, fill the line with it
+				fit := width - len(line)
+				line = append(line, runes[:fit]...)
+				runes = runes[fit:]
+			}
+
+			lines = append(lines, string(line))
+			line = append([]rune(nil), indent...)
+			empty = true
+		}
+	}
+
+	return append(lines, string(line))
+}
+
+// hangingIndent returns the indent of the lines wrapped from text: the text of a message starts
+// after its "[...] " prefixes (the channel, time and author), other lines are indented by 2.  The
+// indent is kept to at most half the width.
+func hangingIndent(text string, width int) int {
+	prefix := 0
+	for strings.HasPrefix(text[prefix:], "[") {
+		end := strings.Index(text[prefix:], "] ")
+		if end == -1 {
+			break
+		}
+		prefix += end + 2
+	}
+
+	indent := utf8.RuneCountInString(text[:prefix])
+	if indent == 0 {
+		indent = 2
+	}
+
+	if indent > width/2 {
+		indent = 4
+	}
+
+	return indent
+}
+
+// padWidth truncates or pads text to exactly width characters.
+func padWidth(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+
+	return text + strings.Repeat(" ", width-len(runes))
+}
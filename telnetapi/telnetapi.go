@@ -6,13 +6,19 @@ package telnetapi
 
 import (
 	"bytes"
+	"chatserver/credentials"
 	"chatserver/model"
 	"chatserver/model/subs"
 	"chatserver/telnetconn"
+	"chatserver/tracing"
+	"context"
 	"log"
+	"net"
+	"runtime/debug"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"time"
 
 	oi "github.com/reiver/go-oi"
 	gotelnet "github.com/reiver/go-telnet"
@@ -21,44 +27,115 @@ import (
 // ConnectionHandler holds data that needs to be forwarded/used for the
 // individual telnet connections
 type ConnectionHandler struct {
-	model      *model.Model
-	subsEngine *subs.Engine
+	model         *model.Model
+	subsEngine    *subs.Engine
+	credentials   *credentials.Credentials
+	tracer        *tracing.Tracer
+	outputOptions OutputOptions
+	connections   int32
 }
 
-// NewConnectionHandler creates/initializes/returns a new ConnectionHandler
-func NewConnectionHandler(model *model.Model, subsEngine *subs.Engine) *ConnectionHandler {
+// NewConnectionHandler creates/initializes/returns a new ConnectionHandler (the credentials and
+// tracer may be nil)
+func NewConnectionHandler(model *model.Model, subsEngine *subs.Engine, credentials *credentials.Credentials, tracer *tracing.Tracer, outputOptions OutputOptions) *ConnectionHandler {
 	handler := ConnectionHandler{
-		model:      model,
-		subsEngine: subsEngine,
+		model:         model,
+		subsEngine:    subsEngine,
+		credentials:   credentials,
+		tracer:        tracer,
+		outputOptions: outputOptions,
 	}
 
 	return &handler
 }
 
+// Serve accepts connections on the listener and serves each of them with the handler.  Unlike
+// go-telnet's Serve, it negotiates the client's window size and terminal type (which the full
+// screen view needs).  It only returns when the listener fails.
+func Serve(listener net.Listener, h *ConnectionHandler) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go h.serveConn(conn)
+	}
+}
+
+func (h *ConnectionHandler) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic serving telnet connection: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	protocol := newProtocolConn(conn)
+	if protocol.negotiate() != nil {
+		return
+	}
+
+	address, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	h.serve(protocol, protocol, protocol, address)
+}
+
 // ServeTELNET satisfies the go-telnet Handler interface and is called
 // whenever a new telnet session is initiated.  It will create a new telnet
 // connection and parse/forward telnet commands to that connection.
 func (h *ConnectionHandler) ServeTELNET(ctx gotelnet.Context, writer gotelnet.Writer, reader gotelnet.Reader) {
-	connChan := make(chan error)
+	// go-telnet doesn't keep what the client reports about its terminal (or its address)
+	h.serve(writer, reader, nil, "")
+}
 
-	// We need a mutex for each connection in case we get printLinesCallback called from multiple goroutines
-	var connMutex sync.Mutex
-	printLinesCallback := func(lines []string) {
-		connMutex.Lock()
-		defer connMutex.Unlock()
+// serve runs a telnet session (the protocol is nil when the client's terminal is unknown, and the
+// address empty when the client's address is).
+func (h *ConnectionHandler) serve(writer gotelnet.Writer, reader gotelnet.Reader, protocol *protocolConn, address string) {
+	atomic.AddInt32(&h.connections, 1)
+	defer atomic.AddInt32(&h.connections, -1)
+
+	// Everything started for this session stops when the session context is done (go-telnet closes
+	// the connection when we return, which also ends the blocked read in handleConn)
+	sessionCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Both the handler and the output queue report (once) when the session has ended
+	connChan := make(chan error, 2)
 
-		// Write the new text to the telnet client
+	// All output to the client is queued (so a slow client can't block the model's subscription
+	// notifications), and laid out by the screen when the full screen view is on
+	// NOTE: Assume all write errors mean the session has ended and should be swallowed
+	screen := newScreen(writer)
+	output := NewOutputQueue(sessionCtx, screen, h.outputOptions, func(err error) {
+		if err == ErrOutputOverflow {
+			log.Println("telnet: disconnecting a client that fell behind on its output")
+		}
+		connChan <- nil
+	})
+
+	// The lines are queued as a single write (which also keeps them together in case we get
+	// printLinesCallback called from multiple goroutines)
+	printLinesCallback := func(lines []string) {
+		var text bytes.Buffer
 		for _, line := range lines {
-			_, err := oi.LongWriteString(writer, line+"\r\n")
-			if err != nil {
-				connChan <- err
-				return
-			}
+			text.WriteString(line + "\r\n")
 		}
+		output.Write(text.Bytes())
 	}
 
 	// Create a new telnet connection
-	telnetConn := telnetconn.NewTelnetConn(h.model, printLinesCallback)
+	telnetConn := telnetconn.NewTelnetConn(h.model, h.credentials, printLinesCallback)
+	telnetConn.SetAddress(address)
+	screen.setStatus(telnetConn.Status)
+	if protocol != nil {
+		protocol.setOnWindowSize(func(width int, height int) {
+			cleared, err := screen.resize(width, height)
+			if err == nil && cleared {
+				telnetConn.Refresh()
+			}
+		})
+	}
 
 	// Connect it to the subscription engine
 	err := h.subsEngine.Connect(telnetConn)
@@ -66,20 +143,29 @@ func (h *ConnectionHandler) ServeTELNET(ctx gotelnet.Context, writer gotelnet.Wr
 		log.Fatal(err)
 	}
 
+	// Clean up the subscriptions (before the session context is cancelled, so no more output is
+	// queued once the session has ended)
+	defer func() {
+		err := h.subsEngine.Disconnect(telnetConn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		telnetConn.Close()
+	}()
+
 	// Handle the new connection
-	go h.handleConn(ctx, writer, reader, telnetConn, connChan)
+	go h.handleConn(sessionCtx, output, reader, telnetConn, screen, protocol, connChan)
 
-	// Wait for the handler to exit
+	// Wait for the handler (or the output queue) to exit
 	err = <-connChan
 	if err != nil {
 		log.Fatal(err)
 	}
+}
 
-	// Clean up the subscriptions
-	err = h.subsEngine.Disconnect(telnetConn)
-	if err != nil {
-		log.Fatal(err)
-	}
+// NumConnections returns the number of open telnet connections.
+func (h *ConnectionHandler) NumConnections() int {
+	return int(atomic.LoadInt32(&h.connections))
 }
 
 func (h *ConnectionHandler) writePrompt(writer gotelnet.Writer) error {
@@ -112,16 +198,37 @@ func (h *ConnectionHandler) parseHelpCmd(telnetConn *telnetconn.TelnetConn, writ
 	if _, err := oi.LongWriteString(writer, "/users - display users\r\n"); err != nil {
 		return err
 	}
-	if _, err := oi.LongWriteString(writer, "/user <user> - change current user to <user>\r\n"); err != nil {
+	if _, err := oi.LongWriteString(writer, "/user <user> - change current user to <user> (if it isn't a registered account)\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/login <user> <password> [<code>] - log in to the registered account <user> (with the <code> from its authenticator if it has two-factor authentication)\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/logout - log out (back to the built-in user)\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/register <user> <password> - create the registered account <user> and log in to it\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/totp enable|disable <code> - enable two-factor authentication for the logged in account, or disable it\r\n"); err != nil {
 		return err
 	}
 	if _, err := oi.LongWriteString(writer, "/userinfo - display info about the current user\r\n"); err != nil {
 		return err
 	}
+	if _, err := oi.LongWriteString(writer, "/profile <displayname|bio|pronouns> [text] - set (or, without <text>, clear) a field of the current user's profile\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/status [text] - set (or, without <text>, clear) the current user's status\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/away [text] - mark the current user as away, with an optional status <text>\r\n"); err != nil {
+		return err
+	}
 	if _, err := oi.LongWriteString(writer, "/createuser <user> - create a new <user>\r\n"); err != nil {
 		return err
 	}
-	if _, err := oi.LongWriteString(writer, "/deleteuser <user> - delete an existing <user>\r\n"); err != nil {
+	if _, err := oi.LongWriteString(writer, "/renameuser <newuser> - rename the current user to <newuser>, keeping their messages (asks for confirmation)\r\n"); err != nil {
 		return err
 	}
 	if _, err := oi.LongWriteString(writer, "/blockuser <user> - block posts from <user>\r\n"); err != nil {
@@ -130,22 +237,118 @@ func (h *ConnectionHandler) parseHelpCmd(telnetConn *telnetconn.TelnetConn, writ
 	if _, err := oi.LongWriteString(writer, "/unblockuser <user> - unblock posts from <user>\r\n"); err != nil {
 		return err
 	}
-	if _, err := oi.LongWriteString(writer, "/channels - display channels\r\n"); err != nil {
+	if _, err := oi.LongWriteString(writer, "/dm <user> <text> - send a direct message to <user>\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/dms [user] - display the current user's conversations, or the direct messages with [user]\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/group <user> [user...] - create a group conversation with the listed users\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/gm <group> <text> - send a message to group <group>\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/groups [group] - display the current user's groups, or the messages in [group]\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/events - display the upcoming events on the current channel's calendar\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/event <YYYY-MM-DD> <HH:MM> <title> - add an event to the current channel's calendar\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/rsvp <event> [no] - tell <event> the current user is going (or, with no, isn't)\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/mentions - display the messages that mention the current user\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/security - display the security events on the current user's account (e.g. logins from new addresses)\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/starred - display the messages the current user has starred\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/pins - display the messages pinned in the current channel\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/channels - display joined (with unread counts) and available channels\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/browse - display public channels\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/join <channel> - join <channel> and change current channel to it\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/leave <channel> - leave <channel>\r\n"); err != nil {
 		return err
 	}
 	if _, err := oi.LongWriteString(writer, "/channel <channel> - change current channel to <channel>\r\n"); err != nil {
 		return err
 	}
+	if _, err := oi.LongWriteString(writer, "/watch <channel> - show new messages from <channel> inline without changing current channel\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/unwatch <channel> - stop showing new messages from <channel>\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/split [channel] - (experimental) show messages from <channel> interleaved with the current channel, or turn the split view off\r\n"); err != nil {
+		return err
+	}
 	if _, err := oi.LongWriteString(writer, "/channelinfo - display info about the current channel\r\n"); err != nil {
 		return err
 	}
+	if _, err := oi.LongWriteString(writer, "/members - display the members of the current channel\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/topic <topic> - set the <topic> of the current channel\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/rules <language> <rules> - set the <language> and content <rules> of the current channel\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/notes [add|set <text>] - display the current channel's notes, or add a line of <text> to them (or set them to it, asks for confirmation)\r\n"); err != nil {
+		return err
+	}
 	if _, err := oi.LongWriteString(writer, "/channelhistory <num messages> - show <num messages> of current channel history (-1 for all)\r\n"); err != nil {
 		return err
 	}
-	if _, err := oi.LongWriteString(writer, "/createchannel <channel> - create a new <channel>\r\n"); err != nil {
+	if _, err := oi.LongWriteString(writer, "/history since <duration> - show current channel history from the last <duration> (e.g. 30m, 2h or 1d)\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/snippet <language> - post the lines that follow, up to a ``` line, as a code snippet in <language> (pasting a fenced ```<language> block does the same)\r\n"); err != nil {
 		return err
 	}
-	if _, err := oi.LongWriteString(writer, "/deletechannel <channel> - delete an existing <channel>\r\n"); err != nil {
+	if _, err := oi.LongWriteString(writer, "/post #<channel> #<channel>... <text> - cross-post <text> to several channels (editing or deleting one copy changes them all)\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/leaderboard [day|week|month|all] - show the top posters in the current channel (defaults to week)\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/karma [user] - show the karma score of [user] (defaults to the current user)\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/mutechannel <channel> - mute notifications from <channel>\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/unmutechannel <channel> - unmute notifications from <channel>\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/createchannel <channel> [<topic>] - create a new <channel> (with a <topic>), join it and switch to it\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/restorechannel <channel> - restore a <channel> deleted in the last few minutes\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/renamechannel <channel> <newchannel> - rename a <channel> to <newchannel>, keeping its history (asks for confirmation)\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/fullscreen - turn the full screen view (output above a pinned input line, with a channel sidebar) on or off, on terminals that report their size and type\r\n"); err != nil {
+		return err
+	}
+	if _, err := oi.LongWriteString(writer, "/screenreader - turn screen reader friendly output (no separators or full screen view, messages read out as \"message from <user> at <time>\") on or off for the current user\r\n"); err != nil {
 		return err
 	}
 	if _, err := oi.LongWriteString(writer, "/exit - exit\r\n"); err != nil {
@@ -189,41 +392,105 @@ func (h *ConnectionHandler) parseUserCmd(telnetConn *telnetconn.TelnetConn, writ
 	return nil
 }
 
-func (h *ConnectionHandler) parseUserInfoCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+func (h *ConnectionHandler) parseLoginCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 3 && len(fields) != 4 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <user> and <password> (and a <code> with two-factor authentication)\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	code := ""
+	if len(fields) == 4 {
+		code = fields[3]
+	}
+
+	telnetConn.Login(fields[1], fields[2], code)
+	return nil
+}
+
+func (h *ConnectionHandler) parseRegisterCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 3 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <user> and <password>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.Register(fields[1], fields[2])
+	return nil
+}
+
+func (h *ConnectionHandler) parseLogoutCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
 	if len(fields) != 1 {
-		if _, err := oi.LongWriteString(writer, "error: unknown /userinfo option\r\n"); err != nil {
+		if _, err := oi.LongWriteString(writer, "error: unknown /logout option\r\n"); err != nil {
 			return err
 		}
 
 		return nil
 	}
 
-	telnetConn.ShowUserInfo()
+	telnetConn.Logout()
 	return nil
 }
 
-func (h *ConnectionHandler) parseCreateUserCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
-	if len(fields) == 1 {
-		if _, err := oi.LongWriteString(writer, "error: must provide a <user>\r\n"); err != nil {
+func (h *ConnectionHandler) parseTOTPCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 2 && fields[1] == "enable" {
+		telnetConn.EnableTOTP()
+		return nil
+	}
+
+	if len(fields) == 3 && fields[1] == "disable" {
+		telnetConn.DisableTOTP(fields[2])
+		return nil
+	}
+
+	if _, err := oi.LongWriteString(writer, "error: unknown /totp option\r\n"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *ConnectionHandler) parseUserInfoCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 1 {
+		if _, err := oi.LongWriteString(writer, "error: unknown /userinfo option\r\n"); err != nil {
 			return err
 		}
 
 		return nil
 	}
 
-	if len(fields) > 2 {
-		if _, err := oi.LongWriteString(writer, "error: <user> must not contain spaces\r\n"); err != nil {
+	telnetConn.ShowUserInfo()
+	return nil
+}
+
+func (h *ConnectionHandler) parseProfileCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 || (fields[1] != "displayname" && fields[1] != "bio" && fields[1] != "pronouns") {
+		if _, err := oi.LongWriteString(writer, "error: must provide displayname, bio or pronouns\r\n"); err != nil {
 			return err
 		}
 
 		return nil
 	}
 
-	telnetConn.CreateUser(fields[1])
+	telnetConn.SetProfileField(fields[1], strings.Join(fields[2:], " "))
+	return nil
+}
+
+func (h *ConnectionHandler) parseStatusCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	telnetConn.SetStatus(strings.Join(fields[1:], " "), false)
+	return nil
+}
+
+func (h *ConnectionHandler) parseAwayCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	telnetConn.SetStatus(strings.Join(fields[1:], " "), true)
 	return nil
 }
 
-func (h *ConnectionHandler) parseDeleteUserCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+func (h *ConnectionHandler) parseCreateUserCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
 	if len(fields) == 1 {
 		if _, err := oi.LongWriteString(writer, "error: must provide a <user>\r\n"); err != nil {
 			return err
@@ -240,7 +507,7 @@ func (h *ConnectionHandler) parseDeleteUserCmd(telnetConn *telnetconn.TelnetConn
 		return nil
 	}
 
-	telnetConn.DeleteUser(fields[1])
+	telnetConn.CreateUser(fields[1])
 	return nil
 }
 
@@ -286,84 +553,123 @@ func (h *ConnectionHandler) parseUnblockUserCmd(telnetConn *telnetconn.TelnetCon
 	return nil
 }
 
-func (h *ConnectionHandler) parseChannelsCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
-	if len(fields) != 1 {
-		if _, err := oi.LongWriteString(writer, "error: unknown /channels option\r\n"); err != nil {
+func (h *ConnectionHandler) parseEventsCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	telnetConn.ShowEvents()
+	return nil
+}
+
+func (h *ConnectionHandler) parseMentionsCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	telnetConn.ShowMentions()
+	return nil
+}
+
+func (h *ConnectionHandler) parseSecurityCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	telnetConn.ShowSecurityEvents()
+	return nil
+}
+
+func (h *ConnectionHandler) parsePinsCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	telnetConn.ShowPinnedMessages()
+	return nil
+}
+
+func (h *ConnectionHandler) parseStarredCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	telnetConn.ShowStarredMessages()
+	return nil
+}
+
+func (h *ConnectionHandler) parseEventCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) < 4 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <YYYY-MM-DD> <HH:MM> and <title>\r\n"); err != nil {
 			return err
 		}
 
 		return nil
 	}
 
-	telnetConn.ShowChannels()
+	start, err := time.ParseInLocation("2006-01-02 15:04", fields[1]+" "+fields[2], time.Local)
+	if err != nil {
+		if _, err := oi.LongWriteString(writer, "error: invalid <YYYY-MM-DD> <HH:MM>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.CreateEvent(start, strings.Join(fields[3:], " "))
 	return nil
 }
 
-func (h *ConnectionHandler) parseChannelCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
-	if len(fields) == 1 {
-		if _, err := oi.LongWriteString(writer, "error: must provide a <channel>\r\n"); err != nil {
+func (h *ConnectionHandler) parseRSVPCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 || len(fields) > 3 || (len(fields) == 3 && fields[2] != "no") {
+		if _, err := oi.LongWriteString(writer, "error: must provide an <event> and optionally no\r\n"); err != nil {
 			return err
 		}
 
 		return nil
 	}
 
-	if len(fields) > 2 {
-		if _, err := oi.LongWriteString(writer, "error: <channel> must not contain spaces\r\n"); err != nil {
+	eventID, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		if _, err := oi.LongWriteString(writer, "error: invalid <event>\r\n"); err != nil {
 			return err
 		}
 
 		return nil
 	}
 
-	telnetConn.SwitchChannel(fields[1])
+	telnetConn.RSVPEvent(eventID, len(fields) == 2)
 	return nil
 }
 
-func (h *ConnectionHandler) parseChannelInfoCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+func (h *ConnectionHandler) parseChannelsCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
 	if len(fields) != 1 {
-		if _, err := oi.LongWriteString(writer, "error: unknown /channelinfo option\r\n"); err != nil {
+		if _, err := oi.LongWriteString(writer, "error: unknown /channels option\r\n"); err != nil {
 			return err
 		}
 
 		return nil
 	}
 
-	telnetConn.ShowChannelInfo()
+	telnetConn.ShowChannels()
 	return nil
 }
 
-func (h *ConnectionHandler) parseChannelHistoryCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
-	if len(fields) == 1 {
-		if _, err := oi.LongWriteString(writer, "error: must provide <num messages>\r\n"); err != nil {
+func (h *ConnectionHandler) parseBrowseCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 1 {
+		if _, err := oi.LongWriteString(writer, "error: unknown /browse option\r\n"); err != nil {
 			return err
 		}
 
 		return nil
 	}
 
-	if len(fields) > 2 {
-		if _, err := oi.LongWriteString(writer, "error: unknown /channelhistory option\r\n"); err != nil {
+	telnetConn.ShowPublicChannels()
+	return nil
+}
+
+func (h *ConnectionHandler) parseJoinCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <channel>\r\n"); err != nil {
 			return err
 		}
 
 		return nil
 	}
 
-	numMessages, err := strconv.Atoi(fields[1])
-	if err != nil || numMessages < -1 {
-		if _, err := oi.LongWriteString(writer, "error: invalid <num messages>\r\n"); err != nil {
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: <channel> must not contain spaces\r\n"); err != nil {
 			return err
 		}
 
 		return nil
 	}
 
-	telnetConn.ShowChannelHistory(numMessages)
+	telnetConn.JoinChannel(fields[1])
 	return nil
 }
 
-func (h *ConnectionHandler) parseCreateChannelCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+func (h *ConnectionHandler) parseLeaveCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
 	if len(fields) == 1 {
 		if _, err := oi.LongWriteString(writer, "error: must provide a <channel>\r\n"); err != nil {
 			return err
@@ -380,11 +686,11 @@ func (h *ConnectionHandler) parseCreateChannelCmd(telnetConn *telnetconn.TelnetC
 		return nil
 	}
 
-	telnetConn.CreateChannel(fields[1])
+	telnetConn.LeaveChannel(fields[1])
 	return nil
 }
 
-func (h *ConnectionHandler) parseDeleteChannelCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+func (h *ConnectionHandler) parseChannelCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
 	if len(fields) == 1 {
 		if _, err := oi.LongWriteString(writer, "error: must provide a <channel>\r\n"); err != nil {
 			return err
@@ -401,33 +707,748 @@ func (h *ConnectionHandler) parseDeleteChannelCmd(telnetConn *telnetconn.TelnetC
 		return nil
 	}
 
-	telnetConn.DeleteChannel(fields[1])
+	telnetConn.SwitchChannel(fields[1])
 	return nil
 }
 
-func (h *ConnectionHandler) handleConn(ctx gotelnet.Context, writer gotelnet.Writer, reader gotelnet.Reader, telnetConn *telnetconn.TelnetConn, c chan error) {
-	// NOTE: Assume all write errors mean the session has ended and should be swallowed
-	err := h.writePrompt(writer)
-	if err != nil {
-		c <- nil
-		return
-	}
+func (h *ConnectionHandler) parseWatchCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <channel>\r\n"); err != nil {
+			return err
+		}
 
-	// Create the buffer to hold user input
-	var buffer [1]byte
-	p := buffer[:]
-	var line bytes.Buffer
+		return nil
+	}
 
-	for {
-		// Read 1 byte.
-		n, err := reader.Read(p)
-		if err != nil {
-			c <- nil
-			return
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: <channel> must not contain spaces\r\n"); err != nil {
+			return err
 		}
 
-		if n <= 0 {
-			continue
+		return nil
+	}
+
+	telnetConn.WatchChannel(fields[1])
+	return nil
+}
+
+func (h *ConnectionHandler) parseUnwatchCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <channel>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: <channel> must not contain spaces\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.UnwatchChannel(fields[1])
+	return nil
+}
+
+func (h *ConnectionHandler) parseDirectMessageCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) < 3 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <user> and <text>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.PostDirectMessage(fields[1], strings.Join(fields[2:], " "))
+	return nil
+}
+
+func (h *ConnectionHandler) parseDirectMessagesCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: <user> must not contain spaces\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	// Without a user the conversations are listed
+	if len(fields) == 1 {
+		telnetConn.ShowConversations()
+		return nil
+	}
+
+	telnetConn.ShowDirectMessages(fields[1])
+	return nil
+}
+
+func (h *ConnectionHandler) parseGroupCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) < 2 {
+		if _, err := oi.LongWriteString(writer, "error: must provide at least one <user>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.CreateGroup(fields[1:])
+	return nil
+}
+
+func (h *ConnectionHandler) parseGroupMessageCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) < 3 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <group> and <text>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	groupID, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		if _, err := oi.LongWriteString(writer, "error: invalid <group>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.PostGroupMessage(groupID, strings.Join(fields[2:], " "))
+	return nil
+}
+
+func (h *ConnectionHandler) parsePostCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	// The channels come first, each marked with a #
+	channelnames := make([]string, 0)
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "#") || len(field) == 1 {
+			break
+		}
+		channelnames = append(channelnames, field[1:])
+	}
+
+	if len(channelnames) < 2 || len(fields) < len(channelnames)+2 {
+		if _, err := oi.LongWriteString(writer, "error: must provide at least two #<channel>s and <text>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.PostMessageMulti(channelnames, strings.Join(fields[len(channelnames)+1:], " "))
+	return nil
+}
+
+func (h *ConnectionHandler) parseGroupsCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: <group> must not contain spaces\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	// Without a group the groups are listed
+	if len(fields) == 1 {
+		telnetConn.ShowGroups()
+		return nil
+	}
+
+	groupID, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		if _, err := oi.LongWriteString(writer, "error: invalid <group>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.ShowGroupMessages(groupID)
+	return nil
+}
+
+func (h *ConnectionHandler) parseSplitCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: <channel> must not contain spaces\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	// Without a channel the split view is turned off
+	if len(fields) == 1 {
+		telnetConn.SplitChannel("")
+		return nil
+	}
+
+	telnetConn.SplitChannel(fields[1])
+	return nil
+}
+
+func (h *ConnectionHandler) parseChannelInfoCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 1 {
+		if _, err := oi.LongWriteString(writer, "error: unknown /channelinfo option\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.ShowChannelInfo()
+	return nil
+}
+
+func (h *ConnectionHandler) parseMembersCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 1 {
+		if _, err := oi.LongWriteString(writer, "error: unknown /members option\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.ShowChannelMembers()
+	return nil
+}
+
+func (h *ConnectionHandler) parseTopicCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <topic>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.SetChannelTopic(strings.Join(fields[1:], " "))
+	return nil
+}
+
+func (h *ConnectionHandler) parseRulesCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) < 3 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <language> and <rules>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.SetChannelRules(fields[1], strings.Join(fields[2:], " "))
+	return nil
+}
+
+func (h *ConnectionHandler) parseNotesCmd(telnetConn *telnetconn.TelnetConn, confirm *confirmation, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 {
+		telnetConn.ShowChannelNotes()
+		return nil
+	}
+
+	if fields[1] != "add" && fields[1] != "set" {
+		if _, err := oi.LongWriteString(writer, "error: unknown /notes option\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	if len(fields) == 2 && fields[1] == "add" {
+		if _, err := oi.LongWriteString(writer, "error: must provide <text>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	text := strings.Join(fields[2:], " ")
+	if fields[1] == "add" {
+		telnetConn.SetChannelNotes(text, true)
+		return nil
+	}
+
+	// Setting the notes replaces everyone's edits
+	return confirm.ask(writer, "replace the notes of the current channel?", func() {
+		telnetConn.SetChannelNotes(text, false)
+	})
+}
+
+func (h *ConnectionHandler) parseChannelHistoryCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 {
+		if _, err := oi.LongWriteString(writer, "error: must provide <num messages>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: unknown /channelhistory option\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	numMessages, err := strconv.Atoi(fields[1])
+	if err != nil || numMessages < -1 {
+		if _, err := oi.LongWriteString(writer, "error: invalid <num messages>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.ShowChannelHistory(numMessages)
+	return nil
+}
+
+func (h *ConnectionHandler) parseHistoryCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 3 || fields[1] != "since" {
+		if _, err := oi.LongWriteString(writer, "error: usage is /history since <duration>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	// Durations can also be given in days
+	var duration time.Duration
+	var err error
+	if strings.HasSuffix(fields[2], "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(fields[2], "d"))
+		duration = time.Duration(days) * 24 * time.Hour
+	} else {
+		duration, err = time.ParseDuration(fields[2])
+	}
+
+	if err != nil || duration <= 0 {
+		if _, err := oi.LongWriteString(writer, "error: invalid <duration>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.ShowChannelHistorySince(h.model.Now().Add(-duration))
+	return nil
+}
+
+func (h *ConnectionHandler) parseLeaderboardCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: unknown /leaderboard option\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	period := "week"
+	if len(fields) == 2 {
+		period = fields[1]
+	}
+
+	since, ok := model.PeriodSince(period, h.model.Now())
+	if !ok {
+		if _, err := oi.LongWriteString(writer, "error: period must be day, week, month or all\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.ShowLeaderboard(since)
+	return nil
+}
+
+func (h *ConnectionHandler) parseKarmaCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: unknown /karma option\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	username := ""
+	if len(fields) == 2 {
+		username = fields[1]
+	}
+
+	telnetConn.ShowKarma(username)
+	return nil
+}
+
+func (h *ConnectionHandler) parseMuteChannelCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <channel>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: <channel> must not contain spaces\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.MuteChannel(fields[1])
+	return nil
+}
+
+func (h *ConnectionHandler) parseUnmuteChannelCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <channel>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: <channel> must not contain spaces\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.UnmuteChannel(fields[1])
+	return nil
+}
+
+func (h *ConnectionHandler) parseCreateChannelCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <channel>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.CreateChannel(fields[1], strings.Join(fields[2:], " "))
+	return nil
+}
+
+func (h *ConnectionHandler) parseRestoreChannelCmd(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) == 1 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <channel>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	if len(fields) > 2 {
+		if _, err := oi.LongWriteString(writer, "error: <channel> must not contain spaces\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	telnetConn.RestoreChannel(fields[1])
+	return nil
+}
+
+func (h *ConnectionHandler) parseRenameUserCmd(telnetConn *telnetconn.TelnetConn, confirm *confirmation, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 2 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <newuser>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	// The old name is freed for anyone to take
+	newUsername := fields[1]
+	return confirm.ask(writer, "rename the current user to '"+newUsername+"'?", func() {
+		telnetConn.RenameUser(newUsername)
+	})
+}
+
+func (h *ConnectionHandler) parseRenameChannelCmd(telnetConn *telnetconn.TelnetConn, confirm *confirmation, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 3 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <channel> and <newchannel>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	// Unknown channels are reported right away, there's nothing to confirm
+	channelname, newChannelname := fields[1], fields[2]
+	if _, ok := h.model.GetChannels()[channelname]; !ok {
+		telnetConn.RenameChannel(channelname, newChannelname)
+		return nil
+	}
+
+	return confirm.ask(writer, "rename channel '"+channelname+"' to '"+newChannelname+"' for everyone?", func() {
+		telnetConn.RenameChannel(channelname, newChannelname)
+	})
+}
+
+func (h *ConnectionHandler) parseSnippetCmd(pending *snippet, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 2 {
+		if _, err := oi.LongWriteString(writer, "error: must provide a <language>\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	return pending.start(writer, fields[1])
+}
+
+func (h *ConnectionHandler) parseFullscreenCmd(telnetConn *telnetconn.TelnetConn, screen *screen, protocol *protocolConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 1 {
+		if _, err := oi.LongWriteString(writer, "error: unknown /fullscreen option\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	if telnetConn.ScreenReader() {
+		_, err := oi.LongWriteString(writer, "error: full screen view isn't available in screen reader mode\r\n")
+		return err
+	}
+
+	ok, err := screen.enable(!screen.isEnabled(), protocol.terminal())
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		_, err := oi.LongWriteString(writer, "error: full screen view needs a terminal that reports its type and a size of at least "+strconv.Itoa(minScreenWidth)+"x"+strconv.Itoa(minScreenHeight)+"\r\n")
+		return err
+	}
+
+	// The terminal was cleared, show where we are again
+	telnetConn.Refresh()
+	return nil
+}
+
+func (h *ConnectionHandler) parseScreenReaderCmd(telnetConn *telnetconn.TelnetConn, screen *screen, protocol *protocolConn, writer gotelnet.Writer, fields []string) error {
+	if len(fields) != 1 {
+		if _, err := oi.LongWriteString(writer, "error: unknown /screenreader option\r\n"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	// Screen readers follow the text as it scrolls, so the full screen view is turned off
+	enabled := !telnetConn.ScreenReader()
+	if enabled && screen.isEnabled() {
+		if _, err := screen.enable(false, protocol.terminal()); err != nil {
+			return err
+		}
+	}
+
+	telnetConn.SetScreenReader(enabled)
+	return nil
+}
+
+// dispatchCmd runs a single command line against the telnet connection.  A panic while handling
+// the command is recovered (and logged with a stack trace) so one bad command can't take down the
+// server; the client is told about the error and the session carries on.
+func (h *ConnectionHandler) dispatchCmd(telnetConn *telnetconn.TelnetConn, confirm *confirmation, pending *snippet, screen *screen, protocol *protocolConn, writer gotelnet.Writer, fields []string, lineString string) (exit bool, err error) {
+	// Messages are traced without their text
+	traceName := "post"
+	if confirm.action != nil {
+		traceName = "confirm"
+	} else if fields[0][0] == '/' {
+		traceName = fields[0]
+	}
+	span := h.tracer.StartRequest("telnet "+traceName, map[string]string{"telnet.command": traceName})
+	defer span.End()
+
+	defer func() {
+		if r := recover(); r != nil {
+			span.SetAttribute("error", "panic")
+			log.Printf("panic handling telnet command %q: %v\n%s", fields[0], r, debug.Stack())
+			_, err = oi.LongWriteString(writer, "error: internal error\r\n")
+		}
+	}()
+
+	// A pending destructive command is carried out or cancelled by the next line
+	if confirm.action != nil {
+		return false, confirm.answer(writer, fields)
+	}
+
+	command := fields[0]
+
+	// A pasted fenced block (a line of just "```<language>") is collected as a snippet
+	if len(fields) == 1 && strings.HasPrefix(command, "```") {
+		language := strings.TrimPrefix(command, "```")
+		if language == "" {
+			language = defaultSnippetLanguage
+		}
+
+		return false, pending.start(writer, language)
+	}
+
+	switch command {
+	case "/help":
+		err = h.parseHelpCmd(telnetConn, writer, fields)
+	case "/users":
+		err = h.parseUsersCmd(telnetConn, writer, fields)
+	case "/user":
+		err = h.parseUserCmd(telnetConn, writer, fields)
+	case "/login":
+		err = h.parseLoginCmd(telnetConn, writer, fields)
+	case "/logout":
+		err = h.parseLogoutCmd(telnetConn, writer, fields)
+	case "/register":
+		err = h.parseRegisterCmd(telnetConn, writer, fields)
+	case "/totp":
+		err = h.parseTOTPCmd(telnetConn, writer, fields)
+	case "/userinfo":
+		err = h.parseUserInfoCmd(telnetConn, writer, fields)
+	case "/profile":
+		err = h.parseProfileCmd(telnetConn, writer, fields)
+	case "/status":
+		err = h.parseStatusCmd(telnetConn, writer, fields)
+	case "/away":
+		err = h.parseAwayCmd(telnetConn, writer, fields)
+	case "/createuser":
+		err = h.parseCreateUserCmd(telnetConn, writer, fields)
+	case "/renameuser":
+		err = h.parseRenameUserCmd(telnetConn, confirm, writer, fields)
+	case "/blockuser":
+		err = h.parseBlockUserCmd(telnetConn, writer, fields)
+	case "/unblockuser":
+		err = h.parseUnblockUserCmd(telnetConn, writer, fields)
+	case "/dm":
+		err = h.parseDirectMessageCmd(telnetConn, writer, fields)
+	case "/dms":
+		err = h.parseDirectMessagesCmd(telnetConn, writer, fields)
+	case "/group":
+		err = h.parseGroupCmd(telnetConn, writer, fields)
+	case "/gm":
+		err = h.parseGroupMessageCmd(telnetConn, writer, fields)
+	case "/groups":
+		err = h.parseGroupsCmd(telnetConn, writer, fields)
+	case "/events":
+		err = h.parseEventsCmd(telnetConn, writer, fields)
+	case "/event":
+		err = h.parseEventCmd(telnetConn, writer, fields)
+	case "/rsvp":
+		err = h.parseRSVPCmd(telnetConn, writer, fields)
+	case "/mentions":
+		err = h.parseMentionsCmd(telnetConn, writer, fields)
+	case "/security":
+		err = h.parseSecurityCmd(telnetConn, writer, fields)
+	case "/starred":
+		err = h.parseStarredCmd(telnetConn, writer, fields)
+	case "/pins":
+		err = h.parsePinsCmd(telnetConn, writer, fields)
+	case "/channels":
+		err = h.parseChannelsCmd(telnetConn, writer, fields)
+	case "/browse":
+		err = h.parseBrowseCmd(telnetConn, writer, fields)
+	case "/join":
+		err = h.parseJoinCmd(telnetConn, writer, fields)
+	case "/leave":
+		err = h.parseLeaveCmd(telnetConn, writer, fields)
+	case "/channel":
+		err = h.parseChannelCmd(telnetConn, writer, fields)
+	case "/watch":
+		err = h.parseWatchCmd(telnetConn, writer, fields)
+	case "/unwatch":
+		err = h.parseUnwatchCmd(telnetConn, writer, fields)
+	case "/split":
+		err = h.parseSplitCmd(telnetConn, writer, fields)
+	case "/channelinfo":
+		err = h.parseChannelInfoCmd(telnetConn, writer, fields)
+	case "/members":
+		err = h.parseMembersCmd(telnetConn, writer, fields)
+	case "/topic":
+		err = h.parseTopicCmd(telnetConn, writer, fields)
+	case "/rules":
+		err = h.parseRulesCmd(telnetConn, writer, fields)
+	case "/notes":
+		err = h.parseNotesCmd(telnetConn, confirm, writer, fields)
+	case "/channelhistory":
+		err = h.parseChannelHistoryCmd(telnetConn, writer, fields)
+	case "/history":
+		err = h.parseHistoryCmd(telnetConn, writer, fields)
+	case "/snippet":
+		err = h.parseSnippetCmd(pending, writer, fields)
+	case "/post":
+		err = h.parsePostCmd(telnetConn, writer, fields)
+	case "/leaderboard":
+		err = h.parseLeaderboardCmd(telnetConn, writer, fields)
+	case "/karma":
+		err = h.parseKarmaCmd(telnetConn, writer, fields)
+	case "/mutechannel":
+		err = h.parseMuteChannelCmd(telnetConn, writer, fields)
+	case "/unmutechannel":
+		err = h.parseUnmuteChannelCmd(telnetConn, writer, fields)
+	case "/createchannel":
+		err = h.parseCreateChannelCmd(telnetConn, writer, fields)
+	case "/restorechannel":
+		err = h.parseRestoreChannelCmd(telnetConn, writer, fields)
+	case "/renamechannel":
+		err = h.parseRenameChannelCmd(telnetConn, confirm, writer, fields)
+	case "/fullscreen":
+		err = h.parseFullscreenCmd(telnetConn, screen, protocol, writer, fields)
+	case "/screenreader":
+		err = h.parseScreenReaderCmd(telnetConn, screen, protocol, writer, fields)
+	case "/exit":
+		return true, nil
+	default:
+		if command[0] == '/' {
+			_, err = oi.LongWriteString(writer, "error: unknown command\r\n")
+		} else {
+			telnetConn.PostMessage(strings.TrimSuffix(lineString, "\r\n"))
+		}
+	}
+
+	return false, err
+}
+
+func (h *ConnectionHandler) handleConn(ctx context.Context, writer gotelnet.Writer, reader gotelnet.Reader, telnetConn *telnetconn.TelnetConn, screen *screen, protocol *protocolConn, c chan error) {
+	// NOTE: Assume all write errors mean the session has ended and should be swallowed
+	err := h.writePrompt(writer)
+	if err != nil {
+		c <- nil
+		return
+	}
+
+	// Destructive commands wait here for the client to confirm them, and snippets for their lines
+	confirm := &confirmation{}
+	pending := &snippet{}
+
+	// Create the buffer to hold user input
+	var buffer [1]byte
+	p := buffer[:]
+	var line bytes.Buffer
+
+	for {
+		// Stop once the session has ended
+		if ctx.Err() != nil {
+			c <- nil
+			return
+		}
+
+		// Read 1 byte.
+		n, err := reader.Read(p)
+		if err != nil {
+			c <- nil
+			return
+		}
+
+		if n <= 0 {
+			continue
 		}
 
 		line.WriteByte(p[0])
@@ -437,52 +1458,17 @@ func (h *ConnectionHandler) handleConn(ctx gotelnet.Context, writer gotelnet.Wri
 			lineString := line.String()
 
 			fields := strings.Fields(lineString)
-			if len(fields) > 0 && lineString != "\r\n" {
-				// Parse the message
-				command := fields[0]
-
-				err = nil
-				switch command {
-				case "/help":
-					err = h.parseHelpCmd(telnetConn, writer, fields)
-				case "/users":
-					err = h.parseUsersCmd(telnetConn, writer, fields)
-				case "/user":
-					err = h.parseUserCmd(telnetConn, writer, fields)
-				case "/userinfo":
-					err = h.parseUserInfoCmd(telnetConn, writer, fields)
-				case "/createuser":
-					err = h.parseCreateUserCmd(telnetConn, writer, fields)
-				case "/deleteuser":
-					err = h.parseDeleteUserCmd(telnetConn, writer, fields)
-				case "/blockuser":
-					err = h.parseBlockUserCmd(telnetConn, writer, fields)
-				case "/unblockuser":
-					err = h.parseUnblockUserCmd(telnetConn, writer, fields)
-				case "/channels":
-					err = h.parseChannelsCmd(telnetConn, writer, fields)
-				case "/channel":
-					err = h.parseChannelCmd(telnetConn, writer, fields)
-				case "/channelinfo":
-					err = h.parseChannelInfoCmd(telnetConn, writer, fields)
-				case "/channelhistory":
-					err = h.parseChannelHistoryCmd(telnetConn, writer, fields)
-				case "/createchannel":
-					err = h.parseCreateChannelCmd(telnetConn, writer, fields)
-				case "/deletechannel":
-					err = h.parseDeleteChannelCmd(telnetConn, writer, fields)
-				case "/exit":
+			if pending.collecting {
+				// The lines of a snippet are kept as they are (blank and indented ones included)
+				err := pending.add(telnetConn, writer, strings.TrimRight(lineString, "\r\n"))
+				if err != nil {
 					c <- nil
 					return
-				default:
-					if command[0] == '/' {
-						_, err = oi.LongWriteString(writer, "error: unknown command\r\n")
-					} else {
-						telnetConn.PostMessage(strings.TrimSuffix(lineString, "\r\n"))
-					}
 				}
-
-				if err != nil {
+			} else if len(fields) > 0 && lineString != "\r\n" {
+				// Parse the message
+				exit, err := h.dispatchCmd(telnetConn, confirm, pending, screen, protocol, writer, fields, lineString)
+				if exit || err != nil {
 					c <- nil
 					return
 				}
@@ -498,3 +1484,78 @@ func (h *ConnectionHandler) handleConn(ctx gotelnet.Context, writer gotelnet.Wri
 		}
 	}
 }
+
+// defaultSnippetLanguage is the language of a pasted fenced block that doesn't name one.
+const defaultSnippetLanguage string = "text"
+
+// maxSnippetLines is the most lines a snippet can have, so a paste that's never closed doesn't
+// grow without bound.
+const maxSnippetLines int = 1000
+
+// snippet is a connection's snippet being collected.  Starting one sets it, and the lines the
+// client sends are its code until a line of just "```", which posts it.
+type snippet struct {
+	collecting bool
+	language   string
+	lines      []string
+}
+
+// start starts collecting a snippet in a language.
+func (s *snippet) start(writer gotelnet.Writer, language string) error {
+	s.collecting = true
+	s.language = language
+	s.lines = nil
+
+	_, err := oi.LongWriteString(writer, "snippet in "+language+", end it with a ``` line\r\n")
+	return err
+}
+
+// add adds a line to the snippet, or posts the snippet if it's the closing fence.  The lines past
+// the most a snippet can have are dropped (keeping one over, to tell it was too long when it's
+// closed).
+func (s *snippet) add(telnetConn *telnetconn.TelnetConn, writer gotelnet.Writer, line string) error {
+	if strings.TrimSpace(line) != "```" {
+		if len(s.lines) <= maxSnippetLines {
+			s.lines = append(s.lines, line)
+		}
+
+		return nil
+	}
+
+	s.collecting = false
+	if len(s.lines) > maxSnippetLines {
+		_, err := oi.LongWriteString(writer, "error: snippet too long\r\n")
+		return err
+	}
+
+	telnetConn.PostSnippet(s.language, strings.Join(s.lines, "\n"))
+	return nil
+}
+
+// confirmation is a connection's pending destructive command.  Asking for confirmation sets it,
+// and the next line the client sends either confirms it ("yes") or cancels it.
+type confirmation struct {
+	action func()
+}
+
+// ask sets the pending command and asks the client to confirm it.
+func (c *confirmation) ask(writer gotelnet.Writer, question string, action func()) error {
+	c.action = action
+
+	_, err := oi.LongWriteString(writer, question+" type 'yes' to confirm\r\n")
+	return err
+}
+
+// answer carries out or cancels the pending command.
+func (c *confirmation) answer(writer gotelnet.Writer, fields []string) error {
+	action := c.action
+	c.action = nil
+
+	if len(fields) == 1 && fields[0] == "yes" {
+		action()
+		return nil
+	}
+
+	_, err := oi.LongWriteString(writer, "cancelled\r\n")
+	return err
+}
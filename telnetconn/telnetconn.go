@@ -4,14 +4,23 @@
 package telnetconn
 
 import (
+	"chatserver/bots"
+	"chatserver/credentials"
+	"chatserver/drafts"
 	"chatserver/model"
+	"chatserver/pins"
+	"chatserver/preferences"
+	"chatserver/reactions"
+	"chatserver/security"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 const defaultHistoricalMessages int = 10
+const defaultLeaderboardPosters int = 10
 const defaultSeparator string = "-----------------"
 
 // PrintLinesCallback is the function signature that clients will provide in order
@@ -24,39 +33,69 @@ type PrintLinesCallback = func(lines []string)
 type TelnetConn struct {
 	model                      *model.Model
 	printLinesCallback         PrintLinesCallback
+	credentials                *credentials.Credentials
+	address                    string
 	currentUser                string
+	loggedIn                   bool
 	currentChannel             string
 	currentChannelMessageIndex int
+	lastMessageDay             string
+	watchedChannels            map[string]int
+	splitChannel               string
+	splitChannelMessageIndex   int
+	directMessageCounts        map[string]uint64
+	groupMessageCounts         map[uint64]uint64
+	connectionID               uint64
 	mutex                      sync.Mutex
 }
 
 // NewTelnetConn creates/initializes/returns a new TelnetConn.  It will default the
-// connection to the "Anonymous" user as well as the "General" channel.
-func NewTelnetConn(model *model.Model, printLinesCallback PrintLinesCallback) *TelnetConn {
+// connection to the model's built-in user as well as its built-in channel.  Registered accounts
+// (users with a password in the credentials) can only be used after logging in (the credentials
+// are nil when passwords are disabled).
+func NewTelnetConn(model *model.Model, credentials *credentials.Credentials, printLinesCallback PrintLinesCallback) *TelnetConn {
 	telnetConn := TelnetConn{
 		model:                      model,
 		printLinesCallback:         printLinesCallback,
+		credentials:                credentials,
 		currentUser:                "None",
 		currentChannel:             "None",
 		currentChannelMessageIndex: 0,
+		watchedChannels:            make(map[string]int),
+		directMessageCounts:        make(map[string]uint64),
+		groupMessageCounts:         make(map[uint64]uint64),
+		connectionID:               model.Connect(model.BuiltinUsername()),
 	}
 
-	// Default to the Anonymous user
-	telnetConn.SwitchUser("Anonymous")
+	// Default to the built-in user
+	telnetConn.SwitchUser(model.BuiltinUsername())
 
 	return &telnetConn
 }
 
+// SetAddress sets the address the client connects from (its IP), which failed logins are tracked
+// by along with the account (see credentials.Throttle).
+func (t *TelnetConn) SetAddress(address string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.address = address
+}
+
+// Close disconnects the connection from the model, so its user is no longer online from it.
+func (t *TelnetConn) Close() {
+	t.model.Disconnect(t.connectionID)
+}
+
 // OnUsersChanged is called whenever the users state changes in the model.
 func (t *TelnetConn) OnUsersChanged() {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	users := t.model.GetUsers()
-
-	// If our current user has been deleted, switch to Anonymous
-	if _, ok := users[t.currentUser]; !ok {
-		t.switchUser("Anonymous")
+	// If our current user has been deleted, switch to the built-in user
+	if t.model.GetUserInfo(t.currentUser).Name == "" {
+		t.loggedIn = false
+		t.switchUser(t.model.BuiltinUsername())
 	}
 }
 
@@ -72,6 +111,26 @@ func (t *TelnetConn) OnUserChanged(username string) {
 	}
 }
 
+// OnUserRenamed is called whenever a user is renamed in the model.  The connection keeps acting as
+// the current user under their new name, and keeps its place in the conversations with the user.
+func (t *TelnetConn) OnUserRenamed(username string, newUsername string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if numMessages, ok := t.directMessageCounts[username]; ok {
+		delete(t.directMessageCounts, username)
+		t.directMessageCounts[newUsername] = numMessages
+	}
+
+	if t.currentUser == username {
+		t.currentUser = newUsername
+
+		msg := make([]string, 0)
+		msg = append(msg, "user '"+username+"' renamed to '"+newUsername+"'")
+		t.printLinesCallback(msg)
+	}
+}
+
 // OnChannelsChanged is called whenever the channels state changes in the model.
 func (t *TelnetConn) OnChannelsChanged() {
 	t.mutex.Lock()
@@ -79,9 +138,58 @@ func (t *TelnetConn) OnChannelsChanged() {
 
 	channels := t.model.GetChannels()
 
-	// If our current channel has been deleted, switch to General
+	// Stop watching the channels that have been deleted
+	for channelname := range t.watchedChannels {
+		if _, ok := channels[channelname]; !ok {
+			delete(t.watchedChannels, channelname)
+
+			msg := make([]string, 0)
+			msg = append(msg, "channel '"+channelname+"' deleted, no longer watching it")
+			t.printLinesCallback(msg)
+		}
+	}
+
+	// Leave the split view if its other channel has been deleted
+	if _, ok := channels[t.splitChannel]; !ok && t.splitChannel != "" {
+		msg := make([]string, 0)
+		msg = append(msg, "channel '"+t.splitChannel+"' deleted, split view off")
+		t.printLinesCallback(msg)
+		t.splitChannel = ""
+	}
+
+	// If our current channel has been deleted, switch to the built-in channel
 	if _, ok := channels[t.currentChannel]; !ok {
-		t.switchChannel("General")
+		t.switchChannel(t.model.BuiltinChannelname())
+	}
+}
+
+// OnChannelRenamed is called whenever a channel is renamed in the model.  The connection follows
+// the channel to its new name, whether it's being viewed, shown in the split view or watched.
+func (t *TelnetConn) OnChannelRenamed(channelname string, newChannelname string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	following := false
+	if t.currentChannel == channelname {
+		t.currentChannel = newChannelname
+		following = true
+	}
+
+	if t.splitChannel == channelname {
+		t.splitChannel = newChannelname
+		following = true
+	}
+
+	if messageIndex, ok := t.watchedChannels[channelname]; ok {
+		delete(t.watchedChannels, channelname)
+		t.watchedChannels[newChannelname] = messageIndex
+		following = true
+	}
+
+	if following {
+		msg := make([]string, 0)
+		msg = append(msg, "channel '"+channelname+"' renamed to '"+newChannelname+"'")
+		t.printLinesCallback(msg)
 	}
 }
 
@@ -96,289 +204,1601 @@ func (t *TelnetConn) OnChannelChanged(channelname string) {
 		numNewMessages := channelInfo.NumMessages - t.currentChannelMessageIndex
 		t.showChannelHistory(numNewMessages)
 	}
-}
-
-// ShowUsers will print a list of all of the users in the model.
-func (t *TelnetConn) ShowUsers() {
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
-
-	users := t.model.GetUsers()
 
-	// Sort the users alphabetically
-	sortedUsers := make([]string, 0)
-	for user := range users {
-		sortedUsers = append(sortedUsers, user)
+	// If the other channel of the split view has changed, show its new messages along with the
+	// current channel's
+	if t.splitChannel == channelname {
+		channelInfo := t.model.GetChannelInfo(channelname)
+		numNewMessages := channelInfo.NumMessages - t.splitChannelMessageIndex
+		t.splitChannelMessageIndex = channelInfo.NumMessages
+
+		if numNewMessages > 0 && t.currentChannel != channelname {
+			msg := make([]string, 0)
+			for _, message := range t.model.GetChannelHistory(channelname, t.currentUser, numNewMessages) {
+				msg = append(msg, t.formatMessage(channelname, message))
+			}
+			t.printLinesCallback(msg)
+		}
 	}
-	sort.Strings(sortedUsers)
 
-	// Tell the client about the users
-	msg := make([]string, 0)
-	msg = append(msg, defaultSeparator)
-	for _, user := range sortedUsers {
-		if user == t.currentUser {
-			msg = append(msg, "--> "+user+" <--")
-		} else {
-			msg = append(msg, user)
+	// If a watched channel has changed, show its new messages inline (unless it's being viewed,
+	// or already shown in the split view)
+	if messageIndex, ok := t.watchedChannels[channelname]; ok {
+		channelInfo := t.model.GetChannelInfo(channelname)
+		numNewMessages := channelInfo.NumMessages - messageIndex
+		t.watchedChannels[channelname] = channelInfo.NumMessages
+
+		if numNewMessages > 0 && t.currentChannel != channelname && t.splitChannel != channelname {
+			msg := make([]string, 0)
+			for _, message := range t.model.GetChannelHistory(channelname, t.currentUser, numNewMessages) {
+				msg = append(msg, t.formatMessage(channelname, message))
+			}
+			t.printLinesCallback(msg)
 		}
 	}
-	msg = append(msg, defaultSeparator)
-	t.printLinesCallback(msg)
 }
 
-// SwitchUser will change the user that is associated with the current telnet view connection.
-func (t *TelnetConn) SwitchUser(username string) {
+// OnMentioned is called whenever the current user is mentioned in a channel message.  The message
+// is shown inline, unless its channel is being viewed (or watched) and it's shown there anyway.
+func (t *TelnetConn) OnMentioned(channelname string, messageID uint64) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	// Call the private (lock held) version
-	t.switchUser(username)
+	if t.currentChannel == channelname || t.splitChannel == channelname {
+		return
+	}
+	if _, ok := t.watchedChannels[channelname]; ok {
+		return
+	}
+
+	message, ok := t.model.GetMessage(channelname, t.currentUser, messageID)
+	if !ok {
+		return
+	}
+	t.printLinesCallback([]string{"you were mentioned in " + channelname + ":", t.formatMessage(channelname, message)})
 }
 
-// ShowUserInfo will print information associated with the current user.
-func (t *TelnetConn) ShowUserInfo() {
+// OnSecurityEvent is called whenever there's a security event on the current user's account (e.g.
+// a login from a new address).  The event is shown inline.
+func (t *TelnetConn) OnSecurityEvent() {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	userInfo := t.model.GetUserInfo(t.currentUser)
-
-	// Sort the blocked users alphabetically
-	sort.Strings(userInfo.BlockedUsers)
-
-	// Tell the client about the user info
-	msg := make([]string, 0)
-	msg = append(msg, defaultSeparator)
-	msg = append(msg, "User: "+userInfo.Name)
-	msg = append(msg, "Blocked Users:")
-	for _, blockedUser := range userInfo.BlockedUsers {
-		msg = append(msg, "    "+blockedUser)
+	events := security.Get(t.model, t.currentUser)
+	if len(events) == 0 {
+		return
 	}
-	msg = append(msg, defaultSeparator)
-	t.printLinesCallback(msg)
+	t.printLinesCallback([]string{"security: " + events[len(events)-1].Text})
 }
 
-// CreateUser will create a new user.
-func (t *TelnetConn) CreateUser(username string) {
+// OnMessageChanged is called whenever a message in a channel is edited or deleted.  If the message
+// was already shown (in the current channel, the split view or a watched channel), it's shown again
+// with its new text (marked as edited), or as deleted.
+func (t *TelnetConn) OnMessageChanged(channelname string, messageID uint64) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	users := t.model.GetUsers()
+	// Figure out how many of the channel's messages have been shown
+	var numShownMessages int
+	switch {
+	case t.currentChannel == channelname:
+		numShownMessages = t.currentChannelMessageIndex
+	case t.splitChannel == channelname:
+		numShownMessages = t.splitChannelMessageIndex
+	default:
+		messageIndex, ok := t.watchedChannels[channelname]
+		if !ok {
+			return
+		}
+		numShownMessages = messageIndex
+	}
 
-	// Validate the user input
-	if _, ok := users[username]; ok {
-		msg := make([]string, 0)
-		msg = append(msg, "error: <user> already exists")
-		t.printLinesCallback(msg)
+	// Messages that haven't been shown yet will be shown as they are now
+	message, ok := t.model.GetMessage(channelname, t.currentUser, messageID)
+	if !ok || message.Seq > uint64(numShownMessages) {
 		return
 	}
 
-	// Tell the model about the new user
-	t.model.CreateUser(username)
+	// The current channel's messages are only shown with the channel name in the split view
+	shownChannelname := channelname
+	if t.currentChannel == channelname && (t.splitChannel == "" || t.splitChannel == t.currentChannel) {
+		shownChannelname = ""
+	}
+
+	msg := make([]string, 0)
+	msg = append(msg, t.formatMessage(shownChannelname, message))
+	t.printLinesCallback(msg)
 }
 
-// DeleteUser will delete an existing user.
-func (t *TelnetConn) DeleteUser(username string) {
+// CurrentUser returns the current user (whose direct messages the connection is notified of).
+func (t *TelnetConn) CurrentUser() string {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	users := t.model.GetUsers()
-
-	// Validate the user input
-	if _, ok := users[username]; !ok {
-		msg := make([]string, 0)
-		msg = append(msg, "error: <user> not found")
-		t.printLinesCallback(msg)
-		return
-	}
-
-	// Delete the user in the model
-	t.model.DeleteUser(username)
+	return t.currentUser
 }
 
-// BlockUser will add a new user to the current user's blocked user list.
-func (t *TelnetConn) BlockUser(username string) {
+// IsChannelMuted returns whether the current user has muted a channel, so its changes aren't shown.
+// The channels being viewed (the current channel and the split view) are shown regardless.
+func (t *TelnetConn) IsChannelMuted(channelname string) bool {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	users := t.model.GetUsers()
-
-	// Validate the user input
-	if _, ok := users[username]; !ok {
-		msg := make([]string, 0)
-		msg = append(msg, "error: <user> not found")
-		t.printLinesCallback(msg)
-		return
+	if t.currentChannel == channelname || t.splitChannel == channelname {
+		return false
 	}
 
-	t.model.BlockUser(t.currentUser, username)
+	return t.model.IsChannelMuted(t.currentUser, channelname)
 }
 
-// UnblockUser will delete an existing user from the current user's blocked user list.
-func (t *TelnetConn) UnblockUser(username string) {
+// OnDirectMessagesChanged is called whenever the direct messages between the current user and
+// another user change in the model.  The new messages are shown inline.
+func (t *TelnetConn) OnDirectMessagesChanged(otherUsername string) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	users := t.model.GetUsers()
-
-	// Validate the user input
-	if _, ok := users[username]; !ok {
-		msg := make([]string, 0)
-		msg = append(msg, "error: <user> not found")
-		t.printLinesCallback(msg)
+	numMessages := t.model.GetConversations(t.currentUser)[otherUsername]
+	if numMessages <= t.directMessageCounts[otherUsername] {
 		return
 	}
+	numNewMessages := numMessages - t.directMessageCounts[otherUsername]
+	t.directMessageCounts[otherUsername] = numMessages
 
-	t.model.UnblockUser(t.currentUser, username)
+	msg := make([]string, 0)
+	for _, message := range t.model.GetDirectMessageHistory(t.currentUser, otherUsername, int(numNewMessages)) {
+		msg = append(msg, t.formatMessage("DM "+otherUsername, message))
+	}
+	t.printLinesCallback(msg)
 }
 
-// ShowChannels will print a list of all of the channels in the model.
-func (t *TelnetConn) ShowChannels() {
+// OnGroupChanged is called whenever a group the current user is a member of is created, or its
+// messages change, in the model.  New groups and new messages are shown inline.
+func (t *TelnetConn) OnGroupChanged(groupID uint64) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	channels := t.model.GetChannels()
+	msg := make([]string, 0)
+	var numMessages uint64
+	for _, group := range t.model.GetGroups(t.currentUser) {
+		if group.ID != groupID {
+			continue
+		}
 
-	// Sort the channels alphabetically
-	sortedChannels := make([]string, 0)
-	for channel := range channels {
-		sortedChannels = append(sortedChannels, channel)
+		numMessages = group.NumMessages
+		if _, ok := t.groupMessageCounts[groupID]; !ok {
+			msg = append(msg, "added to group "+strconv.FormatUint(groupID, 10)+": "+strings.Join(group.Members, ", "))
+			t.groupMessageCounts[groupID] = 0
+		}
 	}
-	sort.Strings(sortedChannels)
 
-	// Tell the client about the channels
-	msg := make([]string, 0)
-	msg = append(msg, defaultSeparator)
-	for _, channel := range sortedChannels {
-		if channel == t.currentChannel {
-			msg = append(msg, "--> "+channel+" <--")
-		} else {
-			msg = append(msg, channel)
+	if numMessages > t.groupMessageCounts[groupID] {
+		numNewMessages := numMessages - t.groupMessageCounts[groupID]
+		t.groupMessageCounts[groupID] = numMessages
+		for _, message := range t.model.GetGroupMessageHistory(groupID, t.currentUser, int(numNewMessages)) {
+			msg = append(msg, t.formatMessage("group "+strconv.FormatUint(groupID, 10), message))
 		}
 	}
-	msg = append(msg, defaultSeparator)
+
+	if len(msg) == 0 {
+		return
+	}
 	t.printLinesCallback(msg)
 }
 
-// SwitchChannel will change the channel that the current user is viewing.
-func (t *TelnetConn) SwitchChannel(channelname string) {
+// Status returns the current user, the current channel and the channels joined by the current
+// user (sorted).
+func (t *TelnetConn) Status() (string, string, []string) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	// Call the private (lock held) version
-	t.switchChannel(channelname)
+	channels := make([]string, 0)
+	for channelname := range t.model.GetJoinedChannels(t.currentUser) {
+		channels = append(channels, channelname)
+	}
+	sort.Strings(channels)
+
+	return t.currentUser, t.currentChannel, channels
 }
 
-// ShowChannelInfo will print information associated with the current channel.
-func (t *TelnetConn) ShowChannelInfo() {
+// Refresh will print the current user and channel, and the recent channel history, again (e.g.
+// after the client's screen was cleared).
+func (t *TelnetConn) Refresh() {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	channelInfo := t.model.GetChannelInfo(t.currentChannel)
-
-	// Tell the client about the channel info
 	msg := make([]string, 0)
-	msg = append(msg, defaultSeparator)
-	msg = append(msg, "Channel: "+channelInfo.Name)
-	msg = append(msg, "Messages: "+strconv.Itoa(channelInfo.NumMessages))
-	msg = append(msg, defaultSeparator)
+	msg = t.appendSeparator(msg)
+	msg = append(msg, "User: "+t.currentUser)
+	msg = append(msg, "Channel: "+t.currentChannel)
+	msg = t.appendSeparator(msg)
 	t.printLinesCallback(msg)
+
+	t.lastMessageDay = ""
+	t.showChannelHistory(defaultHistoricalMessages)
 }
 
-// ShowChannelHistory will print up to 'numMessages' worth of history from the current channel
-// (NOTE: '-1' will print all messages).
-func (t *TelnetConn) ShowChannelHistory(numMessages int) {
+// ShowUsers will print a list of all of the users in the model.
+func (t *TelnetConn) ShowUsers() {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	// Call the private (lock held) version
-	t.showChannelHistory(numMessages)
+	users := t.model.GetUsers()
+	statuses := t.model.GetUserStatuses()
+
+	// Sort the users alphabetically
+	sortedUsers := make([]string, 0)
+	for user := range users {
+		sortedUsers = append(sortedUsers, user)
+	}
+	sort.Strings(sortedUsers)
+
+	// Tell the client about the users
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	for _, user := range sortedUsers {
+		line := user
+		if user == t.currentUser {
+			line = t.markCurrent(user)
+		}
+		if status := t.formatStatus(statuses[user]); status != "" {
+			line += "  " + status
+		}
+		msg = append(msg, line)
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
 }
 
-// CreateChannel will create a new channel.
-func (t *TelnetConn) CreateChannel(channelname string) {
+// SwitchUser will change the user that is associated with the current telnet view connection.
+func (t *TelnetConn) SwitchUser(username string) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	channels := t.model.GetChannels()
+	// The identity of a logged in connection is fixed until it logs out
+	if t.loggedIn {
+		msg := make([]string, 0)
+		msg = append(msg, "error: logged in as "+t.currentUser+", /logout first")
+		t.printLinesCallback(msg)
+		return
+	}
 
-	// Validate the user input
-	if _, ok := channels[channelname]; ok {
+	// Registered accounts need their password
+	if t.credentials != nil && t.credentials.HasPassword(username) {
 		msg := make([]string, 0)
-		msg = append(msg, "error: <channel> already exists")
+		msg = append(msg, "error: <user> is a registered account, use /login")
 		t.printLinesCallback(msg)
 		return
 	}
 
-	// Tell the model about the new channel
-	t.model.CreateChannel(channelname)
+	// Call the private (lock held) version
+	t.switchUser(username)
 }
 
-// DeleteChannel will delete an existing channel.
-func (t *TelnetConn) DeleteChannel(channelname string) {
+// Login will switch to a registered account if the password (and, with two-factor authentication
+// enabled, the code) is correct, and keep the connection bound to it until Logout.
+func (t *TelnetConn) Login(username string, password string, code string) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	channels := t.model.GetChannels()
+	if t.credentials == nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: passwords are disabled")
+		t.printLinesCallback(msg)
+		return
+	}
 
-	// Validate the user input
-	if _, ok := channels[channelname]; !ok {
+	err := t.credentials.Login(username, password, code, t.address)
+	if err == credentials.ErrThrottled {
 		msg := make([]string, 0)
-		msg = append(msg, "error: <channel> not found")
+		msg = append(msg, "error: "+err.Error())
+		t.printLinesCallback(msg)
+		return
+	} else if err != nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: invalid <user>, <password> or <code>")
 		t.printLinesCallback(msg)
 		return
 	}
 
-	// Delete the channel in the model
-	t.model.DeleteChannel(channelname)
+	t.switchUser(username)
+	if t.currentUser != username {
+		return
+	}
+	t.loggedIn = true
+
+	msg := make([]string, 0)
+	msg = append(msg, "logged in as "+username)
+	t.printLinesCallback(msg)
 }
 
-// PostMessage will post a new message to the current channel by the current user.
-func (t *TelnetConn) PostMessage(text string) {
+// Register will create a new registered account with the password and log in to it, staying in
+// the current channel (so a guest can claim a name mid-conversation, with their messages from
+// then on posted as the new user).
+func (t *TelnetConn) Register(username string, password string) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	t.model.PostMessage(t.currentChannel, t.currentUser, time.Now(), text)
-}
+	if t.credentials == nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: passwords are disabled")
+		t.printLinesCallback(msg)
+		return
+	}
 
-func (t *TelnetConn) showChannelHistory(numMessages int) {
-	// This will always bring us up to date with the channel messages
-	channelInfo := t.model.GetChannelInfo(t.currentChannel)
-	t.currentChannelMessageIndex = channelInfo.NumMessages
+	if t.loggedIn {
+		msg := make([]string, 0)
+		msg = append(msg, "error: logged in as "+t.currentUser+", /logout first")
+		t.printLinesCallback(msg)
+		return
+	}
 
-	messages := t.model.GetChannelHistory(t.currentChannel, t.currentUser, numMessages)
+	// A name with a password is taken even if its user is gone, so nobody else can claim the
+	// account's password along with it
+	if t.credentials.HasPassword(username) {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+credentials.ErrRegistered.Error())
+		t.printLinesCallback(msg)
+		return
+	}
 
-	// Tell the client about the messages
-	msg := make([]string, 0)
-	for _, message := range messages {
-		timestamp := message.Timestamp.Format("2006-01-02 15:04:05")
-		msg = append(msg, "["+timestamp+" - "+message.Username+"] "+message.Text)
+	// Claim the username (only one connection can win it), joining the current channel along with
+	// it so the channel can't be deleted in between
+	channelname := t.currentChannel
+	err := t.model.CreateUserAndJoin(username, channelname)
+	if err != nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+err.Error())
+		t.printLinesCallback(msg)
+		return
 	}
-	t.printLinesCallback(msg)
-}
 
-func (t *TelnetConn) switchUser(username string) {
-	users := t.model.GetUsers()
+	err = t.credentials.SetPassword(username, password)
+	if err != nil {
+		t.model.DeleteUser(username)
 
-	// Validate the user input
-	if _, ok := users[username]; !ok {
 		msg := make([]string, 0)
-		msg = append(msg, "error: <user> not found")
+		msg = append(msg, "error: "+err.Error())
 		t.printLinesCallback(msg)
 		return
 	}
 
-	// Update the current user
-	t.currentUser = username
+	t.switchUser(username)
+	if t.currentUser != username {
+		return
+	}
+	t.loggedIn = true
 
-	// Switch channels
-	t.switchChannel("General")
-}
+	if channelname != t.currentChannel {
+		t.switchChannel(channelname)
+	}
+
+	msg := make([]string, 0)
+	msg = append(msg, "registered and logged in as "+username)
+	t.printLinesCallback(msg)
+}
+
+// Logout will unbind the connection from its registered account and switch to the built-in user.
+func (t *TelnetConn) Logout() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.loggedIn {
+		msg := make([]string, 0)
+		msg = append(msg, "error: not logged in")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	t.loggedIn = false
+	t.switchUser(t.model.BuiltinUsername())
+}
+
+// EnableTOTP will enable two-factor authentication for the logged in account, printing the secret
+// (and its otpauth URI) for the user's authenticator and their single-use recovery codes.
+func (t *TelnetConn) EnableTOTP() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.credentials == nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: passwords are disabled")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	if !t.loggedIn {
+		msg := make([]string, 0)
+		msg = append(msg, "error: not logged in")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	secret, codes, err := t.credentials.EnableTOTP(t.currentUser)
+	if err != nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+err.Error())
+		t.printLinesCallback(msg)
+		return
+	}
+
+	msg := make([]string, 0)
+	msg = append(msg, "two-factor authentication enabled, /login now needs a <code> from your authenticator")
+	msg = append(msg, "secret: "+secret)
+	msg = append(msg, "uri: "+credentials.TOTPURI("chatserver", t.currentUser, secret))
+	msg = append(msg, "recovery codes (each logs in once without the authenticator): "+strings.Join(codes, " "))
+	t.printLinesCallback(msg)
+}
+
+// DisableTOTP will disable two-factor authentication for the logged in account, if the code from
+// its authenticator (or a recovery code) is correct.
+func (t *TelnetConn) DisableTOTP(code string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.credentials == nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: passwords are disabled")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	if !t.loggedIn {
+		msg := make([]string, 0)
+		msg = append(msg, "error: not logged in")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	if !t.credentials.CheckCode(t.currentUser, code) {
+		msg := make([]string, 0)
+		msg = append(msg, "error: invalid <code>")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	err := t.credentials.DisableTOTP(t.currentUser)
+	if err != nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+err.Error())
+		t.printLinesCallback(msg)
+		return
+	}
+
+	msg := make([]string, 0)
+	msg = append(msg, "two-factor authentication disabled")
+	t.printLinesCallback(msg)
+}
+
+// ShowUserInfo will print information associated with the current user.
+func (t *TelnetConn) ShowUserInfo() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	userInfo := t.model.GetUserInfo(t.currentUser)
+
+	// Sort the blocked users and muted channels alphabetically
+	sort.Strings(userInfo.BlockedUsers)
+	sort.Strings(userInfo.MutedChannels)
+
+	// Tell the client about the user info
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	msg = append(msg, "User: "+userInfo.Name)
+	if userInfo.Owner != "" {
+		msg = append(msg, "Owner: "+userInfo.Owner)
+	}
+	if userInfo.Profile.DisplayName != "" {
+		msg = append(msg, "Display Name: "+userInfo.Profile.DisplayName)
+	}
+	if userInfo.Profile.Pronouns != "" {
+		msg = append(msg, "Pronouns: "+userInfo.Profile.Pronouns)
+	}
+	if userInfo.Profile.Bio != "" {
+		msg = append(msg, "Bio:")
+		for _, line := range strings.Split(userInfo.Profile.Bio, "\n") {
+			msg = append(msg, "    "+line)
+		}
+	}
+	if status := t.formatStatus(userInfo.Status); status != "" {
+		msg = append(msg, "Status: "+status)
+	}
+	msg = append(msg, "Blocked Users:")
+	for _, blockedUser := range userInfo.BlockedUsers {
+		msg = append(msg, "    "+blockedUser)
+	}
+	msg = append(msg, "Muted Channels:")
+	for _, mutedChannel := range userInfo.MutedChannels {
+		msg = append(msg, "    "+mutedChannel)
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// CreateUser will create a new user.
+func (t *TelnetConn) CreateUser(username string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Tell the model about the new user
+	err := t.model.CreateUser(username)
+	t.printResult(err, "user '"+username+"' created")
+}
+
+// RenameUser will rename the current user, keeping their password (when logged in), preferences
+// and drafts.
+func (t *TelnetConn) RenameUser(newUsername string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Refuse the name of a registered account (which would otherwise come with its password)
+	if t.credentials != nil && t.credentials.HasPassword(newUsername) {
+		t.printResult(credentials.ErrRegistered, "")
+		return
+	}
+
+	// Rename the user in the model
+	username := t.currentUser
+	err := t.model.RenameUser(username, newUsername)
+	if err == nil {
+		t.currentUser = newUsername
+		preferences.Rename(t.model, username, newUsername)
+		drafts.Rename(t.model, username, newUsername)
+		security.Rename(t.model, username, newUsername)
+		reactions.Rename(t.model, username, newUsername)
+		if t.loggedIn {
+			err = t.credentials.RenamePassword(username, newUsername)
+		}
+	}
+	t.printResult(err, "user '"+username+"' renamed to '"+newUsername+"'")
+}
+
+// SetProfileField will set one field of the current user's profile ("displayname", "bio" or
+// "pronouns"), keeping the others.  An empty value clears the field.
+func (t *TelnetConn) SetProfileField(field string, value string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	profile := t.model.GetUserInfo(t.currentUser).Profile
+	switch field {
+	case "displayname":
+		profile.DisplayName = value
+	case "bio":
+		profile.Bio = value
+	case "pronouns":
+		profile.Pronouns = value
+	}
+
+	err := t.model.SetUserProfile(t.currentUser, profile)
+	t.printResult(err, "profile updated")
+}
+
+// SetStatus will replace the current user's status.  An empty text that isn't away clears it.
+func (t *TelnetConn) SetStatus(text string, away bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.model.SetUserStatus(t.currentUser, model.Status{Text: text, Away: away})
+	t.printResult(err, "status updated")
+}
+
+// BlockUser will add a new user to the current user's blocked user list.
+func (t *TelnetConn) BlockUser(username string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.model.BlockUser(t.currentUser, username)
+	t.printResult(err, "user '"+username+"' blocked")
+}
+
+// PostDirectMessage will send a direct message from the current user to another user.
+func (t *TelnetConn) PostDirectMessage(username string, text string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// The message is shown when the model notifies the connection, as with channel messages
+	_, err := t.model.PostDirectMessage(t.currentUser, username, time.Time{}, text)
+	if err != nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+err.Error())
+		t.printLinesCallback(msg)
+	}
+}
+
+// ShowConversations will print the users the current user has direct messages with, along with
+// the number of messages with each.
+func (t *TelnetConn) ShowConversations() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	conversations := t.model.GetConversations(t.currentUser)
+
+	// Sort the users alphabetically
+	sortedUsers := make([]string, 0)
+	for user := range conversations {
+		sortedUsers = append(sortedUsers, user)
+	}
+	sort.Strings(sortedUsers)
+
+	// Tell the client about the conversations
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	if len(sortedUsers) == 0 {
+		msg = append(msg, "no direct messages")
+	}
+	for _, user := range sortedUsers {
+		msg = append(msg, user+" ("+strconv.FormatUint(conversations[user], 10)+" messages)")
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// ShowDirectMessages will print the recent direct messages between the current user and another
+// user.
+func (t *TelnetConn) ShowDirectMessages(username string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// This will always bring us up to date with the conversation
+	t.directMessageCounts[username] = t.model.GetConversations(t.currentUser)[username]
+
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	msg = append(msg, "Direct messages with "+username)
+	msg = t.appendSeparator(msg)
+	for _, message := range t.model.GetDirectMessageHistory(t.currentUser, username, defaultHistoricalMessages) {
+		msg = append(msg, t.formatMessage("", message))
+	}
+	t.printLinesCallback(msg)
+}
+
+// CreateGroup will create a group conversation between the current user and other users.
+func (t *TelnetConn) CreateGroup(usernames []string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// The new group is shown when the model notifies the connection
+	_, err := t.model.CreateGroup(t.currentUser, usernames)
+	if err != nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+err.Error())
+		t.printLinesCallback(msg)
+	}
+}
+
+// PostGroupMessage will send a message from the current user to a group.
+func (t *TelnetConn) PostGroupMessage(groupID uint64, text string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// The message is shown when the model notifies the connection, as with channel messages
+	_, err := t.model.PostGroupMessage(groupID, t.currentUser, time.Time{}, text)
+	if err != nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+err.Error())
+		t.printLinesCallback(msg)
+	}
+}
+
+// ShowGroups will print the groups the current user is a member of, along with their members and
+// the number of messages in each.
+func (t *TelnetConn) ShowGroups() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	groups := t.model.GetGroups(t.currentUser)
+
+	// Tell the client about the groups
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	if len(groups) == 0 {
+		msg = append(msg, "no groups")
+	}
+	for _, group := range groups {
+		msg = append(msg, strconv.FormatUint(group.ID, 10)+": "+strings.Join(group.Members, ", ")+" ("+strconv.FormatUint(group.NumMessages, 10)+" messages)")
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// ShowEvents will print the events on the current channel's calendar that haven't started yet.
+func (t *TelnetConn) ShowEvents() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.model.Now()
+
+	// Tell the client about the events
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	numEvents := 0
+	for _, event := range t.model.GetEvents(t.currentChannel) {
+		if event.Start.Before(now) {
+			continue
+		}
+
+		line := strconv.FormatUint(event.ID, 10) + ": " + event.Start.Local().Format("2006-01-02 15:04") + " " + event.Title
+		if len(event.Going) > 0 {
+			line += " (going: " + strings.Join(event.Going, ", ") + ")"
+		}
+		msg = append(msg, line)
+		numEvents++
+	}
+	if numEvents == 0 {
+		msg = append(msg, "no upcoming events")
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// CreateEvent will add an event to the current channel's calendar.
+func (t *TelnetConn) CreateEvent(start time.Time, title string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	eventID, err := t.model.CreateEvent(t.currentChannel, t.currentUser, start, title)
+	t.printResult(err, "event "+strconv.FormatUint(eventID, 10)+" created")
+}
+
+// RSVPEvent will record whether the current user is going to an event.
+func (t *TelnetConn) RSVPEvent(eventID uint64, going bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.model.RSVPEvent(eventID, t.currentUser, going)
+	if going {
+		t.printResult(err, "going to event "+strconv.FormatUint(eventID, 10))
+	} else {
+		t.printResult(err, "not going to event "+strconv.FormatUint(eventID, 10))
+	}
+}
+
+// ShowMentions will print the channel messages that mention the current user.
+func (t *TelnetConn) ShowMentions() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Tell the client about the mentions
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	mentions := t.model.GetMentions(t.currentUser)
+	for _, mention := range mentions {
+		msg = append(msg, t.formatMessage(mention.Channelname, mention.Message))
+	}
+	if len(mentions) == 0 {
+		msg = append(msg, "no mentions")
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// ShowSecurityEvents will print the security events on the current user's account (e.g. logins
+// from new addresses and password changes), oldest first.
+func (t *TelnetConn) ShowSecurityEvents() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	events := security.Get(t.model, t.currentUser)
+	for _, event := range events {
+		msg = append(msg, event.Timestamp.Local().Format("2006-01-02 15:04")+" "+event.Text)
+	}
+	if len(events) == 0 {
+		msg = append(msg, "no security events")
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// ShowPinnedMessages will print the messages pinned in the current channel, in the order they were
+// pinned.
+func (t *TelnetConn) ShowPinnedMessages() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	numPinned := 0
+	for _, messageID := range pins.Get(t.model, t.currentChannel) {
+		message, ok := t.model.GetMessage(t.currentChannel, t.currentUser, messageID)
+		if ok && !message.Deleted {
+			msg = append(msg, t.formatMessage("", message))
+			numPinned++
+		}
+	}
+	if numPinned == 0 {
+		msg = append(msg, "no pinned messages")
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// ShowStarredMessages will print the messages the current user has starred.
+func (t *TelnetConn) ShowStarredMessages() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	starred := t.model.GetStarredMessages(t.currentUser)
+	for _, starredMessage := range starred {
+		msg = append(msg, t.formatMessage(starredMessage.Channelname, starredMessage.Message))
+	}
+	if len(starred) == 0 {
+		msg = append(msg, "no starred messages")
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// ShowGroupMessages will print the recent messages in a group the current user is a member of.
+func (t *TelnetConn) ShowGroupMessages(groupID uint64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// This will always bring us up to date with the group
+	numMessages, ok := t.groupCounts()[groupID]
+	if !ok {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+model.ErrGroupNotFound.Error())
+		t.printLinesCallback(msg)
+		return
+	}
+	t.groupMessageCounts[groupID] = numMessages
+
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	msg = append(msg, "Messages in group "+strconv.FormatUint(groupID, 10))
+	msg = t.appendSeparator(msg)
+	for _, message := range t.model.GetGroupMessageHistory(groupID, t.currentUser, defaultHistoricalMessages) {
+		msg = append(msg, t.formatMessage("", message))
+	}
+	t.printLinesCallback(msg)
+}
+
+// groupCounts returns the number of messages in each of the current user's groups.
+func (t *TelnetConn) groupCounts() map[uint64]uint64 {
+	counts := make(map[uint64]uint64)
+	for _, group := range t.model.GetGroups(t.currentUser) {
+		counts[group.ID] = group.NumMessages
+	}
+
+	return counts
+}
+
+// UnblockUser will delete an existing user from the current user's blocked user list.
+func (t *TelnetConn) UnblockUser(username string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.model.UnblockUser(t.currentUser, username)
+	t.printResult(err, "user '"+username+"' unblocked")
+}
+
+// MuteChannel will add a channel to the current user's muted channel list.
+func (t *TelnetConn) MuteChannel(channelname string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.model.MuteChannel(t.currentUser, channelname)
+	t.printResult(err, "channel '"+channelname+"' muted")
+}
+
+// UnmuteChannel will delete a channel from the current user's muted channel list.
+func (t *TelnetConn) UnmuteChannel(channelname string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.model.UnmuteChannel(t.currentUser, channelname)
+	t.printResult(err, "channel '"+channelname+"' unmuted")
+}
+
+// ShowChannels will print a list of all of the channels in the model, separated into the
+// channels joined by the current user and the channels available to join.
+func (t *TelnetConn) ShowChannels() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	channels := t.model.GetChannels()
+	joinedChannels := t.model.GetJoinedChannels(t.currentUser)
+	unreadCounts := t.model.GetUnreadCounts(t.currentUser)
+
+	// Sort the channels alphabetically
+	sortedChannels := make([]string, 0)
+	for channel := range channels {
+		sortedChannels = append(sortedChannels, channel)
+	}
+	sort.Strings(sortedChannels)
+
+	// Tell the client about the channels
+	joinedMsg := make([]string, 0)
+	availableMsg := make([]string, 0)
+	for _, channel := range sortedChannels {
+		line := "    " + channel
+		if channel == t.currentChannel {
+			line = "    " + t.markCurrent(channel)
+		}
+
+		if _, ok := t.watchedChannels[channel]; ok {
+			line += " (watching)"
+		}
+
+		// Flag the channels with new activity (the current channel is read as it's shown)
+		if unreadCounts[channel] > 0 && channel != t.currentChannel {
+			line += " (" + strconv.Itoa(unreadCounts[channel]) + " unread)"
+		}
+
+		if _, ok := joinedChannels[channel]; ok {
+			joinedMsg = append(joinedMsg, line)
+		} else {
+			availableMsg = append(availableMsg, line)
+		}
+	}
+
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	msg = append(msg, "Joined Channels:")
+	msg = append(msg, joinedMsg...)
+	msg = append(msg, "Available Channels:")
+	msg = append(msg, availableMsg...)
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// ShowPublicChannels will print a directory of all of the public channels that can be joined,
+// ordered by most recent activity.
+func (t *TelnetConn) ShowPublicChannels() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	channelInfos := t.model.BrowseChannels()
+	joinedChannels := t.model.GetJoinedChannels(t.currentUser)
+
+	// Tell the client about the channels
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	msg = append(msg, "Public Channels:")
+	for _, channelInfo := range channelInfos {
+		line := "    " + channelInfo.Name + " (" + strconv.Itoa(channelInfo.NumMembers) + " members)"
+		if _, ok := joinedChannels[channelInfo.Name]; ok {
+			line += " (joined)"
+		}
+		if channelInfo.Topic != "" {
+			line += " - " + channelInfo.Topic
+		}
+		msg = append(msg, line)
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// JoinChannel will add the current user to the members of a channel and switch to it.
+func (t *TelnetConn) JoinChannel(channelname string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Joining a channel that's already joined just switches to it
+	err := t.model.JoinChannel(t.currentUser, channelname)
+	if err != nil && err != model.ErrAlreadyMember {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+err.Error())
+		t.printLinesCallback(msg)
+		return
+	}
+	t.switchChannel(channelname)
+
+	// Tell the client about the channel's rules
+	channelInfo := t.model.GetChannelInfo(channelname)
+	if channelInfo.Language != "" || channelInfo.Rules != "" {
+		msg := make([]string, 0)
+		msg = append(msg, "Language: "+channelInfo.Language)
+		msg = append(msg, "Rules: "+channelInfo.Rules)
+		msg = t.appendSeparator(msg)
+		t.printLinesCallback(msg)
+	}
+}
+
+// LeaveChannel will remove the current user from the members of a channel.  If the channel
+// is currently being viewed, the connection will switch back to the built-in channel.
+func (t *TelnetConn) LeaveChannel(channelname string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.model.LeaveChannel(t.currentUser, channelname)
+	t.printResult(err, "left channel '"+channelname+"'")
+	if err == nil && t.currentChannel == channelname {
+		t.switchChannel(t.model.BuiltinChannelname())
+	}
+}
+
+// SwitchChannel will change the channel that the current user is viewing.
+func (t *TelnetConn) SwitchChannel(channelname string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Call the private (lock held) version
+	t.switchChannel(channelname)
+}
+
+// WatchChannel will show the new messages of a channel inline (prefixed with the channel name)
+// while viewing other channels, without switching to it.
+func (t *TelnetConn) WatchChannel(channelname string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Validate the user input (looking up just the channel, rather than copying all of them)
+	channelInfo := t.model.GetChannelInfo(channelname)
+	if channelInfo.Name == "" {
+		msg := make([]string, 0)
+		msg = append(msg, "error: <channel> not found")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	if _, ok := t.watchedChannels[channelname]; ok {
+		msg := make([]string, 0)
+		msg = append(msg, "error: <channel> already watched")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	// Only the messages posted from now on are shown
+	t.watchedChannels[channelname] = channelInfo.NumMessages
+
+	msg := make([]string, 0)
+	msg = append(msg, "watching channel '"+channelname+"'")
+	t.printLinesCallback(msg)
+}
+
+// UnwatchChannel will stop showing the new messages of a watched channel.
+func (t *TelnetConn) UnwatchChannel(channelname string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Validate the user input
+	if _, ok := t.watchedChannels[channelname]; !ok {
+		msg := make([]string, 0)
+		msg = append(msg, "error: <channel> not watched")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	delete(t.watchedChannels, channelname)
+
+	msg := make([]string, 0)
+	msg = append(msg, "no longer watching channel '"+channelname+"'")
+	t.printLinesCallback(msg)
+}
+
+// SplitChannel will show the messages of another channel interleaved with the current channel's
+// (each prefixed with its channel name), starting with their recent history.  An empty
+// channelname leaves the split view.
+func (t *TelnetConn) SplitChannel(channelname string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if channelname == "" {
+		if t.splitChannel == "" {
+			msg := make([]string, 0)
+			msg = append(msg, "error: split view not on")
+			t.printLinesCallback(msg)
+			return
+		}
+
+		t.splitChannel = ""
+
+		msg := make([]string, 0)
+		msg = append(msg, "split view off")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	// Validate the user input
+	if t.model.GetChannelInfo(channelname).Name == "" {
+		msg := make([]string, 0)
+		msg = append(msg, "error: <channel> not found")
+		t.printLinesCallback(msg)
+		return
+	}
 
-func (t *TelnetConn) switchChannel(channelname string) {
-	channels := t.model.GetChannels()
+	if channelname == t.currentChannel {
+		msg := make([]string, 0)
+		msg = append(msg, "error: <channel> is the current channel")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	t.splitChannel = channelname
+
+	// Tell the client about the split view
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	msg = append(msg, "User: "+t.currentUser)
+	msg = append(msg, "Channels: "+t.currentChannel+" + "+t.splitChannel)
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+
+	// Show the recent history of both channels, interleaved in the order the messages were posted
+	t.currentChannelMessageIndex = t.model.GetChannelInfo(t.currentChannel).NumMessages
+	t.splitChannelMessageIndex = t.model.GetChannelInfo(t.splitChannel).NumMessages
+
+	type channelMessage struct {
+		channelname string
+		message     model.Message
+	}
+
+	messages := make([]channelMessage, 0)
+	for _, channelname := range []string{t.currentChannel, t.splitChannel} {
+		for _, message := range t.model.GetChannelHistory(channelname, t.currentUser, defaultHistoricalMessages) {
+			messages = append(messages, channelMessage{channelname: channelname, message: message})
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].message.ID < messages[j].message.ID })
+
+	msg = make([]string, 0)
+	for _, message := range messages {
+		msg = append(msg, t.formatMessage(message.channelname, message.message))
+	}
+	t.printLinesCallback(msg)
+}
+
+// ShowChannelInfo will print information associated with the current channel.
+func (t *TelnetConn) ShowChannelInfo() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	channelInfo := t.model.GetChannelInfo(t.currentChannel)
+
+	// Tell the client about the channel info
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	msg = append(msg, "Channel: "+channelInfo.Name)
+	msg = append(msg, "Topic: "+channelInfo.Topic)
+	msg = append(msg, "Language: "+channelInfo.Language)
+	msg = append(msg, "Rules: "+channelInfo.Rules)
+	msg = append(msg, "Messages: "+strconv.Itoa(channelInfo.NumMessages))
+	msg = append(msg, "Members: "+strconv.Itoa(channelInfo.NumMembers))
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// ShowChannelMembers will print a list of the members of the current channel.
+func (t *TelnetConn) ShowChannelMembers() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	members := t.model.GetChannelMembers(t.currentChannel)
+
+	// Sort the members alphabetically
+	sortedMembers := make([]string, 0)
+	for member := range members {
+		sortedMembers = append(sortedMembers, member)
+	}
+	sort.Strings(sortedMembers)
+
+	// Tell the client about the members
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	msg = append(msg, "Members of "+t.currentChannel+":")
+	for _, member := range sortedMembers {
+		if member == t.currentUser {
+			msg = append(msg, t.markCurrent(member))
+		} else {
+			msg = append(msg, member)
+		}
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// ShowLeaderboard will print the top posters in the current channel since a day (a zero time for
+// all time).
+func (t *TelnetConn) ShowLeaderboard(since time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	posters := t.model.GetTopPosters(t.currentChannel, since, defaultLeaderboardPosters)
+
+	// Tell the client about the top posters
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	msg = append(msg, "Top posters in "+t.currentChannel+":")
+	for i, poster := range posters {
+		msg = append(msg, strconv.Itoa(i+1)+". "+poster.Username+" - "+strconv.Itoa(poster.NumMessages)+" messages")
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// ShowKarma will print a user's karma score (see the karma bot).
+func (t *TelnetConn) ShowKarma(username string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if username == "" {
+		username = t.currentUser
+	}
+
+	msg := make([]string, 0)
+	msg = append(msg, username+" has "+strconv.Itoa(bots.KarmaScore(t.model, username))+" karma")
+	t.printLinesCallback(msg)
+}
+
+// SetChannelTopic will set the topic of the current channel.
+func (t *TelnetConn) SetChannelTopic(topic string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.model.SetChannelTopic(t.currentChannel, topic)
+	t.printResult(err, "topic of channel '"+t.currentChannel+"' set")
+}
+
+// SetChannelRules will set the language and content rules of the current channel.
+func (t *TelnetConn) SetChannelRules(language string, rules string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.model.SetChannelRules(t.currentChannel, language, rules)
+	t.printResult(err, "rules of channel '"+t.currentChannel+"' set")
+}
+
+// ShowChannelNotes will print the notes of the current channel, along with who last edited them.
+func (t *TelnetConn) ShowChannelNotes() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	notes := t.model.GetChannelNotes(t.currentChannel)
+
+	msg := make([]string, 0)
+	msg = t.appendSeparator(msg)
+	if notes.Version == 0 {
+		msg = append(msg, "no notes")
+	} else {
+		msg = append(msg, "Notes of "+t.currentChannel+" (version "+strconv.FormatUint(notes.Version, 10)+", edited by "+notes.Editor+" at "+notes.Edited.Local().Format("2006-01-02 15:04")+")")
+		msg = t.appendSeparator(msg)
+		msg = append(msg, strings.Split(notes.Text, "\n")...)
+	}
+	msg = t.appendSeparator(msg)
+	t.printLinesCallback(msg)
+}
+
+// SetChannelNotes will set the notes of the current channel to a text, or add it to them as a new
+// line.
+func (t *TelnetConn) SetChannelNotes(text string, add bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	notes := t.model.GetChannelNotes(t.currentChannel)
+	if add && notes.Text != "" {
+		text = notes.Text + "\n" + text
+	}
+
+	version, err := t.model.SetChannelNotes(t.currentChannel, t.currentUser, notes.Version, text)
+	t.printResult(err, "notes of channel '"+t.currentChannel+"' saved (version "+strconv.FormatUint(version, 10)+")")
+}
+
+// ShowChannelHistory will print up to 'numMessages' worth of history from the current channel
+// (NOTE: '-1' will print all messages).
+func (t *TelnetConn) ShowChannelHistory(numMessages int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Call the private (lock held) version
+	t.showChannelHistory(numMessages)
+}
+
+// ShowChannelHistorySince will print the history from the current channel posted since a time.
+func (t *TelnetConn) ShowChannelHistorySince(since time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// This will always bring us up to date with the channel messages
+	channelInfo := t.model.GetChannelInfo(t.currentChannel)
+	t.currentChannelMessageIndex = channelInfo.NumMessages
+
+	t.printMessages(t.model.GetChannelHistoryByTime(t.currentChannel, t.currentUser, since, time.Time{}))
+}
+
+// CreateChannel will create a new channel with a topic (empty for none), add the current user to
+// its members and switch to it.
+func (t *TelnetConn) CreateChannel(channelname string, topic string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Tell the model about the new channel
+	err := t.model.CreateChannelAndJoin(channelname, topic, t.currentUser)
+	t.printResult(err, "channel '"+channelname+"' created")
+	if err == nil {
+		t.switchChannel(channelname)
+	}
+}
+
+// RestoreChannel will restore a recently deleted channel.
+func (t *TelnetConn) RestoreChannel(channelname string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Restore the channel in the model
+	err := t.model.RestoreChannel(channelname)
+	t.printResult(err, "channel '"+channelname+"' restored")
+}
+
+// RenameChannel will rename an existing channel, keeping its history, its reactions and pins, and the
+// drafts for it.
+func (t *TelnetConn) RenameChannel(channelname string, newChannelname string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Rename the channel in the model
+	err := t.model.RenameChannel(channelname, newChannelname)
+	if err == nil {
+		drafts.RenameChannel(t.model, channelname, newChannelname)
+		reactions.RenameChannel(t.model, channelname, newChannelname)
+		pins.RenameChannel(t.model, channelname, newChannelname)
+	}
+	t.printResult(err, "channel '"+channelname+"' renamed to '"+newChannelname+"'")
+}
+
+// ScreenReader returns whether the current user's output is screen reader friendly.
+func (t *TelnetConn) ScreenReader() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.isScreenReader()
+}
+
+// SetScreenReader will turn the screen reader friendly output (without separators and
+// decorations, with messages read out as "message from <user> at <time>") on or off for the
+// current user, and print the recent channel history again in the new format.
+func (t *TelnetConn) SetScreenReader(enabled bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	value := ""
+	if enabled {
+		value = "on"
+	}
+	preferences.Set(t.model, t.currentUser, preferences.ScreenReader, value)
+
+	msg := make([]string, 0)
+	if enabled {
+		msg = append(msg, "screen reader mode on for "+t.currentUser)
+	} else {
+		msg = append(msg, "screen reader mode off for "+t.currentUser)
+	}
+	t.printLinesCallback(msg)
+
+	t.lastMessageDay = ""
+	t.showChannelHistory(defaultHistoricalMessages)
+}
+
+// PostMessage will post a new message to the current channel by the current user.
+func (t *TelnetConn) PostMessage(text string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Telnet clients don't claim a time, the model assigns it (an empty message, e.g. one masked
+	// entirely by the channel's word list, isn't worth an error)
+	_, err := t.model.PostMessage(t.currentChannel, t.currentUser, time.Time{}, text)
+	if err != nil && err != model.ErrEmptyMessage {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+err.Error())
+		t.printLinesCallback(msg)
+	}
+}
+
+// PostMessageMulti cross-posts a message to several channels.
+func (t *TelnetConn) PostMessageMulti(channelnames []string, text string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// The copies are shown when the model notifies the connection, as with any other message
+	_, err := t.model.PostMessageMulti(channelnames, t.currentUser, time.Time{}, text)
+	if err != nil && err != model.ErrEmptyMessage {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+err.Error())
+		t.printLinesCallback(msg)
+	}
+}
+
+// PostSnippet posts a code snippet in a language (e.g. "go") to the current channel.
+func (t *TelnetConn) PostSnippet(language string, text string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	_, err := t.model.PostSnippet(t.currentChannel, t.currentUser, time.Time{}, language, text)
+	if err != nil {
+		msg := make([]string, 0)
+		msg = append(msg, "error: "+err.Error())
+		t.printLinesCallback(msg)
+	}
+}
+
+func (t *TelnetConn) showChannelHistory(numMessages int) {
+	// This will always bring us up to date with the channel messages
+	channelInfo := t.model.GetChannelInfo(t.currentChannel)
+	t.currentChannelMessageIndex = channelInfo.NumMessages
+
+	messages := t.model.GetChannelHistory(t.currentChannel, t.currentUser, numMessages)
+	t.printMessages(messages)
+
+	// The user has now read the channel up to the last message shown (the built-in user has no read
+	// markers)
+	if len(messages) > 0 && t.currentUser != t.model.BuiltinUsername() {
+		t.model.MarkRead(t.currentUser, t.currentChannel, messages[len(messages)-1].ID)
+	}
+}
+
+func (t *TelnetConn) printMessages(messages []model.Message) {
+	// Separate the days (from the last message printed, or within the messages if they span more
+	// than one day)
+	if t.lastMessageDay == "" && len(messages) > 0 && messages[0].Timestamp.Format("2006-01-02") != messages[len(messages)-1].Timestamp.Format("2006-01-02") {
+		t.lastMessageDay = "None"
+	}
+
+	// Tell the client about the messages (screen readers get the day of the first one too, since
+	// their messages only have the time)
+	screenReader := t.isScreenReader()
+	msg := make([]string, 0)
+	for _, message := range messages {
+		day := message.Timestamp.Format("2006-01-02")
+		if screenReader && day != t.lastMessageDay {
+			msg = append(msg, message.Timestamp.Format("Monday, January 2, 2006"))
+		} else if t.lastMessageDay != "" && day != t.lastMessageDay {
+			msg = append(msg, "----- "+message.Timestamp.Format("Monday, January 2, 2006")+" -----")
+		}
+		t.lastMessageDay = day
+
+		// The split view tells the channels apart
+		if t.splitChannel != "" && t.splitChannel != t.currentChannel {
+			msg = append(msg, t.formatMessage(t.currentChannel, message))
+		} else {
+			msg = append(msg, t.formatMessage("", message))
+		}
+	}
+	t.printLinesCallback(msg)
+}
+
+// formatMessage formats a message (of the channel, if it's given) for the current user, who may
+// be using a screen reader.
+func (t *TelnetConn) formatMessage(channelname string, message model.Message) string {
+	edited := ""
+	if !message.Edited.IsZero() {
+		edited = " (edited)"
+	}
+
+	if message.Deleted {
+		message.Text = "(message deleted)"
+		edited = ""
+	} else if message.SnippetLanguage != "" {
+		message.Text = t.formatSnippet(message.SnippetLanguage, message.Text)
+	}
+
+	// The attached files are listed by name (telnet clients can't show them)
+	if len(message.Attachments) > 0 {
+		names := make([]string, len(message.Attachments))
+		for i, attachment := range message.Attachments {
+			names[i] = attachment.Name
+		}
+		edited += " (attached: " + strings.Join(names, ", ") + ")"
+	}
+
+	if t.isScreenReader() {
+		text := "message from " + message.DisplayAuthor() + " at " + message.Timestamp.Format("15:04") + ": " + message.Text + edited
+		if channelname != "" {
+			text = "in " + channelname + ", " + text
+		}
+
+		return text
+	}
+
+	timestamp := message.Timestamp.Format("2006-01-02 15:04:05")
+	text := "[" + timestamp + " - " + message.DisplayAuthor() + "] " + message.Text + edited
+	if channelname != "" {
+		text = "[" + channelname + "] " + text
+	}
+
+	return text
+}
+
+// formatSnippet formats the code of a snippet to follow the message's header, fenced (or, for a
+// screen reader, announced) on lines of its own.  The lines are joined with "\r\n" so the message
+// still goes out as a single line would.
+func (t *TelnetConn) formatSnippet(language string, code string) string {
+	code = strings.TrimRight(strings.Replace(code, "\r\n", "\n", -1), "\n")
+	lines := strings.Split(code, "\n")
+	if t.isScreenReader() {
+		return language + " snippet\r\n" + strings.Join(lines, "\r\n") + "\r\nend of snippet"
+	}
+
+	return "```" + language + "\r\n" + strings.Join(lines, "\r\n") + "\r\n```"
+}
+
+// appendSeparator appends a separator line to the lines (unless the current user is using a
+// screen reader, which would read it out).
+func (t *TelnetConn) appendSeparator(lines []string) []string {
+	if t.isScreenReader() {
+		return lines
+	}
+
+	return append(lines, defaultSeparator)
+}
+
+// formatStatus returns a user's status as it's shown next to their name (e.g. "🟡 away — lunch"),
+// empty if they haven't set one.  Screen readers get it without the emoji.
+func (t *TelnetConn) formatStatus(status model.Status) string {
+	parts := make([]string, 0)
+	if status.Away {
+		if t.isScreenReader() {
+			parts = append(parts, "away")
+		} else {
+			parts = append(parts, "🟡 away")
+		}
+	}
+	if status.Text != "" {
+		parts = append(parts, status.Text)
+	}
+
+	return strings.Join(parts, " — ")
+}
+
+// markCurrent marks the name of the current user/channel in a list.
+func (t *TelnetConn) markCurrent(name string) string {
+	if t.isScreenReader() {
+		return name + " (current)"
+	}
+
+	return "--> " + name + " <--"
+}
+
+func (t *TelnetConn) isScreenReader() bool {
+	return preferences.Get(t.model, t.currentUser, preferences.ScreenReader) == "on"
+}
+
+// printResult tells the client whether a command took effect, or why the model rejected it.
+func (t *TelnetConn) printResult(err error, success string) {
+	msg := make([]string, 0)
+	if err == nil {
+		msg = append(msg, success)
+	} else {
+		msg = append(msg, "error: "+err.Error())
+	}
+	t.printLinesCallback(msg)
+}
+
+func (t *TelnetConn) switchUser(username string) {
+	// Look the user up once, so it can't be deleted between the checks
+	userInfo := t.model.GetUserInfo(username)
+
+	// Validate the user input
+	if userInfo.Name == "" {
+		msg := make([]string, 0)
+		msg = append(msg, "error: <user> not found")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	// Virtual users can only be used by their owner
+	if userInfo.Owner != "" {
+		msg := make([]string, 0)
+		msg = append(msg, "error: <user> is a virtual user")
+		t.printLinesCallback(msg)
+		return
+	}
+
+	// Update the current user (only direct messages from now on are shown inline)
+	t.currentUser = username
+	t.model.SetConnectionUser(t.connectionID, username)
+	t.directMessageCounts = t.model.GetConversations(username)
+	t.groupMessageCounts = t.groupCounts()
 
+	// Switch channels
+	t.switchChannel(t.model.BuiltinChannelname())
+}
+
+func (t *TelnetConn) switchChannel(channelname string) {
 	// Validate the user input
-	if _, ok := channels[channelname]; !ok {
+	if t.model.GetChannelInfo(channelname).Name == "" {
 		msg := make([]string, 0)
 		msg = append(msg, "error: <channel> not found")
 		t.printLinesCallback(msg)
@@ -387,13 +1807,15 @@ func (t *TelnetConn) switchChannel(channelname string) {
 
 	// Update the current channel
 	t.currentChannel = channelname
+	t.lastMessageDay = ""
+	t.model.EmitEvent("channel_switched", t.currentUser, channelname)
 
 	// Tell the client about the new channel
 	msg := make([]string, 0)
-	msg = append(msg, defaultSeparator)
+	msg = t.appendSeparator(msg)
 	msg = append(msg, "User: "+t.currentUser)
 	msg = append(msg, "Channel: "+t.currentChannel)
-	msg = append(msg, defaultSeparator)
+	msg = t.appendSeparator(msg)
 	t.printLinesCallback(msg)
 
 	// Show channel history
@@ -0,0 +1,122 @@
+package telnetconn_test
+
+import (
+	"chatserver/credentials"
+	"chatserver/model"
+	"chatserver/telnetconn"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// The benchmarks run against a model with enough users and channels that copying them all (rather
+// than looking up the one a command names) shows up, with the connections running in parallel so
+// they contend for the model's lock.
+const benchmarkUsers int = 1000
+const benchmarkChannels int = 1000
+
+func newBenchmarkModel(b *testing.B) *model.Model {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		b.Fatal("Failed to create model")
+	}
+
+	for i := 0; i < benchmarkUsers; i++ {
+		testModel.CreateUser("user" + strconv.Itoa(i))
+	}
+
+	for i := 0; i < benchmarkChannels; i++ {
+		testModel.CreateChannel("channel" + strconv.Itoa(i))
+	}
+
+	return testModel
+}
+
+func TestRegisterDeletedAccount(t *testing.T) {
+	testModel, err := model.NewModel(model.Options{}, nil, nil, nil)
+	if err != nil {
+		t.Fatal("Failed to create model")
+	}
+	testCredentials := credentials.New(credentials.NewLogStore(testModel))
+
+	var lines []string
+	printLines := func(newLines []string) {
+		lines = append(lines, newLines...)
+	}
+
+	// Register an account, then delete its user without the password (as a deletion outside the
+	// admin API used to, so older state can still have such passwords)
+	owner := telnetconn.NewTelnetConn(testModel, testCredentials, printLines)
+	owner.Register("alice", "secret")
+	if !testCredentials.CheckPassword("alice", "secret") {
+		t.Fatal("Failed to register an account")
+	}
+	testModel.DeleteUser("alice")
+
+	// Ensure that a guest can't claim the name, by registering it or renaming onto it
+	testModel.CreateUser("guest")
+	guest := telnetconn.NewTelnetConn(testModel, testCredentials, printLines)
+	guest.SwitchUser("guest")
+
+	lines = nil
+	guest.Register("alice", "guess")
+	if len(lines) != 1 || !strings.Contains(lines[0], credentials.ErrRegistered.Error()) {
+		t.Error("Failed to refuse registering a name with a password")
+	}
+	if !testCredentials.CheckPassword("alice", "secret") || testCredentials.CheckPassword("alice", "guess") {
+		t.Error("Failed to keep the account's password")
+	}
+
+	lines = nil
+	guest.RenameUser("alice")
+	if len(lines) != 1 || !strings.Contains(lines[0], credentials.ErrRegistered.Error()) {
+		t.Error("Failed to refuse renaming onto a name with a password")
+	}
+	username, _, _ := guest.Status()
+	if _, ok := testModel.GetUsers()["alice"]; ok || username != "guest" {
+		t.Error("Renamed a guest onto a registered account")
+	}
+}
+
+// runParallel runs a command on a connection per goroutine, each with its own user.
+func runParallel(b *testing.B, testModel *model.Model, command func(telnetConn *telnetconn.TelnetConn)) {
+	var nextUser int32
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		telnetConn := telnetconn.NewTelnetConn(testModel, nil, func(lines []string) {})
+		telnetConn.SwitchUser("user" + strconv.Itoa(int(atomic.AddInt32(&nextUser, 1))%benchmarkUsers))
+		for pb.Next() {
+			command(telnetConn)
+		}
+	})
+}
+
+func BenchmarkUnblockUser(b *testing.B) {
+	testModel := newBenchmarkModel(b)
+	runParallel(b, testModel, func(telnetConn *telnetconn.TelnetConn) {
+		telnetConn.UnblockUser(testModel.BuiltinUsername())
+	})
+}
+
+func BenchmarkUnmuteChannel(b *testing.B) {
+	testModel := newBenchmarkModel(b)
+	runParallel(b, testModel, func(telnetConn *telnetconn.TelnetConn) {
+		telnetConn.UnmuteChannel(testModel.BuiltinChannelname())
+	})
+}
+
+func BenchmarkWatchChannel(b *testing.B) {
+	testModel := newBenchmarkModel(b)
+	runParallel(b, testModel, func(telnetConn *telnetconn.TelnetConn) {
+		telnetConn.WatchChannel("channel1")
+		telnetConn.UnwatchChannel("channel1")
+	})
+}
+
+func BenchmarkSwitchChannel(b *testing.B) {
+	testModel := newBenchmarkModel(b)
+	runParallel(b, testModel, func(telnetConn *telnetconn.TelnetConn) {
+		telnetConn.SwitchChannel("channel1")
+	})
+}
@@ -0,0 +1,678 @@
+// Package tracing provides optional request tracing.  Spans are recorded around API request
+// handling, logged model actions and subscription notifications, and are exported in batches to
+// an OpenTelemetry collector using OTLP (JSON over HTTP).  A nil Tracer is valid and records
+// nothing, so tracing can be disabled without any checks at the call sites.
+package tracing
+
+import (
+	"bytes"
+	"chatserver/model/actions"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exportInterval is how often recorded spans are sent to the collector.
+const exportInterval time.Duration = time.Second
+
+// maxPendingSpans bounds the spans held while the collector is unreachable.
+const maxPendingSpans int = 10000
+
+// Span kinds (as defined by OTLP).
+const (
+	kindInternal int = 1
+	kindServer   int = 2
+)
+
+// Tracer records spans and exports them to an OTLP endpoint.
+type Tracer struct {
+	endpoint    string
+	serviceName string
+	mutex       sync.Mutex
+	pending     []*Span
+}
+
+// Span is a single timed operation.
+type Span struct {
+	tracer     *Tracer
+	traceID    string
+	spanID     string
+	parentID   string
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+}
+
+// NewTracer creates/initializes/returns a new Tracer that exports to the given OTLP HTTP
+// endpoint (e.g. http://localhost:4318).  Exporting happens in the background.
+func NewTracer(endpoint string, serviceName string) *Tracer {
+	tracer := Tracer{
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		pending:     make([]*Span, 0),
+	}
+
+	go func() {
+		for range time.Tick(exportInterval) {
+			tracer.export()
+		}
+	}()
+
+	return &tracer
+}
+
+// StartRequest starts a new (root) span for handling an API request.
+func (t *Tracer) StartRequest(name string, attributes map[string]string) *Span {
+	return t.start(name, kindServer, "", "", attributes)
+}
+
+// Start starts a new (root) span for an internal operation.
+func (t *Tracer) Start(name string, attributes map[string]string) *Span {
+	return t.start(name, kindInternal, "", "", attributes)
+}
+
+// SetAttribute adds an attribute to the span.
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+
+	s.attributes[key] = value
+}
+
+// End ends the span and queues it for export.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.end = time.Now()
+
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+
+	if len(s.tracer.pending) < maxPendingSpans {
+		s.tracer.pending = append(s.tracer.pending, s)
+	}
+}
+
+func (t *Tracer) start(name string, kind int, traceID string, parentID string, attributes map[string]string) *Span {
+	if t == nil {
+		return nil
+	}
+
+	if traceID == "" {
+		traceID = newID(16)
+	}
+
+	span := Span{
+		tracer:     t,
+		traceID:    traceID,
+		spanID:     newID(8),
+		parentID:   parentID,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+
+	for key, value := range attributes {
+		span.attributes[key] = value
+	}
+
+	return &span
+}
+
+func (t *Tracer) export() {
+	t.mutex.Lock()
+	spans := t.pending
+	t.pending = make([]*Span, 0)
+	t.mutex.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(t.newExportRequest(spans))
+	if err != nil {
+		log.Println("tracing:", err)
+		return
+	}
+
+	response, err := http.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The spans are dropped, tracing must never get in the way of serving
+		log.Println("tracing:", err)
+		return
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		log.Println("tracing: export failed with status", response.Status)
+	}
+}
+
+// The OTLP JSON encoding of an export request (only the fields we use)
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func (t *Tracer) newExportRequest(spans []*Span) otlpExportRequest {
+	scopeSpans := otlpScopeSpans{
+		Scope: otlpScope{Name: "chatserver"},
+		Spans: make([]otlpSpan, 0),
+	}
+
+	for _, span := range spans {
+		scopeSpans.Spans = append(scopeSpans.Spans, otlpSpan{
+			TraceID:           span.traceID,
+			SpanID:            span.spanID,
+			ParentSpanID:      span.parentID,
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        newAttributes(span.attributes),
+		})
+	}
+
+	resourceSpans := otlpResourceSpans{
+		Resource: otlpResource{
+			Attributes: newAttributes(map[string]string{"service.name": t.serviceName}),
+		},
+		ScopeSpans: []otlpScopeSpans{scopeSpans},
+	}
+
+	return otlpExportRequest{
+		ResourceSpans: []otlpResourceSpans{resourceSpans},
+	}
+}
+
+func newAttributes(attributes map[string]string) []otlpAttribute {
+	otlpAttributes := make([]otlpAttribute, 0)
+	for key, value := range attributes {
+		otlpAttributes = append(otlpAttributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+	}
+
+	return otlpAttributes
+}
+
+func newID(numBytes int) string {
+	id := make([]byte, numBytes)
+	_, err := rand.Read(id)
+	if err != nil {
+		log.Println("tracing:", err)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// SubsEngine is the subscription engine interface being traced (matching model.SubsEngine).
+type SubsEngine interface {
+	UsersChanged()
+	UserChanged(username string)
+	ChannelsChanged()
+	ChannelChanged(channelname string)
+	ChannelRenamed(channelname string, newChannelname string)
+	UserRenamed(username string, newUsername string)
+	MessageChanged(channelname string, messageID uint64)
+	DirectMessagesChanged(username string, otherUsername string)
+	GroupChanged(groupID uint64, members []string)
+	PresenceChanged(username string)
+	ReadMarkerChanged(username string, channelname string)
+	Mentioned(username string, channelname string, messageID uint64)
+}
+
+type tracedSubsEngine struct {
+	tracer *Tracer
+	engine SubsEngine
+}
+
+// NewSubsEngine wraps a subscription engine so that each notification fan-out is traced.
+func NewSubsEngine(tracer *Tracer, engine SubsEngine) SubsEngine {
+	return &tracedSubsEngine{tracer: tracer, engine: engine}
+}
+
+func (t *tracedSubsEngine) UsersChanged() {
+	span := t.tracer.Start("subs.UsersChanged", nil)
+	defer span.End()
+
+	t.engine.UsersChanged()
+}
+
+func (t *tracedSubsEngine) UserChanged(username string) {
+	span := t.tracer.Start("subs.UserChanged", map[string]string{"username": username})
+	defer span.End()
+
+	t.engine.UserChanged(username)
+}
+
+func (t *tracedSubsEngine) ChannelsChanged() {
+	span := t.tracer.Start("subs.ChannelsChanged", nil)
+	defer span.End()
+
+	t.engine.ChannelsChanged()
+}
+
+func (t *tracedSubsEngine) ChannelChanged(channelname string) {
+	span := t.tracer.Start("subs.ChannelChanged", map[string]string{"channelname": channelname})
+	defer span.End()
+
+	t.engine.ChannelChanged(channelname)
+}
+
+func (t *tracedSubsEngine) ChannelRenamed(channelname string, newChannelname string) {
+	span := t.tracer.Start("subs.ChannelRenamed", map[string]string{"channelname": channelname, "newChannelname": newChannelname})
+	defer span.End()
+
+	t.engine.ChannelRenamed(channelname, newChannelname)
+}
+
+func (t *tracedSubsEngine) UserRenamed(username string, newUsername string) {
+	span := t.tracer.Start("subs.UserRenamed", map[string]string{"username": username, "newUsername": newUsername})
+	defer span.End()
+
+	t.engine.UserRenamed(username, newUsername)
+}
+
+func (t *tracedSubsEngine) MessageChanged(channelname string, messageID uint64) {
+	span := t.tracer.Start("subs.MessageChanged", map[string]string{"channelname": channelname})
+	defer span.End()
+
+	t.engine.MessageChanged(channelname, messageID)
+}
+
+func (t *tracedSubsEngine) DirectMessagesChanged(username string, otherUsername string) {
+	span := t.tracer.Start("subs.DirectMessagesChanged", map[string]string{"username": username, "otherUsername": otherUsername})
+	defer span.End()
+
+	t.engine.DirectMessagesChanged(username, otherUsername)
+}
+
+func (t *tracedSubsEngine) GroupChanged(groupID uint64, members []string) {
+	span := t.tracer.Start("subs.GroupChanged", map[string]string{"groupID": strconv.FormatUint(groupID, 10)})
+	defer span.End()
+
+	t.engine.GroupChanged(groupID, members)
+}
+
+func (t *tracedSubsEngine) PresenceChanged(username string) {
+	span := t.tracer.Start("subs.PresenceChanged", map[string]string{"username": username})
+	defer span.End()
+
+	t.engine.PresenceChanged(username)
+}
+
+func (t *tracedSubsEngine) ReadMarkerChanged(username string, channelname string) {
+	span := t.tracer.Start("subs.ReadMarkerChanged", map[string]string{"username": username, "channelname": channelname})
+	defer span.End()
+
+	t.engine.ReadMarkerChanged(username, channelname)
+}
+
+func (t *tracedSubsEngine) Mentioned(username string, channelname string, messageID uint64) {
+	span := t.tracer.Start("subs.Mentioned", map[string]string{"username": username, "channelname": channelname, "messageID": strconv.FormatUint(messageID, 10)})
+	defer span.End()
+
+	t.engine.Mentioned(username, channelname, messageID)
+}
+
+type tracedActor struct {
+	tracer *Tracer
+	actor  actions.Actor
+}
+
+// NewActor wraps an actions Actor (e.g. the actions Logger) so that each model mutation it is
+// given is traced.
+func NewActor(tracer *Tracer, actor actions.Actor) actions.Actor {
+	return &tracedActor{tracer: tracer, actor: actor}
+}
+
+// Err returns the error of the wrapped Actor recording the latest action (see actions.Recorder).
+func (t *tracedActor) Err() error {
+	if recorder, ok := t.actor.(actions.Recorder); ok {
+		return recorder.Err()
+	}
+
+	return nil
+}
+
+func (t *tracedActor) CreateUser(username string) {
+	span := t.tracer.Start("actions.CreateUser", map[string]string{"username": username})
+	defer span.End()
+
+	t.actor.CreateUser(username)
+}
+
+func (t *tracedActor) CreateVirtualUser(ownerUsername string, username string) {
+	span := t.tracer.Start("actions.CreateVirtualUser", map[string]string{"owner": ownerUsername, "username": username})
+	defer span.End()
+
+	t.actor.CreateVirtualUser(ownerUsername, username)
+}
+
+func (t *tracedActor) DeleteUser(username string) {
+	span := t.tracer.Start("actions.DeleteUser", map[string]string{"username": username})
+	defer span.End()
+
+	t.actor.DeleteUser(username)
+}
+
+func (t *tracedActor) BlockUser(username string, usernameToBlock string) {
+	span := t.tracer.Start("actions.BlockUser", map[string]string{"username": username})
+	defer span.End()
+
+	t.actor.BlockUser(username, usernameToBlock)
+}
+
+func (t *tracedActor) UnblockUser(username string, usernameToUnblock string) {
+	span := t.tracer.Start("actions.UnblockUser", map[string]string{"username": username})
+	defer span.End()
+
+	t.actor.UnblockUser(username, usernameToUnblock)
+}
+
+func (t *tracedActor) MuteChannel(username string, channelname string) {
+	span := t.tracer.Start("actions.MuteChannel", map[string]string{"username": username, "channelname": channelname})
+	defer span.End()
+
+	t.actor.MuteChannel(username, channelname)
+}
+
+func (t *tracedActor) UnmuteChannel(username string, channelname string) {
+	span := t.tracer.Start("actions.UnmuteChannel", map[string]string{"username": username, "channelname": channelname})
+	defer span.End()
+
+	t.actor.UnmuteChannel(username, channelname)
+}
+
+func (t *tracedActor) CreateChannel(channelname string) {
+	span := t.tracer.Start("actions.CreateChannel", map[string]string{"channelname": channelname})
+	defer span.End()
+
+	t.actor.CreateChannel(channelname)
+}
+
+func (t *tracedActor) DeleteChannel(channelname string) {
+	span := t.tracer.Start("actions.DeleteChannel", map[string]string{"channelname": channelname})
+	defer span.End()
+
+	t.actor.DeleteChannel(channelname)
+}
+
+func (t *tracedActor) RestoreChannel(channelname string) {
+	span := t.tracer.Start("actions.RestoreChannel", map[string]string{"channelname": channelname})
+	defer span.End()
+
+	t.actor.RestoreChannel(channelname)
+}
+
+func (t *tracedActor) SetChannelTopic(channelname string, topic string) {
+	span := t.tracer.Start("actions.SetChannelTopic", map[string]string{"channelname": channelname})
+	defer span.End()
+
+	t.actor.SetChannelTopic(channelname, topic)
+}
+
+func (t *tracedActor) SetChannelRules(channelname string, language string, rules string) {
+	span := t.tracer.Start("actions.SetChannelRules", map[string]string{"channelname": channelname})
+	defer span.End()
+
+	t.actor.SetChannelRules(channelname, language, rules)
+}
+
+func (t *tracedActor) JoinChannel(username string, channelname string) {
+	span := t.tracer.Start("actions.JoinChannel", map[string]string{"username": username, "channelname": channelname})
+	defer span.End()
+
+	t.actor.JoinChannel(username, channelname)
+}
+
+func (t *tracedActor) LeaveChannel(username string, channelname string) {
+	span := t.tracer.Start("actions.LeaveChannel", map[string]string{"username": username, "channelname": channelname})
+	defer span.End()
+
+	t.actor.LeaveChannel(username, channelname)
+}
+
+func (t *tracedActor) PostMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	span := t.tracer.Start("actions.PostMessage", map[string]string{"username": username, "channelname": channelname})
+	defer span.End()
+
+	t.actor.PostMessage(channelname, username, timestamp, claimedTimestamp, text)
+}
+
+func (t *tracedActor) PostBridgedMessage(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, text string, originSystem string, originAuthor string) {
+	span := t.tracer.Start("actions.PostBridgedMessage", map[string]string{"username": username, "channelname": channelname, "origin": originSystem})
+	defer span.End()
+
+	t.actor.PostBridgedMessage(channelname, username, timestamp, claimedTimestamp, text, originSystem, originAuthor)
+}
+
+func (t *tracedActor) SetUserProfile(username string, displayName string, bio string, pronouns string) {
+	span := t.tracer.Start("actions.SetUserProfile", map[string]string{"username": username})
+	defer span.End()
+
+	t.actor.SetUserProfile(username, displayName, bio, pronouns)
+}
+
+func (t *tracedActor) SetUserStatus(username string, text string, away bool) {
+	span := t.tracer.Start("actions.SetUserStatus", map[string]string{"username": username})
+	defer span.End()
+
+	t.actor.SetUserStatus(username, text, away)
+}
+
+func (t *tracedActor) PostSnippet(channelname string, username string, timestamp time.Time, claimedTimestamp time.Time, language string, text string) {
+	span := t.tracer.Start("actions.PostSnippet", map[string]string{"username": username, "channelname": channelname, "language": language})
+	defer span.End()
+
+	t.actor.PostSnippet(channelname, username, timestamp, claimedTimestamp, language, text)
+}
+
+func (t *tracedActor) EditMessage(channelname string, messageID uint64, username string, timestamp time.Time, text string) {
+	span := t.tracer.Start("actions.EditMessage", map[string]string{"username": username, "channelname": channelname})
+	defer span.End()
+
+	t.actor.EditMessage(channelname, messageID, username, timestamp, text)
+}
+
+func (t *tracedActor) DeleteMessage(channelname string, messageID uint64, username string) {
+	span := t.tracer.Start("actions.DeleteMessage", map[string]string{"username": username, "channelname": channelname})
+	defer span.End()
+
+	t.actor.DeleteMessage(channelname, messageID, username)
+}
+
+func (t *tracedActor) PostDirectMessage(username string, recipient string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	span := t.tracer.Start("actions.PostDirectMessage", map[string]string{"username": username, "recipient": recipient})
+	defer span.End()
+
+	t.actor.PostDirectMessage(username, recipient, timestamp, claimedTimestamp, text)
+}
+
+func (t *tracedActor) CreateGroup(username string, members []string) {
+	span := t.tracer.Start("actions.CreateGroup", map[string]string{"username": username})
+	defer span.End()
+
+	t.actor.CreateGroup(username, members)
+}
+
+func (t *tracedActor) PostGroupMessage(groupID uint64, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	span := t.tracer.Start("actions.PostGroupMessage", map[string]string{"groupID": strconv.FormatUint(groupID, 10), "username": username})
+	defer span.End()
+
+	t.actor.PostGroupMessage(groupID, username, timestamp, claimedTimestamp, text)
+}
+
+func (t *tracedActor) PutPluginData(namespace string, key string, value string) {
+	span := t.tracer.Start("actions.PutPluginData", map[string]string{"namespace": namespace})
+	defer span.End()
+
+	t.actor.PutPluginData(namespace, key, value)
+}
+
+func (t *tracedActor) RestoreSnapshot(snapshot *actions.Snapshot) {
+	span := t.tracer.Start("actions.RestoreSnapshot", map[string]string{"users": strconv.Itoa(len(snapshot.Users)), "channels": strconv.Itoa(len(snapshot.Channels))})
+	defer span.End()
+
+	t.actor.RestoreSnapshot(snapshot)
+}
+
+func (t *tracedActor) AttachFile(channelname string, messageID uint64, username string, attachment actions.Attachment) {
+	span := t.tracer.Start("actions.AttachFile", map[string]string{"username": username, "channelname": channelname})
+	defer span.End()
+
+	t.actor.AttachFile(channelname, messageID, username, attachment)
+}
+
+func (t *tracedActor) RenameChannel(channelname string, newChannelname string) {
+	span := t.tracer.Start("actions.RenameChannel", map[string]string{"channelname": channelname, "newChannelname": newChannelname})
+	defer span.End()
+
+	t.actor.RenameChannel(channelname, newChannelname)
+}
+
+func (t *tracedActor) RenameUser(username string, newUsername string) {
+	span := t.tracer.Start("actions.RenameUser", map[string]string{"username": username, "newUsername": newUsername})
+	defer span.End()
+
+	t.actor.RenameUser(username, newUsername)
+}
+
+func (t *tracedActor) CreateTeam(teamname string) {
+	span := t.tracer.Start("actions.CreateTeam", map[string]string{"teamname": teamname})
+	defer span.End()
+
+	t.actor.CreateTeam(teamname)
+}
+
+func (t *tracedActor) DeleteTeam(teamname string) {
+	span := t.tracer.Start("actions.DeleteTeam", map[string]string{"teamname": teamname})
+	defer span.End()
+
+	t.actor.DeleteTeam(teamname)
+}
+
+func (t *tracedActor) AddTeamMember(teamname string, username string) {
+	span := t.tracer.Start("actions.AddTeamMember", map[string]string{"teamname": teamname, "username": username})
+	defer span.End()
+
+	t.actor.AddTeamMember(teamname, username)
+}
+
+func (t *tracedActor) RemoveTeamMember(teamname string, username string) {
+	span := t.tracer.Start("actions.RemoveTeamMember", map[string]string{"teamname": teamname, "username": username})
+	defer span.End()
+
+	t.actor.RemoveTeamMember(teamname, username)
+}
+
+func (t *tracedActor) CreateEvent(channelname string, username string, start time.Time, title string) {
+	span := t.tracer.Start("actions.CreateEvent", map[string]string{"channelname": channelname, "username": username})
+	defer span.End()
+
+	t.actor.CreateEvent(channelname, username, start, title)
+}
+
+func (t *tracedActor) RSVPEvent(eventID uint64, username string, going bool) {
+	span := t.tracer.Start("actions.RSVPEvent", map[string]string{"eventID": strconv.FormatUint(eventID, 10), "username": username})
+	defer span.End()
+
+	t.actor.RSVPEvent(eventID, username, going)
+}
+
+func (t *tracedActor) MarkEventReminded(eventID uint64) {
+	span := t.tracer.Start("actions.MarkEventReminded", map[string]string{"eventID": strconv.FormatUint(eventID, 10)})
+	defer span.End()
+
+	t.actor.MarkEventReminded(eventID)
+}
+
+func (t *tracedActor) MarkRead(username string, channelname string, messageID uint64) {
+	span := t.tracer.Start("actions.MarkRead", map[string]string{"username": username, "channelname": channelname, "messageID": strconv.FormatUint(messageID, 10)})
+	defer span.End()
+
+	t.actor.MarkRead(username, channelname, messageID)
+}
+
+func (t *tracedActor) PostMessageMulti(channelnames []string, username string, timestamp time.Time, claimedTimestamp time.Time, text string) {
+	span := t.tracer.Start("actions.PostMessageMulti", map[string]string{"username": username, "channelnames": strings.Join(channelnames, ",")})
+	defer span.End()
+
+	t.actor.PostMessageMulti(channelnames, username, timestamp, claimedTimestamp, text)
+}
+
+func (t *tracedActor) StarMessage(username string, channelname string, messageID uint64, starred bool) {
+	span := t.tracer.Start("actions.StarMessage", map[string]string{"username": username, "channelname": channelname, "messageID": strconv.FormatUint(messageID, 10), "starred": strconv.FormatBool(starred)})
+	defer span.End()
+
+	t.actor.StarMessage(username, channelname, messageID, starred)
+}
+
+func (t *tracedActor) SetChannelNotes(channelname string, username string, timestamp time.Time, text string) {
+	span := t.tracer.Start("actions.SetChannelNotes", map[string]string{"channelname": channelname, "username": username})
+	defer span.End()
+
+	t.actor.SetChannelNotes(channelname, username, timestamp, text)
+}
+
+func (t *tracedActor) BeginBatch() {
+	span := t.tracer.Start("actions.BeginBatch", nil)
+	defer span.End()
+
+	t.actor.BeginBatch()
+}
+
+func (t *tracedActor) EndBatch() {
+	span := t.tracer.Start("actions.EndBatch", nil)
+	defer span.End()
+
+	t.actor.EndBatch()
+}
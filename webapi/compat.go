@@ -0,0 +1,219 @@
+package webapi
+
+import (
+	"chatserver/model"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinProtocolVersion is the oldest version of the JSON RPC API still served.
+//
+// A request selects the version it's served with by its method name: "<registeredAPI>.v2.<method>"
+// is served with version 2, and unversioned names ("<registeredAPI>.<method>") with version 1, so
+// existing clients keep working as the API changes.  The methods are handled in the latest
+// version, and the compatibility shims adapt the responses to the older versions.
+//
+// Changes by version
+//
+//	2 - timestamps are RFC 3339 (including the time zone), like the ones clients send, rather
+//	    than "2006-01-02 15:04:05"
+//	3 - changes the model rejects (e.g. creating a user that already exists) are errors saying
+//	    why, rather than empty responses (or PostMessage responses with Posted false)
+//	4 - an edited (or deleted) message is an OnMessageChanged notification (and event) with the channel and
+//	    message ID, rather than OnChannelChanged (connect with /ws?protocol=4 for the
+//	    notifications)
+const MinProtocolVersion int = 1
+
+// parseVersionedMethod returns the service method to serve for the method name of a request, along
+// with the protocol version to serve it with.  Unsupported versions are left in the name, so the
+// client is told the method can't be found.
+func parseVersionedMethod(serviceMethod string) (string, int) {
+	fields := strings.Split(serviceMethod, ".")
+	if len(fields) != 3 || !strings.HasPrefix(fields[1], "v") {
+		return serviceMethod, MinProtocolVersion
+	}
+
+	version, err := strconv.Atoi(fields[1][1:])
+	if err != nil || version < MinProtocolVersion || version > ProtocolVersion {
+		return serviceMethod, MinProtocolVersion
+	}
+
+	return fields[0] + "." + fields[2], version
+}
+
+// deprecatedMethods returns the deprecated methods, with the warning sent to the clients that call
+// them (once per connection, as an OnDeprecated notification).
+func deprecatedMethods() map[string]string {
+	return map[string]string{
+		"GetBuiltinNames": "GetBuiltinNames is deprecated, the built-in names are in the InitialState notification",
+	}
+}
+
+// downgradeResponse adapts a response (or the initial state) to an older protocol version.
+func downgradeResponse(version int, body interface{}) {
+	// Version 3 events
+	if version < 4 {
+		switch response := body.(type) {
+		case *GetEventsSinceResponse:
+			downgradeEvents(response.Events)
+		case *ResumeResponse:
+			downgradeEvents(response.Events)
+		}
+	}
+
+	if version >= 2 {
+		return
+	}
+
+	// Version 1 timestamps
+	switch response := body.(type) {
+	case *GetChannelHistoryResponse:
+		downgradeMessages(response.Messages)
+	case *GetChannelHistoryRangeResponse:
+		downgradeMessages(response.Messages)
+	case *GetChannelHistoryByTimeResponse:
+		downgradeMessages(response.Messages)
+	case *SearchChannelHistoryResponse:
+		downgradeMessages(response.Messages)
+	case *GetMentionsResponse:
+		for i := range response.Mentions {
+			downgradeMessage(&response.Mentions[i].Message)
+		}
+	case *GetStarredMessagesResponse:
+		for i := range response.Messages {
+			downgradeMessage(&response.Messages[i].Message)
+		}
+	case *InitialState:
+		downgradeMessages(response.Messages)
+	case *BrowseChannelsResponse:
+		for i := range response.Channels {
+			response.Channels[i].LastActivity = downgradeTimestamp(response.Channels[i].LastActivity)
+		}
+	case *PostMessageResponse:
+		response.Timestamp = downgradeTimestamp(response.Timestamp)
+	case *PostMessageMultiResponse:
+		response.Timestamp = downgradeTimestamp(response.Timestamp)
+	case *PostBridgedMessageResponse:
+		response.Timestamp = downgradeTimestamp(response.Timestamp)
+	case *PostSnippetResponse:
+		response.Timestamp = downgradeTimestamp(response.Timestamp)
+	case *GetChannelNotesResponse:
+		response.Edited = downgradeTimestamp(response.Edited)
+	}
+}
+
+// downgradeError adapts the response to a request the model rejected to an older protocol version,
+// returning the body to send.
+func downgradeError(version int, response *rpc.Response, body interface{}) interface{} {
+	if version >= 3 || !isRejection(response.Error) {
+		return body
+	}
+
+	// Version 1 and 2 rejections are empty responses
+	response.Error = ""
+	if strings.HasSuffix(response.ServiceMethod, ".PostMessage") {
+		return &PostMessageResponse{}
+	}
+
+	return struct{}{}
+}
+
+// isRejection returns whether an error response is the model rejecting a change.
+func isRejection(responseError string) bool {
+	rejections := []error{
+		model.ErrUserExists,
+		model.ErrUserNotFound,
+		model.ErrUserProtected,
+		model.ErrInvalidName,
+		model.ErrInvalidOwner,
+		model.ErrBuiltinUser,
+		model.ErrInvalidProfile,
+		model.ErrInvalidStatus,
+		model.ErrBlockSelf,
+		model.ErrNotBlocked,
+		model.ErrMessageSelf,
+		model.ErrGroupTooSmall,
+		model.ErrGroupNotFound,
+		model.ErrNotGroupMember,
+		model.ErrTeamExists,
+		model.ErrTeamNotFound,
+		model.ErrAlreadyTeamMember,
+		model.ErrNotTeamMember,
+		model.ErrInvalidEvent,
+		model.ErrEventNotFound,
+		model.ErrChannelExists,
+		model.ErrChannelNotFound,
+		model.ErrChannelProtected,
+		model.ErrChannelReadOnly,
+		model.ErrNotMuted,
+		model.ErrNotRestorable,
+		model.ErrAlreadyMember,
+		model.ErrNotMember,
+		model.ErrInvalidLanguage,
+		model.ErrInvalidNotes,
+		model.ErrNotesChanged,
+		model.ErrInvalidSnippet,
+		model.ErrEmptyMessage,
+		model.ErrDuplicateMessage,
+		model.ErrInvalidCrossPost,
+		model.ErrMessageNotFound,
+		model.ErrNotAuthor,
+		model.ErrInvalidAttachment,
+		model.ErrHasHistory,
+		model.ErrMissingOrigin,
+		model.ErrUnknownMutation,
+		model.ErrUserQuota,
+		model.ErrChannelQuota,
+		model.ErrMessageQuota,
+		model.ErrReadOnly,
+	}
+
+	for _, rejection := range rejections {
+		if responseError == rejection.Error() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func downgradeMessages(messages []ChannelHistoryMessage) {
+	for i := range messages {
+		downgradeMessage(&messages[i])
+	}
+}
+
+func downgradeMessage(message *ChannelHistoryMessage) {
+	message.Timestamp = downgradeTimestamp(message.Timestamp)
+	message.ClaimedTimestamp = downgradeTimestamp(message.ClaimedTimestamp)
+	message.Edited = downgradeTimestamp(message.Edited)
+}
+
+// downgradeEvents replaces message changes with changes to their channels (the client fetches the
+// channel's history again).
+func downgradeEvents(events []Event) {
+	for i := range events {
+		if events[i].Method == "OnMessageChanged" {
+			events[i].Method = "OnChannelChanged"
+			events[i].MessageID = 0
+		}
+	}
+}
+
+// downgradeTimestamp converts an RFC 3339 timestamp to a version 1 timestamp (the same wall clock
+// time, without the time zone).
+func downgradeTimestamp(timestamp string) string {
+	parsed, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return timestamp
+	}
+
+	return parsed.Format("2006-01-02 15:04:05")
+}
+
+// formatTimestamp formats a time for a response (in the latest version).
+func formatTimestamp(timestamp time.Time) string {
+	return timestamp.Format(time.RFC3339)
+}
@@ -0,0 +1,82 @@
+package webapi
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+)
+
+// maxLoggedParams bounds the length of the params written to the request log.
+const maxLoggedParams int = 256
+
+// SetRequestLogging turns logging every request (its method, the client's address, how long it
+// took and its params, truncated and with secrets redacted) on or off.
+func (h *ConnectionHandler) SetRequestLogging(enabled bool) {
+	value := int32(0)
+	if enabled {
+		value = 1
+	}
+
+	atomic.StoreInt32(&h.requestLogging, value)
+}
+
+// RequestLogging returns whether requests are being logged.
+func (h *ConnectionHandler) RequestLogging() bool {
+	return atomic.LoadInt32(&h.requestLogging) == 1
+}
+
+// formatLoggedParams formats the params of a request for the request log.  The values of fields
+// that hold secrets (passwords, tokens and session IDs, at any depth) are redacted.
+func formatLoggedParams(params interface{}) string {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "?"
+	}
+
+	var value interface{}
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return "?"
+	}
+
+	data, err = json.Marshal(redact(value))
+	if err != nil {
+		return "?"
+	}
+
+	if len(data) > maxLoggedParams {
+		return string(data[:maxLoggedParams]) + "..."
+	}
+
+	return string(data)
+}
+
+func redact(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for field, fieldValue := range value {
+			if isSecretField(field) {
+				value[field] = "REDACTED"
+			} else {
+				value[field] = redact(fieldValue)
+			}
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = redact(value[i])
+		}
+	}
+
+	return value
+}
+
+func isSecretField(field string) bool {
+	field = strings.ToLower(field)
+	for _, secret := range []string{"password", "token", "secret", "sessionid"} {
+		if strings.Contains(field, secret) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,141 +4,3391 @@
 package webapi
 
 import (
+	"chatserver/attachments"
+	"chatserver/clienterrors"
+	"chatserver/credentials"
+	"chatserver/drafts"
 	"chatserver/model"
+	"chatserver/model/fuzzy"
 	"chatserver/model/subs"
+	"chatserver/pins"
+	"chatserver/preferences"
+	"chatserver/projections"
+	"chatserver/reactions"
+	"chatserver/security"
+	"chatserver/sessions"
+	"chatserver/tracing"
 	"chatserver/webconn"
+	"errors"
 	"log"
+	"net"
+	"net/http"
 	"net/rpc"
 	"net/rpc/jsonrpc"
+	"reflect"
+	"runtime/debug"
 	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/websocket"
 )
 
-// NewConnectionHandler creates a new websocket Handler that will manage individual
-// websocket connections.  It will serve a JSON RPC API on that connection.
-func NewConnectionHandler(subsEngine *subs.Engine) websocket.Handler {
-	connectionHandler := func(ws *websocket.Conn) {
-		webConn := webconn.NewWebConn(ws)
+// ConnectionHandler manages individual websocket connections.  It will serve a JSON RPC API on
+// each connection (traced with the given tracer, which may be nil, and logged when request logging
+// is on), after pushing the initial state from the API instance.
+type ConnectionHandler struct {
+	subsEngine     *subs.Engine
+	instance       *WebAPI
+	tracer         *tracing.Tracer
+	connections    int32
+	requestLogging int32
+}
+
+// NewConnectionHandler creates/initializes/returns a new ConnectionHandler.
+func NewConnectionHandler(subsEngine *subs.Engine, instance *WebAPI, tracer *tracing.Tracer) *ConnectionHandler {
+	handler := ConnectionHandler{
+		subsEngine: subsEngine,
+		instance:   instance,
+		tracer:     tracer,
+	}
+
+	return &handler
+}
+
+// ServeHTTP satisfies the http Handler interface by upgrading the request to a websocket.
+func (h *ConnectionHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	websocket.Handler(h.serveConn).ServeHTTP(writer, request)
+}
+
+// NumConnections returns the number of open websocket connections.
+func (h *ConnectionHandler) NumConnections() int {
+	return int(atomic.LoadInt32(&h.connections))
+}
+
+// serveConn serves a single websocket connection.  No goroutines are started per connection, so
+// everything for the connection ends when it returns (and the websocket is closed).
+func (h *ConnectionHandler) serveConn(ws *websocket.Conn) {
+	atomic.AddInt32(&h.connections, 1)
+	defer atomic.AddInt32(&h.connections, -1)
+
+	// The notifications are sent in the protocol version in the URL
+	query := ws.Request().URL.Query()
+	version, _ := strconv.Atoi(query.Get("protocol"))
+	webConn := webconn.NewWebConn(ws, version >= 4)
+
+	// Connect the subscriptions for this web conn
+	err := h.subsEngine.Connect(webConn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Push the state the client needs to render (for the session in the URL, if it's still
+	// around) so it doesn't have to ask for each part of it in turn.  The subscriptions are
+	// connected first, so no update made in the meantime is missed.
+	seq := h.subsEngine.LastSeq()
+	state := h.instance.initialState(query.Get("session"))
+	webConn.SetUsername(state.Username)
+	downgradeResponse(version, &state)
+	err = webConn.SendInitialState(seq, state)
+
+	// The connection's user is online until it's closed
+	connectionID := h.instance.model.Connect(state.Username)
+
+	// For a single connection, handle requests sequentially
+	codec := &requestCodec{ServerCodec: jsonrpc.NewServerCodec(ws), tracer: h.tracer, handler: h, caller: ws.Request().RemoteAddr, webConn: webConn, connectionID: connectionID, warned: make(map[string]bool)}
+	for err == nil {
+		err = serveRequest(codec)
+	}
+
+	h.instance.model.Disconnect(connectionID)
+
+	// Disconnect the subscriptions for this web conn
+	err = h.subsEngine.Disconnect(webConn)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// requestCodec remembers the header of the request being served so that an error response can
+// still be sent if handling the request panics.  It also traces each request from reading its
+// header to writing its response, serves it with the protocol version it asks for (see
+// MinProtocolVersion) and warns the client the first time it calls a deprecated method.  When the
+// handler's request logging is on, each request is logged once its response is written.
+type requestCodec struct {
+	rpc.ServerCodec
+	tracer    *tracing.Tracer
+	handler   *ConnectionHandler
+	caller    string
+	webConn   *webconn.WebConn
+	warned    map[string]bool
+	request   rpc.Request
+	version   int
+	span      *tracing.Span
+	started   time.Time
+	logParams string
+
+	// sessionUsername is the user of the session being created or updated by the request
+	sessionUsername string
+
+	// connectionID identifies the connection to the model's presence tracking
+	connectionID uint64
+}
+
+func (c *requestCodec) ReadRequestHeader(request *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(request)
+	if err == nil {
+		request.ServiceMethod, c.version = parseVersionedMethod(request.ServiceMethod)
+	}
+	c.request = *request
+	c.started = time.Now()
+	c.logParams = ""
+	if err == nil {
+		c.span = c.tracer.StartRequest(request.ServiceMethod, map[string]string{
+			"rpc.system":  "jsonrpc",
+			"rpc.method":  request.ServiceMethod,
+			"rpc.version": strconv.Itoa(c.version),
+		})
+
+		method := request.ServiceMethod[strings.LastIndex(request.ServiceMethod, ".")+1:]
+		if warning, ok := deprecatedMethods()[method]; ok && !c.warned[method] {
+			c.warned[method] = true
+			err = c.webConn.SendDeprecation(method, warning)
+		}
+	}
+	return err
+}
+
+func (c *requestCodec) ReadRequestBody(body interface{}) error {
+	err := c.ServerCodec.ReadRequestBody(body)
+	if err == nil && body != nil && c.handler.RequestLogging() {
+		c.logParams = formatLoggedParams(body)
+	}
+
+	// The session's user is the one the connection is notified of direct messages for (and is
+	// online as), once the session is accepted (see WriteResponse)
+	c.sessionUsername = ""
+	switch args := body.(type) {
+	case *CreateSessionArgs:
+		c.sessionUsername = args.Username
+		args.address, _, _ = net.SplitHostPort(c.caller)
+	case *UpdateSessionArgs:
+		c.sessionUsername = args.Username
+	}
+	return err
+}
+
+// setUsername sets the user the connection is acting as.
+func (c *requestCodec) setUsername(username string) {
+	c.webConn.SetUsername(username)
+	c.handler.instance.model.SetConnectionUser(c.connectionID, username)
+}
+
+func (c *requestCodec) WriteResponse(response *rpc.Response, body interface{}) error {
+	if response.Error != "" {
+		c.span.SetAttribute("error", response.Error)
+	}
+	c.span.End()
+	c.span = nil
+
+	if c.handler.RequestLogging() {
+		result := "ok"
+		if response.Error != "" {
+			result = "error: " + response.Error
+		}
+		log.Printf("web request %s from %s: %s in %v, params %s", response.ServiceMethod, c.caller, result, time.Since(c.started), c.logParams)
+	}
+
+	if resumed, ok := body.(*ResumeResponse); ok && resumed.Resumed {
+		c.setUsername(resumed.Username)
+	}
+
+	if c.sessionUsername != "" && response.Error == "" {
+		if updated, ok := body.(*UpdateSessionResponse); !ok || updated.Updated {
+			c.setUsername(c.sessionUsername)
+		}
+	}
+
+	body = downgradeError(c.version, response, body)
+	downgradeResponse(c.version, body)
+	return c.ServerCodec.WriteResponse(response, body)
+}
+
+// serveRequest serves a single request on the codec.  A panic while handling the request is
+// recovered (and logged with a stack trace) so one bad request can't take down the server; the
+// client gets an error response and the connection carries on.
+func serveRequest(codec *requestCodec) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic handling JSON RPC request %q: %v\n%s", codec.request.ServiceMethod, r, debug.Stack())
+			response := rpc.Response{
+				ServiceMethod: codec.request.ServiceMethod,
+				Seq:           codec.request.Seq,
+				Error:         "internal error",
+			}
+			err = codec.WriteResponse(&response, nil)
+		}
+	}()
+
+	return rpc.ServeRequest(codec)
+}
+
+// ProtocolVersion is the latest version of the JSON RPC API (see MinProtocolVersion).
+const ProtocolVersion int = 4
+
+// InstanceOptions describe the deployment to clients (see GetServerInfo): the server version, the
+// account passwords (nil when authentication is disabled) and the store of the uploaded
+// attachments (nil when attachments are disabled).
+type InstanceOptions struct {
+	Version     string
+	Credentials *credentials.Credentials
+	Attachments *attachments.Store
+}
+
+// WebAPI provides the JSON RPC service API.  Read requests are spread across the read
+// replicas of the model (when there are any).
+type WebAPI struct {
+	model       *model.Model
+	subsEngine  *subs.Engine
+	sessions    *sessions.Store
+	replicas    []*model.Model
+	nextReplica uint32
+	searchIndex  *projections.SearchIndex
+	clientErrors *clienterrors.Buffer
+	options      InstanceOptions
+	commands     *fuzzy.Index
+}
+
+// NewInstance creates/initializes/returns a new WebAPI instance.  The replicas and search
+// index must be kept up to date with the model (e.g. fed its actions via an actions.Fanout),
+// the search index may be nil when message search is disabled, and the errors reported by clients
+// are kept in the client errors buffer.
+func NewInstance(model *model.Model, subsEngine *subs.Engine, sessions *sessions.Store, replicas []*model.Model, searchIndex *projections.SearchIndex, clientErrors *clienterrors.Buffer, options InstanceOptions) *WebAPI {
+	instance := WebAPI{
+		model:        model,
+		subsEngine:   subsEngine,
+		sessions:     sessions,
+		replicas:     replicas,
+		searchIndex:  searchIndex,
+		clientErrors: clientErrors,
+		options:      options,
+		commands:     fuzzy.NewIndex(),
+	}
+
+	for _, method := range rpcMethods() {
+		if _, ok := deprecatedMethods()[method]; !ok {
+			instance.commands.Add(FuzzyKindCommand, method)
+		}
+	}
+
+	return &instance
+}
+
+// rpcMethods returns the names of the methods served over JSON RPC (the WebAPI methods net/rpc
+// registers: the exported ones taking a request and a response pointer and returning an error).
+func rpcMethods() []string {
+	methods := make([]string, 0)
+	webAPIType := reflect.TypeOf(&WebAPI{})
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	for i := 0; i < webAPIType.NumMethod(); i++ {
+		methodType := webAPIType.Method(i).Type
+		if methodType.NumIn() == 3 && methodType.In(2).Kind() == reflect.Ptr && methodType.NumOut() == 1 && methodType.Out(0) == errorType {
+			methods = append(methods, webAPIType.Method(i).Name)
+		}
+	}
+
+	return methods
+}
+
+// isRegistered returns whether a user is a registered account (has a password).
+func (w *WebAPI) isRegistered(username string) bool {
+	return w.options.Credentials != nil && w.options.Credentials.HasPassword(username)
+}
+
+// reader returns the model to serve a read request from (the next replica in turn, or the
+// model itself when there are no replicas).
+func (w *WebAPI) reader() *model.Model {
+	if len(w.replicas) == 0 {
+		return w.model
+	}
+
+	index := atomic.AddUint32(&w.nextReplica, 1)
+	return w.replicas[index%uint32(len(w.replicas))]
+}
+
+// GetServerInfoArgs provides the input arguments for the GetServerInfo action.
+type GetServerInfoArgs struct {
+}
+
+// GetServerInfoResponse provides the output arguments for the GetServerInfo action.
+type GetServerInfoResponse struct {
+	Version            string
+	ProtocolVersion    int
+	MinProtocolVersion int
+	Features           map[string]bool
+	ThumbnailSizes     []int
+}
+
+// GetServerInfo will get the server version, the versions of this API served and which of the optional
+// features are enabled on this server, so clients can adapt rather than assume every feature
+// exists.  The features are "auth" (account passwords), "message_search" (SearchChannelHistory),
+// "sessions" (CreateSession/Resume), "batch_mutate" (BatchMutate), "initial_state" (the
+// InitialState notification), "attachments" (AttachFile), "thumbnails" (the image thumbnails, served at
+// "/attachments/<ID>?thumbnail=<size>" in each of ThumbnailSizes), "snippets" (PostSnippet),
+// "drafts" (SetDraft/GetDrafts), "teams" (CreateTeam/GetTeams), "presence" (GetPresence and the
+// OnPresenceChanged notification), "calendar" (CreateEvent/GetCalendar), "read_markers"
+// (MarkRead/GetReadMarkers/GetUnreadCounts), "mentions" (GetMentions and the OnMentioned
+// notification), "cross_posting" (PostMessageMulti), "stars" (StarMessage/GetStarredMessages),
+// "notes" (GetChannelNotes/SetChannelNotes), "reactions" (ReactToMessage/GetReactions), "pins"
+// (GetPinnedMessages), "quick_switcher" (FuzzyFind), "security_events"
+// (GetSecurityEvents and the OnSecurityEvent notification, with account passwords) and "threads" (not
+// supported yet).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetServerInfo",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Version": "v1.2.0",
+//     "ProtocolVersion": 4,
+//     "MinProtocolVersion": 1,
+//     "Features": {
+//         "attachments": false,
+//         "auth": true,
+//         "batch_mutate": true,
+//         "calendar": true,
+//         "cross_posting": true,
+//         "drafts": true,
+//         "initial_state": true,
+//         "mentions": true,
+//         "message_search": false,
+//         "notes": true,
+//         "pins": true,
+//         "presence": true,
+//         "quick_switcher": true,
+//         "reactions": true,
+//         "read_markers": true,
+//         "security_events": true,
+//         "sessions": true,
+//         "snippets": true,
+//         "stars": true,
+//         "teams": true,
+//         "threads": false,
+//         "thumbnails": false
+//     },
+//     "ThumbnailSizes": []
+// }
+func (w *WebAPI) GetServerInfo(args *GetServerInfoArgs, response *GetServerInfoResponse) error {
+	response.Version = w.options.Version
+	response.ProtocolVersion = ProtocolVersion
+	response.MinProtocolVersion = MinProtocolVersion
+	response.Features = map[string]bool{
+		"auth":            w.options.Credentials != nil,
+		"message_search":  w.searchIndex != nil,
+		"sessions":        true,
+		"batch_mutate":    true,
+		"initial_state":   true,
+		"attachments":     w.options.Attachments != nil,
+		"thumbnails":      false,
+		"snippets":        true,
+		"drafts":          true,
+		"teams":           true,
+		"presence":        true,
+		"calendar":        true,
+		"read_markers":    true,
+		"mentions":        true,
+		"cross_posting":   true,
+		"stars":           true,
+		"notes":           true,
+		"reactions":       true,
+		"pins":            true,
+		"quick_switcher":  true,
+		"security_events": w.options.Credentials != nil,
+		"threads":         false,
+	}
+	response.ThumbnailSizes = []int{}
+	if w.options.Attachments != nil && len(w.options.Attachments.ThumbnailSizes()) > 0 {
+		response.Features["thumbnails"] = true
+		response.ThumbnailSizes = w.options.Attachments.ThumbnailSizes()
+	}
+
+	return nil
+}
+
+// ReportClientErrorArgs provides the input arguments for the ReportClientError action.
+type ReportClientErrorArgs struct {
+	Kind      string
+	Message   string
+	Details   string
+	UserAgent string
+}
+
+// ReportClientErrorResponse provides the output arguments for the ReportClientError action.
+type ReportClientErrorResponse struct {
+}
+
+// ReportClientError will report an error in the client for admins to look into (see the GetClientErrors admin RPC).
+// The Kind is "error" (e.g. an uncaught JavaScript error, with its stack trace as the details) or "protocol" (a
+// response or notification the client didn't expect).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.ReportClientError",
+//     "params": [{
+//         "Kind": "error",
+//         "Message": "TypeError: result.User is undefined",
+//         "Details": "updateCurrentUserInfo@http://localhost:8080/:250:21\n...",
+//         "UserAgent": "Mozilla/5.0 ..."
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) ReportClientError(args *ReportClientErrorArgs, response *ReportClientErrorResponse) error {
+	// Validate the user input
+	if args.Kind != "error" && args.Kind != "protocol" {
+		return nil
+	}
+
+	w.clientErrors.Add(args.Kind, args.Message, args.Details, args.UserAgent)
+
+	return nil
+}
+
+// GetBuiltinNamesArgs provides the input arguments for the GetBuiltinNames action.
+type GetBuiltinNamesArgs struct {
+}
+
+// GetBuiltinNamesResponse provides the output arguments for the GetBuiltinNames action.
+type GetBuiltinNamesResponse struct {
+	Username    string
+	Channelname string
+}
+
+// GetBuiltinNames will get the names of the built-in user and channel that always exist.  Deprecated: they're in the
+// InitialState notification.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetBuiltinNames",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Username": "Anonymous",
+//     "Channelname": "General"
+// }
+func (w *WebAPI) GetBuiltinNames(args *GetBuiltinNamesArgs, response *GetBuiltinNamesResponse) error {
+	response.Username = w.model.BuiltinUsername()
+	response.Channelname = w.model.BuiltinChannelname()
+
+	return nil
+}
+
+// GetEventsSinceArgs provides the input arguments for the GetEventsSince action.
+type GetEventsSinceArgs struct {
+	Seq uint64
+}
+
+// Event provides a translation of the subs.Event struct
+type Event struct {
+	Seq         uint64
+	Method      string
+	Username    string
+	Channelname string
+	MessageID   uint64
+}
+
+// GetEventsSinceResponse provides the output arguments for the GetEventsSince action.
+type GetEventsSinceResponse struct {
+	Events   []Event
+	Complete bool
+	LastSeq  uint64
+}
+
+// GetEventsSince will get the subscription updates made after a sequence number (the "seq" of the last update a
+// reconnecting client saw), oldest first.  When Complete is false some of them are no longer available and the
+// client should refetch everything instead.  LastSeq is the sequence number of the latest update.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetEventsSince",
+//     "params": [{
+//         "Seq": 12
+//     }]
+// }
+//
+// Output
+// {
+//     "Events": [{
+//         "Seq": 13,
+//         "Method": "OnChannelChanged",
+//         "Username": "",
+//         "Channelname": "Channel1",
+//         "MessageID": 0
+//     }],
+//     "Complete": true,
+//     "LastSeq": 13
+// }
+func (w *WebAPI) GetEventsSince(args *GetEventsSinceArgs, response *GetEventsSinceResponse) error {
+	response.LastSeq = w.subsEngine.LastSeq()
+
+	events, complete := w.subsEngine.EventsSince(args.Seq)
+	response.Events = make([]Event, len(events))
+	for i, event := range events {
+		response.Events[i].Seq = event.Seq
+		response.Events[i].Method = event.Method
+		switch event.Method {
+		case "OnUserChanged":
+			response.Events[i].Username = event.Name
+		case "OnChannelChanged":
+			response.Events[i].Channelname = event.Name
+		case "OnMessageChanged":
+			response.Events[i].Channelname = event.Name
+			response.Events[i].MessageID = event.MessageID
+		case "OnChannelRenamed":
+			// Web clients don't follow renames, they're told the channels changed
+			response.Events[i].Method = "OnChannelsChanged"
+		case "OnUserRenamed":
+			response.Events[i].Method = "OnUsersChanged"
+		}
+	}
+	response.Complete = complete
+
+	return nil
+}
+
+// CreateSessionArgs provides the input arguments for the CreateSession action.
+type CreateSessionArgs struct {
+	Username    string
+	Password    string
+	Code        string
+	Channelname string
+
+	// address is the client's address (its IP), which failed logins are tracked by along with the
+	// account (see credentials.Throttle)
+	address string
+}
+
+// CreateSessionResponse provides the output arguments for the CreateSession action.
+type CreateSessionResponse struct {
+	SessionID string
+	LastSeq   uint64
+}
+
+// CreateSession will start a session that can be resumed after the connection drops (see Resume).  LastSeq is the
+// sequence number of the latest subscription update.  A session for a registered account (a user with a password)
+// needs its password, and a Code from its authenticator (or a recovery code) if it has two-factor authentication
+// enabled, with failed logins throttled (see credentials.Throttle); the session ID then stands in for them when the
+// session is resumed.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.CreateSession",
+//     "params": [{
+//         "Username": "User1",
+//         "Password": "Password1",
+//         "Code": "123456",
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+//     "SessionID": "3f2a...",
+//     "LastSeq": 12
+// }
+func (w *WebAPI) CreateSession(args *CreateSessionArgs, response *CreateSessionResponse) error {
+	if w.isRegistered(args.Username) {
+		err := w.options.Credentials.Login(args.Username, args.Password, args.Code, args.address)
+		if err != nil {
+			return err
+		}
+	}
+
+	lastSeq := w.subsEngine.LastSeq()
+	session := w.sessions.Create(args.Username, args.Channelname, lastSeq)
+	response.SessionID = session.ID
+	response.LastSeq = lastSeq
+
+	return nil
+}
+
+// UpdateSessionArgs provides the input arguments for the UpdateSession action.
+type UpdateSessionArgs struct {
+	SessionID   string
+	Username    string
+	Channelname string
+	LastSeq     uint64
+}
+
+// UpdateSessionResponse provides the output arguments for the UpdateSession action.
+type UpdateSessionResponse struct {
+	Updated bool
+}
+
+// UpdateSession will record the current user and channel of a session, and the sequence number of the last
+// subscription update it saw.  Updated is false if the session has expired.  A session can only switch to a
+// registered account with CreateSession (which checks the password).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.UpdateSession",
+//     "params": [{
+//         "SessionID": "3f2a...",
+//         "Username": "User1",
+//         "Channelname": "Channel1",
+//         "LastSeq": 14
+//     }]
+// }
+//
+// Output
+// {
+//     "Updated": true
+// }
+func (w *WebAPI) UpdateSession(args *UpdateSessionArgs, response *UpdateSessionResponse) error {
+	if session, ok := w.sessions.Get(args.SessionID); ok && session.Username != args.Username && w.isRegistered(args.Username) {
+		return credentials.ErrInvalidLogin
+	}
+
+	response.Updated = w.sessions.Update(args.SessionID, args.Username, args.Channelname, args.LastSeq)
+
+	return nil
+}
+
+// ResumeArgs provides the input arguments for the Resume action.
+type ResumeArgs struct {
+	SessionID string
+	LastSeq   uint64
+}
+
+// ResumeResponse provides the output arguments for the Resume action.
+type ResumeResponse struct {
+	Resumed     bool
+	Username    string
+	Channelname string
+	Events      []Event
+	Complete    bool
+	LastSeq     uint64
+}
+
+// Resume will restore a session after the connection drops, returning its user and channel along with the
+// subscription updates made since the last one it saw (the later of the given LastSeq and the one recorded for the
+// session), the same as GetEventsSince.  Resumed is false if the session has expired (the client should start over
+// with a new session).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.Resume",
+//     "params": [{
+//         "SessionID": "3f2a...",
+//         "LastSeq": 14
+//     }]
+// }
+//
+// Output
+// {
+//     "Resumed": true,
+//     "Username": "User1",
+//     "Channelname": "Channel1",
+//     "Events": [{
+//         "Seq": 15,
+//         "Method": "OnChannelChanged",
+//         "Username": "",
+//         "Channelname": "Channel1",
+//         "MessageID": 0
+//     }],
+//     "Complete": true,
+//     "LastSeq": 15
+// }
+func (w *WebAPI) Resume(args *ResumeArgs, response *ResumeResponse) error {
+	response.Events = make([]Event, 0)
+
+	session, ok := w.sessions.Get(args.SessionID)
+	if !ok {
+		return nil
+	}
+
+	lastSeq := session.LastSeq
+	if args.LastSeq > lastSeq {
+		lastSeq = args.LastSeq
+	}
+
+	eventsResponse := GetEventsSinceResponse{}
+	err := w.GetEventsSince(&GetEventsSinceArgs{Seq: lastSeq}, &eventsResponse)
+	if err != nil {
+		return err
+	}
+
+	response.Resumed = true
+	response.Username = session.Username
+	response.Channelname = session.Channelname
+	response.Events = eventsResponse.Events
+	response.Complete = eventsResponse.Complete
+	response.LastSeq = eventsResponse.LastSeq
+
+	return nil
+}
+
+// InitialState is pushed to each web client when it connects: the users and channels, and the
+// current user's info, preferences, drafts, read markers, joined channels and unread counts (the
+// messages in each joined channel posted by others after the user's read marker), along with the
+// current channel's info and history.  The current user and channel are the session's when the client connects
+// with a session that can be resumed (Resumed is true), otherwise the built-in ones.
+//
+// JSON Notification Definition
+// ----------------------------
+//
+// Output
+// {
+//     "method": "InitialState",
+//     "seq": 15,
+//     "state": {
+//         "BuiltinUsername": "Anonymous",
+//         "BuiltinChannelname": "General",
+//         "Resumed": true,
+//         "Username": "User1",
+//         "Channelname": "Channel1",
+//         "Users": ["User1", "User2"],
+//         "Statuses": {"User2": {"Text": "lunch", "Away": true}},
+//         "Channels": ["Channel1", "Channel2"],
+//         "JoinedChannels": ["Channel1"],
+//         "User": {...},
+//         "Preferences": {"screenreader": "on"},
+//         "Drafts": {"Channel1": "Text1"},
+//         "ReadMarkers": {"Channel1": 12},
+//         "UnreadCounts": {"Channel1": 2},
+//         "Channel": {...},
+//         "Messages": [{...}]
+//     }
+// }
+type InitialState struct {
+	BuiltinUsername    string
+	BuiltinChannelname string
+	Resumed            bool
+	Username           string
+	Channelname        string
+	Users              []string
+	Statuses           map[string]model.Status
+	Channels           []string
+	JoinedChannels     []string
+	User               model.User
+	Preferences        map[string]string
+	Drafts             map[string]string
+	ReadMarkers        map[string]uint64
+	UnreadCounts       map[string]int
+	Channel            model.ChannelInfo
+	Messages           []ChannelHistoryMessage
+}
+
+// initialState returns the initial state for a client connecting with a session ID (empty if it
+// doesn't have one).  It's read from the model rather than a replica, which may not have caught
+// up with the updates the client won't be told about.
+func (w *WebAPI) initialState(sessionID string) InitialState {
+	state := InitialState{
+		BuiltinUsername:    w.model.BuiltinUsername(),
+		BuiltinChannelname: w.model.BuiltinChannelname(),
+		Username:           w.model.BuiltinUsername(),
+		Channelname:        w.model.BuiltinChannelname(),
+	}
+
+	users := w.model.GetUsers()
+	channels := w.model.GetChannels()
+
+	session, ok := w.sessions.Get(sessionID)
+	if ok {
+		state.Resumed = true
+		if _, ok := users[session.Username]; ok {
+			state.Username = session.Username
+		}
+		if _, ok := channels[session.Channelname]; ok {
+			state.Channelname = session.Channelname
+		}
+	}
+
+	// Sort the users and channels alphabetically
+	state.Users = make([]string, 0)
+	for user := range users {
+		state.Users = append(state.Users, user)
+	}
+	sort.Strings(state.Users)
+	state.Statuses = w.model.GetUserStatuses()
+
+	state.Channels = make([]string, 0)
+	for channel := range channels {
+		state.Channels = append(state.Channels, channel)
+	}
+	sort.Strings(state.Channels)
+
+	state.JoinedChannels = make([]string, 0)
+	for channel := range w.model.GetJoinedChannels(state.Username) {
+		state.JoinedChannels = append(state.JoinedChannels, channel)
+	}
+	sort.Strings(state.JoinedChannels)
+
+	state.User = w.model.GetUserInfo(state.Username)
+	sort.Strings(state.User.BlockedUsers)
+	sort.Strings(state.User.MutedChannels)
+	state.Preferences = preferences.GetAll(w.model, state.Username)
+	state.Drafts = drafts.Get(w.model, state.Username)
+	state.ReadMarkers = w.model.GetReadMarkers(state.Username)
+	state.UnreadCounts = w.model.GetUnreadCounts(state.Username)
+
+	state.Channel = w.model.GetChannelInfo(state.Channelname)
+	state.Messages = newChannelHistoryMessages(w.model.GetChannelHistory(state.Channelname, state.Username, -1))
+
+	return state
+}
+
+// CreateUserArgs provides the input arguments for the CreateUser action.
+type CreateUserArgs struct {
+	Username string
+}
+
+// CreateUserResponse provides the output arguments for the CreateUser action.
+type CreateUserResponse struct {
+}
+
+// CreateUser will create a new user.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.CreateUser",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) CreateUser(args *CreateUserArgs, response *CreateUserResponse) error {
+	return w.model.CreateUser(args.Username)
+}
+
+// CreateVirtualUserArgs provides the input arguments for the CreateVirtualUser action.
+type CreateVirtualUserArgs struct {
+	OwnerUsername string
+	Username      string
+}
+
+// CreateVirtualUserResponse provides the output arguments for the CreateVirtualUser action.
+type CreateVirtualUserResponse struct {
+}
+
+// CreateVirtualUser will create a new virtual user owned by an existing user (e.g. a bridge).  The
+// virtual user can't be used directly and is deleted along with its owner.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.CreateVirtualUser",
+//     "params": [{
+//         "OwnerUsername": "Bridge1",
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) CreateVirtualUser(args *CreateVirtualUserArgs, response *CreateVirtualUserResponse) error {
+	return w.model.CreateVirtualUser(args.OwnerUsername, args.Username)
+}
+
+// GetUserInfoArgs provides the input arguments for the GetUserInfo action.
+type GetUserInfoArgs struct {
+	Username string
+}
+
+// GetUserInfoResponse provides the output arguments for the GetUserInfo action.
+type GetUserInfoResponse struct {
+	User model.User
+}
+
+// GetUserInfo will get user info for a specified user, including the profile and status they set
+// (see SetUserProfile and SetUserStatus).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetUserInfo",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+//     "User": {
+//         "Name": "User1",
+//         "BlockedUsers": [
+//             "User2",
+//             "User3"
+//         ],
+//         "MutedChannels": [
+//             "Channel1"
+//         ],
+//         "Owner": "",
+//         "Profile": {
+//             "DisplayName": "User One",
+//             "Bio": "Bio1",
+//             "Pronouns": "they/them"
+//         },
+//         "Status": {
+//             "Text": "lunch",
+//             "Away": true
+//         }
+//     }
+// }
+func (w *WebAPI) GetUserInfo(args *GetUserInfoArgs, response *GetUserInfoResponse) error {
+	userInfo := w.reader().GetUserInfo(args.Username)
+	response.User = userInfo
+	sort.Strings(response.User.BlockedUsers)
+	sort.Strings(response.User.MutedChannels)
+
+	return nil
+}
+
+// GetUsersArgs provides the input arguments for the GetUsers action.
+type GetUsersArgs struct {
+}
+
+// GetUsersResponse provides the output arguments for the GetUsers action.
+type GetUsersResponse struct {
+	Users    []string
+	Statuses map[string]model.Status
+}
+
+// GetUsers will get a list of all users, along with the statuses of the users that have set one.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetUsers",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Users": [
+//         "User1",
+//         "User2"
+//     ],
+//     "Statuses": {
+//         "User2": {
+//             "Text": "lunch",
+//             "Away": true
+//         }
+//     }
+// }
+func (w *WebAPI) GetUsers(args *GetUsersArgs, response *GetUsersResponse) error {
+	reader := w.reader()
+	users := reader.GetUsers()
+
+	// Sort the users alphabetically
+	response.Users = make([]string, 0)
+	for user := range users {
+		response.Users = append(response.Users, user)
+	}
+	sort.Strings(response.Users)
+	response.Statuses = reader.GetUserStatuses()
+
+	return nil
+}
+
+// SetUserProfileArgs provides the input arguments for the SetUserProfile action.
+type SetUserProfileArgs struct {
+	Username    string
+	DisplayName string
+	Bio         string
+	Pronouns    string
+}
+
+// SetUserProfileResponse provides the output arguments for the SetUserProfile action.
+type SetUserProfileResponse struct {
+}
+
+// SetUserProfile will replace the profile of a user (any of the fields can be left empty).  The
+// display name (up to 64 characters) and pronouns (up to 32) are a single line, the bio (up to 500)
+// can span several.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SetUserProfile",
+//     "params": [{
+//         "Username": "User1",
+//         "DisplayName": "User One",
+//         "Bio": "Bio1",
+//         "Pronouns": "they/them"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) SetUserProfile(args *SetUserProfileArgs, response *SetUserProfileResponse) error {
+	return w.model.SetUserProfile(args.Username, model.Profile{DisplayName: args.DisplayName, Bio: args.Bio, Pronouns: args.Pronouns})
+}
+
+// SetDraftArgs provides the input arguments for the SetDraft action.
+type SetDraftArgs struct {
+	Username    string
+	Channelname string
+	Text        string
+}
+
+// SetDraftResponse provides the output arguments for the SetDraft action.
+type SetDraftResponse struct {
+}
+
+// SetDraft will save the message a user is composing in a channel (up to 4000 characters, an empty
+// text deletes the draft), so it survives the client reloading.  The user's other clients are sent
+// an OnDraftChanged notification with the channel name, and can fetch the drafts with GetDrafts.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SetDraft",
+//     "params": [{
+//         "Username": "User1",
+//         "Channelname": "Channel1",
+//         "Text": "Text1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) SetDraft(args *SetDraftArgs, response *SetDraftResponse) error {
+	err := drafts.Set(w.model, args.Username, args.Channelname, args.Text)
+	if err != nil {
+		return err
+	}
+
+	w.subsEngine.DraftChanged(args.Username, args.Channelname)
+	return nil
+}
+
+// GetDraftsArgs provides the input arguments for the GetDrafts action.
+type GetDraftsArgs struct {
+	Username string
+}
+
+// GetDraftsResponse provides the output arguments for the GetDrafts action.
+type GetDraftsResponse struct {
+	Drafts map[string]string
+}
+
+// GetDrafts will get the drafts of a user, by channel name.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetDrafts",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+//     "Drafts": {
+//         "Channel1": "Text1"
+//     }
+// }
+func (w *WebAPI) GetDrafts(args *GetDraftsArgs, response *GetDraftsResponse) error {
+	response.Drafts = drafts.Get(w.model, args.Username)
+	return nil
+}
+
+// GetSecurityEventsArgs provides the input arguments for the GetSecurityEvents action.
+type GetSecurityEventsArgs struct {
+	Username string
+}
+
+// GetSecurityEventsResponse provides the output arguments for the GetSecurityEvents action.
+type GetSecurityEventsResponse struct {
+	Events []security.Event
+}
+
+// GetSecurityEvents will get the security events on a user's account (logins from new addresses, password changes
+// and two-factor authentication being enabled or disabled), oldest first.  A user's clients are sent an
+// OnSecurityEvent notification for each new one.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetSecurityEvents",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+//     "Events": [{
+//         "Timestamp": "2020-01-02T15:04:05Z",
+//         "Text": "login from a new address 192.0.2.1"
+//     }]
+// }
+func (w *WebAPI) GetSecurityEvents(args *GetSecurityEventsArgs, response *GetSecurityEventsResponse) error {
+	response.Events = security.Get(w.model, args.Username)
+	return nil
+}
+
+// MarkReadArgs provides the input arguments for the MarkRead action.
+type MarkReadArgs struct {
+	Username    string
+	Channelname string
+	MessageID   uint64
+}
+
+// MarkReadResponse provides the output arguments for the MarkRead action.
+type MarkReadResponse struct {
+}
+
+// MarkRead will record that a user has read a channel up to a message (by its ID), so the user's
+// other clients agree on what's been read.  Marking an earlier message than the one already marked
+// does nothing.  The user's other clients are sent an OnReadMarkerChanged notification with the
+// channel name, and can fetch the read markers with GetReadMarkers.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.MarkRead",
+//     "params": [{
+//         "Username": "User1",
+//         "Channelname": "Channel1",
+//         "MessageID": 12
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) MarkRead(args *MarkReadArgs, response *MarkReadResponse) error {
+	return w.model.MarkRead(args.Username, args.Channelname, args.MessageID)
+}
+
+// GetReadMarkersArgs provides the input arguments for the GetReadMarkers action.
+type GetReadMarkersArgs struct {
+	Username string
+}
+
+// GetReadMarkersResponse provides the output arguments for the GetReadMarkers action.
+type GetReadMarkersResponse struct {
+	ReadMarkers map[string]uint64
+}
+
+// GetReadMarkers will get the ID of the last message a user has read in each channel, by channel
+// name (channels they haven't marked are left out).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetReadMarkers",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+//     "ReadMarkers": {
+//         "Channel1": 12
+//     }
+// }
+func (w *WebAPI) GetReadMarkers(args *GetReadMarkersArgs, response *GetReadMarkersResponse) error {
+	response.ReadMarkers = w.model.GetReadMarkers(args.Username)
+	return nil
+}
+
+// GetUnreadCountsArgs provides the input arguments for the GetUnreadCounts action.
+type GetUnreadCountsArgs struct {
+	Username string
+}
+
+// GetUnreadCountsResponse provides the output arguments for the GetUnreadCounts action.
+type GetUnreadCountsResponse struct {
+	UnreadCounts map[string]int
+}
+
+// GetUnreadCounts will get the number of unread messages in each channel a user has joined, by
+// channel name: the messages posted by others after the user's read marker (not counting deleted
+// messages or the ones from blocked users).  Channels with nothing unread are left out, as are muted
+// channels and all of them for the built-in user.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetUnreadCounts",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+//     "UnreadCounts": {
+//         "Channel1": 2
+//     }
+// }
+func (w *WebAPI) GetUnreadCounts(args *GetUnreadCountsArgs, response *GetUnreadCountsResponse) error {
+	response.UnreadCounts = w.model.GetUnreadCounts(args.Username)
+	return nil
+}
+
+// GetMentionsArgs provides the input arguments for the GetMentions action.
+type GetMentionsArgs struct {
+	Username string
+}
+
+// Mention provides a channel message that mentions a user.
+type Mention struct {
+	Channelname string
+	Message     ChannelHistoryMessage
+}
+
+// GetMentionsResponse provides the output arguments for the GetMentions action.
+type GetMentionsResponse struct {
+	Mentions []Mention
+}
+
+// GetMentions will get the channel messages that mention a user with @username, oldest first (not
+// counting the ones from the user's blocked users).  A user's clients are sent an OnMentioned
+// notification with the channel name and message ID when they're mentioned.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetMentions",
+//     "params": [{
+//         "Username": "User2"
+//     }]
+// }
+//
+// Output
+// {
+//     "Mentions": [{
+//         "Channelname": "Channel1",
+//         "Message": {
+//             "ID": 42,
+//             "Username": "User1",
+//             "Text": "@User2 hi",
+//             "Mentions": ["User2"],
+//             ...
+//         }
+//     }]
+// }
+func (w *WebAPI) GetMentions(args *GetMentionsArgs, response *GetMentionsResponse) error {
+	response.Mentions = make([]Mention, 0)
+	for _, mention := range w.reader().GetMentions(args.Username) {
+		response.Mentions = append(response.Mentions, Mention{
+			Channelname: mention.Channelname,
+			Message:     newChannelHistoryMessages([]model.Message{mention.Message})[0],
+		})
+	}
+
+	return nil
+}
+
+// StarMessageArgs provides the input arguments for the StarMessage action.
+type StarMessageArgs struct {
+	Username    string
+	Channelname string
+	MessageID   uint64
+	Starred     bool
+}
+
+// StarMessageResponse provides the output arguments for the StarMessage action.
+type StarMessageResponse struct {
+}
+
+// StarMessage will star (or, with Starred false, unstar) a channel message for a user, to find it
+// again with GetStarredMessages.  Starring a message already starred (or unstarring one that isn't)
+// does nothing.  Deleted messages can't be starred, and are unstarred when they're deleted.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.StarMessage",
+//     "params": [{
+//         "Username": "User1",
+//         "Channelname": "Channel1",
+//         "MessageID": 42,
+//         "Starred": true
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) StarMessage(args *StarMessageArgs, response *StarMessageResponse) error {
+	return w.model.StarMessage(args.Username, args.Channelname, args.MessageID, args.Starred)
+}
+
+// GetStarredMessagesArgs provides the input arguments for the GetStarredMessages action.
+type GetStarredMessagesArgs struct {
+	Username string
+}
+
+// StarredMessage provides a channel message starred by a user.
+type StarredMessage struct {
+	Channelname string
+	Message     ChannelHistoryMessage
+}
+
+// GetStarredMessagesResponse provides the output arguments for the GetStarredMessages action.
+type GetStarredMessagesResponse struct {
+	Messages []StarredMessage
+}
+
+// GetStarredMessages will get the messages a user has starred, oldest first (not counting the
+// ones from the user's blocked users).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetStarredMessages",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+//     "Messages": [{
+//         "Channelname": "Channel1",
+//         "Message": {
+//             "ID": 42,
+//             "Username": "User2",
+//             "Text": "Text1",
+//             ...
+//         }
+//     }]
+// }
+func (w *WebAPI) GetStarredMessages(args *GetStarredMessagesArgs, response *GetStarredMessagesResponse) error {
+	response.Messages = make([]StarredMessage, 0)
+	for _, starred := range w.reader().GetStarredMessages(args.Username) {
+		response.Messages = append(response.Messages, StarredMessage{
+			Channelname: starred.Channelname,
+			Message:     newChannelHistoryMessages([]model.Message{starred.Message})[0],
+		})
+	}
+
+	return nil
+}
+
+// ReactToMessageArgs provides the input arguments for the ReactToMessage action.
+type ReactToMessageArgs struct {
+	Username    string
+	Channelname string
+	MessageID   uint64
+	Reaction    string
+	Remove      bool
+}
+
+// ReactToMessageResponse provides the output arguments for the ReactToMessage action.
+type ReactToMessageResponse struct {
+}
+
+// ReactToMessage will add a user's reaction (an emoji or :shortcode:, up to 32 characters) to a channel message (or,
+// with Remove, take it back).  Adding a reaction the user already made (or removing one they didn't) does nothing.
+// Deleted messages can't be reacted to.  The clients are sent an OnMessageChanged notification for the message.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.ReactToMessage",
+//     "params": [{
+//         "Username": "User1",
+//         "Channelname": "Channel1",
+//         "MessageID": 42,
+//         "Reaction": "👍",
+//         "Remove": false
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) ReactToMessage(args *ReactToMessageArgs, response *ReactToMessageResponse) error {
+	return reactions.React(w.model, w.subsEngine, args.Username, args.Channelname, args.MessageID, args.Reaction, !args.Remove)
+}
+
+// GetReactionsArgs provides the input arguments for the GetReactions action.
+type GetReactionsArgs struct {
+	Channelname string
+	MessageID   uint64
+}
+
+// GetReactionsResponse provides the output arguments for the GetReactions action.
+type GetReactionsResponse struct {
+	Reactions map[string][]string
+}
+
+// GetReactions will get the reactions to a channel message, the users that made each one by reaction.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetReactions",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "MessageID": 42
+//     }]
+// }
+//
+// Output
+// {
+//     "Reactions": {
+//         "👍": ["User1", "User2"]
+//     }
+// }
+func (w *WebAPI) GetReactions(args *GetReactionsArgs, response *GetReactionsResponse) error {
+	response.Reactions = reactions.Get(w.model, args.Channelname, args.MessageID)
+	return nil
+}
+
+// GetPinnedMessagesArgs provides the input arguments for the GetPinnedMessages action.
+type GetPinnedMessagesArgs struct {
+	Username    string
+	Channelname string
+}
+
+// GetPinnedMessagesResponse provides the output arguments for the GetPinnedMessages action.
+type GetPinnedMessagesResponse struct {
+	Messages []ChannelHistoryMessage
+}
+
+// GetPinnedMessages will get the messages pinned in a channel (e.g. by the automation bot), in the order they were
+// pinned (not counting the deleted ones, or the ones from the user's blocked users).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetPinnedMessages",
+//     "params": [{
+//         "Username": "User1",
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+//     "Messages": [{
+//         "ID": 42,
+//         "Username": "User2",
+//         "Text": "Text1",
+//         ...
+//     }]
+// }
+func (w *WebAPI) GetPinnedMessages(args *GetPinnedMessagesArgs, response *GetPinnedMessagesResponse) error {
+	messages := make([]model.Message, 0)
+	for _, messageID := range pins.Get(w.model, args.Channelname) {
+		message, ok := w.model.GetMessage(args.Channelname, args.Username, messageID)
+		if ok && !message.Deleted {
+			messages = append(messages, message)
+		}
+	}
+	response.Messages = newChannelHistoryMessages(messages)
+
+	return nil
+}
+
+// SetUserStatusArgs provides the input arguments for the SetUserStatus action.
+type SetUserStatusArgs struct {
+	Username string
+	Text     string
+	Away     bool
+}
+
+// SetUserStatusResponse provides the output arguments for the SetUserStatus action.
+type SetUserStatusResponse struct {
+}
+
+// SetUserStatus will replace the status of a user: what they're up to (a single line, up to 100
+// characters) and whether they're away.  An empty text that isn't away clears the status.  The
+// clients are notified the user changed.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SetUserStatus",
+//     "params": [{
+//         "Username": "User1",
+//         "Text": "lunch",
+//         "Away": true
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) SetUserStatus(args *SetUserStatusArgs, response *SetUserStatusResponse) error {
+	return w.model.SetUserStatus(args.Username, model.Status{Text: args.Text, Away: args.Away})
+}
+
+// BlockUserArgs provides the input arguments for the BlockUser action.
+type BlockUserArgs struct {
+	Username        string
+	UsernameToBlock string
+}
+
+// BlockUserResponse provides the output arguments for the BlockUser action.
+type BlockUserResponse struct {
+}
+
+// BlockUser will block an existing user for the given user.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.BlockUser",
+//     "params": [{
+//         "Username": "User1",
+//         "UsernameToBlock": "User2"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) BlockUser(args *BlockUserArgs, response *BlockUserResponse) error {
+	return w.model.BlockUser(args.Username, args.UsernameToBlock)
+}
+
+// UnblockUserArgs provides the input arguments for the UnblockUser action.
+type UnblockUserArgs struct {
+	Username          string
+	UsernameToUnblock string
+}
+
+// UnblockUserResponse provides the output arguments for the UnblockUser action.
+type UnblockUserResponse struct {
+}
+
+// UnblockUser will unblock an existing user for the given user.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.UnblockUser",
+//     "params": [{
+//         "Username": "User1",
+//         "UsernameToUnblock": "User2"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) UnblockUser(args *UnblockUserArgs, response *UnblockUserResponse) error {
+	return w.model.UnblockUser(args.Username, args.UsernameToUnblock)
+}
+
+// MuteChannelArgs provides the input arguments for the MuteChannel action.
+type MuteChannelArgs struct {
+	Username    string
+	Channelname string
+}
+
+// MuteChannelResponse provides the output arguments for the MuteChannel action.
+type MuteChannelResponse struct {
+}
+
+// MuteChannel will mute an existing channel for the given user.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.MuteChannel",
+//     "params": [{
+//         "Username": "User1",
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) MuteChannel(args *MuteChannelArgs, response *MuteChannelResponse) error {
+	return w.model.MuteChannel(args.Username, args.Channelname)
+}
+
+// UnmuteChannelArgs provides the input arguments for the UnmuteChannel action.
+type UnmuteChannelArgs struct {
+	Username    string
+	Channelname string
+}
+
+// UnmuteChannelResponse provides the output arguments for the UnmuteChannel action.
+type UnmuteChannelResponse struct {
+}
+
+// UnmuteChannel will unmute an existing channel for the given user.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.UnmuteChannel",
+//     "params": [{
+//         "Username": "User1",
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) UnmuteChannel(args *UnmuteChannelArgs, response *UnmuteChannelResponse) error {
+	return w.model.UnmuteChannel(args.Username, args.Channelname)
+}
+
+// CreateChannelArgs provides the input arguments for the CreateChannel action.
+type CreateChannelArgs struct {
+	Channelname string
+}
+
+// CreateChannelResponse provides the output arguments for the CreateChannel action.
+type CreateChannelResponse struct {
+}
+
+// CreateChannel will create a new channel.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.CreateChannel",
+//     "params": [{
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) CreateChannel(args *CreateChannelArgs, response *CreateChannelResponse) error {
+	return w.model.CreateChannel(args.Channelname)
+}
+
+// CreateChannelAndJoinArgs provides the input arguments for the CreateChannelAndJoin action.
+type CreateChannelAndJoinArgs struct {
+	Channelname string
+	Topic       string
+	Username    string
+}
+
+// CreateChannelAndJoinResponse provides the output arguments for the CreateChannelAndJoin action.
+type CreateChannelAndJoinResponse struct {
+}
+
+// CreateChannelAndJoin will create a new channel with a topic (empty for none) and have a user join it, as a single change (nothing is created if the user doesn't exist).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.CreateChannelAndJoin",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Topic": "Topic1",
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) CreateChannelAndJoin(args *CreateChannelAndJoinArgs, response *CreateChannelAndJoinResponse) error {
+	return w.model.CreateChannelAndJoin(args.Channelname, args.Topic, args.Username)
+}
+
+// GetChannelHistoryArgs provides the input arguments for the GetChannelHistory action.
+type GetChannelHistoryArgs struct {
+	Channelname string
+	Username    string
+	NumMessages int
+}
+
+// ChannelHistoryMessage provides a translation of the model.Message struct
+type ChannelHistoryMessage struct {
+	ID               uint64
+	Seq              uint64
+	Username         string
+	Timestamp        string
+	ClaimedTimestamp string
+	Edited           string
+	Deleted          bool
+	Text             string
+	OriginSystem     string
+	OriginAuthor     string
+	SnippetLanguage  string
+	Attachments      []HistoryAttachment
+	Mentions         []string
+	CrossPost        uint64
+}
+
+// HistoryAttachment provides a translation of the model.Attachment struct (the file is served at
+// "/attachments/<ID>", and an image's thumbnails at "/attachments/<ID>?thumbnail=<size>").
+type HistoryAttachment struct {
+	ID          string
+	Name        string
+	ContentType string
+	Size        int64
+}
+
+// GetChannelHistoryResponse provides the output arguments for the GetChannelHistory action.
+type GetChannelHistoryResponse struct {
+	Messages []ChannelHistoryMessage
+}
+
+// GetChannelHistory will get channel history for a channel (filtered for a user) up to a number of messages.  ClaimedTimestamp is only set when the time claimed by a bridged system was too far from the server's time, and Edited when the message was edited.  Deleted messages are tombstones without text.  SnippetLanguage is only set on snippets (see PostSnippet), whose text is code to show highlighted.  Mentions is the users the message mentions with @username.  CrossPost is the ID shared by the copies of a message cross-posted to several channels (see PostMessageMulti), zero otherwise.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetChannelHistory",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Username": "User1",
+//         "NumMessages": 12
+//     }]
+// }
+//
+// Output
+// {
+//     "Messages": [{
+//         "ID": 42,
+//         "Seq": 12,
+//         "Username": "User1",
+//         "Timestamp": "2020-01-12...",
+//         "ClaimedTimestamp": "",
+//         "Edited": "",
+//         "Deleted": false,
+//         "Text": "Message1",
+//         "OriginSystem": "Slack",
+//         "OriginAuthor": "Author1",
+//         "SnippetLanguage": "",
+//         "Attachments": [{
+//             "ID": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+//             "Name": "image.png",
+//             "ContentType": "image/png",
+//             "Size": 1024
+//         }],
+//         "Mentions": ["User2"],
+//         "CrossPost": 0
+//     }]
+// }
+func (w *WebAPI) GetChannelHistory(args *GetChannelHistoryArgs, response *GetChannelHistoryResponse) error {
+	messages := w.reader().GetChannelHistory(args.Channelname, args.Username, args.NumMessages)
+	response.Messages = newChannelHistoryMessages(messages)
+
+	return nil
+}
+
+func newChannelHistoryMessages(messages []model.Message) []ChannelHistoryMessage {
+	historyMessages := make([]ChannelHistoryMessage, len(messages))
+	for i, message := range messages {
+		historyMessages[i].ID = message.ID
+		historyMessages[i].Seq = message.Seq
+		historyMessages[i].Username = message.Username
+		historyMessages[i].Timestamp = formatTimestamp(message.Timestamp)
+		if !message.ClaimedTimestamp.IsZero() {
+			historyMessages[i].ClaimedTimestamp = formatTimestamp(message.ClaimedTimestamp)
+		}
+		if !message.Edited.IsZero() {
+			historyMessages[i].Edited = formatTimestamp(message.Edited)
+		}
+		historyMessages[i].Deleted = message.Deleted
+		historyMessages[i].Text = message.Text
+		historyMessages[i].OriginSystem = message.Origin.System
+		historyMessages[i].OriginAuthor = message.Origin.Author
+		historyMessages[i].SnippetLanguage = message.SnippetLanguage
+		for _, attachment := range message.Attachments {
+			historyMessages[i].Attachments = append(historyMessages[i].Attachments, HistoryAttachment(attachment))
+		}
+		historyMessages[i].Mentions = message.Mentions
+		historyMessages[i].CrossPost = message.CrossPost
+	}
+
+	return historyMessages
+}
+
+// GetChannelHistoryRangeArgs provides the input arguments for the GetChannelHistoryRange action.
+type GetChannelHistoryRangeArgs struct {
+	Channelname string
+	Username    string
+	FromSeq     uint64
+	ToSeq       uint64
+}
+
+// GetChannelHistoryRangeResponse provides the output arguments for the GetChannelHistoryRange action.
+type GetChannelHistoryRangeResponse struct {
+	Messages []ChannelHistoryMessage
+}
+
+// GetChannelHistoryRange will get the messages in a channel (filtered for a user) with seqs from FromSeq to ToSeq (inclusive).  Every message in a channel has a seq, starting at 1 with no gaps (the channel info has the latest one), so a client can fetch just the messages it's missing.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetChannelHistoryRange",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Username": "User1",
+//         "FromSeq": 11,
+//         "ToSeq": 12
+//     }]
+// }
+//
+// Output
+// {
+//     "Messages": [{
+//         "ID": 42,
+//         "Seq": 12,
+//         "Username": "User1",
+//         "Timestamp": "2020-01-12...",
+//         "ClaimedTimestamp": "",
+//         "Edited": "",
+//         "Deleted": false,
+//         "Text": "Message1",
+//         "OriginSystem": "Slack",
+//         "OriginAuthor": "Author1"
+//     }]
+// }
+func (w *WebAPI) GetChannelHistoryRange(args *GetChannelHistoryRangeArgs, response *GetChannelHistoryRangeResponse) error {
+	messages := w.reader().GetChannelHistoryRange(args.Channelname, args.Username, args.FromSeq, args.ToSeq)
+	response.Messages = newChannelHistoryMessages(messages)
+
+	return nil
+}
+
+// GetChannelHistoryByTimeArgs provides the input arguments for the GetChannelHistoryByTime action.
+type GetChannelHistoryByTimeArgs struct {
+	Channelname string
+	Username    string
+	FromTime    string
+	UntilTime   string
+}
+
+// GetChannelHistoryByTimeResponse provides the output arguments for the GetChannelHistoryByTime action.
+type GetChannelHistoryByTimeResponse struct {
+	Messages []ChannelHistoryMessage
+}
+
+// GetChannelHistoryByTime will get the messages in a channel (filtered for a user) posted from FromTime (inclusive) until UntilTime (exclusive).  Times are RFC 3339, and UntilTime can be left empty to get everything since FromTime.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetChannelHistoryByTime",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Username": "User1",
+//         "FromTime": "2020-01-11T00:00:00Z",
+//         "UntilTime": "2020-01-12T00:00:00Z"
+//     }]
+// }
+//
+// Output
+// {
+//     "Messages": [{
+//         "ID": 42,
+//         "Seq": 12,
+//         "Username": "User1",
+//         "Timestamp": "2020-01-11...",
+//         "ClaimedTimestamp": "",
+//         "Edited": "",
+//         "Deleted": false,
+//         "Text": "Message1",
+//         "OriginSystem": "Slack",
+//         "OriginAuthor": "Author1"
+//     }]
+// }
+func (w *WebAPI) GetChannelHistoryByTime(args *GetChannelHistoryByTimeArgs, response *GetChannelHistoryByTimeResponse) error {
+	response.Messages = make([]ChannelHistoryMessage, 0)
+
+	// Invalid times return no messages
+	fromTime, err := time.Parse(time.RFC3339, args.FromTime)
+	if err != nil {
+		return nil
+	}
+
+	var untilTime time.Time
+	if args.UntilTime != "" {
+		untilTime, err = time.Parse(time.RFC3339, args.UntilTime)
+		if err != nil {
+			return nil
+		}
+	}
+
+	messages := w.reader().GetChannelHistoryByTime(args.Channelname, args.Username, fromTime, untilTime)
+	response.Messages = newChannelHistoryMessages(messages)
+
+	return nil
+}
+
+// GetFirstMessageOnDateArgs provides the input arguments for the GetFirstMessageOnDate action.
+type GetFirstMessageOnDateArgs struct {
+	Channelname string
+	Date        string
+}
+
+// GetFirstMessageOnDateResponse provides the output arguments for the GetFirstMessageOnDate action.
+type GetFirstMessageOnDateResponse struct {
+	Found bool
+	ID    uint64
+	Seq   uint64
+}
+
+// GetFirstMessageOnDate will find the first message in a channel posted on or after a date (YYYY-MM-DD in the server's time zone, or an RFC 3339 time), so a client can jump to it (e.g. with GetChannelHistoryRange).  Found is false if there are no messages since then.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetFirstMessageOnDate",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Date": "2020-01-11"
+//     }]
+// }
+//
+// Output
+// {
+//     "Found": true,
+//     "ID": 42,
+//     "Seq": 12
+// }
+func (w *WebAPI) GetFirstMessageOnDate(args *GetFirstMessageOnDateArgs, response *GetFirstMessageOnDateResponse) error {
+	// Invalid dates find nothing
+	date, err := time.ParseInLocation("2006-01-02", args.Date, time.Local)
+	if err != nil {
+		date, err = time.Parse(time.RFC3339, args.Date)
+		if err != nil {
+			return nil
+		}
+	}
+
+	message, ok := w.reader().GetFirstMessageSince(args.Channelname, date)
+	if !ok {
+		return nil
+	}
+
+	response.Found = true
+	response.ID = message.ID
+	response.Seq = message.Seq
+
+	return nil
+}
+
+// GetTopPostersArgs provides the input arguments for the GetTopPosters action.
+type GetTopPostersArgs struct {
+	Channelname string
+	Period      string
+	NumPosters  int
+}
+
+// GetTopPostersResponse provides the output arguments for the GetTopPosters action.
+type GetTopPostersResponse struct {
+	Posters []model.PosterCount
+}
+
+// GetTopPosters will get the users who posted the most messages in a channel over a period ("day", "week", "month" or "all"), most first, up to a number of users (-1 for all).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetTopPosters",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Period": "week",
+//         "NumPosters": 10
+//     }]
+// }
+//
+// Output
+// {
+//     "Posters": [{
+//         "Username": "User1",
+//         "NumMessages": 42
+//     }]
+// }
+func (w *WebAPI) GetTopPosters(args *GetTopPostersArgs, response *GetTopPostersResponse) error {
+	response.Posters = make([]model.PosterCount, 0)
+
+	// Unknown periods have no posters
+	since, ok := model.PeriodSince(args.Period, w.model.Now())
+	if !ok {
+		return nil
+	}
+
+	response.Posters = w.reader().GetTopPosters(args.Channelname, since, args.NumPosters)
+
+	return nil
+}
+
+// SearchChannelHistoryArgs provides the input arguments for the SearchChannelHistory action.
+type SearchChannelHistoryArgs struct {
+	Channelname string
+	Username    string
+	Query       string
+	NumMessages int
+}
+
+// SearchChannelHistoryResponse provides the output arguments for the SearchChannelHistory action.
+type SearchChannelHistoryResponse struct {
+	Messages []ChannelHistoryMessage
+}
+
+// SearchChannelHistory will get the most recent messages in a channel (filtered for a user) containing every word of a query, up to a number of messages.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SearchChannelHistory",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Username": "User1",
+//         "Query": "word1 word2",
+//         "NumMessages": 12
+//     }]
+// }
+//
+// Output
+// {
+//     "Messages": [{
+//         "ID": 42,
+//         "Seq": 12,
+//         "Username": "User1",
+//         "Timestamp": "2020-01-12...",
+//         "Text": "Message1",
+//         "OriginSystem": "Slack",
+//         "OriginAuthor": "Author1"
+//     }]
+// }
+func (w *WebAPI) SearchChannelHistory(args *SearchChannelHistoryArgs, response *SearchChannelHistoryResponse) error {
+	response.Messages = make([]ChannelHistoryMessage, 0)
+
+	// Message search is optional
+	if w.searchIndex == nil {
+		return nil
+	}
+
+	messages := w.searchIndex.Search(args.Channelname, args.Username, args.Query, args.NumMessages)
+	response.Messages = newChannelHistoryMessages(messages)
+
+	return nil
+}
+
+// GetChannelInfoArgs provides the input arguments for the GetChannelInfo action.
+type GetChannelInfoArgs struct {
+	Channelname string
+}
+
+// GetChannelInfoResponse provides the output arguments for the GetChannelInfo action.
+type GetChannelInfoResponse struct {
+	Channel model.ChannelInfo
+}
+
+// GetChannelInfo will get channel info for a specified channel.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetChannelInfo",
+//     "params": [{
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+//     "Channel": {
+//         "Name": "Channel1",
+//         "Topic": "Topic1",
+//         "Language": "en",
+//         "Rules": "Rules1",
+//         "NumMessages": 12,
+//         "NumMembers": 3,
+//         "LastActivity": "2020-01-12T...",
+//         "LastSeq": 12
+//     }
+// }
+func (w *WebAPI) GetChannelInfo(args *GetChannelInfoArgs, response *GetChannelInfoResponse) error {
+	channelInfo := w.reader().GetChannelInfo(args.Channelname)
+	response.Channel = channelInfo
+
+	return nil
+}
+
+// GetChannelsArgs provides the input arguments for the GetChannels action.
+type GetChannelsArgs struct {
+}
+
+// GetChannelsResponse provides the output arguments for the GetChannels action.
+type GetChannelsResponse struct {
+	Channels []string
+}
+
+// GetChannels will get a list of all channels.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetChannels",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Channels": [
+//         "Channel1",
+//         "Channel2"
+//     ]
+// }
+func (w *WebAPI) GetChannels(args *GetChannelsArgs, response *GetChannelsResponse) error {
+	channels := w.reader().GetChannels()
+
+	// Sort the channels alphabetically
+	response.Channels = make([]string, 0)
+	for channel := range channels {
+		response.Channels = append(response.Channels, channel)
+	}
+	sort.Strings(response.Channels)
+
+	return nil
+}
+
+// JoinChannelArgs provides the input arguments for the JoinChannel action.
+type JoinChannelArgs struct {
+	Username    string
+	Channelname string
+}
+
+// JoinChannelResponse provides the output arguments for the JoinChannel action.
+type JoinChannelResponse struct {
+}
+
+// JoinChannel will add a user to the members of a channel.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.JoinChannel",
+//     "params": [{
+//         "Username": "User1",
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) JoinChannel(args *JoinChannelArgs, response *JoinChannelResponse) error {
+	return w.model.JoinChannel(args.Username, args.Channelname)
+}
+
+// LeaveChannelArgs provides the input arguments for the LeaveChannel action.
+type LeaveChannelArgs struct {
+	Username    string
+	Channelname string
+}
+
+// LeaveChannelResponse provides the output arguments for the LeaveChannel action.
+type LeaveChannelResponse struct {
+}
+
+// LeaveChannel will remove a user from the members of a channel.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.LeaveChannel",
+//     "params": [{
+//         "Username": "User1",
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) LeaveChannel(args *LeaveChannelArgs, response *LeaveChannelResponse) error {
+	return w.model.LeaveChannel(args.Username, args.Channelname)
+}
+
+// GetJoinedChannelsArgs provides the input arguments for the GetJoinedChannels action.
+type GetJoinedChannelsArgs struct {
+	Username string
+}
+
+// GetJoinedChannelsResponse provides the output arguments for the GetJoinedChannels action.
+type GetJoinedChannelsResponse struct {
+	Channels []string
+}
+
+// GetJoinedChannels will get a list of all channels a user is a member of.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetJoinedChannels",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+//     "Channels": [
+//         "Channel1",
+//         "Channel2"
+//     ]
+// }
+func (w *WebAPI) GetJoinedChannels(args *GetJoinedChannelsArgs, response *GetJoinedChannelsResponse) error {
+	channels := w.reader().GetJoinedChannels(args.Username)
+
+	// Sort the channels alphabetically
+	response.Channels = make([]string, 0)
+	for channel := range channels {
+		response.Channels = append(response.Channels, channel)
+	}
+	sort.Strings(response.Channels)
+
+	return nil
+}
+
+// GetChannelMembersArgs provides the input arguments for the GetChannelMembers action.
+type GetChannelMembersArgs struct {
+	Channelname string
+}
+
+// GetChannelMembersResponse provides the output arguments for the GetChannelMembers action.
+type GetChannelMembersResponse struct {
+	Members []string
+}
+
+// GetChannelMembers will get a list of all users that are members of a channel (empty if the channel
+// doesn't exist).  Clients are sent an OnChannelChanged notification when a user joins or leaves the
+// channel.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetChannelMembers",
+//     "params": [{
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+//     "Members": [
+//         "User1",
+//         "User2"
+//     ]
+// }
+func (w *WebAPI) GetChannelMembers(args *GetChannelMembersArgs, response *GetChannelMembersResponse) error {
+	members := w.reader().GetChannelMembers(args.Channelname)
+
+	// Sort the members alphabetically
+	response.Members = make([]string, 0)
+	for member := range members {
+		response.Members = append(response.Members, member)
+	}
+	sort.Strings(response.Members)
+
+	return nil
+}
+
+// GetPublicChannelsArgs provides the input arguments for the GetPublicChannels action.
+type GetPublicChannelsArgs struct {
+}
+
+// GetPublicChannelsResponse provides the output arguments for the GetPublicChannels action.
+type GetPublicChannelsResponse struct {
+	Channels []string
+}
+
+// GetPublicChannels will get a list of all channels that can be discovered and joined.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetPublicChannels",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Channels": [
+//         "Channel1",
+//         "Channel2"
+//     ]
+// }
+func (w *WebAPI) GetPublicChannels(args *GetPublicChannelsArgs, response *GetPublicChannelsResponse) error {
+	channels := w.reader().GetPublicChannels()
+
+	// Sort the channels alphabetically
+	response.Channels = make([]string, 0)
+	for channel := range channels {
+		response.Channels = append(response.Channels, channel)
+	}
+	sort.Strings(response.Channels)
+
+	return nil
+}
+
+// SetChannelTopicArgs provides the input arguments for the SetChannelTopic action.
+type SetChannelTopicArgs struct {
+	Channelname string
+	Topic       string
+}
+
+// SetChannelTopicResponse provides the output arguments for the SetChannelTopic action.
+type SetChannelTopicResponse struct {
+}
+
+// SetChannelTopic will set the topic of an existing channel.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SetChannelTopic",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Topic": "Topic1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) SetChannelTopic(args *SetChannelTopicArgs, response *SetChannelTopicResponse) error {
+	return w.model.SetChannelTopic(args.Channelname, args.Topic)
+}
+
+// SetChannelRulesArgs provides the input arguments for the SetChannelRules action.
+type SetChannelRulesArgs struct {
+	Channelname string
+	Language    string
+	Rules       string
+}
+
+// SetChannelRulesResponse provides the output arguments for the SetChannelRules action.
+type SetChannelRulesResponse struct {
+}
+
+// SetChannelRules will set the language and content rules of an existing channel.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SetChannelRules",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Language": "en",
+//         "Rules": "Rules1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) SetChannelRules(args *SetChannelRulesArgs, response *SetChannelRulesResponse) error {
+	return w.model.SetChannelRules(args.Channelname, args.Language, args.Rules)
+}
+
+// GetChannelNotesArgs provides the input arguments for the GetChannelNotes action.
+type GetChannelNotesArgs struct {
+	Channelname string
+}
+
+// GetChannelNotesResponse provides the output arguments for the GetChannelNotes action.
+type GetChannelNotesResponse struct {
+	Text    string
+	Version uint64
+	Editor  string
+	Edited  string
+}
+
+// GetChannelNotes will get the notes of an existing channel: reference text its users keep up to
+// date, separate from the channel's history.  Version counts the edits, and Editor and Edited are
+// who last edited the notes and when (all empty, with Version 0, until the notes are first set).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetChannelNotes",
+//     "params": [{
+//         "Channelname": "Channel1"
+//     }]
+// }
+//
+// Output
+// {
+//     "Text": "Notes1",
+//     "Version": 3,
+//     "Editor": "User1",
+//     "Edited": "2020-01-02T15:04:05Z"
+// }
+func (w *WebAPI) GetChannelNotes(args *GetChannelNotesArgs, response *GetChannelNotesResponse) error {
+	notes := w.reader().GetChannelNotes(args.Channelname)
+	response.Text = notes.Text
+	response.Version = notes.Version
+	response.Editor = notes.Editor
+	if !notes.Edited.IsZero() {
+		response.Edited = formatTimestamp(notes.Edited)
+	}
+
+	return nil
+}
+
+// SetChannelNotesArgs provides the input arguments for the SetChannelNotes action.
+type SetChannelNotesArgs struct {
+	Channelname string
+	Username    string
+	Version     uint64
+	Text        string
+}
+
+// SetChannelNotesResponse provides the output arguments for the SetChannelNotes action.
+type SetChannelNotesResponse struct {
+	Version uint64
+}
+
+// SetChannelNotes will replace the notes of an existing channel, as edited by a user, returning
+// their new version.  Version is the version the edit was made to (from GetChannelNotes), and the
+// edit is rejected if the notes have changed since, so the client can fetch them and edit again
+// rather than overwrite someone else's edit.  The channel's clients are sent an OnChannelChanged
+// notification.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.SetChannelNotes",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Username": "User1",
+//         "Version": 3,
+//         "Text": "Notes2"
+//     }]
+// }
+//
+// Output
+// {
+//     "Version": 4
+// }
+func (w *WebAPI) SetChannelNotes(args *SetChannelNotesArgs, response *SetChannelNotesResponse) error {
+	version, err := w.model.SetChannelNotes(args.Channelname, args.Username, args.Version, args.Text)
+	if err != nil {
+		return err
+	}
+
+	response.Version = version
+	return nil
+}
+
+// BrowseChannelsArgs provides the input arguments for the BrowseChannels action.
+type BrowseChannelsArgs struct {
+}
+
+// BrowseChannelsChannel provides a translation of the model.ChannelInfo struct
+type BrowseChannelsChannel struct {
+	Name         string
+	Topic        string
+	NumMembers   int
+	LastActivity string
+}
+
+// BrowseChannelsResponse provides the output arguments for the BrowseChannels action.
+type BrowseChannelsResponse struct {
+	Channels []BrowseChannelsChannel
+}
+
+// BrowseChannels will get a directory of all public channels ordered by most recent activity.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.BrowseChannels",
+//     "params": [{
+//     }]
+// }
+//
+// Output
+// {
+//     "Channels": [{
+//         "Name": "Channel1",
+//         "Topic": "Topic1",
+//         "NumMembers": 3,
+//         "LastActivity": "2020-01-12..."
+//     }]
+// }
+func (w *WebAPI) BrowseChannels(args *BrowseChannelsArgs, response *BrowseChannelsResponse) error {
+	channelInfos := w.reader().BrowseChannels()
+	response.Channels = make([]BrowseChannelsChannel, len(channelInfos))
+	for i, channelInfo := range channelInfos {
+		response.Channels[i].Name = channelInfo.Name
+		response.Channels[i].Topic = channelInfo.Topic
+		response.Channels[i].NumMembers = channelInfo.NumMembers
+		if !channelInfo.LastActivity.IsZero() {
+			response.Channels[i].LastActivity = formatTimestamp(channelInfo.LastActivity)
+		}
+	}
+
+	return nil
+}
+
+// FuzzyKindCommand is the kind of the commands (the methods of this API) found by FuzzyFind.
+const FuzzyKindCommand string = "command"
+
+// The number of matches FuzzyFind returns when a number isn't given, and the most it returns.
+const (
+	defaultFuzzyMatches int = 10
+	maxFuzzyMatches     int = 50
+)
+
+// FuzzyFindArgs provides the input arguments for the FuzzyFind action.
+type FuzzyFindArgs struct {
+	Query      string
+	NumMatches int
+}
+
+// FuzzyMatch provides a name found by FuzzyFind, along with its kind.
+type FuzzyMatch struct {
+	Kind string
+	Name string
+}
+
+// FuzzyFindResponse provides the output arguments for the FuzzyFind action.
+type FuzzyFindResponse struct {
+	Matches []FuzzyMatch
+}
+
+// FuzzyFind will get the channels, users and commands (the methods of this API) whose names match a
+// query, such as the few characters typed into a quick switcher, best first: a name matches if it
+// has the query's characters in order (ignoring case), and exact names, then prefixes, then the
+// names with the query's characters at the start of their words rank highest.  The kinds are
+// "channel", "user" and "command".  Up to NumMatches are returned (10 if it isn't given, and never
+// more than 50), so clients don't need the full lists.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.FuzzyFind",
+//     "params": [{
+//         "Query": "gen",
+//         "NumMatches": 10
+//     }]
+// }
+//
+// Output
+// {
+//     "Matches": [{
+//         "Kind": "channel",
+//         "Name": "General"
+//     }, {
+//         "Kind": "command",
+//         "Name": "GetEventsSince"
+//     }]
+// }
+func (w *WebAPI) FuzzyFind(args *FuzzyFindArgs, response *FuzzyFindResponse) error {
+	numMatches := args.NumMatches
+	if numMatches <= 0 {
+		numMatches = defaultFuzzyMatches
+	}
+	if numMatches > maxFuzzyMatches {
+		numMatches = maxFuzzyMatches
+	}
+
+	// Both lists are cut to the number of matches before they're merged, which can't drop any of
+	// the best matches overall
+	matches := append(w.reader().FuzzyFind(args.Query, numMatches), w.commands.Find(args.Query, numMatches)...)
+
+	response.Matches = make([]FuzzyMatch, 0)
+	for _, match := range fuzzy.Rank(matches, numMatches) {
+		response.Matches = append(response.Matches, FuzzyMatch{Kind: match.Kind, Name: match.Name})
+	}
+
+	return nil
+}
+
+// PostMessageArgs provides the input arguments for the PostMessage action.
+type PostMessageArgs struct {
+	Channelname    string
+	Username       string
+	Text           string
+	IdempotencyKey string
+}
+
+// PostMessageResponse provides the output arguments for the PostMessage action.
+type PostMessageResponse struct {
+	Posted    bool
+	ID        uint64
+	Timestamp string
+}
+
+// PostMessage will post a message to a channel by a user, returning the message's ID and timestamp
+// (a rejected message, e.g. when the channel or user doesn't exist, is an error; before version 3
+// Posted is false instead).  A client that doesn't hear back (e.g. the connection drops) should retry with the same
+// IdempotencyKey (any string unique to the message, e.g. a UUID), which posts the message at most
+// once and returns the original ID and timestamp.  Without a key, every retry is posted again.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.PostMessage",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Username": "User1",
+//         "Text": "Message1",
+//         "IdempotencyKey": "c7a1..."
+//     }]
+// }
+//
+// Output
+// {
+//     "Posted": true,
+//     "ID": 42,
+//     "Timestamp": "2020-01-12 09:30:00"
+// }
+func (w *WebAPI) PostMessage(args *PostMessageArgs, response *PostMessageResponse) error {
+	message, err := w.model.PostMessageOnce(args.Channelname, args.Username, time.Time{}, args.Text, args.IdempotencyKey)
+	if err != nil {
+		return err
+	}
+
+	response.Posted = true
+	response.ID = message.ID
+	response.Timestamp = formatTimestamp(message.Timestamp)
+
+	return nil
+}
+
+// PostMessageMultiArgs provides the input arguments for the PostMessageMulti action.
+type PostMessageMultiArgs struct {
+	Channelnames []string
+	Username     string
+	Text         string
+}
+
+// PostMessageMultiResponse provides the output arguments for the PostMessageMulti action.
+type PostMessageMultiResponse struct {
+	IDs       []uint64
+	Timestamp string
+}
+
+// PostMessageMulti will cross-post a message to several channels by a user, returning the IDs of
+// its copies (in the order of the channels) and their timestamp.  It's posted to all of the
+// channels or, if any of them rejects it (e.g. one doesn't exist), none of them.  The copies share
+// their identity (the first copy's ID, as CrossPost in the channel history), so editing or deleting
+// any of them (EditMessage/DeleteMessage) edits or deletes them all.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.PostMessageMulti",
+//     "params": [{
+//         "Channelnames": ["Channel1", "Channel2"],
+//         "Username": "User1",
+//         "Text": "Message1"
+//     }]
+// }
+//
+// Output
+// {
+//     "IDs": [42, 43],
+//     "Timestamp": "2020-01-12T09:30:00Z"
+// }
+func (w *WebAPI) PostMessageMulti(args *PostMessageMultiArgs, response *PostMessageMultiResponse) error {
+	messages, err := w.model.PostMessageMulti(args.Channelnames, args.Username, time.Time{}, args.Text)
+	if err != nil {
+		return err
+	}
+
+	response.IDs = make([]uint64, len(messages))
+	for i, message := range messages {
+		response.IDs[i] = message.ID
+	}
+	response.Timestamp = formatTimestamp(messages[0].Timestamp)
+
+	return nil
+}
+
+// PostBridgedMessageArgs provides the input arguments for the PostBridgedMessage action.
+type PostBridgedMessageArgs struct {
+	Channelname  string
+	Username     string
+	Text         string
+	OriginSystem string
+	OriginAuthor string
+	Timestamp    string
+}
+
+// PostBridgedMessageResponse provides the output arguments for the PostBridgedMessage action.
+type PostBridgedMessageResponse struct {
+	ID        uint64
+	Timestamp string
+}
+
+// PostBridgedMessage will post a message to a channel by a user (e.g. a bridge) on behalf of an
+// author from another system, returning the message's ID and timestamp.  The optional Timestamp (RFC 3339) is when the other system says the
+// message was posted.  The message is still given the server's time, but the claimed time is kept
+// (see GetChannelHistory) if the clocks disagree by more than a minute.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.PostBridgedMessage",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Username": "Bridge1",
+//         "Text": "Message1",
+//         "OriginSystem": "Slack",
+//         "OriginAuthor": "Author1",
+//         "Timestamp": "2020-01-12T09:30:00Z"
+//     }]
+// }
+//
+// Output
+// {
+//     "ID": 42,
+//     "Timestamp": "2020-01-12T09:30:00Z"
+// }
+func (w *WebAPI) PostBridgedMessage(args *PostBridgedMessageArgs, response *PostBridgedMessageResponse) error {
+	// An invalid claimed time is treated as no claim
+	var timestamp time.Time
+	if args.Timestamp != "" {
+		timestamp, _ = time.Parse(time.RFC3339, args.Timestamp)
+	}
+
+	message, err := w.model.PostBridgedMessage(args.Channelname, args.Username, timestamp, args.Text, args.OriginSystem, args.OriginAuthor)
+	if err != nil {
+		return err
+	}
+
+	response.ID = message.ID
+	response.Timestamp = formatTimestamp(message.Timestamp)
+
+	return nil
+}
+
+// PostSnippetArgs provides the input arguments for the PostSnippet action.
+type PostSnippetArgs struct {
+	Channelname string
+	Username    string
+	Language    string
+	Text        string
+}
+
+// PostSnippetResponse provides the output arguments for the PostSnippet action.
+type PostSnippetResponse struct {
+	ID        uint64
+	Timestamp string
+}
+
+// PostSnippet will post a code snippet to a channel by a user, returning the snippet's ID and
+// timestamp.  The language (e.g. "go") is returned with the snippet in the history, so clients can
+// highlight it, and the text can span several lines.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.PostSnippet",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "Username": "User1",
+//         "Language": "go",
+//         "Text": "func main() {\n}\n"
+//     }]
+// }
+//
+// Output
+// {
+//     "ID": 42,
+//     "Timestamp": "2020-01-12T09:30:00Z"
+// }
+func (w *WebAPI) PostSnippet(args *PostSnippetArgs, response *PostSnippetResponse) error {
+	message, err := w.model.PostSnippet(args.Channelname, args.Username, time.Time{}, args.Language, args.Text)
+	if err != nil {
+		return err
+	}
+
+	response.ID = message.ID
+	response.Timestamp = formatTimestamp(message.Timestamp)
+
+	return nil
+}
+
+// EditMessageArgs provides the input arguments for the EditMessage action.
+type EditMessageArgs struct {
+	Channelname string
+	MessageID   uint64
+	Username    string
+	Text        string
+}
+
+// EditMessageResponse provides the output arguments for the EditMessage action.
+type EditMessageResponse struct {
+}
+
+// EditMessage will replace the text of a message (by its ID) in a channel, which only the user that posted it can do.
+// Clients are sent an OnMessageChanged notification with the channel and message ID (OnChannelChanged for clients
+// connected with an older protocol version).
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.EditMessage",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "MessageID": 42,
+//         "Username": "User1",
+//         "Text": "Message1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) EditMessage(args *EditMessageArgs, response *EditMessageResponse) error {
+	return w.model.EditMessage(args.Channelname, args.MessageID, args.Username, args.Text)
+}
+
+// AttachFileArgs provides the input arguments for the AttachFile action.
+type AttachFileArgs struct {
+	Channelname string
+	MessageID   uint64
+	Username    string
+	ID          string
+	Name        string
+}
+
+// AttachFileResponse provides the output arguments for the AttachFile action.
+type AttachFileResponse struct {
+	ContentType string
+	Size        int64
+}
+
+// AttachFile will attach an uploaded file (by the ID the upload returned) to a message in a channel, which only the user
+// that posted it can do.  Files are uploaded by POSTing them to "/attachments/?session=<session ID>", and the same file
+// can be attached to any number of messages.  The file's content type and size are taken from the stored file (an
+// invalid attachment error is returned if there's none), and clients are notified the same as for an edit.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.AttachFile",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "MessageID": 42,
+//         "Username": "User1",
+//         "ID": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+//         "Name": "image.png"
+//     }]
+// }
+//
+// Output
+// {
+//     "ContentType": "image/png",
+//     "Size": 1024
+// }
+func (w *WebAPI) AttachFile(args *AttachFileArgs, response *AttachFileResponse) error {
+	if w.options.Attachments == nil {
+		return errors.New("attachments are disabled")
+	}
+
+	data, contentType, err := w.options.Attachments.Get(args.ID)
+	if err == attachments.ErrNotFound {
+		return model.ErrInvalidAttachment
+	} else if err != nil {
+		return err
+	}
+
+	attachment := model.Attachment{
+		ID:          args.ID,
+		Name:        args.Name[strings.LastIndexAny(args.Name, "/\\")+1:],
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+
+	err = w.model.AttachFile(args.Channelname, args.MessageID, args.Username, attachment)
+	if err != nil {
+		return err
+	}
+
+	response.ContentType = attachment.ContentType
+	response.Size = attachment.Size
+
+	return nil
+}
+
+// DeleteMessageArgs provides the input arguments for the DeleteMessage action.
+type DeleteMessageArgs struct {
+	Channelname string
+	MessageID   uint64
+	Username    string
+}
+
+// DeleteMessageResponse provides the output arguments for the DeleteMessage action.
+type DeleteMessageResponse struct {
+}
+
+// DeleteMessage will delete a message (by its ID) in a channel, which only the user that posted it can do.  The message
+// is left in the history as a tombstone (Deleted, without text), and clients are notified the same as for an edit.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.DeleteMessage",
+//     "params": [{
+//         "Channelname": "Channel1",
+//         "MessageID": 42,
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) DeleteMessage(args *DeleteMessageArgs, response *DeleteMessageResponse) error {
+	return w.model.DeleteMessage(args.Channelname, args.MessageID, args.Username)
+}
+
+// PostDirectMessageArgs provides the input arguments for the PostDirectMessage action.
+type PostDirectMessageArgs struct {
+	Username  string
+	Recipient string
+	Text      string
+}
+
+// PostDirectMessageResponse provides the output arguments for the PostDirectMessage action.
+type PostDirectMessageResponse struct {
+	ID        uint64
+	Seq       uint64
+	Timestamp string
+}
+
+// PostDirectMessage will post a direct message from a user to another user, returning the message's ID, seq (within the
+// conversation) and timestamp.  Only the clients whose session is for one of the two users are sent an
+// OnDirectMessagesChanged notification, with the other user in the conversation as its username.  These notifications
+// aren't kept for GetEventsSince, so a client that reconnects should fetch its conversations again.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.PostDirectMessage",
+//     "params": [{
+//         "Username": "User1",
+//         "Recipient": "User2",
+//         "Text": "Message1"
+//     }]
+// }
+//
+// Output
+// {
+//     "ID": 42,
+//     "Seq": 3,
+//     "Timestamp": "2020-01-12 09:30:00"
+// }
+func (w *WebAPI) PostDirectMessage(args *PostDirectMessageArgs, response *PostDirectMessageResponse) error {
+	message, err := w.model.PostDirectMessage(args.Username, args.Recipient, time.Time{}, args.Text)
+	if err != nil {
+		return err
+	}
+
+	response.ID = message.ID
+	response.Seq = message.Seq
+	response.Timestamp = formatTimestamp(message.Timestamp)
+
+	return nil
+}
+
+// GetDirectMessageHistoryArgs provides the input arguments for the GetDirectMessageHistory action.
+type GetDirectMessageHistoryArgs struct {
+	Username      string
+	OtherUsername string
+	NumMessages   int
+}
+
+// GetDirectMessageHistoryResponse provides the output arguments for the GetDirectMessageHistory action.
+type GetDirectMessageHistoryResponse struct {
+	Messages []ChannelHistoryMessage
+}
+
+// GetDirectMessageHistory will get the direct messages between a user and another user (filtered for the first) up to a
+// number of messages (-1 for all), in the same form as GetChannelHistory.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetDirectMessageHistory",
+//     "params": [{
+//         "Username": "User1",
+//         "OtherUsername": "User2",
+//         "NumMessages": 12
+//     }]
+// }
+//
+// Output
+// {
+//     "Messages": [{
+//         "ID": 42,
+//         "Seq": 3,
+//         "Username": "User2",
+//         "Timestamp": "2020-01-12...",
+//         "ClaimedTimestamp": "",
+//         "Edited": "",
+//         "Deleted": false,
+//         "Text": "Message1",
+//         "OriginSystem": "",
+//         "OriginAuthor": ""
+//     }]
+// }
+func (w *WebAPI) GetDirectMessageHistory(args *GetDirectMessageHistoryArgs, response *GetDirectMessageHistoryResponse) error {
+	messages := w.reader().GetDirectMessageHistory(args.Username, args.OtherUsername, args.NumMessages)
+	response.Messages = newChannelHistoryMessages(messages)
 
-		// Connect the subscriptions for this web conn
-		err := subsEngine.Connect(webConn)
-		if err != nil {
-			log.Fatal(err)
-		}
+	return nil
+}
 
-		// For a single connection, handle requests sequentially
-		for {
-			err := rpc.ServeRequest(jsonrpc.NewServerCodec(ws))
-			if err != nil {
-				break
-			}
-		}
+// GetConversationsArgs provides the input arguments for the GetConversations action.
+type GetConversationsArgs struct {
+	Username string
+}
 
-		// Disconnect the subscriptions for this web conn
-		err = subsEngine.Disconnect(webConn)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-	return connectionHandler
+// GetConversationsResponse provides the output arguments for the GetConversations action.
+type GetConversationsResponse struct {
+	Conversations map[string]uint64
 }
 
-// WebAPI provides the JSON RPC service API.
-type WebAPI struct {
-	model *model.Model
+// GetConversations will get the users a user has direct messages with, along with the number of messages with each.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.GetConversations",
+//     "params": [{
+//         "Username": "User1"
+//     }]
+// }
+//
+// Output
+// {
+//     "Conversations": {
+//         "User2": 3
+//     }
+// }
+func (w *WebAPI) GetConversations(args *GetConversationsArgs, response *GetConversationsResponse) error {
+	response.Conversations = w.reader().GetConversations(args.Username)
+
+	return nil
 }
 
-// NewInstance creates/initializes/returns a new WebAPI instance.
-func NewInstance(model *model.Model) *WebAPI {
-	instance := WebAPI{
-		model: model,
+// CreateGroupArgs provides the input arguments for the CreateGroup action.
+type CreateGroupArgs struct {
+	Username string
+	Members  []string
+}
+
+// CreateGroupResponse provides the output arguments for the CreateGroup action.
+type CreateGroupResponse struct {
+	GroupID uint64
+}
+
+// CreateGroup will create a group conversation between a user and some other users, returning the group's ID.  Groups
+// aren't listed with the channels.  Only the clients whose session is for a member of the group are sent an
+// OnGroupChanged notification (with the group's ID as its groupID) when it's created and when a message is posted to
+// it.  As with direct messages, these notifications aren't kept for GetEventsSince.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.CreateGroup",
+//     "params": [{
+//         "Username": "User1",
+//         "Members": ["User2", "User3"]
+//     }]
+// }
+//
+// Output
+// {
+//     "GroupID": 1
+// }
+func (w *WebAPI) CreateGroup(args *CreateGroupArgs, response *CreateGroupResponse) error {
+	groupID, err := w.model.CreateGroup(args.Username, args.Members)
+	if err != nil {
+		return err
 	}
 
-	return &instance
+	response.GroupID = groupID
+
+	return nil
 }
 
-// CreateUserArgs provides the input arguments for the CreateUser action.
-type CreateUserArgs struct {
+// PostGroupMessageArgs provides the input arguments for the PostGroupMessage action.
+type PostGroupMessageArgs struct {
+	GroupID  uint64
 	Username string
+	Text     string
 }
 
-// CreateUserResponse provides the output arguments for the CreateUser action.
-type CreateUserResponse struct {
+// PostGroupMessageResponse provides the output arguments for the PostGroupMessage action.
+type PostGroupMessageResponse struct {
+	ID        uint64
+	Seq       uint64
+	Timestamp string
 }
 
-// CreateUser will create a new user.
+// PostGroupMessage will post a message from a user to a group they're a member of, returning the message's ID, seq
+// (within the group) and timestamp.
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.CreateUser",
+//     "method": "<registeredAPI>.PostGroupMessage",
 //     "params": [{
-//         "Username": "User1"
+//         "GroupID": 1,
+//         "Username": "User1",
+//         "Text": "Message1"
 //     }]
 // }
 //
 // Output
 // {
+//     "ID": 42,
+//     "Seq": 3,
+//     "Timestamp": "2020-01-12 09:30:00"
 // }
-func (w *WebAPI) CreateUser(args *CreateUserArgs, response *CreateUserResponse) error {
-	w.model.CreateUser(args.Username)
+func (w *WebAPI) PostGroupMessage(args *PostGroupMessageArgs, response *PostGroupMessageResponse) error {
+	message, err := w.model.PostGroupMessage(args.GroupID, args.Username, time.Time{}, args.Text)
+	if err != nil {
+		return err
+	}
+
+	response.ID = message.ID
+	response.Seq = message.Seq
+	response.Timestamp = formatTimestamp(message.Timestamp)
 
 	return nil
 }
 
-// DeleteUserArgs provides the input arguments for the DeleteUser action.
-type DeleteUserArgs struct {
-	Username string
+// GetGroupMessageHistoryArgs provides the input arguments for the GetGroupMessageHistory action.
+type GetGroupMessageHistoryArgs struct {
+	GroupID     uint64
+	Username    string
+	NumMessages int
 }
 
-// DeleteUserResponse provides the output arguments for the DeleteUser action.
-type DeleteUserResponse struct {
+// GetGroupMessageHistoryResponse provides the output arguments for the GetGroupMessageHistory action.
+type GetGroupMessageHistoryResponse struct {
+	Messages []ChannelHistoryMessage
 }
 
-// DeleteUser will delete an existing user.
+// GetGroupMessageHistory will get the messages in a group (filtered for a user, who gets none unless they're a member)
+// up to a number of messages (-1 for all), in the same form as GetChannelHistory.
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.DeleteUser",
+//     "method": "<registeredAPI>.GetGroupMessageHistory",
 //     "params": [{
-//         "Username": "User1"
+//         "GroupID": 1,
+//         "Username": "User1",
+//         "NumMessages": 12
 //     }]
 // }
 //
 // Output
 // {
+//     "Messages": [{
+//         "ID": 42,
+//         "Seq": 3,
+//         "Username": "User2",
+//         "Timestamp": "2020-01-12...",
+//         "ClaimedTimestamp": "",
+//         "Edited": "",
+//         "Deleted": false,
+//         "Text": "Message1",
+//         "OriginSystem": "",
+//         "OriginAuthor": ""
+//     }]
 // }
-func (w *WebAPI) DeleteUser(args *DeleteUserArgs, response *DeleteUserResponse) error {
-	w.model.DeleteUser(args.Username)
+func (w *WebAPI) GetGroupMessageHistory(args *GetGroupMessageHistoryArgs, response *GetGroupMessageHistoryResponse) error {
+	messages := w.reader().GetGroupMessageHistory(args.GroupID, args.Username, args.NumMessages)
+	response.Messages = newChannelHistoryMessages(messages)
 
 	return nil
 }
 
-// GetUserInfoArgs provides the input arguments for the GetUserInfo action.
-type GetUserInfoArgs struct {
+// GetGroupsArgs provides the input arguments for the GetGroups action.
+type GetGroupsArgs struct {
 	Username string
 }
 
-// GetUserInfoResponse provides the output arguments for the GetUserInfo action.
-type GetUserInfoResponse struct {
-	User model.User
+// GetGroupsResponse provides the output arguments for the GetGroups action.
+type GetGroupsResponse struct {
+	Groups []model.GroupInfo
 }
 
-// GetUserInfo will get user info for a specified user.
+// GetGroups will get the groups a user is a member of (in the order they were created), along with their members and
+// the number of messages in each.
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.GetUserInfo",
+//     "method": "<registeredAPI>.GetGroups",
 //     "params": [{
 //         "Username": "User1"
 //     }]
@@ -146,354 +3396,443 @@ type GetUserInfoResponse struct {
 //
 // Output
 // {
-//     "User": {
-//         "Name": "User1",
-//         "BlockedUsers": [
-//             "User2",
-//             "User3"
-//         ]
-//     }
+//     "Groups": [{
+//         "ID": 1,
+//         "Members": ["User1", "User2", "User3"],
+//         "NumMessages": 3
+//     }]
 // }
-func (w *WebAPI) GetUserInfo(args *GetUserInfoArgs, response *GetUserInfoResponse) error {
-	userInfo := w.model.GetUserInfo(args.Username)
-	response.User = userInfo
-	sort.Strings(response.User.BlockedUsers)
+func (w *WebAPI) GetGroups(args *GetGroupsArgs, response *GetGroupsResponse) error {
+	response.Groups = w.reader().GetGroups(args.Username)
 
 	return nil
 }
 
-// GetUsersArgs provides the input arguments for the GetUsers action.
-type GetUsersArgs struct {
+// CreateTeamArgs provides the input arguments for the CreateTeam action.
+type CreateTeamArgs struct {
+	Teamname string
 }
 
-// GetUsersResponse provides the output arguments for the GetUsers action.
-type GetUsersResponse struct {
-	Users []string
+// CreateTeamResponse provides the output arguments for the CreateTeam action.
+type CreateTeamResponse struct {
 }
 
-// GetUsers will get a list of all users.
+// CreateTeam will create a new (empty) team, a named set of users that other features can target
+// as a whole.
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.GetUsers",
+//     "method": "<registeredAPI>.CreateTeam",
 //     "params": [{
+//         "Teamname": "Team1"
 //     }]
 // }
 //
 // Output
 // {
-//     "Users": [
-//         "User1",
-//         "User2"
-//     ]
 // }
-func (w *WebAPI) GetUsers(args *GetUsersArgs, response *GetUsersResponse) error {
-	users := w.model.GetUsers()
+func (w *WebAPI) CreateTeam(args *CreateTeamArgs, response *CreateTeamResponse) error {
+	return w.model.CreateTeam(args.Teamname)
+}
 
-	// Sort the users alphabetically
-	response.Users = make([]string, 0)
-	for user := range users {
-		response.Users = append(response.Users, user)
-	}
-	sort.Strings(response.Users)
+// DeleteTeamArgs provides the input arguments for the DeleteTeam action.
+type DeleteTeamArgs struct {
+	Teamname string
+}
 
-	return nil
+// DeleteTeamResponse provides the output arguments for the DeleteTeam action.
+type DeleteTeamResponse struct {
 }
 
-// BlockUserArgs provides the input arguments for the BlockUser action.
-type BlockUserArgs struct {
-	Username        string
-	UsernameToBlock string
+// DeleteTeam will delete an existing team.
+//
+// JSON RPC Definition
+// -------------------
+//
+// Input
+// {
+//     "method": "<registeredAPI>.DeleteTeam",
+//     "params": [{
+//         "Teamname": "Team1"
+//     }]
+// }
+//
+// Output
+// {
+// }
+func (w *WebAPI) DeleteTeam(args *DeleteTeamArgs, response *DeleteTeamResponse) error {
+	return w.model.DeleteTeam(args.Teamname)
 }
 
-// BlockUserResponse provides the output arguments for the BlockUser action.
-type BlockUserResponse struct {
+// AddTeamMemberArgs provides the input arguments for the AddTeamMember action.
+type AddTeamMemberArgs struct {
+	Teamname string
+	Username string
 }
 
-// BlockUser will block an existing user for the given user.
+// AddTeamMemberResponse provides the output arguments for the AddTeamMember action.
+type AddTeamMemberResponse struct {
+}
+
+// AddTeamMember will add an existing user to an existing team.
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.BlockUser",
+//     "method": "<registeredAPI>.AddTeamMember",
 //     "params": [{
-//         "Username": "User1",
-//         "UsernameToBlock": "User2"
+//         "Teamname": "Team1",
+//         "Username": "User1"
 //     }]
 // }
 //
 // Output
 // {
 // }
-func (w *WebAPI) BlockUser(args *BlockUserArgs, response *BlockUserResponse) error {
-	w.model.BlockUser(args.Username, args.UsernameToBlock)
-
-	return nil
+func (w *WebAPI) AddTeamMember(args *AddTeamMemberArgs, response *AddTeamMemberResponse) error {
+	return w.model.AddTeamMember(args.Teamname, args.Username)
 }
 
-// UnblockUserArgs provides the input arguments for the UnblockUser action.
-type UnblockUserArgs struct {
-	Username          string
-	UsernameToUnblock string
+// RemoveTeamMemberArgs provides the input arguments for the RemoveTeamMember action.
+type RemoveTeamMemberArgs struct {
+	Teamname string
+	Username string
 }
 
-// UnblockUserResponse provides the output arguments for the UnblockUser action.
-type UnblockUserResponse struct {
+// RemoveTeamMemberResponse provides the output arguments for the RemoveTeamMember action.
+type RemoveTeamMemberResponse struct {
 }
 
-// UnblockUser will unblock an existing user for the given user.
+// RemoveTeamMember will remove a member from a team.
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.UnblockUser",
+//     "method": "<registeredAPI>.RemoveTeamMember",
 //     "params": [{
-//         "Username": "User1",
-//         "UsernameToUnblock": "User2"
+//         "Teamname": "Team1",
+//         "Username": "User1"
 //     }]
 // }
 //
 // Output
 // {
 // }
-func (w *WebAPI) UnblockUser(args *UnblockUserArgs, response *UnblockUserResponse) error {
-	w.model.UnblockUser(args.Username, args.UsernameToUnblock)
-
-	return nil
+func (w *WebAPI) RemoveTeamMember(args *RemoveTeamMemberArgs, response *RemoveTeamMemberResponse) error {
+	return w.model.RemoveTeamMember(args.Teamname, args.Username)
 }
 
-// CreateChannelArgs provides the input arguments for the CreateChannel action.
-type CreateChannelArgs struct {
-	Channelname string
+// GetTeamsArgs provides the input arguments for the GetTeams action.
+type GetTeamsArgs struct {
 }
 
-// CreateChannelResponse provides the output arguments for the CreateChannel action.
-type CreateChannelResponse struct {
+// GetTeamsResponse provides the output arguments for the GetTeams action.
+type GetTeamsResponse struct {
+	Teams map[string][]string
 }
 
-// CreateChannel will create a new channel.
+// GetTeams will get all of the teams, along with their members (sorted alphabetically).
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.CreateChannel",
+//     "method": "<registeredAPI>.GetTeams",
 //     "params": [{
-//         "Channelname": "Channel1"
 //     }]
 // }
 //
 // Output
 // {
+//     "Teams": {
+//         "Team1": ["User1", "User2"]
+//     }
 // }
-func (w *WebAPI) CreateChannel(args *CreateChannelArgs, response *CreateChannelResponse) error {
-	w.model.CreateChannel(args.Channelname)
+func (w *WebAPI) GetTeams(args *GetTeamsArgs, response *GetTeamsResponse) error {
+	reader := w.reader()
+
+	response.Teams = make(map[string][]string)
+	for teamname := range reader.GetTeams() {
+		members := make([]string, 0)
+		for member := range reader.GetTeamMembers(teamname) {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		response.Teams[teamname] = members
+	}
 
 	return nil
 }
 
-// DeleteChannelArgs provides the input arguments for the DeleteChannel action.
-type DeleteChannelArgs struct {
-	Channelname string
+// GetPresenceArgs provides the input arguments for the GetPresence action.
+type GetPresenceArgs struct {
 }
 
-// DeleteChannelResponse provides the output arguments for the DeleteChannel action.
-type DeleteChannelResponse struct {
+// GetPresenceResponse provides the output arguments for the GetPresence action.
+type GetPresenceResponse struct {
+	Online []string
 }
 
-// DeleteChannel will delete an existing channel.
+// GetPresence will get the users that are online (connected from a telnet or web client), sorted
+// alphabetically.  Connections aren't replicated, so this always reads the primary model.
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.DeleteChannel",
+//     "method": "<registeredAPI>.GetPresence",
 //     "params": [{
-//         "Channelname": "Channel1"
 //     }]
 // }
 //
 // Output
 // {
+//     "Online": ["User1", "User2"]
 // }
-func (w *WebAPI) DeleteChannel(args *DeleteChannelArgs, response *DeleteChannelResponse) error {
-	w.model.DeleteChannel(args.Channelname)
+func (w *WebAPI) GetPresence(args *GetPresenceArgs, response *GetPresenceResponse) error {
+	response.Online = make([]string, 0)
+	for username := range w.model.GetPresence() {
+		response.Online = append(response.Online, username)
+	}
+	sort.Strings(response.Online)
 
 	return nil
 }
 
-// GetChannelHistoryArgs provides the input arguments for the GetChannelHistory action.
-type GetChannelHistoryArgs struct {
+// CreateEventArgs provides the input arguments for the CreateEvent action.
+type CreateEventArgs struct {
 	Channelname string
 	Username    string
-	NumMessages int
+	Start       string
+	Title       string
 }
 
-// ChannelHistoryMessage provides a translation of the model.Message struct
-type ChannelHistoryMessage struct {
-	Username  string
-	Timestamp string
-	Text      string
-}
-
-// GetChannelHistoryResponse provides the output arguments for the GetChannelHistory action.
-type GetChannelHistoryResponse struct {
-	Messages []ChannelHistoryMessage
+// CreateEventResponse provides the output arguments for the CreateEvent action.
+type CreateEventResponse struct {
+	EventID uint64
 }
 
-// GetChannelHistory will get channel history for a channel (filtered for a user) up to a number of messages.
+// CreateEvent will add an event to a channel's calendar, starting at an RFC 3339 time, and return
+// its ID.  The title is a single line of up to 100 characters.  A reminder is posted to the channel
+// shortly before the event starts.
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.GetChannelHistory",
+//     "method": "<registeredAPI>.CreateEvent",
 //     "params": [{
 //         "Channelname": "Channel1",
 //         "Username": "User1",
-//         "NumMessages": 12
+//         "Start": "2020-01-02T15:00:00Z",
+//         "Title": "Standup"
 //     }]
 // }
 //
 // Output
 // {
-//     "Messages": [{
-//         "Username": "User1",
-//         "Timestamp": "2020-01-12...",
-//         "Text": "Message1"
-//     }]
+//     "EventID": 1
 // }
-func (w *WebAPI) GetChannelHistory(args *GetChannelHistoryArgs, response *GetChannelHistoryResponse) error {
-	messages := w.model.GetChannelHistory(args.Channelname, args.Username, args.NumMessages)
-	response.Messages = make([]ChannelHistoryMessage, len(messages))
-	for i, message := range messages {
-		response.Messages[i].Username = message.Username
-		response.Messages[i].Timestamp = message.Timestamp.Format("2006-01-02 15:04:05")
-		response.Messages[i].Text = message.Text
+func (w *WebAPI) CreateEvent(args *CreateEventArgs, response *CreateEventResponse) error {
+	start, err := time.Parse(time.RFC3339, args.Start)
+	if err != nil {
+		return model.ErrInvalidEvent
 	}
 
-	return nil
+	response.EventID, err = w.model.CreateEvent(args.Channelname, args.Username, start, args.Title)
+	return err
 }
 
-// GetChannelInfoArgs provides the input arguments for the GetChannelInfo action.
-type GetChannelInfoArgs struct {
-	Channelname string
+// RSVPEventArgs provides the input arguments for the RSVPEvent action.
+type RSVPEventArgs struct {
+	EventID  uint64
+	Username string
+	Going    bool
 }
 
-// GetChannelInfoResponse provides the output arguments for the GetChannelInfo action.
-type GetChannelInfoResponse struct {
-	Channel model.ChannelInfo
+// RSVPEventResponse provides the output arguments for the RSVPEvent action.
+type RSVPEventResponse struct {
 }
 
-// GetChannelInfo will get channel info for a specified channel.
+// RSVPEvent will record whether a user is going to an event.
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.GetChannelInfo",
+//     "method": "<registeredAPI>.RSVPEvent",
 //     "params": [{
-//         "Channelname": "Channel1"
+//         "EventID": 1,
+//         "Username": "User1",
+//         "Going": true
 //     }]
 // }
 //
 // Output
 // {
-//     "Channel": {
-//         "Name": "Channel1",
-//         "NumMessages": 12
-//     }
 // }
-func (w *WebAPI) GetChannelInfo(args *GetChannelInfoArgs, response *GetChannelInfoResponse) error {
-	channelInfo := w.model.GetChannelInfo(args.Channelname)
-	response.Channel = channelInfo
+func (w *WebAPI) RSVPEvent(args *RSVPEventArgs, response *RSVPEventResponse) error {
+	return w.model.RSVPEvent(args.EventID, args.Username, args.Going)
+}
 
-	return nil
+// CalendarEvent provides a translation of the model.CalendarEvent struct
+type CalendarEvent struct {
+	ID       uint64
+	Creator  string
+	Start    string
+	Title    string
+	Going    []string
+	Reminded bool
 }
 
-// GetChannelsArgs provides the input arguments for the GetChannels action.
-type GetChannelsArgs struct {
+// GetCalendarArgs provides the input arguments for the GetCalendar action.
+type GetCalendarArgs struct {
+	Channelname string
 }
 
-// GetChannelsResponse provides the output arguments for the GetChannels action.
-type GetChannelsResponse struct {
-	Channels []string
+// GetCalendarResponse provides the output arguments for the GetCalendar action.
+type GetCalendarResponse struct {
+	Events []CalendarEvent
 }
 
-// GetChannels will get a list of all channels.
+// GetCalendar will get the events on a channel's calendar (including the past ones), in the order
+// they start.
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.GetChannels",
+//     "method": "<registeredAPI>.GetCalendar",
 //     "params": [{
+//         "Channelname": "Channel1"
 //     }]
 // }
 //
 // Output
 // {
-//     "Channels": [
-//         "Channel1",
-//         "Channel2"
-//     ]
+//     "Events": [{
+//         "ID": 1,
+//         "Creator": "User1",
+//         "Start": "2020-01-02T15:00:00Z",
+//         "Title": "Standup",
+//         "Going": ["User1", "User2"],
+//         "Reminded": false
+//     }]
 // }
-func (w *WebAPI) GetChannels(args *GetChannelsArgs, response *GetChannelsResponse) error {
-	channels := w.model.GetChannels()
-
-	// Sort the channels alphabetically
-	response.Channels = make([]string, 0)
-	for channel := range channels {
-		response.Channels = append(response.Channels, channel)
+func (w *WebAPI) GetCalendar(args *GetCalendarArgs, response *GetCalendarResponse) error {
+	response.Events = make([]CalendarEvent, 0)
+	for _, event := range w.reader().GetEvents(args.Channelname) {
+		response.Events = append(response.Events, CalendarEvent{
+			ID:       event.ID,
+			Creator:  event.Creator,
+			Start:    formatTimestamp(event.Start),
+			Title:    event.Title,
+			Going:    event.Going,
+			Reminded: event.Reminded,
+		})
 	}
-	sort.Strings(response.Channels)
 
 	return nil
 }
 
-// PostMessageArgs provides the input arguments for the PostMessage action.
-type PostMessageArgs struct {
-	Channelname string
-	Username    string
-	Text        string
+// BatchMutateMutation provides a translation of the model.Mutation struct
+type BatchMutateMutation struct {
+	Type          string
+	Username      string
+	OwnerUsername string
+	OtherUsername string
+	Channelname   string
+	Topic         string
+	Language      string
+	Rules         string
+	Text          string
 }
 
-// PostMessageResponse provides the output arguments for the PostMessage action.
-type PostMessageResponse struct {
+// BatchMutateArgs provides the input arguments for the BatchMutate action.
+type BatchMutateArgs struct {
+	Mutations []BatchMutateMutation
 }
 
-// PostMessage will post a message to a channel by a user.
+// BatchMutateResponse provides the output arguments for the BatchMutate action.
+type BatchMutateResponse struct {
+	Applied       bool
+	RejectedIndex int
+	Rejection     string
+}
+
+// BatchMutate will apply a list of mutations atomically, in order: either all of them are
+// applied, or none of them are (if any of them would be rejected) and the index of the first one
+// that would be is returned, along with why it would be (e.g. "user already exists").  The types are the names of the mutating actions (CreateUser,
+// CreateVirtualUser, BlockUser, UnblockUser, MuteChannel, UnmuteChannel, CreateChannel,
+// JoinChannel, LeaveChannel, SetChannelTopic, SetChannelRules, PostMessage and PostSnippet), taking the same
+// arguments (OtherUsername is the user to block or unblock).
 //
 // JSON RPC Definition
 // -------------------
 //
 // Input
 // {
-//     "method": "<registeredAPI>.PostMessage",
+//     "method": "<registeredAPI>.BatchMutate",
 //     "params": [{
-//         "Channelname": "Channel1",
-//         "Username": "User1",
-//         "Text": "Message1"
+//         "Mutations": [{
+//             "Type": "CreateChannel",
+//             "Channelname": "Channel1"
+//         }, {
+//             "Type": "JoinChannel",
+//             "Username": "User1",
+//             "Channelname": "Channel1"
+//         }]
 //     }]
 // }
 //
 // Output
 // {
+//     "Applied": true,
+//     "RejectedIndex": 0,
+//     "Rejection": ""
 // }
-func (w *WebAPI) PostMessage(args *PostMessageArgs, response *PostMessageResponse) error {
-	w.model.PostMessage(args.Channelname, args.Username, time.Now(), args.Text)
+func (w *WebAPI) BatchMutate(args *BatchMutateArgs, response *BatchMutateResponse) error {
+	mutations := make([]model.Mutation, 0)
+	for i, mutation := range args.Mutations {
+		// Deleting users and channels (and storing plugin data) is left to the admin API
+		if mutation.Type == "DeleteUser" || mutation.Type == "DeleteChannel" || mutation.Type == "PutPluginData" {
+			response.RejectedIndex = i
+			response.Rejection = model.ErrUnknownMutation.Error()
+			return nil
+		}
+
+		mutations = append(mutations, model.Mutation{
+			Type:          mutation.Type,
+			Username:      mutation.Username,
+			OwnerUsername: mutation.OwnerUsername,
+			OtherUsername: mutation.OtherUsername,
+			Channelname:   mutation.Channelname,
+			Topic:         mutation.Topic,
+			Language:      mutation.Language,
+			Rules:         mutation.Rules,
+			Text:          mutation.Text,
+		})
+	}
+
+	rejectedIndex, err := w.model.Batch(mutations)
+	if err != nil {
+		response.RejectedIndex = rejectedIndex
+		response.Rejection = err.Error()
+		return nil
+	}
+	response.Applied = true
 
 	return nil
 }
@@ -1,30 +1,89 @@
 // Package webconn manages state associated with a single web view connection.  As most of the
 // web view connection state is held in the web client, this only handles forwarding model
-// subscription updates to the open websocket.
+// subscription updates to the open websocket.  Each update includes its sequence number so a
+// client that reconnects can ask for the updates it missed.
 package webconn
 
 import (
+	"encoding/json"
+	"strconv"
+	"sync"
+
 	"golang.org/x/net/websocket"
 )
 
 // WebConn manages data associated with a single web client connection (over websocket).
 type WebConn struct {
-	ws *websocket.Conn
+	ws             *websocket.Conn
+	seq            uint64
+	messageChanges bool
+	username       string
+	mutex          sync.Mutex
 }
 
-// NewWebConn creates/initializes/returns a new WebConn.
-func NewWebConn(ws *websocket.Conn) *WebConn {
+// NewWebConn creates/initializes/returns a new WebConn.  Clients that don't handle OnMessageChanged
+// notifications (messageChanges false) are sent OnChannelChanged for the message's channel instead,
+// so they fetch its history again.
+func NewWebConn(ws *websocket.Conn, messageChanges bool) *WebConn {
 	webConn := WebConn{
-		ws: ws,
+		ws:             ws,
+		messageChanges: messageChanges,
 	}
 
 	return &webConn
 }
 
+// OnSeq is called with the sequence number of each subscription update before the update.
+func (w *WebConn) OnSeq(seq uint64) {
+	w.seq = seq
+}
+
+// SetUsername sets the user the client is acting as (from its session), whose direct messages it's
+// notified of.
+func (w *WebConn) SetUsername(username string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.username = username
+}
+
+// CurrentUser returns the user the client is acting as.
+func (w *WebConn) CurrentUser() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.username
+}
+
+// SendInitialState sends the client the state it needs to render (pushed when it connects), as of
+// the given subscription update sequence number.
+func (w *WebConn) SendInitialState(seq uint64, state interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	msg := "{\"id\":-1,\"result\":{\"method\":\"InitialState\",\"seq\":" + strconv.FormatUint(seq, 10) + ",\"state\":" + string(data) + "},\"error\":null}"
+	_, err = w.ws.Write([]byte(msg))
+	return err
+}
+
+// SendDeprecation warns the client that a method it called is deprecated.
+func (w *WebConn) SendDeprecation(method string, warning string) error {
+	data, err := json.Marshal(warning)
+	if err != nil {
+		return err
+	}
+
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnDeprecated\",\"deprecatedMethod\":\"" + method + "\",\"warning\":" + string(data) + "},\"error\":null}"
+	_, err = w.ws.Write([]byte(msg))
+	return err
+}
+
 // OnUsersChanged is called whenever the users state changes in the model.  It will forward this
 // update to the websocket.
 func (w *WebConn) OnUsersChanged() {
-	msg := "{\"id\":-1,\"result\":{\"method\":\"OnUsersChanged\"},\"error\":null}"
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnUsersChanged\",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
 	_, err := w.ws.Write([]byte(msg))
 	if err != nil {
 		// Assume this error means the client went away and will be cleaned up eventually
@@ -35,7 +94,7 @@ func (w *WebConn) OnUsersChanged() {
 // OnUserChanged is called whenever a particular user's state changes in the model.  It will forward
 // this update to the websocket.
 func (w *WebConn) OnUserChanged(username string) {
-	msg := "{\"id\":-1,\"result\":{\"method\":\"OnUserChanged\",\"username\":\"" + username + "\"},\"error\":null}"
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnUserChanged\",\"username\":\"" + username + "\",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
 	_, err := w.ws.Write([]byte(msg))
 	if err != nil {
 		// Assume this error means the client went away and will be cleaned up eventually
@@ -46,7 +105,7 @@ func (w *WebConn) OnUserChanged(username string) {
 // OnChannelsChanged is called whenever the channels state changes in the model.  It will forward
 // this update to the websocket.
 func (w *WebConn) OnChannelsChanged() {
-	msg := "{\"id\":-1,\"result\":{\"method\":\"OnChannelsChanged\"},\"error\":null}"
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnChannelsChanged\",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
 	_, err := w.ws.Write([]byte(msg))
 	if err != nil {
 		// Assume this error means the client went away and will be cleaned up eventually
@@ -57,7 +116,100 @@ func (w *WebConn) OnChannelsChanged() {
 // OnChannelChanged is called whenever a particular channel's state changes in the model.  It will
 // forward this update to the websocket.
 func (w *WebConn) OnChannelChanged(channelname string) {
-	msg := "{\"id\":-1,\"result\":{\"method\":\"OnChannelChanged\",\"channelname\":\"" + channelname + "\"},\"error\":null}"
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnChannelChanged\",\"channelname\":\"" + channelname + "\",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
+	_, err := w.ws.Write([]byte(msg))
+	if err != nil {
+		// Assume this error means the client went away and will be cleaned up eventually
+		return
+	}
+}
+
+// OnMessageChanged is called whenever a message in a channel changes in the model (it was edited
+// or deleted).  It will forward this update to the websocket.
+func (w *WebConn) OnMessageChanged(channelname string, messageID uint64) {
+	if !w.messageChanges {
+		w.OnChannelChanged(channelname)
+		return
+	}
+
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnMessageChanged\",\"channelname\":\"" + channelname + "\",\"messageID\":" + strconv.FormatUint(messageID, 10) + ",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
+	_, err := w.ws.Write([]byte(msg))
+	if err != nil {
+		// Assume this error means the client went away and will be cleaned up eventually
+		return
+	}
+}
+
+// OnDirectMessagesChanged is called whenever the direct messages between the client's user and
+// another user change in the model.  It will forward this update to the websocket.
+func (w *WebConn) OnDirectMessagesChanged(otherUsername string) {
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnDirectMessagesChanged\",\"username\":\"" + otherUsername + "\",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
+	_, err := w.ws.Write([]byte(msg))
+	if err != nil {
+		// Assume this error means the client went away and will be cleaned up eventually
+		return
+	}
+}
+
+// OnDraftChanged is called whenever the client's user saves a draft for a channel (possibly from
+// another client).  It will forward this update to the websocket.
+func (w *WebConn) OnDraftChanged(channelname string) {
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnDraftChanged\",\"channelname\":\"" + channelname + "\",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
+	_, err := w.ws.Write([]byte(msg))
+	if err != nil {
+		// Assume this error means the client went away and will be cleaned up eventually
+		return
+	}
+}
+
+// OnReadMarkerChanged is called whenever the client's user reads a channel further (possibly on
+// another client).  It will forward this update to the websocket.
+func (w *WebConn) OnReadMarkerChanged(channelname string) {
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnReadMarkerChanged\",\"channelname\":\"" + channelname + "\",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
+	_, err := w.ws.Write([]byte(msg))
+	if err != nil {
+		// Assume this error means the client went away and will be cleaned up eventually
+		return
+	}
+}
+
+// OnMentioned is called whenever the client's user is mentioned in a channel message.  It will
+// forward this update to the websocket.
+func (w *WebConn) OnMentioned(channelname string, messageID uint64) {
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnMentioned\",\"channelname\":\"" + channelname + "\",\"messageID\":" + strconv.FormatUint(messageID, 10) + ",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
+	_, err := w.ws.Write([]byte(msg))
+	if err != nil {
+		// Assume this error means the client went away and will be cleaned up eventually
+		return
+	}
+}
+
+// OnSecurityEvent is called whenever there's a security event on the client's user's account (e.g.
+// a login from a new address).  It will forward this update to the websocket.
+func (w *WebConn) OnSecurityEvent() {
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnSecurityEvent\",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
+	_, err := w.ws.Write([]byte(msg))
+	if err != nil {
+		// Assume this error means the client went away and will be cleaned up eventually
+		return
+	}
+}
+
+// OnPresenceChanged is called whenever a user comes online or goes offline.  It will forward this
+// update to the websocket.
+func (w *WebConn) OnPresenceChanged(username string) {
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnPresenceChanged\",\"username\":\"" + username + "\",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
+	_, err := w.ws.Write([]byte(msg))
+	if err != nil {
+		// Assume this error means the client went away and will be cleaned up eventually
+		return
+	}
+}
+
+// OnGroupChanged is called whenever a group the client's user is a member of is created, or its
+// messages change, in the model.  It will forward this update to the websocket.
+func (w *WebConn) OnGroupChanged(groupID uint64) {
+	msg := "{\"id\":-1,\"result\":{\"method\":\"OnGroupChanged\",\"groupID\":" + strconv.FormatUint(groupID, 10) + ",\"seq\":" + strconv.FormatUint(w.seq, 10) + "},\"error\":null}"
 	_, err := w.ws.Write([]byte(msg))
 	if err != nil {
 		// Assume this error means the client went away and will be cleaned up eventually